@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/ssh"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the apply command
+const (
+	applyExitApplied = 0
+	applyExitError   = 1
+)
+
+var (
+	applyApprove  bool
+	applyParallel int
+	applyTimeout  time.Duration
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan-file>",
+	Short: "apply a plan saved by plan --output",
+	Long: `Apply runs exactly the actions of a plan saved with plan --output, for
+change management where a reviewed plan is approved before it runs.
+
+Apply refuses the plan if the file was modified, if any configuration file
+or the state changed since it was planned, or without --approve. With
+SETTLE_PLAN_KEY set, plan signs the file with it and apply only accepts
+plans carrying a valid signature.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runApply(cmd.Context(), args[0]))
+	},
+}
+
+// planKey returns the key saved plans are signed with, if any
+func planKey() []byte {
+	return []byte(os.Getenv("SETTLE_PLAN_KEY"))
+}
+
+func runApply(cmdCtx context.Context, path string) int {
+	logger := inventory.NewLogger()
+	planFile, err := core.ReadPlanFile(path, planKey())
+	if err != nil {
+		logger.Error(fmt.Sprintf("Refusing to apply: %v", err))
+		return applyExitError
+	}
+	logger.Info(fmt.Sprintf("Applying the plan made at %s", planFile.CreatedAt))
+	// The plan only covers the hosts it was limited to
+	hostLimit = planFile.Limit
+
+	proj, err := loadProjectWith(logger, projectOptions{preflight: true})
+	if err != nil {
+		logger.Error(err.Error())
+		return applyExitError
+	}
+	hosts, graph := proj.hosts, proj.graph
+	if planFile.ConfigHash != proj.snapshot.Hash() {
+		logger.Error(fmt.Sprintf("Refusing to apply: the configuration changed since %s was planned; plan again", path))
+		return applyExitError
+	}
+	stateManager, err := openState(graph, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error opening state: %v", err))
+		return applyExitError
+	}
+	if err := stateManager.Lock(lockOwner()); err != nil {
+		logger.Error(fmt.Sprintf("Error locking state: %v", err))
+		return applyExitError
+	}
+	defer stateManager.Unlock()
+	if err := stateManager.LoadState(); err != nil {
+		logger.Error(fmt.Sprintf("Error loading state: %v", err))
+		return applyExitError
+	}
+	if planFile.StateHash != stateManager.Hash() {
+		logger.Error(fmt.Sprintf("Refusing to apply: the state changed since %s was planned; plan again", path))
+		return applyExitError
+	}
+	// Resources the plan deletes because they were removed from the
+	// configuration are rebuilt from the state
+	core.AddOrphans(graph, stateManager, proj.providers, logger)
+	plan, err := planFile.Plan(graph)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Refusing to apply: %v", err))
+		return applyExitError
+	}
+
+	logger.Info(inventory.Message(inventory.MsgExecutionPlan))
+	renderPlan(logger, plan, hosts, false)
+	if !applyApprove {
+		logger.Error("Refusing to apply without approval: review the plan above and pass --approve")
+		return applyExitError
+	}
+	if err := enforceLimits(logger, plan, hosts); err != nil {
+		logger.Error(err.Error())
+		return applyExitError
+	}
+	if err := enforcePolicies(cmdCtx, logger, plan, hosts); err != nil {
+		logger.Error(err.Error())
+		return applyExitError
+	}
+	if err := enforceImpact(logger, plan); err != nil {
+		logger.Error(err.Error())
+		return applyExitError
+	}
+	runHooks, err := loadRunHooks()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error parsing hooks from %s: %v", configFile, err))
+		return applyExitError
+	}
+
+	executor := core.NewExecutor(graph, stateManager, logger)
+	executor.SetHosts(hosts)
+	executor.SetInventory(proj.allHosts)
+	executor.SetParallelism(applyParallel)
+	executor.SetConcurrency(parallelActions)
+	executor.SetGuard(proj.snapshot.Verify)
+	executor.SetCheckpoint(core.NewCheckpoint(executor.RunID(), plan))
+	notifyOnFinish(executor.Events(), logger, "apply", time.Now())
+	pushMetricsOnFinish(executor.Events(), logger, "apply")
+	ctx, stop := interruptContext(context.Background(), logger)
+	defer stop()
+	if applyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, applyTimeout)
+		defer cancel()
+	}
+	if err := core.RunProjectHooks(ctx, "pre-run", runHooks, hosts, logger); err != nil {
+		logger.Error(fmt.Sprintf("Pre-run hook failed, nothing was applied: %v", err))
+		return applyExitError
+	}
+
+	before := stateManager.Checksums()
+	stopProgress := startProgress(executor, logger, plan, hosts)
+	result, err := executor.Execute(ctx, plan)
+	stopProgress()
+	if result != nil {
+		recordRun(logger, stateManager, "apply", result, before)
+	}
+	if err := core.RunProjectHooks(context.WithoutCancel(ctx), "post-run", runHooks, hosts, logger); err != nil {
+		logger.Error(fmt.Sprintf("Post-run hook failed: %v", err))
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Execution failed: %v", err))
+		return applyExitError
+	}
+
+	logger.Info("Execution completed:")
+	logger.Info(fmt.Sprintf("  Duration: %v", result.GetDuration()))
+	logger.Info(fmt.Sprintf("  Success: %d", result.GetSuccessCount()))
+	logger.Info(fmt.Sprintf("  Failed: %d", result.GetFailureCount()))
+	if skipped := result.GetSkippedCount(); skipped > 0 {
+		logger.Info(fmt.Sprintf("  Skipped: %d (their dependencies failed)", skipped))
+	}
+	if result.GetFailureCount() > 0 {
+		return applyExitError
+	}
+	return applyExitApplied
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().BoolVar(&applyApprove, "approve", false, "Approve the reviewed plan; apply refuses to run without it")
+	applyCmd.Flags().IntVar(&applyParallel, "parallel", ssh.MaxConnections, "Maximum number of hosts to apply a resource to concurrently")
+	addParallelActionsFlag(applyCmd)
+	applyCmd.Flags().DurationVar(&applyTimeout, "timeout", 0, "Deadline for the whole run, e.g. 30m (0 means no deadline)")
+	applyCmd.Flags().BoolVar(&overrideLimits, "override-limits", false, "Apply even when the plan exceeds the limits in settle.stl")
+	applyCmd.Flags().BoolVar(&forbidDisruptive, "forbid-disruptive", false, "Refuse plans with disruptive changes, such as service restarts or package removals")
+	applyCmd.Flags().BoolVar(&approveDisruptive, "approve-disruptive", false, "Apply the disruptive changes of the plan despite --forbid-disruptive")
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// Commands a bare image must provide before Settle can manage it
+var bootstrapRequirements = []string{
+	"sudo", "visudo", "python3", "sh", "useradd", "getent", "install", "grep",
+	"cat", "tee", "stat", "sha256sum", "mktemp", "mv", "chmod", "chown",
+}
+
+// userNamePattern matches user names useradd accepts on every distribution
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+var (
+	bootstrapName          string
+	bootstrapUser          string
+	bootstrapPort          int
+	bootstrapKeyfile       string
+	bootstrapSettleUser    string
+	bootstrapSettleKeyfile string
+	bootstrapAuthorizedKey string
+	bootstrapSudoers       string
+	bootstrapGroup         string
+	bootstrapNoRegister    bool
+)
+
+var bootstrapCmd = &cobra.Command{
+	Use:          "bootstrap <address>",
+	Short:        "Prepare a bare host for management and add it to hosts.stl",
+	SilenceUsage: true,
+	Long: `Bootstrap connects to a freshly created machine as its initial user
+(root or the image's default user with passwordless sudo) and prepares it for
+Settle:
+
+  1. checks that sudo, python3 and the coreutils Settle runs are installed
+  2. creates the settle user
+  3. installs /etc/sudoers.d/settle, validated with visudo
+  4. adds the public key to the settle user's authorized_keys
+  5. reconnects as the settle user to prove the key and sudo work
+  6. appends the host to hosts.stl
+
+Running it again on a prepared host is safe.
+
+Example:
+  settlectl bootstrap 10.0.0.7 --name web3 --group web --user ubuntu \
+    --settle-keyfile ~/.ssh/settle`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := inventory.NewLogger()
+
+		name := bootstrapName
+		if name == "" {
+			name = args[0]
+		}
+		if !userNamePattern.MatchString(bootstrapSettleUser) {
+			return fmt.Errorf("invalid user name %q", bootstrapSettleUser)
+		}
+		if !bootstrapNoRegister {
+			if err := checkNotRegistered(name); err != nil {
+				return err
+			}
+		}
+
+		initial := &common.Host{
+			Name:     name,
+			Hostname: args[0],
+			User:     bootstrapUser,
+			Port:     bootstrapPort,
+			Keyfile:  bootstrapKeyfile,
+		}
+		settleHost := &common.Host{
+			Name:     name,
+			Hostname: args[0],
+			User:     bootstrapSettleUser,
+			Port:     bootstrapPort,
+			Keyfile:  bootstrapSettleKeyfile,
+			Group:    bootstrapGroup,
+		}
+
+		logger.Task(fmt.Sprintf("Bootstrap %s", name))
+		if err := bootstrapHost(cmd.Context(), logger, initial, settleHost); err != nil {
+			return fmt.Errorf("bootstrap of %s failed: %w", name, err)
+		}
+
+		if bootstrapNoRegister {
+			logger.Success(fmt.Sprintf("%s is ready for management", name))
+			return nil
+		}
+		if err := registerHost(settleHost); err != nil {
+			return err
+		}
+		logger.Success(fmt.Sprintf("%s is ready for management and was added to %s", name, hostsFile))
+		return nil
+	},
+}
+
+// bootstrapHost prepares the host reached as initial for settleHost
+func bootstrapHost(ctx context.Context, logger *inventory.Logger, initial, settleHost *common.Host) error {
+	transport, err := inventory.Connect(initial)
+	if err != nil {
+		return fmt.Errorf("failed to connect as %s: %w", initial.User, err)
+	}
+	defer transport.Close()
+	logger.Info(fmt.Sprintf("Connected to %s as %s", initial.Hostname, initial.User))
+
+	// The settle user logs in with its own key, or the one used to bootstrap
+	if settleHost.Keyfile == "" {
+		settleHost.Keyfile = initial.Keyfile
+	}
+	authorizedKey, err := readAuthorizedKey(settleHost.Keyfile)
+	if err != nil {
+		return err
+	}
+	sudoers, err := sudoersPolicy(settleHost.User)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Checking required commands")
+	if err := checkRequirements(ctx, logger, transport); err != nil {
+		return err
+	}
+
+	logger.Info(fmt.Sprintf("Creating user %s", settleHost.User))
+	user := settleHost.User
+	quotedUser := common.ShellQuote(user)
+	if err := bootstrapRun(ctx, logger, transport, fmt.Sprintf("id -u %s >/dev/null 2>&1 || sudo useradd --create-home --shell /bin/sh %s", quotedUser, quotedUser)); err != nil {
+		return err
+	}
+
+	logger.Info("Installing sudo policy")
+	// sudo ignores files in sudoers.d whose name contains a dot
+	tmpSudoers := "/etc/sudoers.d/.settle-tmp"
+	if err := bootstrapRun(ctx, logger, transport, "sudo install -d -m 0750 /etc/sudoers.d"); err != nil {
+		return err
+	}
+	if err := transport.UploadFile(ctx, tmpSudoers, []byte(sudoers)); err != nil {
+		return err
+	}
+	if err := bootstrapRun(ctx, logger, transport, fmt.Sprintf("sudo visudo -cf %s && sudo chmod 0440 %s && sudo mv -f %s /etc/sudoers.d/settle || { sudo rm -f %s; exit 1; }", tmpSudoers, tmpSudoers, tmpSudoers, tmpSudoers)); err != nil {
+		return fmt.Errorf("invalid sudo policy: %w", err)
+	}
+
+	logger.Info("Installing authorized key")
+	result, err := transport.Exec(ctx, fmt.Sprintf("getent passwd %s | cut -d: -f6", quotedUser))
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find the home of %s: %w", user, err)
+	}
+	home := strings.TrimSpace(result.Stdout)
+	if home == "" {
+		return fmt.Errorf("user %s has no home directory", user)
+	}
+	sshDir := home + "/.ssh"
+	authorizedKeys := common.ShellQuote(sshDir + "/authorized_keys")
+	tmpKey := sshDir + "/.settle-key-tmp"
+	quotedKey := common.ShellQuote(tmpKey)
+	if err := bootstrapRun(ctx, logger, transport, fmt.Sprintf("sudo install -d -m 0700 -o %s -g $(id -gn %s) %s", quotedUser, quotedUser, common.ShellQuote(sshDir))); err != nil {
+		return err
+	}
+	if err := transport.UploadFile(ctx, tmpKey, []byte(authorizedKey)); err != nil {
+		return err
+	}
+	commands := []string{
+		fmt.Sprintf("sudo touch %s", authorizedKeys),
+		fmt.Sprintf("sudo grep -qxF -f %s %s || sudo sh -c %s", quotedKey, authorizedKeys, common.ShellQuote(fmt.Sprintf("cat %s >> %s", quotedKey, authorizedKeys))),
+		fmt.Sprintf("sudo rm -f %s", quotedKey),
+		fmt.Sprintf("sudo chown %s:$(id -gn %s) %s && sudo chmod 0600 %s", quotedUser, quotedUser, authorizedKeys, authorizedKeys),
+	}
+	for _, command := range commands {
+		if err := bootstrapRun(ctx, logger, transport, command); err != nil {
+			return err
+		}
+	}
+
+	logger.Info(fmt.Sprintf("Verifying login as %s", user))
+	check := *settleHost
+	settleTransport, err := inventory.Connect(&check)
+	if err != nil {
+		return fmt.Errorf("failed to connect as %s: %w", user, err)
+	}
+	defer settleTransport.Close()
+	return bootstrapRun(ctx, logger, settleTransport, "sudo -n true")
+}
+
+// checkRequirements fails listing every required command the host lacks
+func checkRequirements(ctx context.Context, logger *inventory.Logger, transport inventory.Transport) error {
+	command := fmt.Sprintf("for c in %s; do command -v $c >/dev/null 2>&1 || echo $c; done", strings.Join(bootstrapRequirements, " "))
+	logger.Command(command)
+	result, err := transport.Exec(ctx, command)
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check required commands: %w", err)
+	}
+	if missing := strings.Fields(result.Stdout); len(missing) > 0 {
+		return fmt.Errorf("missing required commands: %s; install them in the image first", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// bootstrapRun logs and runs command, logging its output when it fails
+func bootstrapRun(ctx context.Context, logger *inventory.Logger, transport inventory.Transport, command string) error {
+	logger.Command(command)
+	result, err := transport.Exec(ctx, command)
+	if err != nil {
+		return err
+	}
+	if !result.Success() && result.Output() != "" {
+		logger.ErrorOutput(result.Output())
+	}
+	return result.Err()
+}
+
+// readAuthorizedKey returns the public key for keyfile: --authorized-key, or
+// keyfile.pub
+func readAuthorizedKey(keyfile string) (string, error) {
+	path := bootstrapAuthorizedKey
+	if path == "" {
+		if keyfile == "" || common.IsKeyReference(keyfile) {
+			return "", fmt.Errorf("no public key to authorize: pass --authorized-key")
+		}
+		path = keyfile + ".pub"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	key, comment, _, _, err := gossh.ParseAuthorizedKey(data)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key in %s: %w", path, err)
+	}
+	line := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(key)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line + "\n", nil
+}
+
+// sudoersPolicy returns --sudoers, or passwordless sudo for user
+func sudoersPolicy(user string) (string, error) {
+	if bootstrapSudoers == "" {
+		return fmt.Sprintf("%s ALL=(ALL) NOPASSWD: ALL\n", user), nil
+	}
+	data, err := os.ReadFile(bootstrapSudoers)
+	if err != nil {
+		return "", fmt.Errorf("failed to read sudo policy: %w", err)
+	}
+	return string(data), nil
+}
+
+// checkNotRegistered fails if hosts.stl already declares name
+func checkNotRegistered(name string) error {
+	if _, err := os.Stat(hostsFile); err != nil {
+		return nil
+	}
+	hosts, err := parser.ParseInventory(hostsFile)
+	if err != nil {
+		return fmt.Errorf("error parsing hosts file: %w", err)
+	}
+	for _, host := range hosts {
+		if host.Name == name {
+			return fmt.Errorf("host %s is already in the inventory; pass --name or --no-register", name)
+		}
+	}
+	return nil
+}
+
+// registerHost appends host to hosts.stl
+func registerHost(host *common.Host) error {
+	var block strings.Builder
+	if err := writeHosts(&block, []common.Host{*host}); err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(hostsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	prefix := ""
+	if len(existing) > 0 {
+		prefix = "\n"
+		if !strings.HasSuffix(string(existing), "\n") {
+			prefix = "\n\n"
+		}
+	}
+
+	f, err := os.OpenFile(hostsFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(prefix + block.String()); err != nil {
+		return fmt.Errorf("failed to register host in %s: %w", hostsFile, err)
+	}
+	return f.Close()
+}
+
+func init() {
+	bootstrapCmd.Flags().StringVar(&bootstrapName, "name", "", "Name of the host in hosts.stl (default: the address)")
+	bootstrapCmd.Flags().StringVar(&bootstrapUser, "user", "root", "Initial user to connect as; needs passwordless sudo unless root")
+	bootstrapCmd.Flags().IntVar(&bootstrapPort, "port", 22, "SSH port")
+	bootstrapCmd.Flags().StringVar(&bootstrapKeyfile, "keyfile", "", "Private key of the initial user (default: ~/.ssh/id_rsa, id_ed25519 or id_ecdsa)")
+	bootstrapCmd.Flags().StringVar(&bootstrapSettleUser, "settle-user", "settle", "User Settle manages the host as")
+	bootstrapCmd.Flags().StringVar(&bootstrapSettleKeyfile, "settle-keyfile", "", "Private key of the settle user, recorded in hosts.stl (default: --keyfile)")
+	bootstrapCmd.Flags().StringVar(&bootstrapAuthorizedKey, "authorized-key", "", "Public key to authorize for the settle user (default: the settle key with .pub)")
+	bootstrapCmd.Flags().StringVar(&bootstrapSudoers, "sudoers", "", "File with the sudo policy to install (default: passwordless sudo for the settle user)")
+	bootstrapCmd.Flags().StringVar(&bootstrapGroup, "group", "", "Group of the host in hosts.stl")
+	bootstrapCmd.Flags().BoolVar(&bootstrapNoRegister, "no-register", false, "Prepare the host without adding it to hosts.stl")
+	rootCmd.AddCommand(bootstrapCmd)
+}
@@ -4,179 +4,287 @@ import (
 	"context"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/settlectl/settle-core/common"
 	"github.com/settlectl/settle-core/core"
 	"github.com/settlectl/settle-core/inventory"
 	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/inventory/ssh"
 	"github.com/spf13/cobra"
 )
 
+var (
+	cleanParallel int
+	cleanTimeout  time.Duration
+	cleanForce    bool
+	cleanTargets  []string
+	cleanGroup    string
+)
+
 var cleanCmd = &cobra.Command{
 	Use:   "clean",
 	Short: "clean up resources",
+	Long: `Clean destroys resources recorded in the state file, including resources
+whose blocks were removed from the resource files. Resources that were never
+applied are not touched.
+
+Clean asks for confirmation twice unless --force is given, and refuses to
+run non-interactively without --force.
+
+Scope the cleanup with --target (resource IDs or glob patterns such as
+'package:apt:*'), --group, --limit and --label, which selects resources by
+the labels (tags) recorded with their state.
+
+Clean exits 1 when it is refused, cancelled or interrupted, or when an
+action fails.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Every return before the end is an error
+		setExitStatus(exitError)
 		logger := inventory.NewLogger()
 		logger.Info("Starting resource cleanup")
 
-		// Parse hosts
-		hosts, err := parser.ParseHosts("hosts.stl")
+		// Parse hosts, resources and build the graph
+		proj, err := loadProject(logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error parsing hosts file: %v", err))
+			logger.Error(err.Error())
 			return
 		}
-		logger.Info(fmt.Sprintf("Found %d hosts", len(hosts)))
+		hosts, graph := proj.hosts, proj.graph
 
-		// Parse all resource files
-		resourceFiles, err := findResourceFiles()
+		// Create state manager
+		stateManager, err := openState(graph, logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error finding resource files: %v", err))
+			logger.Error(fmt.Sprintf("Error opening state: %v", err))
+			return
+		}
+		if err := stateManager.Lock(lockOwner()); err != nil {
+			logger.Error(fmt.Sprintf("Error locking state: %v", err))
+			return
+		}
+		defer stateManager.Unlock()
+
+		if err := stateManager.LoadState(); err != nil {
+			logger.Error(fmt.Sprintf("Error loading state: %v", err))
 			return
 		}
 
-		// Create resource parser and populate with data
+		// Build the delete set from recorded state, not from whatever
+		// resource files happen to be present
 		resourceParser := core.NewResourceParser()
-		resourceParser.SetHosts(hosts)
-
-		// Parse packages from all resource files
-		var allPackages []common.Package
-		for _, file := range resourceFiles {
-			packages, err := parser.ParsePackages(file)
-			if err != nil {
-				logger.Error(fmt.Sprintf("Error parsing packages from %s: %v", file, err))
+		resourceParser.SetProviders(proj.providers)
+		cleanGraph := core.NewGraph()
+		var targets []core.Resource
+		for id, state := range stateManager.GetAllStates() {
+			if !matchesCleanFilters(id) {
 				continue
 			}
-			allPackages = append(allPackages, packages...)
-		}
-		resourceParser.SetPackages(allPackages)
 
-		// Create resources using the parser
-		resources, err := resourceParser.ParseResources()
-		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating resources: %v", err))
-			return
-		}
-		logger.Info(fmt.Sprintf("Created %d resources for cleanup", len(resources)))
+			resource, declared := graph.GetResource(id)
+			if !declared {
+				resource, err = resourceParser.ResourceFromState(id, state)
+				if err != nil {
+					logger.Warning(fmt.Sprintf("Skipping %s: %v", id, err))
+					continue
+				}
+			}
+			if cleanGroup != "" && resource.GetTarget().Group != cleanGroup {
+				continue
+			}
+			if len(hostLimit) > 0 && !core.TargetsAny(resource, core.HostMap(hosts)) {
+				continue
+			}
+			if !labelSelector.Matches(core.StateLabels(state)) {
+				continue
+			}
 
-		// Create and populate the graph
-		graph := core.NewGraph()
-		for _, resource := range resources {
-			if err := graph.AddResource(resource); err != nil {
-				logger.Error(fmt.Sprintf("Error adding resource %s to graph: %v", resource.GetID(), err))
+			if err := cleanGraph.AddResource(resource); err != nil {
+				logger.Warning(fmt.Sprintf("Skipping %s: %v", id, err))
 				continue
 			}
+			targets = append(targets, resource)
 		}
 
-		// Validate the graph
-		if err := graph.ValidateDependencies(); err != nil {
-			logger.Error(fmt.Sprintf("Graph validation failed: %v", err))
+		if len(targets) == 0 {
+			logger.Info("Nothing to clean: no matching resources recorded in state")
+			setExitStatus(exitNoChanges)
 			return
 		}
 
-		// Create state manager
-		stateManager := core.NewStateManager(".settle/state.json", graph)
-		if err := stateManager.LoadState(); err != nil {
-			logger.Error(fmt.Sprintf("Error loading state: %v", err))
-			return
-		}
+		// Remove the most dependent layers first
+		sort.Slice(targets, func(i, j int) bool {
+			if targets[i].GetLayer() != targets[j].GetLayer() {
+				return targets[i].GetLayer() > targets[j].GetLayer()
+			}
+			return targets[i].GetID() < targets[j].GetID()
+		})
 
-		// Create a cleanup plan (all resources marked for deletion)
 		plan := &core.Plan{
 			Actions:   make([]*core.Action, 0),
 			CreatedAt: time.Now(),
-			Graph:     graph,
+			Graph:     cleanGraph,
 		}
-
-		// Mark all resources for deletion
-		for _, resource := range resources {
-			plan.Actions = append(plan.Actions, &core.Action{
-				ResourceID: resource.GetID(),
-				Type:       core.ActionDelete,
-				Changes:    []core.Change{},
-				Metadata: map[string]interface{}{
-					"reason": "cleanup requested",
-				},
-			})
+		hostMap := core.HostMap(hosts)
+		for _, resource := range targets {
+			plan.Actions = append(plan.Actions, core.NewDeleteAction(resource, hostMap, "cleanup requested"))
 		}
 
 		// Log plan summary
 		logger.Info("Cleanup Plan:")
 		logger.Info(fmt.Sprintf("  Delete: %d resources", len(plan.Actions)))
+		for _, action := range plan.Actions {
+			logger.Info(fmt.Sprintf("  - %s", action.ResourceID))
+			logRemovals(logger, action, "      ")
+		}
+		if err := enforceLimits(logger, plan, hosts); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		if err := enforcePolicies(cmd.Context(), logger, plan, hosts); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		if err := enforceImpact(logger, plan); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		if !cleanForce {
+			if !isInteractive() {
+				logger.Error("Refusing to clean without confirmation: run interactively or pass --force")
+				return
+			}
+			if !confirm(fmt.Sprintf("Delete %d resources from their hosts? Type 'yes' to continue: ", len(plan.Actions)), "yes") ||
+				!confirm(fmt.Sprintf("Please confirm again by typing the number of resources (%d): ", len(plan.Actions)), fmt.Sprint(len(plan.Actions))) {
+				logger.Info("Cleanup cancelled")
+				return
+			}
+		}
 
 		// Create executor and execute the plan
-		executor := core.NewExecutor(graph, stateManager, logger)
+		executor := core.NewExecutor(cleanGraph, stateManager, logger)
 		executor.SetHosts(hosts)
-		result, err := executor.Execute(context.Background(), plan)
+		executor.SetInventory(proj.allHosts)
+		executor.SetParallelism(cleanParallel)
+		executor.SetConcurrency(parallelActions)
+		pushMetricsOnFinish(executor.Events(), logger, "clean")
+		ctx, stop := interruptContext(context.Background(), logger)
+		defer stop()
+		if cleanTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cleanTimeout)
+			defer cancel()
+		}
+		before := stateManager.Checksums()
+		stopProgress := startProgress(executor, logger, plan, hosts)
+		result, err := executor.Execute(ctx, plan)
+		stopProgress()
 		if err != nil {
 			logger.Error(fmt.Sprintf("Cleanup failed: %v", err))
-			return
+			if result == nil {
+				return
+			}
 		}
+		recordRun(logger, stateManager, "clean", result, before)
 
 		// Log execution summary
-		logger.Info("Cleanup completed:")
+		if result.Interrupted {
+			logger.Warning("Cleanup interrupted; partial summary:")
+		} else {
+			logger.Info("Cleanup completed:")
+		}
 		logger.Info(fmt.Sprintf("  Duration: %v", result.GetDuration()))
 		logger.Info(fmt.Sprintf("  Success: %d", result.GetSuccessCount()))
 		logger.Info(fmt.Sprintf("  Failed: %d", result.GetFailureCount()))
-	},
-}
-
-func init() {
-	rootCmd.AddCommand(cleanCmd)
-}
-
-func detectResourceTypes(content string) []string {
-	lines := strings.Split(content, "\n")
-	resourceTypes := make(map[string]bool)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		if strings.HasPrefix(line, "package ") && strings.Contains(line, "\"") {
-			resourceTypes["package"] = true
+		if skipped := result.GetSkippedCount(); skipped > 0 {
+			logger.Info(fmt.Sprintf("  Skipped: %d (their dependencies failed)", skipped))
 		}
-		if strings.HasPrefix(line, "service ") && strings.Contains(line, "\"") {
-			resourceTypes["service"] = true
+		hostsSucceeded, hostsFailed := result.GetHostCounts()
+		logger.Info(fmt.Sprintf("  Host runs: %d succeeded, %d failed", hostsSucceeded, hostsFailed))
+		if result.Interrupted {
+			logger.Info(fmt.Sprintf("  Not started: %d", len(plan.Actions)-len(result.Actions)))
 		}
-		if strings.HasPrefix(line, "file ") && strings.Contains(line, "\"") {
-			resourceTypes["file"] = true
+		if err == nil && !result.Interrupted && result.GetFailureCount() == 0 {
+			setExitStatus(exitNoChanges)
 		}
-	}
-
-	var types []string
-	for resourceType := range resourceTypes {
-		types = append(types, resourceType)
-	}
-	return types
+	},
 }
 
-func countResourceDeclarations(content string, resourceType string) int {
-	lines := strings.Split(content, "\n")
-	count := 0
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, resourceType+" ") && strings.Contains(line, "\"") {
-			count++
+// matchesCleanFilters reports whether id is selected by --target patterns
+func matchesCleanFilters(id core.ResourceID) bool {
+	if len(cleanTargets) == 0 {
+		return true
+	}
+	for _, pattern := range cleanTargets {
+		if ok, _ := filepath.Match(pattern, string(id)); ok {
+			return true
 		}
 	}
-	return count
+	return false
+}
+
+func init() {
+	cleanCmd.Flags().BoolVarP(&cleanForce, "force", "f", false, "Skip the confirmation prompts")
+	cleanCmd.Flags().StringSliceVarP(&cleanTargets, "target", "t", nil, "Only clean resources matching these IDs or glob patterns")
+	cleanCmd.Flags().StringVarP(&cleanGroup, "group", "G", "", "Only clean resources targeting this host group")
+	cleanCmd.RegisterFlagCompletionFunc("target", completeResourceIDs)
+	cleanCmd.RegisterFlagCompletionFunc("group", completeGroups)
+	cleanCmd.Flags().DurationVar(&cleanTimeout, "timeout", 0, "Deadline for the whole run, e.g. 30m (0 means no deadline)")
+	cleanCmd.Flags().BoolVar(&overrideLimits, "override-limits", false, "Clean even when the plan exceeds the limits in settle.stl")
+	cleanCmd.Flags().BoolVar(&forbidDisruptive, "forbid-disruptive", false, "Refuse plans with disruptive changes, such as service stops or package removals")
+	cleanCmd.Flags().BoolVar(&approveDisruptive, "approve-disruptive", false, "Clean despite --forbid-disruptive when the plan has disruptive changes")
+	cleanCmd.Flags().IntVar(&cleanParallel, "parallel", ssh.MaxConnections, "Maximum number of hosts to apply a resource to concurrently")
+	addParallelActionsFlag(cleanCmd)
+	addLimitFlag(cleanCmd)
+	rootCmd.AddCommand(cleanCmd)
 }
 
-// Find all .stl files except hosts.stl
+// Find all .stl files of the resource directory except the inventory and the
+// settle.stl config file
 func findResourceFiles() ([]string, error) {
-	files, err := filepath.Glob("*.stl")
+	files, err := filepath.Glob(filepath.Join(resourceDir, "*.stl"))
 	if err != nil {
 		return nil, err
 	}
 
+	// Inventory files, including those hosts.stl includes, hold no resources
+	inventoryFiles, err := parser.InventoryFiles(hostsFile)
+	if err != nil {
+		return nil, err
+	}
+	skip := map[string]bool{configFile: true}
+	for _, file := range inventoryFiles {
+		skip[filepath.Clean(file)] = true
+	}
+
 	var resources []string
 	for _, file := range files {
-		if file == "hosts.stl" {
-			continue // Skip hosts file
+		if skip[filepath.Clean(file)] {
+			continue // Skip hosts and config files
 		}
 		resources = append(resources, file)
 	}
 	return resources, nil
 }
+
+// logRemovals lists what a delete action will remove and from which hosts
+func logRemovals(logger *inventory.Logger, action *core.Action, indent string) {
+	if hosts, ok := action.Metadata["hosts"].([]string); ok {
+		logger.Info(fmt.Sprintf("%sHosts: %s", indent, strings.Join(hosts, ", ")))
+	}
+	if removes, ok := action.Metadata["removes"].([]core.Removal); ok {
+		for _, removal := range removes {
+			logger.Info(fmt.Sprintf("%sRemoves %s", indent, describeRemoval(removal)))
+		}
+	}
+}
+
+// describeRemoval returns what a removal deletes, e.g. "unit nginx.service"
+func describeRemoval(removal core.Removal) string {
+	description := fmt.Sprintf("%s %s", removal.Kind, removal.Name)
+	if removal.Detail != "" {
+		description += fmt.Sprintf(" (%s)", removal.Detail)
+	}
+	return description
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+// Completion functions run without the PersistentPreRunE of rootCmd, so each
+// applies --chdir and settle.stl itself. They read only local files: the
+// inventory without dynamic providers and the state, never a host.
+
+// completionHosts returns the hosts of the inventory file, or nil when it
+// cannot be read
+func completionHosts(cmd *cobra.Command) []common.Host {
+	if err := loadSettings(cmd); err != nil {
+		return nil
+	}
+	hosts, err := parser.ParseInventory(hostsFile)
+	if err != nil {
+		return nil
+	}
+	return hosts
+}
+
+// completeHostNames completes the names and aliases of the inventory
+func completeHostNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var names []string
+	for _, host := range completionHosts(cmd) {
+		names = append(names, host.Name)
+		names = append(names, host.Aliases...)
+	}
+	return matchCompletions(names, toComplete, args), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeGroups completes the groups the hosts of the inventory belong to
+func completeGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var groups []string
+	for _, host := range completionHosts(cmd) {
+		if host.Group != "" {
+			groups = append(groups, host.Group)
+		}
+	}
+	return matchCompletions(groups, toComplete, nil), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeHostsAndGroups completes host names, aliases and groups, e.g. for
+// --limit
+func completeHostsAndGroups(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	names, _ := completeHostNames(cmd, nil, toComplete)
+	groups, _ := completeGroups(cmd, nil, toComplete)
+	return matchCompletions(append(names, groups...), toComplete, nil), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeResourceIDs completes the IDs of the resources in the state
+func completeResourceIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if err := loadSettings(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	stateManager, err := openState(nil, inventory.NewLogger())
+	if err != nil || stateManager.LoadState() != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var ids []string
+	for id := range stateManager.GetAllStates() {
+		ids = append(ids, string(id))
+	}
+	return matchCompletions(ids, toComplete, nil), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeValues returns a completion function offering a fixed set of values
+func completeValues(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return cobra.FixedCompletions(values, cobra.ShellCompDirectiveNoFileComp)
+}
+
+// completeDirs completes directories only
+func completeDirs(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+// completeStlFiles completes .stl files and directories
+func completeStlFiles(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return []string{"stl"}, cobra.ShellCompDirectiveFilterFileExt
+}
+
+// matchCompletions returns the sorted, distinct candidates starting with
+// toComplete, leaving out those already given in used
+func matchCompletions(candidates []string, toComplete string, used []string) []string {
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, toComplete) && !slices.Contains(used, candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+	return slices.Compact(matches)
+}
@@ -0,0 +1,12 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+// parallelActions holds --parallel-actions, how many independent resources
+// are applied at once
+var parallelActions int
+
+// addParallelActionsFlag registers --parallel-actions on cmd
+func addParallelActionsFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&parallelActions, "parallel-actions", 1, "Maximum number of independent resources to apply concurrently, the slowest first")
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// isInteractive reports whether stdin is a terminal
+func isInteractive() bool {
+	return isTerminal(os.Stdin)
+}
+
+// isTerminal reports whether file is a terminal rather than a pipe or file
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// confirm prints prompt and reports whether the user typed expected
+func confirm(prompt, expected string) bool {
+	fmt.Print(prompt)
+	answer, err := stdinReader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == expected
+}
+
+// ask prints question with its default and returns the answer, or the
+// default when the answer is empty
+func ask(question, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", question, defaultValue)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, _ := stdinReader.ReadString('\n')
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return defaultValue
+	}
+	return answer
+}
@@ -3,113 +3,243 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"time"
 
-	"github.com/settlectl/settle-core/common"
 	"github.com/settlectl/settle-core/core"
 	"github.com/settlectl/settle-core/inventory"
-	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/inventory/ssh"
 	"github.com/spf13/cobra"
 )
 
+var (
+	createParallel  int
+	createTimeout   time.Duration
+	createNoCompare bool
+	createResume    bool
+)
+
 var createCmd = &cobra.Command{
 	Use:   "create",
 	Short: "create units on hosts",
+	Long: `Create plans the changes the configuration needs and applies them to the
+hosts.
+
+Each action completed is recorded in a checkpoint next to the state. When a
+run fails or is interrupted, create --resume runs the rest of its plan
+without planning again, provided the configuration is unchanged.
+
+With --detailed-exitcode create exits 0 when nothing needed to change, 2
+when changes were applied and 1 on errors, including failed actions.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Every return before the end is an error
+		setExitCode(exitError)
 		logger := inventory.NewLogger()
+		if err := checkReportFlags(); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		if reportToStdout() {
+			logger.SetOutput(os.Stderr)
+		}
 		logger.Info("Starting resource creation")
 
-
-		hosts, err := parser.ParseHosts("hosts.stl")
+		proj, err := loadProjectWith(logger, projectOptions{preflight: true})
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error parsing hosts file: %v", err))
+			logger.Error(err.Error())
 			return
 		}
-		logger.Info(fmt.Sprintf("Found %d hosts", len(hosts)))
-
+		hosts, resources, graph := proj.hosts, proj.resources, proj.graph
+		logger.Info(fmt.Sprintf("Created %d resources", len(resources)))
+		proj.logPermissionWarnings(logger)
 
-		resourceFiles, err := findResourceFiles()
+		stateManager, err := openState(graph, logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error finding resource files: %v", err))
+			logger.Error(fmt.Sprintf("Error opening state: %v", err))
 			return
 		}
-
-
-		resourceParser := core.NewResourceParser()
-		resourceParser.SetHosts(hosts)
-
-
-		var allPackages []common.Package
-		for _, file := range resourceFiles {
-			packages, err := parser.ParsePackages(file)
-			if err != nil {
-				logger.Error(fmt.Sprintf("Error parsing packages from %s: %v", file, err))
-				continue
-			}
-			allPackages = append(allPackages, packages...)
+		if err := stateManager.Lock(lockOwner()); err != nil {
+			logger.Error(fmt.Sprintf("Error locking state: %v", err))
+			return
 		}
-		resourceParser.SetPackages(allPackages)
+		defer stateManager.Unlock()
 
-
-		resources, err := resourceParser.ParseResources()
-		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating resources: %v", err))
+		if err := stateManager.LoadState(); err != nil {
+			logger.Error(fmt.Sprintf("Error loading state: %v", err))
 			return
 		}
-		logger.Info(fmt.Sprintf("Created %d resources", len(resources)))
 
-
-		graph := core.NewGraph()
-		for _, resource := range resources {
-			if err := graph.AddResource(resource); err != nil {
-				logger.Error(fmt.Sprintf("Error adding resource %s to graph: %v", resource.GetID(), err))
-				continue
+		var plan *core.Plan
+		var checkpoint *core.Checkpoint
+		if createResume {
+			if checkpoint, err = resumeCheckpoint(stateManager, proj); err != nil {
+				logger.Error(err.Error())
+				return
+			}
+			// Resources the run deletes because they were removed from the
+			// configuration are rebuilt from the state
+			core.AddOrphans(graph, stateManager, proj.providers, logger)
+			if plan, err = checkpoint.Remaining(graph); err != nil {
+				logger.Error(fmt.Sprintf("Cannot resume: %v", err))
+				return
 			}
+			logger.Info(fmt.Sprintf("Resuming run %s started at %s: %d of %d actions already completed",
+				checkpoint.RunID, checkpoint.StartedAt.Local().Format("2006-01-02 15:04:05"), len(checkpoint.Actions)-len(plan.Actions), len(checkpoint.Actions)))
+		} else {
+			planner := core.NewPlanner(graph, stateManager, logger)
+			planner.SetHosts(hosts)
+			planner.SetProviders(proj.providers)
+			planner.SetSelector(labelSelector)
+			if plan, err = planner.Plan(); err != nil {
+				logger.Error(fmt.Sprintf("Error creating plan: %v", err))
+				return
+			}
+			plan.ConfigHash = proj.snapshot.Hash()
 		}
 
+		logger.Info(inventory.Message(inventory.MsgExecutionPlan))
+		renderPlan(logger, plan, hosts, false)
 
-		if err := graph.ValidateDependencies(); err != nil {
-			logger.Error(fmt.Sprintf("Graph validation failed: %v", err))
+		if err := enforceLimits(logger, plan, hosts); err != nil {
+			logger.Error(err.Error())
 			return
 		}
-
-
-		stateManager := core.NewStateManager(".settle/state.json", graph)
-		if err := stateManager.LoadState(); err != nil {
-			logger.Error(fmt.Sprintf("Error loading state: %v", err))
+		if err := enforcePolicies(cmd.Context(), logger, plan, hosts); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		if err := enforceImpact(logger, plan); err != nil {
+			logger.Error(err.Error())
 			return
 		}
 
-
-		planner := core.NewPlanner(graph, stateManager, logger)
-		plan, err := planner.Plan()
+		runHooks, err := loadRunHooks()
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating plan: %v", err))
+			logger.Error(fmt.Sprintf("Error parsing hooks from %s: %v", configFile, err))
 			return
 		}
 
-
-		logger.Info("Execution Plan:")
-		logger.Info(fmt.Sprintf("  Create: %d resources", plan.GetActionCount(core.ActionCreate)))
-		logger.Info(fmt.Sprintf("  Update: %d resources", plan.GetActionCount(core.ActionUpdate)))
-		logger.Info(fmt.Sprintf("  No-op: %d resources", plan.GetActionCount(core.ActionNoOp)))
-
-
 		executor := core.NewExecutor(graph, stateManager, logger)
 		executor.SetHosts(hosts)
-		result, err := executor.Execute(context.Background(), plan)
+		executor.SetInventory(proj.allHosts)
+		executor.SetParallelism(createParallel)
+		executor.SetConcurrency(parallelActions)
+		executor.SetGuard(proj.snapshot.Verify)
+		if checkpoint == nil {
+			checkpoint = core.NewCheckpoint(executor.RunID(), plan)
+		}
+		executor.SetCheckpoint(checkpoint)
+		notifyOnFinish(executor.Events(), logger, "create", time.Now())
+		pushMetricsOnFinish(executor.Events(), logger, "create")
+		var comparison *core.PlanComparison
+		if !createNoCompare {
+			comparison = core.NewPlanComparison()
+			executor.Use(comparison.Middleware())
+		}
+		ctx, stop := interruptContext(context.Background(), logger)
+		defer stop()
+		if createTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, createTimeout)
+			defer cancel()
+		}
+		if err := core.RunProjectHooks(ctx, "pre-run", runHooks, hosts, logger); err != nil {
+			logger.Error(fmt.Sprintf("Pre-run hook failed, nothing was applied: %v", err))
+			return
+		}
+
+		before := stateManager.Checksums()
+		stopProgress := startProgress(executor, logger, plan, hosts)
+		result, err := executor.Execute(ctx, plan)
+		stopProgress()
 		if err != nil {
 			logger.Error(fmt.Sprintf("Execution failed: %v", err))
-			return
+			if result == nil {
+				return
+			}
+		}
+		recordRun(logger, stateManager, "create", result, before)
+		if comparison != nil {
+			result.Discrepancies = comparison.Discrepancies()
 		}
 
+		// Post-run hooks typically undo pre-run ones, so they run even after an interrupt
+		if err := core.RunProjectHooks(context.WithoutCancel(ctx), "post-run", runHooks, hosts, logger); err != nil {
+			logger.Error(fmt.Sprintf("Post-run hook failed: %v", err))
+		}
 
-		logger.Info("Execution completed:")
+		if result.Interrupted {
+			logger.Warning("Execution interrupted; partial summary:")
+		} else {
+			logger.Info("Execution completed:")
+		}
 		logger.Info(fmt.Sprintf("  Duration: %v", result.GetDuration()))
 		logger.Info(fmt.Sprintf("  Success: %d", result.GetSuccessCount()))
 		logger.Info(fmt.Sprintf("  Failed: %d", result.GetFailureCount()))
+		if skipped := result.GetSkippedCount(); skipped > 0 {
+			logger.Info(fmt.Sprintf("  Skipped: %d (their dependencies failed)", skipped))
+		}
+		hostsSucceeded, hostsFailed := result.GetHostCounts()
+		logger.Info(fmt.Sprintf("  Host runs: %d succeeded, %d failed", hostsSucceeded, hostsFailed))
+		if result.Interrupted {
+			logger.Info(fmt.Sprintf("  Not started: %d", len(plan.Actions)-len(result.Actions)))
+		}
+		if comparison != nil {
+			logPlanDiscrepancies(logger, result.Discrepancies)
+		}
+
+		if err := writeRunReport(result); err != nil {
+			logger.Error(fmt.Sprintf("Error writing report: %v", err))
+		}
+		summary := core.NewExecutionSummary("create", result)
+		if result.Success {
+			setExitCode(changesExitCode(summary.Changed))
+		}
 	},
 }
 
+// resumeCheckpoint returns the checkpoint of the run to resume, which must
+// have been planned from the current configuration
+func resumeCheckpoint(stateManager *core.StateManager, proj *project) (*core.Checkpoint, error) {
+	checkpoint, err := stateManager.LoadCheckpoint()
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint: %w", err)
+	}
+	if checkpoint == nil {
+		return nil, fmt.Errorf("nothing to resume: the last run completed")
+	}
+	if checkpoint.ConfigHash != proj.snapshot.Hash() {
+		return nil, fmt.Errorf("the configuration changed since run %s was planned; run create without --resume to plan again", checkpoint.RunID)
+	}
+	return checkpoint, nil
+}
+
+// logPlanDiscrepancies reports where the run's outcome differed from the plan
+func logPlanDiscrepancies(logger *inventory.Logger, discrepancies []core.Discrepancy) {
+	if len(discrepancies) == 0 {
+		logger.Info("  Plan discrepancies: none")
+		return
+	}
+	logger.Warning(fmt.Sprintf("  Plan discrepancies: %d", len(discrepancies)))
+	for _, discrepancy := range discrepancies {
+		logger.Warning(fmt.Sprintf("    [%s] %s", discrepancy.Kind, discrepancy))
+	}
+}
+
 func init() {
+	createCmd.Flags().StringVar(&reportFormat, "report", "", "Write a run report: json or junit")
+	createCmd.RegisterFlagCompletionFunc("report", completeValues("json", "junit"))
+	createCmd.Flags().StringVar(&reportFile, "report-file", "-", "File to write the run report to (- for stdout)")
+	createCmd.Flags().DurationVar(&createTimeout, "timeout", 0, "Deadline for the whole run, e.g. 30m (0 means no deadline)")
+	createCmd.Flags().IntVar(&createParallel, "parallel", ssh.MaxConnections, "Maximum number of hosts to apply a resource to concurrently")
+	addParallelActionsFlag(createCmd)
+	addUnreachableFlag(createCmd)
+	createCmd.Flags().BoolVar(&overrideLimits, "override-limits", false, "Apply a plan that exceeds the limits in settle.stl")
+	createCmd.Flags().BoolVar(&forbidDisruptive, "forbid-disruptive", false, "Refuse plans with disruptive changes, such as service restarts or package removals")
+	createCmd.Flags().BoolVar(&approveDisruptive, "approve-disruptive", false, "Apply the disruptive changes of the plan despite --forbid-disruptive")
+	createCmd.Flags().BoolVar(&detailedExitCode, "detailed-exitcode", false, "Exit 0 without changes, 2 with changes applied and 1 on errors")
+	createCmd.Flags().BoolVar(&createResume, "resume", false, "Finish the last run, which failed or was interrupted, without planning again")
+	createCmd.Flags().BoolVar(&createNoCompare, "no-compare", false, "Do not read resources around each action to compare the outcome with the plan")
+	addLimitFlag(createCmd)
 	rootCmd.AddCommand(createCmd)
 }
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/ssh"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the daemon command
+const (
+	daemonExitStopped = 0
+	daemonExitError   = 1
+)
+
+// Notification modes of the daemon
+const (
+	daemonNotifyDrift  = "drift"  // only after runs that changed or failed something
+	daemonNotifyAlways = "always" // after every run
+	daemonNotifyNever  = "never"
+)
+
+var (
+	daemonInterval   time.Duration
+	daemonJitter     float64
+	daemonNotify     string
+	daemonStatusAddr string
+	daemonParallel   int
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "keep hosts converged by planning and applying periodically",
+	Long: `Daemon plans and applies the configuration every --interval until it is
+stopped with SIGINT or SIGTERM, so changes made on hosts are reverted and
+changes to the configuration are rolled out. The project is loaded again
+for every run.
+
+Each wait is lengthened by a random part of up to --jitter times the
+interval, so daemons of several projects do not all connect at once.
+Runs that find nothing to change apply nothing. By default notifications
+are only sent after runs that changed or failed something.
+
+The status of the last run on each host is served as JSON on
+http://<status-addr>/status, and /healthz answers 503 while the last run
+failed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runDaemon())
+	},
+}
+
+func runDaemon() int {
+	logger := inventory.NewLogger()
+	if daemonInterval <= 0 {
+		logger.Error("--interval must be positive")
+		return daemonExitError
+	}
+	if daemonJitter < 0 || daemonJitter > 1 {
+		logger.Error("--jitter must be between 0 and 1")
+		return daemonExitError
+	}
+	switch daemonNotify {
+	case daemonNotifyDrift, daemonNotifyAlways, daemonNotifyNever:
+	default:
+		logger.Error(fmt.Sprintf("Unknown --notify %q (expected %s, %s or %s)", daemonNotify, daemonNotifyDrift, daemonNotifyAlways, daemonNotifyNever))
+		return daemonExitError
+	}
+
+	status := core.NewConvergenceStatus(daemonInterval)
+	if daemonStatusAddr != "" {
+		listener, err := net.Listen("tcp", daemonStatusAddr)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error listening for status requests: %v", err))
+			return daemonExitError
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/status", status)
+		mux.HandleFunc("/healthz", status.ServeHealth)
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go server.Serve(listener)
+		defer server.Close()
+		logger.Info(fmt.Sprintf("Serving status on http://%s/status", listener.Addr()))
+	}
+
+	ctx, stop := interruptContext(context.Background(), logger)
+	defer stop()
+	logger.Info(fmt.Sprintf("Converging every %v", daemonInterval))
+	for first := true; ; first = false {
+		if !first {
+			inventory.SetRunID(inventory.NewRunID())
+		}
+		converge(ctx, logger, status)
+		if ctx.Err() != nil {
+			break
+		}
+
+		wait := daemonInterval + time.Duration(rand.Float64()*daemonJitter*float64(daemonInterval))
+		next := time.Now().Add(wait)
+		status.Scheduled(next)
+		logger.Info(fmt.Sprintf("Next run at %s", next.Format("15:04:05")))
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	logger.Info("Daemon stopped")
+	return daemonExitStopped
+}
+
+// converge plans and applies the configuration once and records the outcome
+// in status
+func converge(ctx context.Context, logger *inventory.Logger, status *core.ConvergenceStatus) {
+	run := core.ConvergenceRun{RunID: inventory.RunID(), StartedAt: time.Now()}
+	logger.Info(fmt.Sprintf("Starting run %s", run.RunID))
+	hosts, plan, result, err := convergeOnce(ctx, logger, run.StartedAt)
+	run.FinishedAt = time.Now()
+	if err != nil {
+		logger.Error(err.Error())
+	}
+	status.Record(run, hosts, plan, result, err)
+}
+
+// convergeOnce is one run of the daemon, like create. It returns the hosts
+// and plan of the run, and its result if anything was applied.
+func convergeOnce(ctx context.Context, logger *inventory.Logger, startedAt time.Time) ([]common.Host, *core.Plan, *core.ExecutionResult, error) {
+	proj, err := loadProjectWith(logger, projectOptions{preflight: true})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hosts, graph := proj.hosts, proj.graph
+
+	stateManager, err := openState(graph, logger)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error opening state: %w", err)
+	}
+	if err := stateManager.Lock(lockOwner()); err != nil {
+		return nil, nil, nil, fmt.Errorf("error locking state: %w", err)
+	}
+	defer stateManager.Unlock()
+	if err := stateManager.LoadState(); err != nil {
+		return nil, nil, nil, fmt.Errorf("error loading state: %w", err)
+	}
+
+	planner := core.NewPlanner(graph, stateManager, logger)
+	planner.SetHosts(hosts)
+	planner.SetProviders(proj.providers)
+	planner.SetSelector(labelSelector)
+	plan, err := planner.Plan()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error creating plan: %w", err)
+	}
+	if changes := len(plan.Actions) - plan.GetActionCount(core.ActionNoOp); changes == 0 {
+		logger.Info(fmt.Sprintf("Converged: none of %d resources needs to change", len(plan.Actions)))
+		if daemonNotify == daemonNotifyAlways {
+			summary := core.NewPlanSummary(plan, time.Since(startedAt).Round(time.Millisecond))
+			summary.Command = "daemon"
+			notifyRun(logger, summary)
+		}
+		return hosts, plan, nil, nil
+	}
+
+	logger.Info(inventory.Message(inventory.MsgExecutionPlan))
+	renderPlan(logger, plan, hosts, false)
+	if err := enforceLimits(logger, plan, hosts); err != nil {
+		return hosts, plan, nil, err
+	}
+	if err := enforcePolicies(ctx, logger, plan, hosts); err != nil {
+		return hosts, plan, nil, err
+	}
+	if err := enforceImpact(logger, plan); err != nil {
+		return hosts, plan, nil, err
+	}
+	runHooks, err := loadRunHooks()
+	if err != nil {
+		return hosts, plan, nil, fmt.Errorf("error parsing hooks from %s: %w", configFile, err)
+	}
+
+	executor := core.NewExecutor(graph, stateManager, logger)
+	executor.SetHosts(hosts)
+	executor.SetInventory(proj.allHosts)
+	executor.SetParallelism(daemonParallel)
+	executor.SetConcurrency(parallelActions)
+	executor.SetGuard(proj.snapshot.Verify)
+	if daemonNotify != daemonNotifyNever {
+		notifyOnFinish(executor.Events(), logger, "daemon", startedAt)
+	}
+	pushMetricsOnFinish(executor.Events(), logger, "daemon")
+	if err := core.RunProjectHooks(ctx, "pre-run", runHooks, hosts, logger); err != nil {
+		return hosts, plan, nil, fmt.Errorf("pre-run hook failed, nothing was applied: %w", err)
+	}
+
+	before := stateManager.Checksums()
+	result, err := executor.Execute(ctx, plan)
+	if result != nil {
+		recordRun(logger, stateManager, "daemon", result, before)
+	}
+	if err := core.RunProjectHooks(context.WithoutCancel(ctx), "post-run", runHooks, hosts, logger); err != nil {
+		logger.Error(fmt.Sprintf("Post-run hook failed: %v", err))
+	}
+	if err != nil {
+		return hosts, plan, result, fmt.Errorf("execution failed: %w", err)
+	}
+
+	logger.Info(fmt.Sprintf("Run completed in %v: %d succeeded, %d failed, %d skipped",
+		result.GetDuration().Round(time.Millisecond), result.GetSuccessCount(), result.GetFailureCount(), result.GetSkippedCount()))
+	if failed := result.GetFailureCount(); failed > 0 {
+		return hosts, plan, result, fmt.Errorf("%d actions failed", failed)
+	}
+	return hosts, plan, result, nil
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+	daemonCmd.Flags().DurationVar(&daemonInterval, "interval", 30*time.Minute, "Time between runs, e.g. 30m")
+	daemonCmd.Flags().Float64Var(&daemonJitter, "jitter", 0.1, "Delay each run by a random part of up to this fraction of the interval")
+	daemonCmd.Flags().StringVar(&daemonNotify, "notify", daemonNotifyDrift, "When to send notifications: drift (after runs that changed or failed something), always or never")
+	daemonCmd.Flags().StringVar(&daemonStatusAddr, "status-addr", "127.0.0.1:9440", "Address serving the status of the last runs (empty disables it)")
+	daemonCmd.Flags().IntVar(&daemonParallel, "parallel", ssh.MaxConnections, "Maximum number of hosts to apply a resource to concurrently")
+	addParallelActionsFlag(daemonCmd)
+	addUnreachableFlag(daemonCmd)
+	daemonCmd.Flags().BoolVar(&overrideLimits, "override-limits", false, "Apply even when a plan exceeds the limits in settle.stl")
+	daemonCmd.Flags().BoolVar(&forbidDisruptive, "forbid-disruptive", false, "Fail runs whose plan has disruptive changes instead of applying them")
+}
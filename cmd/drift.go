@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the drift command
+const (
+	driftExitClean   = 0
+	driftExitError   = 1
+	driftExitDrifted = 2
+)
+
+var driftJSON bool
+
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "detect resources that changed on their hosts",
+	Long: `Drift inspects the real state of every resource recorded in the state file
+(installed package versions, file checksums, service status) and reports
+those that no longer match the applied configuration. Drifted resources are
+marked as drifted in state.
+
+Exit codes:
+  0  no drift detected
+  1  an error occurred
+  2  drift detected`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runDrift())
+	},
+}
+
+func runDrift() int {
+	logger := inventory.NewLogger()
+	if driftJSON {
+		logger.SetOutput(os.Stderr)
+	}
+	logger.Info("Starting drift detection")
+
+	proj, err := loadProject(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		return driftExitError
+	}
+
+	stateManager, err := openState(proj.graph, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error opening state: %v", err))
+		return driftExitError
+	}
+	if err := stateManager.Lock(lockOwner()); err != nil {
+		logger.Error(fmt.Sprintf("Error locking state: %v", err))
+		return driftExitError
+	}
+	defer stateManager.Unlock()
+
+	if err := stateManager.LoadState(); err != nil {
+		logger.Error(fmt.Sprintf("Error loading state: %v", err))
+		return driftExitError
+	}
+
+	verifier := core.NewVerifier(proj.graph, logger)
+	verifier.SetHosts(proj.hosts)
+	result, err := verifier.Verify(common.Profile{Name: "drift"})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Drift detection failed: %v", err))
+		return driftExitError
+	}
+
+	drifted := make([]*core.Check, 0)
+	failed := false
+	for _, check := range result.Checks {
+		if stateManager.GetState(check.ResourceID) == nil {
+			continue // not managed yet
+		}
+		switch check.Status {
+		case core.CheckMissing, core.CheckMismatch:
+			drifted = append(drifted, check)
+			if err := stateManager.MarkDrifted(check.ResourceID, check.Changes); err != nil {
+				logger.Error(fmt.Sprintf("Error updating state for %s: %v", check.ResourceID, err))
+				failed = true
+			}
+		case core.CheckError:
+			logger.Error(fmt.Sprintf("Error inspecting %s on %s: %s", check.ResourceID, check.Host, check.Error))
+			failed = true
+		}
+	}
+
+	if driftJSON {
+		data, err := json.MarshalIndent(drifted, "", "  ")
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error encoding drift report: %v", err))
+			return driftExitError
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, check := range drifted {
+			logger.Warning(fmt.Sprintf("%s on %s drifted (%s)", check.ResourceID, check.Host, check.Status))
+			for _, change := range check.Changes {
+				logger.Info(fmt.Sprintf("    %s: applied %v, found %v", change.Field, change.NewValue, change.OldValue))
+			}
+		}
+		logger.Info(fmt.Sprintf("Drifted: %d resources", len(drifted)))
+	}
+
+	switch {
+	case failed:
+		return driftExitError
+	case len(drifted) > 0:
+		return driftExitDrifted
+	}
+	return driftExitClean
+}
+
+func init() {
+	driftCmd.Flags().BoolVar(&driftJSON, "json", false, "Print drifted resources as JSON")
+	rootCmd.AddCommand(driftCmd)
+}
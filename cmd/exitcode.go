@@ -0,0 +1,46 @@
+package cmd
+
+import "os"
+
+// Exit codes of plan and create with --detailed-exitcode, the same drift
+// always uses
+const (
+	exitNoChanges = 0
+	exitError     = 1
+	exitChanges   = 2
+)
+
+var (
+	detailedExitCode bool
+	exitCode         int
+)
+
+// setExitCode sets the status the process exits with once the command
+// returns; without --detailed-exitcode it stays 0
+func setExitCode(code int) {
+	if detailedExitCode {
+		setExitStatus(code)
+	}
+}
+
+// setExitStatus sets the status the process exits with once the command
+// returns, for commands that always report errors in it
+func setExitStatus(code int) {
+	exitCode = code
+}
+
+// changesExitCode returns exitChanges when changed is positive and
+// exitNoChanges otherwise
+func changesExitCode(changed int) int {
+	if changed > 0 {
+		return exitChanges
+	}
+	return exitNoChanges
+}
+
+// exit ends the process with the status set by the command
+func exit() {
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportFormat string
+	exportOutput string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export managed resources to other systems",
+}
+
+var exportCMDBCmd = &cobra.Command{
+	Use:   "cmdb",
+	Short: "list every managed resource for import into a CMDB",
+	Long: `Export lists every resource recorded in state, once per host it targets,
+with its type, a summary of the applied configuration, when it was last
+applied and its status.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := inventory.NewLogger()
+		logger.SetOutput(os.Stderr)
+
+		if exportFormat != "json" && exportFormat != "csv" {
+			logger.Error(fmt.Sprintf("Unsupported export format: %s (expected json or csv)", exportFormat))
+			os.Exit(1)
+		}
+
+		proj, err := loadProject(logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		stateManager, err := openState(proj.graph, logger)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error opening state: %v", err))
+			os.Exit(1)
+		}
+		if err := stateManager.LoadState(); err != nil {
+			logger.Error(fmt.Sprintf("Error loading state: %v", err))
+			os.Exit(1)
+		}
+
+		states := stateManager.GetAllStates()
+		for id, state := range states {
+			if !labelSelector.Matches(core.StateLabels(state)) {
+				delete(states, id)
+			}
+		}
+		records := core.BuildCMDB(states, proj.graph, core.HostMap(proj.hosts))
+
+		var out io.Writer = os.Stdout
+		if exportOutput != "" && exportOutput != "-" {
+			f, err := os.Create(exportOutput)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Error creating %s: %v", exportOutput, err))
+				os.Exit(1)
+			}
+			defer f.Close()
+			out = f
+		}
+
+		if exportFormat == "csv" {
+			err = core.WriteCMDBCSV(out, records)
+		} else {
+			encoder := json.NewEncoder(out)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(records)
+		}
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error writing export: %v", err))
+			os.Exit(1)
+		}
+		logger.Info(fmt.Sprintf("Exported %d records", len(records)))
+	},
+}
+
+func init() {
+	exportCMDBCmd.Flags().StringVar(&exportFormat, "format", "json", "Output format: json or csv")
+	exportCMDBCmd.RegisterFlagCompletionFunc("format", completeValues("json", "csv"))
+	exportCMDBCmd.Flags().StringVarP(&exportOutput, "output", "o", "-", "File to write to (- for stdout)")
+	exportCmd.AddCommand(exportCMDBCmd)
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/spf13/cobra"
+)
+
+var factsCmd = &cobra.Command{
+	Use:          "facts [host...]",
+	Short:        "Print the facts when conditions can compare",
+	SilenceUsage: true,
+	Long: `Gather and print the facts of every host, or of the named hosts, as
+when conditions see them:
+
+  settlectl facts web1
+  web1 arch=x86_64
+  web1 os=ubuntu
+  ...`,
+	ValidArgsFunction: completeHostNames,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hosts, err := loadHosts(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if len(args) > 0 {
+			hosts = slices.DeleteFunc(hosts, func(host common.Host) bool {
+				return !slices.ContainsFunc(args, func(name string) bool {
+					return name == host.Name || slices.Contains(host.Aliases, name)
+				})
+			})
+			if len(hosts) == 0 {
+				return fmt.Errorf("no host named %v", args)
+			}
+		}
+
+		failed := core.GatherFacts(cmd.Context(), hosts)
+		out := cmd.OutOrStdout()
+		for _, host := range hosts {
+			if err, ok := failed[host.Name]; ok {
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", host.Name, err)
+				continue
+			}
+			keys := make([]string, 0, len(host.Facts))
+			for key := range host.Facts {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(out, "%s %s=%s\n", host.Name, key, host.Facts[key])
+			}
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("failed to gather facts from %d of %d hosts", len(failed), len(hosts))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(factsCmd)
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the fmt command
+const (
+	fmtExitFormatted   = 0
+	fmtExitError       = 1
+	fmtExitUnformatted = 2
+)
+
+var fmtCheck bool
+
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [files...]",
+	Short: "rewrite .stl files in canonical format",
+	Long: `Fmt rewrites .stl files in canonical format: two-space indentation, the '='
+of consecutive attributes aligned, strings and block labels quoted and at most
+one blank line between statements. Comments are kept.
+
+Without arguments every .stl file of the project is formatted: the inventory,
+settle.stl, the resource files and the files of their modules.
+
+With --check nothing is written; files that are not formatted are listed.
+
+Exit codes:
+  0  every file is formatted
+  1  an error occurred, e.g. a syntax error
+  2  with --check, some files need formatting`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runFmt(args))
+	},
+}
+
+func runFmt(files []string) int {
+	logger := inventory.NewLogger()
+
+	if len(files) == 0 {
+		var err error
+		files, err = projectFiles()
+		if err != nil {
+			logger.Error(err.Error())
+			return fmtExitError
+		}
+	}
+
+	failed, unformatted := false, 0
+	for _, file := range files {
+		changed, err := formatFile(file)
+		if err != nil {
+			logger.Error(err.Error())
+			failed = true
+			continue
+		}
+		if !changed {
+			continue
+		}
+		unformatted++
+		if fmtCheck {
+			logger.Warning(fmt.Sprintf("%s is not formatted", file))
+		} else {
+			logger.Info(fmt.Sprintf("Formatted %s", file))
+		}
+	}
+
+	switch {
+	case failed:
+		return fmtExitError
+	case fmtCheck && unformatted > 0:
+		logger.Info(fmt.Sprintf("%d files need formatting; run settlectl fmt", unformatted))
+		return fmtExitUnformatted
+	}
+	return fmtExitFormatted
+}
+
+// formatFile formats a file in place, or only reports whether it would
+// change with --check
+func formatFile(file string) (bool, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	formatted, err := parser.Format(file, src)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(src, formatted) {
+		return false, nil
+	}
+	if fmtCheck {
+		return true, nil
+	}
+	if err := os.WriteFile(file, formatted, info.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return true, nil
+}
+
+// projectFiles returns every .stl file of the project: the inventory,
+// settle.stl, the resource files and the files of their modules
+func projectFiles() ([]string, error) {
+	files, err := parser.InventoryFiles(hostsFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(configFile); err == nil {
+		files = append(files, configFile)
+	}
+
+	resourceFiles, err := findResourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, resourceFiles...)
+
+	_, moduleFiles, err := findModules(resourceFiles)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, moduleFiles...)
+
+	// Modules may share a source directory
+	seen := make(map[string]bool)
+	unique := files[:0]
+	for _, file := range files {
+		if !seen[file] {
+			seen[file] = true
+			unique = append(unique, file)
+		}
+	}
+	return unique, nil
+}
+
+func init() {
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "Report unformatted files without changing them")
+	rootCmd.AddCommand(fmtCmd)
+}
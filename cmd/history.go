@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyJSON bool
+	historyLast int
+)
+
+var historyCmd = &cobra.Command{
+	Use:          "history [run-id]",
+	Short:        "List past runs or show what one of them changed",
+	SilenceUsage: true,
+	Long: `Every create and clean records who ran it, its plan and how each change
+ended, with the checksum of the resource before and after, in
+.settle/history/. Entries are only ever added.
+
+Without arguments history lists the last runs; with a run ID, or the start
+of one, it shows the changes of that run.
+
+Example:
+  settlectl history
+  settlectl history 3f9a0c`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeRunIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateManager, err := openState(nil, inventory.NewLogger())
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+
+		if len(args) == 1 {
+			run, err := stateManager.Run(args[0])
+			if err != nil {
+				return err
+			}
+			if historyJSON {
+				return printJSON(out, run)
+			}
+			printRun(out, run)
+			return nil
+		}
+
+		runs, err := stateManager.Runs()
+		if err != nil {
+			return err
+		}
+		if historyLast > 0 && len(runs) > historyLast {
+			runs = runs[len(runs)-historyLast:]
+		}
+		if historyJSON {
+			if runs == nil {
+				runs = []*core.RunRecord{}
+			}
+			return printJSON(out, runs)
+		}
+		if len(runs) == 0 {
+			fmt.Fprintln(out, "No runs recorded")
+			return nil
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tSTARTED\tCOMMAND\tUSER\tOUTCOME\tPLAN")
+		for _, run := range runs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t+%d ~%d -%d\n", run.RunID, run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+				run.Command, run.User, run.Outcome(), run.Plan.Create, run.Plan.Update, run.Plan.Delete)
+		}
+		return w.Flush()
+	},
+}
+
+// printRun prints a run and each of its changes with the checksums before
+// and after
+func printRun(out io.Writer, run *core.RunRecord) {
+	fmt.Fprintf(out, "Run:      %s\n", run.RunID)
+	fmt.Fprintf(out, "Command:  %s\n", run.Command)
+	fmt.Fprintf(out, "User:     %s\n", run.User)
+	fmt.Fprintf(out, "Started:  %s\n", run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(out, "Duration: %.1fs\n", run.Duration)
+	fmt.Fprintf(out, "Outcome:  %s\n", run.Outcome())
+	if run.Error != "" {
+		fmt.Fprintf(out, "Error:    %s\n", run.Error)
+	}
+	fmt.Fprintf(out, "Plan:     %d to create, %d to update, %d to delete, %d unchanged\n", run.Plan.Create, run.Plan.Update, run.Plan.Delete, run.Plan.NoOp)
+	if len(run.Actions) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tRESOURCE\tSTATUS\tBEFORE\tAFTER")
+	for _, action := range run.Actions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", action.Type, action.ResourceID, action.Status, shortChecksum(action.Before), shortChecksum(action.After))
+	}
+	w.Flush()
+	for _, action := range run.Actions {
+		if action.SkipReason != "" {
+			fmt.Fprintf(out, "%s skipped: %s\n", action.ResourceID, action.SkipReason)
+		}
+		for _, host := range action.Hosts {
+			if host.Error != "" {
+				fmt.Fprintf(out, "%s on %s: %s\n", action.ResourceID, host.Host, host.Error)
+			}
+		}
+	}
+}
+
+// shortChecksum abbreviates a checksum like sha256:1f0c9ab2... to its
+// algorithm and first 12 digits
+func shortChecksum(checksum string) string {
+	if checksum == "" {
+		return "-"
+	}
+	if len(checksum) > 19 {
+		return checksum[:19]
+	}
+	return checksum
+}
+
+func printJSON(out io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, string(data))
+	return err
+}
+
+// recordRun adds the run to the history, warning when it cannot
+func recordRun(logger *inventory.Logger, stateManager *core.StateManager, command string, result *core.ExecutionResult, before map[core.ResourceID]string) {
+	record := core.NewRunRecord(command, lockOwner(), result, before, stateManager)
+	if err := stateManager.RecordRun(record); err != nil {
+		logger.Warning(fmt.Sprintf("Failed to record run %s in the history: %v", result.RunID, err))
+	}
+}
+
+// completeRunIDs completes the IDs of the recorded runs
+func completeRunIDs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || loadSettings(cmd) != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	stateManager, err := openState(nil, inventory.NewLogger())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	runs, _ := stateManager.Runs()
+	var ids []string
+	for _, run := range runs {
+		ids = append(ids, run.RunID)
+	}
+	return matchCompletions(ids, toComplete, nil), cobra.ShellCompDirectiveNoFileComp
+}
+
+func init() {
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Print the runs as JSON")
+	historyCmd.Flags().IntVarP(&historyLast, "last", "n", 20, "Number of runs to list (0 for all)")
+	rootCmd.AddCommand(historyCmd)
+}
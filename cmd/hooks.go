@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+// loadRunHooks returns the pre-run and post-run hooks declared in settle.stl
+func loadRunHooks() ([]common.RunHook, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, nil
+	}
+	hooks, err := parser.ParseRunHooks(configFile)
+	if err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		if err := expandSecrets(&hooks[i].Command); err != nil {
+			return nil, fmt.Errorf("hook %s: %w", hooks[i].Name, err)
+		}
+	}
+	return hooks, nil
+}
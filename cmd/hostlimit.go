@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+// hostLimit holds the --limit patterns; empty means every host
+var hostLimit []string
+
+// addLimitFlag registers --limit on cmd
+func addLimitFlag(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVarP(&hostLimit, "limit", "l", nil, "Only touch these hosts: host names, groups or glob patterns such as web-*")
+	cmd.RegisterFlagCompletionFunc("limit", completeHostsAndGroups)
+}
+
+// limitHosts returns the hosts selected by --limit, or hosts without it
+func limitHosts(logger *inventory.Logger, hosts []common.Host) ([]common.Host, error) {
+	if len(hostLimit) == 0 {
+		return hosts, nil
+	}
+	limited, err := core.FilterHosts(hosts, hostLimit)
+	if err != nil {
+		return nil, fmt.Errorf("--limit: %w", err)
+	}
+	names := make([]string, 0, len(limited))
+	for _, host := range limited {
+		names = append(names, host.Name)
+	}
+	logger.Info(fmt.Sprintf("Limited to %d hosts: %s", len(limited), strings.Join(names, ", ")))
+	return limited, nil
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the check-idempotence command
+const (
+	idempotenceExitClean   = 0
+	idempotenceExitError   = 1
+	idempotenceExitChanges = 2
+)
+
+var checkIdempotenceCmd = &cobra.Command{
+	Use:   "check-idempotence",
+	Short: "apply the plan, then check that planning again finds nothing to change",
+	Long: `Check-idempotence applies the configuration like create, then plans again
+straight away. A correct configuration converges in one run, so the second
+plan must contain only no-ops; any action it still plans means a resource
+reports changes it has just made, or two resources fight over the same
+thing. Run it against test hosts when writing or reviewing configuration.
+
+Exit codes:
+  0  the second plan is all no-ops
+  1  an error occurred, including a failed action
+  2  the second plan still changes resources`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runCheckIdempotence(cmd))
+	},
+}
+
+func runCheckIdempotence(cmd *cobra.Command) int {
+	logger := inventory.NewLogger()
+	logger.Info("Checking idempotence: applying the plan, then planning again")
+
+	proj, err := loadProjectWith(logger, projectOptions{preflight: true})
+	if err != nil {
+		logger.Error(err.Error())
+		return idempotenceExitError
+	}
+	hosts, graph := proj.hosts, proj.graph
+
+	stateManager, err := openState(graph, logger)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error opening state: %v", err))
+		return idempotenceExitError
+	}
+	if err := stateManager.Lock(lockOwner()); err != nil {
+		logger.Error(fmt.Sprintf("Error locking state: %v", err))
+		return idempotenceExitError
+	}
+	defer stateManager.Unlock()
+
+	if err := stateManager.LoadState(); err != nil {
+		logger.Error(fmt.Sprintf("Error loading state: %v", err))
+		return idempotenceExitError
+	}
+
+	planner := core.NewPlanner(graph, stateManager, logger)
+	planner.SetHosts(hosts)
+	planner.SetProviders(proj.providers)
+	planner.SetSelector(labelSelector)
+	plan, err := planner.Plan()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating plan: %v", err))
+		return idempotenceExitError
+	}
+	plan.ConfigHash = proj.snapshot.Hash()
+
+	logger.Info(inventory.Message(inventory.MsgExecutionPlan))
+	renderPlan(logger, plan, hosts, false)
+	if err := enforceLimits(logger, plan, hosts); err != nil {
+		logger.Error(err.Error())
+		return idempotenceExitError
+	}
+	if err := enforcePolicies(context.Background(), logger, plan, hosts); err != nil {
+		logger.Error(err.Error())
+		return idempotenceExitError
+	}
+	runHooks, err := loadRunHooks()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error parsing hooks from %s: %v", configFile, err))
+		return idempotenceExitError
+	}
+
+	executor := core.NewExecutor(graph, stateManager, logger)
+	executor.SetHosts(hosts)
+	executor.SetInventory(proj.allHosts)
+	executor.SetGuard(proj.snapshot.Verify)
+	pushMetricsOnFinish(executor.Events(), logger, "check-idempotence")
+	ctx, stop := interruptContext(context.Background(), logger)
+	defer stop()
+	if err := core.RunProjectHooks(ctx, "pre-run", runHooks, hosts, logger); err != nil {
+		logger.Error(fmt.Sprintf("Pre-run hook failed, nothing was applied: %v", err))
+		return idempotenceExitError
+	}
+
+	before := stateManager.Checksums()
+	stopProgress := startProgress(executor, logger, plan, hosts)
+	result, err := executor.Execute(ctx, plan)
+	stopProgress()
+	if result != nil {
+		recordRun(logger, stateManager, "check-idempotence", result, before)
+	}
+	if err := core.RunProjectHooks(context.WithoutCancel(ctx), "post-run", runHooks, hosts, logger); err != nil {
+		logger.Error(fmt.Sprintf("Post-run hook failed: %v", err))
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Execution failed, so idempotence cannot be checked: %v", err))
+		return idempotenceExitError
+	}
+
+	logger.Info("Planning again")
+	second, err := planner.Plan()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating the second plan: %v", err))
+		return idempotenceExitError
+	}
+
+	changes := len(second.Actions) - second.GetActionCount(core.ActionNoOp)
+	if changes == 0 {
+		logger.Success(fmt.Sprintf("Idempotent: the second plan changes none of %d resources", len(second.Actions)))
+		return idempotenceExitClean
+	}
+	logger.Error(fmt.Sprintf("Not idempotent: the second plan still changes %d resources", changes))
+	renderPlan(logger, second, hosts, true)
+	return idempotenceExitChanges
+}
+
+func init() {
+	rootCmd.AddCommand(checkIdempotenceCmd)
+}
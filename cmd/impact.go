@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+var (
+	forbidDisruptive  bool
+	approveDisruptive bool
+)
+
+// enforceImpact refuses a plan with disruptive changes when
+// --forbid-disruptive is given, unless --approve-disruptive approves them
+func enforceImpact(logger *inventory.Logger, plan *core.Plan) error {
+	if !forbidDisruptive {
+		return nil
+	}
+	disruptive := core.DisruptiveActions(plan)
+	if len(disruptive) == 0 {
+		return nil
+	}
+
+	for _, action := range disruptive {
+		_, reason := core.ActionImpact(plan, action)
+		if approveDisruptive {
+			logger.Warning(fmt.Sprintf("%s %s: %s (approved)", action.Type, action.ResourceID, reason))
+		} else {
+			logger.Error(fmt.Sprintf("%s %s: %s", action.Type, action.ResourceID, reason))
+		}
+	}
+	if approveDisruptive {
+		return nil
+	}
+	return fmt.Errorf("plan has %d disruptive changes; nothing was applied (review them, then pass --approve-disruptive to apply them)", len(disruptive))
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+var importForce bool
+
+var importCmd = &cobra.Command{
+	Use:   "import <resource-id> <host>",
+	Short: "adopt an existing resource on a host into state",
+	Long: `Import reads the real state of a declared resource on a host and records
+it in the state file, so already-configured servers can be brought under
+management without reinstalling anything.
+
+Example:
+  settlectl import package:apt:nginx web-1`,
+	Args: cobra.ExactArgs(2),
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 1 {
+			return completeHostNames(cmd, nil, toComplete)
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := inventory.NewLogger()
+		resourceID := core.ResourceID(args[0])
+		hostName := args[1]
+		logger.Info(fmt.Sprintf("Importing %s from %s", resourceID, hostName))
+
+		proj, err := loadProject(logger)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		resource, exists := proj.graph.GetResource(resourceID)
+		if !exists {
+			logger.Error(fmt.Sprintf("Resource %s is not declared in any resource file", resourceID))
+			return
+		}
+
+		var ctx *inventory.Context
+		for i := range proj.hosts {
+			if proj.hosts[i].Name == hostName {
+				ctx = &inventory.Context{Host: &proj.hosts[i], Logger: logger}
+				break
+			}
+		}
+		if ctx == nil {
+			logger.Error(fmt.Sprintf("Host %s not found in hosts.stl", hostName))
+			return
+		}
+
+		stateManager, err := openState(proj.graph, logger)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error opening state: %v", err))
+			return
+		}
+		if err := stateManager.Lock(lockOwner()); err != nil {
+			logger.Error(fmt.Sprintf("Error locking state: %v", err))
+			return
+		}
+		defer stateManager.Unlock()
+
+		if err := stateManager.LoadState(); err != nil {
+			logger.Error(fmt.Sprintf("Error loading state: %v", err))
+			return
+		}
+
+		if stateManager.GetState(resourceID) != nil && !importForce {
+			logger.Error(fmt.Sprintf("Resource %s is already in state (use --force to overwrite)", resourceID))
+			return
+		}
+
+		observed, err := resource.Read(ctx)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error reading %s: %v", resourceID, err))
+			return
+		}
+		if observed == nil {
+			logger.Error(fmt.Sprintf("Resource %s does not exist on %s", resourceID, hostName))
+			return
+		}
+
+		if err := stateManager.MarkImported(resource, observed); err != nil {
+			logger.Error(fmt.Sprintf("Error saving state: %v", err))
+			return
+		}
+
+		logger.Success(fmt.Sprintf("Imported %s", resourceID))
+		for key, value := range core.MaskConfig(resource, observed) {
+			logger.Info(fmt.Sprintf("  %s: %v", key, value))
+		}
+		if changes := core.DiffConfig(resource.GetConfig(), observed); len(changes) > 0 {
+			logger.Warning("Observed state differs from configuration; the next plan will update it")
+		}
+	},
+}
+
+func init() {
+	importCmd.Flags().BoolVarP(&importForce, "force", "f", false, "Overwrite an existing state entry")
+	rootCmd.AddCommand(importCmd)
+}
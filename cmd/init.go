@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	initInteractive bool
+	initBackend     string
+	initHost        string
+	initHostname    string
+	initUser        string
+	initKeyfile     string
+)
+
+// initResources is the example resource file of a new project
+const initResources = `# Resources Settle manages. Run settlectl plan to see what would change and
+# settlectl create to apply it.
+
+file "/tmp/settle-hello.txt" {
+  content = "Hello from Settle\n"
+  mode    = "0644"
+}
+`
+
+var initCmd = &cobra.Command{
+	Use:          "init [directory]",
+	Short:        "Create a new project with an inventory, an example resource and settings",
+	SilenceUsage: true,
+	Long: `Init lays out a project in the directory, the current one by default:
+
+  .settle/     state, locks and caches
+  hosts.stl    the inventory, with one host
+  main.stl     an example resource
+  settle.stl   project settings with the state backend
+
+The host is the machine running Settle unless --hostname is given, so plan
+and create work right away. Files that already exist are left unchanged.
+--interactive asks for each setting instead.
+
+Example:
+  settlectl init infra --host web1 --hostname 10.0.0.5 --user deploy`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := inventory.NewLogger()
+
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		if initInteractive {
+			if !isInteractive() {
+				return fmt.Errorf("--interactive needs a terminal")
+			}
+			askInitSettings()
+		}
+		if initBackend != common.StateBackendLocal {
+			return fmt.Errorf("unsupported state backend %q (expected %s)", initBackend, common.StateBackendLocal)
+		}
+
+		host := common.Host{Name: initHost, Hostname: initHostname, User: initUser, Keyfile: initKeyfile}
+		if host.Hostname == "" {
+			host.Transport = common.TransportLocal
+		}
+		var hosts strings.Builder
+		if err := writeHosts(&hosts, []common.Host{host}); err != nil {
+			return err
+		}
+
+		config := fmt.Sprintf("# Project settings, see the README for every block\n\nstate {\n  backend = %q\n  path    = \".settle\"\n}\n", initBackend)
+		files := []struct {
+			name, content string
+		}{
+			{"hosts.stl", hosts.String()},
+			{"main.stl", initResources},
+			{configFile, config},
+		}
+
+		if err := os.MkdirAll(filepath.Join(dir, ".settle"), 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Join(dir, ".settle"), err)
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.name)
+			if _, err := os.Stat(path); err == nil {
+				logger.Warning(fmt.Sprintf("%s exists, left unchanged", path))
+				continue
+			}
+			content, err := parser.Format(path, []byte(file.content))
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			logger.Success(fmt.Sprintf("Created %s", path))
+		}
+
+		logger.Info("Next: settlectl ping, then settlectl plan")
+		return nil
+	},
+}
+
+// askInitSettings prompts for each setting of init, keeping the flag value
+// when the answer is empty
+func askInitSettings() {
+	initHost = ask("Name of the first host", initHost)
+	initHostname = ask("Address of the host (empty for this machine)", initHostname)
+	if initHostname != "" {
+		initUser = ask("SSH user", initUser)
+		initKeyfile = ask("SSH private key (empty for ~/.ssh/id_*)", initKeyfile)
+	}
+	initBackend = ask("State backend", initBackend)
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().BoolVarP(&initInteractive, "interactive", "i", false, "Ask for each setting")
+	initCmd.Flags().StringVar(&initBackend, "backend", common.StateBackendLocal, "State backend: "+common.StateBackendLocal)
+	initCmd.Flags().StringVar(&initHost, "host", "local", "Name of the first host")
+	initCmd.Flags().StringVar(&initHostname, "hostname", "", "Address of the first host; empty manages this machine")
+	initCmd.Flags().StringVar(&initUser, "user", "", "SSH user of the first host")
+	initCmd.Flags().StringVar(&initKeyfile, "keyfile", "", "SSH private key of the first host")
+}
@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/dynamic"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/inventory/resolve"
+	"github.com/spf13/cobra"
+)
+
+// hostsFile is the inventory, hosts.stl unless settle.stl sets hosts_file
+var hostsFile = "hosts.stl"
+
+var (
+	inventoryName     string
+	inventoryFilters  []string
+	inventoryPatterns []string
+	inventoryAddress  string
+	inventoryUser     string
+	inventoryKeyfile  string
+)
+
+// loadHosts returns the hosts of hosts.stl, its includes and hosts.d together
+// with those enumerated by the --inventory provider. The files are optional
+// when a provider is set; hosts declared there win over provider hosts of the
+// same name.
+func loadHosts(ctx context.Context) ([]common.Host, error) {
+	files, err := parser.InventoryFiles(hostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory: %w", err)
+	}
+
+	var hosts []common.Host
+	if len(files) > 0 || inventoryName == "" {
+		parsed, err := parser.ParseInventory(hostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing hosts file: %w", err)
+		}
+		hosts = parsed
+	}
+
+	if inventoryName != "" {
+		discovered, err := discoverHosts(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		declared := make(map[string]bool, len(hosts))
+		for _, host := range hosts {
+			declared[host.Name] = true
+		}
+		for _, host := range discovered {
+			if !declared[host.Name] {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) > common.MaxHosts {
+			return nil, fmt.Errorf("inventory returned %d hosts, more than the maximum of %d", len(hosts), common.MaxHosts)
+		}
+	}
+
+	if err := applyAllowLists(hosts); err != nil {
+		return nil, err
+	}
+	applySSHDefaults(hosts)
+	if err := applyResolvers(hosts); err != nil {
+		return nil, err
+	}
+	if err := applyMeshes(ctx, hosts); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+// discoverHosts enumerates the hosts of the --inventory provider
+func discoverHosts(ctx context.Context) ([]common.Host, error) {
+	filters := make(map[string]string)
+	for _, arg := range inventoryFilters {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid inventory filter %q (expected key=value)", arg)
+		}
+		filters[key] = value
+	}
+
+	provider, err := dynamic.New(inventoryName, dynamic.Options{
+		Filters:  filters,
+		Patterns: inventoryPatterns,
+		Address:  inventoryAddress,
+		User:     inventoryUser,
+		Keyfile:  inventoryKeyfile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return provider.Hosts(ctx)
+}
+
+// applyAllowLists restricts each host to the commands of the allow-lists in
+// settle.stl that target it. Hosts no allow-list targets are unrestricted.
+func applyAllowLists(hosts []common.Host) error {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil
+	}
+	allowLists, err := parser.ParseAllowLists(configFile)
+	if err != nil {
+		return fmt.Errorf("error parsing allow-lists from %s: %w", configFile, err)
+	}
+
+	for i := range hosts {
+		for _, allowList := range allowLists {
+			if targets(allowList.Target, &hosts[i]) {
+				hosts[i].AllowedCommands = append(hosts[i].AllowedCommands, allowList.Commands...)
+			}
+		}
+	}
+	return nil
+}
+
+// applyResolvers assigns each host the first resolver in settle.stl that
+// targets it. Hosts no resolver targets use the system resolver.
+func applyResolvers(hosts []common.Host) error {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil
+	}
+	resolvers, err := parser.ParseResolvers(configFile)
+	if err != nil {
+		return fmt.Errorf("error parsing resolvers from %s: %w", configFile, err)
+	}
+
+	for i := range hosts {
+		for j := range resolvers {
+			if targets(resolvers[j].Target, &hosts[i]) {
+				hosts[i].Resolver = &resolvers[j]
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// applyMeshes sets the mesh address of hosts a mesh block in settle.stl
+// targets, unless hosts.stl gives one. Hosts the mesh does not know keep
+// connecting to their hostname.
+func applyMeshes(ctx context.Context, hosts []common.Host) error {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil
+	}
+	meshes, err := parser.ParseMeshes(configFile)
+	if err != nil {
+		return fmt.Errorf("error parsing meshes from %s: %w", configFile, err)
+	}
+
+	for _, mesh := range meshes {
+		var targeted []*common.Host
+		for i := range hosts {
+			if hosts[i].MeshAddress == "" && targets(mesh.Target, &hosts[i]) {
+				targeted = append(targeted, &hosts[i])
+			}
+		}
+		if len(targeted) == 0 {
+			continue
+		}
+
+		addresses, err := resolve.TailscaleAddresses(ctx, mesh.Tailnet)
+		if err != nil {
+			return fmt.Errorf("mesh %s: %w", mesh.Name, err)
+		}
+		for _, host := range targeted {
+			address, ok := addresses[resolve.ShortName(host.Name)]
+			if !ok && host.Hostname != "" {
+				address = addresses[resolve.ShortName(host.Hostname)]
+			}
+			host.MeshAddress = address
+		}
+	}
+	return nil
+}
+
+// targets reports whether target selects host; an empty target selects all
+func targets(target common.Target, host *common.Host) bool {
+	if len(target.Hosts) > 0 && !slices.ContainsFunc(target.Hosts, func(name string) bool {
+		return name == host.Name || slices.Contains(host.Aliases, name)
+	}) {
+		return false
+	}
+	return target.Group == "" || target.Group == host.Group
+}
+
+var inventoryCmd = &cobra.Command{
+	Use:          "inventory",
+	Short:        "Print the inventory in hosts.stl syntax",
+	SilenceUsage: true,
+	Long: `Print every host Settle would target, from hosts.stl and --inventory, in
+hosts.stl syntax. Redirect the output to adopt an existing fleet:
+
+  settlectl inventory --inventory ssh-config --inventory-pattern 'web-*' > hosts.stl`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hosts, err := loadHosts(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return writeHosts(cmd.OutOrStdout(), hosts)
+	},
+}
+
+// writeHosts writes hosts as hosts.stl blocks in canonical format
+func writeHosts(w io.Writer, hosts []common.Host) error {
+	var buf bytes.Buffer
+	for i, host := range hosts {
+		if i > 0 {
+			fmt.Fprintln(&buf)
+		}
+		fmt.Fprintf(&buf, "host %q {\n", host.Name)
+		if host.Hostname != "" {
+			fmt.Fprintf(&buf, "hostname = %q\n", host.Hostname)
+		}
+		if host.User != "" {
+			fmt.Fprintf(&buf, "user = %q\n", host.User)
+		}
+		if host.Port != 0 {
+			fmt.Fprintf(&buf, "port = %d\n", host.Port)
+		}
+		if host.Keyfile != "" {
+			fmt.Fprintf(&buf, "keyfile = %q\n", host.Keyfile)
+		}
+		if host.Group != "" {
+			fmt.Fprintf(&buf, "group = %q\n", host.Group)
+		}
+		if host.MeshAddress != "" {
+			fmt.Fprintf(&buf, "mesh_address = %q\n", host.MeshAddress)
+		}
+		if host.Transport != "" {
+			fmt.Fprintf(&buf, "transport = %q\n", host.Transport)
+		}
+		if len(host.Aliases) > 0 {
+			quoted := make([]string, len(host.Aliases))
+			for i, alias := range host.Aliases {
+				quoted[i] = strconv.Quote(alias)
+			}
+			fmt.Fprintf(&buf, "aliases = [%s]\n", strings.Join(quoted, ", "))
+		}
+		if len(host.Vars) > 0 {
+			names := make([]string, 0, len(host.Vars))
+			for name := range host.Vars {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(&buf, "vars {")
+			for _, name := range names {
+				fmt.Fprintf(&buf, "%s = %q\n", name, host.Vars[name])
+			}
+			fmt.Fprintln(&buf, "}")
+		}
+		fmt.Fprintln(&buf, "}")
+	}
+
+	formatted, err := parser.Format("", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(formatted)
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+	rootCmd.PersistentFlags().StringVar(&inventoryName, "inventory", os.Getenv("SETTLE_INVENTORY"), fmt.Sprintf("Also enumerate hosts from a provider: %s (env SETTLE_INVENTORY)", strings.Join(dynamic.Names(), ", ")))
+	rootCmd.PersistentFlags().StringSliceVar(&inventoryFilters, "inventory-filter", nil, "Only include instances with this tag or label, e.g. --inventory-filter env=prod")
+	rootCmd.PersistentFlags().StringSliceVar(&inventoryPatterns, "inventory-pattern", nil, "Only include provider hosts whose name matches this pattern, e.g. --inventory-pattern 'web-*'")
+	rootCmd.PersistentFlags().StringVar(&inventoryAddress, "inventory-address", "public", "Address to connect to for provider hosts: public or private")
+	rootCmd.PersistentFlags().StringVar(&inventoryUser, "inventory-user", "", "SSH user for provider hosts without an ssh-user tag")
+	rootCmd.PersistentFlags().StringVar(&inventoryKeyfile, "inventory-keyfile", "", "SSH private key for provider hosts")
+}
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+var overrideLimits bool
+
+// limitViolations returns the limits in settle.stl the plan exceeds
+func limitViolations(plan *core.Plan, hosts []common.Host) ([]core.LimitViolation, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, nil
+	}
+	limits, err := parser.ParseLimits(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing limits from %s: %w", configFile, err)
+	}
+	return core.CheckLimits(plan, core.HostMap(hosts), limits), nil
+}
+
+// enforceLimits refuses a plan that exceeds a limit in settle.stl unless
+// --override-limits is given
+func enforceLimits(logger *inventory.Logger, plan *core.Plan, hosts []common.Host) error {
+	violations, err := limitViolations(plan, hosts)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	for _, violation := range violations {
+		if overrideLimits {
+			logger.Warning(violation.String() + " (overridden)")
+		} else {
+			logger.Error(violation.String())
+		}
+	}
+	if overrideLimits {
+		return nil
+	}
+	return fmt.Errorf("plan exceeds %d limits; nothing was applied (review the plan, then pass --override-limits to apply it anyway)", len(violations))
+}
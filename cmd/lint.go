@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the lint command
+const (
+	lintExitClean    = 0
+	lintExitError    = 1
+	lintExitFindings = 2
+)
+
+var lintDisable []string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "check the project for hygiene issues",
+	Long: `Lint checks the project for issues worth fixing before review. Nothing is
+read from hosts and secrets are not resolved.
+
+Rules:
+` + lintRuleHelp() + `
+Rules are disabled in settle.stl or with --disable:
+
+  lint {
+    disable = ["missing-owner"]
+  }
+
+Exit codes:
+  0  no findings
+  1  an error occurred
+  2  findings reported`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runLint())
+	},
+}
+
+func runLint() int {
+	logger := inventory.NewLogger()
+
+	disabled, err := lintDisabledRules()
+	if err != nil {
+		logger.Error(err.Error())
+		return lintExitError
+	}
+
+	proj, err := loadProjectWith(logger, projectOptions{skipSecrets: true, skipFacts: true})
+	if err != nil {
+		logger.Error(err.Error())
+		return lintExitError
+	}
+
+	modules, _, err := findModules(proj.resourceFiles)
+	if err != nil {
+		logger.Error(err.Error())
+		return lintExitError
+	}
+	unused := make(map[string][]string)
+	for _, module := range modules {
+		names, err := parser.UnusedVariables(module)
+		if err != nil {
+			logger.Error(err.Error())
+			return lintExitError
+		}
+		if len(names) > 0 {
+			unused[module.Name] = names
+		}
+	}
+
+	findings := core.Lint(core.LintInput{
+		Resources:       proj.resources,
+		Hosts:           proj.hosts,
+		UnusedVariables: unused,
+	}, disabled)
+	for _, finding := range findings {
+		logger.Warning(finding.String())
+	}
+	logger.Info(fmt.Sprintf("Lint: %d findings", len(findings)))
+
+	if len(findings) > 0 {
+		return lintExitFindings
+	}
+	return lintExitClean
+}
+
+// lintDisabledRules returns the rules disabled in settle.stl and with
+// --disable; unknown rule names are an error
+func lintDisabledRules() (map[string]bool, error) {
+	names := append([]string(nil), lintDisable...)
+	if _, err := os.Stat(configFile); err == nil {
+		configured, err := parser.ParseLintRules(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing lint rules from %s: %w", configFile, err)
+		}
+		names = append(names, configured...)
+	}
+
+	known := make(map[string]bool)
+	for _, rule := range core.LintRules() {
+		known[rule.Name] = true
+	}
+	disabled := make(map[string]bool)
+	for _, name := range names {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown lint rule: %s", name)
+		}
+		disabled[name] = true
+	}
+	return disabled, nil
+}
+
+func lintRuleHelp() string {
+	var help strings.Builder
+	for _, rule := range core.LintRules() {
+		fmt.Fprintf(&help, "  %-24s %s\n", rule.Name, rule.Description)
+	}
+	return help.String()
+}
+
+func init() {
+	lintCmd.Flags().StringSliceVar(&lintDisable, "disable", nil, "Rules to skip (repeatable)")
+	rootCmd.AddCommand(lintCmd)
+}
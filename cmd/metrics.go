@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// pushMetricsOnFinish measures the run published to bus and pushes its
+// metrics to the gateway of --metrics-push when it finishes. Failing to
+// push is logged, never fatal.
+func pushMetricsOnFinish(bus *core.EventBus, logger *inventory.Logger, command string) {
+	if metricsPush == "" {
+		return
+	}
+	metrics := core.NewMetrics(command, projectName)
+	inventory.ObserveConnects(metrics.ObserveConnect)
+	bus.Subscribe(func(event core.Event) {
+		metrics.HandleEvent(event)
+		if event.Type != core.EventRunFinished {
+			return
+		}
+		if err := metrics.Push(metricsPush); err != nil {
+			logger.Warning(fmt.Sprintf("Error pushing metrics: %v", err))
+			return
+		}
+		logger.Debug("Pushed metrics to " + metricsPush)
+	})
+}
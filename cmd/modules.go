@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+// findModules returns the modules declared in the resource files and the
+// files of their source directories
+func findModules(resourceFiles []string) ([]common.Module, []string, error) {
+	var modules []common.Module
+	var files []string
+	declaredIn := make(map[string]string)
+
+	for _, resourceFile := range resourceFiles {
+		fileModules, err := parser.ParseModules(resourceFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing modules from %s: %w", resourceFile, err)
+		}
+		for _, module := range fileModules {
+			if previous, ok := declaredIn[module.Name]; ok {
+				return nil, nil, fmt.Errorf("module %s is declared in both %s and %s", module.Name, previous, resourceFile)
+			}
+			declaredIn[module.Name] = resourceFile
+
+			moduleFiles, err := parser.ModuleFiles(module)
+			if err != nil {
+				return nil, nil, err
+			}
+			modules = append(modules, module)
+			files = append(files, moduleFiles...)
+		}
+	}
+
+	return modules, files, nil
+}
+
+// loadModules loads every module and returns their resources and their
+// outputs as "module.output" for ${module.name.output} references
+func loadModules(modules []common.Module) (*parser.ModuleContents, error) {
+	all := &parser.ModuleContents{Outputs: make(map[string]string)}
+
+	for _, module := range modules {
+		contents, err := parser.LoadModule(module)
+		if err != nil {
+			return nil, err
+		}
+		all.Packages = append(all.Packages, contents.Packages...)
+		all.Files = append(all.Files, contents.Files...)
+		all.Services = append(all.Services, contents.Services...)
+		all.Units = append(all.Units, contents.Units...)
+		all.Provided = append(all.Provided, contents.Provided...)
+		for name, value := range contents.Outputs {
+			all.Outputs[module.Name+"."+name] = value
+		}
+	}
+
+	return all, nil
+}
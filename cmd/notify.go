@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+// configFile holds project settings such as notifications
+const configFile = "settle.stl"
+
+// notifyOnFinish posts the summary of the plan or run published to bus when
+// it finishes; startedAt is when the command started, which plan durations
+// count from
+func notifyOnFinish(bus *core.EventBus, logger *inventory.Logger, command string, startedAt time.Time) {
+	bus.Subscribe(func(event core.Event) {
+		switch event.Type {
+		case core.EventPlanFinished:
+			notifyRun(logger, core.NewPlanSummary(event.Plan, event.At.Sub(startedAt).Round(time.Millisecond)))
+		case core.EventRunFinished:
+			notifyRun(logger, core.NewExecutionSummary(command, event.Result))
+		}
+	})
+}
+
+// notifyRun posts the summary to every notification in settle.stl that is
+// configured for its command. Failures are logged, never fatal.
+func notifyRun(logger *inventory.Logger, summary core.RunSummary) {
+	if _, err := os.Stat(configFile); err != nil {
+		return
+	}
+
+	notifications, err := parser.ParseNotifications(configFile)
+	if err != nil {
+		logger.Warning(fmt.Sprintf("Error parsing notifications from %s: %v", configFile, err))
+		return
+	}
+
+	summary.Project = projectName
+	for _, notification := range notifications {
+		if err := expandSecrets(&notification.URL); err != nil {
+			logger.Warning(fmt.Sprintf("Notification %s: %v", notification.Name, err))
+			continue
+		}
+		notifier, err := core.NewNotifier(notification)
+		if err != nil {
+			logger.Warning(err.Error())
+			continue
+		}
+		if !notifier.Wants(summary.Command) {
+			continue
+		}
+		if err := notifier.Notify(summary); err != nil {
+			logger.Warning(err.Error())
+			continue
+		}
+		logger.Info(fmt.Sprintf("Sent notification %s", notification.Name))
+	}
+}
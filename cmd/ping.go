@@ -1,74 +1,200 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"sync"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/settlectl/settle-core/common"
-	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/inventory"
 	"github.com/settlectl/settle-core/inventory/ssh"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes of the ping command
+const (
+	pingExitReachable = 0
+	pingExitError     = 1
+)
+
 var (
 	filterHost string
 	filterGroup string
 )
 
 
+var pingJSON bool
+
 var pingCmd = &cobra.Command{
-	Use: "ping",
+	Use:   "ping",
 	Short: "Check ssh connectivity to hosts",
+	Long: `Ping connects to every host, or those selected by --host or --group, all at
+once, and prints a table with the time each took to connect, the version of
+its SSH server and its operating system. Hosts with the local transport run
+the OS check only.
+
+Exit codes:
+  0  every host was reachable
+  1  a host was not reachable, or an error occurred`,
 	Run: func(cmd *cobra.Command, args []string) {
-		hosts, err := parser.ParseHosts("hosts.stl")
-		if err != nil {
-			fmt.Printf("Error parsing hosts file: %v\n", err)
-			return
-		}
+		os.Exit(runPing(cmd.Context(), cmd.OutOrStdout()))
+	},
+}
 
-		if len(hosts) == 0 {
-			fmt.Println("No hosts found")
-			return
-		}
+// pingResult is the outcome of pinging one host
+type pingResult struct {
+	Host      string        `json:"host"`
+	Reachable bool          `json:"reachable"`
+	Latency   time.Duration `json:"-"`
+	LatencyMS float64       `json:"latency_ms"`
+	Banner    string        `json:"banner,omitempty"` // SSH server version, e.g. SSH-2.0-OpenSSH_9.6
+	OS        string        `json:"os,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
 
-		successCount := 0
-		failureCount := 0
+// pingOSCommand prints the name and version of the OS, or the kernel when
+// the host has no os-release file
+const pingOSCommand = `if [ -r /etc/os-release ]; then . /etc/os-release; echo "$PRETTY_NAME"; else uname -sr; fi`
 
-		var wg sync.WaitGroup
+func runPing(ctx context.Context, out io.Writer) int {
+	hosts, err := loadHosts(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading hosts: %v\n", err)
+		return pingExitError
+	}
 
+	var selected []common.Host
+	for _, host := range hosts {
+		if filterHost != "" && host.Name != filterHost {
+			continue
+		}
+		if filterGroup != "" && host.Group != filterGroup {
+			continue
+		}
+		selected = append(selected, host)
+	}
+	if len(selected) == 0 {
+		fmt.Fprintln(os.Stderr, "No hosts found")
+		return pingExitError
+	}
 
-		for _, host := range hosts {
-			if filterHost != "" && host.Name != filterHost {
-				continue
-			}
+	// Explicit pings always connect, but refresh the cache for later commands
+	cache := reachabilityCache()
+	type indexed struct {
+		index  int
+		result pingResult
+	}
+	results := make(chan indexed)
+	sem := make(chan struct{}, ssh.MaxConnections)
+	for i := range selected {
+		go func(i int, host common.Host) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results <- indexed{i, pingHost(ctx, &host)}
+		}(i, selected[i])
+	}
 
-			if filterGroup != "" && host.Group != filterGroup {
-				continue
+	// Results arrive as hosts answer and are printed in inventory order
+	ordered := make([]pingResult, len(selected))
+	failed := 0
+	for range selected {
+		received := <-results
+		ordered[received.index] = received.result
+		if !received.result.Reachable {
+			failed++
+		}
+		if cache != nil {
+			if received.result.Reachable {
+				cache.Record(&selected[received.index])
+			} else {
+				cache.Forget(&selected[received.index])
 			}
+		}
+	}
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving reachability cache: %v\n", err)
+		}
+	}
 
-			wg.Add(1)
-			go func(h *common.Host) {
-				defer wg.Done()
-				err := ssh.PingHost(h)
-				if err != nil {
-					fmt.Printf("Failed to ping %s: %v\n", host.Name, err)
-					failureCount++
-				} else {
-					fmt.Printf("Successfully pinged %s\n", host.Name)
-					successCount++
-				}
-			}(&host)
+	if pingJSON {
+		if err := printJSON(out, ordered); err != nil {
+			fmt.Fprintf(os.Stderr, "Error printing results: %v\n", err)
+			return pingExitError
 		}
-		wg.Wait()
+	} else {
+		printPingResults(out, ordered)
+		fmt.Fprintf(out, "\n%d reachable, %d failed\n", len(ordered)-failed, failed)
+	}
+	if failed > 0 {
+		return pingExitError
+	}
+	return pingExitReachable
+}
 
-		fmt.Printf("Ping results:\n")
-		fmt.Printf("Success: %d\n", successCount)
-		fmt.Printf("Failure: %d\n", failureCount)
-	},
+// pingHost connects to host and asks for its OS. Failing to read the OS does
+// not make a reachable host fail.
+func pingHost(ctx context.Context, host *common.Host) pingResult {
+	result := pingResult{Host: host.Name, Banner: "local"}
+
+	var transport inventory.Transport
+	if host.Transport == common.TransportLocal {
+		transport = inventory.NewLocalTransport(host)
+	} else {
+		started := time.Now()
+		client, err := ssh.NewSSHClient(host)
+		result.Latency = time.Since(started)
+		result.LatencyMS = float64(result.Latency.Microseconds()) / 1000
+		if err != nil {
+			result.Banner = ""
+			result.Error = err.Error()
+			return result
+		}
+		result.Banner = string(client.Client.ServerVersion())
+		transport = inventory.NewSSHTransport(client)
+	}
+	defer transport.Close()
+	result.Reachable = true
+
+	if output, err := transport.RunCommand(ctx, pingOSCommand); err == nil {
+		result.OS = strings.TrimSpace(output)
+	}
+	return result
+}
+
+// printPingResults prints a row per host, with the error of the failed ones
+func printPingResults(out io.Writer, results []pingResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tLATENCY\tSSH\tOS")
+	for _, result := range results {
+		status, latency := "ok", "-"
+		if !result.Reachable {
+			status = "failed"
+		}
+		if rounded := result.Latency.Round(time.Millisecond); rounded > 0 {
+			latency = rounded.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", result.Host, status, latency, valueOrDash(result.Banner), valueOrDash(result.OS))
+	}
+	w.Flush()
+
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(out, "%s: %s\n", result.Host, result.Error)
+		}
+	}
 }
 
 func init() {
 
 	pingCmd.Flags().StringVarP(&filterHost, "host", "H", "", "Filter hosts by name")
 	pingCmd.Flags().StringVarP(&filterGroup, "group", "G", "", "Filter hosts by group")
+	pingCmd.Flags().BoolVar(&pingJSON, "json", false, "Print the results as JSON")
+	pingCmd.RegisterFlagCompletionFunc("host", completeHostNames)
+	pingCmd.RegisterFlagCompletionFunc("group", completeGroups)
 	rootCmd.AddCommand(pingCmd)
 }
\ No newline at end of file
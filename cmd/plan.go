@@ -1,183 +1,151 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
+	"time"
 
-	"github.com/settlectl/settle-core/common"
 	"github.com/settlectl/settle-core/core"
 	"github.com/settlectl/settle-core/inventory"
-	"github.com/settlectl/settle-core/inventory/parser"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
-	planOutput string
+	planOutput       string
+	planStateVersion int
+	planDetails      bool
 )
 
 var planCmd = &cobra.Command{
 	Use:   "plan",
 	Short: "show what would be executed",
+	Long: `Plan compares the configuration with the state and shows the actions
+create would take.
+
+Every command that changes the state records a numbered version of it. With
+--state-version the plan is made against that version instead of the current
+state, e.g. to see what a past run changed or to reproduce an incident.
+
+With --detailed-exitcode plan exits 0 when there is nothing to change, 2
+when there are changes and 1 on errors.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Every return before the end is an error
+		setExitCode(exitError)
 		logger := inventory.NewLogger()
 		logger.Info("Creating execution plan")
+		startedAt := time.Now()
 
-		hosts, err := parser.ParseHosts("hosts.stl")
+		proj, err := loadProjectWith(logger, projectOptions{preflight: true})
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error parsing hosts file: %v", err))
+			logger.Error(err.Error())
 			return
 		}
-		logger.Info(fmt.Sprintf("Found %d hosts", len(hosts)))
+		hosts, resources, graph := proj.hosts, proj.resources, proj.graph
+		logger.Info(fmt.Sprintf("Created %d resources", len(resources)))
+		proj.logPermissionWarnings(logger)
 
-		resourceFiles, err := findResourceFiles()
+		stateManager, err := openState(graph, logger)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error finding resource files: %v", err))
+			logger.Error(fmt.Sprintf("Error opening state: %v", err))
 			return
 		}
-
-		resourceParser := core.NewResourceParser()
-		resourceParser.SetHosts(hosts)
-
-		var allPackages []common.Package
-		for _, file := range resourceFiles {
-			packages, err := parser.ParsePackages(file)
+		if planStateVersion > 0 {
+			version, err := stateManager.LoadVersion(planStateVersion)
 			if err != nil {
-				logger.Error(fmt.Sprintf("Error parsing packages from %s: %v", file, err))
-				continue
+				logger.Error(fmt.Sprintf("Error loading state: %v", err))
+				return
 			}
-			allPackages = append(allPackages, packages...)
+			logger.Info(fmt.Sprintf("Planning against state version %d, saved at %s", version.Version, version.SavedAt.Format("2006-01-02 15:04:05")))
+		} else if err := stateManager.LoadState(); err != nil {
+			logger.Error(fmt.Sprintf("Error loading state: %v", err))
+			return
 		}
-		resourceParser.SetPackages(allPackages)
 
-		resources, err := resourceParser.ParseResources()
+		planner := core.NewPlanner(graph, stateManager, logger)
+		planner.SetHosts(hosts)
+		planner.SetProviders(proj.providers)
+		planner.SetSelector(labelSelector)
+		// plan does not take the state lock, so it never writes the state
+		planner.SetReadOnly(true)
+		stateHash := stateManager.Hash()
+		events := core.NewEventBus()
+		notifyOnFinish(events, logger, "plan", startedAt)
+		planner.SetEvents(events)
+		plan, err := planner.Plan()
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating resources: %v", err))
+			logger.Error(fmt.Sprintf("Error creating plan: %v", err))
 			return
 		}
-		logger.Info(fmt.Sprintf("Created %d resources", len(resources)))
+		plan.ConfigHash = proj.snapshot.Hash()
+		plan.StateHash = stateHash
 
-		graph := core.NewGraph()
-		for _, resource := range resources {
-			if err := graph.AddResource(resource); err != nil {
-				logger.Error(fmt.Sprintf("Error adding resource %s to graph: %v", resource.GetID(), err))
-				continue
-			}
-		}
+		logger.Info(inventory.Message(inventory.MsgPlanTitle))
+		logger.Info(inventory.Message(inventory.MsgPlanCreatedAt, plan.CreatedAt.Format("2006-01-02 15:04:05")))
+		logger.Printf("")
 
-		if err := graph.ValidateDependencies(); err != nil {
-			logger.Error(fmt.Sprintf("Graph validation failed: %v", err))
+		violations, err := limitViolations(plan, hosts)
+		if err != nil {
+			logger.Error(err.Error())
 			return
 		}
-
-		stateManager := core.NewStateManager(".settle/state.json", graph)
-		if err := stateManager.LoadState(); err != nil {
-			logger.Error(fmt.Sprintf("Error loading state: %v", err))
-			return
+		for _, violation := range violations {
+			logger.Warning(violation.String() + "; create will refuse this plan without --override-limits")
 		}
-
-		planner := core.NewPlanner(graph, stateManager, logger)
-		plan, err := planner.Plan()
+		policyResults, err := policyViolations(cmd.Context(), plan, hosts)
 		if err != nil {
-			logger.Error(fmt.Sprintf("Error creating plan: %v", err))
+			logger.Error(err.Error())
 			return
 		}
+		logPolicyViolations(logger, policyResults)
 
-		logger.Info("=== EXECUTION PLAN ===")
-		logger.Info(fmt.Sprintf("Plan created at: %s", plan.CreatedAt.Format("2006-01-02 15:04:05")))
-		logger.Info("")
-
-		logger.Info("Summary:")
-		logger.Info(fmt.Sprintf("  Create: %d resources", plan.GetActionCount(core.ActionCreate)))
-		logger.Info(fmt.Sprintf("  Update: %d resources", plan.GetActionCount(core.ActionUpdate)))
-		logger.Info(fmt.Sprintf("  Delete: %d resources", plan.GetActionCount(core.ActionDelete)))
-		logger.Info(fmt.Sprintf("  No-op: %d resources", plan.GetActionCount(core.ActionNoOp)))
-		logger.Info("")
-
-		if len(plan.Actions) > 0 {
-			logger.Info("Detailed Actions:")
-			for i, action := range plan.Actions {
-				logger.Info(fmt.Sprintf("  %d. %s (%s)", i+1, action.ResourceID, action.Type))
-				if reason, ok := action.Metadata["reason"]; ok {
-					logger.Info(fmt.Sprintf("      Reason: %s", reason))
-				}
-
-				resource, exists := graph.GetResource(action.ResourceID)
-				if exists {
-					config := resource.GetConfig()
-					logger.Info(fmt.Sprintf("      Type: %s", resource.GetType()))
-					logger.Info(fmt.Sprintf("      Layer: %s", resource.GetLayer().String()))
-
-					if len(config) > 0 {
-						logger.Info("      Configuration:")
-						for key, value := range config {
-							logger.Info(fmt.Sprintf("        %s: %v", key, value))
-						}
-					}
-				}
-				logger.Info("")
-			}
+		if len(plan.Actions) > plan.GetActionCount(core.ActionNoOp) {
+			renderPlan(logger, plan, hosts, planDetails)
 		} else {
-			logger.Info("No changes needed. All resources are up to date.")
+			logger.Info(inventory.Message(inventory.MsgPlanNoChanges))
 		}
 
-		logger.Info("")
-		logger.Info("To apply this plan, run: settlectl create")
+		if core.Denied(policyResults) {
+			logger.Error("The plan is denied by policy; create and apply will refuse it")
+			return
+		}
 
-		if planOutput != "" {
-			if err := savePlanToFile(plan, planOutput); err != nil {
+		logger.Printf("")
+		if planOutput == "" {
+			logger.Info(inventory.Message(inventory.MsgPlanApplyHint))
+		} else {
+			planFile := core.NewPlanFile(plan)
+			planFile.Limit = hostLimit
+			if len(proj.leftOut) > 0 {
+				// Apply the plan to the hosts it was made for only
+				planFile.Limit = nil
+				for _, host := range hosts {
+					planFile.Limit = append(planFile.Limit, host.Name)
+				}
+			}
+			if err := planFile.Write(planOutput, planKey()); err != nil {
 				logger.Error(fmt.Sprintf("Error saving plan to file: %v", err))
 				return
 			}
-			logger.Info(fmt.Sprintf("Plan saved to: %s", planOutput))
+			logger.Info(inventory.Message(inventory.MsgPlanSaved, planOutput))
+			logger.Info(inventory.Message(inventory.MsgPlanApplySaved, planOutput))
 		}
+		setExitCode(changesExitCode(len(plan.Actions) - plan.GetActionCount(core.ActionNoOp)))
 	},
 }
 
-func savePlanToFile(plan *core.Plan, filename string) error {
-	planOutput := struct {
-		CreatedAt string                 `json:"created_at"`
-		Summary   map[string]int         `json:"summary"`
-		Actions   []*core.Action         `json:"actions"`
-		Resources map[string]interface{} `json:"resources"`
-	}{
-		CreatedAt: plan.CreatedAt.Format("2006-01-02 15:04:05"),
-		Summary: map[string]int{
-			"create": plan.GetActionCount(core.ActionCreate),
-			"update": plan.GetActionCount(core.ActionUpdate),
-			"delete": plan.GetActionCount(core.ActionDelete),
-			"no_op":  plan.GetActionCount(core.ActionNoOp),
-		},
-		Actions:   plan.Actions,
-		Resources: make(map[string]interface{}),
-	}
-
-	for _, action := range plan.Actions {
-		resource, exists := plan.Graph.GetResource(action.ResourceID)
-		if exists {
-			planOutput.Resources[string(action.ResourceID)] = map[string]interface{}{
-				"type":   resource.GetType(),
-				"layer":  resource.GetLayer().String(),
-				"config": resource.GetConfig(),
-				"action": action.Type,
-				"reason": action.Metadata["reason"],
-			}
-		}
-	}
-
-	data, err := json.MarshalIndent(planOutput, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal plan: %w", err)
-	}
-
-	if err := os.WriteFile(filename, data, 0644); err != nil {
-		return fmt.Errorf("failed to write plan file: %w", err)
-	}
-
-	return nil
-}
-
 func init() {
-	planCmd.Flags().StringVarP(&planOutput, "output", "o", "", "Output plan to file")
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", "", "Save the plan to this file for apply (also --out)")
+	// --out is the name other tools use for saved plans
+	planCmd.Flags().SetNormalizeFunc(func(flags *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "out" {
+			name = "output"
+		}
+		return pflag.NormalizedName(name)
+	})
+	planCmd.Flags().BoolVar(&planDetails, "details", false, "Show the labels and configuration of each resource")
+	planCmd.Flags().BoolVar(&detailedExitCode, "detailed-exitcode", false, "Exit 0 without changes, 2 with changes and 1 on errors")
+	planCmd.Flags().IntVar(&planStateVersion, "state-version", 0, "Plan against this recorded version of the state instead of the current one")
+	addLimitFlag(planCmd)
+	addUnreachableFlag(planCmd)
 	rootCmd.AddCommand(planCmd)
 }
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+// policyViolations returns how the plan breaks the policies in settle.stl
+func policyViolations(ctx context.Context, plan *core.Plan, hosts []common.Host) ([]core.PolicyViolation, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, nil
+	}
+	policies, err := parser.ParsePolicies(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing policies from %s: %w", configFile, err)
+	}
+	return core.EvaluatePolicies(ctx, plan, core.HostMap(hosts), policies, projectName)
+}
+
+// enforcePolicies logs the policy violations of the plan and refuses it if
+// any policy denies it
+func enforcePolicies(ctx context.Context, logger *inventory.Logger, plan *core.Plan, hosts []common.Host) error {
+	violations, err := policyViolations(ctx, plan, hosts)
+	if err != nil {
+		return err
+	}
+	logPolicyViolations(logger, violations)
+	if core.Denied(violations) {
+		return fmt.Errorf("plan denied by policy; nothing was applied")
+	}
+	return nil
+}
+
+func logPolicyViolations(logger *inventory.Logger, violations []core.PolicyViolation) {
+	for _, violation := range violations {
+		if violation.Effect == common.PolicyDeny {
+			logger.Error(violation.String())
+		} else {
+			logger.Warning(violation.String())
+		}
+	}
+}
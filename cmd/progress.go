@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// noProgress turns the live status of create and clean off
+var noProgress bool
+
+// startProgress shows the live status of each host while executor runs plan,
+// when the log goes to a terminal as text. The logger writes through the
+// status lines until the returned function stops them; elsewhere, e.g. in CI
+// logs, the run is only logged line by line.
+func startProgress(executor *core.Executor, logger *inventory.Logger, plan *core.Plan, hosts []common.Host) func() {
+	if noProgress || inventory.Plain() || logFormat == common.LogFormatJSON || reportToStdout() || !isTerminal(os.Stdout) {
+		return func() {}
+	}
+	progress := core.NewProgress(os.Stdout, plan, hosts)
+	unsubscribe := executor.Events().Subscribe(progress.HandleEvent)
+	logger.SetOutput(progress)
+	progress.Start()
+	return func() {
+		unsubscribe()
+		progress.Stop()
+		logger.SetOutput(os.Stdout)
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", os.Getenv("SETTLE_NO_PROGRESS") == "1", "Log create and clean line by line instead of showing the live status of each host (env SETTLE_NO_PROGRESS=1)")
+}
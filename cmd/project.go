@@ -0,0 +1,457 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/secrets"
+)
+
+// project holds the parsed inventory and resource graph of the working directory
+type project struct {
+	hosts         []common.Host
+	allHosts      []common.Host // Every host of the inventory, before --limit and the pre-flight
+	resourceFiles []string
+	resources     []core.Resource
+	graph         *core.Graph
+	providers     []*core.Provider
+	snapshot      core.ConfigSnapshot // Checksums of the files the project was loaded from
+	leftOut       []string            // Hosts the pre-flight left out of the run
+}
+
+// projectOptions change how a project is loaded
+type projectOptions struct {
+	skipSecrets bool // leave ${secret.name} references unresolved, e.g. for lint
+	skipFacts   bool // do not connect to hosts to gather the facts when conditions read
+	preflight   bool // check the targeted hosts are reachable before planning, see preflight
+}
+
+// loadProject loads the inventory, parses all resource files and builds the graph
+func loadProject(logger *inventory.Logger) (*project, error) {
+	return loadProjectWith(logger, projectOptions{})
+}
+
+// loadProjectWith loads the project like loadProject with options
+func loadProjectWith(logger *inventory.Logger, options projectOptions) (*project, error) {
+	resourceFiles, err := findResourceFiles()
+	if err != nil {
+		return nil, fmt.Errorf("error finding resource files: %w", err)
+	}
+
+	modules, moduleFiles, err := findModules(resourceFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// Snapshot before parsing so edits made while the run is in progress
+	// are detected before anything is applied
+	inventoryFiles, err := parser.InventoryFiles(hostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading inventory: %w", err)
+	}
+	configFiles := append(inventoryFiles, resourceFiles...)
+	configFiles = append(configFiles, moduleFiles...)
+	if _, err := os.Stat(configFile); err == nil {
+		configFiles = append(configFiles, configFile)
+	}
+	snapshot, err := core.TakeConfigSnapshot(configFiles)
+	if err != nil {
+		return nil, fmt.Errorf("error reading configuration: %w", err)
+	}
+
+	// Provider resource types must be known before the files are checked
+	providers, err := loadProviders()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUnknownAttributes(logger, projectSchemaFiles(inventoryFiles, resourceFiles, moduleFiles)); err != nil {
+		return nil, err
+	}
+
+	hosts, err := loadHosts(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	logger.Info(fmt.Sprintf("Found %d hosts", len(hosts)))
+	// Resources are parsed against the whole inventory and only then
+	// pruned to the hosts of --limit
+	allHosts := hosts
+	if hosts, err = limitHosts(logger, hosts); err != nil {
+		return nil, err
+	}
+
+	resourceParser := core.NewResourceParser()
+	resourceParser.SetHosts(allHosts)
+
+	moduleContents, err := loadModules(modules)
+	if err != nil {
+		return nil, err
+	}
+	allPackages, allFiles, allServices, allUnits := moduleContents.Packages, moduleContents.Files, moduleContents.Services, moduleContents.Units
+	allProvided := moduleContents.Provided
+	outputs := moduleContents.Outputs
+	variables, err := projectVariables(resourceFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range resourceFiles {
+		// ${module.name.output} references are resolved before parsing
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file, err)
+		}
+		if len(data) > common.MaxFileSize {
+			return nil, fmt.Errorf("%s is too large: %d bytes (max: %d)", file, len(data), common.MaxFileSize)
+		}
+		text, err := parser.Interpolate(string(data), "module", outputs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		blocks, err := parser.ParseSource(file, strings.NewReader(text))
+		if err != nil {
+			return nil, err
+		}
+		blocks, err = parser.ExpandBlocks(blocks, variables)
+		if err != nil {
+			return nil, err
+		}
+
+		packages, err := parser.PackagesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing packages from %s: %w", file, err)
+		}
+		allPackages = append(allPackages, packages...)
+
+		files, err := parser.FilesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing files from %s: %w", file, err)
+		}
+		allFiles = append(allFiles, files...)
+
+		services, err := parser.ServicesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing services from %s: %w", file, err)
+		}
+		allServices = append(allServices, services...)
+
+		units, err := parser.SystemdUnitsFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing systemd units from %s: %w", file, err)
+		}
+		allUnits = append(allUnits, units...)
+
+		provided, err := parser.ProviderResourcesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing provider resources from %s: %w", file, err)
+		}
+		allProvided = append(allProvided, provided...)
+	}
+
+	if _, err := os.Stat(configFile); err == nil {
+		defaults, err := parser.ParseDefaults(configFile)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing defaults from %s: %w", configFile, err)
+		}
+		for i := range allFiles {
+			defaults.ApplyToFile(&allFiles[i])
+		}
+	}
+
+	// Resolve ${secret.name} references only after parsing, so plaintext
+	// never reaches the parsers' error messages. Fields holding secrets are
+	// sensitive.
+	for i := range allPackages {
+		if secrets.HasReferences(allPackages[i].Version) {
+			allPackages[i].Sensitive = append(allPackages[i].Sensitive, "version")
+		}
+		if options.skipSecrets {
+			continue
+		}
+		if err := expandSecrets(&allPackages[i].Version); err != nil {
+			return nil, fmt.Errorf("package %s: %w", allPackages[i].Name, err)
+		}
+		// The parser leaves versions with secrets to be checked here; the
+		// error leaves out the value
+		if common.CheckName(allPackages[i].Version) != nil {
+			return nil, fmt.Errorf("package %s: invalid version: with its secrets expanded it contains whitespace or shell metacharacters, or starts with -", allPackages[i].Name)
+		}
+		if err := expandHookSecrets(&allPackages[i].Hooks); err != nil {
+			return nil, fmt.Errorf("package %s: %w", allPackages[i].Name, err)
+		}
+	}
+	for i := range allFiles {
+		// Verbatim content is uploaded as is, so it holds no references
+		if !allFiles[i].Verbatim && secrets.HasReferences(allFiles[i].Content) {
+			allFiles[i].Sensitive = append(allFiles[i].Sensitive, "content")
+		}
+		if options.skipSecrets {
+			continue
+		}
+		if !allFiles[i].Verbatim {
+			if err := expandSecrets(&allFiles[i].Content); err != nil {
+				return nil, fmt.Errorf("file %s: %w", allFiles[i].Path, err)
+			}
+		}
+		if err := expandHookSecrets(&allFiles[i].Hooks); err != nil {
+			return nil, fmt.Errorf("file %s: %w", allFiles[i].Path, err)
+		}
+	}
+	for i := range allServices {
+		if options.skipSecrets {
+			continue
+		}
+		if err := expandHookSecrets(&allServices[i].Hooks); err != nil {
+			return nil, fmt.Errorf("service %s: %w", allServices[i].Name, err)
+		}
+	}
+	for i := range allUnits {
+		if secrets.HasReferences(allUnits[i].Content) {
+			allUnits[i].Sensitive = append(allUnits[i].Sensitive, "content")
+		}
+		if options.skipSecrets {
+			continue
+		}
+		if err := expandSecrets(&allUnits[i].Content); err != nil {
+			return nil, fmt.Errorf("systemd_unit %s: %w", allUnits[i].Name, err)
+		}
+		if err := expandHookSecrets(&allUnits[i].Hooks); err != nil {
+			return nil, fmt.Errorf("systemd_unit %s: %w", allUnits[i].Name, err)
+		}
+	}
+	for i := range allProvided {
+		declared := &allProvided[i]
+		for key, value := range declared.Config {
+			text, ok := value.(string)
+			if !ok || !secrets.HasReferences(text) {
+				continue
+			}
+			declared.Sensitive = append(declared.Sensitive, key)
+			if options.skipSecrets {
+				continue
+			}
+			if err := expandSecrets(&text); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", declared.Type, declared.Name, err)
+			}
+			declared.Config[key] = text
+		}
+		if options.skipSecrets {
+			continue
+		}
+		if err := expandHookSecrets(&declared.Hooks); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", declared.Type, declared.Name, err)
+		}
+	}
+	resourceParser.SetPackages(allPackages)
+	resourceParser.SetFiles(allFiles)
+	resourceParser.SetServices(allServices)
+	resourceParser.SetSystemdUnits(allUnits)
+	resourceParser.SetProviders(providers)
+	resourceParser.SetProvidedResources(allProvided)
+
+	resources, err := resourceParser.ParseResources()
+	if err != nil {
+		return nil, fmt.Errorf("error creating resources: %w", err)
+	}
+	core.RegisterSensitiveValues(resources)
+	if err := core.CheckHostReferences(resources, core.HostMap(allHosts)); err != nil {
+		return nil, err
+	}
+
+	// The pre-flight connects to the targeted hosts before anything is
+	// planned, so a host that is down stops the run or is left out of it
+	var leftOut []string
+	if options.preflight {
+		reachable, err := preflight(context.Background(), logger, hosts, resources, !options.skipFacts)
+		if err != nil {
+			return nil, err
+		}
+		kept := core.HostMap(reachable)
+		for _, host := range hosts {
+			if _, ok := kept[host.Name]; !ok {
+				leftOut = append(leftOut, host.Name)
+			}
+		}
+		hosts = reachable
+	} else if core.NeedsFacts(resources) && !options.skipFacts {
+		// Facts are only gathered when a when condition reads them
+		logger.Info("Gathering facts...")
+		failed := core.GatherFacts(context.Background(), hosts)
+		for _, host := range hosts {
+			if err, ok := failed[host.Name]; ok {
+				return nil, fmt.Errorf("failed to gather facts from %s: %w", host.Name, err)
+			}
+		}
+	}
+	if !options.skipFacts {
+		if err := core.CheckConditions(resources, core.HostMap(hosts)); err != nil {
+			return nil, err
+		}
+	}
+
+	graph := core.NewGraph()
+	for _, resource := range resources {
+		if err := graph.AddResource(resource); err != nil {
+			return nil, fmt.Errorf("error adding resource %s to graph: %w", resource.GetID(), err)
+		}
+	}
+
+	if err := graph.ValidateDependencies(); err != nil {
+		return nil, fmt.Errorf("graph validation failed: %w", err)
+	}
+	if len(hostLimit) > 0 || len(leftOut) > 0 {
+		if pruned := core.PruneGraph(graph, core.HostMap(hosts)); pruned > 0 {
+			logger.Info(fmt.Sprintf("Skipping %d resources that target none of these hosts", pruned))
+		}
+	}
+
+	return &project{
+		hosts:         hosts,
+		allHosts:      allHosts,
+		resourceFiles: resourceFiles,
+		resources:     resources,
+		graph:         graph,
+		providers:     providers,
+		snapshot:      snapshot,
+		leftOut:       leftOut,
+	}, nil
+}
+
+// projectVariables returns the variables declared in the resource files,
+// which count and for_each refer to as var.name
+func projectVariables(resourceFiles []string) (map[string]string, error) {
+	var blocks []*parser.Block
+	for _, file := range resourceFiles {
+		fileBlocks, err := parser.ParseBlocksOfType(file, "variable")
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, fileBlocks...)
+	}
+	return parser.Variables(blocks)
+}
+
+// schemaFile is a .stl file of the project and the schema of its kind
+type schemaFile struct {
+	path   string
+	schema parser.FileSchema
+}
+
+// projectSchemaFiles pairs the files of a project with their schemas
+func projectSchemaFiles(inventoryFiles, resourceFiles, moduleFiles []string) []schemaFile {
+	var files []schemaFile
+	for _, file := range inventoryFiles {
+		files = append(files, schemaFile{file, parser.InventorySchema})
+	}
+	if _, err := os.Stat(configFile); err == nil {
+		files = append(files, schemaFile{configFile, parser.ConfigSchema})
+	}
+	for _, file := range resourceFiles {
+		files = append(files, schemaFile{file, parser.ResourceSchema})
+	}
+	for _, file := range moduleFiles {
+		files = append(files, schemaFile{file, parser.ModuleSchema})
+	}
+	return files
+}
+
+// checkUnknownAttributes fails on unknown block types, attributes and nested
+// blocks, which the parsers would otherwise ignore, so typos such as
+// `verion = "1.2"` are caught. With --lax they are only logged as warnings.
+func checkUnknownAttributes(logger *inventory.Logger, files []schemaFile) error {
+	var unknown []string
+	for _, file := range files {
+		blocks, err := parser.ParseBlocks(file.path)
+		if err != nil {
+			return err
+		}
+		for _, diagnostic := range file.schema.Validate(blocks) {
+			if diagnostic.Unknown {
+				unknown = append(unknown, diagnostic.String())
+			}
+		}
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+	if laxParse {
+		for _, message := range unknown {
+			logger.Warning(message)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown attributes or blocks (pass --lax to only warn):\n  %s", strings.Join(unknown, "\n  "))
+}
+
+// logPermissionWarnings warns about resources whose result would be unsafe,
+// such as world-writable files
+func (p *project) logPermissionWarnings(logger *inventory.Logger) {
+	for _, warning := range core.CheckPermissions(p.resources) {
+		logger.Warning(warning.String())
+	}
+}
+
+// profiles returns the verification profiles declared in the resource files
+func (p *project) profiles() ([]common.Profile, error) {
+	var profiles []common.Profile
+	for _, file := range p.resourceFiles {
+		fileProfiles, err := parser.ParseProfiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing profiles from %s: %w", file, err)
+		}
+		profiles = append(profiles, fileProfiles...)
+	}
+	return profiles, nil
+}
+
+// openState creates the state manager for the current project, separated
+// from other projects by SETTLE_PROJECT_KEY when --project is set. State
+// transitions are sent to --events-url when configured.
+func openState(graph *core.Graph, logger *inventory.Logger) (*core.StateManager, error) {
+	dir, name := stateLocation()
+	var backend core.StateBackend = core.NewLocalBackend(dir)
+
+	if projectName != "" {
+		namespaced, err := core.NewNamespacedBackend(backend, projectName, os.Getenv("SETTLE_PROJECT_KEY"))
+		if err != nil {
+			return nil, err
+		}
+		backend = namespaced
+	}
+
+	stateManager := core.NewStateManagerWithBackend(backend, name, graph)
+
+	if eventsURL != "" {
+		sink, err := core.NewTransitionSink(eventsURL)
+		if err != nil {
+			return nil, err
+		}
+		stateManager.OnTransition(func(transition core.StateTransition) {
+			transition.Project = projectName
+			if err := sink.Send(transition); err != nil {
+				logger.Warning(fmt.Sprintf("Failed to send state transition of %s: %v", transition.ResourceID, err))
+			}
+		})
+	}
+
+	return stateManager, nil
+}
+
+// lockOwner identifies this process in state lock files
+func lockOwner() string {
+	hostname, _ := os.Hostname()
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	return fmt.Sprintf("%s@%s (pid %d)", name, hostname, os.Getpid())
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory/parser"
+)
+
+// loadProviders starts each provider declared in settle.stl, asks it for
+// its resource types and registers them with the parser, so their blocks
+// are accepted in resource files and modules
+func loadProviders() ([]*core.Provider, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		return nil, nil
+	}
+	declared, err := parser.ParseProviders(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing providers from %s: %w", configFile, err)
+	}
+
+	var providers []*core.Provider
+	for _, provider := range declared {
+		loaded, err := core.LoadProvider(context.Background(), provider, ".")
+		if err != nil {
+			return nil, err
+		}
+		types := make([]string, 0, len(loaded.Types))
+		for name := range loaded.Types {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+		for _, name := range types {
+			if err := parser.RegisterResourceType(loaded.Name, name, providerAttributeSchemas(loaded.Types[name])); err != nil {
+				return nil, err
+			}
+		}
+		providers = append(providers, loaded)
+	}
+	return providers, nil
+}
+
+// providerAttributeSchemas converts the attributes of a provider's resource
+// type to parser schemas
+func providerAttributeSchemas(resourceType core.ProviderType) map[string]parser.AttrSchema {
+	kinds := map[string]parser.AttrKind{
+		"string":   parser.KindString,
+		"bool":     parser.KindBool,
+		"int":      parser.KindInt,
+		"duration": parser.KindDuration,
+		"list":     parser.KindList,
+		"map":      parser.KindMap,
+		"any":      parser.KindAny,
+	}
+	schemas := make(map[string]parser.AttrSchema, len(resourceType.Attributes))
+	for name, attr := range resourceType.Attributes {
+		schemas[name] = parser.AttrSchema{Kind: kinds[attr.Type], Required: attr.Required, Values: attr.Values}
+	}
+	return schemas
+}
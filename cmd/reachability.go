@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+// reachabilityTTL is how long a successful connection vouches for a host
+const reachabilityTTL = 5 * time.Minute
+
+var (
+	noCache bool
+
+	// unreachablePolicy holds --unreachable: fail, or skip to run without
+	// the hosts that cannot be reached
+	unreachablePolicy string
+)
+
+// addUnreachableFlag registers --unreachable on cmd
+func addUnreachableFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&unreachablePolicy, "unreachable", common.UnreachableFail, "What to do when a targeted host cannot be reached: fail, or skip it and run on the others; defaults to unreachable in settle.stl")
+	cmd.RegisterFlagCompletionFunc("unreachable", completeValues(common.UnreachableFail, common.UnreachableSkip))
+}
+
+// reachabilityCache returns the cache of reachable hosts, or nil with --no-cache
+func reachabilityCache() *core.ReachabilityCache {
+	if noCache {
+		return nil
+	}
+	return core.LoadReachabilityCache(filepath.Join(stateDir, "reachability.json"), reachabilityTTL)
+}
+
+// preflight connects to the hosts resources target, all at once, before
+// anything is planned: it checks they accept a connection, skipping hosts
+// that did within reachabilityTTL, and gathers their facts when conditions
+// read them. A host failing either fails the run, or with --unreachable skip
+// is left out of it. It returns the hosts to run on.
+func preflight(ctx context.Context, logger *inventory.Logger, hosts []common.Host, resources []core.Resource, gatherFacts bool) ([]common.Host, error) {
+	switch unreachablePolicy {
+	case "", common.UnreachableFail, common.UnreachableSkip:
+	default:
+		return nil, fmt.Errorf("invalid --unreachable %q (expected fail or skip)", unreachablePolicy)
+	}
+
+	targeted := core.TargetedHosts(resources, hosts)
+	cache := reachabilityCache()
+	failed := core.CheckReachability(ctx, targeted, cache)
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			logger.Warning(err.Error())
+		}
+	}
+	reachable, err := leaveOut(logger, targeted, failed, core.ErrUnreachable, "is not reachable")
+	if err != nil {
+		return nil, err
+	}
+
+	if gatherFacts && core.NeedsFacts(resources) {
+		logger.Info("Gathering facts...")
+		failed := core.GatherFacts(ctx, reachable)
+		if reachable, err = leaveOut(logger, reachable, failed, errNoFacts, "has no facts"); err != nil {
+			return nil, err
+		}
+	}
+	if len(targeted) > 0 && len(reachable) == 0 {
+		return nil, fmt.Errorf("%w: none of the targeted hosts is left", core.ErrUnreachable)
+	}
+
+	// Hosts no resource targets stay, with the facts gathered for the others
+	checked := core.HostMap(reachable)
+	targetedNames := core.HostMap(targeted)
+	var result []common.Host
+	for _, host := range hosts {
+		if checkedHost, ok := checked[host.Name]; ok {
+			result = append(result, *checkedHost)
+		} else if _, ok := targetedNames[host.Name]; !ok {
+			result = append(result, host)
+		}
+	}
+	return result, nil
+}
+
+// errNoFacts is returned when facts cannot be gathered from hosts
+var errNoFacts = errors.New("failed to gather facts")
+
+// leaveOut logs the hosts of failed, the error of each by name, and returns
+// the other hosts with --unreachable skip, or an error wrapping errKind
+func leaveOut(logger *inventory.Logger, hosts []common.Host, failed map[string]error, errKind error, problem string) ([]common.Host, error) {
+	if len(failed) == 0 {
+		return hosts, nil
+	}
+	skip := unreachablePolicy == common.UnreachableSkip
+
+	names := make([]string, 0, len(failed))
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if skip {
+			logger.Warning(fmt.Sprintf("Host %s %s and is left out of the run: %v", name, problem, failed[name]))
+		} else {
+			logger.Error(fmt.Sprintf("Host %s %s: %v", name, problem, failed[name]))
+		}
+	}
+	if !skip {
+		return nil, fmt.Errorf("%w: %s", errKind, strings.Join(names, ", "))
+	}
+
+	var kept []common.Host
+	for _, host := range hosts {
+		if _, ok := failed[host.Name]; !ok {
+			kept = append(kept, host)
+		}
+	}
+	return kept, nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&noCache, "no-cache", false, "Connect to every host instead of trusting connections made in the last 5 minutes")
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// planRow is an action as the plan renderer lists it under one host
+type planRow struct {
+	action   *core.Action
+	resource core.Resource // nil for resources no longer configured
+}
+
+// actionSymbols mark each kind of action in the plan; colors tell them apart
+// at a glance
+var actionSymbols = map[core.ActionType]struct {
+	symbol string
+	color  inventory.Color
+}{
+	core.ActionCreate: {"+", inventory.ColorGreen},
+	core.ActionUpdate: {"~", inventory.ColorYellow},
+	core.ActionDelete: {"-", inventory.ColorRed},
+}
+
+// renderPlan prints the actions of plan as aligned rows grouped by host:
+// symbol and action, resource ID, type and reason, followed by the changes
+// and removals of the action and what it disrupts. No-op actions are only
+// counted. With details, the labels and configuration of each resource
+// follow its row and the execution waves end the plan.
+func renderPlan(logger *inventory.Logger, plan *core.Plan, hosts []common.Host, details bool) {
+	hostMap := core.HostMap(hosts)
+	sections := make(map[string][]planRow)
+	for _, action := range plan.Actions {
+		if action.Type == core.ActionNoOp {
+			continue
+		}
+		var resource core.Resource
+		if plan.Graph != nil {
+			resource, _ = plan.Graph.GetResource(action.ResourceID)
+		}
+		names := core.ActionHosts(plan, action, hostMap)
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, name := range names {
+			sections[name] = append(sections[name], planRow{action: action, resource: resource})
+		}
+	}
+
+	for _, name := range sectionOrder(sections, hosts) {
+		rows := sections[name]
+		heading := inventory.Message(inventory.MsgHost, name)
+		if name == "" {
+			heading = inventory.Message(inventory.MsgPlanNoHost)
+		}
+		logger.Printf("%s", inventory.Colorize(inventory.ColorBold, heading))
+
+		idWidth, typeWidth := 0, 0
+		for _, row := range rows {
+			idWidth = max(idWidth, len(row.action.ResourceID))
+			typeWidth = max(typeWidth, len(rowType(row)))
+		}
+		for _, row := range rows {
+			reason, _ := row.action.Metadata["reason"].(string)
+			line := fmt.Sprintf("%s %-*s  %-*s  %s", actionLabel(row.action.Type), idWidth, row.action.ResourceID, typeWidth, rowType(row), inventory.Colorize(inventory.ColorDim, reason))
+			logger.Printf("  %s", strings.TrimRight(line, " "))
+
+			for _, change := range row.action.Changes {
+				logger.Printf("        %s", inventory.Message(inventory.MsgPlanChange, change.Field, formatChangeValue(change.OldValue), formatChangeValue(change.NewValue)))
+			}
+			if row.action.Type == core.ActionDelete {
+				if removes, ok := row.action.Metadata["removes"].([]core.Removal); ok {
+					for _, removal := range removes {
+						logger.Printf("        removes %s", describeRemoval(removal))
+					}
+				}
+			}
+			if impact, reason := core.ActionImpact(plan, row.action); impact == core.ImpactDisruptive {
+				logger.Printf("        %s", inventory.Colorize(inventory.ColorRed, "disruptive: "+reason))
+			}
+			if details && row.resource != nil {
+				logResourceDetails(logger, row.resource, "        ")
+			}
+		}
+		logger.Printf("")
+	}
+
+	logger.Printf("%s", inventory.Message(inventory.MsgPlanTotals,
+		plan.GetActionCount(core.ActionCreate),
+		plan.GetActionCount(core.ActionUpdate),
+		plan.GetActionCount(core.ActionDelete),
+		plan.GetActionCount(core.ActionNoOp)))
+	if disruptive := len(core.DisruptiveActions(plan)); disruptive > 0 {
+		logger.Printf("%s", inventory.Colorize(inventory.ColorRed, fmt.Sprintf("Disruptive changes: %d", disruptive)))
+	}
+	if details {
+		if waves := plan.Waves(); len(waves) > 1 {
+			logger.Printf("")
+			logger.Printf("Execution waves (each waits on the ones before it):")
+			for _, wave := range waves {
+				ids := make([]string, len(wave.Resources))
+				for i, id := range wave.Resources {
+					ids[i] = string(id)
+				}
+				logger.Printf("  %d: %s", wave.Index+1, strings.Join(ids, ", "))
+			}
+		}
+	}
+}
+
+// sectionOrder returns the host names of sections in inventory order, then
+// hosts missing from the inventory sorted, and actions without a host last
+func sectionOrder(sections map[string][]planRow, hosts []common.Host) []string {
+	var order []string
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if _, ok := sections[host.Name]; ok && !seen[host.Name] {
+			order = append(order, host.Name)
+			seen[host.Name] = true
+		}
+	}
+	var rest []string
+	for name := range sections {
+		if !seen[name] && name != "" {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	order = append(order, rest...)
+	if _, ok := sections[""]; ok {
+		order = append(order, "")
+	}
+	return order
+}
+
+// actionLabel returns the colored symbol and name of an action, padded so
+// rows align; plain output spells out the action alone
+func actionLabel(actionType core.ActionType) string {
+	label := fmt.Sprintf("%-6s", actionType)
+	if inventory.Plain() {
+		return label
+	}
+	mark, ok := actionSymbols[actionType]
+	if !ok {
+		return "  " + label
+	}
+	return inventory.Colorize(mark.color, mark.symbol+" "+label)
+}
+
+func rowType(row planRow) string {
+	if row.resource != nil {
+		return row.resource.GetType()
+	}
+	if kind, _, ok := strings.Cut(string(row.action.ResourceID), ":"); ok {
+		return kind
+	}
+	return ""
+}
+
+func formatChangeValue(value interface{}) string {
+	if value == nil || value == "" {
+		return "(none)"
+	}
+	return fmt.Sprint(value)
+}
+
+// logResourceDetails prints the owner, labels and masked configuration of a
+// resource
+func logResourceDetails(logger *inventory.Logger, resource core.Resource, indent string) {
+	if labels := resource.GetLabels(); len(labels) > 0 {
+		if owner := core.Owner(labels); owner != "" {
+			logger.Printf("%s%s", indent, inventory.Message(inventory.MsgPlanOwner, owner))
+		}
+		logger.Printf("%s%s", indent, inventory.Message(inventory.MsgPlanLabels, core.FormatLabels(labels)))
+	}
+	config := core.MaskConfig(resource, resource.GetConfig())
+	if len(config) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	logger.Printf("%s%s", indent, inventory.Message(inventory.MsgPlanConfiguration))
+	for _, key := range keys {
+		logger.Printf("%s  %s: %v", indent, key, config[key])
+	}
+}
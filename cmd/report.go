@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/settlectl/settle-core/core"
+)
+
+var (
+	reportFormat string
+	reportFile   string
+)
+
+// checkReportFlags validates --report before anything runs
+func checkReportFlags() error {
+	switch reportFormat {
+	case "", core.ReportJSON, core.ReportJUnit:
+		return nil
+	}
+	return fmt.Errorf("unsupported report format: %s (expected %s or %s)", reportFormat, core.ReportJSON, core.ReportJUnit)
+}
+
+// reportToStdout reports whether the run report is printed on stdout, in
+// which case log output moves to stderr
+func reportToStdout() bool {
+	return reportFormat != "" && (reportFile == "" || reportFile == "-")
+}
+
+// writeRunReport writes the --report of an execution result, if requested
+func writeRunReport(result *core.ExecutionResult) error {
+	if reportFormat == "" {
+		return nil
+	}
+
+	report := core.NewRunReport(result)
+	if reportToStdout() {
+		return report.WriteReport(os.Stdout, reportFormat)
+	}
+
+	f, err := os.Create(reportFile)
+	if err != nil {
+		return fmt.Errorf("failed to create report file: %w", err)
+	}
+	if err := report.WriteReport(f, reportFormat); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return f.Close()
+}
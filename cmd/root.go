@@ -1,6 +1,14 @@
 package cmd
 
 import (
+	"log"
+	"os"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/ssh"
+	"github.com/settlectl/settle-core/secrets"
 	"github.com/spf13/cobra"
 )
 
@@ -38,4 +46,101 @@ Settle is early but growing fast. Open source. Built in Go. Made for you.`,
 
 func Execute() {
 	cobra.CheckErr(rootCmd.Execute())
-}
\ No newline at end of file
+	exit()
+}
+
+var (
+	projectName       string
+	checksumAlgorithm string
+	fipsMode          bool
+	eventsURL         string
+	metricsPush       string
+	labelArgs         []string
+	labelSelector     core.LabelSelector
+	debugSSH          bool
+	verbose           int
+	laxParse          bool
+	outputLanguage    string
+	plainOutput       bool
+	noColor           bool
+)
+
+// configureGlobals applies global flags before any subcommand runs
+func configureGlobals(cmd *cobra.Command, args []string) error {
+	if err := loadSettings(cmd); err != nil {
+		return err
+	}
+	inventory.SetPlain(plainOutput || logFormat == common.LogFormatPlain)
+	inventory.SetJSON(logFormat == common.LogFormatJSON)
+	// NO_COLOR is the convention of https://no-color.org
+	inventory.SetColor(!noColor && os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout))
+	// serve chooses the run IDs of the runs it starts
+	runID := os.Getenv("SETTLE_RUN_ID")
+	if runID == "" {
+		runID = inventory.NewRunID()
+	}
+	inventory.SetRunID(runID)
+	if err := openLogFile(cmd); err != nil {
+		return err
+	}
+	lang := outputLanguage
+	if lang == "" {
+		lang = inventory.LocaleLanguage()
+	}
+	if err := inventory.SetLanguage(lang); err != nil {
+		return err
+	}
+	if err := common.SetFIPSMode(fipsMode); err != nil {
+		return err
+	}
+	ssh.SetKeyLoader(secrets.LoadKey)
+
+	inventory.SetVerbosity(verbose)
+	// Diagnostics go to stderr so they never mix with JSON or report output
+	debugLogger := inventory.NewLogger()
+	debugLogger.SetOutput(os.Stderr)
+	debugLogger.SetFlags(log.Ltime | log.Lmicroseconds)
+	if verbose >= inventory.VerbosityOutput {
+		inventory.SetTraceLogger(func(message string) {
+			debugLogger.Debug("command: " + message)
+		})
+	}
+	if debugSSH || verbose >= inventory.VerbosityTrace {
+		ssh.SetDebugLogger(func(message string) {
+			debugLogger.Debug("ssh: " + message)
+		})
+	}
+
+	selector, err := core.ParseLabelSelector(labelArgs)
+	if err != nil {
+		return err
+	}
+	labelSelector = selector
+
+	if checksumAlgorithm != "" {
+		if err := common.SetChecksumAlgorithm(common.HashAlgorithm(checksumAlgorithm)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentPreRunE = configureGlobals
+	rootCmd.PersistentFlags().StringVar(&projectName, "project", os.Getenv("SETTLE_PROJECT"), "Project namespace for state and locks, separated by SETTLE_PROJECT_KEY (env SETTLE_PROJECT)")
+	rootCmd.PersistentFlags().StringSliceVar(&labelArgs, "label", nil, "Only act on resources with these labels, e.g. --label owner=payments --label ticket")
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "Log more: -v commands, -vv their full output and timing, -vvv SSH handshakes and connection fallbacks")
+	rootCmd.PersistentFlags().BoolVar(&debugSSH, "debug-ssh", os.Getenv("SETTLE_DEBUG_SSH") == "1", "Log SSH connection setup, handshake, auth attempts and channels to stderr (env SETTLE_DEBUG_SSH=1)")
+	rootCmd.PersistentFlags().StringVar(&eventsURL, "events-url", os.Getenv("SETTLE_EVENTS_URL"), "Send state transitions to a webhook (http[s]://...) or NATS subject (nats://host:port/subject)")
+	rootCmd.PersistentFlags().StringVar(&metricsPush, "metrics-push", os.Getenv("SETTLE_METRICS_PUSH"), "Push run metrics to a Prometheus Pushgateway, e.g. http://pushgateway:9091 (env SETTLE_METRICS_PUSH)")
+	rootCmd.PersistentFlags().StringVar(&checksumAlgorithm, "checksum-algorithm", os.Getenv("SETTLE_CHECKSUM_ALGORITHM"), "Checksum algorithm: sha256 (default), sha384, sha512, sha1, md5")
+	rootCmd.PersistentFlags().BoolVar(&laxParse, "lax", os.Getenv("SETTLE_LAX") == "1", "Warn about unknown attributes and blocks in .stl files instead of failing (env SETTLE_LAX=1)")
+	rootCmd.PersistentFlags().StringVar(&outputLanguage, "lang", os.Getenv("SETTLE_LANG"), "Language of messages: en or de; defaults to the locale (env SETTLE_LANG)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", os.Getenv("SETTLE_LOG_FORMAT"), "Output format: text, plain or json; defaults to log_format in settle.stl, then text (env SETTLE_LOG_FORMAT)")
+	rootCmd.PersistentFlags().BoolVar(&plainOutput, "plain", os.Getenv("SETTLE_PLAIN") == "1", "Plain output without rules or emoji, phrased for screen readers (env SETTLE_PLAIN=1)")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", os.Getenv("SETTLE_NO_COLOR") == "1", "Print without ANSI colors, which are only used on terminals (env SETTLE_NO_COLOR=1 or NO_COLOR)")
+	rootCmd.PersistentFlags().BoolVar(&fipsMode, "fips", os.Getenv("SETTLE_FIPS") == "1", "Allow only FIPS-approved checksum algorithms (env SETTLE_FIPS=1)")
+	rootCmd.RegisterFlagCompletionFunc("log-format", completeValues(common.LogFormatText, common.LogFormatPlain, common.LogFormatJSON))
+	rootCmd.RegisterFlagCompletionFunc("lang", completeValues("en", "de"))
+	rootCmd.RegisterFlagCompletionFunc("checksum-algorithm", completeValues(string(common.HashSHA256), string(common.HashSHA384), string(common.HashSHA512), string(common.HashSHA1), string(common.HashMD5)))
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/secrets"
+	"github.com/spf13/cobra"
+)
+
+const defaultSecretsFile = ".settle/secrets.enc"
+
+var resolver *secrets.Resolver
+
+// secretsFile returns the encrypted secrets file provider
+func secretsFile() *secrets.FileProvider {
+	path := os.Getenv("SETTLE_SECRETS_FILE")
+	if path == "" {
+		path = defaultSecretsFile
+	}
+	return secrets.NewFileProvider(path, os.Getenv("SETTLE_SECRETS_KEY"))
+}
+
+// secretResolver returns the resolver for ${secret.name} references. Secrets
+// are looked up in SETTLE_SECRET_* environment variables, then the encrypted
+// secrets file, then Vault when VAULT_ADDR is set. Resolved values are
+// redacted from all log output.
+func secretResolver() *secrets.Resolver {
+	if resolver != nil {
+		return resolver
+	}
+
+	providers := []secrets.Provider{secrets.NewEnvProvider(), secretsFile()}
+	if address := os.Getenv("VAULT_ADDR"); address != "" {
+		path := os.Getenv("SETTLE_VAULT_PATH")
+		if path == "" {
+			path = "secret/data/settle"
+		}
+		providers = append(providers, secrets.NewVaultProvider(address, os.Getenv("VAULT_TOKEN"), path))
+	}
+
+	resolver = secrets.NewResolver(providers...)
+	resolver.OnResolve(inventory.RegisterSecret)
+	return resolver
+}
+
+// expandSecrets replaces ${secret.name} references in each value in place
+func expandSecrets(values ...*string) error {
+	for _, value := range values {
+		if !secrets.HasReferences(*value) {
+			continue
+		}
+		expanded, err := secretResolver().Expand(context.Background(), *value)
+		if err != nil {
+			return err
+		}
+		*value = expanded
+	}
+	return nil
+}
+
+// expandHookSecrets expands secret references in hook and wait_for commands
+func expandHookSecrets(hooks *common.Hooks) error {
+	for _, list := range [][]common.Hook{hooks.Before, hooks.After} {
+		for i := range list {
+			if err := expandSecrets(&list[i].Command); err != nil {
+				return err
+			}
+		}
+	}
+	for i := range hooks.WaitFor {
+		if err := expandSecrets(&hooks.WaitFor[i].Command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "manage the encrypted secrets file",
+	Long: `Secrets referenced as ${secret.name} in .stl files are read from the
+SETTLE_SECRET_NAME environment variable, then from the encrypted secrets file
+(.settle/secrets.enc, or SETTLE_SECRETS_FILE), then from Vault when VAULT_ADDR
+is set (VAULT_TOKEN, SETTLE_VAULT_PATH). The secrets file is encrypted with
+AES-256-GCM using the passphrase in SETTLE_SECRETS_KEY.`,
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "store a secret read from stdin in the encrypted secrets file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := secretsFile()
+		values, err := file.Load()
+		if err != nil {
+			return err
+		}
+
+		if isInteractive() {
+			fmt.Fprintf(os.Stderr, "Value for %s: ", args[0])
+		}
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && value == "" {
+			return fmt.Errorf("failed to read secret value: %w", err)
+		}
+		values[args[0]] = strings.TrimRight(value, "\r\n")
+
+		return file.Save(values)
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the names of secrets in the encrypted secrets file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, err := secretsFile().Load()
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Println(name)
+		}
+		return nil
+	},
+}
+
+var secretsDeleteCmd = &cobra.Command{
+	Use:   "delete <name>",
+	Short: "remove a secret from the encrypted secrets file",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := secretsFile()
+		values, err := file.Load()
+		if err != nil {
+			return err
+		}
+		if _, ok := values[args[0]]; !ok {
+			return fmt.Errorf("secret %s not found", args[0])
+		}
+		delete(values, args[0])
+		return file.Save(values)
+	},
+}
+
+func init() {
+	secretsCmd.AddCommand(secretsSetCmd, secretsListCmd, secretsDeleteCmd)
+	rootCmd.AddCommand(secretsCmd)
+}
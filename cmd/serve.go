@@ -0,0 +1,501 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Exit codes of the serve command
+const (
+	serveExitStopped = 0
+	serveExitError   = 1
+)
+
+// Statuses of a run started through the API
+const (
+	apiRunRunning   = "running"
+	apiRunSucceeded = "succeeded"
+	apiRunFailed    = "failed"
+)
+
+var (
+	serveAddr      string
+	serveTokenFile string
+	serveTLSCert   string
+	serveTLSKey    string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "drive plan, apply and drift detection over an HTTP API",
+	Long: `Serve exposes the project over an HTTP API so a UI or CI system can plan,
+apply and detect drift remotely. Every request must carry the token from
+SETTLE_API_TOKEN or --token-file as "Authorization: Bearer <token>".
+
+Each run is a separate settlectl process with its own run ID, started in the
+project directory with the global flags serve was given, so runs never
+share state in memory; the state lock keeps two runs from changing the same
+state at once.
+
+  POST /v1/runs              start a run: {"operation": "plan", "labels": [...]}
+                             operations: plan, apply, drift
+  GET  /v1/runs              list runs
+  GET  /v1/runs/{id}         status, exit code and result of a run
+  GET  /v1/runs/{id}/logs    stream the log until the run finishes
+                             (?follow=false returns it as it is)
+  POST /v1/runs/{id}/cancel  interrupt a run
+  GET  /v1/state             managed resources per host, as export cmdb`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runServe())
+	},
+}
+
+// apiOperation is how an operation of the API runs settlectl; result is
+// the file the run writes its result to, or empty if it prints it
+type apiOperation struct {
+	args   func(result string) []string
+	result string
+}
+
+var apiOperations = map[string]apiOperation{
+	"plan": {
+		args:   func(result string) []string { return []string{"plan", "--detailed-exitcode", "--output", result} },
+		result: "plan.json",
+	},
+	"apply": {
+		args: func(result string) []string {
+			return []string{"create", "--detailed-exitcode", "--no-progress", "--report", "json", "--report-file", result}
+		},
+		result: "report.json",
+	},
+	"drift": {
+		args: func(string) []string { return []string{"drift", "--json"} },
+	},
+}
+
+// apiRunRequest is the body of POST /v1/runs
+type apiRunRequest struct {
+	Operation string   `json:"operation"`
+	Labels    []string `json:"labels,omitempty"` // like --label
+}
+
+// apiRun is a settlectl process started through the API
+type apiRun struct {
+	ID         string          `json:"id"`
+	Operation  string          `json:"operation"`
+	Labels     []string        `json:"labels,omitempty"`
+	Status     string          `json:"status"`
+	ExitCode   *int            `json:"exit_code,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+
+	log    *runLog
+	cancel func()
+}
+
+// runLog is the output of a run, which readers can follow as it grows
+type runLog struct {
+	mu      sync.Mutex
+	data    []byte
+	done    bool
+	changed chan struct{} // closed on every write and when done
+}
+
+func newRunLog() *runLog {
+	return &runLog{changed: make(chan struct{})}
+}
+
+func (l *runLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.data = append(l.data, p...)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return len(p), nil
+}
+
+func (l *runLog) finish() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done = true
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// from returns the output after offset, whether the run is done and a
+// channel closed when there is more
+func (l *runLog) from(offset int) ([]byte, bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.data[offset:], l.done, l.changed
+}
+
+// apiServer runs operations for API requests
+type apiServer struct {
+	token      []byte
+	executable string
+	globalArgs []string
+	dir        string // where runs keep their results
+
+	mu   sync.Mutex
+	runs map[string]*apiRun
+	wg   sync.WaitGroup
+}
+
+func runServe() int {
+	logger := inventory.NewLogger()
+	token, err := apiToken()
+	if err != nil {
+		logger.Error(err.Error())
+		return serveExitError
+	}
+	if (serveTLSCert == "") != (serveTLSKey == "") {
+		logger.Error("--tls-cert and --tls-key must be given together")
+		return serveExitError
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error finding the settlectl executable: %v", err))
+		return serveExitError
+	}
+	dir, err := os.MkdirTemp("", "settle-serve-")
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error creating the run directory: %v", err))
+		return serveExitError
+	}
+	defer os.RemoveAll(dir)
+
+	server := &apiServer{
+		token:      token,
+		executable: executable,
+		globalArgs: forwardedFlags(),
+		dir:        dir,
+		runs:       make(map[string]*apiRun),
+	}
+	listener, err := net.Listen("tcp", serveAddr)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error listening for API requests: %v", err))
+		return serveExitError
+	}
+	httpServer := &http.Server{Handler: server.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	ctx, stop := interruptContext(context.Background(), logger)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	if serveTLSCert != "" {
+		logger.Info(fmt.Sprintf("Serving the API on https://%s", listener.Addr()))
+		err = httpServer.ServeTLS(listener, serveTLSCert, serveTLSKey)
+	} else {
+		logger.Info(fmt.Sprintf("Serving the API on http://%s", listener.Addr()))
+		err = httpServer.Serve(listener)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(fmt.Sprintf("Error serving the API: %v", err))
+		return serveExitError
+	}
+
+	logger.Info("Interrupting running runs")
+	server.cancelAll()
+	server.wg.Wait()
+	logger.Info("API server stopped")
+	return serveExitStopped
+}
+
+// apiToken returns the token requests must carry
+func apiToken() ([]byte, error) {
+	token := os.Getenv("SETTLE_API_TOKEN")
+	if serveTokenFile != "" {
+		data, err := os.ReadFile(serveTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the API token: %w", err)
+		}
+		token = string(data)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("serve needs a token: set SETTLE_API_TOKEN or pass --token-file")
+	}
+	return []byte(token), nil
+}
+
+// forwardedFlags returns the global flags serve was given, for its runs.
+// The project directory is not forwarded, since runs already start in it.
+func forwardedFlags() []string {
+	var args []string
+	rootCmd.PersistentFlags().Visit(func(flag *pflag.Flag) {
+		if flag.Name == "chdir" {
+			return
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range slice.GetSlice() {
+				args = append(args, "--"+flag.Name+"="+value)
+			}
+			return
+		}
+		args = append(args, "--"+flag.Name+"="+flag.Value.String())
+	})
+	return args
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/runs", s.startRun)
+	mux.HandleFunc("GET /v1/runs", s.listRuns)
+	mux.HandleFunc("GET /v1/runs/{id}", s.getRun)
+	mux.HandleFunc("GET /v1/runs/{id}/logs", s.streamLogs)
+	mux.HandleFunc("POST /v1/runs/{id}/cancel", s.cancelRun)
+	mux.HandleFunc("GET /v1/state", s.getState)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="settle"`)
+			writeAPIError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) startRun(w http.ResponseWriter, r *http.Request) {
+	var request apiRunRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	operation, ok := apiOperations[request.Operation]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown operation %q (expected plan, apply or drift)", request.Operation))
+		return
+	}
+
+	id := inventory.NewRunID()
+	runDir := filepath.Join(s.dir, id)
+	if err := os.Mkdir(runDir, 0o700); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var result string
+	if operation.result != "" {
+		result = filepath.Join(runDir, operation.result)
+	}
+	args := append([]string{}, s.globalArgs...)
+	for _, label := range request.Labels {
+		args = append(args, "--label="+label)
+	}
+	args = append(args, operation.args(result)...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, s.executable, args...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = time.Minute
+	cmd.Env = append(os.Environ(), "SETTLE_CHDIR=", "SETTLE_RUN_ID="+id)
+	run := &apiRun{
+		ID:        id,
+		Operation: request.Operation,
+		Labels:    request.Labels,
+		Status:    apiRunRunning,
+		StartedAt: time.Now(),
+		log:       newRunLog(),
+		cancel:    cancel,
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = run.log
+	if result == "" {
+		cmd.Stdout = &stdout
+	}
+	cmd.Stderr = run.log
+	if err := cmd.Start(); err != nil {
+		cancel()
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("failed to start run: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	s.runs[id] = run
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		err := cmd.Wait()
+		output := stdout.Bytes()
+		if result != "" {
+			output, _ = os.ReadFile(result)
+		}
+
+		s.mu.Lock()
+		code := cmd.ProcessState.ExitCode()
+		run.ExitCode = &code
+		finished := time.Now()
+		run.FinishedAt = &finished
+		run.Status = apiRunSucceeded
+		// Runs exit 2 when they find or make changes
+		if err != nil && code != exitChanges {
+			run.Status = apiRunFailed
+		}
+		if json.Valid(output) {
+			run.Result = output
+		}
+		s.mu.Unlock()
+		run.log.finish()
+	}()
+
+	writeAPIJSON(w, http.StatusAccepted, s.snapshot(run))
+}
+
+// snapshot copies run for encoding while it may still change
+func (s *apiServer) snapshot(run *apiRun) apiRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *run
+}
+
+func (s *apiServer) lookup(w http.ResponseWriter, r *http.Request) *apiRun {
+	s.mu.Lock()
+	run := s.runs[r.PathValue("id")]
+	s.mu.Unlock()
+	if run == nil {
+		writeAPIError(w, http.StatusNotFound, "no run "+r.PathValue("id"))
+	}
+	return run
+}
+
+func (s *apiServer) listRuns(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	runs := make([]apiRun, 0, len(s.runs))
+	for _, run := range s.runs {
+		summary := *run
+		summary.Result = nil
+		runs = append(runs, summary)
+	}
+	s.mu.Unlock()
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.Before(runs[j].StartedAt) })
+	writeAPIJSON(w, http.StatusOK, runs)
+}
+
+func (s *apiServer) getRun(w http.ResponseWriter, r *http.Request) {
+	if run := s.lookup(w, r); run != nil {
+		writeAPIJSON(w, http.StatusOK, s.snapshot(run))
+	}
+}
+
+// streamLogs writes the log of a run as it is written until the run
+// finishes or the client goes away
+func (s *apiServer) streamLogs(w http.ResponseWriter, r *http.Request) {
+	run := s.lookup(w, r)
+	if run == nil {
+		return
+	}
+	follow := r.URL.Query().Get("follow") != "false"
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	offset := 0
+	for {
+		data, done, changed := run.log.from(offset)
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+			offset += len(data)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if done || !follow {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *apiServer) cancelRun(w http.ResponseWriter, r *http.Request) {
+	run := s.lookup(w, r)
+	if run == nil {
+		return
+	}
+	run.cancel()
+	writeAPIJSON(w, http.StatusAccepted, s.snapshot(run))
+}
+
+func (s *apiServer) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		run.cancel()
+	}
+}
+
+// getState runs export cmdb and returns its records
+func (s *apiServer) getState(w http.ResponseWriter, r *http.Request) {
+	args := append(append([]string{}, s.globalArgs...), "export", "cmdb", "--format", "json")
+	cmd := exec.CommandContext(r.Context(), s.executable, args...)
+	cmd.Env = append(os.Environ(), "SETTLE_CHDIR=")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read state: %v: %s", err, strings.TrimSpace(stderr.String())))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(stdout.Bytes())
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]string{"error": message})
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:9450", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "File holding the token requests must carry (default SETTLE_API_TOKEN)")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "Serve HTTPS with this certificate")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "Private key of --tls-cert")
+}
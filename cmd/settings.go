@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// settings are the defaults of settle.stl, loaded before any command runs
+	settings common.Settings
+
+	// projectDir is the directory --chdir changes to before anything is read
+	projectDir string
+
+	// resourceDir holds the resource files, set by --resource-dir or
+	// resource_dir in settle.stl
+	resourceDir = "."
+
+	// stateDir holds the state, locks and caches, set by the state block of
+	// settle.stl
+	stateDir = ".settle"
+
+	// stateFile is the state, set by --state-file or the state block;
+	// state.json in stateDir when empty
+	stateFile string
+
+	// logFormat is text, plain or json, from --log-format or settle.stl
+	logFormat string
+
+	hostsFileFlag   string
+	resourceDirFlag string
+	logFileFlag     string
+	logMaxSizeFlag  string
+	logMaxFilesFlag int
+)
+
+// loadSettings changes to the --chdir directory, reads the settings of
+// settle.stl and applies them where the command line and environment leave a
+// value unset
+func loadSettings(cmd *cobra.Command) error {
+	if projectDir != "" {
+		if err := os.Chdir(projectDir); err != nil {
+			return fmt.Errorf("cannot change to project directory: %w", err)
+		}
+	}
+
+	if _, err := os.Stat(configFile); err == nil {
+		parsed, err := parser.ParseSettings(configFile)
+		if err != nil {
+			return fmt.Errorf("error parsing settings from %s: %w", configFile, err)
+		}
+		settings = parsed
+	}
+
+	switch {
+	case hostsFileFlag != "":
+		hostsFile = hostsFileFlag
+	case settings.HostsFile != "":
+		hostsFile = settings.HostsFile
+	}
+	switch {
+	case resourceDirFlag != "":
+		resourceDir = resourceDirFlag
+	case settings.ResourceDir != "":
+		resourceDir = settings.ResourceDir
+	}
+	if settings.StatePath != "" {
+		stateDir = settings.StatePath
+	}
+	if stateFile == "" {
+		stateFile = settings.StateFile
+	}
+	if logFormat == "" {
+		logFormat = settings.LogFormat
+	}
+	switch logFormat {
+	case "", common.LogFormatText, common.LogFormatPlain, common.LogFormatJSON:
+	default:
+		return fmt.Errorf("invalid log format %q (expected text, plain or json)", logFormat)
+	}
+
+	// Commands that apply take --parallel
+	if flag := cmd.Flags().Lookup("parallel"); flag != nil && !flag.Changed && settings.Parallelism > 0 {
+		if err := flag.Value.Set(strconv.Itoa(settings.Parallelism)); err != nil {
+			return err
+		}
+	}
+	// Commands that plan take --unreachable
+	if flag := cmd.Flags().Lookup("unreachable"); flag != nil && !flag.Changed && settings.Unreachable != "" {
+		if err := flag.Value.Set(settings.Unreachable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openLogFile starts copying log output to --log-file or log_file in
+// settle.stl, if either is set
+func openLogFile(cmd *cobra.Command) error {
+	path := logFileFlag
+	if path == "" {
+		path = settings.LogFile
+	}
+	if path == "" {
+		return nil
+	}
+
+	maxSize := settings.LogMaxSize
+	if logMaxSizeFlag != "" {
+		size, err := common.ParseSize(logMaxSizeFlag)
+		if err != nil {
+			return fmt.Errorf("--log-max-size: %w", err)
+		}
+		maxSize = size
+	}
+	maxFiles := inventory.DefaultLogFiles
+	if settings.LogMaxFiles > 0 {
+		maxFiles = settings.LogMaxFiles
+	}
+	if cmd.Flags().Changed("log-max-files") {
+		if logMaxFilesFlag < 1 {
+			return fmt.Errorf("--log-max-files must be at least 1")
+		}
+		maxFiles = logMaxFilesFlag
+	}
+
+	file, err := inventory.OpenLogFile(path, maxSize, maxFiles)
+	if err != nil {
+		return err
+	}
+	inventory.SetLogFile(file)
+	return nil
+}
+
+// stateLocation returns the directory and name of the state file
+func stateLocation() (string, string) {
+	if stateFile != "" {
+		return filepath.Dir(stateFile), filepath.Base(stateFile)
+	}
+	return stateDir, "state.json"
+}
+
+// applySSHDefaults gives each host the user, port, key, timeouts and max
+// sessions of the ssh block in settle.stl that it does not set itself
+func applySSHDefaults(hosts []common.Host) {
+	defaults := settings.SSH
+	for i := range hosts {
+		if hosts[i].User == "" {
+			hosts[i].User = defaults.User
+		}
+		if hosts[i].Port == 0 {
+			hosts[i].Port = defaults.Port
+		}
+		if hosts[i].Keyfile == "" {
+			hosts[i].Keyfile = defaults.Keyfile
+		}
+		if hosts[i].Timeouts.Connect == 0 {
+			hosts[i].Timeouts.Connect = defaults.Timeouts.Connect
+		}
+		if hosts[i].Timeouts.Command == 0 {
+			hosts[i].Timeouts.Command = defaults.Timeouts.Command
+		}
+		if hosts[i].Timeouts.Keepalive == 0 {
+			hosts[i].Timeouts.Keepalive = defaults.Timeouts.Keepalive
+		}
+		if hosts[i].MaxSessions == 0 {
+			hosts[i].MaxSessions = defaults.MaxSessions
+		}
+	}
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&projectDir, "chdir", "C", os.Getenv("SETTLE_CHDIR"), "Run in this project directory instead of the current one (env SETTLE_CHDIR)")
+	rootCmd.PersistentFlags().StringVar(&hostsFileFlag, "hosts-file", os.Getenv("SETTLE_HOSTS_FILE"), "Inventory file; defaults to hosts_file in settle.stl, then hosts.stl (env SETTLE_HOSTS_FILE)")
+	rootCmd.PersistentFlags().StringVar(&resourceDirFlag, "resource-dir", os.Getenv("SETTLE_RESOURCE_DIR"), "Directory of the resource files; defaults to resource_dir in settle.stl, then the project directory (env SETTLE_RESOURCE_DIR)")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state-file", os.Getenv("SETTLE_STATE_FILE"), "State file; defaults to the state block of settle.stl, then .settle/state.json (env SETTLE_STATE_FILE)")
+	rootCmd.PersistentFlags().StringVar(&logFileFlag, "log-file", os.Getenv("SETTLE_LOG_FILE"), "Copy all log output to this file with timestamps; defaults to log_file in settle.stl (env SETTLE_LOG_FILE)")
+	rootCmd.PersistentFlags().StringVar(&logMaxSizeFlag, "log-max-size", os.Getenv("SETTLE_LOG_MAX_SIZE"), "Rotate the log file when it grows past this size, e.g. 10MB; defaults to log_max_size in settle.stl, else never (env SETTLE_LOG_MAX_SIZE)")
+	rootCmd.PersistentFlags().IntVar(&logMaxFilesFlag, "log-max-files", inventory.DefaultLogFiles, "Rotated log files to keep; defaults to log_max_files in settle.stl")
+	rootCmd.RegisterFlagCompletionFunc("chdir", completeDirs)
+	rootCmd.RegisterFlagCompletionFunc("hosts-file", completeStlFiles)
+	rootCmd.RegisterFlagCompletionFunc("resource-dir", completeDirs)
+}
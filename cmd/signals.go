@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// exitInterrupted is the conventional exit status after SIGINT
+const exitInterrupted = 130
+
+// interruptContext returns a context cancelled on the first SIGINT/SIGTERM so
+// in-flight actions can abort and state is recorded. A second signal exits
+// immediately.
+func interruptContext(parent context.Context, logger *inventory.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-ctx.Done():
+			return
+		}
+		logger.Warning("Interrupt received: aborting in-flight actions and saving state (press Ctrl-C again to force quit)")
+		cancel()
+
+		<-signals
+		logger.Error("Forced quit: state may be incomplete and the state lock may need to be removed by hand")
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
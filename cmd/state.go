@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	stateShowHistory bool
+	stateShowJSON    bool
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "inspect the recorded state",
+}
+
+var stateShowCmd = &cobra.Command{
+	Use:          "show <resource-id>",
+	Short:        "show the recorded state of a resource",
+	SilenceUsage: true,
+	Long: fmt.Sprintf(`Show prints the state recorded for a resource: its status, when it was
+last applied, its checksum and the run that applied it.
+
+With --history it also lists the previous states of the resource, newest
+first. The state file keeps the last %d of them per resource.
+
+Example:
+  settlectl state show file:/etc/motd --history`, core.MaxStateHistory),
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeResourceIDs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stateManager, err := openState(nil, inventory.NewLogger())
+		if err != nil {
+			return err
+		}
+		if err := stateManager.LoadState(); err != nil {
+			return fmt.Errorf("error loading state: %w", err)
+		}
+		id := core.ResourceID(args[0])
+		state := stateManager.GetState(id)
+		if state == nil {
+			return fmt.Errorf("%s is not recorded in the state", id)
+		}
+
+		out := cmd.OutOrStdout()
+		if stateShowJSON {
+			if !stateShowHistory {
+				shown := *state
+				shown.History = nil
+				state = &shown
+			}
+			return printJSON(out, state)
+		}
+		printState(out, id, state)
+		if stateShowHistory {
+			printStateHistory(out, state.History)
+		}
+		return nil
+	},
+}
+
+// printState prints the recorded state of a resource
+func printState(out io.Writer, id core.ResourceID, state *core.ResourceState) {
+	fmt.Fprintf(out, "Resource:     %s\n", id)
+	fmt.Fprintf(out, "Status:       %s\n", state.Status)
+	fmt.Fprintf(out, "Last applied: %s\n", state.LastApplied.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(out, "Checksum:     %s\n", valueOrDash(state.Checksum))
+	fmt.Fprintf(out, "Run:          %s\n", valueOrDash(state.LastRunID))
+	if state.Duration > 0 {
+		fmt.Fprintf(out, "Took:         %s\n", state.Duration.Round(time.Millisecond))
+	}
+	if labels := core.StateLabels(state); len(labels) > 0 {
+		fmt.Fprintf(out, "Labels:       %s\n", core.FormatLabels(labels))
+	}
+	if message, ok := state.Metadata["error"].(string); ok {
+		fmt.Fprintf(out, "Error:        %s\n", message)
+	}
+}
+
+// printStateHistory lists previous states, newest first
+func printStateHistory(out io.Writer, history []core.StateRecord) {
+	fmt.Fprintln(out)
+	if len(history) == 0 {
+		fmt.Fprintln(out, "No previous states recorded")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AT\tSTATUS\tRUN\tCHECKSUM\tERROR")
+	for i := len(history) - 1; i >= 0; i-- {
+		record := history[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", record.At.Local().Format("2006-01-02 15:04:05"), record.Status,
+			valueOrDash(record.RunID), shortChecksum(record.Checksum), record.Error)
+	}
+	w.Flush()
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func init() {
+	stateShowCmd.Flags().BoolVar(&stateShowHistory, "history", false, "Also list the previous states of the resource")
+	stateShowCmd.Flags().BoolVar(&stateShowJSON, "json", false, "Print the state as JSON")
+	stateCmd.AddCommand(stateShowCmd)
+	rootCmd.AddCommand(stateCmd)
+}
@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the validate command
+const (
+	validateExitValid   = 0
+	validateExitInvalid = 1
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check .stl files for errors without connecting to hosts",
+	Long: `Validate parses every .stl file of the project and checks each block
+against the schema of its type: unknown block types and attributes, values of
+the wrong type and missing required attributes. When the files are valid, the
+resources are built and the dependency graph is checked.
+
+Every problem is listed with its file, line and column. With --lax, unknown
+attributes and blocks are warnings. Hosts and the state are never touched and
+secrets are not resolved.
+
+Exit codes:
+  0  the project is valid
+  1  problems were found`,
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(runValidate())
+	},
+}
+
+func runValidate() int {
+	logger := inventory.NewLogger()
+
+	diagnostics, warnings := validateFiles()
+	if len(diagnostics) == 0 {
+		diagnostics = validateProject()
+	}
+
+	for _, warning := range warnings {
+		logger.Warning(warning)
+	}
+	for _, diagnostic := range diagnostics {
+		logger.Error(diagnostic)
+	}
+	if len(diagnostics) > 0 {
+		logger.Info(fmt.Sprintf("Found %d problems", len(diagnostics)))
+		return validateExitInvalid
+	}
+	logger.Info("Configuration is valid")
+	return validateExitValid
+}
+
+// validateFiles parses every .stl file of the project and checks it against
+// the schema of its kind. With --lax, unknown attributes and blocks are
+// returned as warnings.
+func validateFiles() (diagnostics, warnings []string) {
+	check := func(file string, schema parser.FileSchema) {
+		blocks, err := parser.ParseBlocks(file)
+		if err != nil {
+			diagnostics = append(diagnostics, err.Error())
+			return
+		}
+		for _, diagnostic := range schema.Validate(blocks) {
+			if diagnostic.Unknown && laxParse {
+				warnings = append(warnings, diagnostic.String())
+				continue
+			}
+			diagnostics = append(diagnostics, diagnostic.String())
+		}
+	}
+
+	inventoryFiles, err := parser.InventoryFiles(hostsFile)
+	if err != nil {
+		diagnostics = append(diagnostics, err.Error())
+	}
+	for _, file := range inventoryFiles {
+		check(file, parser.InventorySchema)
+	}
+
+	if _, err := os.Stat(configFile); err == nil {
+		check(configFile, parser.ConfigSchema)
+	}
+	if _, err := loadProviders(); err != nil {
+		diagnostics = append(diagnostics, err.Error())
+	}
+
+	resourceFiles, err := findResourceFiles()
+	if err != nil {
+		return append(diagnostics, err.Error()), warnings
+	}
+	for _, file := range resourceFiles {
+		check(file, parser.ResourceSchema)
+	}
+	if len(diagnostics) > 0 {
+		return diagnostics, warnings
+	}
+
+	modules, _, err := findModules(resourceFiles)
+	if err != nil {
+		return append(diagnostics, err.Error()), warnings
+	}
+	for _, module := range modules {
+		files, err := parser.ModuleFiles(module)
+		if err != nil {
+			diagnostics = append(diagnostics, err.Error())
+			continue
+		}
+		for _, file := range files {
+			check(file, parser.ModuleSchema)
+		}
+	}
+
+	return diagnostics, warnings
+}
+
+// validateProject builds the resources of a project whose files are valid
+// and checks them and their dependency graph
+func validateProject() []string {
+	var diagnostics []string
+	if _, err := os.Stat(configFile); err == nil {
+		if _, err := parser.ParseDefaults(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseRunHooks(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseNotifications(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseAllowLists(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseResolvers(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseMeshes(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseLimits(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParsePolicies(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseSettings(configFile); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+	}
+
+	resourceFiles, err := findResourceFiles()
+	if err != nil {
+		return append(diagnostics, err.Error())
+	}
+	variables, err := projectVariables(resourceFiles)
+	if err != nil {
+		return append(diagnostics, err.Error())
+	}
+	for _, file := range resourceFiles {
+		blocks, err := parser.ParseBlocks(file)
+		if err != nil {
+			diagnostics = append(diagnostics, err.Error())
+			continue
+		}
+		blocks, err = parser.ExpandBlocks(blocks, variables)
+		if err != nil {
+			diagnostics = append(diagnostics, err.Error())
+			continue
+		}
+		if _, err := parser.PackagesFromBlocks(blocks); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.FilesFromBlocks(blocks); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ServicesFromBlocks(blocks); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.SystemdUnitsFromBlocks(blocks); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+		if _, err := parser.ParseProfiles(file); err != nil {
+			diagnostics = append(diagnostics, err.Error())
+		}
+	}
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	// Problems are reported as diagnostics rather than logged while loading
+	quiet := inventory.NewLogger()
+	quiet.SetOutput(io.Discard)
+	proj, err := loadProjectWith(quiet, projectOptions{skipSecrets: true, skipFacts: true})
+	if err != nil {
+		return append(diagnostics, err.Error())
+	}
+	for _, resource := range proj.resources {
+		if err := resource.Validate(); err != nil {
+			diagnostics = append(diagnostics, fmt.Sprintf("%s: %v", resource.GetID(), err))
+		}
+	}
+	return diagnostics
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
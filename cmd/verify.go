@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/core"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyProfile string
+	verifyWatch   bool
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check resources against hosts without applying changes",
+	Long: `Verify reads the real state of resources on their hosts and compares it
+with the declared configuration. Nothing is changed.
+
+Named profiles select a subset of resources so frequent checks stay cheap:
+
+  profile "security-baseline" {
+    resources = ["package:apt:openssh-*", "package:apt:ufw"]
+    interval  = "1h"
+  }
+
+With --watch each resource is re-verified on its own interval, so
+certificates can be checked weekly and security updates daily:
+
+  package "unattended-upgrades" {
+    manager  = "apt"
+    interval = "24h"
+  }
+
+Resources that declare no interval use the profile's.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := inventory.NewLogger()
+		logger.Info("Starting verification")
+
+		proj, err := loadProject(logger)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		profile := common.Profile{Name: "all"}
+		if verifyProfile != "" {
+			profiles, err := proj.profiles()
+			if err != nil {
+				logger.Error(err.Error())
+				return
+			}
+			found := false
+			for _, p := range profiles {
+				if p.Name == verifyProfile {
+					profile = p
+					found = true
+					break
+				}
+			}
+			if !found {
+				logger.Error(fmt.Sprintf("Profile %s not found", verifyProfile))
+				return
+			}
+		}
+
+		verifier := core.NewVerifier(proj.graph, logger)
+		verifier.SetHosts(proj.hosts)
+
+		if verifyWatch {
+			if err := watchVerification(logger, verifier, profile); err != nil {
+				logger.Error(err.Error())
+			}
+			return
+		}
+
+		result, err := verifier.Verify(profile)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Verification failed: %v", err))
+			return
+		}
+		logVerification(logger, result)
+		if !result.Passed() {
+			os.Exit(1)
+		}
+	},
+}
+
+// watchVerification re-verifies each resource of the profile whenever its
+// interval has passed
+func watchVerification(logger *inventory.Logger, verifier *core.Verifier, profile common.Profile) error {
+	resources, err := verifier.Select(profile)
+	if err != nil {
+		return fmt.Errorf("Verification failed: %w", err)
+	}
+	scheduler := core.NewScheduler(resources, profile.Interval)
+	if scheduler.Len() == 0 {
+		return fmt.Errorf("Profile %s has no interval and none of its resources declares one; --watch requires one", profile.Name)
+	}
+
+	return scheduler.Run(context.Background(), func(due []core.Resource) error {
+		logVerification(logger, verifier.VerifyResources(profile.Name, due))
+		logger.Info(fmt.Sprintf("Next verification in %v", time.Until(scheduler.Next()).Round(time.Second)))
+		return nil
+	})
+}
+
+func logVerification(logger *inventory.Logger, result *core.VerificationResult) {
+	logger.Info(fmt.Sprintf("Verification of profile %s:", result.Profile))
+	for _, check := range result.Checks {
+		logger.Info(fmt.Sprintf("  %s on %s: %s", check.ResourceID, check.Host, check.Status))
+		for _, change := range check.Changes {
+			logger.Info(fmt.Sprintf("      %s: expected %v, found %v", change.Field, change.NewValue, change.OldValue))
+		}
+		if check.Error != "" {
+			logger.Error(fmt.Sprintf("      %s", check.Error))
+		}
+	}
+	logger.Info(fmt.Sprintf("  Passed: %d", result.GetCount(core.CheckPassed)))
+	logger.Info(fmt.Sprintf("  Missing: %d", result.GetCount(core.CheckMissing)))
+	logger.Info(fmt.Sprintf("  Mismatch: %d", result.GetCount(core.CheckMismatch)))
+	logger.Info(fmt.Sprintf("  Errors: %d", result.GetCount(core.CheckError)))
+}
+
+func init() {
+	verifyCmd.Flags().StringVarP(&verifyProfile, "profile", "p", "", "Verify only the resources in this profile")
+	verifyCmd.Flags().BoolVarP(&verifyWatch, "watch", "w", false, "Re-verify the profile on its interval")
+	rootCmd.AddCommand(verifyCmd)
+}
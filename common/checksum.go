@@ -0,0 +1,137 @@
+package common
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+type HashAlgorithm string
+
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashSHA384 HashAlgorithm = "sha384"
+	HashSHA512 HashAlgorithm = "sha512"
+	HashSHA1   HashAlgorithm = "sha1" // legacy, not allowed in FIPS mode
+	HashMD5    HashAlgorithm = "md5"  // legacy, not allowed in FIPS mode
+)
+
+var hashAlgorithms = map[HashAlgorithm]struct {
+	new           func() hash.Hash
+	remoteCommand string
+	fipsApproved  bool
+}{
+	HashSHA256: {sha256.New, "sha256sum", true},
+	HashSHA384: {sha512.New384, "sha384sum", true},
+	HashSHA512: {sha512.New, "sha512sum", true},
+	HashSHA1:   {sha1.New, "sha1sum", false},
+	HashMD5:    {md5.New, "md5sum", false},
+}
+
+var (
+	checksumAlgorithm = HashSHA256
+	fipsMode          = false
+)
+
+// SetChecksumAlgorithm selects the algorithm used for new checksums
+func SetChecksumAlgorithm(algorithm HashAlgorithm) error {
+	if err := checkAlgorithm(algorithm); err != nil {
+		return err
+	}
+	checksumAlgorithm = algorithm
+	return nil
+}
+
+// ChecksumAlgorithm returns the algorithm used for new checksums
+func ChecksumAlgorithm() HashAlgorithm {
+	return checksumAlgorithm
+}
+
+// SetFIPSMode restricts checksums to FIPS-approved algorithms
+func SetFIPSMode(enabled bool) error {
+	fipsMode = enabled
+	if err := checkAlgorithm(checksumAlgorithm); err != nil {
+		return fmt.Errorf("current checksum algorithm: %w", err)
+	}
+	return nil
+}
+
+// FIPSMode reports whether only FIPS-approved algorithms are allowed
+func FIPSMode() bool {
+	return fipsMode
+}
+
+func checkAlgorithm(algorithm HashAlgorithm) error {
+	info, ok := hashAlgorithms[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+	}
+	if fipsMode && !info.fipsApproved {
+		return fmt.Errorf("checksum algorithm %s is not FIPS-approved", algorithm)
+	}
+	return nil
+}
+
+// Checksum hashes data with the selected algorithm and returns "<algorithm>:<hex>"
+func Checksum(data []byte) string {
+	sum, _ := ChecksumWith(checksumAlgorithm, data)
+	return sum
+}
+
+// ChecksumWith hashes data with algorithm and returns "<algorithm>:<hex>"
+func ChecksumWith(algorithm HashAlgorithm, data []byte) (string, error) {
+	if err := checkAlgorithm(algorithm); err != nil {
+		return "", err
+	}
+	h := hashAlgorithms[algorithm].new()
+	h.Write(data)
+	return FormatChecksum(algorithm, hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// FormatChecksum combines an algorithm and a hex digest
+func FormatChecksum(algorithm HashAlgorithm, digest string) string {
+	return string(algorithm) + ":" + strings.ToLower(digest)
+}
+
+// ParseChecksum splits "<algorithm>:<hex>". Bare digests written before
+// algorithms were recorded are sha256.
+func ParseChecksum(checksum string) (HashAlgorithm, string) {
+	if algorithm, digest, ok := strings.Cut(checksum, ":"); ok {
+		return HashAlgorithm(algorithm), digest
+	}
+	return HashSHA256, checksum
+}
+
+// RemoteChecksumCommand returns the coreutils command computing algorithm
+func RemoteChecksumCommand(algorithm HashAlgorithm) (string, error) {
+	if err := checkAlgorithm(algorithm); err != nil {
+		return "", err
+	}
+	return hashAlgorithms[algorithm].remoteCommand, nil
+}
+
+// ChecksumsEqual compares two checksums, which must use the same algorithm
+func ChecksumsEqual(a, b string) bool {
+	algorithmA, digestA := ParseChecksum(a)
+	algorithmB, digestB := ParseChecksum(b)
+	return algorithmA == algorithmB && strings.EqualFold(digestA, digestB)
+}
+
+// VerifyChecksum checks data (e.g. a downloaded artifact) against an expected
+// "<algorithm>:<hex>" checksum
+func VerifyChecksum(expected string, data []byte) error {
+	algorithm, _ := ParseChecksum(expected)
+	actual, err := ChecksumWith(algorithm, data)
+	if err != nil {
+		return err
+	}
+	if !ChecksumsEqual(expected, actual) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CommandResult is the outcome of a command that ran on a host. A nonzero
+// exit code is part of the result, not an error, so callers can tell an
+// expected status such as "package not installed" from a genuine failure.
+type CommandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+}
+
+// Success reports whether the command exited with status 0
+func (r *CommandResult) Success() bool {
+	return r.ExitCode == 0
+}
+
+// Output returns stdout followed by stderr, e.g. for logging
+func (r *CommandResult) Output() string {
+	switch {
+	case r.Stderr == "":
+		return r.Stdout
+	case r.Stdout == "" || strings.HasSuffix(r.Stdout, "\n"):
+		return r.Stdout + r.Stderr
+	}
+	return r.Stdout + "\n" + r.Stderr
+}
+
+// Err returns a *CommandError for a nonzero exit code, and nil otherwise
+func (r *CommandResult) Err() error {
+	if r.Success() {
+		return nil
+	}
+	return &CommandError{ExitCode: r.ExitCode, Stderr: strings.TrimSpace(r.Stderr)}
+}
+
+// ErrCommandTimeout is wrapped by the error of a command that ran longer
+// than the command timeout of its host
+var ErrCommandTimeout = errors.New("command timed out")
+
+// CommandError is a command that exited with a nonzero code
+type CommandError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr != "" {
+		return fmt.Sprintf("exited with status %d: %s", e.ExitCode, e.Stderr)
+	}
+	return fmt.Sprintf("exited with status %d", e.ExitCode)
+}
+
+// CommandOptions set up the environment commands run in on a host. Drivers
+// declare them on their transport instead of prefixing each command.
+type CommandOptions struct {
+	Env   map[string]string // Exported to the command, and kept by sudo
+	Dir   string            // Working directory; the login directory when empty
+	Umask os.FileMode       // File mode creation mask; 0 keeps the host's
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Wrap returns a script that runs command with the options. sudo resets the
+// environment, so the script has sudo keep the variables of Env.
+func (o CommandOptions) Wrap(command string) (string, error) {
+	if o.Umask > 0777 {
+		return "", fmt.Errorf("invalid umask %04o", o.Umask)
+	}
+
+	var b strings.Builder
+	if o.Dir != "" {
+		fmt.Fprintf(&b, "cd %s || exit 1\n", ShellQuote(o.Dir))
+	}
+	if o.Umask != 0 {
+		fmt.Fprintf(&b, "umask %04o\n", o.Umask)
+	}
+	if len(o.Env) > 0 {
+		names := make([]string, 0, len(o.Env))
+		for name := range o.Env {
+			if !envNamePattern.MatchString(name) {
+				return "", fmt.Errorf("invalid environment variable name %q", name)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "export %s=%s\n", name, ShellQuote(o.Env[name]))
+		}
+		fmt.Fprintf(&b, "sudo() { command sudo --preserve-env=%s \"$@\"; }\n", strings.Join(names, ","))
+	}
+	b.WriteString(command)
+	return b.String(), nil
+}
+
+// With returns the options with those of opts on top: its variables are
+// added, and its directory and umask replace these when set
+func (o CommandOptions) With(opts CommandOptions) CommandOptions {
+	if len(opts.Env) > 0 {
+		env := make(map[string]string, len(o.Env)+len(opts.Env))
+		for name, value := range o.Env {
+			env[name] = value
+		}
+		for name, value := range opts.Env {
+			env[name] = value
+		}
+		o.Env = env
+	}
+	if opts.Dir != "" {
+		o.Dir = opts.Dir
+	}
+	if opts.Umask != 0 {
+		o.Umask = opts.Umask
+	}
+	return o
+}
+
+type commandOptionsKey struct{}
+
+// WithCommandOptions returns a copy of ctx under which transports run
+// commands with opts, on top of the options ctx already carries
+func WithCommandOptions(ctx context.Context, opts CommandOptions) context.Context {
+	return context.WithValue(ctx, commandOptionsKey{}, CommandOptionsFromContext(ctx).With(opts))
+}
+
+// CommandOptionsFromContext returns the command options carried by ctx
+func CommandOptionsFromContext(ctx context.Context) CommandOptions {
+	opts, _ := ctx.Value(commandOptionsKey{}).(CommandOptions)
+	return opts
+}
+
+// ShellQuote quotes s as a single word for sh. Words of letters, digits
+// and @%+=:,./- only are returned as they are, so commands stay readable and
+// match allow-lists written without quotes.
+func ShellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, needsQuoting) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func needsQuoting(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	}
+	return !strings.ContainsRune("@%+=:,./-_", r)
+}
+
+// ShellJoin quotes each word with ShellQuote and joins them with spaces
+func ShellJoin(words ...string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = ShellQuote(word)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellMetacharacters have a meaning to sh
+const shellMetacharacters = "|&;<>()$`\\\"'*?[]{}!#"
+
+// CheckName returns an error when name, such as that of a package or a
+// service, holds whitespace, control characters or shell metacharacters, or
+// starts with a dash.
+// Commands quote names anyway; names like these are mistakes or attempts to
+// inject commands through a .stl file.
+func CheckName(name string) error {
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("%q starts with -, which commands would take for an option", name)
+	}
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune(shellMetacharacters, r) {
+			return fmt.Errorf("%q contains %q", name, r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Condition is a parsed `when` expression selecting the hosts a resource
+// applies to, e.g. os == 'ubuntu' && facts.memory_gb > 4. Operands are
+// quoted strings, numbers, true, false and names: facts.<name> and
+// vars.<name> of the host, its name, hostname and group, and any other bare
+// name as a fact. Undefined names are empty. ==, != and the ordering
+// operators compare numerically when both sides are numbers; <, <=, > and >=
+// require numbers. A lone operand holds when it is neither empty nor false.
+type Condition struct {
+	text  string
+	root  conditionNode
+	facts bool // whether the condition reads facts
+}
+
+type conditionNode interface {
+	eval(host *Host) (string, error)
+}
+
+// ParseCondition parses a `when` expression
+func ParseCondition(text string) (*Condition, error) {
+	tokens, err := tokenizeCondition(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in condition %q", p.tokens[p.pos].text, text)
+	}
+	return &Condition{text: text, root: root, facts: p.facts}, nil
+}
+
+// String returns the expression the condition was parsed from
+func (c *Condition) String() string {
+	return c.text
+}
+
+// UsesFacts reports whether evaluating the condition needs the facts of the
+// host
+func (c *Condition) UsesFacts() bool {
+	return c.facts
+}
+
+// Eval reports whether the condition holds for host
+func (c *Condition) Eval(host *Host) (bool, error) {
+	value, err := c.root.eval(host)
+	if err != nil {
+		return false, fmt.Errorf("condition %q on host %s: %w", c.text, host.Name, err)
+	}
+	return truthy(value), nil
+}
+
+func truthy(value string) bool {
+	return value != "" && value != "false"
+}
+
+func boolValue(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+type conditionToken struct {
+	kind string // "op", "string", "number" or "name"
+	text string
+}
+
+// tokenizeCondition splits text into operators, quoted strings, numbers and
+// names
+func tokenizeCondition(text string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(text[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in condition %q", text)
+			}
+			tokens = append(tokens, conditionToken{"string", text[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(text[i:], "&&"), strings.HasPrefix(text[i:], "||"),
+			strings.HasPrefix(text[i:], "=="), strings.HasPrefix(text[i:], "!="),
+			strings.HasPrefix(text[i:], "<="), strings.HasPrefix(text[i:], ">="):
+			tokens = append(tokens, conditionToken{"op", text[i : i+2]})
+			i += 2
+		case strings.IndexByte("()<>!", c) >= 0:
+			tokens = append(tokens, conditionToken{"op", text[i : i+1]})
+			i++
+		case c >= '0' && c <= '9' || c == '-' || c == '.':
+			start := i
+			for i++; i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.'); i++ {
+			}
+			if _, err := strconv.ParseFloat(text[start:i], 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q in condition %q", text[start:i], text)
+			}
+			tokens = append(tokens, conditionToken{"number", text[start:i]})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i++; i < len(text) && (text[i] == '_' || text[i] == '.' || text[i] == '-' ||
+				unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))); i++ {
+			}
+			tokens = append(tokens, conditionToken{"name", text[start:i]})
+		default:
+			return nil, fmt.Errorf("unexpected %q in condition %q", c, text)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty condition")
+	}
+	return tokens, nil
+}
+
+// conditionParser parses tokens by precedence: ||, &&, !, then comparisons
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	facts  bool
+}
+
+func (p *conditionParser) peekOp(ops ...string) (string, bool) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.tokens[p.pos].text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("||"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.peekOp("&&"); !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+}
+
+func (p *conditionParser) parseNot() (conditionNode, error) {
+	if _, ok := p.peekOp("!"); ok {
+		p.pos++
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.peekOp("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *conditionParser) parseOperand() (conditionNode, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("condition ends unexpectedly")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case "string", "number":
+		return literalNode(token.text), nil
+	case "name":
+		switch token.text {
+		case "true", "false":
+			return literalNode(token.text), nil
+		case "name", "hostname", "group":
+			return nameNode(token.text), nil
+		}
+		if strings.HasPrefix(token.text, "vars.") {
+			return nameNode(token.text), nil
+		}
+		p.facts = true
+		return nameNode(token.text), nil
+	}
+	if token.text == "(" {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.peekOp(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", token.text)
+}
+
+type literalNode string
+
+func (n literalNode) eval(*Host) (string, error) {
+	return string(n), nil
+}
+
+type nameNode string
+
+func (n nameNode) eval(host *Host) (string, error) {
+	name := string(n)
+	switch name {
+	case "name":
+		return host.Name, nil
+	case "hostname":
+		return host.Hostname, nil
+	case "group":
+		return host.Group, nil
+	}
+	if varName, ok := strings.CutPrefix(name, "vars."); ok {
+		return host.Vars[varName], nil
+	}
+	return host.Facts[strings.TrimPrefix(name, "facts.")], nil
+}
+
+type notNode struct {
+	operand conditionNode
+}
+
+func (n notNode) eval(host *Host) (string, error) {
+	value, err := n.operand.eval(host)
+	if err != nil {
+		return "", err
+	}
+	return boolValue(!truthy(value)), nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n logicalNode) eval(host *Host) (string, error) {
+	left, err := n.left.eval(host)
+	if err != nil {
+		return "", err
+	}
+	// Short-circuit, so vars.x != '' && vars.x > 2 is safe
+	if n.op == "&&" && !truthy(left) || n.op == "||" && truthy(left) {
+		return boolValue(truthy(left)), nil
+	}
+	right, err := n.right.eval(host)
+	if err != nil {
+		return "", err
+	}
+	return boolValue(truthy(right)), nil
+}
+
+type compareNode struct {
+	op          string
+	left, right conditionNode
+}
+
+func (n compareNode) eval(host *Host) (string, error) {
+	left, err := n.left.eval(host)
+	if err != nil {
+		return "", err
+	}
+	right, err := n.right.eval(host)
+	if err != nil {
+		return "", err
+	}
+
+	a, errA := strconv.ParseFloat(left, 64)
+	b, errB := strconv.ParseFloat(right, 64)
+	numeric := errA == nil && errB == nil
+	switch n.op {
+	case "==":
+		if numeric {
+			return boolValue(a == b), nil
+		}
+		return boolValue(left == right), nil
+	case "!=":
+		if numeric {
+			return boolValue(a != b), nil
+		}
+		return boolValue(left != right), nil
+	}
+	if !numeric {
+		return "", fmt.Errorf("%s needs numbers, got %q and %q", n.op, left, right)
+	}
+	switch n.op {
+	case "<":
+		return boolValue(a < b), nil
+	case "<=":
+		return boolValue(a <= b), nil
+	case ">":
+		return boolValue(a > b), nil
+	default:
+		return boolValue(a >= b), nil
+	}
+}
@@ -2,9 +2,13 @@ package common
 
 const (
 	MaxFileSize    = 1024 * 1024 
+	MaxFileContent = 10 * 1024 * 1024 // largest content a file resource takes from a source or URL
 	MaxLineLength  = 1024        
 	MaxHosts       = 1000        
 	MaxNameLength  = 255 
+	MaxPathLength  = 4096
+	MaxLabels      = 64
+	LabelOwner     = "owner" // label naming the team or person owning a resource
 	PackageManagerAPT = "apt"
 	PackageManagerYUM = "yum"
 	PackageManagerDNF = "dnf"
@@ -12,5 +16,20 @@ const (
 	PackageManagerPacman = "pacman"
 	PackageManagerBrew = "brew"
 	PackageManagerPort = "port"
+	PackageManagerSnap = "snap"
+	PackageManagerFlatpak = "flatpak"
+	DriftRemediate     = "remediate" // re-apply resources that changed on their hosts
+	DriftNotify        = "notify"    // report drift without changing the host
+	DriftFail          = "fail"      // stop the run
+	ConffilesKeep      = "keep"      // keep configuration files changed on the host when apt upgrades a package
+	ConffilesReplace   = "replace"   // install the package's version of changed configuration files
+	ServiceManagerSystemd = "systemd"
+	ServiceManagerOpenRC  = "openrc"  // Alpine, Gentoo
+	ServiceManagerRunit   = "runit"   // Void
+	ServiceManagerLaunchd = "launchd" // macOS
+	ServiceRunning        = "running"
+	ServiceStopped        = "stopped"
+	ServiceRestart        = "restart" // how a notified service reacts
+	ServiceReload         = "reload"
 
 )
\ No newline at end of file
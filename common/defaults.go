@@ -0,0 +1,17 @@
+package common
+
+// ApplyToFile fills in the fields file leaves unset
+func (d Defaults) ApplyToFile(file *File) {
+	if file.Mode == 0 {
+		file.Mode = d.FileMode
+	}
+	if file.DirMode == 0 {
+		file.DirMode = d.DirMode
+	}
+	if file.Owner == "" {
+		file.Owner = d.Owner
+	}
+	if file.Group == "" {
+		file.Group = d.Group
+	}
+}
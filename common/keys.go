@@ -0,0 +1,15 @@
+package common
+
+import "strings"
+
+// Prefixes of host keyfiles that reference a key store instead of a file
+const (
+	KeyStoreVault       = "vault:"
+	KeyStoreOnePassword = "op://"
+)
+
+// IsKeyReference reports whether keyfile references a private key in a key
+// store, fetched when connecting, rather than naming a file
+func IsKeyReference(keyfile string) bool {
+	return strings.HasPrefix(keyfile, KeyStoreVault) || strings.HasPrefix(keyfile, KeyStoreOnePassword)
+}
@@ -0,0 +1,24 @@
+package common
+
+// Policy outcomes
+const (
+	PolicyDeny = "deny" // create and apply refuse the plan
+	PolicyWarn = "warn" // the plan is applied with a warning
+)
+
+// Policy is a rule plans must follow, from a policy block of settle.stl. A
+// policy with a command passes the plan to it; otherwise it matches changes
+// by action, resource type, resource ID and host, and every matching change
+// violates it, or only those exceeding MaxMode when it is set.
+type Policy struct {
+	Name      string
+	Effect    string   // PolicyDeny or PolicyWarn
+	Message   string   // shown for violations; defaults to a description of the change
+	Projects  []string // only evaluated for these projects; empty means every project
+	Actions   []string // create, update or delete; empty means all three
+	Types     []string // resource types, e.g. package
+	Resources []string // resource ID patterns, e.g. file:/etc/**
+	Target    Target   // hosts and group the change must touch
+	MaxMode   int      // files may not have permission bits beyond these; -1 when unset
+	Command   string   // run locally with the plan as JSON on stdin; failing violates the policy
+}
@@ -0,0 +1,27 @@
+package common
+
+import "time"
+
+// Provider is an external program that implements resource types, from a
+// provider block of settle.stl
+type Provider struct {
+	Name    string
+	Command string // path of the executable; empty looks up settle-provider-<name>
+}
+
+// ProviderResource is a resource of a type implemented by a provider, e.g.
+// postgres_database "app" { ... }
+type ProviderResource struct {
+	Type      string // block type, e.g. postgres_database
+	Name      string
+	Provider  string
+	Config    map[string]interface{} // the attributes the provider declared: strings, bools, ints, lists and maps
+	Timeout   time.Duration
+	Labels    map[string]string
+	Sensitive []string
+	Module    string
+	OnDrift   string
+	Interval  time.Duration
+	Target    Target
+	Hooks     Hooks
+}
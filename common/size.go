@@ -0,0 +1,38 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes ParseSize accepts, longest first so "MB" is
+// not read as "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+	{"B", 1},
+}
+
+// ParseSize parses a size such as 512, 100K, 10MB or 1GiB into bytes; units
+// are powers of 1024
+func ParseSize(s string) (int64, error) {
+	text := strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(strings.ToUpper(text), strings.ToUpper(unit.suffix)) {
+			text = strings.TrimSpace(text[:len(text)-len(unit.suffix)])
+			multiplier = unit.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 512, 100K, 10MB or 1GB)", s)
+	}
+	return n * multiplier, nil
+}
@@ -1,5 +1,7 @@
 package common
 
+import "time"
+
 type Host struct {
 	Name     string
 	Hostname string
@@ -7,10 +9,274 @@ type Host struct {
 	Port     int
 	Keyfile  string
 	Group    string
+	AllowedCommands []string // when set, the only remote commands Settle may run; * and ? wildcards
+	Resolver *Resolver // how Hostname is resolved before dialing; nil uses the system resolver
+	MeshAddress string // tailnet or WireGuard address, dialed before Hostname when set
+	Transport string // how commands reach the host: TransportSSH (default) or TransportLocal
+	Timeouts Timeouts // zero values use the settings of settle.stl, then the SSH defaults
+	Aliases  []string          // other names the host is targeted by
+	Vars     map[string]string // variables of the host and its group, e.g. nginx_port; the host's win
+	Facts    map[string]string // gathered from the host when a when condition needs them, e.g. os
+	MaxSessions     int               // SSH sessions open at once over the host's connection; 0 uses settle.stl, then 10
+}
+
+// Timeouts bound connecting to a host and running commands on it
+type Timeouts struct {
+	Connect   time.Duration // TCP connect and SSH handshake
+	Command   time.Duration // a command without a resource timeout
+	Keepalive time.Duration // TCP keepalive period of the connection
 }
 
+// Transports of a host
+const (
+	TransportSSH   = "ssh"
+	TransportLocal = "local" // the machine running settle, without SSH
+)
+
 type Package struct {
 	Name    string 
 	Version string
 	Manager string
-}
\ No newline at end of file
+	Timeout   time.Duration
+	Labels    map[string]string
+	Sensitive []string // config fields masked in logs and state; "*" for all
+	Module    string   // module the package was declared in, if any
+	OnDrift   string   // what a plan does when the package changed on its host: remediate, notify or fail
+	Interval  time.Duration // how often watch and daemon modes re-check the package; 0 uses their default
+	Hold       bool // keep the package at its installed version during upgrades
+	Purge      bool // remove configuration files too when the package is removed
+	Autoremove bool // remove dependencies nothing needs anymore after removing the package
+	CacheValidTime time.Duration // refresh the package index before installing when it is older; 0 uses the driver's default
+	Conffiles      string        // what apt does with configuration files changed on the host: ConffilesKeep (default) or ConffilesReplace
+	Channel   string // snap channel, e.g. 1.28/stable, or flatpak branch
+	Classic   bool   // install a snap with classic confinement
+	Remote    string // flatpak remote to install from; flathub by default
+	RemoteURL string // .flatpakrepo URL that adds Remote when the host lacks it
+	Target
+	Hooks
+}
+
+// Target selects the hosts a resource is applied to. An empty target
+// selects every host in the inventory.
+type Target struct {
+	Hosts []string
+	Group string
+	When  string `json:",omitempty"` // condition on the facts and vars of each host, see Condition
+}
+
+type File struct {
+	Path    string
+	Content string
+	Mode    int
+	DirMode int // mode of missing parent directories created for the file; 0 creates none
+	Owner   string
+	Group   string
+	Timeout time.Duration
+	Labels    map[string]string // attribution, e.g. team or ticket; the owning team is labels.owner
+	Sensitive []string          // fields masked in logs and state, e.g. "content"; "*" for all
+	Module    string            // module the file was declared in, if any
+	OnDrift   string            // what a plan does when the file changed on its host: remediate, notify or fail
+	Interval  time.Duration     // how often watch and daemon modes re-check the file; 0 uses their default
+	Notify    []string          // resources notified when the file changes, e.g. service:nginx
+	Source    string            // local file the content was read from, resolved from the declaring file
+	SourceURL string            // URL the content is fetched from when applied; Checksum is required
+	Checksum  string            // declared checksum of the content, e.g. sha256:<hex>
+	Verbatim  bool              // the content is uploaded as is, without rendering host references
+	Target    Target
+	Hooks     Hooks
+}
+
+// Service is a service kept running or stopped, and enabled at boot or not
+type Service struct {
+	Name      string
+	State     string // running or stopped; empty leaves the service as it is
+	Enabled   *bool  // whether the service starts at boot; nil leaves it as it is
+	Manager   string // systemd, openrc, runit or launchd; empty detects the init system of each host
+	OnNotify  string // restart or reload when notified; empty restarts
+	Timeout   time.Duration
+	Labels    map[string]string
+	Sensitive []string
+	Module    string
+	OnDrift   string
+	Interval  time.Duration
+	Target    Target
+	Hooks     Hooks
+}
+
+// SystemdUnit is a custom unit file written to /etc/systemd/system
+type SystemdUnit struct {
+	Name      string // unit name with its type, e.g. myapp.service
+	Content   string
+	Restart   bool // restart the unit after a change when no service block manages it
+	Timeout   time.Duration
+	Labels    map[string]string
+	Sensitive []string
+	Module    string
+	OnDrift   string
+	Interval  time.Duration
+	Target    Target
+	Hooks     Hooks
+}
+
+// MinInterval is the shortest re-check interval a resource may declare
+const MinInterval = time.Second
+
+// Profile is a named subset of resources verified without converging them
+type Profile struct {
+	Name      string
+	Resources []string // resource ID patterns, e.g. "package:apt:openssh-*"
+	Types     []string
+	Interval  time.Duration
+}
+
+// Defaults are project-wide values for resources that omit them, from the
+// defaults block of settle.stl
+type Defaults struct {
+	FileMode int
+	DirMode  int
+	Owner    string
+	Group    string
+	Umask    int  // implies FileMode and DirMode when they are not set
+	HasUmask bool
+}
+
+// Settings are the command line defaults of a project, from the settings,
+// state and ssh blocks of settle.stl. Flags override them; zero values keep
+// the built-in defaults.
+type Settings struct {
+	HostsFile    string // inventory file, hosts.stl by default
+	ResourceDir  string // directory of the resource files, the project directory by default
+	Parallelism  int    // hosts an action runs on concurrently
+	Unreachable  string // fail or skip: what runs do when a host cannot be reached
+	LogFormat    string // text, plain or json
+	LogFile      string // file every logged line is copied to
+	LogMaxSize   int64  // bytes after which the log file is rotated; 0 never rotates
+	LogMaxFiles  int    // rotated log files kept
+	StateBackend string // local
+	StatePath    string // directory of the state, locks and caches, .settle by default
+	StateFile    string // state file, state.json in StatePath by default
+	SSH          Host   // user, port, key_file, timeouts and max_sessions for hosts that leave them out
+}
+
+// Log formats
+const (
+	LogFormatText  = "text"
+	LogFormatPlain = "plain"
+	LogFormatJSON  = "json"
+)
+
+// StateBackendLocal keeps the state in files of the project
+const StateBackendLocal = "local"
+
+// What a run does when a host it targets cannot be reached
+const (
+	UnreachableFail = "fail" // stop before anything is planned
+	UnreachableSkip = "skip" // leave the host out of the run
+)
+
+// Module instantiates the resource files of a directory with input variables
+type Module struct {
+	Name   string
+	Source string            // directory of the module, relative to the declaring file
+	Vars   map[string]string // values of the module's variables
+}
+
+// AllowList restricts the remote commands Settle may run on the hosts it
+// targets, e.g. to match a least-privilege sudoers file
+type AllowList struct {
+	Name     string
+	Target   Target   // no hosts or group means every host
+	Commands []string // patterns matched against the whole command; * and ? wildcards
+}
+
+// Resolver types
+const (
+	ResolverSystem    = "system"    // the operating system's resolver
+	ResolverDNS       = "dns"       // a specific DNS server
+	ResolverConsul    = "consul"    // the node address in the Consul catalog
+	ResolverTailscale = "tailscale" // Tailscale MagicDNS
+)
+
+// Resolver resolves the names of the hosts it targets before Settle dials
+// them, from a resolver block of settle.stl
+type Resolver struct {
+	Name       string
+	Type       string // ResolverSystem, ResolverDNS, ResolverConsul or ResolverTailscale
+	Server     string // DNS server as host:port, for dns and tailscale
+	Address    string // Consul HTTP API address, e.g. http://127.0.0.1:8500
+	Datacenter string // Consul datacenter; empty means the agent's own
+	Domain     string // suffix appended to names without a dot, e.g. a tailnet's ts.net domain
+	Target     Target // no hosts or group means every host
+}
+
+// Mesh types
+const (
+	MeshTailscale = "tailscale"
+)
+
+// Mesh looks up the mesh network addresses of the hosts it targets, from a
+// mesh block of settle.stl. Hosts with a mesh_address keep it.
+type Mesh struct {
+	Name    string
+	Type    string // MeshTailscale
+	Tailnet string // tailnet to query with the Tailscale API; empty asks the local tailscale client
+	Target  Target // no hosts or group means every host
+}
+
+// Limits cap how much a single run may change, from a limits block of
+// settle.stl. A negative value means no limit.
+type Limits struct {
+	Group           string // only count changes to hosts of this group; empty counts every host
+	MaxChanges      int    // resources created, updated or deleted
+	MaxDeletes      int    // resources deleted
+	MaxHostsChanged int    // hosts with at least one change
+}
+
+// Notification posts a run summary to a webhook after plan or create
+type Notification struct {
+	Name     string
+	URL      string
+	Format   string   // "webhook" (JSON summary) or "slack"
+	Events   []string // commands to notify about, e.g. ["plan", "create"]; empty means all
+	Template string   // Go text/template rendered with the run summary
+}
+
+// Failure policies of a hook
+const (
+	HookAbort  = "abort"  // fail the resource (or the run)
+	HookWarn   = "warn"   // log a warning and continue
+	HookIgnore = "ignore" // continue silently
+)
+
+// Hook is a command run around a resource's Apply or around the whole run
+type Hook struct {
+	Command   string
+	Local     bool   // run on the machine running settle instead of the target host
+	OnFailure string // HookAbort, HookWarn or HookIgnore
+}
+
+// Hooks are the commands run before and after a resource is applied, and
+// the conditions waited for before it is
+type Hooks struct {
+	Before  []Hook
+	After   []Hook
+	WaitFor []WaitFor
+}
+
+// WaitFor is a condition on the target host waited for before a resource is
+// applied. Exactly one of Port, Path, Command and Delay is set.
+type WaitFor struct {
+	Port     string        // [host:]port accepting connections from the target host; host defaults to 127.0.0.1
+	Path     string        // file or directory that exists
+	Command  string        // command that succeeds
+	Delay    time.Duration // fixed pause
+	Timeout  time.Duration // how long to wait for the condition; 0 waits the default
+	Interval time.Duration // pause between checks; 0 uses the default
+}
+
+// RunHook is a project-wide hook run once before or after a run
+type RunHook struct {
+	Name  string
+	Stage string // "pre-run" or "post-run"
+	Hook
+}
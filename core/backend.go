@@ -0,0 +1,335 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// StateBackend stores state documents and their locks by key
+type StateBackend interface {
+	// Read returns the document stored at key, or nil if it does not exist
+	Read(key string) ([]byte, error)
+	Write(key string, data []byte) error
+	// Delete removes the document stored at key, if any
+	Delete(key string) error
+	// List returns the names of the documents directly below the key dir,
+	// sorted; none when dir does not exist
+	List(dir string) ([]string, error)
+	// Lock acquires an exclusive lock on key for owner
+	Lock(key string, owner string) error
+	Unlock(key string) error
+}
+
+// LocalBackend stores state as files below a directory
+type LocalBackend struct {
+	Dir string
+}
+
+func NewLocalBackend(dir string) *LocalBackend {
+	return &LocalBackend{Dir: dir}
+}
+
+func (b *LocalBackend) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || filepath.IsAbs(key) {
+		return "", fmt.Errorf("invalid state key: %q", key)
+	}
+	return filepath.Join(b.Dir, filepath.FromSlash(key)), nil
+}
+
+func (b *LocalBackend) Read(key string) ([]byte, error) {
+	path, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	return data, nil
+}
+
+func (b *LocalBackend) Write(key string, data []byte) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) Delete(key string) error {
+	path, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete state file: %w", err)
+	}
+	return nil
+}
+
+func (b *LocalBackend) List(dir string) ([]string, error) {
+	path, err := b.path(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (b *LocalBackend) Lock(key string, owner string) error {
+	path, err := b.path(key + ".lock")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		holder, _ := os.ReadFile(path)
+		return fmt.Errorf("state %s is locked by %s", key, strings.TrimSpace(string(holder)))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create lock file: %w", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s at %s\n", owner, time.Now().Format(time.RFC3339))
+	return err
+}
+
+func (b *LocalBackend) Unlock(key string) error {
+	path, err := b.path(key + ".lock")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove lock file: %w", err)
+	}
+	return nil
+}
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+const (
+	// projectRecordKey is where a project records the check of its key
+	projectRecordKey = "project.json"
+	// projectHeader starts every document of a project
+	projectHeader   = "settle-project-v1\n"
+	projectKeySize  = 32 // AES-256
+	projectSaltSize = 16
+)
+
+// projectRecord is written unencrypted when a project is first used, so
+// later runs can check their key before touching its documents
+type projectRecord struct {
+	Salt      []byte    `json:"salt"`
+	Verifier  string    `json:"verifier"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NamespacedBackend keeps the state, locks and history of a project under
+// its own key prefix, projects/<name>/, and separates projects sharing one
+// backend: the first run of a project records a check of its key, later
+// runs with another key are refused before they read, lock or write
+// anything, and every document is encrypted with AES-256-GCM under a key
+// derived from the project key, so it cannot be read or replaced without
+// it.
+type NamespacedBackend struct {
+	backend   StateBackend
+	namespace string
+	aead      cipher.AEAD
+}
+
+// NewNamespacedBackend opens the project namespace in backend with key,
+// claiming the project for key when it was never used
+func NewNamespacedBackend(backend StateBackend, namespace, key string) (*NamespacedBackend, error) {
+	if !namespacePattern.MatchString(namespace) {
+		return nil, fmt.Errorf("invalid project name %q: use lowercase letters, digits, '-' and '_'", namespace)
+	}
+	if key == "" {
+		return nil, fmt.Errorf("project %s needs a key: set SETTLE_PROJECT_KEY", namespace)
+	}
+	b := &NamespacedBackend{backend: backend, namespace: namespace}
+
+	record, err := b.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		if record, err = b.claim(key); err != nil {
+			return nil, err
+		}
+	}
+
+	derived, err := scrypt.Key([]byte(key), record.Salt, 1<<15, 8, 1, projectKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive project key: %w", err)
+	}
+	if !hmac.Equal([]byte(projectVerifier(derived, namespace)), []byte(record.Verifier)) {
+		return nil, fmt.Errorf("project %s belongs to another key: check --project and SETTLE_PROJECT_KEY", namespace)
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	if b.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// claim records a new salt and the check of key for the project, unless
+// another run claimed it first
+func (b *NamespacedBackend) claim(key string) (*projectRecord, error) {
+	if err := b.backend.Lock(b.key(projectRecordKey), "project claim"); err != nil {
+		return nil, err
+	}
+	defer b.backend.Unlock(b.key(projectRecordKey))
+
+	record, err := b.readRecord()
+	if err != nil || record != nil {
+		return record, err
+	}
+
+	record = &projectRecord{Salt: make([]byte, projectSaltSize), CreatedAt: time.Now().UTC()}
+	if _, err := io.ReadFull(rand.Reader, record.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	derived, err := scrypt.Key([]byte(key), record.Salt, 1<<15, 8, 1, projectKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive project key: %w", err)
+	}
+	record.Verifier = projectVerifier(derived, b.namespace)
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal project record: %w", err)
+	}
+	if err := b.backend.Write(b.key(projectRecordKey), data); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (b *NamespacedBackend) readRecord() (*projectRecord, error) {
+	data, err := b.backend.Read(b.key(projectRecordKey))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var record projectRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid record of project %s: %w", b.namespace, err)
+	}
+	if len(record.Salt) != projectSaltSize || record.Verifier == "" {
+		return nil, fmt.Errorf("invalid record of project %s", b.namespace)
+	}
+	return &record, nil
+}
+
+// projectVerifier lets a run check its key without decrypting anything
+func projectVerifier(derived []byte, namespace string) string {
+	mac := hmac.New(sha256.New, derived)
+	mac.Write([]byte("settle project " + namespace))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Namespace returns the project whose prefix this backend uses
+func (b *NamespacedBackend) Namespace() string {
+	return b.namespace
+}
+
+func (b *NamespacedBackend) key(key string) string {
+	return "projects/" + b.namespace + "/" + key
+}
+
+// Read decrypts the document at key. The full key is authenticated with it,
+// so a document moved to another key or project fails to decrypt.
+func (b *NamespacedBackend) Read(key string) ([]byte, error) {
+	data, err := b.backend.Read(b.key(key))
+	if err != nil || data == nil {
+		return data, err
+	}
+	if !bytes.HasPrefix(data, []byte(projectHeader)) {
+		return nil, fmt.Errorf("%s of project %s is not encrypted with the project key", key, b.namespace)
+	}
+	sealed := data[len(projectHeader):]
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, fmt.Errorf("%s of project %s is truncated", key, b.namespace)
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():], []byte(projectHeader+b.key(key)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s of project %s: it was written with another key or changed", key, b.namespace)
+	}
+	return plaintext, nil
+}
+
+func (b *NamespacedBackend) Write(key string, data []byte) error {
+	if key == projectRecordKey {
+		return fmt.Errorf("invalid state key: %q", key)
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	out := append([]byte(projectHeader), nonce...)
+	out = b.aead.Seal(out, nonce, data, []byte(projectHeader+b.key(key)))
+	return b.backend.Write(b.key(key), out)
+}
+
+func (b *NamespacedBackend) Delete(key string) error {
+	return b.backend.Delete(b.key(key))
+}
+
+func (b *NamespacedBackend) List(dir string) ([]string, error) {
+	return b.backend.List(b.key(dir))
+}
+
+func (b *NamespacedBackend) Lock(key string, owner string) error {
+	return b.backend.Lock(b.key(key), owner)
+}
+
+func (b *NamespacedBackend) Unlock(key string) error {
+	return b.backend.Unlock(b.key(key))
+}
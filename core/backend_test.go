@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNamespacedBackendSeparatesProjects(t *testing.T) {
+	local := NewLocalBackend(t.TempDir())
+
+	payments, err := NewNamespacedBackend(local, "payments", "payments-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := []byte(`{"package:apt:nginx":{}}`)
+	if err := payments.Write("state.json", state); err != nil {
+		t.Fatal(err)
+	}
+
+	// The document is stored encrypted under the project's prefix
+	raw, err := local.Read("projects/payments/state.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw == nil || bytes.Contains(raw, []byte("nginx")) {
+		t.Fatalf("state stored as %q, want it encrypted", raw)
+	}
+
+	// The same key opens the project again
+	reopened, err := NewNamespacedBackend(local, "payments", "payments-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Read("state.json"); err != nil || !bytes.Equal(got, state) {
+		t.Fatalf("Read = %q, %v, want %q", got, err, state)
+	}
+
+	// Another key is refused before it can read, lock or write
+	if _, err := NewNamespacedBackend(local, "payments", "search-key"); err == nil || !strings.Contains(err.Error(), "belongs to another key") {
+		t.Fatalf("error = %v, want a wrong key error", err)
+	}
+	if _, err := NewNamespacedBackend(local, "payments", ""); err == nil {
+		t.Fatal("expected an error without a key")
+	}
+
+	// A document copied from another project does not decrypt
+	search, err := NewNamespacedBackend(local, "search", "search-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := local.Write("projects/search/state.json", raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := search.Read("state.json"); err == nil {
+		t.Fatal("read a document of another project")
+	}
+
+	// So does one moved to another key of the same project
+	if err := local.Write("projects/payments/state.json.checkpoint", raw); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.Read("state.json.checkpoint"); err == nil {
+		t.Fatal("read a document moved to another key")
+	}
+
+	// Plaintext planted in the project is not taken for state
+	if err := local.Write("projects/payments/state.json", state); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := payments.Read("state.json"); err == nil || !strings.Contains(err.Error(), "not encrypted") {
+		t.Fatalf("error = %v, want a not encrypted error", err)
+	}
+
+	if got, err := payments.Read("missing.json"); err != nil || got != nil {
+		t.Fatalf("Read of a missing document = %q, %v, want nil", got, err)
+	}
+	if err := payments.Write(projectRecordKey, state); err == nil {
+		t.Fatal("overwrote the project record")
+	}
+}
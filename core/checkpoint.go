@@ -0,0 +1,95 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Checkpoint is the plan of a run in progress and the actions it completed
+// so far, saved next to the state after each action. A run that completes
+// removes it; one that fails or is interrupted leaves it for create --resume.
+type Checkpoint struct {
+	RunID      string       `json:"run_id"`
+	ConfigHash string       `json:"config_hash"`
+	StartedAt  time.Time    `json:"started_at"`
+	Actions    []*Action    `json:"actions"`
+	Completed  []ResourceID `json:"completed"`
+	// Triggers holds the resources to trigger at the end of the run, by
+	// host, with the resources that triggered them
+	Triggers map[ResourceID]map[string][]ResourceID `json:"triggers,omitempty"`
+}
+
+// NewCheckpoint returns the checkpoint of a run of plan that has not
+// completed any action yet
+func NewCheckpoint(runID string, plan *Plan) *Checkpoint {
+	return &Checkpoint{
+		RunID:      runID,
+		ConfigHash: plan.ConfigHash,
+		StartedAt:  time.Now(),
+		Actions:    plan.Actions,
+		Completed:  make([]ResourceID, 0),
+	}
+}
+
+// Remaining returns the plan of the actions the run has not completed, on
+// the resources of graph. It fails when one of them is not in graph.
+func (c *Checkpoint) Remaining(graph *Graph) (*Plan, error) {
+	completed := make(map[ResourceID]bool, len(c.Completed))
+	for _, id := range c.Completed {
+		completed[id] = true
+	}
+
+	plan := &Plan{
+		Actions:    make([]*Action, 0),
+		CreatedAt:  time.Now(),
+		Graph:      graph,
+		ConfigHash: c.ConfigHash,
+	}
+	for _, action := range c.Actions {
+		if completed[action.ResourceID] {
+			continue
+		}
+		if _, ok := graph.GetResource(action.ResourceID); !ok {
+			return nil, fmt.Errorf("resource %s of run %s is neither declared nor in the state", action.ResourceID, c.RunID)
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+	return plan, nil
+}
+
+func (s *StateManager) checkpointKey() string {
+	return s.key + ".checkpoint"
+}
+
+// LoadCheckpoint returns the checkpoint left by a run that did not complete,
+// or nil
+func (s *StateManager) LoadCheckpoint() (*Checkpoint, error) {
+	data, err := s.backend.Read(s.checkpointKey())
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal checkpoint: %w", err)
+	}
+	return &checkpoint, nil
+}
+
+// SaveCheckpoint saves the progress of the current run
+func (s *StateManager) SaveCheckpoint(checkpoint *Checkpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	return s.backend.Write(s.checkpointKey(), data)
+}
+
+// ClearCheckpoint removes the checkpoint once its run completed
+func (s *StateManager) ClearCheckpoint() error {
+	return s.backend.Delete(s.checkpointKey())
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointRemaining(t *testing.T) {
+	checkpoint := &Checkpoint{
+		RunID: "run1",
+		Actions: []*Action{
+			{Type: ActionCreate, ResourceID: "package:apt:nginx"},
+			{Type: ActionCreate, ResourceID: "service:nginx"},
+			{Type: ActionDelete, ResourceID: "file:/etc/motd"},
+		},
+		Completed: []ResourceID{"package:apt:nginx"},
+	}
+
+	graph := buildGraph(t, []Resource{
+		testResource("package:apt:nginx", LayerPlatform),
+		testResource("service:nginx", LayerApplication, dependsOn("package:apt:nginx")),
+	})
+	if _, err := checkpoint.Remaining(graph); err == nil || !strings.Contains(err.Error(), "file:/etc/motd") {
+		t.Fatalf("error = %v, want one naming file:/etc/motd", err)
+	}
+
+	// The orphan is back once it is rebuilt from the state
+	if err := graph.AddResource(testResource("file:/etc/motd", LayerConfiguration)); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := checkpoint.Remaining(graph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []ResourceID
+	for _, action := range plan.Actions {
+		got = append(got, action.ResourceID)
+	}
+	if want := []ResourceID{"service:nginx", "file:/etc/motd"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining = %v, want %v", got, want)
+	}
+}
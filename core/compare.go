@@ -0,0 +1,144 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Kinds of discrepancy between a plan and what a run did
+const (
+	// DiscrepancyUnexpectedNoOp is an update whose host already matched the
+	// configuration, i.e. drift detection reported a change that was not
+	// there. Retries of failed resources are not flagged.
+	DiscrepancyUnexpectedNoOp = "unexpected-no-op"
+	// DiscrepancyUnexpectedChange is a no-op whose host did not match the
+	// configuration, i.e. drift detection missed a change
+	DiscrepancyUnexpectedChange = "unexpected-change"
+	// DiscrepancyNotConverged is a create or update that succeeded but left
+	// the host still differing from the configuration
+	DiscrepancyNotConverged = "not-converged"
+)
+
+// Discrepancy is a difference between what the plan predicted for a resource
+// on a host and what the run observed there
+type Discrepancy struct {
+	ResourceID ResourceID `json:"resource_id"`
+	Host       string     `json:"host"`
+	Planned    ActionType `json:"planned"`
+	Kind       string     `json:"kind"`
+	Message    string     `json:"message"`
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("%s on %s (planned %s): %s", d.ResourceID, d.Host, d.Planned, d.Message)
+}
+
+// PlanComparison reads every resource on its hosts around its action and
+// records where the outcome differs from the plan's prediction. Resources
+// that cannot be read are not compared.
+type PlanComparison struct {
+	mu            sync.Mutex
+	discrepancies []Discrepancy
+}
+
+func NewPlanComparison() *PlanComparison {
+	return &PlanComparison{}
+}
+
+// Middleware returns the middleware that performs the comparison
+func (c *PlanComparison) Middleware() Middleware {
+	return func(next ActionHandler) ActionHandler {
+		return func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+			if action.Type == ActionDelete {
+				return next(ctx, action, resource, resourceCtx)
+			}
+
+			before, readable := observe(resource, resourceCtx)
+			if err := next(ctx, action, resource, resourceCtx); err != nil {
+				return err
+			}
+			if !readable {
+				return nil
+			}
+
+			switch action.Type {
+			case ActionNoOp:
+				// A no-op for known drift (on_drift = notify) is expected to differ
+				drift, _ := action.Metadata["drift"].(bool)
+				if !drift && before != "" {
+					c.record(action, resourceCtx, DiscrepancyUnexpectedChange, "plan predicted no change but host differs: "+before)
+				}
+			case ActionCreate, ActionUpdate:
+				retry, _ := action.Metadata["retry"].(bool)
+				if action.Type == ActionUpdate && !retry && before == "" {
+					c.record(action, resourceCtx, DiscrepancyUnexpectedNoOp, "host already matched the configuration before the update")
+				}
+				if after, ok := observe(resource, resourceCtx); ok && after != "" {
+					c.record(action, resourceCtx, DiscrepancyNotConverged, "host still differs after apply: "+after)
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// observe reads resource on the host of ctx and describes how it differs
+// from the configuration, or returns "" if it matches. ok is false when the
+// resource cannot be read.
+func observe(resource Resource, ctx *inventory.Context) (difference string, ok bool) {
+	observed, err := resource.Read(ctx)
+	if err != nil {
+		ctx.Logger.Debug(fmt.Sprintf("Not comparing %s on %s with the plan: %v", resource.GetID(), ctx.Host.Name, err))
+		return "", false
+	}
+	if observed == nil {
+		return "resource is missing", true
+	}
+
+	changes := DiffConfig(resource.GetConfig(), observed)
+	if len(changes) == 0 {
+		return "", true
+	}
+	sensitive, _ := resource.(SensitiveResource)
+	fields := make([]string, 0, len(changes))
+	for _, change := range changes {
+		oldValue, newValue := fmt.Sprint(change.OldValue), fmt.Sprint(change.NewValue)
+		if sensitive != nil && sensitive.IsSensitive(change.Field) {
+			oldValue, newValue = MaskValue(oldValue), MaskValue(newValue)
+		}
+		fields = append(fields, fmt.Sprintf("%s is %s, want %s", change.Field, oldValue, newValue))
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ", "), true
+}
+
+func (c *PlanComparison) record(action *Action, ctx *inventory.Context, kind, message string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.discrepancies = append(c.discrepancies, Discrepancy{
+		ResourceID: action.ResourceID,
+		Host:       ctx.Host.Name,
+		Planned:    action.Type,
+		Kind:       kind,
+		Message:    message,
+	})
+}
+
+// Discrepancies returns the recorded discrepancies sorted by resource and host
+func (c *PlanComparison) Discrepancies() []Discrepancy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := append([]Discrepancy(nil), c.discrepancies...)
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].ResourceID != result[j].ResourceID {
+			return result[i].ResourceID < result[j].ResourceID
+		}
+		return result[i].Host < result[j].Host
+	})
+	return result
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// execution is the progress of a run, shared by the actions of a batch
+type execution struct {
+	result *ExecutionResult
+	// failed holds the resources whose action failed or was skipped, with
+	// the failure that caused it; the actions waiting on them are skipped
+	failed  map[ResourceID]ResourceID
+	failure error                  // First failed action; the run carries on without what waits on it
+	stopped error                  // Stops the run before the next action
+	hosts   map[string]*sync.Mutex // Held by the action running on a host
+	started int                    // Actions started or skipped so far
+	total   int
+}
+
+// batches splits the actions of plan into batches run one after the other.
+// With a concurrency of 1 every action is a batch of its own, in plan order.
+// Otherwise a batch holds actions none of which waits on another, the
+// slowest first by the durations recorded in state.
+func (e *Executor) batches(plan *Plan) [][]*Action {
+	if e.concurrency <= 1 {
+		batches := make([][]*Action, len(plan.Actions))
+		for i, action := range plan.Actions {
+			batches[i] = []*Action{action}
+		}
+		return batches
+	}
+
+	planned := make(map[ResourceID]bool, len(plan.Actions))
+	for _, action := range plan.Actions {
+		planned[action.ResourceID] = true
+	}
+	batchOf := make(map[ResourceID]int, len(plan.Actions))
+
+	// earliest returns the first batch an action waiting on id can run in;
+	// resources without an action pass on what they wait on themselves
+	var earliest func(actionType ActionType, id ResourceID, seen map[ResourceID]bool) int
+	earliest = func(actionType ActionType, id ResourceID, seen map[ResourceID]bool) int {
+		n := 0
+		for _, prerequisite := range e.graph.Prerequisites(actionType, id) {
+			if batch, ok := batchOf[prerequisite]; ok {
+				n = max(n, batch+1)
+			} else if !planned[prerequisite] && !seen[prerequisite] {
+				seen[prerequisite] = true
+				n = max(n, earliest(actionType, prerequisite, seen))
+			}
+		}
+		return n
+	}
+
+	var batches [][]*Action
+	for _, action := range plan.Actions {
+		n := earliest(action.Type, action.ResourceID, map[ResourceID]bool{action.ResourceID: true})
+		batchOf[action.ResourceID] = n
+		if n == len(batches) {
+			batches = append(batches, nil)
+		}
+		batches[n] = append(batches[n], action)
+	}
+
+	for _, batch := range batches {
+		sort.SliceStable(batch, func(i, j int) bool {
+			return e.stateManager.DurationHint(batch[i].ResourceID) > e.stateManager.DurationHint(batch[j].ResourceID)
+		})
+	}
+	return batches
+}
+
+// runBatch runs the actions of batch, at most e.concurrency at a time, and
+// returns the error stopping the run, if any. Actions waiting on one that
+// failed earlier are skipped.
+func (e *Executor) runBatch(ctx context.Context, run *execution, batch []*Action) error {
+	sem := make(chan struct{}, e.concurrency)
+	var wg sync.WaitGroup
+
+	for _, action := range batch {
+		sem <- struct{}{}
+		if err := e.stopBefore(ctx, run, action); err != nil {
+			<-sem
+			break
+		}
+
+		e.mu.Lock()
+		blocker, cause := e.blockedBy(action, run.failed)
+		if blocker != "" {
+			run.started++
+			run.result.Actions = append(run.result.Actions, e.skipAction(action, blocker, cause))
+			run.failed[action.ResourceID] = cause
+		}
+		e.mu.Unlock()
+		if blocker != "" {
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(action *Action) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			e.runScheduled(ctx, run, action)
+		}(action)
+	}
+	wg.Wait()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return run.stopped
+}
+
+// stopBefore stops the run before action when it is cancelled, the guard
+// fails or an action was interrupted, and returns why
+func (e *Executor) stopBefore(ctx context.Context, run *execution, action *Action) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if run.stopped != nil {
+		return run.stopped
+	}
+
+	if err := ctx.Err(); err != nil {
+		run.result.FailedAt = time.Now()
+		run.result.Error = err
+		run.result.Interrupted = errors.Is(err, context.Canceled)
+		run.stopped = fmt.Errorf("execution stopped before action %s: %w", action.ResourceID, err)
+	} else if e.guard != nil {
+		if err := e.guard(); err != nil {
+			run.result.FailedAt = time.Now()
+			run.result.Error = err
+			run.stopped = fmt.Errorf("execution stopped before action %s: %w", action.ResourceID, err)
+		}
+	}
+	return run.stopped
+}
+
+// runScheduled runs action once its hosts are free and records the outcome
+func (e *Executor) runScheduled(ctx context.Context, run *execution, action *Action) {
+	release := e.holdHosts(run, action)
+	defer release()
+
+	e.mu.Lock()
+	run.started++
+	position := run.started
+	e.mu.Unlock()
+	e.logger.Info(fmt.Sprintf("Executing action %d/%d: %s", position, run.total, action.ResourceID))
+
+	execAction, err := e.executeAction(ctx, action)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	run.result.Actions = append(run.result.Actions, execAction)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			if run.stopped == nil {
+				run.result.FailedAt = time.Now()
+				run.result.Error = err
+				run.result.Interrupted = true
+				run.stopped = fmt.Errorf("execution interrupted at action %s: %w", action.ResourceID, err)
+			}
+			return
+		}
+		// Carry on with the actions that do not depend on this one
+		run.failed[action.ResourceID] = action.ResourceID
+		if run.failure == nil {
+			run.result.Error = err
+			run.failure = fmt.Errorf("execution failed at action %s: %w", action.ResourceID, err)
+		}
+		return
+	}
+	e.saveCheckpoint(action)
+}
+
+// holdHosts waits until no other action runs on the target hosts of action
+// and holds them until the returned function is called, so two package
+// installs do not contend for the package manager of a host. Hosts are taken
+// in name order so actions sharing several hosts cannot deadlock.
+func (e *Executor) holdHosts(run *execution, action *Action) func() {
+	resource, ok := e.graph.GetResource(action.ResourceID)
+	if !ok || e.concurrency <= 1 {
+		return func() {}
+	}
+
+	var names []string
+	for _, host := range TargetHosts(resource, e.hosts) {
+		names = append(names, host.Name)
+	}
+	sort.Strings(names)
+
+	var held []*sync.Mutex
+	for i, name := range names {
+		lock, ok := run.hosts[name]
+		if !ok || (i > 0 && name == names[i-1]) {
+			continue
+		}
+		lock.Lock()
+		held = append(held, lock)
+	}
+	return func() {
+		for _, lock := range held {
+			lock.Unlock()
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// conditions caches parsed when conditions by their text
+var conditions sync.Map
+
+func parseCondition(text string) (*common.Condition, error) {
+	if cached, ok := conditions.Load(text); ok {
+		return cached.(*common.Condition), nil
+	}
+	condition, err := common.ParseCondition(text)
+	if err != nil {
+		return nil, err
+	}
+	conditions.Store(text, condition)
+	return condition, nil
+}
+
+// conditionHolds reports whether the when condition holds for host; a
+// condition that cannot be evaluated does not hold, and is reported by
+// CheckConditions before a run
+func conditionHolds(when string, host *common.Host) bool {
+	condition, err := parseCondition(when)
+	if err != nil {
+		return false
+	}
+	holds, err := condition.Eval(host)
+	return err == nil && holds
+}
+
+// NeedsFacts reports whether a when condition of resources reads the facts
+// of hosts
+func NeedsFacts(resources []Resource) bool {
+	for _, resource := range resources {
+		if when := resource.GetTarget().When; when != "" {
+			if condition, err := parseCondition(when); err == nil && condition.UsesFacts() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckConditions evaluates the when condition of every resource for the
+// hosts it would otherwise target, so a condition comparing a missing fact
+// fails the plan instead of silently skipping the host
+func CheckConditions(resources []Resource, hosts map[string]*common.Host) error {
+	for _, resource := range resources {
+		target := resource.GetTarget()
+		if target.When == "" {
+			continue
+		}
+		condition, err := parseCondition(target.When)
+		if err != nil {
+			return fmt.Errorf("%s: %w", resource.GetID(), err)
+		}
+		target.When = ""
+		for _, host := range selectHosts(target, hosts) {
+			if _, err := condition.Eval(host); err != nil {
+				return fmt.Errorf("%s: %w", resource.GetID(), err)
+			}
+		}
+	}
+	return nil
+}
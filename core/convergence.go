@@ -0,0 +1,145 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ConvergenceStatus is what a daemon knows about its recent runs: the last
+// run and, for each host, the outcome of the last run that reached it. It
+// is safe for concurrent use and serves itself as JSON over HTTP.
+type ConvergenceStatus struct {
+	mu        sync.Mutex
+	StartedAt time.Time              `json:"started_at"`
+	Interval  string                 `json:"interval"`
+	Runs      int                    `json:"runs"`
+	NextRunAt time.Time              `json:"next_run_at,omitempty"`
+	LastRun   *ConvergenceRun        `json:"last_run,omitempty"`
+	Hosts     map[string]*HostStatus `json:"hosts"`
+}
+
+// ConvergenceRun is the outcome of one plan and apply of a daemon
+type ConvergenceRun struct {
+	RunID      string    `json:"run_id"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Changed    int       `json:"changed"`
+	Failed     int       `json:"failed"`
+	Drifted    []string  `json:"drifted,omitempty"`
+	Error      string    `json:"error,omitempty"` // the run could not plan or apply
+}
+
+// HostStatus is the outcome of the last run on a host
+type HostStatus struct {
+	LastRunID     string    `json:"last_run_id"`
+	LastRunAt     time.Time `json:"last_run_at"`
+	LastSuccessAt time.Time `json:"last_success_at,omitempty"` // last run without failed actions
+	Changed       int       `json:"changed"`
+	Failed        int       `json:"failed"`
+	Errors        []string  `json:"errors,omitempty"`
+}
+
+func NewConvergenceStatus(interval time.Duration) *ConvergenceStatus {
+	return &ConvergenceStatus{
+		StartedAt: time.Now(),
+		Interval:  interval.String(),
+		Hosts:     make(map[string]*HostStatus),
+	}
+}
+
+// Scheduled records when the next run starts
+func (s *ConvergenceStatus) Scheduled(at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.NextRunAt = at
+}
+
+// Record records a run on hosts. plan is nil if the run failed before
+// planning and result is nil if nothing was applied, e.g. because nothing
+// needed to change; err is why the run failed.
+func (s *ConvergenceStatus) Record(run ConvergenceRun, hosts []common.Host, plan *Plan, result *ExecutionResult, err error) {
+	if err != nil {
+		run.Error = err.Error()
+	}
+	run.Drifted = driftedResources(plan)
+	if len(run.Drifted) == 0 {
+		run.Drifted = nil
+	}
+
+	byHost := make(map[string]*HostStatus, len(hosts))
+	if plan != nil {
+		for _, host := range hosts {
+			byHost[host.Name] = &HostStatus{LastRunID: run.RunID, LastRunAt: run.FinishedAt}
+		}
+	}
+	if result != nil {
+		for _, execAction := range result.Actions {
+			if execAction.Error == nil && execAction.SkipReason == "" && execAction.Action.Type != ActionNoOp {
+				run.Changed++
+			}
+			if execAction.Error != nil {
+				run.Failed++
+			}
+			for _, hostResult := range execAction.Hosts {
+				host := byHost[hostResult.Host]
+				if host == nil {
+					continue
+				}
+				switch {
+				case hostResult.Error != nil:
+					host.Failed++
+					host.Errors = append(host.Errors, string(execAction.Action.ResourceID)+": "+hostResult.Error.Error())
+				case execAction.Action.Type != ActionNoOp:
+					host.Changed++
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Runs++
+	s.LastRun = &run
+	for name, host := range byHost {
+		if previous := s.Hosts[name]; previous != nil {
+			host.LastSuccessAt = previous.LastSuccessAt
+		}
+		if err == nil && host.Failed == 0 {
+			host.LastSuccessAt = run.FinishedAt
+		}
+		s.Hosts[name] = host
+	}
+}
+
+// ServeHTTP responds with the status as JSON
+func (s *ConvergenceStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// ServeHealth responds 200 when the last run succeeded and 503 when it
+// failed or there has been none yet
+func (s *ConvergenceStatus) ServeHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	last := s.LastRun
+	s.mu.Unlock()
+	switch {
+	case last == nil:
+		http.Error(w, "no run yet", http.StatusServiceUnavailable)
+	case last.Error != "" || last.Failed > 0:
+		http.Error(w, "last run failed", http.StatusServiceUnavailable)
+	default:
+		w.Write([]byte("ok\n"))
+	}
+}
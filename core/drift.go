@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// DriftStrategy decides what the plan does with a resource that changed on
+// its host. Returning an error fails the plan.
+type DriftStrategy interface {
+	PlanDrift(resource Resource, state *ResourceState) (*Action, error)
+}
+
+// DriftStrategyFunc adapts a function to DriftStrategy
+type DriftStrategyFunc func(resource Resource, state *ResourceState) (*Action, error)
+
+func (f DriftStrategyFunc) PlanDrift(resource Resource, state *ResourceState) (*Action, error) {
+	return f(resource, state)
+}
+
+// DriftHandled is implemented by resources that choose a drift strategy
+type DriftHandled interface {
+	GetOnDrift() string
+}
+
+var (
+	driftStrategiesMu sync.RWMutex
+	driftStrategies   = map[string]DriftStrategy{
+		common.DriftRemediate: DriftStrategyFunc(remediateDrift),
+		common.DriftNotify:    DriftStrategyFunc(notifyDrift),
+		common.DriftFail:      DriftStrategyFunc(failDrift),
+	}
+)
+
+// RegisterDriftStrategy adds or replaces the strategy selected by name
+// with `on_drift = "name"`
+func RegisterDriftStrategy(name string, strategy DriftStrategy) {
+	driftStrategiesMu.Lock()
+	defer driftStrategiesMu.Unlock()
+	driftStrategies[name] = strategy
+}
+
+// DriftStrategies returns the names of the registered strategies
+func DriftStrategies() []string {
+	driftStrategiesMu.RLock()
+	defer driftStrategiesMu.RUnlock()
+
+	names := make([]string, 0, len(driftStrategies))
+	for name := range driftStrategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// driftStrategyFor returns the strategy of resource; resources that choose
+// none are remediated
+func driftStrategyFor(resource Resource) (DriftStrategy, error) {
+	name := common.DriftRemediate
+	if handled, ok := resource.(DriftHandled); ok && handled.GetOnDrift() != "" {
+		name = handled.GetOnDrift()
+	}
+
+	driftStrategiesMu.RLock()
+	defer driftStrategiesMu.RUnlock()
+	strategy, ok := driftStrategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown on_drift strategy %q (available: %v)", name, DriftStrategies())
+	}
+	return strategy, nil
+}
+
+// remediateDrift re-applies the resource
+func remediateDrift(resource Resource, state *ResourceState) (*Action, error) {
+	return &Action{
+		ResourceID: resource.GetID(),
+		Type:       ActionUpdate,
+		Changes:    driftChanges(state),
+		Metadata: map[string]interface{}{
+			"reason": "drift detected on host",
+			"drift":  true,
+		},
+	}, nil
+}
+
+// notifyDrift leaves the resource as it is on the host; the drift is still
+// reported in the plan and in notifications
+func notifyDrift(resource Resource, state *ResourceState) (*Action, error) {
+	return &Action{
+		ResourceID: resource.GetID(),
+		Type:       ActionNoOp,
+		Changes:    driftChanges(state),
+		Metadata: map[string]interface{}{
+			"reason": "drift detected on host, not remediated (on_drift = notify)",
+			"drift":  true,
+		},
+	}, nil
+}
+
+// failDrift stops the run so someone decides how to reconcile the host
+func failDrift(resource Resource, state *ResourceState) (*Action, error) {
+	return nil, fmt.Errorf("%s drifted on its host and on_drift = fail", resource.GetID())
+}
+
+// driftChanges returns the changes recorded when the drift was detected,
+// whether still in memory or loaded back from the state file
+func driftChanges(state *ResourceState) []Change {
+	changes := []Change{}
+	switch recorded := state.Metadata["drift"].(type) {
+	case []Change:
+		return recorded
+	case nil:
+	default:
+		if data, err := json.Marshal(recorded); err == nil {
+			_ = json.Unmarshal(data, &changes)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// EventType names a lifecycle event of a plan or run
+type EventType string
+
+// Lifecycle events, in the order they happen
+const (
+	EventPlanStarted     EventType = "plan_started"
+	EventDriftDetected   EventType = "drift_detected" // a resource was found changed on a host while planning
+	EventPlanFinished    EventType = "plan_finished"
+	EventRunStarted      EventType = "run_started"
+	EventActionStarted   EventType = "action_started" // an action starts on one of its hosts
+	EventActionCompleted EventType = "action_completed"
+	EventRunFinished     EventType = "run_finished"
+)
+
+// Event is something that happened while planning or running. Only the
+// fields of its type are set.
+type Event struct {
+	Type       EventType
+	At         time.Time
+	RunID      string           // run and action events
+	Plan       *Plan            // plan_finished and run_started
+	Action     *Action          // action events
+	ResourceID ResourceID       // action and drift events
+	Host       string           // action and drift events
+	Error      error            // action_completed: the action failed on Host
+	Changes    []Change         // drift_detected
+	Result     *ExecutionResult // run_finished
+}
+
+// EventBus delivers events to its subscribers, in the order they
+// subscribed. Events are delivered synchronously, and action events of
+// different hosts concurrently, so subscribers must be quick and safe for
+// concurrent use. A nil bus drops events.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe calls fn with every event published from now on until the
+// returned function is called
+func (b *EventBus) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &subscriber{fn: fn}
+	b.subscribers = append(b.subscribers, sub)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subscribers {
+			if s == sub {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish delivers event to every subscriber, stamping it with the current
+// time unless it has one
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	b.mu.Lock()
+	subscribers := b.subscribers
+	b.mu.Unlock()
+	for _, sub := range subscribers {
+		sub.fn(event)
+	}
+}
+
+// LogEvents returns a subscriber that logs when actions start and finish on
+// each host, with their duration, at debug level
+func LogEvents(logger *inventory.Logger) func(Event) {
+	var mu sync.Mutex
+	started := make(map[string]time.Time)
+	return func(event Event) {
+		key := fmt.Sprintf("%s@%s", event.ResourceID, event.Host)
+		switch event.Type {
+		case EventActionStarted:
+			mu.Lock()
+			started[key] = event.At
+			mu.Unlock()
+			logger.Debug(fmt.Sprintf("Started %s of %s on %s", event.Action.Type, event.ResourceID, event.Host))
+		case EventActionCompleted:
+			mu.Lock()
+			elapsed := event.At.Sub(started[key]).Round(time.Millisecond)
+			delete(started, key)
+			mu.Unlock()
+			if event.Error != nil {
+				logger.Debug(fmt.Sprintf("%s of %s failed on %s after %v", event.Action.Type, event.ResourceID, event.Host, elapsed))
+				return
+			}
+			logger.Debug(fmt.Sprintf("Finished %s of %s on %s in %v", event.Action.Type, event.ResourceID, event.Host, elapsed))
+		}
+	}
+}
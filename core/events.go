@@ -0,0 +1,112 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StateRemoved is the To status of a transition for a destroyed resource
+const StateRemoved StateStatus = "removed"
+
+// StateTransition describes a resource changing status in state, e.g.
+// pending→applied, applied→drifted or applied→failed
+type StateTransition struct {
+	ResourceID ResourceID  `json:"resource_id"`
+	From       StateStatus `json:"from"`
+	To         StateStatus `json:"to"`
+	At         time.Time   `json:"at"`
+	Project    string      `json:"project,omitempty"`
+}
+
+// TransitionSink delivers state transitions to an external system
+type TransitionSink interface {
+	Send(transition StateTransition) error
+}
+
+// NewTransitionSink creates a sink from a URL: http(s)://... posts JSON to a
+// webhook, nats://host:port/subject publishes to a NATS subject
+func NewTransitionSink(rawURL string) (TransitionSink, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid events URL: %w", err)
+	}
+
+	switch parsed.Scheme {
+	case "http", "https":
+		return &WebhookSink{URL: rawURL, Client: &http.Client{Timeout: 5 * time.Second}}, nil
+	case "nats":
+		subject := strings.TrimPrefix(parsed.Path, "/")
+		if subject == "" {
+			subject = "settle.transitions"
+		}
+		return &NATSSink{Address: parsed.Host, Subject: subject}, nil
+	default:
+		return nil, fmt.Errorf("unsupported events URL scheme: %s", parsed.Scheme)
+	}
+}
+
+// WebhookSink POSTs each transition as JSON
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *WebhookSink) Send(transition StateTransition) error {
+	body, err := json.Marshal(transition)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transition: %w", err)
+	}
+
+	resp, err := w.Client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post transition: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// NATSSink publishes each transition as JSON using the NATS text protocol
+type NATSSink struct {
+	Address string
+	Subject string
+}
+
+func (n *NATSSink) Send(transition StateTransition) error {
+	body, err := json.Marshal(transition)
+	if err != nil {
+		return fmt.Errorf("failed to marshal transition: %w", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", n.Address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// PING after PUB makes the server answer once the message is processed
+	message := fmt.Sprintf("CONNECT {\"verbose\":false}\r\nPUB %s %d\r\n%s\r\nPING\r\n", n.Subject, len(body), body)
+	if _, err := conn.Write([]byte(message)); err != nil {
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+
+	reply := make([]byte, 4096)
+	read, err := conn.Read(reply)
+	if err != nil {
+		return fmt.Errorf("no reply from NATS: %w", err)
+	}
+	if strings.Contains(string(reply[:read]), "-ERR") {
+		return fmt.Errorf("NATS rejected message: %s", strings.TrimSpace(string(reply[:read])))
+	}
+	return nil
+}
@@ -2,11 +2,16 @@ package core
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/settlectl/settle-core/inventory"
 	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/ssh"
 )
 
 // Executor executes planned actions in dependency order
@@ -15,57 +20,181 @@ type Executor struct {
 	stateManager *StateManager
 	logger       *inventory.Logger
 	hosts        map[string]*common.Host // Map of host names to host objects
+	allHosts     map[string]*common.Host // Every host of the inventory, of which hosts may be a subset
+	middleware   []Middleware
+	parallelism  int          // Maximum hosts an action runs on concurrently
+	concurrency  int          // Maximum independent actions run at once
+	guard        func() error // Checked before every action; an error stops the run
+	runID        string
+	connections  *inventory.ConnectionPool
+	workspace    *inventory.Workspace // Remote temp directory of the current run
+	triggers     *triggers            // Resources to trigger at the end of the run
+	checkpoint   *Checkpoint          // Progress saved after every action, if set
+	events       *EventBus            // Lifecycle events of the run
+	mu           sync.Mutex           // Guards the state and results of concurrent actions
 }
 
 func NewExecutor(graph *Graph, stateManager *StateManager, logger *inventory.Logger) *Executor {
-	return &Executor{
+	e := &Executor{
 		graph:        graph,
 		stateManager: stateManager,
 		logger:       logger,
 		hosts:        make(map[string]*common.Host),
+		middleware:   []Middleware{WaitForMiddleware(), HookMiddleware()},
+		parallelism:  ssh.MaxConnections,
+		concurrency:  1,
+		runID:        newRunID(),
+		triggers:     newTriggers(),
+		events:       NewEventBus(),
 	}
+	e.events.Subscribe(LogEvents(logger))
+	return e
+}
+
+// newRunID returns the ID of the current invocation, or a new one outside of
+// the CLI
+func newRunID() string {
+	if id := inventory.RunID(); id != "" {
+		return id
+	}
+	return inventory.NewRunID()
+}
+
+// RunID returns the identifier of the executor's run
+func (e *Executor) RunID() string {
+	return e.runID
 }
 
 // SetHosts sets the hosts available for execution
 func (e *Executor) SetHosts(hosts []common.Host) {
-	e.hosts = make(map[string]*common.Host)
+	e.hosts = HostMap(hosts)
+}
+
+// SetInventory sets every host of the inventory when the run is limited to
+// some of them. A resource deleted on the run's hosts only stays in state.
+func (e *Executor) SetInventory(hosts []common.Host) {
+	e.allHosts = HostMap(hosts)
+}
+
+// HostMap indexes hosts by name
+func HostMap(hosts []common.Host) map[string]*common.Host {
+	result := make(map[string]*common.Host)
 	for i := range hosts {
-		e.hosts[hosts[i].Name] = &hosts[i]
+		result[hosts[i].Name] = &hosts[i]
+	}
+	return result
+}
+
+// SetParallelism sets how many hosts an action runs on concurrently
+func (e *Executor) SetParallelism(n int) {
+	if n < 1 {
+		n = 1
+	}
+	e.parallelism = n
+}
+
+// SetConcurrency sets how many independent actions run at once. Above 1,
+// the actions of each execution wave run concurrently, the slowest first.
+func (e *Executor) SetConcurrency(n int) {
+	if n < 1 {
+		n = 1
 	}
+	e.concurrency = n
+}
+
+// SetGuard sets a check run before every action, e.g. that the configuration
+// the plan was made from is unchanged. An error stops the run before the
+// action starts.
+func (e *Executor) SetGuard(guard func() error) {
+	e.guard = guard
+}
+
+// SetCheckpoint makes the run save its progress to checkpoint after every
+// action, so it can be resumed if it does not complete. Triggers recorded in
+// checkpoint by an earlier attempt fire at the end of the run.
+func (e *Executor) SetCheckpoint(checkpoint *Checkpoint) {
+	e.checkpoint = checkpoint
+	e.triggers.restore(checkpoint.Triggers)
+}
+
+// Events returns the bus the executor publishes the lifecycle events of its
+// runs to
+func (e *Executor) Events() *EventBus {
+	return e.events
+}
+
+// Use appends middleware wrapped around every action, outermost first
+func (e *Executor) Use(middleware ...Middleware) {
+	e.middleware = append(e.middleware, middleware...)
 }
 
 // Execute runs a complete execution plan
 func (e *Executor) Execute(ctx context.Context, plan *Plan) (*ExecutionResult, error) {
 	result := &ExecutionResult{
+		RunID:     e.runID,
 		Plan:      plan,
 		StartedAt: time.Now(),
 		Actions:   make([]*ExecutionAction, 0),
 	}
+	ctx = inventory.WithRunID(ctx, e.runID)
+	e.stateManager.SetRunID(e.runID)
 
 	// Validate the plan before execution
 	if err := plan.ValidatePlan(); err != nil {
 		return nil, fmt.Errorf("plan validation failed: %w", err)
 	}
 
-	e.logger.Info("Starting execution of plan")
-	e.logger.Info(fmt.Sprintf("Plan contains %d actions", len(plan.Actions)))
+	e.logger.Info(fmt.Sprintf("Starting execution of plan (run %s)", e.runID))
+	e.events.Publish(Event{Type: EventRunStarted, RunID: e.runID, Plan: plan})
+	defer func() {
+		e.events.Publish(Event{Type: EventRunFinished, RunID: e.runID, Plan: plan, Result: result})
+	}()
 
-	// Execute actions in order
-	for i, action := range plan.Actions {
-		e.logger.Info(fmt.Sprintf("Executing action %d/%d: %s", i+1, len(plan.Actions), action.ResourceID))
+	// Actions share a connection per host, closed once the workspace is gone
+	e.connections = inventory.NewConnectionPool()
+	defer e.connections.Close()
 
-		execAction, err := e.executeAction(ctx, action)
-		if err != nil {
-			result.FailedAt = time.Now()
-			result.Error = err
-			return result, fmt.Errorf("execution failed at action %s: %w", action.ResourceID, err)
+	// The workspace is removed even when the run fails or is interrupted
+	e.workspace = inventory.NewWorkspace(e.runID)
+	defer e.cleanupWorkspace(ctx)
+	e.logger.Info(fmt.Sprintf("Plan contains %d actions", len(plan.Actions)))
+	e.saveCheckpoint(nil)
+
+	// Execute actions in order, a batch at a time
+	run := &execution{
+		result: result,
+		failed: make(map[ResourceID]ResourceID),
+		hosts:  make(map[string]*sync.Mutex, len(e.hosts)),
+		total:  len(plan.Actions),
+	}
+	for name := range e.hosts {
+		run.hosts[name] = &sync.Mutex{}
+	}
+	for _, batch := range e.batches(plan) {
+		if err := e.runBatch(ctx, run, batch); err != nil {
+			return result, err
 		}
+	}
+
+	if run.failure != nil {
+		result.FailedAt = time.Now()
+		e.triggers.warnSkipped(e.logger)
+		return result, run.failure
+	}
 
-		result.Actions = append(result.Actions, execAction)
+	if err := e.runTriggers(ctx, plan); err != nil {
+		result.FailedAt = time.Now()
+		result.Error = err
+		return result, err
 	}
 
 	result.CompletedAt = time.Now()
 	result.Success = true
+	if e.checkpoint != nil {
+		if err := e.stateManager.ClearCheckpoint(); err != nil {
+			e.logger.Warning(fmt.Sprintf("Failed to remove the checkpoint of the run: %v", err))
+		}
+	}
 	e.logger.Info("Execution completed successfully")
 
 	return result, nil
@@ -86,42 +215,73 @@ func (e *Executor) executeAction(ctx context.Context, action *Action) (*Executio
 		return execAction, execAction.Error
 	}
 
-	// Create context for the resource
-	resourceCtx := e.createResourceContext(resource)
+	targets := TargetHosts(resource, e.hosts)
+	if len(targets) == 0 && action.Type != ActionNoOp {
+		execAction.FailedAt = time.Now()
+		execAction.Error = fmt.Errorf("no hosts match the target of %s", action.ResourceID)
+		return execAction, execAction.Error
+	}
 
-	// Execute based on action type
-	var err error
-	switch action.Type {
-	case ActionCreate:
-		err = resource.Apply(resourceCtx)
-	case ActionUpdate:
-		err = resource.Apply(resourceCtx)
-	case ActionDelete:
-		err = resource.Destroy(resourceCtx)
-	case ActionNoOp:
-		e.logger.Info(fmt.Sprintf("Skipping %s (no-op)", action.ResourceID))
+	// Execute through the middleware chain on every target host in parallel
+	handler := chain(e.runAction, e.middleware)
+	execAction.Hosts = e.fanOut(targets, func(host *common.Host) error {
+		actionCtx := ctx
+		if timeout := resource.GetTimeout(); timeout > 0 {
+			var cancel context.CancelFunc
+			actionCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		e.events.Publish(Event{Type: EventActionStarted, RunID: e.runID, Action: action, ResourceID: action.ResourceID, Host: host.Name})
+		err := handler(actionCtx, action, resource, e.createResourceContext(actionCtx, host))
+		e.events.Publish(Event{Type: EventActionCompleted, RunID: e.runID, Action: action, ResourceID: action.ResourceID, Host: host.Name, Error: err})
+		if err != nil && errors.Is(actionCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			return fmt.Errorf("timed out after %v: %w", resource.GetTimeout(), err)
+		}
+		return err
+	})
+
+	err := execAction.hostError()
+	if err == nil && action.Type == ActionNoOp {
 		execAction.CompletedAt = time.Now()
 		return execAction, nil
-	default:
-		err = fmt.Errorf("unknown action type: %s", action.Type)
 	}
 
 	if err != nil {
 		execAction.FailedAt = time.Now()
 		execAction.Error = err
 
-		// Mark resource as failed in state
-		e.stateManager.MarkFailed(resource, err.Error())
+		// Mark resource as failed in state; an interrupted action leaves the
+		// host in an unknown state rather than a failed one
+		e.mu.Lock()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			e.stateManager.MarkInterrupted(resource)
+		} else {
+			e.stateManager.MarkFailed(resource, err.Error())
+		}
+		e.mu.Unlock()
 
 		return execAction, fmt.Errorf("action failed: %w", err)
 	}
 
-	// Mark resource as applied in state
-	err = e.stateManager.MarkApplied(resource)
+	// Record the outcome in state: destroyed resources are forgotten, unless
+	// hosts outside the run still have them
+	e.mu.Lock()
+	if action.Type == ActionDelete {
+		if outside := e.hostsOutsideRun(resource); len(outside) > 0 {
+			e.logger.Warning(fmt.Sprintf("%s stays in state: it was not deleted on %s", action.ResourceID, strings.Join(outside, ", ")))
+		} else {
+			err = e.stateManager.MarkDestroyed(resource)
+		}
+	} else {
+		e.triggers.notify(e.graph, resource, execAction.Hosts)
+		err = e.stateManager.MarkApplied(resource, time.Since(execAction.StartedAt))
+	}
+	e.mu.Unlock()
 	if err != nil {
 		execAction.FailedAt = time.Now()
 		execAction.Error = err
-		return execAction, fmt.Errorf("failed to mark resource as applied: %w", err)
+		return execAction, fmt.Errorf("failed to record resource in state: %w", err)
 	}
 
 	execAction.CompletedAt = time.Now()
@@ -130,52 +290,251 @@ func (e *Executor) executeAction(ctx context.Context, action *Action) (*Executio
 	return execAction, nil
 }
 
-// createResourceContext creates a context for resource execution
-func (e *Executor) createResourceContext(resource Resource) *inventory.Context {
-	// Create a basic context
-	ctx := &inventory.Context{
-		Logger: e.logger,
+// hostsOutsideRun returns the target hosts of resource the run does not
+// reach, e.g. those left out by --limit
+func (e *Executor) hostsOutsideRun(resource Resource) []string {
+	var outside []string
+	for _, host := range TargetHosts(resource, e.allHosts) {
+		if _, ok := e.hosts[host.Name]; !ok {
+			outside = append(outside, host.Name)
+		}
 	}
+	return outside
+}
 
-	// For host resources, set the host
+// saveCheckpoint records that action completed, if not nil, with the
+// triggers pending so far
+func (e *Executor) saveCheckpoint(action *Action) {
+	if e.checkpoint == nil {
+		return
+	}
+	if action != nil {
+		e.checkpoint.Completed = append(e.checkpoint.Completed, action.ResourceID)
+	}
+	e.checkpoint.Triggers = e.triggers.snapshot()
+	if err := e.stateManager.SaveCheckpoint(e.checkpoint); err != nil {
+		e.logger.Warning(fmt.Sprintf("Failed to save the checkpoint of the run: %v", err))
+	}
+}
+
+// blockedBy returns the resource action waits on, directly or through
+// others, whose own action failed or was skipped, with the failure that
+// caused it, or empty IDs
+func (e *Executor) blockedBy(action *Action, failed map[ResourceID]ResourceID) (blocker, cause ResourceID) {
+	if action.Type == ActionNoOp || len(failed) == 0 {
+		return "", ""
+	}
+
+	for _, id := range e.graph.AllPrerequisites(action.Type, action.ResourceID) {
+		if cause, ok := failed[id]; ok {
+			return id, cause
+		}
+	}
+	return "", ""
+}
+
+// skipAction records action as skipped because blocker failed or was skipped
+// after cause failed
+func (e *Executor) skipAction(action *Action, blocker, cause ResourceID) *ExecutionAction {
+	var reason string
+	switch {
+	case action.Type == ActionDelete:
+		reason = fmt.Sprintf("%s depends on it and was not removed", blocker)
+	case blocker == cause:
+		reason = fmt.Sprintf("dependency %s failed", blocker)
+	default:
+		reason = fmt.Sprintf("dependency %s was skipped after %s failed", blocker, cause)
+	}
+	e.logger.Warning(fmt.Sprintf("Skipping %s: %s", action.ResourceID, reason))
+
+	if resource, ok := e.graph.GetResource(action.ResourceID); ok {
+		if err := e.stateManager.MarkSkipped(resource, reason); err != nil {
+			e.logger.Warning(fmt.Sprintf("Failed to record %s as skipped: %v", action.ResourceID, err))
+		}
+	}
+	return &ExecutionAction{
+		Action:     action,
+		StartedAt:  time.Now(),
+		SkipReason: reason,
+	}
+}
+
+// runAction performs the action itself; it is the innermost ActionHandler
+func (e *Executor) runAction(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+	switch action.Type {
+	case ActionCreate:
+		return resource.Apply(resourceCtx)
+	case ActionUpdate:
+		return resource.Apply(resourceCtx)
+	case ActionDelete:
+		return resource.Destroy(resourceCtx)
+	case ActionNoOp:
+		e.logger.Info(fmt.Sprintf("Skipping %s on %s (no-op)", action.ResourceID, resourceCtx.Host.Name))
+		return nil
+	default:
+		return fmt.Errorf("unknown action type: %s", action.Type)
+	}
+}
+
+// fanOut runs fn for every host, at most e.parallelism at a time, and
+// collects the per-host outcomes in host order
+func (e *Executor) fanOut(hosts []*common.Host, fn func(host *common.Host) error) []*HostResult {
+	results := make([]*HostResult, len(hosts))
+	sem := make(chan struct{}, e.parallelism)
+	var wg sync.WaitGroup
+
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host *common.Host) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			hostResult := &HostResult{Host: host.Name, StartedAt: time.Now()}
+			if err := fn(host); err != nil {
+				hostResult.FailedAt = time.Now()
+				hostResult.Error = err
+			} else {
+				hostResult.CompletedAt = time.Now()
+			}
+			results[i] = hostResult
+		}(i, host)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// cleanupWorkspace removes the run's workspace from the hosts it was created
+// on, with its own deadline so an interrupted run still cleans up
+func (e *Executor) cleanupWorkspace(ctx context.Context) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), workspaceCleanupTimeout)
+	defer cancel()
+
+	if err := e.workspace.Cleanup(cleanupCtx, e.logger, e.connections); err != nil {
+		e.logger.Warning(err.Error())
+	}
+}
+
+// workspaceCleanupTimeout bounds removing the workspace at the end of a run
+const workspaceCleanupTimeout = 30 * time.Second
+
+// createResourceContext creates a context for executing a resource on host
+func (e *Executor) createResourceContext(ctx context.Context, host *common.Host) *inventory.Context {
+	return &inventory.Context{
+		Host:        host,
+		Logger:      e.logger,
+		Ctx:         ctx,
+		Workspace:   e.workspace,
+		Connections: e.connections,
+	}
+}
+
+// TargetHosts returns the hosts a resource applies to, sorted by name. Host
+// resources target themselves; other resources select hosts by name, alias or
+// group and default to every host, keeping those their when condition holds
+// for.
+func TargetHosts(resource Resource, hosts map[string]*common.Host) []*common.Host {
 	if hostResource, ok := resource.(*HostResource); ok {
-		ctx.SetHost(&hostResource.Host)
+		return []*common.Host{&hostResource.Host}
 	}
+	return selectHosts(resource.GetTarget(), hosts)
+}
 
-	// For package resources, try to find the host they should be installed on
-	// This is a simplified approach - in a real system, you'd have explicit dependencies
-	if _, ok := resource.(*PackageResource); ok {
-		// For now, use the first available host
-		// In a real system, you'd determine this from dependencies
-		for _, host := range e.hosts {
-			ctx.SetHost(host)
-			break
+// selectHosts returns the hosts target selects, sorted by name
+func selectHosts(target common.Target, hosts map[string]*common.Host) []*common.Host {
+	names := make(map[string]bool)
+	for _, name := range target.Hosts {
+		names[name] = true
+	}
+
+	var result []*common.Host
+	for _, host := range hosts {
+		switch {
+		case len(names) > 0 && !selectedByName(names, host):
+			continue
+		case target.Group != "" && host.Group != target.Group:
+			continue
+		case target.When != "" && !conditionHolds(target.When, host):
+			continue
 		}
+		result = append(result, host)
 	}
 
-	return ctx
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// selectedByName reports whether names holds the name or an alias of host
+func selectedByName(names map[string]bool, host *common.Host) bool {
+	if names[host.Name] {
+		return true
+	}
+	for _, alias := range host.Aliases {
+		if names[alias] {
+			return true
+		}
+	}
+	return false
 }
 
 // ExecutionResult represents the result of an execution
 type ExecutionResult struct {
+	RunID       string             `json:"run_id"`
 	Plan        *Plan              `json:"plan"`
 	StartedAt   time.Time          `json:"started_at"`
 	CompletedAt time.Time          `json:"completed_at,omitempty"`
 	FailedAt    time.Time          `json:"failed_at,omitempty"`
 	Success     bool               `json:"success"`
+	Interrupted bool               `json:"interrupted,omitempty"`
 	Error       error              `json:"error,omitempty"`
 	Actions     []*ExecutionAction `json:"actions"`
+	// Discrepancies between the plan and the outcome, if it was compared
+	Discrepancies []Discrepancy `json:"discrepancies,omitempty"`
 }
 
 // ExecutionAction represents the result of executing a single action
 type ExecutionAction struct {
-	Action      *Action   `json:"action"`
+	Action      *Action       `json:"action"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at,omitempty"`
+	FailedAt    time.Time     `json:"failed_at,omitempty"`
+	Error       error         `json:"error,omitempty"`
+	Hosts       []*HostResult `json:"hosts"`
+	// SkipReason tells why the action was skipped instead of run
+	SkipReason string `json:"skip_reason,omitempty"`
+}
+
+// HostResult represents the result of an action on one of its target hosts
+type HostResult struct {
+	Host        string    `json:"host"`
 	StartedAt   time.Time `json:"started_at"`
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	FailedAt    time.Time `json:"failed_at,omitempty"`
 	Error       error     `json:"error,omitempty"`
 }
 
+// hostError combines the errors of all failed hosts, or returns nil
+func (a *ExecutionAction) hostError() error {
+	var failed []string
+	var last *HostResult
+	for _, hostResult := range a.Hosts {
+		if hostResult.Error != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", hostResult.Host, hostResult.Error))
+			last = hostResult
+		}
+	}
+
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s: %w", last.Host, last.Error)
+	default:
+		return fmt.Errorf("failed on %d of %d hosts: %s", len(failed), len(a.Hosts), strings.Join(failed, "; "))
+	}
+}
+
 // GetDuration returns the total execution duration
 func (r *ExecutionResult) GetDuration() time.Duration {
 	if r.Success {
@@ -188,7 +547,7 @@ func (r *ExecutionResult) GetDuration() time.Duration {
 func (r *ExecutionResult) GetSuccessCount() int {
 	count := 0
 	for _, action := range r.Actions {
-		if action.Error == nil {
+		if action.Error == nil && action.SkipReason == "" {
 			count++
 		}
 	}
@@ -205,3 +564,30 @@ func (r *ExecutionResult) GetFailureCount() int {
 	}
 	return count
 }
+
+// GetSkippedCount returns the number of actions skipped because an action
+// they depend on failed
+func (r *ExecutionResult) GetSkippedCount() int {
+	count := 0
+	for _, action := range r.Actions {
+		if action.SkipReason != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// GetHostCounts returns the number of successful and failed per-host runs
+// across all actions
+func (r *ExecutionResult) GetHostCounts() (succeeded, failed int) {
+	for _, action := range r.Actions {
+		for _, hostResult := range action.Hosts {
+			if hostResult.Error != nil {
+				failed++
+			} else {
+				succeeded++
+			}
+		}
+	}
+	return succeeded, failed
+}
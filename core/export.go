@@ -0,0 +1,126 @@
+package core
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// CMDBRecord describes one managed resource on one host, for import into a
+// configuration management database
+type CMDBRecord struct {
+	ResourceID  ResourceID        `json:"resource_id"`
+	Host        string            `json:"host"`
+	Hostname    string            `json:"hostname"`
+	Type        string            `json:"type"`
+	Config      string            `json:"config"`
+	Owner       string            `json:"owner"`
+	Labels      map[string]string `json:"labels"`
+	Status      StateStatus       `json:"status"`
+	LastApplied time.Time         `json:"last_applied"`
+}
+
+// BuildCMDB lists every resource recorded in state once per target host.
+// Declared resources are preferred; resources only present in state are
+// rebuilt from it. Resources without matching hosts are listed without one.
+func BuildCMDB(states map[ResourceID]*ResourceState, graph *Graph, hosts map[string]*common.Host) []CMDBRecord {
+	resourceParser := NewResourceParser()
+	records := make([]CMDBRecord, 0)
+
+	for id, state := range states {
+		resource, declared := graph.GetResource(id)
+		if !declared {
+			var err error
+			if resource, err = resourceParser.ResourceFromState(id, state); err != nil {
+				_, localID := splitModuleID(id)
+				kind, _, _ := strings.Cut(string(localID), ":")
+				records = append(records, CMDBRecord{ResourceID: id, Type: kind, Status: state.Status, LastApplied: state.LastApplied})
+				continue
+			}
+		}
+
+		// Summarise what was applied rather than what is currently declared
+		config, _ := state.Metadata["config"].(map[string]interface{})
+		if config == nil {
+			config = resource.GetConfig()
+		}
+
+		record := CMDBRecord{
+			ResourceID:  id,
+			Type:        resource.GetType(),
+			Config:      SummarizeConfig(config),
+			Owner:       Owner(resource.GetLabels()),
+			Labels:      resource.GetLabels(),
+			Status:      state.Status,
+			LastApplied: state.LastApplied,
+		}
+
+		targets := TargetHosts(resource, hosts)
+		if len(targets) == 0 {
+			records = append(records, record)
+			continue
+		}
+		for _, host := range targets {
+			record.Host = host.Name
+			record.Hostname = host.Hostname
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].ResourceID != records[j].ResourceID {
+			return records[i].ResourceID < records[j].ResourceID
+		}
+		return records[i].Host < records[j].Host
+	})
+	return records
+}
+
+// SummarizeConfig renders a config map as sorted "key=value" pairs
+func SummarizeConfig(config map[string]interface{}) string {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, config[key]))
+	}
+	return strings.Join(pairs, "; ")
+}
+
+// WriteCMDBCSV writes records as CSV with a header row
+func WriteCMDBCSV(w io.Writer, records []CMDBRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"resource_id", "host", "hostname", "type", "config", "owner", "labels", "status", "last_applied"}); err != nil {
+		return err
+	}
+	for _, record := range records {
+		lastApplied := ""
+		if !record.LastApplied.IsZero() {
+			lastApplied = record.LastApplied.Format(time.RFC3339)
+		}
+		if err := writer.Write([]string{
+			string(record.ResourceID),
+			record.Host,
+			record.Hostname,
+			record.Type,
+			record.Config,
+			record.Owner,
+			FormatLabels(record.Labels),
+			string(record.Status),
+			lastApplied,
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"context"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// GatherFacts sets the facts of every host, connecting to them in parallel,
+// and returns the errors of the hosts it could not gather them from
+func GatherFacts(ctx context.Context, hosts []common.Host) map[string]error {
+	failed := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ssh.MaxConnections)
+
+	for i := range hosts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			host := hosts[i] // copy: connecting may fill in details from ~/.ssh/config
+			facts, err := gatherHostFacts(ctx, &host)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[host.Name] = err
+				return
+			}
+			hosts[i].Facts = facts
+		}(i)
+	}
+	wg.Wait()
+
+	return failed
+}
+
+func gatherHostFacts(ctx context.Context, host *common.Host) (map[string]string, error) {
+	transport, err := inventory.Connect(host)
+	if err != nil {
+		return nil, err
+	}
+	defer transport.Close()
+	return inventory.GatherFacts(ctx, transport)
+}
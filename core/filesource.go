@@ -0,0 +1,59 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+var sourceClient = &http.Client{Timeout: 60 * time.Second}
+
+// fetchedSources holds the content fetched for each source_url, so a file
+// applied to several hosts is downloaded once
+var fetchedSources = struct {
+	sync.Mutex
+	content map[string][]byte
+}{content: make(map[string][]byte)}
+
+// fetchSource downloads the content of a file's source_url and checks it
+// against the declared checksum. Content over common.MaxFileContent is
+// refused.
+func fetchSource(ctx context.Context, file common.File) ([]byte, error) {
+	key := file.SourceURL + " " + file.Checksum
+	fetchedSources.Lock()
+	defer fetchedSources.Unlock()
+	if content, ok := fetchedSources.content[key]; ok {
+		return content, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, file.SourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source_url %s: %w", file.SourceURL, err)
+	}
+	resp, err := sourceClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", file.SourceURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", file.SourceURL, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, common.MaxFileContent+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", file.SourceURL, err)
+	}
+	if len(content) > common.MaxFileContent {
+		return nil, fmt.Errorf("%s is larger than %d bytes", file.SourceURL, common.MaxFileContent)
+	}
+	if err := common.VerifyChecksum(file.Checksum, content); err != nil {
+		return nil, fmt.Errorf("content fetched from %s: %w", file.SourceURL, err)
+	}
+	fetchedSources.content[key] = content
+	return content, nil
+}
@@ -2,6 +2,7 @@ package core
 
 import (
 	"fmt"
+	"sort"
 )
 
 type Graph struct {
@@ -35,64 +36,42 @@ func (g *Graph) GetResource(id ResourceID) (Resource, bool) {
 	return resource, true
 }
 
+// GetAllResources returns the resources of the graph sorted by ID
 func (g *Graph) GetAllResources() []Resource {
 	resources := make([]Resource, 0, len(g.nodes))
 	for _, resource := range g.nodes {
 		resources = append(resources, resource)
 	}
+	sort.Slice(resources, func(i, j int) bool { return resources[i].GetID() < resources[j].GetID() })
 	return resources
 }
 
+// TopologicalSort orders the resources of the graph so that each comes
+// after the resources it must wait on: the execution waves one after
+// another, each sorted by layer, then by ID, so the same graph always sorts
+// the same way.
 func (g *Graph) TopologicalSort() ([]ResourceID, error) {
-	// Kahn's algorithm
-	inDegree := make(map[ResourceID]int)
-
-	// Initialize in-degree count
-	for id := range g.nodes {
-		inDegree[id] = 0
-	}
-
-	// Count incoming edges
-	for _, deps := range g.edges {
-		for _, dep := range deps {
-			if dep.Required {
-				inDegree[dep.Target]++
-			}
-		}
+	waves, err := g.ExecutionWaves()
+	if err != nil {
+		return nil, err
 	}
-
-	// Find nodes with no incoming edges
-	queue := make([]ResourceID, 0)
-	for id, degree := range inDegree {
-		if degree == 0 {
-			queue = append(queue, id)
-		}
+	result := make([]ResourceID, 0, len(g.nodes))
+	for _, wave := range waves {
+		result = append(result, wave.Resources...)
 	}
+	return result, nil
+}
 
-	result := make([]ResourceID, 0)
-
-	for len(queue) > 0 {
-		current := queue[0]
-		queue = queue[1:]
-		result = append(result, current)
-
-
-		for _, dep := range g.edges[current] {
-			if dep.Required {
-				inDegree[dep.Target]--
-				if inDegree[dep.Target] == 0 {
-					queue = append(queue, dep.Target)
-				}
-			}
+// sortReady orders resources that are ready to be taken by layer, then by
+// ID
+func (g *Graph) sortReady(ids []ResourceID) {
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := g.nodes[ids[i]], g.nodes[ids[j]]
+		if a != nil && b != nil && a.GetLayer() != b.GetLayer() {
+			return a.GetLayer() < b.GetLayer()
 		}
-	}
-
-	// Check for cycles
-	if len(result) != len(g.nodes) {
-		return nil, fmt.Errorf("circular dependency detected")
-	}
-
-	return result, nil
+		return ids[i] < ids[j]
+	})
 }
 
 func (g *Graph) ValidateDependencies() error {
@@ -113,6 +92,10 @@ func (g *Graph) ValidateDependencies() error {
 					return fmt.Errorf("resource %s depends on non-existent resource %s", id, dep.Target)
 				}
 
+				// A triggers edge runs its target later, whatever its layer
+				if dep.EdgeType == EdgeTriggers {
+					continue
+				}
 				toLayer := targetResource.GetLayer()
 				if err := ValidateLayerDependency(fromLayer, toLayer); err != nil {
 					return fmt.Errorf("resource %s: %w", id, err)
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// testResource returns a resource of layer with edges to deps
+func testResource(id ResourceID, layer Layer, deps ...Dependency) Resource {
+	return &BaseResource{ID: id, Type: "test", Layer: layer, Dependencies: deps}
+}
+
+func dependsOn(target ResourceID) Dependency {
+	return Dependency{Target: target, EdgeType: EdgeDependsOn, Required: true}
+}
+
+func triggersEdge(target ResourceID) Dependency {
+	return Dependency{Target: target, EdgeType: EdgeTriggers, Required: true}
+}
+
+// testGraphResources has ties within layers, across layers and across waves
+func testGraphResources() []Resource {
+	return []Resource{
+		testResource("host:web", LayerFoundation),
+		testResource("package:apt:nginx", LayerPlatform),
+		testResource("package:apt:curl", LayerPlatform),
+		testResource("package:apt:zlib", LayerPlatform),
+		testResource("service:nginx", LayerApplication, dependsOn("package:apt:nginx")),
+		testResource("service:cron", LayerApplication),
+		testResource("file:/etc/nginx/nginx.conf", LayerConfiguration, dependsOn("package:apt:nginx"), triggersEdge("service:nginx")),
+		testResource("file:/etc/motd", LayerConfiguration),
+		testResource("file:/etc/issue", LayerConfiguration),
+		testResource("check:http", LayerRuntime, dependsOn("service:nginx")),
+	}
+}
+
+func buildGraph(t *testing.T, resources []Resource) *Graph {
+	t.Helper()
+	graph := NewGraph()
+	for _, resource := range resources {
+		if err := graph.AddResource(resource); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return graph
+}
+
+func TestTopologicalSortIsReproducible(t *testing.T) {
+	want := []ResourceID{
+		"host:web",
+		"package:apt:curl",
+		"package:apt:nginx",
+		"package:apt:zlib",
+		"service:cron",
+		"file:/etc/issue",
+		"file:/etc/motd",
+		"file:/etc/nginx/nginx.conf",
+		"service:nginx",
+		"check:http",
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		// Add the resources in a different order every time; map iteration
+		// is randomized as well
+		resources := testGraphResources()
+		r.Shuffle(len(resources), func(i, j int) { resources[i], resources[j] = resources[j], resources[i] })
+
+		got, err := buildGraph(t, resources).TopologicalSort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("sort %d:\ngot  %v\nwant %v", i, got, want)
+		}
+	}
+}
+
+func TestTopologicalSortDetectsCycles(t *testing.T) {
+	graph := buildGraph(t, []Resource{
+		testResource("a", LayerPlatform, dependsOn("b")),
+		testResource("b", LayerPlatform, dependsOn("a")),
+	})
+	if _, err := graph.TopologicalSort(); err == nil {
+		t.Fatal("expected a circular dependency error")
+	}
+}
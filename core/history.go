@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// historyDir holds one document per run, next to the state; documents are
+// only ever added
+const historyDir = "history"
+
+// RunRecord is the audit entry of a run that applied a plan: who ran it,
+// what the plan contained, and how each change ended with the checksums of
+// its resource before and after
+type RunRecord struct {
+	RunID       string            `json:"run_id"`
+	Command     string            `json:"command"`
+	User        string            `json:"user"`
+	StartedAt   time.Time         `json:"started_at"`
+	Duration    float64           `json:"duration_seconds"`
+	Success     bool              `json:"success"`
+	Interrupted bool              `json:"interrupted"`
+	Error       string            `json:"error,omitempty"`
+	Plan        PlanCounts        `json:"plan"`
+	Actions     []*RecordedAction `json:"actions"`
+}
+
+// PlanCounts counts the actions of a plan by type
+type PlanCounts struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+	NoOp   int `json:"no_op"`
+}
+
+// RecordedAction is a change of a run with the checksum its resource had in
+// the state before and after the run
+type RecordedAction struct {
+	*ActionReport
+	Before string `json:"before_checksum,omitempty"`
+	After  string `json:"after_checksum,omitempty"`
+}
+
+// Outcome returns succeeded, failed or interrupted
+func (r *RunRecord) Outcome() string {
+	switch {
+	case r.Interrupted:
+		return "interrupted"
+	case r.Success:
+		return ReportSucceeded
+	default:
+		return ReportFailed
+	}
+}
+
+// Checksums returns the checksum of every resource in the state
+func (s *StateManager) Checksums() map[ResourceID]string {
+	checksums := make(map[ResourceID]string, len(s.state))
+	for id, state := range s.state {
+		checksums[id] = state.Checksum
+	}
+	return checksums
+}
+
+// NewRunRecord builds the audit entry of result. before holds the checksums
+// of the state when the run started; the state manager has those it left.
+// No-op actions are only counted.
+func NewRunRecord(command, user string, result *ExecutionResult, before map[ResourceID]string, stateManager *StateManager) *RunRecord {
+	report := NewRunReport(result)
+	record := &RunRecord{
+		RunID:       report.RunID,
+		Command:     command,
+		User:        user,
+		StartedAt:   report.StartedAt,
+		Duration:    report.Duration,
+		Success:     report.Success,
+		Interrupted: report.Interrupted,
+		Error:       report.Error,
+		Actions:     make([]*RecordedAction, 0),
+	}
+	if result.Plan != nil {
+		record.Plan = PlanCounts{
+			Create: result.Plan.GetActionCount(ActionCreate),
+			Update: result.Plan.GetActionCount(ActionUpdate),
+			Delete: result.Plan.GetActionCount(ActionDelete),
+			NoOp:   result.Plan.GetActionCount(ActionNoOp),
+		}
+	}
+
+	after := stateManager.Checksums()
+	for _, action := range report.Actions {
+		if action.Type == ActionNoOp {
+			continue
+		}
+		record.Actions = append(record.Actions, &RecordedAction{
+			ActionReport: action,
+			Before:       before[action.ResourceID],
+			After:        after[action.ResourceID],
+		})
+	}
+	return record
+}
+
+// RecordRun adds record to the history. An existing entry is never
+// overwritten.
+func (s *StateManager) RecordRun(record *RunRecord) error {
+	key := fmt.Sprintf("%s/%s-%s.json", historyDir, record.StartedAt.UTC().Format("20060102T150405Z"), record.RunID)
+	existing, err := s.backend.Read(key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("history entry of run %s already exists", record.RunID)
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+	return s.backend.Write(key, data)
+}
+
+// Runs returns the recorded runs, oldest first
+func (s *StateManager) Runs() ([]*RunRecord, error) {
+	names, err := s.backend.List(historyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*RunRecord
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		data, err := s.backend.Read(historyDir + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+		var record RunRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal history entry %s: %w", name, err)
+		}
+		runs = append(runs, &record)
+	}
+	return runs, nil
+}
+
+// Run returns the recorded run whose ID starts with prefix
+func (s *StateManager) Run(prefix string) (*RunRecord, error) {
+	runs, err := s.Runs()
+	if err != nil {
+		return nil, err
+	}
+	var found *RunRecord
+	for _, run := range runs {
+		if !strings.HasPrefix(run.RunID, prefix) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("run ID %s is ambiguous", prefix)
+		}
+		found = run
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no run %s in the history", prefix)
+	}
+	return found, nil
+}
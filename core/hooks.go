@@ -0,0 +1,119 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Hooked is implemented by resources declaring before/after hooks
+type Hooked interface {
+	GetHooks() common.Hooks
+}
+
+// HookMiddleware runs a resource's before hooks ahead of Apply and its after
+// hooks once Apply succeeded. Deletes and no-ops run no hooks.
+func HookMiddleware() Middleware {
+	return func(next ActionHandler) ActionHandler {
+		return func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+			hooked, ok := resource.(Hooked)
+			if !ok || (action.Type != ActionCreate && action.Type != ActionUpdate) {
+				return next(ctx, action, resource, resourceCtx)
+			}
+
+			hooks := hooked.GetHooks()
+			env := map[string]string{"SETTLE_RESOURCE": string(resource.GetID())}
+			if err := runHooks(ctx, "before", hooks.Before, resourceCtx, env); err != nil {
+				return err
+			}
+			if err := next(ctx, action, resource, resourceCtx); err != nil {
+				return err
+			}
+			return runHooks(ctx, "after", hooks.After, resourceCtx, env)
+		}
+	}
+}
+
+// RunProjectHooks runs the project-wide hooks of stage ("pre-run" or
+// "post-run"). Local hooks run once; remote hooks run on every host.
+func RunProjectHooks(ctx context.Context, stage string, hooks []common.RunHook, hosts []common.Host, logger *inventory.Logger) error {
+	for _, runHook := range hooks {
+		if runHook.Stage != stage {
+			continue
+		}
+		logger.Info(fmt.Sprintf("Running %s hook %s", stage, runHook.Name))
+
+		env := map[string]string{"SETTLE_STAGE": stage}
+		if runHook.Local {
+			if err := runHooks(ctx, stage, []common.Hook{runHook.Hook}, &inventory.Context{Logger: logger, Ctx: ctx}, env); err != nil {
+				return fmt.Errorf("hook %s: %w", runHook.Name, err)
+			}
+			continue
+		}
+		for i := range hosts {
+			hostCtx := &inventory.Context{Host: &hosts[i], Logger: logger, Ctx: ctx}
+			if err := runHooks(ctx, stage, []common.Hook{runHook.Hook}, hostCtx, env); err != nil {
+				return fmt.Errorf("hook %s on %s: %w", runHook.Name, hosts[i].Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks runs hooks in order, applying each hook's failure policy
+func runHooks(ctx context.Context, stage string, hooks []common.Hook, resourceCtx *inventory.Context, env map[string]string) error {
+	for _, hook := range hooks {
+		output, err := runHookCommand(ctx, hook, resourceCtx, env)
+		if err == nil {
+			continue
+		}
+
+		switch hook.OnFailure {
+		case common.HookIgnore:
+		case common.HookWarn:
+			resourceCtx.Logger.Warning(fmt.Sprintf("%s hook %q failed: %v", stage, hook.Command, err))
+			resourceCtx.Logger.ErrorOutput(output)
+		default:
+			if output = strings.TrimSpace(output); output != "" {
+				return fmt.Errorf("%s hook %q failed: %w: %s", stage, hook.Command, err, output)
+			}
+			return fmt.Errorf("%s hook %q failed: %w", stage, hook.Command, err)
+		}
+	}
+	return nil
+}
+
+// runHookCommand runs a hook on the context's host, or on this machine for
+// local hooks. Local hooks see env, SETTLE_HOST and SETTLE_RUN_ID in their
+// environment.
+func runHookCommand(ctx context.Context, hook common.Hook, resourceCtx *inventory.Context, env map[string]string) (string, error) {
+	resourceCtx.Logger.Command(hook.Command)
+
+	if !hook.Local {
+		client, closeClient, err := connect(resourceCtx)
+		if err != nil {
+			return "", err
+		}
+		defer closeClient()
+		return client.RunCommand(ctx, hook.Command)
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = os.Environ()
+	for key, val := range env {
+		cmd.Env = append(cmd.Env, key+"="+val)
+	}
+	if resourceCtx.Host != nil {
+		cmd.Env = append(cmd.Env, "SETTLE_HOST="+resourceCtx.Host.Name)
+	}
+	if id := inventory.RunIDFromContext(ctx); id != "" {
+		cmd.Env = append(cmd.Env, "SETTLE_RUN_ID="+id)
+	}
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// FilterHosts returns the hosts matched by one of patterns: a host name or
+// alias, a group name, or a glob pattern such as web-* matched against host
+// names. A pattern matching no host is an error, so a mistyped name does not
+// silently select nothing.
+func FilterHosts(hosts []common.Host, patterns []string) ([]common.Host, error) {
+	matched := make(map[string]bool)
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", pattern, err)
+		}
+		found := false
+		for _, host := range hosts {
+			if hostMatches(host, pattern) {
+				matched[host.Name] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%q matches no host or group of the inventory", pattern)
+		}
+	}
+
+	var filtered []common.Host
+	for _, host := range hosts {
+		if matched[host.Name] {
+			filtered = append(filtered, host)
+		}
+	}
+	return filtered, nil
+}
+
+// hostMatches reports whether pattern names host, one of its aliases or its
+// group, or matches its name as a glob
+func hostMatches(host common.Host, pattern string) bool {
+	if host.Name == pattern || host.Group == pattern {
+		return true
+	}
+	for _, alias := range host.Aliases {
+		if alias == pattern {
+			return true
+		}
+	}
+	ok, _ := filepath.Match(pattern, host.Name)
+	return ok
+}
+
+// PruneGraph removes the resources of graph that target none of hosts, with
+// the edges to them, and returns how many it removed
+func PruneGraph(graph *Graph, hosts map[string]*common.Host) int {
+	pruned := 0
+	for _, resource := range graph.GetAllResources() {
+		if TargetsAny(resource, hosts) {
+			continue
+		}
+		graph.RemoveResource(resource.GetID())
+		pruned++
+	}
+	return pruned
+}
+
+// TargetsAny reports whether resource targets at least one of hosts
+func TargetsAny(resource Resource, hosts map[string]*common.Host) bool {
+	for _, host := range TargetHosts(resource, hosts) {
+		if _, ok := hosts[host.Name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// TargetedHosts returns the hosts that at least one of resources targets.
+// When conditions are left out, since they may read facts not gathered yet.
+func TargetedHosts(resources []Resource, hosts []common.Host) []common.Host {
+	hostMap := HostMap(hosts)
+	targeted := make(map[string]bool)
+	for _, resource := range resources {
+		target := resource.GetTarget()
+		target.When = ""
+		for _, host := range selectHosts(target, hostMap) {
+			targeted[host.Name] = true
+		}
+	}
+
+	var result []common.Host
+	for _, host := range hosts {
+		if targeted[host.Name] {
+			result = append(result, host)
+		}
+	}
+	return result
+}
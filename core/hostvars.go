@@ -0,0 +1,72 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+var hostReferenceRegex = regexp.MustCompile(`\$\{host\.([a-zA-Z0-9_.]+)\}`)
+
+// HasHostReferences reports whether text references the host it is applied
+// to, e.g. ${host.vars.nginx_port}
+func HasHostReferences(text string) bool {
+	return hostReferenceRegex.MatchString(text)
+}
+
+// RenderHost replaces the ${host.name}, ${host.hostname}, ${host.group} and
+// ${host.vars.<name>} references in text with the values of host. An
+// undefined reference is an error.
+func RenderHost(text string, host *common.Host) (string, error) {
+	var err error
+	result := hostReferenceRegex.ReplaceAllStringFunc(text, func(reference string) string {
+		if err != nil {
+			return reference
+		}
+		name := hostReferenceRegex.FindStringSubmatch(reference)[1]
+		value, ok := hostValue(host, name)
+		if !ok {
+			err = fmt.Errorf("undefined reference %s on host %s", reference, host.Name)
+			return reference
+		}
+		return value
+	})
+	return result, err
+}
+
+// hostValue returns the value of a ${host.name} reference
+func hostValue(host *common.Host, name string) (string, bool) {
+	switch name {
+	case "name":
+		return host.Name, true
+	case "hostname":
+		return host.Hostname, true
+	case "group":
+		return host.Group, true
+	}
+	if varName, ok := strings.CutPrefix(name, "vars."); ok {
+		value, ok := host.Vars[varName]
+		return value, ok
+	}
+	return "", false
+}
+
+// CheckHostReferences renders the host references of every resource for
+// each of its target hosts, so an undefined variable fails before a run
+// rather than halfway through it
+func CheckHostReferences(resources []Resource, hosts map[string]*common.Host) error {
+	for _, resource := range resources {
+		rendered, ok := resource.(HostRendered)
+		if !ok {
+			continue
+		}
+		for _, host := range TargetHosts(resource, hosts) {
+			if _, err := rendered.Render(host); err != nil {
+				return fmt.Errorf("%s: %w", resource.GetID(), err)
+			}
+		}
+	}
+	return nil
+}
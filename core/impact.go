@@ -0,0 +1,116 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// Impact tells how an action affects what runs on its hosts
+type Impact string
+
+const (
+	// ImpactSafe actions do not interrupt anything, e.g. creating a file
+	ImpactSafe Impact = "safe"
+	// ImpactDisruptive actions may interrupt what runs on the host, e.g.
+	// restarting a service or removing a package
+	ImpactDisruptive Impact = "disruptive"
+)
+
+// ImpactDescriber is implemented by resources that know the impact of their
+// own actions, e.g. a package whose removal is disruptive
+type ImpactDescriber interface {
+	// Impact returns the impact of action on the resource and, for a
+	// disruptive one, what it disrupts
+	Impact(action *Action) (Impact, string)
+}
+
+// ClassifyImpact records the impact of action in its metadata as impact and
+// impact_reason. A create or update is disruptive when the resource says so
+// or when it restarts a resource through a triggers edge; resources that do
+// not describe their impact are disrupted by deletes only. graph may be nil
+// when the action triggers nothing.
+func ClassifyImpact(graph *Graph, resource Resource, action *Action) {
+	if action.Type == ActionNoOp {
+		return
+	}
+	impact, reason := resourceImpact(resource, action)
+	if impact == ImpactSafe && graph != nil && action.Type != ActionDelete {
+		impact, reason = triggerImpact(graph, resource)
+	}
+	if action.Metadata == nil {
+		action.Metadata = make(map[string]interface{})
+	}
+	action.Metadata["impact"] = string(impact)
+	if impact == ImpactDisruptive {
+		action.Metadata["impact_reason"] = reason
+	}
+}
+
+// ActionImpact returns the impact of action recorded by the planner, or
+// classifies it against the graph of plan for actions planned before
+// impacts were recorded
+func ActionImpact(plan *Plan, action *Action) (Impact, string) {
+	if action.Type == ActionNoOp {
+		return ImpactSafe, ""
+	}
+	if impact, ok := action.Metadata["impact"].(string); ok {
+		reason, _ := action.Metadata["impact_reason"].(string)
+		return Impact(impact), reason
+	}
+	if plan.Graph == nil {
+		return resourceImpact(nil, action)
+	}
+	resource, ok := plan.Graph.GetResource(action.ResourceID)
+	if !ok {
+		return resourceImpact(nil, action)
+	}
+	impact, reason := resourceImpact(resource, action)
+	if impact == ImpactSafe && action.Type != ActionDelete {
+		impact, reason = triggerImpact(plan.Graph, resource)
+	}
+	return impact, reason
+}
+
+// DisruptiveActions returns the actions of plan that are disruptive
+func DisruptiveActions(plan *Plan) []*Action {
+	var disruptive []*Action
+	for _, action := range plan.Actions {
+		if impact, _ := ActionImpact(plan, action); impact == ImpactDisruptive {
+			disruptive = append(disruptive, action)
+		}
+	}
+	return disruptive
+}
+
+// resourceImpact returns the impact of action as resource describes it, or
+// the default impact when it does not
+func resourceImpact(resource Resource, action *Action) (Impact, string) {
+	if describer, ok := resource.(ImpactDescriber); ok {
+		return describer.Impact(action)
+	}
+	if action.Type == ActionDelete {
+		return ImpactDisruptive, fmt.Sprintf("removes %s", action.ResourceID)
+	}
+	return ImpactSafe, ""
+}
+
+// triggerImpact returns whether a change of resource restarts a resource it
+// has a triggers edge to. Services that reload when notified are not
+// disrupted.
+func triggerImpact(graph *Graph, resource Resource) (Impact, string) {
+	for _, dep := range graph.GetDependencies(resource.GetID()) {
+		if dep.EdgeType != EdgeTriggers {
+			continue
+		}
+		target, ok := graph.GetResource(dep.Target)
+		if !ok {
+			continue
+		}
+		if service, ok := target.(*ServiceResource); ok && service.Service.OnNotify == common.ServiceReload {
+			continue
+		}
+		return ImpactDisruptive, fmt.Sprintf("restarts %s", dep.Target)
+	}
+	return ImpactSafe, ""
+}
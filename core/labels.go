@@ -0,0 +1,85 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// LabelSelector selects resources by label. Every requirement must match:
+// "key=value" requires the value, a bare "key" requires the label to be set.
+type LabelSelector []labelRequirement
+
+type labelRequirement struct {
+	key      string
+	value    string
+	anyValue bool
+}
+
+// ParseLabelSelector parses --label arguments such as "team=payments"
+func ParseLabelSelector(args []string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, arg := range args {
+		key, value, hasValue := strings.Cut(arg, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid label selector: %q", arg)
+		}
+		selector = append(selector, labelRequirement{
+			key:      key,
+			value:    strings.TrimSpace(value),
+			anyValue: !hasValue,
+		})
+	}
+	return selector, nil
+}
+
+// Matches reports whether labels satisfy every requirement. An empty
+// selector matches everything.
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		value, ok := labels[requirement.key]
+		if !ok || (!requirement.anyValue && value != requirement.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Owner returns the owner label of labels, if any
+func Owner(labels map[string]string) string {
+	return labels[common.LabelOwner]
+}
+
+// FormatLabels renders labels as sorted "key=value" pairs
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// StateLabels returns the labels recorded in a state entry
+func StateLabels(state *ResourceState) map[string]string {
+	labels := make(map[string]string)
+	raw, _ := state.Metadata["labels"].(map[string]interface{})
+	for key, value := range raw {
+		labels[key] = fmt.Sprint(value)
+	}
+	// Entries written by this process still hold the original map
+	if typed, ok := state.Metadata["labels"].(map[string]string); ok {
+		for key, value := range typed {
+			labels[key] = value
+		}
+	}
+	return labels
+}
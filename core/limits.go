@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// LimitViolation is a blast-radius limit a plan exceeds
+type LimitViolation struct {
+	Limit  string // max_changes, max_deletes or max_hosts_changed
+	Group  string // empty for a project-wide limit
+	Max    int
+	Actual int
+}
+
+func (v LimitViolation) String() string {
+	scope := "the project"
+	if v.Group != "" {
+		scope = "group " + v.Group
+	}
+	return fmt.Sprintf("%s = %d exceeded for %s: the plan has %d", v.Limit, v.Max, scope, v.Actual)
+}
+
+// CheckLimits returns every limit the plan exceeds. Changes count against a
+// group's limits when they touch at least one host of the group.
+func CheckLimits(plan *Plan, hosts map[string]*common.Host, limits []common.Limits) []LimitViolation {
+	var violations []LimitViolation
+	for _, limit := range limits {
+		changes, deletes := 0, 0
+		changedHosts := make(map[string]bool)
+		for _, action := range plan.Actions {
+			if action.Type == ActionNoOp {
+				continue
+			}
+			touched := false
+			for _, name := range ActionHosts(plan, action, hosts) {
+				host, ok := hosts[name]
+				if limit.Group != "" && (!ok || host.Group != limit.Group) {
+					continue
+				}
+				touched = true
+				changedHosts[name] = true
+			}
+			if !touched && limit.Group != "" {
+				continue
+			}
+			changes++
+			if action.Type == ActionDelete {
+				deletes++
+			}
+		}
+
+		check := func(name string, max, actual int) {
+			if max >= 0 && actual > max {
+				violations = append(violations, LimitViolation{Limit: name, Group: limit.Group, Max: max, Actual: actual})
+			}
+		}
+		check("max_changes", limit.MaxChanges, changes)
+		check("max_deletes", limit.MaxDeletes, deletes)
+		check("max_hosts_changed", limit.MaxHostsChanged, len(changedHosts))
+	}
+	return violations
+}
+
+// ActionHosts returns the names of the hosts an action runs on: those a
+// delete action recorded, or the targets of its resource
+func ActionHosts(plan *Plan, action *Action, hosts map[string]*common.Host) []string {
+	switch names := action.Metadata["hosts"].(type) {
+	case []string:
+		return names
+	case []interface{}: // read back from JSON
+		hostNames := make([]string, 0, len(names))
+		for _, name := range names {
+			hostNames = append(hostNames, fmt.Sprint(name))
+		}
+		return hostNames
+	}
+	if plan.Graph == nil {
+		return nil
+	}
+	resource, ok := plan.Graph.GetResource(action.ResourceID)
+	if !ok {
+		return nil
+	}
+	var names []string
+	for _, host := range TargetHosts(resource, hosts) {
+		names = append(names, host.Name)
+	}
+	return names
+}
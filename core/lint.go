@@ -0,0 +1,162 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/secrets"
+)
+
+// LintFinding is a project hygiene issue reported by a lint rule
+type LintFinding struct {
+	Rule    string
+	Subject string // resource ID, host or variable the finding is about
+	Message string
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s: %s [%s]", f.Subject, f.Message, f.Rule)
+}
+
+// LintInput is the project a lint run checks. Resources must be loaded
+// without resolving secrets, so inline values can be told from references.
+type LintInput struct {
+	Resources       []Resource
+	Hosts           []common.Host
+	UnusedVariables map[string][]string // module name to variables it declares but never references
+}
+
+// LintRule checks one aspect of a project
+type LintRule struct {
+	Name        string
+	Description string
+	Check       func(input LintInput) []LintFinding
+}
+
+// LintRules returns the built-in rules
+func LintRules() []LintRule {
+	return []LintRule{
+		{Name: "unused-variable", Description: "module variables that are never referenced", Check: lintUnusedVariables},
+		{Name: "untargeted-host", Description: "hosts no resource targets", Check: lintUntargetedHosts},
+		{Name: "missing-owner", Description: "resources without an owner label", Check: lintMissingOwners},
+		{Name: "service-without-package", Description: "services whose package is not managed", Check: lintServicesWithoutPackages},
+		{Name: "inline-secret", Description: "files with secrets in their content instead of ${secret.name} references", Check: lintInlineSecrets},
+	}
+}
+
+// Lint runs every rule not in disabled and returns the findings sorted by
+// subject
+func Lint(input LintInput, disabled map[string]bool) []LintFinding {
+	var findings []LintFinding
+	for _, rule := range LintRules() {
+		if disabled[rule.Name] {
+			continue
+		}
+		for _, finding := range rule.Check(input) {
+			finding.Rule = rule.Name
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Subject < findings[j].Subject })
+	return findings
+}
+
+func lintUnusedVariables(input LintInput) []LintFinding {
+	var findings []LintFinding
+	for module, names := range input.UnusedVariables {
+		for _, name := range names {
+			findings = append(findings, LintFinding{
+				Subject: fmt.Sprintf("module.%s.var.%s", module, name),
+				Message: "variable is declared but never referenced",
+			})
+		}
+	}
+	return findings
+}
+
+func lintUntargetedHosts(input LintInput) []LintFinding {
+	var findings []LintFinding
+	for i := range input.Hosts {
+		host := &input.Hosts[i]
+		targeted := false
+		for _, resource := range input.Resources {
+			if len(TargetHosts(resource, map[string]*common.Host{host.Name: host})) > 0 {
+				targeted = true
+				break
+			}
+		}
+		if !targeted {
+			findings = append(findings, LintFinding{
+				Subject: host.Name,
+				Message: "host is not targeted by any resource",
+			})
+		}
+	}
+	return findings
+}
+
+func lintMissingOwners(input LintInput) []LintFinding {
+	var findings []LintFinding
+	for _, resource := range input.Resources {
+		if Owner(resource.GetLabels()) == "" {
+			findings = append(findings, LintFinding{
+				Subject: string(resource.GetID()),
+				Message: fmt.Sprintf("resource has no %q label", common.LabelOwner),
+			})
+		}
+	}
+	return findings
+}
+
+func lintServicesWithoutPackages(input LintInput) []LintFinding {
+	packages := make(map[string]bool)
+	for _, resource := range input.Resources {
+		if pkg, ok := resource.(*PackageResource); ok {
+			packages[pkg.Package.Name] = true
+		}
+	}
+
+	var findings []LintFinding
+	for _, resource := range input.Resources {
+		service, ok := resource.(*ServiceResource)
+		if !ok || packages[service.Service.Name] {
+			continue
+		}
+		findings = append(findings, LintFinding{
+			Subject: string(resource.GetID()),
+			Message: fmt.Sprintf("no package %s is managed for this service", service.Service.Name),
+		})
+	}
+	return findings
+}
+
+// secretPatterns match values that look like credentials
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`\bAKIA[0-9A-Z]{16}\b`),
+	regexp.MustCompile(`(?i)(password|passwd|secret|token|api[_-]?key)["']?\s*[:=]\s*["']?[^\s"'$]{4,}`),
+}
+
+func lintInlineSecrets(input LintInput) []LintFinding {
+	var findings []LintFinding
+	for _, resource := range input.Resources {
+		file, ok := resource.(*FileResource)
+		if !ok || file.File.Verbatim {
+			continue
+		}
+		content := secrets.StripReferences(file.File.Content)
+		for _, pattern := range secretPatterns {
+			if pattern.MatchString(content) {
+				findings = append(findings, LintFinding{
+					Subject: string(resource.GetID()),
+					Message: "content looks like it holds a secret; use a ${secret.name} reference",
+				})
+				break
+			}
+		}
+	}
+	return findings
+}
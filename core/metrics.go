@@ -0,0 +1,248 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics measures a run for monitoring: how long actions take by resource
+// type, how many succeed and fail on each host, how long SSH connections
+// take to open and how the run ended. Subscribe HandleEvent to the
+// executor's events and pass ObserveConnect to inventory.ObserveConnects.
+type Metrics struct {
+	Command string // e.g. create
+	Project string
+
+	mu              sync.Mutex
+	started         map[string]time.Time // by resource@host
+	durations       map[metricLabels]*durationSummary
+	outcomes        map[metricLabels]int
+	connects        map[string]*durationSummary
+	connectFailures map[string]int
+	result          *ExecutionResult
+}
+
+// metricLabels identify a series of action metrics; host and outcome are
+// empty for durations
+type metricLabels struct {
+	action       ActionType
+	resourceType string
+	host         string
+	outcome      string
+}
+
+type durationSummary struct {
+	sum   time.Duration
+	count int
+}
+
+func (s *durationSummary) observe(d time.Duration) {
+	s.sum += d
+	s.count++
+}
+
+func NewMetrics(command, project string) *Metrics {
+	return &Metrics{
+		Command:         command,
+		Project:         project,
+		started:         make(map[string]time.Time),
+		durations:       make(map[metricLabels]*durationSummary),
+		outcomes:        make(map[metricLabels]int),
+		connects:        make(map[string]*durationSummary),
+		connectFailures: make(map[string]int),
+	}
+}
+
+// HandleEvent records action durations and outcomes and the result of the
+// run
+func (m *Metrics) HandleEvent(event Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := fmt.Sprintf("%s@%s", event.ResourceID, event.Host)
+	switch event.Type {
+	case EventActionStarted:
+		m.started[key] = event.At
+	case EventActionCompleted:
+		started, ok := m.started[key]
+		delete(m.started, key)
+		labels := metricLabels{action: event.Action.Type, resourceType: resourceTypeOf(event.ResourceID)}
+		if ok {
+			summary := m.durations[labels]
+			if summary == nil {
+				summary = &durationSummary{}
+				m.durations[labels] = summary
+			}
+			summary.observe(event.At.Sub(started))
+		}
+		labels.host = event.Host
+		labels.outcome = "succeeded"
+		if event.Error != nil {
+			labels.outcome = "failed"
+		}
+		m.outcomes[labels]++
+	case EventRunFinished:
+		m.result = event.Result
+	}
+}
+
+// ObserveConnect records an attempt to open an SSH connection to host
+func (m *Metrics) ObserveConnect(host string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.connectFailures[host]++
+		return
+	}
+	summary := m.connects[host]
+	if summary == nil {
+		summary = &durationSummary{}
+		m.connects[host] = summary
+	}
+	summary.observe(elapsed)
+}
+
+// resourceTypeOf returns the type of a resource from its ID, e.g. package
+// for module.web.package:apt:nginx
+func resourceTypeOf(id ResourceID) string {
+	_, local := splitModuleID(id)
+	resourceType, _, _ := strings.Cut(string(local), ":")
+	return resourceType
+}
+
+// Render writes the metrics in the Prometheus text exposition format
+func (m *Metrics) Render() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b bytes.Buffer
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("settle_action_duration_seconds", "summary", "Time actions took on a host, by action and resource type")
+	for _, labels := range sortedLabels(m.durations) {
+		summary := m.durations[labels]
+		series := formatLabels("action", string(labels.action), "resource_type", labels.resourceType)
+		fmt.Fprintf(&b, "settle_action_duration_seconds_sum%s %g\n", series, summary.sum.Seconds())
+		fmt.Fprintf(&b, "settle_action_duration_seconds_count%s %d\n", series, summary.count)
+	}
+
+	header("settle_actions_total", "counter", "Actions run on each host, by action, resource type and outcome")
+	for _, labels := range sortedLabels(m.outcomes) {
+		series := formatLabels("action", string(labels.action), "resource_type", labels.resourceType, "host", labels.host, "outcome", labels.outcome)
+		fmt.Fprintf(&b, "settle_actions_total%s %d\n", series, m.outcomes[labels])
+	}
+
+	header("settle_ssh_connect_duration_seconds", "summary", "Time taken to open SSH connections, by host")
+	for _, host := range sortedKeys(m.connects) {
+		series := formatLabels("host", host)
+		fmt.Fprintf(&b, "settle_ssh_connect_duration_seconds_sum%s %g\n", series, m.connects[host].sum.Seconds())
+		fmt.Fprintf(&b, "settle_ssh_connect_duration_seconds_count%s %d\n", series, m.connects[host].count)
+	}
+
+	header("settle_ssh_connect_failures_total", "counter", "SSH connections that could not be opened, by host")
+	for _, host := range sortedKeys(m.connectFailures) {
+		fmt.Fprintf(&b, "settle_ssh_connect_failures_total%s %d\n", formatLabels("host", host), m.connectFailures[host])
+	}
+
+	if result := m.result; result != nil {
+		series := formatLabels("command", m.Command)
+		success := 0
+		if result.Success && !result.Interrupted {
+			success = 1
+		}
+		header("settle_run_duration_seconds", "gauge", "Duration of the last run")
+		fmt.Fprintf(&b, "settle_run_duration_seconds%s %g\n", series, result.GetDuration().Seconds())
+		header("settle_run_success", "gauge", "Whether the last run applied every action (1) or not (0)")
+		fmt.Fprintf(&b, "settle_run_success%s %d\n", series, success)
+		header("settle_run_finished_timestamp_seconds", "gauge", "When the last run finished, in seconds since the epoch")
+		fmt.Fprintf(&b, "settle_run_finished_timestamp_seconds%s %d\n", series, time.Now().Unix())
+		header("settle_run_resources", "gauge", "Resources of the last run, by outcome")
+		fmt.Fprintf(&b, "settle_run_resources%s %d\n", formatLabels("command", m.Command, "outcome", "succeeded"), result.GetSuccessCount())
+		fmt.Fprintf(&b, "settle_run_resources%s %d\n", formatLabels("command", m.Command, "outcome", "failed"), result.GetFailureCount())
+		fmt.Fprintf(&b, "settle_run_resources%s %d\n", formatLabels("command", m.Command, "outcome", "skipped"), result.GetSkippedCount())
+	}
+
+	return b.Bytes()
+}
+
+// Push replaces the metrics of this project and command on a Prometheus
+// Pushgateway. gateway is its base URL, e.g. http://pushgateway:9091, to
+// which the job settle and the grouping labels project and command are
+// added; a URL already naming a job is used as is.
+func (m *Metrics) Push(gateway string) error {
+	target := strings.TrimSuffix(gateway, "/")
+	if !strings.Contains(target, "/metrics/job/") {
+		target += "/metrics/job/settle"
+		if m.Project != "" {
+			target += "/project/" + url.PathEscape(m.Project)
+		}
+		target += "/command/" + url.PathEscape(m.Command)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, target, bytes.NewReader(m.Render()))
+	if err != nil {
+		return fmt.Errorf("invalid metrics gateway URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("metrics gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// labelEscaper escapes label values as the exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats name/value pairs as {name="value",...}
+func formatLabels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func sortedLabels[V any](series map[metricLabels]V) []metricLabels {
+	labels := make([]metricLabels, 0, len(series))
+	for l := range series {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.resourceType != b.resourceType {
+			return a.resourceType < b.resourceType
+		}
+		if a.action != b.action {
+			return a.action < b.action
+		}
+		if a.host != b.host {
+			return a.host < b.host
+		}
+		return a.outcome < b.outcome
+	})
+	return labels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
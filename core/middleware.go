@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// ActionHandler performs a single planned action against its resource
+type ActionHandler func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error
+
+// Middleware wraps an ActionHandler to run code around every action, e.g.
+// logging, metrics, approval gates or adjusting the resource context.
+// Returning an error without calling next aborts the action.
+type Middleware func(next ActionHandler) ActionHandler
+
+// BeforeAction returns a middleware that calls fn before each action and
+// aborts the action when fn returns an error
+func BeforeAction(fn func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error) Middleware {
+	return func(next ActionHandler) ActionHandler {
+		return func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+			if err := fn(ctx, action, resource, resourceCtx); err != nil {
+				return err
+			}
+			return next(ctx, action, resource, resourceCtx)
+		}
+	}
+}
+
+// AfterAction returns a middleware that calls fn with the outcome of each
+// action. The error fn returns replaces the action's error.
+func AfterAction(fn func(ctx context.Context, action *Action, resource Resource, err error) error) Middleware {
+	return func(next ActionHandler) ActionHandler {
+		return func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+			err := next(ctx, action, resource, resourceCtx)
+			return fn(ctx, action, resource, err)
+		}
+	}
+}
+
+// chain wraps handler so the first middleware is the outermost
+func chain(handler ActionHandler, middleware []Middleware) ActionHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
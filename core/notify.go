@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// defaultNotificationTemplate is used for Slack messages without a template
+const defaultNotificationTemplate = `settle {{.Command}}{{if .Project}} ({{.Project}}){{end}}: {{.Changed}} changed, {{.Failed}} failed in {{.Duration}}{{if .Drifted}}; drifted: {{join .Drifted ", "}}{{end}}{{if .RunID}} (run {{.RunID}}){{end}}`
+
+var notificationFuncs = template.FuncMap{"join": strings.Join}
+
+// RunSummary is what notifications report about a plan or create run
+type RunSummary struct {
+	RunID    string        `json:"run_id,omitempty"`
+	Command  string        `json:"command"`
+	Project  string        `json:"project,omitempty"`
+	Success  bool          `json:"success"`
+	Changed  int           `json:"changed"`
+	Failed   int           `json:"failed"`
+	Duration time.Duration `json:"-"`
+	Drifted  []string      `json:"drifted"`
+}
+
+// MarshalJSON encodes the duration in seconds
+func (s RunSummary) MarshalJSON() ([]byte, error) {
+	type summary RunSummary
+	return json.Marshal(struct {
+		summary
+		DurationSeconds float64 `json:"duration_seconds"`
+	}{summary(s), s.Duration.Seconds()})
+}
+
+// NewPlanSummary summarises a plan; nothing has failed yet
+func NewPlanSummary(plan *Plan, duration time.Duration) RunSummary {
+	return RunSummary{
+		RunID:    inventory.RunID(),
+		Command:  "plan",
+		Success:  true,
+		Changed:  len(plan.Actions) - plan.GetActionCount(ActionNoOp),
+		Duration: duration,
+		Drifted:  driftedResources(plan),
+	}
+}
+
+// NewExecutionSummary summarises an execution result
+func NewExecutionSummary(command string, result *ExecutionResult) RunSummary {
+	summary := RunSummary{
+		RunID:    result.RunID,
+		Command:  command,
+		Success:  result.Success,
+		Failed:   result.GetFailureCount(),
+		Duration: result.GetDuration().Round(time.Millisecond),
+		Drifted:  driftedResources(result.Plan),
+	}
+	for _, execAction := range result.Actions {
+		if execAction.Error == nil && execAction.SkipReason == "" && execAction.Action.Type != ActionNoOp {
+			summary.Changed++
+		}
+	}
+	return summary
+}
+
+// driftedResources lists resources the plan found changed on their hosts
+func driftedResources(plan *Plan) []string {
+	drifted := make([]string, 0)
+	if plan == nil {
+		return drifted
+	}
+	for _, action := range plan.Actions {
+		if drift, _ := action.Metadata["drift"].(bool); drift {
+			drifted = append(drifted, string(action.ResourceID))
+		}
+	}
+	return drifted
+}
+
+// Notifier posts run summaries to a configured endpoint
+type Notifier struct {
+	notification common.Notification
+	template     *template.Template
+	client       *http.Client
+}
+
+func NewNotifier(notification common.Notification) (*Notifier, error) {
+	text := notification.Template
+	if text == "" && notification.Format == "slack" {
+		text = defaultNotificationTemplate
+	}
+
+	notifier := &Notifier{
+		notification: notification,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+	if text != "" {
+		tmpl, err := template.New(notification.Name).Funcs(notificationFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in notification %s: %w", notification.Name, err)
+		}
+		notifier.template = tmpl
+	}
+	return notifier, nil
+}
+
+// Wants reports whether the notification is configured for command
+func (n *Notifier) Wants(command string) bool {
+	if len(n.notification.Events) == 0 {
+		return true
+	}
+	for _, event := range n.notification.Events {
+		if event == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify posts the summary. Slack endpoints receive {"text": ...}; plain
+// webhooks receive the rendered template, or the summary as JSON.
+func (n *Notifier) Notify(summary RunSummary) error {
+	var body []byte
+	var err error
+	contentType := "application/json"
+
+	switch {
+	case n.notification.Format == "slack":
+		var text string
+		if text, err = n.render(summary); err == nil {
+			body, err = json.Marshal(map[string]string{"text": text})
+		}
+	case n.template != nil:
+		var text string
+		text, err = n.render(summary)
+		body = []byte(text)
+		if !json.Valid(body) {
+			contentType = "text/plain; charset=utf-8"
+		}
+	default:
+		body, err = json.Marshal(summary)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build notification %s: %w", n.notification.Name, err)
+	}
+
+	resp, err := n.client.Post(n.notification.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send notification %s: %w", n.notification.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification %s: endpoint returned %s", n.notification.Name, resp.Status)
+	}
+	return nil
+}
+
+func (n *Notifier) render(summary RunSummary) (string, error) {
+	var buf bytes.Buffer
+	if err := n.template.Execute(&buf, summary); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// OrphanReason is the reason of the delete actions planned for resources
+// recorded in state whose blocks were removed from the configuration
+const OrphanReason = "removed from configuration"
+
+// AddOrphans rebuilds the resources recorded in state that graph does not
+// declare and adds them to graph, so they can be deleted. They are returned
+// with the most dependent layers first, the order to remove them in.
+// Resources that cannot be rebuilt are logged and left alone.
+func AddOrphans(graph *Graph, stateManager *StateManager, providers []*Provider, logger *inventory.Logger) []Resource {
+	resourceParser := NewResourceParser()
+	resourceParser.SetProviders(providers)
+
+	var orphans []Resource
+	for id, state := range stateManager.GetAllStates() {
+		if _, declared := graph.GetResource(id); declared {
+			continue
+		}
+		resource, err := resourceParser.ResourceFromState(id, state)
+		if err == nil {
+			err = graph.AddResource(resource)
+		}
+		if err != nil {
+			logger.Warning(fmt.Sprintf("%s was removed from the configuration but cannot be deleted: %v", id, err))
+			continue
+		}
+		orphans = append(orphans, resource)
+	}
+
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].GetLayer() != orphans[j].GetLayer() {
+			return orphans[i].GetLayer() > orphans[j].GetLayer()
+		}
+		return orphans[i].GetID() < orphans[j].GetID()
+	})
+	return orphans
+}
@@ -1,19 +1,62 @@
 package core
 
 import (
+	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
 
 	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/drivers/svc"
 )
 
 // ResourceParser converts parsed data into Resource objects
 type ResourceParser struct {
-	hosts    []common.Host
-	packages []common.Package
+	hosts []common.Host
+	// declarations by the name of their resource type
+	declared map[string][]interface{}
+	provided []common.ProviderResource
+	// providers by the resource types they implement
+	providers map[string]*Provider
 }
 
 func NewResourceParser() *ResourceParser {
-	return &ResourceParser{}
+	return &ResourceParser{declared: make(map[string][]interface{})}
+}
+
+func init() {
+	RegisterResourceType(ResourceType{
+		Name:      "package",
+		New:       newDeclared(NewPackageResource),
+		FromState: packageFromState,
+	})
+	RegisterResourceType(ResourceType{
+		Name:      "file",
+		New:       newDeclared(NewFileResource),
+		FromState: fileFromState,
+	})
+	RegisterResourceType(ResourceType{
+		Name:      "service",
+		New:       newDeclared(NewServiceResource),
+		FromState: serviceFromState,
+	})
+	RegisterResourceType(ResourceType{
+		Name:      "systemd_unit",
+		New:       newDeclared(NewSystemdUnitResource),
+		FromState: systemdUnitFromState,
+	})
+}
+
+// newDeclared adapts the constructor of a built-in resource type to
+// ResourceType.New
+func newDeclared[T any](create func(T) Resource) func(declared interface{}) (Resource, error) {
+	return func(declared interface{}) (Resource, error) {
+		value, ok := declared.(T)
+		if !ok {
+			return nil, fmt.Errorf("resource declared as %T, expected %T", declared, value)
+		}
+		return create(value), nil
+	}
 }
 
 // SetHosts sets the hosts data for the parser (for context, not as resources)
@@ -21,9 +64,58 @@ func (rp *ResourceParser) SetHosts(hosts []common.Host) {
 	rp.hosts = hosts
 }
 
+// Declare adds declarations of the registered resource type typeName, which
+// ParseResources passes to the type's New function
+func (rp *ResourceParser) Declare(typeName string, declared ...interface{}) {
+	rp.declared[typeName] = append(rp.declared[typeName], declared...)
+}
+
 // SetPackages sets the packages data for the parser
 func (rp *ResourceParser) SetPackages(packages []common.Package) {
-	rp.packages = packages
+	rp.declared["package"] = nil
+	for _, pkg := range packages {
+		rp.Declare("package", pkg)
+	}
+}
+
+// SetFiles sets the files data for the parser
+func (rp *ResourceParser) SetFiles(files []common.File) {
+	rp.declared["file"] = nil
+	for _, file := range files {
+		rp.Declare("file", file)
+	}
+}
+
+// SetServices sets the services data for the parser
+func (rp *ResourceParser) SetServices(services []common.Service) {
+	rp.declared["service"] = nil
+	for _, service := range services {
+		rp.Declare("service", service)
+	}
+}
+
+// SetSystemdUnits sets the systemd units data for the parser
+func (rp *ResourceParser) SetSystemdUnits(units []common.SystemdUnit) {
+	rp.declared["systemd_unit"] = nil
+	for _, unit := range units {
+		rp.Declare("systemd_unit", unit)
+	}
+}
+
+// SetProviders sets the providers implementing the resource types of the
+// provided resources
+func (rp *ResourceParser) SetProviders(providers []*Provider) {
+	rp.providers = make(map[string]*Provider)
+	for _, provider := range providers {
+		for resourceType := range provider.Types {
+			rp.providers[resourceType] = provider
+		}
+	}
+}
+
+// SetProvidedResources sets the resources of types implemented by providers
+func (rp *ResourceParser) SetProvidedResources(resources []common.ProviderResource) {
+	rp.provided = resources
 }
 
 // GetHosts returns the hosts (for context)
@@ -31,62 +123,225 @@ func (rp *ResourceParser) GetHosts() []common.Host {
 	return rp.hosts
 }
 
-// CreatePackageResources converts Package objects to PackageResource objects
-func (rp *ResourceParser) CreatePackageResources() ([]Resource, error) {
-	var resources []Resource
+// NewFileResource creates a FileResource from a File
+func NewFileResource(file common.File) Resource {
+	fileResource := &FileResource{
+		BaseResource: BaseResource{
+			ID:    moduleID(file.Module, ResourceID(fmt.Sprintf("file:%s", file.Path))),
+			Type:  "file",
+			Layer: LayerConfiguration, // Files configure what the platform installed
+			State: ResourceState{
+				Status: StatePending,
+			},
+			Target:    file.Target,
+			Timeout:   file.Timeout,
+			Hooks:     file.Hooks,
+			Labels:    file.Labels,
+			Sensitive: file.Sensitive,
+			OnDrift:   file.OnDrift,
+			Interval:  file.Interval,
+		},
+		File: file,
+	}
+	// Config carries the content hash rather than the content itself
+	fileResource.Config = map[string]interface{}{
+		"path":     file.Path,
+		"checksum": fileResource.Checksum(),
+		"mode":     fmt.Sprintf("%04o", file.Mode),
+		"owner":    file.Owner,
+		"group":    file.Group,
+	}
+	if file.DirMode != 0 {
+		fileResource.Config["dir_mode"] = fmt.Sprintf("%04o", file.DirMode)
+	}
+	return fileResource
+}
 
-	for _, pkg := range rp.packages {
-		// Create a unique resource ID for the package
-		resourceID := ResourceID(fmt.Sprintf("package:%s:%s", pkg.Manager, pkg.Name))
+// NewServiceResource creates a ServiceResource from a Service
+func NewServiceResource(service common.Service) Resource {
+	resource := &ServiceResource{
+		BaseResource: BaseResource{
+			ID:    moduleID(service.Module, ResourceID(fmt.Sprintf("service:%s", service.Name))),
+			Type:  "service",
+			Layer: LayerRuntime,
+			State: ResourceState{
+				Status: StatePending,
+			},
+			Config: map[string]interface{}{
+				"name": service.Name,
+			},
+			Target:    service.Target,
+			Timeout:   service.Timeout,
+			Hooks:     service.Hooks,
+			Labels:    service.Labels,
+			Sensitive: service.Sensitive,
+			OnDrift:   service.OnDrift,
+			Interval:  service.Interval,
+		},
+		Service: service,
+	}
+	// Unset fields are left as they are on the host, so they are not compared
+	if service.State != "" {
+		resource.Config["state"] = service.State
+	}
+	if service.Enabled != nil {
+		resource.Config["enabled"] = *service.Enabled
+	}
+	if service.Manager != "" {
+		resource.Config["manager"] = service.Manager
+	}
+	return resource
+}
 
-		// Create the package resource
-		pkgResource := &PackageResource{
+// NewSystemdUnitResource creates a SystemdUnitResource from a SystemdUnit.
+// The unit file is written like a file resource.
+func NewSystemdUnitResource(unit common.SystemdUnit) Resource {
+	resource := &SystemdUnitResource{
+		FileResource: FileResource{
 			BaseResource: BaseResource{
-				ID:    resourceID,
-				Type:  "package",
-				Layer: LayerPlatform, // Packages are at the platform layer
+				ID:    moduleID(unit.Module, ResourceID(fmt.Sprintf("systemd_unit:%s", unit.Name))),
+				Type:  "systemd_unit",
+				Layer: LayerConfiguration,
 				State: ResourceState{
 					Status: StatePending,
 				},
-				Config: map[string]interface{}{
-					"name":    pkg.Name,
-					"version": pkg.Version,
-					"manager": pkg.Manager,
-				},
+				Target:    unit.Target,
+				Timeout:   unit.Timeout,
+				Hooks:     unit.Hooks,
+				Labels:    unit.Labels,
+				Sensitive: unit.Sensitive,
+				OnDrift:   unit.OnDrift,
+				Interval:  unit.Interval,
 			},
-			Package: pkg,
-		}
+			File: common.File{
+				Path:    path.Join(svc.SystemdUnitDir, unit.Name),
+				Content: unit.Content,
+				Mode:    0644,
+				Owner:   "root",
+				Group:   "root",
+			},
+		},
+		Unit: unit,
+	}
+	resource.Config = map[string]interface{}{
+		"name":     unit.Name,
+		"path":     resource.File.Path,
+		"checksum": resource.Checksum(),
+	}
+	if unit.Restart {
+		resource.Config["restart"] = true
+	}
+	return resource
+}
 
-		resources = append(resources, pkgResource)
+// CreateProviderResources converts the resources of types implemented by
+// providers to ProviderResource objects
+func (rp *ResourceParser) CreateProviderResources() ([]Resource, error) {
+	var resources []Resource
+
+	for _, declared := range rp.provided {
+		resource, err := rp.CreateResourceFromProvider(declared)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
 	}
 
 	return resources, nil
 }
 
-// ParseResources creates all resources from the stored data (excluding hosts)
+// CreateResourceFromProvider creates a single ProviderResource from a
+// resource of a type implemented by a provider
+func (rp *ResourceParser) CreateResourceFromProvider(declared common.ProviderResource) (Resource, error) {
+	provider, ok := rp.providers[declared.Type]
+	if !ok {
+		return nil, fmt.Errorf("no provider implements resource type %s", declared.Type)
+	}
+	return NewProviderResource(provider, declared)
+}
+
+// linkTriggers adds the triggers edges of resources: from every file to the
+// resources it notifies, and from every unit of a service to the service
+// resource of the same module, so rewriting the unit restarts it. A file
+// notifies resources of its own module.
+func linkTriggers(resources []Resource) error {
+	type key struct{ module, name string }
+	services := make(map[key]ResourceID)
+	byID := make(map[ResourceID]Resource)
+	for _, resource := range resources {
+		byID[resource.GetID()] = resource
+		if service, ok := resource.(*ServiceResource); ok {
+			name := strings.TrimSuffix(service.Service.Name, ".service")
+			services[key{service.Service.Module, name}] = service.GetID()
+		}
+	}
+	for _, resource := range resources {
+		switch r := resource.(type) {
+		case *FileResource:
+			for _, notify := range r.File.Notify {
+				id := moduleID(r.File.Module, ResourceID(notify))
+				target, ok := byID[id]
+				if !ok {
+					return fmt.Errorf("file %s notifies %s, which is not declared", r.File.Path, id)
+				}
+				if _, ok := target.(Triggered); !ok {
+					return fmt.Errorf("file %s notifies %s, which cannot be notified; only services can", r.File.Path, id)
+				}
+				r.AddDependency(Dependency{Target: id, EdgeType: EdgeTriggers, Required: true})
+			}
+		case *SystemdUnitResource:
+			if !strings.HasSuffix(r.Unit.Name, ".service") {
+				continue
+			}
+			name := strings.TrimSuffix(r.Unit.Name, ".service")
+			if serviceID, ok := services[key{r.Unit.Module, name}]; ok {
+				r.AddDependency(Dependency{Target: serviceID, EdgeType: EdgeTriggers, Required: true})
+			}
+		}
+	}
+	return nil
+}
+
+// ParseResources creates all resources from the stored data (excluding
+// hosts), type by type in the order the types were registered, then the
+// resources of provider types
 func (rp *ResourceParser) ParseResources() ([]Resource, error) {
 	var resources []Resource
 
-	// Only create actual resources, not hosts
-	// Hosts are targets, not resources to be created
+	for typeName := range rp.declared {
+		if _, ok := LookupResourceType(typeName); !ok {
+			return nil, fmt.Errorf("unknown resource type %s", typeName)
+		}
+	}
+	for _, typeName := range ResourceTypeNames() {
+		resourceType, _ := LookupResourceType(typeName)
+		for _, declared := range rp.declared[typeName] {
+			resource, err := resourceType.New(declared)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s resources: %w", typeName, err)
+			}
+			resources = append(resources, resource)
+		}
+	}
 
-	// Create package resources
-	pkgResources, err := rp.CreatePackageResources()
+	// Create the resources of provider types
+	providedResources, err := rp.CreateProviderResources()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create package resources: %w", err)
+		return nil, fmt.Errorf("failed to create provider resources: %w", err)
+	}
+	resources = append(resources, providedResources...)
+	if err := linkTriggers(resources); err != nil {
+		return nil, err
 	}
-	resources = append(resources, pkgResources...)
-
-	// TODO: Add other resource types (services, files, etc.)
 
 	return resources, nil
 }
 
-// CreateResourceFromPackage creates a single PackageResource from a Package
-func (rp *ResourceParser) CreateResourceFromPackage(pkg common.Package) Resource {
-	resourceID := ResourceID(fmt.Sprintf("package:%s:%s", pkg.Manager, pkg.Name))
+// NewPackageResource creates a PackageResource from a Package
+func NewPackageResource(pkg common.Package) Resource {
+	resourceID := moduleID(pkg.Module, ResourceID(fmt.Sprintf("package:%s:%s", pkg.Manager, pkg.Name)))
 
-	return &PackageResource{
+	resource := &PackageResource{
 		BaseResource: BaseResource{
 			ID:    resourceID,
 			Type:  "package",
@@ -99,9 +354,29 @@ func (rp *ResourceParser) CreateResourceFromPackage(pkg common.Package) Resource
 				"version": pkg.Version,
 				"manager": pkg.Manager,
 			},
+			Target:    pkg.Target,
+			Timeout:   pkg.Timeout,
+			Hooks:     pkg.Hooks,
+			Labels:    pkg.Labels,
+			Sensitive: pkg.Sensitive,
+			OnDrift:   pkg.OnDrift,
+			Interval:  pkg.Interval,
 		},
 		Package: pkg,
 	}
+	// Options are recorded only when set, so existing state is unchanged,
+	// and travel with the state so a removed package is purged as declared
+	for key, enabled := range map[string]bool{"hold": pkg.Hold, "purge": pkg.Purge, "autoremove": pkg.Autoremove, "classic": pkg.Classic} {
+		if enabled {
+			resource.Config[key] = true
+		}
+	}
+	for key, value := range map[string]string{"channel": pkg.Channel, "remote": pkg.Remote} {
+		if value != "" {
+			resource.Config[key] = value
+		}
+	}
+	return resource
 }
 
 // ValidateResources validates all created resources
@@ -113,3 +388,140 @@ func (rp *ResourceParser) ValidateResources(resources []Resource) error {
 	}
 	return nil
 }
+
+// ResourceFromState rebuilds a resource from its state entry so it can be
+// destroyed even when it is no longer declared in any resource file. The
+// type is looked up by the prefix of id.
+func (rp *ResourceParser) ResourceFromState(id ResourceID, state *ResourceState) (Resource, error) {
+	config, _ := state.Metadata["config"].(map[string]interface{})
+	if config == nil {
+		return nil, fmt.Errorf("state of %s has no recorded configuration", id)
+	}
+
+	var target common.Target
+	if raw, ok := state.Metadata["target"]; ok {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target recorded for %s: %w", id, err)
+		}
+		if err := json.Unmarshal(data, &target); err != nil {
+			return nil, fmt.Errorf("invalid target recorded for %s: %w", id, err)
+		}
+	}
+
+	module, localID := splitModuleID(id)
+	kind, rest, _ := strings.Cut(string(localID), ":")
+	entry := StateEntry{
+		ID:     id,
+		Module: module,
+		Name:   rest,
+		State:  state,
+		Config: config,
+		Target: target,
+		Labels: StateLabels(state),
+	}
+	if resourceType, ok := LookupResourceType(kind); ok {
+		return resourceType.FromState(entry)
+	}
+	if provider, ok := rp.providers[kind]; ok {
+		return rp.CreateResourceFromProvider(common.ProviderResource{
+			Type:     kind,
+			Name:     rest,
+			Provider: provider.Name,
+			Config:   config,
+			Labels:   entry.Labels,
+			Module:   module,
+			Target:   target,
+		})
+	}
+	return nil, fmt.Errorf("cannot rebuild resource %s from state", id)
+}
+
+func packageFromState(entry StateEntry) (Resource, error) {
+	manager, name, ok := strings.Cut(entry.Name, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid package resource ID: %s", entry.ID)
+	}
+	config := entry.Config
+	version, _ := config["version"].(string)
+	purge, _ := config["purge"].(bool)
+	autoremove, _ := config["autoremove"].(bool)
+	hold, _ := config["hold"].(bool)
+	classic, _ := config["classic"].(bool)
+	channel, _ := config["channel"].(string)
+	remote, _ := config["remote"].(string)
+	return NewPackageResource(common.Package{
+		Name:       name,
+		Version:    version,
+		Manager:    manager,
+		Labels:     entry.Labels,
+		Module:     entry.Module,
+		Target:     entry.Target,
+		Hold:       hold,
+		Purge:      purge,
+		Autoremove: autoremove,
+		Classic:    classic,
+		Channel:    channel,
+		Remote:     remote,
+	}), nil
+}
+
+func serviceFromState(entry StateEntry) (Resource, error) {
+	service := common.Service{Name: entry.Name, Labels: entry.Labels, Module: entry.Module, Target: entry.Target}
+	service.State, _ = entry.Config["state"].(string)
+	service.Manager, _ = entry.Config["manager"].(string)
+	if enabled, ok := entry.Config["enabled"].(bool); ok {
+		service.Enabled = &enabled
+	}
+	return NewServiceResource(service), nil
+}
+
+func systemdUnitFromState(entry StateEntry) (Resource, error) {
+	restart, _ := entry.Config["restart"].(bool)
+	return NewSystemdUnitResource(common.SystemdUnit{
+		Name:    entry.Name,
+		Restart: restart,
+		Labels:  entry.Labels,
+		Module:  entry.Module,
+		Target:  entry.Target,
+	}), nil
+}
+
+// fileFromState rebuilds a file from its recorded configuration, as its
+// content is not recorded
+func fileFromState(entry StateEntry) (Resource, error) {
+	return &FileResource{
+		BaseResource: BaseResource{
+			ID:     entry.ID,
+			Type:   "file",
+			Layer:  LayerConfiguration,
+			State:  *entry.State,
+			Config: entry.Config,
+			Target: entry.Target,
+			Labels: entry.Labels,
+		},
+		File: common.File{Path: entry.Name, Target: entry.Target, Labels: entry.Labels, Module: entry.Module},
+	}, nil
+}
+
+// moduleID namespaces the ID of a resource declared in a module, e.g.
+// module.nginx.package:apt:nginx
+func moduleID(module string, id ResourceID) ResourceID {
+	if module == "" {
+		return id
+	}
+	return ResourceID(fmt.Sprintf("module.%s.%s", module, id))
+}
+
+// splitModuleID returns the module and module-local ID of id
+func splitModuleID(id ResourceID) (string, ResourceID) {
+	rest, ok := strings.CutPrefix(string(id), "module.")
+	if !ok {
+		return "", id
+	}
+	module, localID, ok := strings.Cut(rest, ".")
+	if !ok {
+		return "", id
+	}
+	return module, ResourceID(localID)
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PermissionWarning flags a resource whose result would be unsafe, e.g. a
+// world-writable file
+type PermissionWarning struct {
+	ResourceID ResourceID
+	Message    string
+}
+
+func (w PermissionWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.ResourceID, w.Message)
+}
+
+// CheckPermissions returns a warning for every file that would be
+// world-writable, or whose created parent directories would be without the
+// sticky bit
+func CheckPermissions(resources []Resource) []PermissionWarning {
+	var warnings []PermissionWarning
+	for _, resource := range resources {
+		file, ok := resource.(*FileResource)
+		if !ok {
+			continue
+		}
+		if file.File.Mode&0002 != 0 {
+			warnings = append(warnings, PermissionWarning{
+				ResourceID: file.GetID(),
+				Message:    fmt.Sprintf("file would be world-writable (mode %04o)", file.File.Mode),
+			})
+		}
+		if file.File.DirMode&0002 != 0 && file.File.DirMode&01000 == 0 {
+			warnings = append(warnings, PermissionWarning{
+				ResourceID: file.GetID(),
+				Message:    fmt.Sprintf("parent directories would be world-writable without the sticky bit (mode %04o)", file.File.DirMode),
+			})
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].ResourceID < warnings[j].ResourceID })
+	return warnings
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// PlanFile is a plan saved by plan --output for apply. It pins the
+// checksums of the configuration and the state it was made from, and is
+// itself checksummed, and signed when a key is given, so apply can refuse
+// a plan that was edited or has gone stale.
+type PlanFile struct {
+	CreatedAt  string                 `json:"created_at"`
+	ConfigHash string                 `json:"config_hash"`
+	StateHash  string                 `json:"state_hash"`
+	Limit      []string               `json:"limit,omitempty"` // --limit the plan was made with
+	Summary    map[string]int         `json:"summary"`
+	Actions    []*Action              `json:"actions"`
+	Resources  map[string]interface{} `json:"resources"`
+	// PlanHash is the checksum of the file without PlanHash and Signature
+	PlanHash string `json:"plan_hash"`
+	// Signature is the HMAC-SHA256 of PlanHash with the plan key, if any
+	Signature string `json:"signature,omitempty"`
+}
+
+// NewPlanFile describes plan for saving
+func NewPlanFile(plan *Plan) *PlanFile {
+	file := &PlanFile{
+		CreatedAt:  plan.CreatedAt.Format("2006-01-02 15:04:05"),
+		ConfigHash: plan.ConfigHash,
+		StateHash:  plan.StateHash,
+		Summary: map[string]int{
+			"create": plan.GetActionCount(ActionCreate),
+			"update": plan.GetActionCount(ActionUpdate),
+			"delete": plan.GetActionCount(ActionDelete),
+			"no_op":  plan.GetActionCount(ActionNoOp),
+		},
+		Actions:   plan.Actions,
+		Resources: make(map[string]interface{}),
+	}
+	for _, action := range plan.Actions {
+		resource, exists := plan.Graph.GetResource(action.ResourceID)
+		if exists {
+			file.Resources[string(action.ResourceID)] = map[string]interface{}{
+				"type":   resource.GetType(),
+				"layer":  resource.GetLayer().String(),
+				"config": MaskConfig(resource, resource.GetConfig()),
+				"action": action.Type,
+				"reason": action.Metadata["reason"],
+			}
+		}
+	}
+	return file
+}
+
+// Write checksums the plan, signs it when key is not empty and writes it to
+// path
+func (f *PlanFile) Write(path string, key []byte) error {
+	hash, err := f.hash()
+	if err != nil {
+		return err
+	}
+	f.PlanHash = hash
+	f.Signature = ""
+	if len(key) > 0 {
+		f.Signature = signPlan(hash, key)
+	}
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write plan file: %w", err)
+	}
+	return nil
+}
+
+// ReadPlanFile reads the plan saved at path and checks that it is unchanged.
+// With a key the plan must carry a valid signature; without one a signed
+// plan is refused, since its signature cannot be checked.
+func ReadPlanFile(path string, key []byte) (*PlanFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan file: %w", err)
+	}
+	var file PlanFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse plan file %s: %w", path, err)
+	}
+	if file.PlanHash == "" || file.StateHash == "" {
+		return nil, fmt.Errorf("%s was not saved by this version of plan --output; plan again", path)
+	}
+
+	hash, err := file.hashWith(file.PlanHash)
+	if err != nil {
+		return nil, err
+	}
+	if !common.ChecksumsEqual(hash, file.PlanHash) {
+		return nil, fmt.Errorf("%s was modified after it was planned", path)
+	}
+	switch {
+	case len(key) > 0 && file.Signature == "":
+		return nil, fmt.Errorf("%s is not signed", path)
+	case len(key) > 0 && !hmac.Equal([]byte(file.Signature), []byte(signPlan(file.PlanHash, key))):
+		return nil, fmt.Errorf("%s has an invalid signature", path)
+	case len(key) == 0 && file.Signature != "":
+		return nil, fmt.Errorf("%s is signed; set the plan key to verify it", path)
+	}
+	return &file, nil
+}
+
+// Plan returns the saved actions as a plan on the resources of graph
+func (f *PlanFile) Plan(graph *Graph) (*Plan, error) {
+	for _, action := range f.Actions {
+		if _, ok := graph.GetResource(action.ResourceID); !ok {
+			return nil, fmt.Errorf("resource %s of the plan is not declared", action.ResourceID)
+		}
+	}
+	return &Plan{
+		Actions:    f.Actions,
+		CreatedAt:  time.Now(),
+		Graph:      graph,
+		ConfigHash: f.ConfigHash,
+		StateHash:  f.StateHash,
+	}, nil
+}
+
+// hash checksums the plan with the checksum algorithm in use
+func (f *PlanFile) hash() (string, error) {
+	return f.hashWith("")
+}
+
+// hashWith checksums the plan with the algorithm of checksum, or the one in
+// use when checksum is empty. The plan is checksummed as the JSON of its
+// decoded form, so values read back from a file hash the same as before.
+func (f *PlanFile) hashWith(checksum string) (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal plan: %w", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("failed to unmarshal plan: %w", err)
+	}
+	delete(fields, "plan_hash")
+	delete(fields, "signature")
+	if data, err = json.Marshal(fields); err != nil {
+		return "", fmt.Errorf("failed to marshal plan: %w", err)
+	}
+
+	if checksum == "" {
+		return common.Checksum(data), nil
+	}
+	algorithm, _ := common.ParseChecksum(checksum)
+	return common.ChecksumWith(algorithm, data)
+}
+
+func signPlan(hash string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"fmt"
+	"strings"
 	"time"
+	"github.com/settlectl/settle-core/common"
 	"github.com/settlectl/settle-core/inventory"
 )
 
@@ -11,6 +13,12 @@ type Planner struct {
 	graph        *Graph
 	stateManager *StateManager
 	logger       *inventory.Logger
+	hosts        map[string]*common.Host // When set, file checksums are compared with the hosts
+	selector     LabelSelector           // When set, only matching resources are planned
+	events       *EventBus               // When set, receives the lifecycle events of each plan
+	providers    []*Provider             // Rebuild removed resources of provider types
+	orphans      []Resource              // Removed resources added to the graph to delete them
+	readOnly     bool                    // Drift found while planning is not saved
 }
 
 func NewPlanner(graph *Graph, stateManager *StateManager, logger *inventory.Logger) *Planner {
@@ -21,8 +29,36 @@ func NewPlanner(graph *Graph, stateManager *StateManager, logger *inventory.Logg
 	}
 }
 
+// SetHosts enables refreshing checksummed resources from their hosts while planning
+func (p *Planner) SetHosts(hosts []common.Host) {
+	p.hosts = HostMap(hosts)
+}
+
+// SetSelector limits planning to resources whose labels match selector
+func (p *Planner) SetSelector(selector LabelSelector) {
+	p.selector = selector
+}
+
+// SetEvents publishes the lifecycle events of each plan to bus
+func (p *Planner) SetEvents(bus *EventBus) {
+	p.events = bus
+}
+
+// SetReadOnly keeps the drift found while planning in memory instead of
+// saving it, for callers that do not hold the state lock
+func (p *Planner) SetReadOnly(readOnly bool) {
+	p.readOnly = readOnly
+}
+
+// SetProviders lets the planner rebuild removed resources of the resource
+// types of providers, so they are deleted too
+func (p *Planner) SetProviders(providers []*Provider) {
+	p.providers = providers
+}
+
 // Plan creates an execution plan by comparing desired state with current state
 func (p *Planner) Plan() (*Plan, error) {
+	p.events.Publish(Event{Type: EventPlanStarted})
 	plan := &Plan{
 		Actions:   make([]*Action, 0),
 		CreatedAt: time.Now(),
@@ -41,6 +77,9 @@ func (p *Planner) Plan() (*Plan, error) {
 		if !exists {
 			return nil, fmt.Errorf("resource %s not found in graph", resourceID)
 		}
+		if !p.selector.Matches(resource.GetLabels()) || p.isOrphan(resourceID) {
+			continue
+		}
 
 		action, err := p.planResource(resource)
 		if err != nil {
@@ -48,15 +87,54 @@ func (p *Planner) Plan() (*Plan, error) {
 		}
 
 		if action != nil {
+			ClassifyImpact(p.graph, resource, action)
 			plan.Actions = append(plan.Actions, action)
 		}
 	}
 
+	// Resources still in state whose blocks were removed are deleted
+	p.orphans = append(p.orphans, AddOrphans(p.graph, p.stateManager, p.providers, p.logger)...)
+	for _, resource := range p.orphans {
+		state := p.stateManager.GetState(resource.GetID())
+		if state == nil || !p.selector.Matches(StateLabels(state)) {
+			continue
+		}
+		if len(p.hosts) > 0 && !TargetsAny(resource, p.hosts) {
+			continue
+		}
+		plan.Actions = append(plan.Actions, NewDeleteAction(resource, p.hosts, OrphanReason))
+	}
+
+	p.events.Publish(Event{Type: EventPlanFinished, Plan: plan})
 	return plan, nil
 }
 
+// isOrphan reports whether id is a removed resource the planner added to
+// the graph
+func (p *Planner) isOrphan(id ResourceID) bool {
+	for _, orphan := range p.orphans {
+		if orphan.GetID() == id {
+			return true
+		}
+	}
+	return false
+}
+
 // planResource determines what action (if any) is needed for a resource
 func (p *Planner) planResource(resource Resource) (*Action, error) {
+	// A resource whose when condition holds on none of its hosts is left
+	// alone rather than failing for lack of hosts
+	if target := resource.GetTarget(); target.When != "" && len(p.hosts) > 0 && len(TargetHosts(resource, p.hosts)) == 0 {
+		return &Action{
+			ResourceID: resource.GetID(),
+			Type:       ActionNoOp,
+			Changes:    []Change{},
+			Metadata: map[string]interface{}{
+				"reason": fmt.Sprintf("condition %q holds on no host", target.When),
+			},
+		}, nil
+	}
+
 	// Check if resource exists in state
 	currentState := p.stateManager.GetState(resource.GetID())
 
@@ -72,6 +150,35 @@ func (p *Planner) planResource(resource Resource) (*Action, error) {
 		}, nil
 	}
 
+	// Resources whose last action failed, was interrupted or skipped are retried
+	switch currentState.Status {
+	case StateFailed, StateUnknown, StateSkipped:
+		return &Action{
+			ResourceID: resource.GetID(),
+			Type:       ActionUpdate,
+			Changes:    []Change{},
+			Metadata: map[string]interface{}{
+				"reason": fmt.Sprintf("previous run left resource %s", currentState.Status),
+				"retry":  true,
+			},
+		}, nil
+	}
+
+	// Compare content hashes with the host so manual edits are detected
+	if err := p.refreshChecksum(resource, currentState); err != nil {
+		return nil, err
+	}
+
+	// Resources found changed on their host are handled by their drift
+	// strategy, re-applied by default
+	if currentState.Status == StateDrifted {
+		strategy, err := driftStrategyFor(resource)
+		if err != nil {
+			return nil, err
+		}
+		return strategy.PlanDrift(resource, currentState)
+	}
+
 	// Check for configuration drift
 	drifted, err := p.stateManager.DetectDrift(resource)
 	if err != nil {
@@ -89,6 +196,23 @@ func (p *Planner) planResource(resource Resource) (*Action, error) {
 		}, nil
 	}
 
+	// Pinned versions are compared with what is installed on the hosts
+	changes, hosts, err := p.versionChanges(resource, currentState)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		return &Action{
+			ResourceID: resource.GetID(),
+			Type:       ActionUpdate,
+			Changes:    changes,
+			Metadata: map[string]interface{}{
+				"reason": fmt.Sprintf("installed version differs on %s", strings.Join(hosts, ", ")),
+				"hosts":  hosts,
+			},
+		}, nil
+	}
+
 	// Resource is up to date
 	return &Action{
 		ResourceID: resource.GetID(),
@@ -100,11 +224,112 @@ func (p *Planner) planResource(resource Resource) (*Action, error) {
 	}, nil
 }
 
+// refreshChecksum marks a checksummed resource as drifted when the content on
+// its host no longer matches the checksum recorded at apply time
+func (p *Planner) refreshChecksum(resource Resource, currentState *ResourceState) error {
+	checksummed, ok := resource.(Checksummed)
+	if !ok || len(p.hosts) == 0 || currentState.Status != StateApplied {
+		return nil
+	}
+
+	// Hash with the algorithm the recorded checksum was made with
+	algorithm, _ := common.ParseChecksum(currentState.Checksum)
+	for _, host := range TargetHosts(resource, p.hosts) {
+		ctx := &inventory.Context{Host: host, Logger: p.logger}
+		remote, err := checksummed.RemoteChecksum(ctx, algorithm)
+		if err != nil {
+			return fmt.Errorf("failed to read remote checksum on %s: %w", host.Name, err)
+		}
+
+		// Content rendered for each host is compared with its rendering
+		expected := currentState.Checksum
+		if rendered, ok := resource.(HostRendered); ok {
+			checksum, err := rendered.HostChecksum(host, algorithm)
+			if err != nil {
+				return err
+			}
+			if checksum != "" {
+				expected = checksum
+			}
+		}
+		if common.ChecksumsEqual(remote, expected) {
+			continue
+		}
+
+		p.logger.Warning(fmt.Sprintf("%s changed on host %s", resource.GetID(), host.Name))
+		changes := []Change{{
+			Field:    "checksum",
+			OldValue: remote,
+			NewValue: expected,
+		}}
+		p.events.Publish(Event{Type: EventDriftDetected, ResourceID: resource.GetID(), Host: host.Name, Changes: changes})
+		if p.readOnly {
+			return p.stateManager.NoteDrifted(resource.GetID(), changes)
+		}
+		return p.stateManager.MarkDrifted(resource.GetID(), changes)
+	}
+
+	return nil
+}
+
+// versionChanges reads the installed version of a versioned resource on its
+// hosts and returns the changes needed with the hosts needing them
+func (p *Planner) versionChanges(resource Resource, currentState *ResourceState) ([]Change, []string, error) {
+	versioned, ok := resource.(Versioned)
+	if !ok || len(p.hosts) == 0 || currentState.Status != StateApplied {
+		return nil, nil, nil
+	}
+
+	var changes []Change
+	var hosts []string
+	for _, host := range TargetHosts(resource, p.hosts) {
+		ctx := &inventory.Context{Host: host, Logger: p.logger}
+		change, err := versioned.VersionChange(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read installed version on %s: %w", host.Name, err)
+		}
+		if change != nil {
+			changes = append(changes, *change)
+			hosts = append(hosts, host.Name)
+		}
+	}
+	return changes, hosts, nil
+}
+
+// NewDeleteAction creates a delete action whose metadata lists the hosts it
+// runs on and exactly what will be removed from them
+func NewDeleteAction(resource Resource, hosts map[string]*common.Host, reason string) *Action {
+	targetNames := make([]string, 0)
+	for _, host := range TargetHosts(resource, hosts) {
+		targetNames = append(targetNames, host.Name)
+	}
+
+	removes := []Removal{{Kind: resource.GetType(), Name: string(resource.GetID())}}
+	if describer, ok := resource.(RemovalDescriber); ok {
+		removes = describer.DescribeRemoval()
+	}
+
+	action := &Action{
+		ResourceID: resource.GetID(),
+		Type:       ActionDelete,
+		Changes:    []Change{},
+		Metadata: map[string]interface{}{
+			"reason":  reason,
+			"hosts":   targetNames,
+			"removes": removes,
+		},
+	}
+	ClassifyImpact(nil, resource, action)
+	return action
+}
+
 // Plan represents a complete execution plan
 type Plan struct {
-	Actions   []*Action `json:"actions"`
-	CreatedAt time.Time `json:"created_at"`
-	Graph     *Graph    `json:"graph"`
+	Actions    []*Action `json:"actions"`
+	CreatedAt  time.Time `json:"created_at"`
+	Graph      *Graph    `json:"graph"`
+	ConfigHash string    `json:"config_hash,omitempty"` // Checksum of the configuration files planned from
+	StateHash  string    `json:"state_hash,omitempty"`  // Checksum of the state planned from
 }
 
 // ValidatePlan validates that the plan can be executed
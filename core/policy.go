@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// PolicyViolation is a change of a plan, or a whole plan, that breaks a
+// policy
+type PolicyViolation struct {
+	Policy     string
+	Effect     string     // common.PolicyDeny or common.PolicyWarn
+	ResourceID ResourceID // empty when a command policy rejected the plan
+	Message    string
+}
+
+func (v PolicyViolation) String() string {
+	if v.ResourceID == "" {
+		return fmt.Sprintf("policy %s: %s", v.Policy, v.Message)
+	}
+	return fmt.Sprintf("policy %s: %s: %s", v.Policy, v.ResourceID, v.Message)
+}
+
+// Denied reports whether any of violations denies the plan
+func Denied(violations []PolicyViolation) bool {
+	for _, violation := range violations {
+		if violation.Effect == common.PolicyDeny {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluatePolicies returns the violations of policies by plan. Policies
+// limited to other projects than project are skipped.
+func EvaluatePolicies(ctx context.Context, plan *Plan, hosts map[string]*common.Host, policies []common.Policy, project string) ([]PolicyViolation, error) {
+	var violations []PolicyViolation
+	for _, policy := range policies {
+		if len(policy.Projects) > 0 && !slices.Contains(policy.Projects, project) {
+			continue
+		}
+		if policy.Command != "" {
+			violation, err := runPolicyCommand(ctx, plan, policy, project)
+			if err != nil {
+				return nil, err
+			}
+			if violation != nil {
+				violations = append(violations, *violation)
+			}
+			continue
+		}
+
+		for _, action := range plan.Actions {
+			if message, ok := policyMatches(plan, action, hosts, policy); ok {
+				if policy.Message != "" {
+					message = policy.Message
+				}
+				violations = append(violations, PolicyViolation{Policy: policy.Name, Effect: policy.Effect, ResourceID: action.ResourceID, Message: message})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// policyMatches reports whether action violates policy and describes how
+func policyMatches(plan *Plan, action *Action, hosts map[string]*common.Host, policy common.Policy) (string, bool) {
+	if action.Type == ActionNoOp {
+		return "", false
+	}
+	if len(policy.Actions) > 0 && !slices.Contains(policy.Actions, string(action.Type)) {
+		return "", false
+	}
+	resource, _ := plan.Graph.GetResource(action.ResourceID)
+	resourceType := resourceTypeOf(action.ResourceID)
+	if resource != nil {
+		resourceType = resource.GetType()
+	}
+	if len(policy.Types) > 0 && !slices.Contains(policy.Types, resourceType) {
+		return "", false
+	}
+	if len(policy.Resources) > 0 && !matchesAnyPattern(policy.Resources, string(action.ResourceID)) {
+		return "", false
+	}
+	if len(policy.Target.Hosts) > 0 || policy.Target.Group != "" {
+		touched := false
+		for _, name := range ActionHosts(plan, action, hosts) {
+			host, ok := hosts[name]
+			if slices.Contains(policy.Target.Hosts, name) || (ok && policy.Target.Group != "" && host.Group == policy.Target.Group) {
+				touched = true
+				break
+			}
+		}
+		if !touched {
+			return "", false
+		}
+	}
+
+	if policy.MaxMode < 0 {
+		if policy.Effect == common.PolicyWarn {
+			return fmt.Sprintf("%s of %s is discouraged", action.Type, resourceType), true
+		}
+		return fmt.Sprintf("%s of %s is not allowed", action.Type, resourceType), true
+	}
+	if resource == nil || action.Type == ActionDelete {
+		return "", false
+	}
+	mode, ok := resource.GetConfig()["mode"].(string)
+	if !ok {
+		return "", false
+	}
+	bits, err := strconv.ParseInt(mode, 8, 32)
+	if err != nil || int(bits)&^policy.MaxMode == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("mode %s exceeds %04o", mode, policy.MaxMode), true
+}
+
+// runPolicyCommand passes the plan as JSON to the policy's command, which
+// rejects it by failing; its output is the message
+func runPolicyCommand(ctx context.Context, plan *Plan, policy common.Policy, project string) (*PolicyViolation, error) {
+	data, err := json.Marshal(NewPlanFile(plan))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plan for policy %s: %w", policy.Name, err)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", policy.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "SETTLE_PROJECT="+project)
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil, nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return nil, fmt.Errorf("policy %s: failed to run %s: %w", policy.Name, policy.Command, err)
+	}
+
+	message := policy.Message
+	if message == "" {
+		message = strings.TrimSpace(string(output))
+	}
+	if message == "" {
+		message = "rejected the plan: " + err.Error()
+	}
+	return &PolicyViolation{Policy: policy.Name, Effect: policy.Effect, Message: message}, nil
+}
+
+// matchesAnyPattern reports whether id matches one of patterns. A pattern
+// ending in ** matches every ID starting with the rest; others are glob
+// patterns in which * does not match /.
+func matchesAnyPattern(patterns []string, id string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "**"); ok {
+			if strings.HasPrefix(id, prefix) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, id); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// maxProgressHosts bounds the status lines of a run; the remaining hosts are
+// summed up in one line
+const maxProgressHosts = 10
+
+// Progress shows a status line per host while a plan runs: how many of its
+// actions are done, the elapsed time and the action in progress. It is meant
+// for terminals: output written through it scrolls above the status lines,
+// which are redrawn in place every second.
+type Progress struct {
+	mu      sync.Mutex
+	out     io.Writer
+	hosts   []*hostProgress // in inventory order
+	byName  map[string]*hostProgress
+	started time.Time
+	drawn   int // status lines on screen below the output
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// hostProgress is the status of one host
+type hostProgress struct {
+	name        string
+	total, done int
+	failed      bool
+	action      *Action // in progress, or nil
+	finishedAt  time.Time
+}
+
+// NewProgress returns a progress display on out for the actions of plan,
+// counting for each host the actions that run on it
+func NewProgress(out io.Writer, plan *Plan, hosts []common.Host) *Progress {
+	p := &Progress{out: out, byName: make(map[string]*hostProgress)}
+	hostMap := HostMap(hosts)
+	for _, action := range plan.Actions {
+		for _, name := range ActionHosts(plan, action, hostMap) {
+			if p.byName[name] == nil {
+				p.byName[name] = &hostProgress{name: name}
+			}
+			p.byName[name].total++
+		}
+	}
+	for _, host := range hosts {
+		if status := p.byName[host.Name]; status != nil {
+			p.hosts = append(p.hosts, status)
+		}
+	}
+	return p
+}
+
+// Start draws the status lines and redraws them every second until Stop
+func (p *Progress) Start() {
+	p.mu.Lock()
+	p.started = time.Now()
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	p.draw()
+	p.mu.Unlock()
+
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.clear()
+				p.draw()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop draws the status lines a last time and leaves them on screen
+func (p *Progress) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.stopped
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stop = nil
+	p.clear()
+	p.draw()
+	p.drawn = 0
+}
+
+// Write prints data above the status lines, so loggers can write through the
+// progress display
+func (p *Progress) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.out.Write(data)
+	if p.stop != nil {
+		p.draw()
+	}
+	return n, err
+}
+
+// HandleEvent records the progress of each host from the action events of
+// a run; subscribe it to the executor's events
+func (p *Progress) HandleEvent(event Event) {
+	switch event.Type {
+	case EventActionStarted:
+		p.update(event.Host, func(status *hostProgress) {
+			status.action = event.Action
+		})
+	case EventActionCompleted:
+		p.update(event.Host, func(status *hostProgress) {
+			status.action = nil
+			status.done++
+			status.failed = status.failed || event.Error != nil
+			if status.done >= status.total || event.Error != nil {
+				status.finishedAt = event.At
+			}
+		})
+	}
+}
+
+func (p *Progress) update(host string, fn func(status *hostProgress)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := p.byName[host]
+	if status == nil {
+		return
+	}
+	fn(status)
+	if p.stop != nil {
+		p.clear()
+		p.draw()
+	}
+}
+
+// clear removes the status lines; the cursor is at the start of the line
+// below them
+func (p *Progress) clear() {
+	if p.drawn > 0 {
+		fmt.Fprintf(p.out, "\x1b[%dA\x1b[J", p.drawn)
+		p.drawn = 0
+	}
+}
+
+// draw prints a status line per host, busy hosts first when they do not all
+// fit
+func (p *Progress) draw() {
+	shown := p.hosts
+	if len(shown) > maxProgressHosts {
+		shown = nil
+		for _, status := range p.hosts {
+			if status.action != nil && len(shown) < maxProgressHosts-1 {
+				shown = append(shown, status)
+			}
+		}
+	}
+
+	nameWidth := 0
+	for _, status := range shown {
+		nameWidth = max(nameWidth, len(status.name))
+	}
+	var lines []string
+	for _, status := range shown {
+		lines = append(lines, p.statusLine(status, nameWidth))
+	}
+	if hidden := len(p.hosts) - len(shown); hidden > 0 {
+		finished := 0
+		for _, status := range p.hosts {
+			if !status.finishedAt.IsZero() {
+				finished++
+			}
+		}
+		lines = append(lines, fmt.Sprintf("  %d more hosts, %d of %d hosts finished", hidden, finished, len(p.hosts)))
+	}
+
+	for _, line := range lines {
+		fmt.Fprintf(p.out, "%s\n", line)
+	}
+	p.drawn = len(lines)
+}
+
+// statusLine returns e.g. "  web1  3/7     12s  update file:/etc/motd"
+func (p *Progress) statusLine(status *hostProgress, nameWidth int) string {
+	end := time.Now()
+	if !status.finishedAt.IsZero() {
+		end = status.finishedAt
+	}
+	elapsed := end.Sub(p.started).Round(time.Second)
+
+	var state string
+	switch {
+	case status.action != nil:
+		state = fmt.Sprintf("%s %s", status.action.Type, status.action.ResourceID)
+	case status.failed:
+		state = inventory.Colorize(inventory.ColorRed, "failed")
+	case status.done >= status.total:
+		state = inventory.Colorize(inventory.ColorGreen, "done")
+	default:
+		state = inventory.Colorize(inventory.ColorDim, "waiting")
+	}
+	counts := fmt.Sprintf("%d/%d", status.done, status.total)
+	return strings.TrimRight(fmt.Sprintf("  %-*s  %*s  %6s  %s", nameWidth, status.name, len(fmt.Sprint(status.total))*2+1, counts, elapsed, state), " ")
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// ProviderProtocol is the version of the JSON protocol spoken with
+// providers. A provider reports the version it speaks in its schema.
+const ProviderProtocol = 1
+
+// ProviderPrefix names provider executables: the provider postgres is
+// settle-provider-postgres
+const ProviderPrefix = "settle-provider-"
+
+// ProviderDir is where providers are looked up in the project before PATH
+const ProviderDir = "providers"
+
+// Provider is an external program implementing resource types. Settle runs
+// it on this machine once per call, with the operation as its argument, a
+// JSON request on stdin and a JSON response on stdout:
+//
+//	schema   the resource types and their attributes
+//	read     the configuration observed on a host, or that it does not exist
+//	apply    bring a host to the configuration
+//	destroy  remove the resource from a host
+//
+// A nonzero exit status fails the call with stderr as the error.
+type Provider struct {
+	Name    string
+	Command string // resolved path of the executable
+	Types   map[string]ProviderType
+}
+
+// ProviderType is a resource type of a provider as its schema declares it
+type ProviderType struct {
+	Layer      string                       `json:"layer,omitempty"` // e.g. infrastructure; application when empty
+	Attributes map[string]ProviderAttribute `json:"attributes"`
+	// Disruptive lists the actions (create, update, delete) that interrupt
+	// what runs on a host; only delete when empty
+	Disruptive []string `json:"disruptive,omitempty"`
+}
+
+// ProviderAttribute is an attribute of a provider's resource type
+type ProviderAttribute struct {
+	Type      string   `json:"type"` // string, bool, int, duration, list, map or any
+	Required  bool     `json:"required,omitempty"`
+	Values    []string `json:"values,omitempty"`    // allowed values of a string, if limited
+	Sensitive bool     `json:"sensitive,omitempty"` // masked in plans, logs and reports
+}
+
+// ProviderSchema is the response to the schema call
+type ProviderSchema struct {
+	Protocol  int                     `json:"protocol"`
+	Resources map[string]ProviderType `json:"resources"`
+}
+
+// ProviderRequest is sent to the read, apply and destroy calls
+type ProviderRequest struct {
+	Protocol int                    `json:"protocol"`
+	RunID    string                 `json:"run_id,omitempty"`
+	Type     string                 `json:"type"`
+	Name     string                 `json:"name"`
+	Config   map[string]interface{} `json:"config"`
+	Host     ProviderHost           `json:"host"`
+}
+
+// ProviderHost is the host a call is for, so the provider can reach it
+type ProviderHost struct {
+	Name      string            `json:"name"`
+	Hostname  string            `json:"hostname"`
+	User      string            `json:"user,omitempty"`
+	Port      int               `json:"port,omitempty"`
+	KeyFile   string            `json:"key_file,omitempty"`
+	Transport string            `json:"transport,omitempty"`
+	Vars      map[string]string `json:"vars,omitempty"`
+}
+
+// ProviderResponse is the response to the read, apply and destroy calls
+type ProviderResponse struct {
+	Exists bool                   `json:"exists"`           // read: the resource exists on the host
+	Config map[string]interface{} `json:"config,omitempty"` // read: its observed configuration
+	Error  string                 `json:"error,omitempty"`  // fails the call
+}
+
+// providerLayers are the layers a provider may place its resource types in
+var providerLayers = map[string]Layer{
+	"foundation":     LayerFoundation,
+	"platform":       LayerPlatform,
+	"infrastructure": LayerInfrastructure,
+	"application":    LayerApplication,
+	"configuration":  LayerConfiguration,
+	"runtime":        LayerRuntime,
+}
+
+// FindProvider returns the executable of provider: its command, resolved
+// against dir when it is a relative path, or else settle-provider-<name> in
+// dir/providers, then in PATH
+func FindProvider(provider common.Provider, dir string) (string, error) {
+	command := provider.Command
+	if command == "" {
+		local := filepath.Join(dir, ProviderDir, ProviderPrefix+provider.Name)
+		if path, err := exec.LookPath(local); err == nil {
+			return path, nil
+		}
+		command = ProviderPrefix + provider.Name
+	} else if strings.Contains(command, "/") && !filepath.IsAbs(command) {
+		command = filepath.Join(dir, command)
+	}
+
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", fmt.Errorf("provider %s not found: %w", provider.Name, err)
+	}
+	return path, nil
+}
+
+// LoadProvider finds provider and asks it for its resource types
+func LoadProvider(ctx context.Context, provider common.Provider, dir string) (*Provider, error) {
+	path, err := FindProvider(provider, dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &Provider{Name: provider.Name, Command: path}
+
+	var schema ProviderSchema
+	if err := p.call(ctx, "schema", map[string]int{"protocol": ProviderProtocol}, &schema); err != nil {
+		return nil, err
+	}
+	if schema.Protocol != ProviderProtocol {
+		return nil, fmt.Errorf("provider %s speaks protocol %d, expected %d", p.Name, schema.Protocol, ProviderProtocol)
+	}
+	if len(schema.Resources) == 0 {
+		return nil, fmt.Errorf("provider %s has no resource types", p.Name)
+	}
+	for name, resourceType := range schema.Resources {
+		if _, err := resourceType.layer(); err != nil {
+			return nil, fmt.Errorf("provider %s: resource type %s: %w", p.Name, name, err)
+		}
+		for attrName, attr := range resourceType.Attributes {
+			switch attr.Type {
+			case "string", "bool", "int", "duration", "list", "map", "any":
+			default:
+				return nil, fmt.Errorf("provider %s: attribute %s of %s has unsupported type %q", p.Name, attrName, name, attr.Type)
+			}
+		}
+	}
+	p.Types = schema.Resources
+	return p, nil
+}
+
+func (t ProviderType) layer() (Layer, error) {
+	if t.Layer == "" {
+		return LayerApplication, nil
+	}
+	layer, ok := providerLayers[t.Layer]
+	if !ok {
+		return 0, fmt.Errorf("unknown layer %q", t.Layer)
+	}
+	return layer, nil
+}
+
+// call runs operation with request on stdin and decodes stdout into
+// response
+func (p *Provider) call(ctx context.Context, operation string, request, response interface{}) error {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request for provider %s: %w", operation, p.Name, err)
+	}
+
+	cmd := exec.CommandContext(ctx, p.Command, operation)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return fmt.Errorf("provider %s %s: %s", p.Name, operation, message)
+		}
+		return fmt.Errorf("provider %s %s: %w", p.Name, operation, err)
+	}
+
+	if response == nil || stdout.Len() == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), response); err != nil {
+		return fmt.Errorf("provider %s %s returned invalid JSON: %w", p.Name, operation, err)
+	}
+	return nil
+}
+
+// ProviderResource is a resource of a type implemented by a provider
+type ProviderResource struct {
+	BaseResource
+	Declared common.ProviderResource
+	provider *Provider
+}
+
+// NewProviderResource creates the resource for declared, implemented by
+// provider
+func NewProviderResource(provider *Provider, declared common.ProviderResource) (Resource, error) {
+	resourceType, ok := provider.Types[declared.Type]
+	if !ok {
+		return nil, fmt.Errorf("provider %s has no resource type %s", provider.Name, declared.Type)
+	}
+	layer, err := resourceType.layer()
+	if err != nil {
+		return nil, err
+	}
+
+	sensitive := declared.Sensitive
+	for name, attr := range resourceType.Attributes {
+		if attr.Sensitive {
+			sensitive = append(sensitive, name)
+		}
+	}
+	return &ProviderResource{
+		BaseResource: BaseResource{
+			ID:    moduleID(declared.Module, ResourceID(fmt.Sprintf("%s:%s", declared.Type, declared.Name))),
+			Type:  declared.Type,
+			Layer: layer,
+			State: ResourceState{
+				Status: StatePending,
+			},
+			Config:    declared.Config,
+			Target:    declared.Target,
+			Timeout:   declared.Timeout,
+			Hooks:     declared.Hooks,
+			Labels:    declared.Labels,
+			Sensitive: sensitive,
+			OnDrift:   declared.OnDrift,
+			Interval:  declared.Interval,
+		},
+		Declared: declared,
+		provider: provider,
+	}, nil
+}
+
+func (r *ProviderResource) Apply(ctx *inventory.Context) error {
+	ctx.Logger.Info(fmt.Sprintf("Applying %s %s with provider %s", r.Declared.Type, r.Declared.Name, r.provider.Name))
+	return r.invoke(ctx, "apply", nil)
+}
+
+func (r *ProviderResource) Destroy(ctx *inventory.Context) error {
+	ctx.Logger.Info(fmt.Sprintf("Destroying %s %s with provider %s", r.Declared.Type, r.Declared.Name, r.provider.Name))
+	return r.invoke(ctx, "destroy", nil)
+}
+
+// Impact reports the actions the provider's schema declares disruptive
+func (r *ProviderResource) Impact(action *Action) (Impact, string) {
+	disruptive := r.provider.Types[r.Declared.Type].Disruptive
+	if len(disruptive) == 0 {
+		disruptive = []string{string(ActionDelete)}
+	}
+	if slices.Contains(disruptive, string(action.Type)) {
+		return ImpactDisruptive, fmt.Sprintf("%s of %s %s", action.Type, r.Declared.Type, r.Declared.Name)
+	}
+	return ImpactSafe, ""
+}
+
+// Read returns the configuration the provider observes, or nil if the
+// resource does not exist on the host
+func (r *ProviderResource) Read(ctx *inventory.Context) (map[string]interface{}, error) {
+	var response ProviderResponse
+	if err := r.invoke(ctx, "read", &response); err != nil {
+		return nil, err
+	}
+	if !response.Exists {
+		return nil, nil
+	}
+	if response.Config == nil {
+		return map[string]interface{}{}, nil
+	}
+	return response.Config, nil
+}
+
+// invoke calls the provider with the resource and the context's host
+func (r *ProviderResource) invoke(ctx *inventory.Context, operation string, response *ProviderResponse) error {
+	request := ProviderRequest{
+		Protocol: ProviderProtocol,
+		RunID:    inventory.RunIDFromContext(ctx.Context()),
+		Type:     r.Declared.Type,
+		Name:     r.Declared.Name,
+		Config:   r.Config,
+	}
+	if host := ctx.Host; host != nil {
+		request.Host = ProviderHost{
+			Name:      host.Name,
+			Hostname:  host.Hostname,
+			User:      host.User,
+			Port:      host.Port,
+			KeyFile:   host.Keyfile,
+			Transport: host.Transport,
+			Vars:      host.Vars,
+		}
+	}
+
+	if response == nil {
+		response = &ProviderResponse{}
+	}
+	if err := r.provider.call(ctx.Context(), operation, request, response); err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("provider %s %s %s: %s", r.provider.Name, operation, r.ID, response.Error)
+	}
+	return nil
+}
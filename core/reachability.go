@@ -0,0 +1,152 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// ReachabilityCache remembers hosts that recently accepted an SSH connection,
+// so consecutive commands do not connect to every host again just to check
+// it is up
+type ReachabilityCache struct {
+	path    string
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]reachabilityEntry
+}
+
+type reachabilityEntry struct {
+	Address   string    `json:"address"` // user@hostname:port the check connected to
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// LoadReachabilityCache reads the cache at path. A missing or unreadable
+// cache starts empty.
+func LoadReachabilityCache(path string, ttl time.Duration) *ReachabilityCache {
+	cache := &ReachabilityCache{
+		path:    path,
+		ttl:     ttl,
+		entries: make(map[string]reachabilityEntry),
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &cache.entries); err != nil {
+			cache.entries = make(map[string]reachabilityEntry)
+		}
+	}
+	return cache
+}
+
+// Fresh reports whether host was reachable at its current address within the TTL
+func (c *ReachabilityCache) Fresh(host *common.Host) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[host.Name]
+	return ok && entry.Address == hostAddress(host) && time.Since(entry.CheckedAt) < c.ttl
+}
+
+// Record marks host as reachable now
+func (c *ReachabilityCache) Record(host *common.Host) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[host.Name] = reachabilityEntry{Address: hostAddress(host), CheckedAt: time.Now()}
+}
+
+// Forget removes host from the cache
+func (c *ReachabilityCache) Forget(host *common.Host) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host.Name)
+}
+
+// Save writes the cache, dropping expired entries
+func (c *ReachabilityCache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, entry := range c.entries {
+		if time.Since(entry.CheckedAt) >= c.ttl {
+			delete(c.entries, name)
+		}
+	}
+
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode reachability cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write reachability cache: %w", err)
+	}
+	return nil
+}
+
+func hostAddress(host *common.Host) string {
+	return fmt.Sprintf("%s@%s:%d", host.User, host.Hostname, host.Port)
+}
+
+// CheckReachability connects to every host the cache does not know to be
+// reachable, at most ssh.MaxConnections at a time, and returns the error of
+// each unreachable host by name. A nil cache checks every host; hosts with
+// the local transport need no check.
+func CheckReachability(ctx context.Context, hosts []common.Host, cache *ReachabilityCache) map[string]error {
+	unreachable := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, ssh.MaxConnections)
+
+	for i := range hosts {
+		host := hosts[i] // copy: connecting may fill in details from ~/.ssh/config
+		if host.Transport == common.TransportLocal {
+			continue
+		}
+		if cache != nil && cache.Fresh(&host) {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				mu.Lock()
+				unreachable[host.Name] = ctx.Err()
+				mu.Unlock()
+				return
+			}
+
+			err := ssh.PingHost(&host)
+			if cache != nil {
+				if err == nil {
+					cache.Record(&hosts[i])
+				} else {
+					cache.Forget(&hosts[i])
+				}
+			}
+			if err != nil {
+				mu.Lock()
+				unreachable[host.Name] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return unreachable
+}
+
+// ErrUnreachable is returned when hosts fail the reachability check
+var ErrUnreachable = errors.New("hosts are not reachable")
@@ -0,0 +1,59 @@
+package core
+
+import "github.com/settlectl/settle-core/common"
+
+// ResourceType is a kind of resource Settle manages. Each type registers
+// itself, so the resource parser creates its resources and clean rebuilds
+// them from the state by the prefix of their IDs without a list of every
+// type.
+type ResourceType struct {
+	// Name is the block type and the prefix of resource IDs, e.g. package
+	Name string
+	// New creates a resource from its declaration, e.g. a common.Package
+	New func(declared interface{}) (Resource, error)
+	// FromState rebuilds a resource that may no longer be declared from its
+	// state entry
+	FromState func(entry StateEntry) (Resource, error)
+}
+
+// StateEntry is the state of a resource with its ID taken apart
+type StateEntry struct {
+	ID     ResourceID
+	Module string
+	Name   string // the ID after the module and the type, e.g. apt:nginx
+	State  *ResourceState
+	Config map[string]interface{} // the configuration recorded when it was applied
+	Target common.Target
+	Labels map[string]string
+}
+
+var (
+	resourceTypes     = make(map[string]ResourceType)
+	resourceTypeNames []string // in registration order
+)
+
+// RegisterResourceType makes a resource type available. It is meant to be
+// called from init functions and panics when the type is incomplete or
+// already registered.
+func RegisterResourceType(resourceType ResourceType) {
+	if resourceType.Name == "" || resourceType.New == nil || resourceType.FromState == nil {
+		panic("core: incomplete resource type " + resourceType.Name)
+	}
+	if _, ok := resourceTypes[resourceType.Name]; ok {
+		panic("core: resource type " + resourceType.Name + " registered twice")
+	}
+	resourceTypes[resourceType.Name] = resourceType
+	resourceTypeNames = append(resourceTypeNames, resourceType.Name)
+}
+
+// LookupResourceType returns the registered resource type name
+func LookupResourceType(name string) (ResourceType, bool) {
+	resourceType, ok := resourceTypes[name]
+	return resourceType, ok
+}
+
+// ResourceTypeNames returns the names of the registered resource types in
+// the order they were registered
+func ResourceTypeNames() []string {
+	return append([]string(nil), resourceTypeNames...)
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Report formats understood by WriteReport
+const (
+	ReportJSON  = "json"
+	ReportJUnit = "junit"
+)
+
+// RunReport is the machine-readable form of an ExecutionResult
+type RunReport struct {
+	RunID       string          `json:"run_id"`
+	StartedAt   time.Time       `json:"started_at"`
+	Duration    float64         `json:"duration_seconds"`
+	Success     bool            `json:"success"`
+	Interrupted bool            `json:"interrupted"`
+	Error       string          `json:"error,omitempty"`
+	Actions     []*ActionReport `json:"actions"`
+	// Discrepancies between the plan and what the run observed on hosts
+	Discrepancies []Discrepancy `json:"discrepancies,omitempty"`
+}
+
+// ActionReport describes the outcome of one planned action
+type ActionReport struct {
+	ResourceID ResourceID        `json:"resource_id"`
+	Type       ActionType        `json:"type"`
+	Reason     string            `json:"reason,omitempty"`
+	Impact     Impact            `json:"impact,omitempty"` // safe or disruptive
+	Owner      string            `json:"owner,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Status     string            `json:"status"` // succeeded, failed, skipped or not_started
+	Duration   float64           `json:"duration_seconds"`
+	Error      string            `json:"error,omitempty"`
+	SkipReason string            `json:"skip_reason,omitempty"`
+	Hosts      []*HostReport     `json:"hosts"`
+}
+
+// HostReport describes the outcome of an action on one host
+type HostReport struct {
+	Host     string  `json:"host"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Action report statuses
+const (
+	ReportSucceeded  = "succeeded"
+	ReportFailed     = "failed"
+	ReportSkipped    = "skipped"
+	ReportNotStarted = "not_started"
+)
+
+// NewRunReport builds a report from an execution result. Planned actions the
+// run never reached are included as not started.
+func NewRunReport(result *ExecutionResult) *RunReport {
+	report := &RunReport{
+		RunID:         result.RunID,
+		StartedAt:     result.StartedAt,
+		Duration:      result.GetDuration().Seconds(),
+		Success:       result.Success,
+		Interrupted:   result.Interrupted,
+		Actions:       make([]*ActionReport, 0),
+		Discrepancies: result.Discrepancies,
+	}
+	if result.Error != nil {
+		report.Error = inventory.Redact(result.Error.Error())
+	}
+
+	for _, execAction := range result.Actions {
+		actionReport := &ActionReport{
+			ResourceID: execAction.Action.ResourceID,
+			Type:       execAction.Action.Type,
+			Reason:     actionReason(execAction.Action),
+			Impact:     actionImpact(execAction.Action),
+			Status:     ReportSucceeded,
+			Duration:   elapsed(execAction.StartedAt, execAction.CompletedAt, execAction.FailedAt),
+			Hosts:      make([]*HostReport, 0),
+		}
+		actionReport.setLabels(result.Plan)
+		if execAction.Error != nil {
+			actionReport.Status = ReportFailed
+			actionReport.Error = inventory.Redact(execAction.Error.Error())
+		}
+		if execAction.SkipReason != "" {
+			actionReport.Status = ReportSkipped
+			actionReport.SkipReason = execAction.SkipReason
+		}
+		for _, hostResult := range execAction.Hosts {
+			hostReport := &HostReport{
+				Host:     hostResult.Host,
+				Status:   ReportSucceeded,
+				Duration: elapsed(hostResult.StartedAt, hostResult.CompletedAt, hostResult.FailedAt),
+			}
+			if hostResult.Error != nil {
+				hostReport.Status = ReportFailed
+				hostReport.Error = inventory.Redact(hostResult.Error.Error())
+			}
+			actionReport.Hosts = append(actionReport.Hosts, hostReport)
+		}
+		report.Actions = append(report.Actions, actionReport)
+	}
+
+	// Concurrent actions finish out of plan order, so the actions not
+	// started are those of the plan that have no outcome
+	if result.Plan != nil {
+		started := make(map[ResourceID]bool, len(result.Actions))
+		for _, execAction := range result.Actions {
+			started[execAction.Action.ResourceID] = true
+		}
+		for _, action := range result.Plan.Actions {
+			if started[action.ResourceID] {
+				continue
+			}
+			actionReport := &ActionReport{
+				ResourceID: action.ResourceID,
+				Type:       action.Type,
+				Reason:     actionReason(action),
+				Impact:     actionImpact(action),
+				Status:     ReportNotStarted,
+				Hosts:      make([]*HostReport, 0),
+			}
+			actionReport.setLabels(result.Plan)
+			report.Actions = append(report.Actions, actionReport)
+		}
+	}
+
+	return report
+}
+
+// WriteReport writes the report in the given format
+func (r *RunReport) WriteReport(w io.Writer, format string) error {
+	switch format {
+	case ReportJSON:
+		return r.WriteJSON(w)
+	case ReportJUnit:
+		return r.WriteJUnit(w)
+	default:
+		return fmt.Errorf("unsupported report format: %s (expected %s or %s)", format, ReportJSON, ReportJUnit)
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	ID        string          `xml:"id,attr,omitempty"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report as JUnit XML with one test case per action
+// and host, so CI systems can point at the failing resource
+func (r *RunReport) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		ID:        r.RunID,
+		Name:      "settle",
+		Time:      formatSeconds(r.Duration),
+		Timestamp: r.StartedAt.Format(time.RFC3339),
+	}
+
+	for _, action := range r.Actions {
+		className := fmt.Sprintf("%s.%s", action.Type, action.ResourceID)
+
+		switch action.Status {
+		case ReportNotStarted, ReportSkipped:
+			message := "not started"
+			if action.Status == ReportSkipped {
+				message = "skipped: " + action.SkipReason
+			}
+			suite.Cases = append(suite.Cases, junitTestCase{
+				Name:      string(action.ResourceID),
+				ClassName: className,
+				Time:      formatSeconds(0),
+				Skipped:   &junitSkipped{Message: message},
+			})
+			continue
+		}
+
+		// Failures before any host ran (e.g. no matching hosts) still get a case
+		if len(action.Hosts) == 0 {
+			testCase := junitTestCase{
+				Name:      string(action.ResourceID),
+				ClassName: className,
+				Time:      formatSeconds(action.Duration),
+			}
+			if action.Status == ReportFailed {
+				testCase.Failure = &junitFailure{Message: action.Error, Type: string(action.Type), Text: action.Error}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+			continue
+		}
+
+		for _, host := range action.Hosts {
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("%s on %s", action.ResourceID, host.Host),
+				ClassName: className,
+				Time:      formatSeconds(host.Duration),
+			}
+			if host.Status == ReportFailed {
+				testCase.Failure = &junitFailure{Message: host.Error, Type: string(action.Type), Text: host.Error}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+	}
+
+	for _, testCase := range suite.Cases {
+		suite.Tests++
+		switch {
+		case testCase.Failure != nil:
+			suite.Failures++
+		case testCase.Skipped != nil:
+			suite.Skipped++
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// setLabels copies the owner and labels of the action's resource
+func (a *ActionReport) setLabels(plan *Plan) {
+	if plan == nil || plan.Graph == nil {
+		return
+	}
+	if resource, ok := plan.Graph.GetResource(a.ResourceID); ok && len(resource.GetLabels()) > 0 {
+		a.Labels = resource.GetLabels()
+		a.Owner = Owner(a.Labels)
+	}
+}
+
+func actionReason(action *Action) string {
+	reason, _ := action.Metadata["reason"].(string)
+	return reason
+}
+
+func actionImpact(action *Action) Impact {
+	impact, _ := action.Metadata["impact"].(string)
+	return Impact(impact)
+}
+
+// elapsed returns the seconds between start and whichever of completed or
+// failed is set
+func elapsed(start, completed, failed time.Time) float64 {
+	switch {
+	case !completed.IsZero():
+		return completed.Sub(start).Seconds()
+	case !failed.IsZero():
+		return failed.Sub(start).Seconds()
+	}
+	return 0
+}
+
+func formatSeconds(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
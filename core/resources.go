@@ -1,12 +1,14 @@
 package core
 
 import (
-	"context"
 	"fmt"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/settlectl/settle-core/common"
 	pkgmanager "github.com/settlectl/settle-core/drivers/pkg"
+	"github.com/settlectl/settle-core/drivers/svc"
 	"github.com/settlectl/settle-core/inventory"
 )
 
@@ -30,6 +32,13 @@ const (
 	EdgeTriggers   EdgeType = "triggers"
 )
 
+// TargetFirst reports whether the target of an edge of this type is applied
+// before the resource declaring the edge. A resource comes after what it
+// depends on, configures or monitors, and before what it triggers.
+func (t EdgeType) TargetFirst() bool {
+	return t != EdgeTriggers
+}
+
 const (
 	LayerFoundation     Layer = iota //hardware, OS, etc.
 	LayerPlatform                    //packageManagers, base services
@@ -65,6 +74,22 @@ type ResourceState struct {
 	LastApplied time.Time              `json:"last_applied"`
 	Checksum    string                 `json:"checksum"`
 	Metadata    map[string]interface{} `json:"metadata"`
+	LastRunID   string                 `json:"last_run_id,omitempty"`
+	// History holds the previous states of the resource, oldest first
+	History []StateRecord `json:"history,omitempty"`
+	// Duration is how long the last apply took; runs with several actions
+	// at a time start the slowest resources first
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// StateRecord is an earlier state of a resource: when an action on it ended,
+// how, with which checksum and in which run
+type StateRecord struct {
+	Status   StateStatus `json:"status"`
+	At       time.Time   `json:"at"`
+	Checksum string      `json:"checksum,omitempty"`
+	RunID    string      `json:"run_id,omitempty"`
+	Error    string      `json:"error,omitempty"`
 }
 
 type Action struct {
@@ -85,12 +110,59 @@ type Resource interface {
 	SetState(state *ResourceState)
 	GetConfig() map[string]interface{}
 	SetConfig(config map[string]interface{})
+	GetTarget() common.Target
+	// GetTimeout returns the per-action timeout, or 0 for none
+	GetTimeout() time.Duration
+	// GetLabels returns attribution labels such as owner, team or ticket
+	GetLabels() map[string]string
 
 	Validate() error
 
 	Plan(ctx *inventory.Context) (*Action, error)
 	Apply(ctx *inventory.Context) error
 	Destroy(ctx *inventory.Context) error
+	// Read returns the configuration observed on the host, or nil if the
+	// resource does not exist there
+	Read(ctx *inventory.Context) (map[string]interface{}, error)
+}
+
+// Removal describes one thing destroying a resource removes from a host
+type Removal struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RemovalDescriber is implemented by resources that can say exactly what
+// Destroy will remove, so delete confirmations are informed
+type RemovalDescriber interface {
+	DescribeRemoval() []Removal
+}
+
+// Versioned is implemented by resources whose installed version on a host
+// is compared with the requested one while planning
+type Versioned interface {
+	// VersionChange returns the change of version the host needs, or nil
+	// when the installed version satisfies the requested one
+	VersionChange(ctx *inventory.Context) (*Change, error)
+}
+
+// Checksummed is implemented by resources whose content on the host is
+// tracked by hash, so edits made on the host are detected as drift
+type Checksummed interface {
+	// Checksum returns "<algorithm>:<hex>" of the declared content
+	Checksum() string
+	RemoteChecksum(ctx *inventory.Context, algorithm common.HashAlgorithm) (string, error)
+}
+
+// HostRendered is implemented by resources whose content may reference the
+// host it is applied to, e.g. ${host.vars.nginx_port}
+type HostRendered interface {
+	// Render returns the content for host
+	Render(host *common.Host) (string, error)
+	// HostChecksum returns "<algorithm>:<hex>" of the content rendered for
+	// host, or "" when the content does not reference the host
+	HostChecksum(host *common.Host, algorithm common.HashAlgorithm) (string, error)
 }
 
 type BaseResource struct {
@@ -100,6 +172,13 @@ type BaseResource struct {
 	Dependencies []Dependency           `json:"dependencies"`
 	State        ResourceState          `json:"state"`
 	Config       map[string]interface{} `json:"config"`
+	Target       common.Target          `json:"target"`
+	Timeout      time.Duration          `json:"timeout,omitempty"`
+	Hooks        common.Hooks           `json:"hooks,omitempty"`
+	Labels       map[string]string      `json:"labels,omitempty"`
+	Sensitive    []string               `json:"sensitive,omitempty"`
+	OnDrift      string                 `json:"on_drift,omitempty"` // drift strategy; remediate when empty
+	Interval     time.Duration          `json:"interval,omitempty"` // re-check interval in watch and daemon modes
 }
 
 func (r *BaseResource) GetID() ResourceID                       { return r.ID }
@@ -110,6 +189,12 @@ func (r *BaseResource) GetState() *ResourceState                { return &r.Stat
 func (r *BaseResource) SetState(state *ResourceState)           { r.State = *state }
 func (r *BaseResource) GetConfig() map[string]interface{}       { return r.Config }
 func (r *BaseResource) SetConfig(config map[string]interface{}) { r.Config = config }
+func (r *BaseResource) GetTarget() common.Target                { return r.Target }
+func (r *BaseResource) GetTimeout() time.Duration               { return r.Timeout }
+func (r *BaseResource) GetHooks() common.Hooks                  { return r.Hooks }
+func (r *BaseResource) GetLabels() map[string]string            { return r.Labels }
+func (r *BaseResource) GetOnDrift() string                      { return r.OnDrift }
+func (r *BaseResource) GetInterval() time.Duration              { return r.Interval }
 
 func (r *BaseResource) AddDependency(dep Dependency) error {
 	r.Dependencies = append(r.Dependencies, dep)
@@ -123,6 +208,11 @@ func (r *BaseResource) Validate() error {
 	if r.Type == "" {
 		return fmt.Errorf("resource type is required")
 	}
+	if r.OnDrift != "" {
+		if _, err := driftStrategyFor(r); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -165,6 +255,10 @@ func (r *BaseResource) Destroy(ctx *inventory.Context) error {
 	return fmt.Errorf("Destroy not implemented for resource type %s", r.Type)
 }
 
+func (r *BaseResource) Read(ctx *inventory.Context) (map[string]interface{}, error) {
+	return nil, fmt.Errorf("Read not implemented for resource type %s", r.Type)
+}
+
 // HostResource represents a host resource
 type HostResource struct {
 	BaseResource
@@ -177,19 +271,13 @@ func (r *HostResource) Apply(ctx *inventory.Context) error {
 
 	ctx.Logger.Info(fmt.Sprintf("Validating host connectivity: %s", r.Host.Name))
 
-	// Test SSH connectivity
-	if ctx.SSHClient == nil {
-		// Create SSH client for this host
-		sshClient, err := ctx.CreateSSHClient(&r.Host)
+	// Opening the transport proves connectivity; an open one already has
+	if ctx.Transport == nil {
+		transport, err := ctx.Connect(&r.Host)
 		if err != nil {
-			return fmt.Errorf("failed to create SSH client for host %s: %w", r.Host.Name, err)
+			return fmt.Errorf("host %s is not reachable: %w", r.Host.Name, err)
 		}
-		ctx.SSHClient = sshClient
-	}
-
-
-	if err := ctx.SSHClient.TestConnection(); err != nil {
-		return fmt.Errorf("host %s is not reachable: %w", r.Host.Name, err)
+		ctx.Transport = transport
 	}
 
 	ctx.Logger.Info(fmt.Sprintf("Host %s is reachable", r.Host.Name))
@@ -201,8 +289,8 @@ func (r *HostResource) Destroy(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Cleaning up host: %s", r.Host.Name))
 
 
-	if ctx.SSHClient != nil {
-		ctx.SSHClient.Close()
+	if ctx.Transport != nil {
+		ctx.Transport.Close()
 	}
 
 	return nil
@@ -218,37 +306,44 @@ func (r *PackageResource) Apply(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Installing package: %s (manager: %s)", r.Package.Name, r.Package.Manager))
 
 	// Get the appropriate package manager
-	var manager pkgmanager.PackageManager
-	var err error
-
-	switch r.Package.Manager {
-	case "apt":
-		manager, err = pkgmanager.NewAptManager(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to create apt manager: %w", err)
-		}
-	default:
-		return fmt.Errorf("unsupported package manager: %s", r.Package.Manager)
+	manager, err := r.manager(ctx)
+	if err != nil {
+		return err
 	}
 
 	// Check if package already exists
-	exists, err := manager.DoesExist(context.Background(), ctx, []common.Package{r.Package})
+	exists, err := manager.DoesExist(ctx.Context(), ctx, []common.Package{r.Package})
 	if err != nil {
 		return fmt.Errorf("failed to check if package exists: %w", err)
 	}
 
 	if exists {
 		ctx.Logger.Info(fmt.Sprintf("Package %s already installed", r.Package.Name))
-		return nil
+		return r.setHold(ctx, manager)
 	}
 
 	// Install the package
-	err = manager.Install(context.Background(), ctx, []common.Package{r.Package})
+	err = manager.Install(ctx.Context(), ctx, []common.Package{r.Package})
 	if err != nil {
 		return fmt.Errorf("failed to install package %s: %w", r.Package.Name, err)
 	}
 
 	ctx.Logger.Info(fmt.Sprintf("Successfully installed package: %s", r.Package.Name))
+	return r.setHold(ctx, manager)
+}
+
+// setHold holds or releases the package when its manager supports holds
+func (r *PackageResource) setHold(ctx *inventory.Context, manager pkgmanager.PackageManager) error {
+	holder, ok := manager.(pkgmanager.Holder)
+	if !ok {
+		if r.Package.Hold {
+			return fmt.Errorf("package manager %s does not support hold", r.Package.Manager)
+		}
+		return nil
+	}
+	if err := holder.SetHold(ctx.Context(), ctx, r.Package); err != nil {
+		return fmt.Errorf("failed to set hold of package %s: %w", r.Package.Name, err)
+	}
 	return nil
 }
 
@@ -256,21 +351,13 @@ func (r *PackageResource) Destroy(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Removing package: %s (manager: %s)", r.Package.Name, r.Package.Manager))
 
 	// Get the appropriate package manager
-	var manager pkgmanager.PackageManager
-	var err error
-
-	switch r.Package.Manager {
-	case "apt":
-		manager, err = pkgmanager.NewAptManager(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to create apt manager: %w", err)
-		}
-	default:
-		return fmt.Errorf("unsupported package manager: %s", r.Package.Manager)
+	manager, err := r.manager(ctx)
+	if err != nil {
+		return err
 	}
 
 	// Remove the package
-	err = manager.Remove(context.Background(), ctx, []common.Package{r.Package})
+	err = manager.Remove(ctx.Context(), ctx, []common.Package{r.Package})
 	if err != nil {
 		return fmt.Errorf("failed to remove package %s: %w", r.Package.Name, err)
 	}
@@ -279,58 +366,598 @@ func (r *PackageResource) Destroy(ctx *inventory.Context) error {
 	return nil
 }
 
+// Read returns the installed package version, or nil if it is not installed
+func (r *PackageResource) Read(ctx *inventory.Context) (map[string]interface{}, error) {
+	manager, err := r.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := manager.GetVersion(ctx.Context(), ctx, r.Package)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package %s: %w", r.Package.Name, err)
+	}
+	if version == "" {
+		return nil, nil
+	}
+
+	return map[string]interface{}{
+		"name":    r.Package.Name,
+		"version": version,
+		"manager": r.Package.Manager,
+	}, nil
+}
+
+// VersionChange compares the installed version of a pinned package with the
+// requested one
+func (r *PackageResource) VersionChange(ctx *inventory.Context) (*Change, error) {
+	if r.Package.Version == "" || r.Package.Version == "latest" {
+		return nil, nil
+	}
+
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTransport()
+	hostCtx := *ctx
+	hostCtx.Transport = transport
+
+	manager, err := r.manager(&hostCtx)
+	if err != nil {
+		return nil, err
+	}
+	installed, err := manager.GetVersion(ctx.Context(), &hostCtx, r.Package)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package %s: %w", r.Package.Name, err)
+	}
+	if manager.VersionSatisfies(installed, r.Package.Version) {
+		return nil, nil
+	}
+	return &Change{Field: "version", OldValue: installed, NewValue: r.Package.Version}, nil
+}
+
+// manager returns the package manager driver for this package
+func (r *PackageResource) manager(ctx *inventory.Context) (pkgmanager.PackageManager, error) {
+	return pkgmanager.New(r.Package.Manager, ctx)
+}
+
+// DescribeRemoval lists the package removed by Destroy
+func (r *PackageResource) DescribeRemoval() []Removal {
+	return []Removal{{
+		Kind:   "package",
+		Name:   r.Package.Name,
+		Detail: fmt.Sprintf("removed via %s", r.Package.Manager),
+	}}
+}
+
+// Impact reports removing the package or changing its installed version as
+// disruptive, since the package's services stop or restart
+func (r *PackageResource) Impact(action *Action) (Impact, string) {
+	switch action.Type {
+	case ActionDelete:
+		return ImpactDisruptive, fmt.Sprintf("removes package %s", r.Package.Name)
+	case ActionUpdate:
+		for _, change := range action.Changes {
+			if change.Field == "version" {
+				return ImpactDisruptive, fmt.Sprintf("changes the version of package %s", r.Package.Name)
+			}
+		}
+	}
+	return ImpactSafe, ""
+}
+
 // ServiceResource represents a service resource
 type ServiceResource struct {
 	BaseResource
-	Service struct {
-		Name    string `json:"name"`
-		State   string `json:"state"`   // running, stopped, enabled, disabled
-		Manager string `json:"manager"` // systemd, rc, launchd
-	}
+	Service common.Service
 }
 
 func (r *ServiceResource) Apply(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Managing service: %s (state: %s)", r.Service.Name, r.Service.State))
 
-	// TODO: Implement service management
-	// This would use the service drivers in drivers/svc/
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	manager, err := r.manager(ctx, transport)
+	if err != nil {
+		return err
+	}
+	status, err := manager.Status(ctx.Context(), ctx, r.Service.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check service %s: %w", r.Service.Name, err)
+	}
+	if !status.Exists {
+		return fmt.Errorf("service %s does not exist on host %s", r.Service.Name, ctx.Host.Name)
+	}
 
-	return fmt.Errorf("service management not yet implemented")
+	// Enable before starting, as runit only runs enabled services, and
+	// disable after stopping
+	enabled := r.Service.Enabled
+	if enabled != nil && *enabled && !status.Enabled {
+		if err := manager.Enable(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to enable service %s: %w", r.Service.Name, err)
+		}
+		status, err = manager.Status(ctx.Context(), ctx, r.Service.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check service %s: %w", r.Service.Name, err)
+		}
+	}
+	switch {
+	case r.Service.State == common.ServiceRunning && !status.Running:
+		if err := manager.Start(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to start service %s: %w", r.Service.Name, err)
+		}
+	case r.Service.State == common.ServiceStopped && status.Running:
+		if err := manager.Stop(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to stop service %s: %w", r.Service.Name, err)
+		}
+	}
+	if enabled != nil && !*enabled && status.Enabled {
+		if err := manager.Disable(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to disable service %s: %w", r.Service.Name, err)
+		}
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Service %s is in its desired state", r.Service.Name))
+	return nil
 }
 
+// Destroy undoes what the service was kept at: a service kept running is
+// stopped and one kept enabled is disabled
 func (r *ServiceResource) Destroy(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Stopping service: %s", r.Service.Name))
 
-	// TODO: Implement service destruction
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	manager, err := r.manager(ctx, transport)
+	if err != nil {
+		return err
+	}
+	status, err := manager.Status(ctx.Context(), ctx, r.Service.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check service %s: %w", r.Service.Name, err)
+	}
 
-	return fmt.Errorf("service destruction not yet implemented")
+	if r.Service.State == common.ServiceRunning && status.Running {
+		if err := manager.Stop(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to stop service %s: %w", r.Service.Name, err)
+		}
+	}
+	if r.Service.Enabled != nil && *r.Service.Enabled && status.Enabled {
+		if err := manager.Disable(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to disable service %s: %w", r.Service.Name, err)
+		}
+	}
+	return nil
+}
+
+// Read returns the state of the service, or nil if it does not exist
+func (r *ServiceResource) Read(ctx *inventory.Context) (map[string]interface{}, error) {
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTransport()
+	manager, err := r.manager(ctx, transport)
+	if err != nil {
+		return nil, err
+	}
+	status, err := manager.Status(ctx.Context(), ctx, r.Service.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service %s: %w", r.Service.Name, err)
+	}
+	if !status.Exists {
+		return nil, nil
+	}
+
+	state := common.ServiceStopped
+	if status.Running {
+		state = common.ServiceRunning
+	}
+	return map[string]interface{}{
+		"name":    r.Service.Name,
+		"state":   state,
+		"enabled": status.Enabled,
+	}, nil
+}
+
+// manager returns the driver of the service's init system, detecting it
+// on the host when the service names none
+func (r *ServiceResource) manager(ctx *inventory.Context, transport inventory.Transport) (svc.ServiceManager, error) {
+	name := r.Service.Manager
+	if name == "" {
+		detected, err := svc.Detect(ctx.Context(), ctx, transport)
+		if err != nil {
+			return nil, err
+		}
+		name = detected
+	}
+	return svc.NewManager(name, transport)
+}
+
+// Trigger restarts the service, or reloads it when on_notify is reload, so
+// it picks up a rewritten configuration or unit file. A stopped service is
+// left stopped.
+func (r *ServiceResource) Trigger(ctx *inventory.Context) error {
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	manager, err := r.manager(ctx, transport)
+	if err != nil {
+		return err
+	}
+	status, err := manager.Status(ctx.Context(), ctx, r.Service.Name)
+	if err != nil {
+		return fmt.Errorf("failed to check service %s: %w", r.Service.Name, err)
+	}
+	if !status.Running {
+		return nil
+	}
+
+	if r.Service.OnNotify == common.ServiceReload {
+		ctx.Logger.Info(fmt.Sprintf("Reloading service: %s", r.Service.Name))
+		if err := manager.Reload(ctx.Context(), ctx, r.Service.Name); err != nil {
+			return fmt.Errorf("failed to reload service %s: %w", r.Service.Name, err)
+		}
+		return nil
+	}
+	ctx.Logger.Info(fmt.Sprintf("Restarting service: %s", r.Service.Name))
+	if err := manager.Restart(ctx.Context(), ctx, r.Service.Name); err != nil {
+		return fmt.Errorf("failed to restart service %s: %w", r.Service.Name, err)
+	}
+	return nil
+}
+
+// DescribeRemoval lists what Destroy changes
+func (r *ServiceResource) DescribeRemoval() []Removal {
+	var details []string
+	if r.Service.State == common.ServiceRunning {
+		details = append(details, "stopped")
+	}
+	if r.Service.Enabled != nil && *r.Service.Enabled {
+		details = append(details, "disabled")
+	}
+	if len(details) == 0 {
+		return nil
+	}
+	return []Removal{{
+		Kind:   "service",
+		Name:   r.Service.Name,
+		Detail: strings.Join(details, " and "),
+	}}
+}
+
+// Impact reports stopping the service as disruptive: destroying a service
+// kept running, or applying one kept stopped
+func (r *ServiceResource) Impact(action *Action) (Impact, string) {
+	switch {
+	case action.Type == ActionDelete && r.Service.State == common.ServiceRunning:
+		return ImpactDisruptive, fmt.Sprintf("stops service %s", r.Service.Name)
+	case action.Type != ActionDelete && r.Service.State == common.ServiceStopped:
+		return ImpactDisruptive, fmt.Sprintf("stops service %s if it runs", r.Service.Name)
+	}
+	return ImpactSafe, ""
+}
+
+// SystemdUnitResource writes a unit file below /etc/systemd/system and
+// reloads systemd. A service resource of the unit is restarted through a
+// triggers edge when the unit changes.
+type SystemdUnitResource struct {
+	FileResource
+	Unit common.SystemdUnit
+}
+
+func (r *SystemdUnitResource) Apply(ctx *inventory.Context) error {
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	hostCtx := *ctx
+	hostCtx.Transport = transport
+
+	if err := r.FileResource.Apply(&hostCtx); err != nil {
+		return err
+	}
+	systemd := svc.NewSystemdManager(transport)
+	if err := systemd.DaemonReload(ctx.Context(), ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+
+	// A service resource restarts through the triggers edge instead
+	if r.Unit.Restart && !r.triggers() {
+		ctx.Logger.Info(fmt.Sprintf("Restarting unit: %s", r.Unit.Name))
+		if err := systemd.Restart(ctx.Context(), ctx, r.Unit.Name); err != nil {
+			return fmt.Errorf("failed to restart unit %s: %w", r.Unit.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *SystemdUnitResource) Destroy(ctx *inventory.Context) error {
+	transport, closeTransport, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeTransport()
+	hostCtx := *ctx
+	hostCtx.Transport = transport
+
+	if err := r.FileResource.Destroy(&hostCtx); err != nil {
+		return err
+	}
+	if err := svc.NewSystemdManager(transport).DaemonReload(ctx.Context(), ctx); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	return nil
+}
+
+// triggers reports whether the unit has a triggers edge to a resource
+func (r *SystemdUnitResource) triggers() bool {
+	for _, dep := range r.Dependencies {
+		if dep.EdgeType == EdgeTriggers {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeRemoval lists the unit file deleted by Destroy
+func (r *SystemdUnitResource) DescribeRemoval() []Removal {
+	return []Removal{{
+		Kind:   "systemd_unit",
+		Name:   r.Unit.Name,
+		Detail: r.File.Path,
+	}}
+}
+
+// Impact reports removing the unit, or restarting it after a change, as
+// disruptive
+func (r *SystemdUnitResource) Impact(action *Action) (Impact, string) {
+	switch {
+	case action.Type == ActionDelete:
+		return ImpactDisruptive, fmt.Sprintf("removes unit %s", r.Unit.Name)
+	case r.Unit.Restart && !r.triggers():
+		return ImpactDisruptive, fmt.Sprintf("restarts unit %s", r.Unit.Name)
+	}
+	return ImpactSafe, ""
 }
 
 // FileResource represents a file resource
 type FileResource struct {
 	BaseResource
-	File struct {
-		Path    string `json:"path"`
-		Content string `json:"content"`
-		Mode    int    `json:"mode"`
-		Owner   string `json:"owner"`
-		Group   string `json:"group"`
+	File common.File
+}
+
+// Checksum returns the checksum of the declared file content, or the
+// declared checksum of content fetched from a URL
+func (r *FileResource) Checksum() string {
+	if r.File.SourceURL != "" {
+		return r.File.Checksum
+	}
+	return common.Checksum([]byte(r.File.Content))
+}
+
+// Render returns the declared content with its host references replaced by
+// the values of host. Verbatim content is returned as is.
+func (r *FileResource) Render(host *common.Host) (string, error) {
+	if r.File.Verbatim || !HasHostReferences(r.File.Content) {
+		return r.File.Content, nil
+	}
+	return RenderHost(r.File.Content, host)
+}
+
+// HostChecksum returns the checksum of the content rendered for host, or ""
+// when the content is the same on every host
+func (r *FileResource) HostChecksum(host *common.Host, algorithm common.HashAlgorithm) (string, error) {
+	if r.File.Verbatim || !HasHostReferences(r.File.Content) {
+		return "", nil
+	}
+	content, err := r.Render(host)
+	if err != nil {
+		return "", err
+	}
+	return common.ChecksumWith(algorithm, []byte(content))
+}
+
+// RemoteChecksum returns the checksum of the file on the host computed with
+// algorithm, or "" if it does not exist
+func (r *FileResource) RemoteChecksum(ctx *inventory.Context, algorithm common.HashAlgorithm) (string, error) {
+	sumCommand, err := common.RemoteChecksumCommand(algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	client, closeClient, err := connect(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer closeClient()
+
+	command := fmt.Sprintf("sudo %s %s 2>/dev/null || true", sumCommand, common.ShellQuote(r.File.Path))
+	ctx.Logger.Command(command)
+	out, err := client.RunCommand(ctx.Context(), command)
+	if err != nil {
+		return "", fmt.Errorf("failed to checksum %s: %w", r.File.Path, err)
+	}
+
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return common.FormatChecksum(algorithm, fields[0]), nil
+}
+
+// SensitiveValues includes the file content, which is not part of the config
+func (r *FileResource) SensitiveValues() []string {
+	values := r.BaseResource.SensitiveValues()
+	if r.IsSensitive("content") && r.File.Content != "" {
+		values = append(values, r.File.Content)
 	}
+	return values
 }
 
 func (r *FileResource) Apply(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Creating/updating file: %s", r.File.Path))
 
-	// TODO: Implement file management
-	// This would use file system drivers
+	client, closeClient, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	// Missing parent directories are created only when a directory mode is set
+	if r.File.DirMode != 0 {
+		command := fmt.Sprintf("sudo mkdir -p -m %o %s", r.File.DirMode, common.ShellQuote(path.Dir(r.File.Path)))
+		if err := runCommand(ctx, client, command); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", r.File.Path, err)
+		}
+	}
 
-	return fmt.Errorf("file management not yet implemented")
+	// Write to a temporary file and rename so readers never see partial content.
+	// Within a run SSH hosts receive the content in the workspace first, so a
+	// failed upload leaves nothing next to the target.
+	tmpPath := r.File.Path + ".settle-tmp"
+	uploadCtx := ctx.Context()
+	if ctx.Workspace != nil {
+		dir, err := ctx.Workspace.Prepare(ctx)
+		if err != nil {
+			return err
+		}
+		uploadCtx = inventory.WithUploadOptions(uploadCtx, inventory.UploadOptions{
+			StagingDir: dir,
+			Progress: func(done, total int64) {
+				ctx.Logger.Debug(fmt.Sprintf("Uploaded %d of %d bytes to %s", done, total, r.File.Path))
+			},
+		})
+	}
+	var commands []string
+	if r.File.Mode != 0 {
+		commands = append(commands, fmt.Sprintf("sudo chmod %o %s", r.File.Mode, common.ShellQuote(tmpPath)))
+	}
+	if r.File.Owner != "" || r.File.Group != "" {
+		commands = append(commands, fmt.Sprintf("sudo chown %s", common.ShellJoin(r.File.Owner+":"+r.File.Group, tmpPath)))
+	}
+	commands = append(commands, fmt.Sprintf("sudo mv -f %s", common.ShellJoin(tmpPath, r.File.Path)))
+
+	content, err := r.content(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Logger.Info(fmt.Sprintf("Uploading %d bytes to %s", len(content), tmpPath))
+	if err := client.UploadFile(uploadCtx, tmpPath, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", r.File.Path, err)
+	}
+	for _, command := range commands {
+		if err := runCommand(ctx, client, command); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", r.File.Path, err)
+		}
+	}
+
+	ctx.Logger.Info(fmt.Sprintf("Successfully wrote file: %s", r.File.Path))
+	return nil
+}
+
+// content returns what Apply uploads: the content fetched from the source
+// URL, or the declared content rendered for the host
+func (r *FileResource) content(ctx *inventory.Context) (string, error) {
+	if r.File.SourceURL == "" {
+		return r.Render(ctx.Host)
+	}
+	ctx.Logger.Info(fmt.Sprintf("Fetching %s", r.File.SourceURL))
+	content, err := fetchSource(ctx.Context(), r.File)
+	if err != nil {
+		return "", fmt.Errorf("file %s: %w", r.File.Path, err)
+	}
+	return string(content), nil
 }
 
 func (r *FileResource) Destroy(ctx *inventory.Context) error {
 	ctx.Logger.Info(fmt.Sprintf("Removing file: %s", r.File.Path))
 
-	// TODO: Implement file removal
+	client, closeClient, err := connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	command := fmt.Sprintf("sudo rm -f %s", common.ShellQuote(r.File.Path))
+	if err := runCommand(ctx, client, command); err != nil {
+		return fmt.Errorf("failed to remove file %s: %w", r.File.Path, err)
+	}
+
+	return nil
+}
+
+// DescribeRemoval lists the file deleted by Destroy
+func (r *FileResource) DescribeRemoval() []Removal {
+	return []Removal{{
+		Kind: "file",
+		Name: r.File.Path,
+	}}
+}
+
+// Read returns the path and content checksum of the file on the host. A
+// file rendered for its host reports the checksum of the declared content
+// when it matches its rendering. The checksum uses the algorithm of the
+// declared one.
+func (r *FileResource) Read(ctx *inventory.Context) (map[string]interface{}, error) {
+	algorithm, _ := common.ParseChecksum(r.Checksum())
+	checksum, err := r.RemoteChecksum(ctx, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if checksum == "" {
+		return nil, nil
+	}
+	rendered, err := r.HostChecksum(ctx.Host, algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if rendered != "" && common.ChecksumsEqual(checksum, rendered) {
+		checksum = r.Checksum()
+	}
+
+	return map[string]interface{}{
+		"path":     r.File.Path,
+		"checksum": checksum,
+	}, nil
+}
+
+// connect returns the context's transport, opening a new one when the
+// context has none. The returned function closes only transports opened here.
+func connect(ctx *inventory.Context) (inventory.Transport, func(), error) {
+	if ctx.Host == nil {
+		return nil, nil, fmt.Errorf("no host available")
+	}
+	if ctx.Transport != nil {
+		return ctx.Transport, func() {}, nil
+	}
 
-	return fmt.Errorf("file removal not yet implemented")
+	transport, err := ctx.Connect(ctx.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transport, func() { transport.Close() }, nil
+}
+
+// runCommand logs and runs command, logging its output when it fails
+func runCommand(ctx *inventory.Context, client inventory.Transport, command string) error {
+	ctx.Logger.Command(command)
+	result, err := client.Exec(ctx.Context(), command)
+	if err != nil {
+		return err
+	}
+	if !result.Success() && result.Output() != "" {
+		ctx.Logger.ErrorOutput(result.Output())
+	}
+	return result.Err()
 }
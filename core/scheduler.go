@@ -0,0 +1,117 @@
+package core
+
+import (
+	"context"
+	"time"
+)
+
+// Scheduled is implemented by resources that declare how often they are
+// re-checked, e.g. certificate expiry weekly and security updates daily
+type Scheduled interface {
+	GetInterval() time.Duration
+}
+
+// Scheduler tracks when each resource is next due, so resources converge
+// at their own cadence instead of on one global loop
+type Scheduler struct {
+	intervals map[ResourceID]time.Duration
+	next      map[ResourceID]time.Time
+	resources []Resource // in the order given, e.g. dependency order
+	now       func() time.Time
+}
+
+// NewScheduler schedules resources on their declared interval, or on
+// defaultInterval when they declare none. Resources without either are not
+// scheduled. Every scheduled resource is due immediately.
+func NewScheduler(resources []Resource, defaultInterval time.Duration) *Scheduler {
+	s := &Scheduler{
+		intervals: make(map[ResourceID]time.Duration),
+		next:      make(map[ResourceID]time.Time),
+		now:       time.Now,
+	}
+	start := s.now()
+	for _, resource := range resources {
+		interval := ResourceInterval(resource, defaultInterval)
+		if interval <= 0 {
+			continue
+		}
+		id := resource.GetID()
+		s.intervals[id] = interval
+		s.next[id] = start
+		s.resources = append(s.resources, resource)
+	}
+	return s
+}
+
+// ResourceInterval returns the re-check interval of resource, or
+// defaultInterval when it declares none
+func ResourceInterval(resource Resource, defaultInterval time.Duration) time.Duration {
+	if scheduled, ok := resource.(Scheduled); ok && scheduled.GetInterval() > 0 {
+		return scheduled.GetInterval()
+	}
+	return defaultInterval
+}
+
+// Len returns the number of scheduled resources
+func (s *Scheduler) Len() int {
+	return len(s.intervals)
+}
+
+// Due returns the resources due at now, in the order they were scheduled
+func (s *Scheduler) Due(now time.Time) []Resource {
+	var due []Resource
+	for _, resource := range s.resources {
+		if !s.next[resource.GetID()].After(now) {
+			due = append(due, resource)
+		}
+	}
+	return due
+}
+
+// Done schedules the next check of resources one interval after at
+func (s *Scheduler) Done(resources []Resource, at time.Time) {
+	for _, resource := range resources {
+		id := resource.GetID()
+		if interval, ok := s.intervals[id]; ok {
+			s.next[id] = at.Add(interval)
+		}
+	}
+}
+
+// Next returns when the next resource is due; the zero time if none is
+// scheduled
+func (s *Scheduler) Next() time.Time {
+	var next time.Time
+	for _, at := range s.next {
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
+
+// Run calls check with the resources that are due, then sleeps until the
+// next one is, until ctx is done or check fails. The next check of a
+// resource is scheduled before check is called, so Next already accounts
+// for it.
+func (s *Scheduler) Run(ctx context.Context, check func(due []Resource) error) error {
+	for s.Len() > 0 {
+		now := s.now()
+		if due := s.Due(now); len(due) > 0 {
+			s.Done(due, now)
+			if err := check(due); err != nil {
+				return err
+			}
+			continue
+		}
+
+		timer := time.NewTimer(s.Next().Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
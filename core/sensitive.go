@@ -0,0 +1,93 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// sensitivePrefix marks a config value replaced by its checksum
+const sensitivePrefix = "sensitive:"
+
+// minRedactedLineLength keeps short lines of multi-line sensitive values
+// (e.g. "}" in a config file) from being redacted everywhere
+const minRedactedLineLength = 8
+
+// SensitiveResource is implemented by resources with fields that must not
+// appear in logs, plan output or state
+type SensitiveResource interface {
+	IsSensitive(field string) bool
+	// SensitiveValues returns the plaintext values to redact from logs
+	SensitiveValues() []string
+}
+
+func (r *BaseResource) IsSensitive(field string) bool {
+	for _, sensitive := range r.Sensitive {
+		if sensitive == "*" || sensitive == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *BaseResource) SensitiveValues() []string {
+	var values []string
+	for field, value := range r.Config {
+		if r.IsSensitive(field) {
+			values = append(values, fmt.Sprint(value))
+		}
+	}
+	return values
+}
+
+// MaskConfig returns a copy of config with the resource's sensitive fields
+// replaced by checksums, so drift can still be compared without storing or
+// printing the values
+func MaskConfig(resource Resource, config map[string]interface{}) map[string]interface{} {
+	sensitive, ok := resource.(SensitiveResource)
+	if !ok {
+		return config
+	}
+
+	masked := make(map[string]interface{}, len(config))
+	for field, value := range config {
+		if sensitive.IsSensitive(field) && value != nil && value != "" {
+			value = MaskValue(fmt.Sprint(value))
+		}
+		masked[field] = value
+	}
+	return masked
+}
+
+// MaskValue replaces a sensitive value with its checksum
+func MaskValue(value string) string {
+	if strings.HasPrefix(value, sensitivePrefix) {
+		return value
+	}
+	return sensitivePrefix + common.Checksum([]byte(value))
+}
+
+// RegisterSensitiveValues makes the logger redact the sensitive values of
+// every resource. Multi-line values are also redacted line by line, since
+// command output is logged one line at a time.
+func RegisterSensitiveValues(resources []Resource) {
+	for _, resource := range resources {
+		sensitive, ok := resource.(SensitiveResource)
+		if !ok {
+			continue
+		}
+		for _, value := range sensitive.SensitiveValues() {
+			inventory.RegisterSecret(value)
+			if !strings.Contains(value, "\n") {
+				continue
+			}
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSpace(line); len(line) >= minRedactedLineLength {
+					inventory.RegisterSecret(line)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ConfigSnapshot records the checksum of every configuration file a run was
+// planned from, so changes made while the run is in progress are caught
+type ConfigSnapshot map[string]string
+
+// TakeConfigSnapshot checksums the given files
+func TakeConfigSnapshot(paths []string) (ConfigSnapshot, error) {
+	snapshot := make(ConfigSnapshot)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		snapshot[path] = common.Checksum(data)
+	}
+	return snapshot, nil
+}
+
+// Hash returns a single checksum covering every file in the snapshot
+func (s ConfigSnapshot) Hash() string {
+	paths := make([]string, 0, len(s))
+	for path := range s {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s %s\n", s[path], path)
+	}
+	return common.Checksum([]byte(b.String()))
+}
+
+// Verify returns an error naming every file that changed or disappeared
+// since the snapshot was taken
+func (s ConfigSnapshot) Verify() error {
+	var changed []string
+	for path, checksum := range s {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			changed = append(changed, path+" (unreadable)")
+			continue
+		}
+		algorithm, _ := common.ParseChecksum(checksum)
+		current, err := common.ChecksumWith(algorithm, data)
+		if err != nil || !common.ChecksumsEqual(current, checksum) {
+			changed = append(changed, path)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+	sort.Strings(changed)
+	return fmt.Errorf("configuration changed since the plan was made: %s; re-run to plan against the new configuration", strings.Join(changed, ", "))
+}
@@ -3,33 +3,55 @@ package core
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
 )
 
+// MaxStateHistory is how many previous states are kept per resource
+const MaxStateHistory = 10
+
 type StateManager struct {
-	stateFile string
-	state     map[ResourceID]*ResourceState
-	graph     *Graph
+	backend      StateBackend
+	key          string
+	state        map[ResourceID]*ResourceState
+	graph        *Graph
+	onTransition func(StateTransition)
+	version      int    // version this state manager saves to; 0 before the first save
+	runID        string // recorded as last_run_id of the resources it changes
 }
 
 func NewStateManager(stateFile string, graph *Graph) *StateManager {
+	return NewStateManagerWithBackend(NewLocalBackend(filepath.Dir(stateFile)), filepath.Base(stateFile), graph)
+}
+
+// NewStateManagerWithBackend creates a state manager storing its state under
+// key in the given backend
+func NewStateManagerWithBackend(backend StateBackend, key string, graph *Graph) *StateManager {
 	return &StateManager{
-		stateFile: stateFile,
-		state:     make(map[ResourceID]*ResourceState),
-		graph:     graph,
+		backend: backend,
+		key:     key,
+		state:   make(map[ResourceID]*ResourceState),
+		graph:   graph,
+		runID:   inventory.RunID(),
 	}
 }
 
-func (s *StateManager) LoadState() error {
-	if _, err := os.Stat(s.stateFile); os.IsNotExist(err) {
-		return nil
-	}
+// SetRunID sets the run recorded as last_run_id of the resources changed
+// from now on
+func (s *StateManager) SetRunID(runID string) {
+	s.runID = runID
+}
 
-	data, err := os.ReadFile(s.stateFile)
+func (s *StateManager) LoadState() error {
+	data, err := s.backend.Read(s.key)
 	if err != nil {
-		return fmt.Errorf("failed to read state file: %w", err)
+		return err
+	}
+	if data == nil {
+		return nil
 	}
 
 	var stateData map[ResourceID]*ResourceState
@@ -42,21 +64,34 @@ func (s *StateManager) LoadState() error {
 }
 
 func (s *StateManager) SaveState() error {
-	dir := filepath.Dir(s.stateFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create state directory: %w", err)
-	}
-
 	data, err := json.MarshalIndent(s.state, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
 
-	if err := os.WriteFile(s.stateFile, data, 0644); err != nil {
-		return fmt.Errorf("failed to write state file: %w", err)
+	if err := s.backend.Write(s.key, data); err != nil {
+		return err
 	}
+	return s.recordVersion(data)
+}
 
-	return nil
+// Lock acquires the state lock so concurrent runs cannot interleave writes
+func (s *StateManager) Lock(owner string) error {
+	return s.backend.Lock(s.key, owner)
+}
+
+// Unlock releases the state lock
+func (s *StateManager) Unlock() error {
+	return s.backend.Unlock(s.key)
+}
+
+// DurationHint returns how long the last apply of id took, or 0 when it
+// was never timed
+func (s *StateManager) DurationHint(id ResourceID) time.Duration {
+	if state, exists := s.state[id]; exists {
+		return state.Duration
+	}
+	return 0
 }
 
 func (s *StateManager) GetState(id ResourceID) *ResourceState {
@@ -67,12 +102,67 @@ func (s *StateManager) GetState(id ResourceID) *ResourceState {
 	return state
 }
 
+// SetState records state as the state of id. The state it replaces is
+// added to the history, which keeps the last MaxStateHistory entries, and
+// its duration is kept when state has none.
 func (s *StateManager) SetState(id ResourceID, state *ResourceState) {
+	from := StatePending
+	if previous, exists := s.state[id]; exists && previous != state {
+		from = previous.Status
+		history := append(previous.History, previous.record())
+		if len(history) > MaxStateHistory {
+			history = history[len(history)-MaxStateHistory:]
+		}
+		state.History = history
+		if state.Duration == 0 {
+			state.Duration = previous.Duration
+		}
+	}
 	s.state[id] = state
+	s.transition(id, from, state.Status)
+}
+
+// record summarises the state for the history
+func (state *ResourceState) record() StateRecord {
+	record := StateRecord{
+		Status:   state.Status,
+		At:       state.LastApplied,
+		Checksum: state.Checksum,
+		RunID:    state.LastRunID,
+	}
+	record.Error, _ = state.Metadata["error"].(string)
+	return record
 }
 
 func (s *StateManager) RemoveState(id ResourceID) {
-	delete(s.state, id)
+	if previous, exists := s.state[id]; exists {
+		delete(s.state, id)
+		s.transition(id, previous.Status, StateRemoved)
+	}
+}
+
+// OnTransition registers fn to be called whenever a resource changes status
+func (s *StateManager) OnTransition(fn func(StateTransition)) {
+	s.onTransition = fn
+}
+
+func (s *StateManager) transition(id ResourceID, from, to StateStatus) {
+	if s.onTransition == nil || from == to {
+		return
+	}
+	s.onTransition(StateTransition{
+		ResourceID: id,
+		From:       from,
+		To:         to,
+		At:         time.Now(),
+	})
+}
+
+// Hash returns a checksum of the loaded state, which changes whenever any
+// resource's state does
+func (s *StateManager) Hash() string {
+	data, _ := json.Marshal(s.state)
+	return common.Checksum(data)
 }
 
 func (s *StateManager) GetAllStates() map[ResourceID]*ResourceState {
@@ -90,7 +180,7 @@ func (s *StateManager) DetectDrift(resource Resource) (bool, error) {
 		return true, nil
 	}
 
-	currentConfig := resource.GetConfig()
+	currentConfig := MaskConfig(resource, resource.GetConfig())
 	lastConfig, exists := currentState.Metadata["config"]
 	if !exists {
 		return true, nil
@@ -109,32 +199,69 @@ func (s *StateManager) DetectDrift(resource Resource) (bool, error) {
 	return string(configBytes) != string(lastConfigBytes), nil
 }
 
-func (s *StateManager) MarkApplied(resource Resource) error {
-	config := resource.GetConfig()
+// MarkApplied records a resource as applied and how long it took.
+// Sensitive config fields are stored as checksums only.
+func (s *StateManager) MarkApplied(resource Resource, duration time.Duration) error {
+	config := MaskConfig(resource, resource.GetConfig())
 	configBytes, err := json.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
+	checksum := common.Checksum(configBytes)
+	if checksummed, ok := resource.(Checksummed); ok {
+		checksum = checksummed.Checksum()
+	}
+
 	state := &ResourceState{
 		Status:      StateApplied,
 		LastApplied: time.Now(),
-		Checksum:    string(configBytes),
+		Checksum:    checksum,
+		LastRunID:   s.runID,
 		Metadata: map[string]interface{}{
 			"config": config,
+			"target": resource.GetTarget(),
+			"labels": resource.GetLabels(),
 		},
+		Duration: duration,
 	}
 
 	s.SetState(resource.GetID(), state)
 	return s.SaveState()
 }
 
-func (s *StateManager) MarkFailed(resource Resource, errorMsg string) error {
+// MarkImported records a resource that already exists on a host using the
+// configuration observed there, so it is managed without being re-applied
+func (s *StateManager) MarkImported(resource Resource, observed map[string]interface{}) error {
+	// When the host already matches the declaration, record the declared
+	// config so the next plan is a no-op; otherwise keep what was observed
+	// so the difference shows up as drift.
+	config := observed
+	if len(DiffConfig(resource.GetConfig(), observed)) == 0 {
+		config = resource.GetConfig()
+	}
+	config = MaskConfig(resource, config)
+
+	configBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	checksum := common.Checksum(configBytes)
+	if _, ok := resource.(Checksummed); ok {
+		checksum = fmt.Sprint(observed["checksum"])
+	}
+
 	state := &ResourceState{
-		Status:      StateFailed,
+		Status:      StateApplied,
 		LastApplied: time.Now(),
+		Checksum:    checksum,
+		LastRunID:   s.runID,
 		Metadata: map[string]interface{}{
-			"error": errorMsg,
+			"config":   config,
+			"target":   resource.GetTarget(),
+			"labels":   resource.GetLabels(),
+			"imported": true,
 		},
 	}
 
@@ -142,6 +269,86 @@ func (s *StateManager) MarkFailed(resource Resource, errorMsg string) error {
 	return s.SaveState()
 }
 
+// MarkDrifted records that the real state of a resource on its host no
+// longer matches what was applied
+func (s *StateManager) MarkDrifted(id ResourceID, changes []Change) error {
+	if err := s.NoteDrifted(id, changes); err != nil {
+		return err
+	}
+	return s.SaveState()
+}
+
+// NoteDrifted marks a resource as drifted like MarkDrifted without saving
+// the state, e.g. while planning without the state lock
+func (s *StateManager) NoteDrifted(id ResourceID, changes []Change) error {
+	state := s.GetState(id)
+	if state == nil {
+		return fmt.Errorf("resource %s is not in state", id)
+	}
+
+	from := state.Status
+	state.Status = StateDrifted
+	s.transition(id, from, StateDrifted)
+	if state.Metadata == nil {
+		state.Metadata = make(map[string]interface{})
+	}
+	state.Metadata["drift"] = changes
+	state.Metadata["drift_detected_at"] = time.Now()
+	return nil
+}
+
+func (s *StateManager) MarkFailed(resource Resource, errorMsg string) error {
+	return s.markUnapplied(resource, StateFailed, map[string]interface{}{
+		"error": inventory.Redact(errorMsg),
+	})
+}
+
+// MarkInterrupted records that an action on the resource was cancelled
+// mid-flight, so its real state on the host is unknown
+func (s *StateManager) MarkInterrupted(resource Resource) error {
+	return s.markUnapplied(resource, StateUnknown, map[string]interface{}{
+		"interrupted": true,
+	})
+}
+
+// MarkSkipped records that the action on the resource was skipped because an
+// action it depends on failed, so the next run retries it
+func (s *StateManager) MarkSkipped(resource Resource, reason string) error {
+	return s.markUnapplied(resource, StateSkipped, map[string]interface{}{
+		"skipped": reason,
+	})
+}
+
+// markUnapplied records a resource whose action did not complete with the
+// given status and metadata. What was last applied is kept so the resource
+// can still be cleaned up.
+func (s *StateManager) markUnapplied(resource Resource, status StateStatus, metadata map[string]interface{}) error {
+	state := &ResourceState{
+		Status:      status,
+		LastApplied: time.Now(),
+		LastRunID:   s.runID,
+		Metadata:    metadata,
+	}
+
+	if previous := s.GetState(resource.GetID()); previous != nil {
+		state.Checksum = previous.Checksum
+		for _, key := range []string{"config", "target", "labels"} {
+			if value, ok := previous.Metadata[key]; ok {
+				state.Metadata[key] = value
+			}
+		}
+	}
+
+	s.SetState(resource.GetID(), state)
+	return s.SaveState()
+}
+
+// MarkDestroyed removes a destroyed resource from state
+func (s *StateManager) MarkDestroyed(resource Resource) error {
+	s.RemoveState(resource.GetID())
+	return s.SaveState()
+}
+
 func (s *StateManager) GetResourcesByStatus(status StateStatus) []ResourceID {
 	var result []ResourceID
 	for id, state := range s.state {
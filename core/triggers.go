@@ -0,0 +1,153 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Triggered is implemented by resources that react when a resource with a
+// triggers edge to them changes, e.g. a service restarted after its
+// configuration file was rewritten
+type Triggered interface {
+	Trigger(ctx *inventory.Context) error
+}
+
+// triggers records which resources changed on which hosts during a run, so
+// the resources they trigger react once per host at the end of the run
+type triggers struct {
+	mu      sync.Mutex
+	pending map[ResourceID]map[string][]ResourceID // target, host, sources
+}
+
+func newTriggers() *triggers {
+	return &triggers{pending: make(map[ResourceID]map[string][]ResourceID)}
+}
+
+// snapshot returns a copy of the pending triggers
+func (t *triggers) snapshot() map[ResourceID]map[string][]ResourceID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := make(map[ResourceID]map[string][]ResourceID, len(t.pending))
+	for target, hosts := range t.pending {
+		pending[target] = make(map[string][]ResourceID, len(hosts))
+		for host, sources := range hosts {
+			pending[target][host] = append([]ResourceID(nil), sources...)
+		}
+	}
+	return pending
+}
+
+// restore adds pending triggers recorded by an earlier attempt of the run
+func (t *triggers) restore(pending map[ResourceID]map[string][]ResourceID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for target, hosts := range pending {
+		if t.pending[target] == nil {
+			t.pending[target] = make(map[string][]ResourceID)
+		}
+		for host, sources := range hosts {
+			t.pending[target][host] = append(t.pending[target][host], sources...)
+		}
+	}
+}
+
+// hasTriggersEdge reports whether source has a triggers edge to target
+func hasTriggersEdge(graph *Graph, source, target ResourceID) bool {
+	for _, dep := range graph.GetDependencies(source) {
+		if dep.EdgeType == EdgeTriggers && dep.Target == target {
+			return true
+		}
+	}
+	return false
+}
+
+// notify records that source changed on hosts, for every resource it has a
+// triggers edge to
+func (t *triggers) notify(graph *Graph, source Resource, hosts []*HostResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, dep := range graph.GetDependencies(source.GetID()) {
+		if dep.EdgeType != EdgeTriggers {
+			continue
+		}
+		if t.pending[dep.Target] == nil {
+			t.pending[dep.Target] = make(map[string][]ResourceID)
+		}
+		for _, host := range hosts {
+			t.pending[dep.Target][host.Host] = append(t.pending[dep.Target][host.Host], source.GetID())
+		}
+	}
+}
+
+// take returns and forgets the hosts target is triggered on, with the
+// resources that triggered it there
+func (t *triggers) take(target ResourceID) map[string][]ResourceID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hosts := t.pending[target]
+	delete(t.pending, target)
+	return hosts
+}
+
+// warnSkipped reports the triggers a failed run did not get to
+func (t *triggers) warnSkipped(logger *inventory.Logger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	targets := make([]string, 0, len(t.pending))
+	for target := range t.pending {
+		targets = append(targets, string(target))
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		hosts := make([]string, 0, len(t.pending[ResourceID(target)]))
+		for host := range t.pending[ResourceID(target)] {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		logger.Warning(fmt.Sprintf("Not triggering %s on %s: the run failed", target, strings.Join(hosts, ", ")))
+	}
+}
+
+// runTriggers triggers every resource notified during the run, in plan
+// order, once on each host a resource triggering it changed on
+func (e *Executor) runTriggers(ctx context.Context, plan *Plan) error {
+	for _, action := range plan.Actions {
+		pending := e.triggers.take(action.ResourceID)
+		if len(pending) == 0 {
+			continue
+		}
+		resource, ok := e.graph.GetResource(action.ResourceID)
+		if !ok {
+			continue
+		}
+		triggered, ok := resource.(Triggered)
+		if !ok {
+			continue
+		}
+
+		var hosts []*common.Host
+		for _, host := range TargetHosts(resource, e.hosts) {
+			if _, ok := pending[host.Name]; ok {
+				hosts = append(hosts, host)
+			}
+		}
+		results := e.fanOut(hosts, func(host *common.Host) error {
+			sources := make([]string, len(pending[host.Name]))
+			for i, source := range pending[host.Name] {
+				sources[i] = string(source)
+			}
+			e.logger.Info(fmt.Sprintf("Triggering %s on %s after changes to %s", resource.GetID(), host.Name, strings.Join(sources, ", ")))
+			return triggered.Trigger(e.createResourceContext(ctx, host))
+		})
+		if err := (&ExecutionAction{Hosts: results}).hostError(); err != nil {
+			return fmt.Errorf("failed to trigger %s: %w", resource.GetID(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+type CheckStatus string
+
+const (
+	CheckPassed   CheckStatus = "passed"
+	CheckMissing  CheckStatus = "missing"
+	CheckMismatch CheckStatus = "mismatch"
+	CheckError    CheckStatus = "error"
+)
+
+// Verifier checks resources against their hosts without changing anything
+type Verifier struct {
+	graph  *Graph
+	logger *inventory.Logger
+	hosts  map[string]*common.Host
+}
+
+func NewVerifier(graph *Graph, logger *inventory.Logger) *Verifier {
+	return &Verifier{
+		graph:  graph,
+		logger: logger,
+		hosts:  make(map[string]*common.Host),
+	}
+}
+
+// SetHosts sets the hosts available for verification
+func (v *Verifier) SetHosts(hosts []common.Host) {
+	v.hosts = HostMap(hosts)
+}
+
+// Verify reads every resource selected by the profile and compares it with
+// the declared configuration
+func (v *Verifier) Verify(profile common.Profile) (*VerificationResult, error) {
+	resources, err := v.Select(profile)
+	if err != nil {
+		return nil, err
+	}
+	return v.VerifyResources(profile.Name, resources), nil
+}
+
+// Select returns the resources selected by the profile in dependency order
+func (v *Verifier) Select(profile common.Profile) ([]Resource, error) {
+	order, err := v.graph.TopologicalSort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort resources: %w", err)
+	}
+
+	var resources []Resource
+	for _, id := range order {
+		resource, _ := v.graph.GetResource(id)
+		if MatchesProfile(resource, profile) {
+			resources = append(resources, resource)
+		}
+	}
+	return resources, nil
+}
+
+// VerifyResources reads resources on their hosts and compares them with the
+// declared configuration; name labels the result
+func (v *Verifier) VerifyResources(name string, resources []Resource) *VerificationResult {
+	result := &VerificationResult{
+		Profile:   name,
+		StartedAt: time.Now(),
+		Checks:    make([]*Check, 0),
+	}
+	for _, resource := range resources {
+		for _, host := range TargetHosts(resource, v.hosts) {
+			result.Checks = append(result.Checks, v.check(resource, host))
+		}
+	}
+	result.CompletedAt = time.Now()
+	return result
+}
+
+// check reads a resource on one host and classifies the outcome
+func (v *Verifier) check(resource Resource, host *common.Host) *Check {
+	check := &Check{ResourceID: resource.GetID(), Host: host.Name}
+
+	ctx := &inventory.Context{Host: host, Logger: v.logger}
+
+	observed, err := resource.Read(ctx)
+	switch {
+	case err != nil:
+		check.Status = CheckError
+		check.Error = err.Error()
+	case observed == nil:
+		check.Status = CheckMissing
+	default:
+		check.Changes = DiffConfig(resource.GetConfig(), observed)
+		for i, change := range check.Changes {
+			if sensitive, ok := resource.(SensitiveResource); ok && sensitive.IsSensitive(change.Field) {
+				check.Changes[i].OldValue = MaskValue(fmt.Sprint(change.OldValue))
+				check.Changes[i].NewValue = MaskValue(fmt.Sprint(change.NewValue))
+			}
+		}
+		if len(check.Changes) > 0 {
+			check.Status = CheckMismatch
+		} else {
+			check.Status = CheckPassed
+		}
+	}
+
+	return check
+}
+
+// MatchesProfile reports whether a resource is selected by the profile. An
+// empty profile selects every resource.
+func MatchesProfile(resource Resource, profile common.Profile) bool {
+	if len(profile.Types) > 0 {
+		found := false
+		for _, t := range profile.Types {
+			if t == resource.GetType() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(profile.Resources) == 0 {
+		return true
+	}
+	for _, pattern := range profile.Resources {
+		if ok, _ := filepath.Match(pattern, string(resource.GetID())); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffConfig compares declared configuration with what was observed on the
+// host. Only observed keys are compared; empty or "latest" declared values
+// accept anything.
+func DiffConfig(desired, observed map[string]interface{}) []Change {
+	var changes []Change
+	for field, actual := range observed {
+		want, ok := desired[field]
+		if !ok || want == nil || want == "" || want == "latest" {
+			continue
+		}
+		if fmt.Sprint(want) != fmt.Sprint(actual) {
+			changes = append(changes, Change{
+				Field:    field,
+				OldValue: actual,
+				NewValue: want,
+			})
+		}
+	}
+	return changes
+}
+
+// Check is the outcome of verifying a single resource
+type Check struct {
+	ResourceID ResourceID  `json:"resource_id"`
+	Host       string      `json:"host"`
+	Status     CheckStatus `json:"status"`
+	Changes    []Change    `json:"changes,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// VerificationResult is the outcome of verifying a profile
+type VerificationResult struct {
+	Profile     string    `json:"profile"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Checks      []*Check  `json:"checks"`
+}
+
+// GetCount returns the number of checks with the given status
+func (r *VerificationResult) GetCount(status CheckStatus) int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// Passed reports whether every check passed
+func (r *VerificationResult) Passed() bool {
+	return r.GetCount(CheckPassed) == len(r.Checks)
+}
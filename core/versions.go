@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// maxStateVersions bounds how many versions of the state are kept
+const maxStateVersions = 100
+
+// StateVersion describes a saved version of the state. Each command that
+// changes the state adds one version, which every save of that command
+// updates, so a version is the state as a run left it.
+type StateVersion struct {
+	Version   int       `json:"version"`
+	SavedAt   time.Time `json:"saved_at"`
+	Resources int       `json:"resources"`
+}
+
+func (s *StateManager) versionsKey() string {
+	return s.key + ".versions/index.json"
+}
+
+func (s *StateManager) versionKey(version int) string {
+	return fmt.Sprintf("%s.versions/%d.json", s.key, version)
+}
+
+// Versions returns the kept versions of the state, oldest first
+func (s *StateManager) Versions() ([]StateVersion, error) {
+	data, err := s.backend.Read(s.versionsKey())
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var versions []StateVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state versions: %w", err)
+	}
+	return versions, nil
+}
+
+// LoadVersion loads a previous version of the state instead of the current
+// one, e.g. to plan against it
+func (s *StateManager) LoadVersion(version int) (*StateVersion, error) {
+	versions, err := s.Versions()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *StateVersion
+	for i := range versions {
+		if versions[i].Version == version {
+			found = &versions[i]
+		}
+	}
+	if found == nil {
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("state version %d not found: no versions are recorded", version)
+		}
+		return nil, fmt.Errorf("state version %d not found (available: %d to %d)", version, versions[0].Version, versions[len(versions)-1].Version)
+	}
+
+	data, err := s.backend.Read(s.versionKey(version))
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("state version %d is missing from the backend", version)
+	}
+
+	var stateData map[ResourceID]*ResourceState
+	if err := json.Unmarshal(data, &stateData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state version %d: %w", version, err)
+	}
+	s.state = stateData
+	return found, nil
+}
+
+// recordVersion stores data as the version of this state manager, adding
+// the version on the first save and dropping the oldest beyond the limit
+func (s *StateManager) recordVersion(data []byte) error {
+	versions, err := s.Versions()
+	if err != nil {
+		return err
+	}
+
+	if s.version == 0 {
+		s.version = 1
+		if len(versions) > 0 {
+			s.version = versions[len(versions)-1].Version + 1
+		}
+		versions = append(versions, StateVersion{Version: s.version})
+	}
+	current := &versions[len(versions)-1]
+	current.SavedAt = time.Now()
+	current.Resources = len(s.state)
+
+	if err := s.backend.Write(s.versionKey(s.version), data); err != nil {
+		return err
+	}
+
+	for len(versions) > maxStateVersions {
+		if err := s.backend.Delete(s.versionKey(versions[0].Version)); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+
+	index, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state versions: %w", err)
+	}
+	return s.backend.Write(s.versionsKey(), index)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+const (
+	// DefaultWaitTimeout is how long a wait_for condition is waited for
+	// when it sets no timeout
+	DefaultWaitTimeout = 5 * time.Minute
+	// DefaultWaitInterval is the pause between checks of a condition
+	DefaultWaitInterval = 2 * time.Second
+)
+
+// WaitForMiddleware waits for a resource's wait_for conditions, in order,
+// before Apply. Deletes and no-ops wait for nothing.
+func WaitForMiddleware() Middleware {
+	return func(next ActionHandler) ActionHandler {
+		return func(ctx context.Context, action *Action, resource Resource, resourceCtx *inventory.Context) error {
+			hooked, ok := resource.(Hooked)
+			if ok && (action.Type == ActionCreate || action.Type == ActionUpdate) {
+				for _, wait := range hooked.GetHooks().WaitFor {
+					if err := waitFor(ctx, wait, resourceCtx); err != nil {
+						return err
+					}
+				}
+			}
+			return next(ctx, action, resource, resourceCtx)
+		}
+	}
+}
+
+// waitFor checks the condition on the host of resourceCtx until it holds,
+// the condition times out or ctx is done
+func waitFor(ctx context.Context, wait common.WaitFor, resourceCtx *inventory.Context) error {
+	if wait.Delay > 0 {
+		resourceCtx.Logger.Info(fmt.Sprintf("Waiting %v on %s", wait.Delay, resourceCtx.Host.Name))
+		return sleep(ctx, wait.Delay)
+	}
+
+	timeout, interval := wait.Timeout, wait.Interval
+	if timeout == 0 {
+		timeout = DefaultWaitTimeout
+	}
+	if interval == 0 {
+		interval = DefaultWaitInterval
+	}
+
+	description, command := waitCommand(wait)
+	client, closeClient, err := connect(resourceCtx)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	resourceCtx.Logger.Info(fmt.Sprintf("Waiting for %s on %s", description, resourceCtx.Host.Name))
+	resourceCtx.Logger.Command(command)
+	deadline := time.Now().Add(timeout)
+	for {
+		result, err := client.Exec(ctx, command)
+		if err != nil {
+			return fmt.Errorf("failed to check %s: %w", description, err)
+		}
+		if result.Success() {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			if out := result.Output(); out != "" {
+				resourceCtx.Logger.ErrorOutput(out)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, description)
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// waitCommand returns a description of the condition and the command that
+// succeeds once it holds
+func waitCommand(wait common.WaitFor) (string, string) {
+	switch {
+	case wait.Port != "":
+		host, port, err := net.SplitHostPort(wait.Port)
+		if err != nil {
+			host, port = "127.0.0.1", wait.Port
+		}
+		return fmt.Sprintf("port %s:%s", host, port),
+			"timeout 5 bash -c " + common.ShellQuote(fmt.Sprintf("exec 3<>/dev/tcp/%s/%s", host, port))
+	case wait.Path != "":
+		return "path " + wait.Path, "test -e " + common.ShellQuote(wait.Path)
+	default:
+		return fmt.Sprintf("command %q", wait.Command), wait.Command
+	}
+}
+
+// sleep pauses for d, returning early with an error when ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
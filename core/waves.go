@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ExecutionWave is a set of resources or actions none of which waits on
+// another of the set or of a later wave, so a wave may run concurrently once
+// the waves before it are done
+type ExecutionWave struct {
+	Index     int          `json:"index"`
+	Resources []ResourceID `json:"resources"`
+}
+
+// ExecutionWaves groups the resources of the graph into waves by their
+// required edges, each wave sorted by layer, then by ID. Edges to resources
+// missing from the graph are left to ValidateDependencies.
+func (g *Graph) ExecutionWaves() ([]ExecutionWave, error) {
+	inDegree := make(map[ResourceID]int, len(g.nodes))
+	successors := make(map[ResourceID][]ResourceID)
+	for id := range g.nodes {
+		inDegree[id] = 0
+	}
+	for id, deps := range g.edges {
+		if _, ok := g.nodes[id]; !ok {
+			continue
+		}
+		for _, dep := range deps {
+			if _, ok := g.nodes[dep.Target]; !ok || !dep.Required || dep.Target == id {
+				continue
+			}
+			before, after := dep.Target, id
+			if !dep.EdgeType.TargetFirst() {
+				before, after = id, dep.Target
+			}
+			successors[before] = append(successors[before], after)
+			inDegree[after]++
+		}
+	}
+
+	var ready []ResourceID
+	for id, degree := range inDegree {
+		if degree == 0 {
+			ready = append(ready, id)
+		}
+	}
+	var waves []ExecutionWave
+	sorted := 0
+	for len(ready) > 0 {
+		g.sortReady(ready)
+		waves = append(waves, ExecutionWave{Index: len(waves), Resources: ready})
+		sorted += len(ready)
+
+		var next []ResourceID
+		for _, id := range ready {
+			for _, successor := range successors[id] {
+				inDegree[successor]--
+				if inDegree[successor] == 0 {
+					next = append(next, successor)
+				}
+			}
+		}
+		ready = next
+	}
+
+	if sorted != len(g.nodes) {
+		return nil, fmt.Errorf("circular dependency detected")
+	}
+	return waves, nil
+}
+
+// Prerequisites returns the resources an action of actionType on id waits
+// on. Creates and updates wait on the targets of its edges that come first,
+// such as what id depends on, and on the resources triggering it, such as
+// its unit or configuration files; deletes wait on every resource pointing
+// at id, which is removed first.
+func (g *Graph) Prerequisites(actionType ActionType, id ResourceID) []ResourceID {
+	var ids []ResourceID
+	if actionType != ActionDelete {
+		for _, dep := range g.GetDependencies(id) {
+			if dep.EdgeType.TargetFirst() {
+				ids = append(ids, dep.Target)
+			}
+		}
+	}
+	dependents := g.GetDependents(id)
+	sort.Slice(dependents, func(i, j int) bool { return dependents[i] < dependents[j] })
+	for _, dependent := range dependents {
+		if actionType == ActionDelete || g.comesBefore(dependent, id) {
+			ids = append(ids, dependent)
+		}
+	}
+	return ids
+}
+
+// AllPrerequisites returns the transitive closure of Prerequisites: every
+// resource an action of actionType on id waits on, directly or through
+// others, nearest first
+func (g *Graph) AllPrerequisites(actionType ActionType, id ResourceID) []ResourceID {
+	var ids []ResourceID
+	seen := map[ResourceID]bool{id: true}
+	queue := g.Prerequisites(actionType, id)
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		ids = append(ids, next)
+		queue = append(queue, g.Prerequisites(actionType, next)...)
+	}
+	return ids
+}
+
+// comesBefore reports whether source has an edge to target that applies
+// source first, such as a triggers edge
+func (g *Graph) comesBefore(source, target ResourceID) bool {
+	for _, dep := range g.GetDependencies(source) {
+		if dep.Target == target && !dep.EdgeType.TargetFirst() {
+			return true
+		}
+	}
+	return false
+}
+
+// Waves groups the changes of the plan into execution waves in plan order:
+// an action joins the wave after the last one holding an action it waits
+// on. No-op actions are left out, and a plan without a graph has no waves.
+func (p *Plan) Waves() []ExecutionWave {
+	if p.Graph == nil {
+		return nil
+	}
+	waveOf := make(map[ResourceID]int)
+	var waves []ExecutionWave
+	for _, action := range p.Actions {
+		if action.Type == ActionNoOp {
+			continue
+		}
+		wave := 0
+		for _, id := range p.Graph.AllPrerequisites(action.Type, action.ResourceID) {
+			if earlier, ok := waveOf[id]; ok {
+				wave = max(wave, earlier+1)
+			}
+		}
+
+		waveOf[action.ResourceID] = wave
+		for len(waves) <= wave {
+			waves = append(waves, ExecutionWave{Index: len(waves)})
+		}
+		waves[wave].Resources = append(waves[wave].Resources, action.ResourceID)
+	}
+	return waves
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// randomGraph returns resources with random edges that always order a
+// resource after the ones numbered before it, so the graph has no cycles
+func randomGraph(r *rand.Rand, n int) []Resource {
+	id := func(i int) ResourceID { return ResourceID(fmt.Sprintf("r%02d", i)) }
+	deps := make([][]Dependency, n)
+	for i := 0; i < n; i++ {
+		for j := 0; j < i; j++ {
+			if r.Intn(4) != 0 {
+				continue
+			}
+			if r.Intn(5) == 0 {
+				deps[j] = append(deps[j], triggersEdge(id(i)))
+			} else {
+				deps[i] = append(deps[i], dependsOn(id(j)))
+			}
+		}
+	}
+
+	resources := make([]Resource, n)
+	for i := range resources {
+		resources[i] = testResource(id(i), Layer(r.Intn(6)), deps[i]...)
+	}
+	return resources
+}
+
+func TestExecutionWavesOrderDependencies(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		resources := randomGraph(r, 2+r.Intn(30))
+		graph := buildGraph(t, resources)
+		waves, err := graph.ExecutionWaves()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waveOf := make(map[ResourceID]int)
+		for _, wave := range waves {
+			for _, id := range wave.Resources {
+				if _, ok := waveOf[id]; ok {
+					t.Fatalf("%s is in more than one wave", id)
+				}
+				waveOf[id] = wave.Index
+			}
+		}
+		if len(waveOf) != len(resources) {
+			t.Fatalf("waves hold %d resources, want %d", len(waveOf), len(resources))
+		}
+
+		for _, resource := range resources {
+			for _, dep := range resource.GetDependencies() {
+				before, after := dep.Target, resource.GetID()
+				if !dep.EdgeType.TargetFirst() {
+					before, after = after, before
+				}
+				if waveOf[before] >= waveOf[after] {
+					t.Fatalf("graph %d: %s (wave %d) must come before %s (wave %d) by its %s edge",
+						i, before, waveOf[before], after, waveOf[after], dep.EdgeType)
+				}
+			}
+		}
+
+		// The topological sort follows the waves
+		order, err := graph.TopologicalSort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 1; j < len(order); j++ {
+			if waveOf[order[j-1]] > waveOf[order[j]] {
+				t.Fatalf("graph %d: sort puts %s before %s from an earlier wave", i, order[j-1], order[j])
+			}
+		}
+	}
+}
+
+func TestPrerequisites(t *testing.T) {
+	graph := buildGraph(t, testGraphResources())
+	tests := []struct {
+		actionType ActionType
+		id         ResourceID
+		want       []ResourceID
+	}{
+		{ActionCreate, "service:nginx", []ResourceID{"package:apt:nginx", "file:/etc/nginx/nginx.conf"}},
+		{ActionCreate, "check:http", []ResourceID{"service:nginx"}},
+		{ActionCreate, "package:apt:nginx", nil},
+		{ActionDelete, "package:apt:nginx", []ResourceID{"file:/etc/nginx/nginx.conf", "service:nginx"}},
+		{ActionDelete, "check:http", nil},
+	}
+	for _, tt := range tests {
+		got := graph.Prerequisites(tt.actionType, tt.id)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Prerequisites(%s, %s) = %v, want %v", tt.actionType, tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestAllPrerequisitesIsTransitiveClosure(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 100; i++ {
+		resources := randomGraph(r, 2+r.Intn(20))
+		graph := buildGraph(t, resources)
+
+		// before[a][b] holds when an edge puts b before a
+		before := make(map[ResourceID]map[ResourceID]bool)
+		for _, resource := range resources {
+			before[resource.GetID()] = make(map[ResourceID]bool)
+		}
+		for _, resource := range resources {
+			for _, dep := range resource.GetDependencies() {
+				if dep.EdgeType.TargetFirst() {
+					before[resource.GetID()][dep.Target] = true
+				} else {
+					before[dep.Target][resource.GetID()] = true
+				}
+			}
+		}
+
+		for _, resource := range resources {
+			id := resource.GetID()
+			want := closure(id, func(id ResourceID) []ResourceID { return keys(before[id]) })
+			if got := sorted(graph.AllPrerequisites(ActionCreate, id)); !reflect.DeepEqual(got, want) {
+				t.Fatalf("graph %d: AllPrerequisites(create, %s) = %v, want %v", i, id, got, want)
+			}
+
+			// Deletes wait on everything pointing at the resource
+			want = closure(id, graph.GetDependents)
+			if got := sorted(graph.AllPrerequisites(ActionDelete, id)); !reflect.DeepEqual(got, want) {
+				t.Fatalf("graph %d: AllPrerequisites(delete, %s) = %v, want %v", i, id, got, want)
+			}
+		}
+	}
+}
+
+// closure returns the resources reachable from id through next, sorted
+func closure(id ResourceID, next func(ResourceID) []ResourceID) []ResourceID {
+	seen := map[ResourceID]bool{}
+	var visit func(ResourceID)
+	visit = func(id ResourceID) {
+		for _, n := range next(id) {
+			if !seen[n] {
+				seen[n] = true
+				visit(n)
+			}
+		}
+	}
+	visit(id)
+	delete(seen, id)
+	return keys(seen)
+}
+
+func keys(set map[ResourceID]bool) []ResourceID {
+	var ids []ResourceID
+	for id := range set {
+		ids = append(ids, id)
+	}
+	return sorted(ids)
+}
+
+func sorted(ids []ResourceID) []ResourceID {
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -2,18 +2,37 @@ package pkg
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/settlectl/settle-core/common"
 	"github.com/settlectl/settle-core/inventory"
-	"github.com/settlectl/settle-core/inventory/ssh"
 )
 
 type AptManager struct {
-	SSHClient *ssh.SSHClient
+	Transport inventory.Transport
 }	
 
+// DefaultCacheValidTime is how old the package index may be before an
+// install refreshes it, for packages that set no cache_valid_time
+const DefaultCacheValidTime = time.Hour
+
+// aptCacheStampCommand prints the host's clock followed by the modification
+// times of the stamp apt touches after a successful update and of the
+// index directory, for hosts without the stamp
+const aptCacheStampCommand = "date +%s; stat -c %Y /var/lib/apt/periodic/update-success-stamp /var/lib/apt/lists 2>/dev/null || true"
+
+// aptEnv keeps apt, debconf and needrestart from asking questions; a
+// command waiting for an answer would hang until it times out
+var aptEnv = map[string]string{
+	"DEBIAN_FRONTEND":          "noninteractive",
+	"APT_LISTCHANGES_FRONTEND": "none",
+	"NEEDRESTART_MODE":         "a",
+}
+
 type InstallResult struct {
 	Package     common.Package
 	Success     bool
@@ -22,24 +41,31 @@ type InstallResult struct {
 	InstallTime time.Duration
 }
 
+// NewAptManager returns a manager using the transport of ctx, connecting to
+// its host when it has none. Its commands run noninteractively.
 func NewAptManager(ctx *inventory.Context) (*AptManager, error) {
-	ctx.Logger.SSHConnection(ctx.Host.Hostname, ctx.Host.User, fmt.Sprintf("%d", ctx.Host.Port))
-
-	sshClient, err := ssh.NewSSHClient(ctx.Host)
+	transport, err := connectTransport(ctx)
 	if err != nil {
-		ctx.Logger.SSHError(err)
-		return nil, fmt.Errorf("failed to create SSH client: %w", err)
+		return nil, err
 	}
-
-	ctx.Logger.SSHSuccess()
 	return &AptManager{
-		SSHClient: sshClient,
+		Transport: inventory.WithCommandOptions(transport, common.CommandOptions{Env: aptEnv}),
 	}, nil
 }
 
+func init() {
+	Register(common.PackageManagerAPT, func(ctx *inventory.Context) (PackageManager, error) {
+		return NewAptManager(ctx)
+	})
+}
+
 func (m *AptManager) Install(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
 	runtimeCtx.Logger.Info("Starting package installation...")
 
+	if err := m.updateCache(ctx, runtimeCtx, packages); err != nil {
+		return err
+	}
+
 	results := make([]InstallResult, 0, len(packages))
 	successCount := 0
 	failureCount := 0
@@ -56,9 +82,18 @@ func (m *AptManager) Install(ctx context.Context, runtimeCtx *inventory.Context,
 
 		runtimeCtx.Logger.Info(fmt.Sprintf("Installing %s...", pkgName))
 
-		command := fmt.Sprintf("sudo apt-get install -y %s", pkgName)
+		flags := ""
+		// A pinned version may be older than the installed one
+		if pkgName != pkg.Name {
+			flags += " --allow-downgrades"
+		}
+		// apt refuses to change a held package unless told to
+		if pkg.Hold {
+			flags += " --allow-change-held-packages"
+		}
+		command := fmt.Sprintf("sudo apt-get install -y%s%s %s", dpkgOptions(pkg), flags, common.ShellQuote(pkgName))
 		runtimeCtx.Logger.Command(command)
-		out, err := m.SSHClient.RunCommand(ctx, command)
+		out, err := m.aptGet(ctx, command)
 
 		result := InstallResult{
 			Package:     pkg,
@@ -73,7 +108,7 @@ func (m *AptManager) Install(ctx context.Context, runtimeCtx *inventory.Context,
 
 			runtimeCtx.Logger.Error(fmt.Sprintf("Failed to install %s: %v", pkgName, err))
 			if out != "" {
-				runtimeCtx.Logger.CommandOutput(out)
+				runtimeCtx.Logger.ErrorOutput(out)
 			}
 
 			results = append(results, result)
@@ -127,9 +162,17 @@ func (m *AptManager) Remove(ctx context.Context, runtimeCtx *inventory.Context,
 			pkgName = pkg.Name
 		}
 
-		command := fmt.Sprintf("sudo apt-get remove -y %s", pkgName)
+		action := "remove"
+		if pkg.Purge {
+			action = "purge"
+		}
+		flags := ""
+		if pkg.Hold {
+			flags = " --allow-change-held-packages"
+		}
+		command := fmt.Sprintf("sudo apt-get %s -y%s %s", action, flags, common.ShellQuote(pkgName))
 		runtimeCtx.Logger.Command(command)
-		out, err := m.SSHClient.RunCommand(ctx, command)
+		out, err := m.aptGet(ctx, command)
 
 		result := InstallResult{
 			Package:     pkg,
@@ -144,7 +187,7 @@ func (m *AptManager) Remove(ctx context.Context, runtimeCtx *inventory.Context,
 
 			runtimeCtx.Logger.Error(fmt.Sprintf("Failed to remove %s: %v", pkgName, err))
 			if out != "" {
-				runtimeCtx.Logger.CommandOutput(out)
+				runtimeCtx.Logger.ErrorOutput(out)
 			}
 
 			results = append(results, result)
@@ -165,6 +208,10 @@ func (m *AptManager) Remove(ctx context.Context, runtimeCtx *inventory.Context,
 
 	runtimeCtx.Logger.Info(fmt.Sprintf("Removal complete: %d successful, %d failed", successCount, failureCount))
 
+	if err := m.autoremove(ctx, runtimeCtx, results); err != nil {
+		return err
+	}
+
 	if failureCount == len(packages) {
 		return fmt.Errorf("all package removals failed on host %s", runtimeCtx.Host.Name)
 	}
@@ -181,19 +228,19 @@ func (m *AptManager) Remove(ctx context.Context, runtimeCtx *inventory.Context,
 	return nil
 }
 
+// DoesExist reports whether every package is installed at its requested
+// version
 func (m *AptManager) DoesExist(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) (bool, error) {
 	runtimeCtx.Logger.Info("Checking if packages exist...")
 	results := make([]InstallResult, 0, len(packages))
-	successCount := 0
+	installedCount := 0
 	failureCount := 0
 
 	for _, pkg := range packages {
 		startTime := time.Now()
 		runtimeCtx.Logger.Info(fmt.Sprintf("Checking if %s exists...", pkg.Name))
 
-		command := fmt.Sprintf("dpkg -l | grep -w %s", pkg.Name)
-		runtimeCtx.Logger.Command(command)
-		out, err := m.SSHClient.RunCommand(ctx, command)
+		version, err := m.GetVersion(ctx, runtimeCtx, pkg)
 
 		result := InstallResult{
 			Package:     pkg,
@@ -203,33 +250,30 @@ func (m *AptManager) DoesExist(ctx context.Context, runtimeCtx *inventory.Contex
 		if err != nil {
 			result.Success = false
 			result.Error = err
-			result.Output = out
 			failureCount++
 
 			runtimeCtx.Logger.Error(fmt.Sprintf("Failed to check if %s exists: %v", pkg.Name, err))
-			if out != "" {
-				runtimeCtx.Logger.CommandOutput(out)
-			}
-
 			results = append(results, result)
 			continue
 		}
 
 		result.Success = true
-		result.Output = out
-		successCount++
+		results = append(results, result)
 
-		runtimeCtx.Logger.Success(fmt.Sprintf("Package %s exists", pkg.Name))
-		if out != "" {
-			runtimeCtx.Logger.CommandOutput(out)
+		switch {
+		case version == "":
+			runtimeCtx.Logger.Info(fmt.Sprintf("Package %s is not installed", pkg.Name))
+		case !m.VersionSatisfies(version, pkg.Version):
+			runtimeCtx.Logger.Info(fmt.Sprintf("Package %s %s is installed, %s requested", pkg.Name, version, pkg.Version))
+		default:
+			installedCount++
+			runtimeCtx.Logger.Success(fmt.Sprintf("Package %s %s exists", pkg.Name, version))
 		}
-
-		results = append(results, result)
 	}
 
-	runtimeCtx.Logger.Info(fmt.Sprintf("Check complete: %d successful, %d failed", successCount, failureCount))
+	runtimeCtx.Logger.Info(fmt.Sprintf("Check complete: %d installed, %d failed", installedCount, failureCount))
 
-	if failureCount == len(packages) {
+	if failureCount > 0 && failureCount == len(packages) {
 		return false, fmt.Errorf("all package checks failed on host %s", runtimeCtx.Host.Name)
 	}
 
@@ -241,5 +285,216 @@ func (m *AptManager) DoesExist(ctx context.Context, runtimeCtx *inventory.Contex
 			}
 		}
 	}
-	return successCount == len(packages), nil
+	return installedCount == len(packages), nil
+}
+
+// updateCache runs apt-get update when the package index is older than the
+// shortest cache_valid_time of packages, so fresh hosts do not install from
+// stale or missing indexes
+func (m *AptManager) updateCache(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
+	valid := time.Duration(0)
+	for _, pkg := range packages {
+		pkgValid := pkg.CacheValidTime
+		if pkgValid <= 0 {
+			pkgValid = DefaultCacheValidTime
+		}
+		if valid == 0 || pkgValid < valid {
+			valid = pkgValid
+		}
+	}
+
+	age, err := m.cacheAge(ctx, runtimeCtx)
+	if err != nil {
+		return err
+	}
+	if age >= 0 && age < valid {
+		runtimeCtx.Logger.Info(fmt.Sprintf("Package index is %v old, not updating (valid for %v)", age.Round(time.Second), valid))
+		return nil
+	}
+
+	command := "sudo apt-get update"
+	runtimeCtx.Logger.Info("Updating package index...")
+	runtimeCtx.Logger.Command(command)
+	out, err := m.aptGet(ctx, command)
+	if err != nil {
+		if out != "" {
+			runtimeCtx.Logger.ErrorOutput(out)
+		}
+		return fmt.Errorf("failed to update package index on host %s: %w", runtimeCtx.Host.Name, err)
+	}
+	return nil
+}
+
+// cacheAge returns the age of the package index by the host's clock, or -1
+// when it was never updated
+func (m *AptManager) cacheAge(ctx context.Context, runtimeCtx *inventory.Context) (time.Duration, error) {
+	runtimeCtx.Logger.Command(aptCacheStampCommand)
+	out, err := m.exec(ctx, aptCacheStampCommand)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check package index age: %w", err)
+	}
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("failed to check package index age: no output")
+	}
+	now, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check package index age: invalid time %q", fields[0])
+	}
+
+	// The newest of the stamp and the index directory
+	var updated int64
+	for _, field := range fields[1:] {
+		if t, err := strconv.ParseInt(field, 10, 64); err == nil && t > updated {
+			updated = t
+		}
+	}
+	if updated == 0 {
+		return -1, nil
+	}
+	return time.Duration(now-updated) * time.Second, nil
+}
+
+// autoremove removes dependencies nothing needs anymore, once, when a
+// removed package asked for it
+func (m *AptManager) autoremove(ctx context.Context, runtimeCtx *inventory.Context, results []InstallResult) error {
+	requested, purge := false, false
+	for _, result := range results {
+		if result.Success && result.Package.Autoremove {
+			requested = true
+			purge = purge || result.Package.Purge
+		}
+	}
+	if !requested {
+		return nil
+	}
+
+	command := "sudo apt-get autoremove -y"
+	if purge {
+		command = "sudo apt-get autoremove -y --purge"
+	}
+	runtimeCtx.Logger.Info("Removing unused dependencies...")
+	runtimeCtx.Logger.Command(command)
+	out, err := m.aptGet(ctx, command)
+	if out != "" && err != nil {
+		runtimeCtx.Logger.ErrorOutput(out)
+	} else if out != "" {
+		runtimeCtx.Logger.CommandOutput(out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove unused dependencies on host %s: %w", runtimeCtx.Host.Name, err)
+	}
+	return nil
+}
+
+// SetHold marks pkg as held with apt-mark, or releases it, when its current
+// mark differs
+func (m *AptManager) SetHold(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) error {
+	command := fmt.Sprintf("apt-mark showhold %s", common.ShellQuote(pkg.Name))
+	runtimeCtx.Logger.Command(command)
+	out, err := m.exec(ctx, command)
+	if err != nil {
+		return fmt.Errorf("failed to check hold of %s: %w", pkg.Name, err)
+	}
+	held := false
+	for _, line := range strings.Fields(out) {
+		if line == pkg.Name {
+			held = true
+		}
+	}
+	if held == pkg.Hold {
+		return nil
+	}
+
+	mark := "unhold"
+	if pkg.Hold {
+		mark = "hold"
+	}
+	command = fmt.Sprintf("sudo apt-mark %s %s", mark, common.ShellQuote(pkg.Name))
+	runtimeCtx.Logger.Command(command)
+	out, err = m.exec(ctx, command)
+	if err != nil {
+		if out != "" {
+			runtimeCtx.Logger.ErrorOutput(out)
+		}
+		return fmt.Errorf("failed to %s %s: %w", mark, pkg.Name, err)
+	}
+	runtimeCtx.Logger.Success(fmt.Sprintf("Package %s: %s", pkg.Name, mark))
+	return nil
+}
+
+// VersionSatisfies compares Debian versions, see DebianVersionSatisfies
+func (m *AptManager) VersionSatisfies(installed, requested string) bool {
+	return DebianVersionSatisfies(installed, requested)
+}
+
+// GetVersion returns the installed version of pkg, or "" when it is not installed
+func (m *AptManager) GetVersion(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) (string, error) {
+	command := fmt.Sprintf("dpkg-query -W -f='${Status} ${Version}' %s", common.ShellQuote(pkg.Name))
+	runtimeCtx.Logger.Command(command)
+	run, err := m.Transport.Exec(ctx, command)
+	if err != nil {
+		return "", fmt.Errorf("failed to query %s: %w", pkg.Name, err)
+	}
+	// dpkg-query exits with 1 for packages it does not know and 2 on errors
+	switch run.ExitCode {
+	case 0:
+	case 1:
+		return "", nil
+	default:
+		return "", fmt.Errorf("failed to query %s: %w", pkg.Name, run.Err())
+	}
+
+	// The status is "<want> <flag> <state>", e.g. "hold ok installed" for
+	// a package held with apt-mark
+	fields := strings.Fields(run.Stdout)
+	if len(fields) != 4 || fields[1] != "ok" || fields[2] != "installed" {
+		return "", nil
+	}
+	return fields[3], nil
+}
+
+// exec runs command and returns its output, with an error for a nonzero
+// exit code
+func (m *AptManager) exec(ctx context.Context, command string) (string, error) {
+	run, err := m.Transport.Exec(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return run.Output(), run.Err()
+}
+
+// aptGet runs an apt-get command like exec, explaining failures caused by a
+// prompt
+func (m *AptManager) aptGet(ctx context.Context, command string) (string, error) {
+	out, err := m.exec(ctx, command)
+	if err != nil {
+		err = aptPromptError(out, err)
+	}
+	return out, err
+}
+
+// dpkgOptions returns the apt-get options telling dpkg what to do with
+// configuration files changed on the host, instead of asking
+func dpkgOptions(pkg common.Package) string {
+	if pkg.Conffiles == common.ConffilesReplace {
+		return " -o Dpkg::Options::=--force-confnew"
+	}
+	return " -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold"
+}
+
+// aptPromptError adds what to do to err when the output of apt-get shows it
+// stopped at a prompt, or when it timed out, likely waiting for an answer
+func aptPromptError(out string, err error) error {
+	switch {
+	case strings.Contains(out, "at conffile prompt"):
+		return fmt.Errorf("%w (dpkg asked what to do with a changed configuration file; set conffiles = \"%s\" or \"%s\" on the package)", err, common.ConffilesKeep, common.ConffilesReplace)
+	case strings.Contains(out, "dpkg was interrupted"):
+		return fmt.Errorf("%w (an earlier dpkg run was interrupted; run 'sudo dpkg --configure -a' on the host)", err)
+	case strings.Contains(out, "Do you want to continue?"), strings.Contains(out, "Yes, do as I say!"):
+		return fmt.Errorf("%w (apt-get asked for a confirmation -y does not give, e.g. to remove an essential package)", err)
+	case errors.Is(err, common.ErrCommandTimeout), errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w (apt-get may be waiting at a prompt: a maintainer script reading from the terminal, or a dpkg lock held by another process)", err)
+	}
+	return err
 }
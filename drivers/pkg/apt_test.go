@@ -0,0 +1,339 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/transporttest"
+)
+
+const freshCache = "1000000 999900"
+
+func TestAptGetVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		call    transporttest.Call
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "installed",
+			call: transporttest.Call{Output: "install ok installed 1.24.0-1"},
+			want: "1.24.0-1",
+		},
+		{
+			name: "held",
+			call: transporttest.Call{Output: "hold ok installed 1.2.3"},
+			want: "1.2.3",
+		},
+		{
+			name: "half configured",
+			call: transporttest.Call{Output: "install ok half-configured 1.24.0-1"},
+		},
+		{
+			name: "config files left after removal",
+			call: transporttest.Call{Output: "deinstall ok config-files 1.24.0-1"},
+		},
+		{
+			name: "unknown package",
+			call: transporttest.Call{Stderr: "dpkg-query: no packages found matching nginx", ExitCode: 1},
+		},
+		{
+			name:    "dpkg-query error",
+			call:    transporttest.Call{ExitCode: 2},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := tt.call
+			call.Command = "dpkg-query -W -f='${Status} ${Version}' nginx"
+			fake := transporttest.New(call)
+			manager := &AptManager{Transport: fake}
+
+			got, err := manager.GetVersion(context.Background(), transporttest.Context(transporttest.Host("web1"), fake), common.Package{Name: "nginx"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("version = %q, want %q", got, tt.want)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAptInstall(t *testing.T) {
+	keep := " -o Dpkg::Options::=--force-confdef -o Dpkg::Options::=--force-confold"
+	tests := []struct {
+		name    string
+		pkg     common.Package
+		calls   []transporttest.Call
+		wantErr string
+	}{
+		{
+			name: "fresh index is not updated",
+			pkg:  common.Package{Name: "nginx"},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get install -y" + keep + " nginx"},
+			},
+		},
+		{
+			name: "missing index is updated first",
+			pkg:  common.Package{Name: "nginx"},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: "1000000"},
+				{Command: "sudo apt-get update"},
+				{Command: "sudo apt-get install -y" + keep + " nginx"},
+			},
+		},
+		{
+			name: "index older than cache_valid_time is updated",
+			pkg:  common.Package{Name: "nginx", CacheValidTime: time.Minute},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get update"},
+				{Command: "sudo apt-get install -y" + keep + " nginx"},
+			},
+		},
+		{
+			name: "pinned version may downgrade",
+			pkg:  common.Package{Name: "nginx", Version: "1.24.0-1"},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get install -y" + keep + " --allow-downgrades nginx=1.24.0-1"},
+			},
+		},
+		{
+			name: "held package",
+			pkg:  common.Package{Name: "nginx", Hold: true},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get install -y" + keep + " --allow-change-held-packages nginx"},
+			},
+		},
+		{
+			name: "replaced configuration files",
+			pkg:  common.Package{Name: "nginx", Conffiles: common.ConffilesReplace},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get install -y -o Dpkg::Options::=--force-confnew nginx"},
+			},
+		},
+		{
+			name: "failed install",
+			pkg:  common.Package{Name: "nginx"},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: freshCache},
+				{Command: "sudo apt-get install -y" + keep + " nginx", Output: "dpkg: error processing package nginx (--configure):\n end of file on stdin at conffile prompt", ExitCode: 1},
+			},
+			wantErr: "all package installations failed",
+		},
+		{
+			name: "failed update stops the install",
+			pkg:  common.Package{Name: "nginx"},
+			calls: []transporttest.Call{
+				{Command: aptCacheStampCommand, Output: "1000000"},
+				{Command: "sudo apt-get update", ExitCode: 100},
+			},
+			wantErr: "failed to update package index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := transporttest.New(tt.calls...)
+			manager := &AptManager{Transport: fake}
+
+			err := manager.Install(context.Background(), transporttest.Context(transporttest.Host("web1"), fake), []common.Package{tt.pkg})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAptRemove(t *testing.T) {
+	tests := []struct {
+		name  string
+		pkg   common.Package
+		calls []string
+	}{
+		{
+			name:  "remove",
+			pkg:   common.Package{Name: "nginx"},
+			calls: []string{"sudo apt-get remove -y nginx"},
+		},
+		{
+			name:  "purge",
+			pkg:   common.Package{Name: "nginx", Purge: true},
+			calls: []string{"sudo apt-get purge -y nginx"},
+		},
+		{
+			name:  "autoremove",
+			pkg:   common.Package{Name: "nginx", Autoremove: true},
+			calls: []string{"sudo apt-get remove -y nginx", "sudo apt-get autoremove -y"},
+		},
+		{
+			name:  "purge and autoremove",
+			pkg:   common.Package{Name: "nginx", Purge: true, Autoremove: true},
+			calls: []string{"sudo apt-get purge -y nginx", "sudo apt-get autoremove -y --purge"},
+		},
+		{
+			name:  "held package",
+			pkg:   common.Package{Name: "nginx", Hold: true},
+			calls: []string{"sudo apt-get remove -y --allow-change-held-packages nginx"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := transporttest.New()
+			for _, command := range tt.calls {
+				fake.Expect(command, "")
+			}
+			manager := &AptManager{Transport: fake}
+
+			if err := manager.Remove(context.Background(), transporttest.Context(transporttest.Host("web1"), fake), []common.Package{tt.pkg}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAptDoesExist(t *testing.T) {
+	query := "dpkg-query -W -f='${Status} ${Version}' *"
+	tests := []struct {
+		name     string
+		packages []common.Package
+		calls    []transporttest.Call
+		want     bool
+	}{
+		{
+			name:     "all installed",
+			packages: []common.Package{{Name: "nginx"}, {Name: "curl"}},
+			calls: []transporttest.Call{
+				{Command: query, Output: "install ok installed 1.24.0-1"},
+				{Command: query, Output: "install ok installed 8.5.0-2"},
+			},
+			want: true,
+		},
+		{
+			name:     "one missing",
+			packages: []common.Package{{Name: "nginx"}, {Name: "curl"}},
+			calls: []transporttest.Call{
+				{Command: query, Output: "install ok installed 1.24.0-1"},
+				{Command: query, ExitCode: 1},
+			},
+		},
+		{
+			name:     "held",
+			packages: []common.Package{{Name: "nginx", Hold: true}},
+			calls: []transporttest.Call{
+				{Command: query, Output: "hold ok installed 1.24.0-1"},
+			},
+			want: true,
+		},
+		{
+			name:     "older version installed",
+			packages: []common.Package{{Name: "nginx", Version: "1.26.0-1"}},
+			calls: []transporttest.Call{
+				{Command: query, Output: "install ok installed 1.24.0-1"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := transporttest.New(tt.calls...)
+			manager := &AptManager{Transport: fake}
+
+			got, err := manager.DoesExist(context.Background(), transporttest.Context(transporttest.Host("web1"), fake), tt.packages)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("exists = %v, want %v", got, tt.want)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAptSetHold(t *testing.T) {
+	tests := []struct {
+		name  string
+		hold  bool
+		held  string
+		calls []string
+	}{
+		{name: "hold", hold: true, calls: []string{"sudo apt-mark hold nginx"}},
+		{name: "already held", hold: true, held: "nginx"},
+		{name: "release", held: "nginx", calls: []string{"sudo apt-mark unhold nginx"}},
+		{name: "not held"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := transporttest.New().Expect("apt-mark showhold nginx", tt.held)
+			for _, command := range tt.calls {
+				fake.Expect(command, "")
+			}
+			manager := &AptManager{Transport: fake}
+
+			if err := manager.SetHold(context.Background(), transporttest.Context(transporttest.Host("web1"), fake), common.Package{Name: "nginx", Hold: tt.hold}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := fake.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestAptPromptError(t *testing.T) {
+	failed := errors.New("exited with status 1")
+	tests := []struct {
+		name string
+		out  string
+		err  error
+		want string
+	}{
+		{name: "conffile prompt", out: "end of file on stdin at conffile prompt", err: failed, want: "conffiles = "},
+		{name: "interrupted dpkg", out: "E: dpkg was interrupted, you must manually run 'dpkg --configure -a'", err: failed, want: "dpkg --configure -a"},
+		{name: "confirmation", out: "Do you want to continue? [Y/n] Abort.", err: failed, want: "asked for a confirmation"},
+		{name: "timeout", err: common.ErrCommandTimeout, want: "waiting at a prompt"},
+		{name: "other failure", out: "E: Unable to locate package nginxx", err: failed, want: failed.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := aptPromptError(tt.out, tt.err)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("error %v does not wrap %v", err, tt.err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// connectTransport returns the transport of ctx, connecting to its host
+// when it has none
+func connectTransport(ctx *inventory.Context) (inventory.Transport, error) {
+	if ctx.Transport != nil {
+		return ctx.Transport, nil
+	}
+
+	ctx.Logger.SSHConnection(ctx.Host.Hostname, ctx.Host.User, fmt.Sprintf("%d", ctx.Host.Port))
+
+	transport, err := ctx.Connect(ctx.Host)
+	if err != nil {
+		ctx.Logger.SSHError(err)
+		return nil, err
+	}
+
+	ctx.Logger.SSHSuccess()
+	return transport, nil
+}
+
+// batchAction names an action in the log, e.g. install, Installing,
+// installed and installation
+type batchAction struct {
+	verb, doing, done, noun string
+}
+
+var (
+	installAction = batchAction{"install", "Installing", "installed", "installation"}
+	removeAction  = batchAction{"remove", "Removing", "removed", "removal"}
+)
+
+// runBatch calls fn for every package, logging each outcome and a summary
+// the way the apt driver does. It fails only when every package failed.
+func runBatch(runtimeCtx *inventory.Context, action batchAction, packages []common.Package, fn func(pkg common.Package) (string, error)) ([]InstallResult, error) {
+	results := make([]InstallResult, 0, len(packages))
+	successCount := 0
+	failureCount := 0
+
+	for _, pkg := range packages {
+		startTime := time.Now()
+		runtimeCtx.Logger.Info(fmt.Sprintf("%s %s...", action.doing, pkg.Name))
+
+		out, err := fn(pkg)
+		result := InstallResult{
+			Package:     pkg,
+			Success:     err == nil,
+			Error:       err,
+			Output:      out,
+			InstallTime: time.Since(startTime),
+		}
+		results = append(results, result)
+
+		if err != nil {
+			failureCount++
+			runtimeCtx.Logger.Error(fmt.Sprintf("Failed to %s %s: %v", action.verb, pkg.Name, err))
+		} else {
+			successCount++
+			runtimeCtx.Logger.Success(fmt.Sprintf("Successfully %s %s in %v", action.done, pkg.Name, result.InstallTime))
+		}
+		if out != "" && err != nil {
+			runtimeCtx.Logger.ErrorOutput(out)
+		} else if out != "" {
+			runtimeCtx.Logger.CommandOutput(out)
+		}
+	}
+
+	runtimeCtx.Logger.Info(fmt.Sprintf("%s%s complete: %d successful, %d failed", strings.ToUpper(action.noun[:1]), action.noun[1:], successCount, failureCount))
+
+	if failureCount == len(packages) {
+		return results, fmt.Errorf("all package %ss failed on host %s", action.noun, runtimeCtx.Host.Name)
+	}
+	if failureCount > 0 {
+		runtimeCtx.Logger.Warning("Failed packages:")
+		for _, result := range results {
+			if !result.Success {
+				runtimeCtx.Logger.Error(fmt.Sprintf("  - %s: %v", result.Package.Name, result.Error))
+			}
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"strconv"
+	"strings"
+)
+
+// debianVersion is a version split as dpkg does: [epoch:]upstream[-revision]
+type debianVersion struct {
+	epoch    int
+	upstream string
+	revision string
+}
+
+func parseDebianVersion(version string) debianVersion {
+	version = strings.TrimSpace(version)
+	var v debianVersion
+	if i := strings.IndexByte(version, ':'); i >= 0 {
+		if epoch, err := strconv.Atoi(version[:i]); err == nil {
+			v.epoch = epoch
+			version = version[i+1:]
+		}
+	}
+	if i := strings.LastIndexByte(version, '-'); i >= 0 {
+		v.upstream, v.revision = version[:i], version[i+1:]
+	} else {
+		v.upstream = version
+	}
+	return v
+}
+
+// CompareDebianVersions compares two Debian package versions the way dpkg
+// does, returning -1, 0 or 1. A tilde sorts before anything, even the end of
+// the version, so 1.0~rc1 is older than 1.0.
+func CompareDebianVersions(a, b string) int {
+	va, vb := parseDebianVersion(a), parseDebianVersion(b)
+	switch {
+	case va.epoch < vb.epoch:
+		return -1
+	case va.epoch > vb.epoch:
+		return 1
+	}
+	if c := compareDebianPart(va.upstream, vb.upstream); c != 0 {
+		return c
+	}
+	return compareDebianPart(va.revision, vb.revision)
+}
+
+// DebianVersionSatisfies reports whether installed is the requested
+// version. A request without a revision, such as 1.24.0, is satisfied by
+// any revision of it, e.g. 1.24.0-1ubuntu1.
+func DebianVersionSatisfies(installed, requested string) bool {
+	if requested == "" || requested == "latest" {
+		return installed != ""
+	}
+	if installed == "" {
+		return false
+	}
+	if CompareDebianVersions(installed, requested) == 0 {
+		return true
+	}
+	want := parseDebianVersion(requested)
+	if want.revision != "" {
+		return false
+	}
+	have := parseDebianVersion(installed)
+	return have.epoch == want.epoch && compareDebianPart(have.upstream, want.upstream) == 0
+}
+
+// compareDebianPart compares an upstream version or revision: alternating
+// runs of non-digits, compared by debianOrder, and digits, compared
+// numerically
+func compareDebianPart(a, b string) int {
+	for a != "" || b != "" {
+		var sa, sb string
+		sa, a = splitRun(a, false)
+		sb, b = splitRun(b, false)
+		if c := compareNonDigits(sa, sb); c != 0 {
+			return c
+		}
+
+		sa, a = splitRun(a, true)
+		sb, b = splitRun(b, true)
+		if c := compareDigits(sa, sb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// splitRun splits the leading run of digits (or non-digits) off s
+func splitRun(s string, digits bool) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func compareNonDigits(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb int
+		if i < len(a) {
+			ca = debianOrder(a[i])
+		}
+		if i < len(b) {
+			cb = debianOrder(b[i])
+		}
+		switch {
+		case ca < cb:
+			return -1
+		case ca > cb:
+			return 1
+		}
+	}
+	return 0
+}
+
+// debianOrder ranks a character: ~ before the end of the string (0), then
+// letters, then everything else
+func debianOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	}
+	return int(c) + 256
+}
+
+func compareDigits(a, b string) int {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// DefaultFlatpakRemote is the remote apps are installed from when a package
+// names none
+const DefaultFlatpakRemote = "flathub"
+
+// FlatpakManager installs flatpak apps system-wide. The package name is the
+// app ID, e.g. org.mozilla.firefox, and its channel the branch.
+type FlatpakManager struct {
+	Transport inventory.Transport
+}
+
+// NewFlatpakManager returns a manager using the transport of ctx, connecting
+// to its host when it has none
+func NewFlatpakManager(ctx *inventory.Context) (*FlatpakManager, error) {
+	transport, err := connectTransport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &FlatpakManager{Transport: transport}, nil
+}
+
+func init() {
+	Register(common.PackageManagerFlatpak, func(ctx *inventory.Context) (PackageManager, error) {
+		return NewFlatpakManager(ctx)
+	})
+}
+
+// flatpakApp is a row of `flatpak list`
+type flatpakApp struct {
+	Branch  string
+	Version string
+}
+
+// flatpakRef returns the app ID of pkg with its branch, if any
+func flatpakRef(pkg common.Package) string {
+	if pkg.Channel != "" {
+		return pkg.Name + "//" + pkg.Channel
+	}
+	return pkg.Name
+}
+
+func flatpakRemote(pkg common.Package) string {
+	if pkg.Remote != "" {
+		return pkg.Remote
+	}
+	return DefaultFlatpakRemote
+}
+
+func (m *FlatpakManager) Install(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
+	runtimeCtx.Logger.Info("Starting flatpak installation...")
+	_, err := runBatch(runtimeCtx, installAction, packages, func(pkg common.Package) (string, error) {
+		remote := flatpakRemote(pkg)
+		if pkg.RemoteURL != "" {
+			command := fmt.Sprintf("sudo flatpak remote-add --if-not-exists %s", common.ShellJoin(remote, pkg.RemoteURL))
+			runtimeCtx.Logger.Command(command)
+			if out, err := m.exec(ctx, command); err != nil {
+				return out, fmt.Errorf("failed to add remote %s: %w", remote, err)
+			}
+		}
+
+		command := fmt.Sprintf("sudo flatpak install -y --noninteractive %s", common.ShellJoin(remote, flatpakRef(pkg)))
+		runtimeCtx.Logger.Command(command)
+		return m.exec(ctx, command)
+	})
+	return err
+}
+
+func (m *FlatpakManager) Remove(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
+	runtimeCtx.Logger.Info("Starting flatpak removal...")
+	results, err := runBatch(runtimeCtx, removeAction, packages, func(pkg common.Package) (string, error) {
+		command := fmt.Sprintf("sudo flatpak uninstall -y --noninteractive %s", common.ShellQuote(flatpakRef(pkg)))
+		if pkg.Purge {
+			command = fmt.Sprintf("sudo flatpak uninstall -y --noninteractive --delete-data %s", common.ShellQuote(flatpakRef(pkg)))
+		}
+		runtimeCtx.Logger.Command(command)
+		return m.exec(ctx, command)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Runtimes no installed app uses anymore
+	for _, result := range results {
+		if result.Success && result.Package.Autoremove {
+			command := "sudo flatpak uninstall -y --noninteractive --unused"
+			runtimeCtx.Logger.Info("Removing unused runtimes...")
+			runtimeCtx.Logger.Command(command)
+			if out, err := m.exec(ctx, command); err != nil {
+				if out != "" {
+					runtimeCtx.Logger.ErrorOutput(out)
+				}
+				return fmt.Errorf("failed to remove unused runtimes on host %s: %w", runtimeCtx.Host.Name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// DoesExist reports whether every app is installed, on its branch when one
+// is requested
+func (m *FlatpakManager) DoesExist(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) (bool, error) {
+	runtimeCtx.Logger.Info("Checking if flatpaks exist...")
+	for _, pkg := range packages {
+		app, err := m.app(ctx, runtimeCtx, pkg)
+		if err != nil {
+			return false, fmt.Errorf("failed to check if %s exists: %w", pkg.Name, err)
+		}
+		if app == nil {
+			runtimeCtx.Logger.Info(fmt.Sprintf("Flatpak %s is not installed", flatpakRef(pkg)))
+			return false, nil
+		}
+		runtimeCtx.Logger.Success(fmt.Sprintf("Flatpak %s exists", flatpakRef(pkg)))
+	}
+	return true, nil
+}
+
+// GetVersion returns the version of the installed app, its branch when the
+// app declares no version, or "" when it is not installed
+func (m *FlatpakManager) GetVersion(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) (string, error) {
+	app, err := m.app(ctx, runtimeCtx, pkg)
+	if err != nil || app == nil {
+		return "", err
+	}
+	if app.Version == "" {
+		return app.Branch, nil
+	}
+	return app.Version, nil
+}
+
+// VersionSatisfies compares versions exactly; flatpaks are pinned by branch
+// rather than version
+func (m *FlatpakManager) VersionSatisfies(installed, requested string) bool {
+	if requested == "" || requested == "latest" {
+		return installed != ""
+	}
+	return installed == requested
+}
+
+// app returns the installed app of pkg, on its branch if it names one, or
+// nil when it is not installed
+func (m *FlatpakManager) app(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) (*flatpakApp, error) {
+	command := "flatpak list --app --columns=application,branch,version"
+	runtimeCtx.Logger.Command(command)
+	out, err := m.exec(ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list flatpaks: %w", err)
+	}
+
+	// Columns are separated by tabs; the version may be empty
+	for _, line := range strings.Split(out, "\n") {
+		columns := strings.Split(line, "\t")
+		if len(columns) < 2 || strings.TrimSpace(columns[0]) != pkg.Name {
+			continue
+		}
+		app := &flatpakApp{Branch: strings.TrimSpace(columns[1])}
+		if len(columns) > 2 {
+			app.Version = strings.TrimSpace(columns[2])
+		}
+		if pkg.Channel == "" || app.Branch == pkg.Channel {
+			return app, nil
+		}
+	}
+	return nil, nil
+}
+
+// exec runs command and returns its output, with an error for a nonzero
+// exit code
+func (m *FlatpakManager) exec(ctx context.Context, command string) (string, error) {
+	run, err := m.Transport.Exec(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return run.Output(), run.Err()
+}
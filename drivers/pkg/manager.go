@@ -11,4 +11,15 @@ type PackageManager interface {
 	Install(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error
 	Remove(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error
 	DoesExist(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) (bool, error)
+	GetVersion(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) (string, error)
+	// VersionSatisfies reports whether the installed version is the
+	// requested one; an empty or "latest" request accepts any version
+	VersionSatisfies(installed, requested string) bool
+}
+
+// Holder is implemented by package managers that can keep a package at its
+// installed version during upgrades
+type Holder interface {
+	// SetHold holds pkg when pkg.Hold is set and releases it otherwise
+	SetHold(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) error
 }
\ No newline at end of file
@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Factory creates a package manager driver for the host of ctx
+type Factory func(ctx *inventory.Context) (PackageManager, error)
+
+var factories = make(map[string]Factory)
+
+// Register makes the driver of the package manager name available to
+// package resources. Drivers register themselves in init functions;
+// registering a name twice panics.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic("pkg: package manager " + name + " registered twice")
+	}
+	factories[name] = factory
+}
+
+// New returns the driver of the package manager name for the host of ctx
+func New(name string, ctx *inventory.Context) (PackageManager, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported package manager: %s", name)
+	}
+	manager, err := factory(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s manager: %w", name, err)
+	}
+	return manager, nil
+}
+
+// Managers returns the names of the registered package managers, sorted
+func Managers() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,204 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// SnapManager installs snaps, following the channel of each package
+type SnapManager struct {
+	Transport inventory.Transport
+}
+
+// NewSnapManager returns a manager using the transport of ctx, connecting to
+// its host when it has none
+func NewSnapManager(ctx *inventory.Context) (*SnapManager, error) {
+	transport, err := connectTransport(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapManager{Transport: transport}, nil
+}
+
+func init() {
+	Register(common.PackageManagerSnap, func(ctx *inventory.Context) (PackageManager, error) {
+		return NewSnapManager(ctx)
+	})
+}
+
+// snapInfo is a row of `snap list`
+type snapInfo struct {
+	Version  string
+	Tracking string // channel the snap follows, e.g. latest/stable
+	Held     bool
+}
+
+// snapRisks are the risk levels of a channel, which may stand alone
+var snapRisks = []string{"stable", "candidate", "beta", "edge"}
+
+// normalizeSnapChannel expands a channel to track/risk as `snap list`
+// reports it: stable becomes latest/stable and 1.28 becomes 1.28/stable
+func normalizeSnapChannel(channel string) string {
+	if channel == "" || strings.Contains(channel, "/") {
+		return channel
+	}
+	for _, risk := range snapRisks {
+		if channel == risk {
+			return "latest/" + channel
+		}
+	}
+	return channel + "/stable"
+}
+
+func (m *SnapManager) Install(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
+	runtimeCtx.Logger.Info("Starting snap installation...")
+	_, err := runBatch(runtimeCtx, installAction, packages, func(pkg common.Package) (string, error) {
+		info, err := m.info(ctx, runtimeCtx, pkg.Name)
+		if err != nil {
+			return "", err
+		}
+
+		// An installed snap on another channel is switched with refresh
+		verb := "install"
+		if info != nil {
+			verb = "refresh"
+		}
+		command := fmt.Sprintf("sudo snap %s %s", verb, common.ShellQuote(pkg.Name))
+		if pkg.Channel != "" {
+			command += " --channel=" + common.ShellQuote(pkg.Channel)
+		}
+		if pkg.Classic {
+			command += " --classic"
+		}
+		runtimeCtx.Logger.Command(command)
+		return m.exec(ctx, command)
+	})
+	return err
+}
+
+func (m *SnapManager) Remove(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) error {
+	runtimeCtx.Logger.Info("Starting snap removal...")
+	_, err := runBatch(runtimeCtx, removeAction, packages, func(pkg common.Package) (string, error) {
+		// --purge skips the snapshot snapd otherwise keeps of the snap's data
+		command := fmt.Sprintf("sudo snap remove %s", common.ShellQuote(pkg.Name))
+		if pkg.Purge {
+			command = fmt.Sprintf("sudo snap remove --purge %s", common.ShellQuote(pkg.Name))
+		}
+		runtimeCtx.Logger.Command(command)
+		return m.exec(ctx, command)
+	})
+	return err
+}
+
+// DoesExist reports whether every snap is installed and follows its
+// requested channel
+func (m *SnapManager) DoesExist(ctx context.Context, runtimeCtx *inventory.Context, packages []common.Package) (bool, error) {
+	runtimeCtx.Logger.Info("Checking if snaps exist...")
+	for _, pkg := range packages {
+		info, err := m.info(ctx, runtimeCtx, pkg.Name)
+		if err != nil {
+			return false, fmt.Errorf("failed to check if %s exists: %w", pkg.Name, err)
+		}
+		switch want := normalizeSnapChannel(pkg.Channel); {
+		case info == nil:
+			runtimeCtx.Logger.Info(fmt.Sprintf("Snap %s is not installed", pkg.Name))
+			return false, nil
+		case want != "" && info.Tracking != want:
+			runtimeCtx.Logger.Info(fmt.Sprintf("Snap %s follows %s, %s requested", pkg.Name, info.Tracking, want))
+			return false, nil
+		}
+		runtimeCtx.Logger.Success(fmt.Sprintf("Snap %s %s exists", pkg.Name, info.Version))
+	}
+	return true, nil
+}
+
+// GetVersion returns the installed version of the snap, or "" when it is
+// not installed
+func (m *SnapManager) GetVersion(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) (string, error) {
+	info, err := m.info(ctx, runtimeCtx, pkg.Name)
+	if err != nil || info == nil {
+		return "", err
+	}
+	return info.Version, nil
+}
+
+// VersionSatisfies compares versions exactly; snaps are pinned by channel
+// rather than version
+func (m *SnapManager) VersionSatisfies(installed, requested string) bool {
+	if requested == "" || requested == "latest" {
+		return installed != ""
+	}
+	return installed == requested
+}
+
+// SetHold holds or releases automatic refreshes of the snap
+func (m *SnapManager) SetHold(ctx context.Context, runtimeCtx *inventory.Context, pkg common.Package) error {
+	info, err := m.info(ctx, runtimeCtx, pkg.Name)
+	if err != nil {
+		return err
+	}
+	if info == nil || info.Held == pkg.Hold {
+		return nil
+	}
+
+	flag := "--unhold"
+	if pkg.Hold {
+		flag = "--hold"
+	}
+	command := fmt.Sprintf("sudo snap refresh %s %s", flag, common.ShellQuote(pkg.Name))
+	runtimeCtx.Logger.Command(command)
+	if out, err := m.exec(ctx, command); err != nil {
+		if out != "" {
+			runtimeCtx.Logger.ErrorOutput(out)
+		}
+		return fmt.Errorf("failed to change hold of %s: %w", pkg.Name, err)
+	}
+	return nil
+}
+
+// info returns the `snap list` row of name, or nil when it is not installed
+func (m *SnapManager) info(ctx context.Context, runtimeCtx *inventory.Context, name string) (*snapInfo, error) {
+	command := fmt.Sprintf("snap list %s", common.ShellQuote(name))
+	runtimeCtx.Logger.Command(command)
+	run, err := m.Transport.Exec(ctx, command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", name, err)
+	}
+	if !run.Success() {
+		// snap list fails with "no matching snaps installed"
+		if strings.Contains(run.Stderr, "no matching snaps") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query %s: %w", name, run.Err())
+	}
+
+	// Name  Version  Rev  Tracking  Publisher  Notes
+	for _, line := range strings.Split(run.Stdout, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != name {
+			continue
+		}
+		info := &snapInfo{Version: fields[1], Tracking: fields[3]}
+		if len(fields) >= 6 {
+			for _, note := range strings.Split(fields[5], ",") {
+				info.Held = info.Held || note == "held"
+			}
+		}
+		return info, nil
+	}
+	return nil, nil
+}
+
+// exec runs command and returns its output, with an error for a nonzero
+// exit code
+func (m *SnapManager) exec(ctx context.Context, command string) (string, error) {
+	run, err := m.Transport.Exec(ctx, command)
+	if err != nil {
+		return "", err
+	}
+	return run.Output(), run.Err()
+}
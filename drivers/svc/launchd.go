@@ -0,0 +1,104 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// launchdDaemonDir holds the property lists of system daemons
+const launchdDaemonDir = "/Library/LaunchDaemons"
+
+// LaunchdManager manages system daemons on macOS with launchctl. The
+// service name is the daemon's label, e.g. homebrew.mxcl.nginx, and its
+// property list is /Library/LaunchDaemons/<label>.plist.
+type LaunchdManager struct {
+	commands
+}
+
+func init() {
+	Register(common.ServiceManagerLaunchd, func(transport inventory.Transport) ServiceManager {
+		return &LaunchdManager{commands{Transport: transport}}
+	})
+}
+
+// plist returns the path of the property list of name, quoted for the shell
+func (m *LaunchdManager) plist(name string) string {
+	return common.ShellQuote(fmt.Sprintf("%s/%s.plist", launchdDaemonDir, name))
+}
+
+func (m *LaunchdManager) Status(ctx context.Context, runtimeCtx *inventory.Context, name string) (Status, error) {
+	plist, err := m.query(ctx, runtimeCtx, "test -f "+m.plist(name))
+	if err != nil {
+		return Status{}, err
+	}
+	// print fails for daemons that are not loaded
+	loaded, err := m.query(ctx, runtimeCtx, fmt.Sprintf("launchctl print system/%s", common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{
+		Exists:  plist.Success() || loaded.Success(),
+		Running: loaded.Success() && strings.Contains(loaded.Stdout, "state = running"),
+	}
+	if !plist.Success() {
+		return status, nil
+	}
+
+	// Lines look like "com.example.agent" => disabled; before macOS 11
+	// the value was true for disabled daemons
+	overrides, err := m.query(ctx, runtimeCtx, "launchctl print-disabled system")
+	if err != nil {
+		return Status{}, err
+	}
+	status.Enabled = true
+	for _, line := range strings.Split(overrides.Stdout, "\n") {
+		label, value, ok := strings.Cut(line, "=>")
+		if !ok || strings.Trim(strings.TrimSpace(label), `"`) != name {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		status.Enabled = value != "disabled" && value != "true"
+	}
+	return status, nil
+}
+
+// Start loads the daemon when it is not loaded yet, then starts it
+func (m *LaunchdManager) Start(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	loaded, err := m.query(ctx, runtimeCtx, fmt.Sprintf("launchctl print system/%s", common.ShellQuote(name)))
+	if err != nil {
+		return err
+	}
+	if !loaded.Success() {
+		if err := m.run(ctx, runtimeCtx, "sudo launchctl bootstrap system "+m.plist(name)); err != nil {
+			return err
+		}
+	}
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl kickstart system/%s", common.ShellQuote(name)))
+}
+
+// Stop unloads the daemon, as launchd restarts killed daemons that ask to
+// be kept alive
+func (m *LaunchdManager) Stop(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl bootout system/%s", common.ShellQuote(name)))
+}
+
+func (m *LaunchdManager) Restart(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl kickstart -k system/%s", common.ShellQuote(name)))
+}
+
+// Reload sends the daemon a HUP signal
+func (m *LaunchdManager) Reload(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl kill SIGHUP system/%s", common.ShellQuote(name)))
+}
+
+func (m *LaunchdManager) Enable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl enable system/%s", common.ShellQuote(name)))
+}
+
+func (m *LaunchdManager) Disable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo launchctl disable system/%s", common.ShellQuote(name)))
+}
@@ -0,0 +1,78 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Status is the state of a service on a host
+type Status struct {
+	Exists  bool // the service is installed
+	Running bool
+	Enabled bool // the service starts at boot
+}
+
+// ServiceManager starts, stops and enables services with the init system of
+// a host
+type ServiceManager interface {
+	Status(ctx context.Context, runtimeCtx *inventory.Context, name string) (Status, error)
+	Start(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+	Stop(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+	Restart(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+	Reload(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+	Enable(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+	Disable(ctx context.Context, runtimeCtx *inventory.Context, name string) error
+}
+
+// detectCommand prints the init system of a host: systemd when it booted
+// with systemd, launchd on macOS, then OpenRC or runit by their tools
+const detectCommand = "if [ -d /run/systemd/system ]; then echo systemd; " +
+	"elif command -v launchctl >/dev/null 2>&1; then echo launchd; " +
+	"elif command -v rc-service >/dev/null 2>&1; then echo openrc; " +
+	"elif command -v sv >/dev/null 2>&1; then echo runit; fi"
+
+// Detect returns the init system of the host behind transport
+func Detect(ctx context.Context, runtimeCtx *inventory.Context, transport inventory.Transport) (string, error) {
+	runtimeCtx.Logger.Command(detectCommand)
+	result, err := transport.Exec(ctx, detectCommand)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect the init system: %w", err)
+	}
+	if err := result.Err(); err != nil {
+		return "", fmt.Errorf("failed to detect the init system: %w", err)
+	}
+	manager := strings.TrimSpace(result.Stdout)
+	if manager == "" {
+		return "", fmt.Errorf("no supported init system found on host %s (systemd, openrc, runit or launchd)", runtimeCtx.Host.Name)
+	}
+	return manager, nil
+}
+
+// commands runs the commands of a driver on its host
+type commands struct {
+	Transport inventory.Transport
+}
+
+// run runs command, logging its output when it fails
+func (c commands) run(ctx context.Context, runtimeCtx *inventory.Context, command string) error {
+	runtimeCtx.Logger.Command(command)
+	result, err := c.Transport.Exec(ctx, command)
+	if err != nil {
+		return err
+	}
+	if !result.Success() && result.Output() != "" {
+		runtimeCtx.Logger.ErrorOutput(result.Output())
+	}
+	return result.Err()
+}
+
+// query runs command and returns its result; a nonzero exit code is not an
+// error, as status commands report with it
+func (c commands) query(ctx context.Context, runtimeCtx *inventory.Context, command string) (*common.CommandResult, error) {
+	runtimeCtx.Logger.Command(command)
+	return c.Transport.Exec(ctx, command)
+}
@@ -0,0 +1,82 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// openRCRunlevel is the runlevel services are enabled in
+const openRCRunlevel = "default"
+
+// OpenRCManager manages init scripts with rc-service and rc-update, as on
+// Alpine
+type OpenRCManager struct {
+	commands
+}
+
+func init() {
+	Register(common.ServiceManagerOpenRC, func(transport inventory.Transport) ServiceManager {
+		return &OpenRCManager{commands{Transport: transport}}
+	})
+}
+
+func (m *OpenRCManager) Status(ctx context.Context, runtimeCtx *inventory.Context, name string) (Status, error) {
+	exists, err := m.query(ctx, runtimeCtx, fmt.Sprintf("rc-service --exists %s", common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	if !exists.Success() {
+		return Status{}, nil
+	}
+
+	// rc-service status exits with 0 for started services and 3 for
+	// stopped ones; crashed services report other codes
+	running, err := m.query(ctx, runtimeCtx, fmt.Sprintf("rc-service %s status", common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+
+	runlevel, err := m.query(ctx, runtimeCtx, "rc-update show "+openRCRunlevel)
+	if err != nil {
+		return Status{}, err
+	}
+	if err := runlevel.Err(); err != nil {
+		return Status{}, fmt.Errorf("failed to list the %s runlevel: %w", openRCRunlevel, err)
+	}
+	// Lines look like "  sshd | default"
+	enabled := false
+	for _, line := range strings.Split(runlevel.Stdout, "\n") {
+		service, _, _ := strings.Cut(line, "|")
+		enabled = enabled || strings.TrimSpace(service) == name
+	}
+
+	return Status{Exists: true, Running: running.Success(), Enabled: enabled}, nil
+}
+
+func (m *OpenRCManager) Start(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-service %s start", common.ShellQuote(name)))
+}
+
+func (m *OpenRCManager) Stop(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-service %s stop", common.ShellQuote(name)))
+}
+
+func (m *OpenRCManager) Restart(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-service %s restart", common.ShellQuote(name)))
+}
+
+func (m *OpenRCManager) Reload(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-service %s reload", common.ShellQuote(name)))
+}
+
+func (m *OpenRCManager) Enable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-update add %s %s", common.ShellQuote(name), openRCRunlevel))
+}
+
+func (m *OpenRCManager) Disable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rc-update del %s %s", common.ShellQuote(name), openRCRunlevel))
+}
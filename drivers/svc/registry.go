@@ -0,0 +1,44 @@
+package svc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Factory creates an init system driver running its commands over
+// transport
+type Factory func(transport inventory.Transport) ServiceManager
+
+var factories = make(map[string]Factory)
+
+// Register makes the driver of the init system name available to service
+// resources. Drivers register themselves in init functions; registering a
+// name twice panics.
+func Register(name string, factory Factory) {
+	if _, ok := factories[name]; ok {
+		panic("svc: service manager " + name + " registered twice")
+	}
+	factories[name] = factory
+}
+
+// NewManager returns the driver of the init system named manager, running
+// its commands over transport
+func NewManager(manager string, transport inventory.Transport) (ServiceManager, error) {
+	factory, ok := factories[manager]
+	if !ok {
+		return nil, fmt.Errorf("unsupported service manager: %s", manager)
+	}
+	return factory(transport), nil
+}
+
+// Managers returns the names of the registered init systems, sorted
+func Managers() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
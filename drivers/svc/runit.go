@@ -0,0 +1,83 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// Where runit keeps service definitions and the services it supervises, as
+// on Void
+const (
+	runitServiceDir = "/etc/sv"
+	runitEnabledDir = "/var/service"
+)
+
+// RunitManager manages services with sv. A service is enabled by linking it
+// into the supervised directory, which also starts it, so only enabled
+// services can run.
+type RunitManager struct {
+	commands
+}
+
+func init() {
+	Register(common.ServiceManagerRunit, func(transport inventory.Transport) ServiceManager {
+		return &RunitManager{commands{Transport: transport}}
+	})
+}
+
+func (m *RunitManager) Status(ctx context.Context, runtimeCtx *inventory.Context, name string) (Status, error) {
+	exists, err := m.query(ctx, runtimeCtx, fmt.Sprintf("test -d %s/%s", runitServiceDir, common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	if !exists.Success() {
+		return Status{}, nil
+	}
+
+	enabled, err := m.query(ctx, runtimeCtx, fmt.Sprintf("test -L %s/%s", runitEnabledDir, common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	if !enabled.Success() {
+		return Status{Exists: true}, nil
+	}
+
+	// run: sshd: (pid 123) 45s, or down: sshd: 3s
+	status, err := m.query(ctx, runtimeCtx, fmt.Sprintf("sudo sv status %s", common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	if err := status.Err(); err != nil {
+		return Status{}, fmt.Errorf("failed to query %s: %w", name, err)
+	}
+	return Status{Exists: true, Enabled: true, Running: strings.HasPrefix(status.Stdout, "run:")}, nil
+}
+
+func (m *RunitManager) Start(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo sv up %s", common.ShellQuote(name)))
+}
+
+func (m *RunitManager) Stop(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo sv down %s", common.ShellQuote(name)))
+}
+
+func (m *RunitManager) Restart(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo sv restart %s", common.ShellQuote(name)))
+}
+
+// Reload sends the service a HUP signal
+func (m *RunitManager) Reload(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo sv hup %s", common.ShellQuote(name)))
+}
+
+func (m *RunitManager) Enable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo ln -s %s/%s %s/", runitServiceDir, common.ShellQuote(name), runitEnabledDir))
+}
+
+func (m *RunitManager) Disable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo rm %s/%s", runitEnabledDir, common.ShellQuote(name)))
+}
@@ -0,0 +1,81 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+)
+
+// SystemdUnitDir holds unit files written by the administrator
+const SystemdUnitDir = "/etc/systemd/system"
+
+// SystemdManager manages units with systemctl
+type SystemdManager struct {
+	commands
+}
+
+// NewSystemdManager returns the systemd driver, running its commands over
+// transport
+func NewSystemdManager(transport inventory.Transport) *SystemdManager {
+	return &SystemdManager{commands{Transport: transport}}
+}
+
+func init() {
+	Register(common.ServiceManagerSystemd, func(transport inventory.Transport) ServiceManager {
+		return NewSystemdManager(transport)
+	})
+}
+
+func (m *SystemdManager) Status(ctx context.Context, runtimeCtx *inventory.Context, name string) (Status, error) {
+	result, err := m.query(ctx, runtimeCtx, fmt.Sprintf("systemctl show -p LoadState -p ActiveState -p UnitFileState %s", common.ShellQuote(name)))
+	if err != nil {
+		return Status{}, err
+	}
+	if err := result.Err(); err != nil {
+		return Status{}, fmt.Errorf("failed to query %s: %w", name, err)
+	}
+
+	properties := make(map[string]string)
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			properties[key] = value
+		}
+	}
+	return Status{
+		Exists:  properties["LoadState"] != "" && properties["LoadState"] != "not-found",
+		Running: properties["ActiveState"] == "active" || properties["ActiveState"] == "reloading",
+		Enabled: properties["UnitFileState"] == "enabled",
+	}, nil
+}
+
+func (m *SystemdManager) Start(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl start %s", common.ShellQuote(name)))
+}
+
+func (m *SystemdManager) Stop(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl stop %s", common.ShellQuote(name)))
+}
+
+func (m *SystemdManager) Restart(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl restart %s", common.ShellQuote(name)))
+}
+
+func (m *SystemdManager) Reload(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl reload %s", common.ShellQuote(name)))
+}
+
+func (m *SystemdManager) Enable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl enable %s", common.ShellQuote(name)))
+}
+
+func (m *SystemdManager) Disable(ctx context.Context, runtimeCtx *inventory.Context, name string) error {
+	return m.run(ctx, runtimeCtx, fmt.Sprintf("sudo systemctl disable %s", common.ShellQuote(name)))
+}
+
+// DaemonReload makes systemd re-read its unit files
+func (m *SystemdManager) DaemonReload(ctx context.Context, runtimeCtx *inventory.Context) error {
+	return m.run(ctx, runtimeCtx, "sudo systemctl daemon-reload")
+}
@@ -0,0 +1,32 @@
+package inventory
+
+// Color is an ANSI escape sequence setting the color or weight of text
+type Color string
+
+// Colors of the plan renderer
+const (
+	ColorGreen  Color = "\x1b[32m"
+	ColorYellow Color = "\x1b[33m"
+	ColorRed    Color = "\x1b[31m"
+	ColorBold   Color = "\x1b[1m"
+	ColorDim    Color = "\x1b[2m"
+
+	colorReset = "\x1b[0m"
+)
+
+// colorOutput enables ANSI colors, which plain and JSON output never use
+var colorOutput bool
+
+// SetColor enables colored output, meant for terminals
+func SetColor(enabled bool) {
+	colorOutput = enabled
+}
+
+// Colorize returns text in color c when colored output is enabled, and text
+// itself otherwise
+func Colorize(c Color, text string) string {
+	if !colorOutput || plainOutput || jsonOutput || text == "" {
+		return text
+	}
+	return string(c) + text + colorReset
+}
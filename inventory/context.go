@@ -1,33 +1,54 @@
 package inventory
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/settlectl/settle-core/common"
-	"github.com/settlectl/settle-core/inventory/ssh"
 )
 
 type Context struct {
-	Host      *common.Host
-	SSHClient *ssh.SSHClient
+	Host *common.Host
+	// Transport reaches Host; nil until a resource connects
+	Transport Transport
 	Logger    *Logger
+	// Ctx bounds remote commands; cancelling it aborts in-flight sessions
+	Ctx context.Context
+	// Workspace is the run's remote temp directory; nil outside of a run
+	Workspace *Workspace
+	// Connections shares SSH connections between the resources of a run;
+	// when nil, Connect opens a connection of its own
+	Connections *ConnectionPool
 }
 
 func NewContext(host *common.Host) *Context {
 	return &Context{
 		Host:      host,
-		SSHClient: nil,
+		Transport: nil,
 		Logger:    NewLogger(),
 	}
 }
 
-// CreateSSHClient creates an SSH client for the given host
-func (c *Context) CreateSSHClient(host *common.Host) (*ssh.SSHClient, error) {
-	sshClient, err := ssh.NewSSHClient(host)
+// Connect opens the transport of the given host, over the run's shared
+// connection when the context has a connection pool
+func (c *Context) Connect(host *common.Host) (Transport, error) {
+	connect := Connect
+	if c.Connections != nil {
+		connect = c.Connections.Connect
+	}
+	transport, err := connect(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create SSH client: %w", err)
+		return nil, fmt.Errorf("failed to connect to %s: %w", host.Name, err)
+	}
+	return transport, nil
+}
+
+// Context returns the context bounding remote commands, never nil
+func (c *Context) Context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
 	}
-	return sshClient, nil
+	return c.Ctx
 }
 
 // SetHost sets the host for this context
@@ -35,7 +56,7 @@ func (c *Context) SetHost(host *common.Host) {
 	c.Host = host
 }
 
-// SetSSHClient sets the SSH client for this context
-func (c *Context) SetSSHClient(client *ssh.SSHClient) {
-	c.SSHClient = client
+// SetTransport sets the transport for this context
+func (c *Context) SetTransport(transport Transport) {
+	c.Transport = transport
 }
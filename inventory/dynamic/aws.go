@@ -0,0 +1,209 @@
+package dynamic
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+const ec2APIVersion = "2016-11-15"
+
+// awsProvider lists running EC2 instances through the EC2 Query API, signed
+// with Signature Version 4 using the standard AWS_* environment variables
+type awsProvider struct {
+	opts         Options
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	endpoint     string
+}
+
+func newAWSProvider(opts Options) (Provider, error) {
+	p := &awsProvider{
+		opts:         opts,
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if p.region == "" {
+		p.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if p.region == "" {
+		return nil, fmt.Errorf("aws inventory: AWS_REGION is not set")
+	}
+	if p.accessKey == "" || p.secretKey == "" {
+		return nil, fmt.Errorf("aws inventory: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+	p.endpoint = fmt.Sprintf("https://ec2.%s.amazonaws.com/", p.region)
+	return p, nil
+}
+
+func (p *awsProvider) Name() string { return "aws" }
+
+type ec2Response struct {
+	Reservations []struct {
+		Instances []struct {
+			InstanceID string `xml:"instanceId"`
+			PrivateIP  string `xml:"privateIpAddress"`
+			PublicIP   string `xml:"ipAddress"`
+			Tags       []struct {
+				Key   string `xml:"key"`
+				Value string `xml:"value"`
+			} `xml:"tagSet>item"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+func (p *awsProvider) Hosts(ctx context.Context) ([]common.Host, error) {
+	var instances []instance
+	nextToken := ""
+	for {
+		params := p.queryParams(nextToken)
+		page, err := p.describeInstances(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range page.Reservations {
+			for _, inst := range reservation.Instances {
+				labels := make(map[string]string)
+				for _, tag := range inst.Tags {
+					labels[tag.Key] = tag.Value
+				}
+				name := labels["Name"]
+				if name == "" {
+					name = inst.InstanceID
+				}
+				instances = append(instances, instance{
+					name:      name,
+					publicIP:  inst.PublicIP,
+					privateIP: inst.PrivateIP,
+					labels:    labels,
+				})
+			}
+		}
+
+		if page.NextToken == "" {
+			break
+		}
+		nextToken = page.NextToken
+	}
+	return toHosts(instances, p.opts), nil
+}
+
+// queryParams builds DescribeInstances parameters for running instances
+// matching every tag filter
+func (p *awsProvider) queryParams(nextToken string) url.Values {
+	params := url.Values{}
+	params.Set("Action", "DescribeInstances")
+	params.Set("Version", ec2APIVersion)
+	params.Set("Filter.1.Name", "instance-state-name")
+	params.Set("Filter.1.Value.1", "running")
+
+	keys := make([]string, 0, len(p.opts.Filters))
+	for key := range p.opts.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		params.Set(fmt.Sprintf("Filter.%d.Name", i+2), "tag:"+key)
+		params.Set(fmt.Sprintf("Filter.%d.Value.1", i+2), p.opts.Filters[key])
+	}
+	if nextToken != "" {
+		params.Set("NextToken", nextToken)
+	}
+	return params
+}
+
+func (p *awsProvider) describeInstances(ctx context.Context, params url.Values) (*ec2Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	p.sign(req, time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("aws inventory: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "EC2"); err != nil {
+		return nil, fmt.Errorf("aws inventory: %w", err)
+	}
+
+	var page ec2Response
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("aws inventory: invalid DescribeInstances response: %w", err)
+	}
+	return &page, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to a GET request
+func (p *awsProvider) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if p.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.sessionToken)
+	}
+
+	signedHeaders := "host;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-date:%s\n", req.URL.Host, amzDate)
+	if p.sessionToken != "" {
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += fmt.Sprintf("x-amz-security-token:%s\n", p.sessionToken)
+	}
+
+	payloadHash := sha256Hex(nil)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/ec2/aws4_request", date, p.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+p.secretKey), date)
+	key = hmacSHA256(key, p.region)
+	key = hmacSHA256(key, "ec2")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by key, with spaces as %20
+func canonicalQuery(values url.Values) string {
+	return strings.ReplaceAll(values.Encode(), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,80 @@
+package dynamic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// etcHostsProvider turns the names in /etc/hosts into hosts, skipping
+// loopback and other non-routable addresses
+type etcHostsProvider struct {
+	opts Options
+	path string
+}
+
+func newEtcHostsProvider(opts Options) (Provider, error) {
+	if len(opts.Filters) > 0 {
+		return nil, fmt.Errorf("etc-hosts inventory does not support filters; use patterns")
+	}
+	return &etcHostsProvider{opts: opts, path: "/etc/hosts"}, nil
+}
+
+func (p *etcHostsProvider) Name() string { return "etc-hosts" }
+
+func (p *etcHostsProvider) Hosts(ctx context.Context) ([]common.Host, error) {
+	file, err := os.Open(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("etc-hosts inventory: %w", err)
+	}
+	defer file.Close()
+
+	seen := make(map[string]bool)
+	var hosts []common.Host
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+
+		// Only the canonical name; aliases would add the same machine twice
+		name := fields[1]
+		if seen[name] || !matchesAny(name, p.opts.Patterns) || isSpecialHostName(name) {
+			continue
+		}
+		seen[name] = true
+
+		hosts = append(hosts, common.Host{
+			Name:     name,
+			Hostname: ip.String(),
+			User:     localUser(p.opts.User),
+			Port:     p.opts.Port,
+			Keyfile:  p.opts.Keyfile,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("etc-hosts inventory: %w", err)
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
+
+// isSpecialHostName reports names distributions add for IPv6 multicast and
+// the like, e.g. ip6-allnodes
+func isSpecialHostName(name string) bool {
+	return name == "localhost" || strings.HasPrefix(name, "ip6-")
+}
@@ -0,0 +1,173 @@
+package dynamic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// gcpProvider lists running Compute Engine instances of a project across all
+// zones. It authenticates with GOOGLE_OAUTH_ACCESS_TOKEN (e.g. from
+// `gcloud auth print-access-token`) or the metadata server's service account.
+type gcpProvider struct {
+	opts    Options
+	project string
+}
+
+func newGCPProvider(opts Options) (Provider, error) {
+	project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+	if project == "" {
+		project = os.Getenv("GCP_PROJECT")
+	}
+	if project == "" {
+		return nil, fmt.Errorf("gcp inventory: GOOGLE_CLOUD_PROJECT is not set")
+	}
+	return &gcpProvider{opts: opts, project: project}, nil
+}
+
+func (p *gcpProvider) Name() string { return "gcp" }
+
+type gcpInstance struct {
+	Name              string            `json:"name"`
+	Status            string            `json:"status"`
+	Labels            map[string]string `json:"labels"`
+	NetworkInterfaces []struct {
+		NetworkIP     string `json:"networkIP"`
+		AccessConfigs []struct {
+			NatIP string `json:"natIP"`
+		} `json:"accessConfigs"`
+	} `json:"networkInterfaces"`
+}
+
+type gcpAggregatedList struct {
+	Items map[string]struct {
+		Instances []gcpInstance `json:"instances"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (p *gcpProvider) Hosts(ctx context.Context) ([]common.Host, error) {
+	token, err := p.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []instance
+	pageToken := ""
+	for {
+		page, err := p.list(ctx, token, pageToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, zone := range page.Items {
+			for _, inst := range zone.Instances {
+				if inst.Status != "RUNNING" {
+					continue
+				}
+				mapped := instance{name: inst.Name, labels: inst.Labels}
+				if len(inst.NetworkInterfaces) > 0 {
+					nic := inst.NetworkInterfaces[0]
+					mapped.privateIP = nic.NetworkIP
+					if len(nic.AccessConfigs) > 0 {
+						mapped.publicIP = nic.AccessConfigs[0].NatIP
+					}
+				}
+				instances = append(instances, mapped)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return toHosts(instances, p.opts), nil
+}
+
+func (p *gcpProvider) list(ctx context.Context, token, pageToken string) (*gcpAggregatedList, error) {
+	params := url.Values{}
+	if filter := p.filter(); filter != "" {
+		params.Set("filter", filter)
+	}
+	if pageToken != "" {
+		params.Set("pageToken", pageToken)
+	}
+	endpoint := fmt.Sprintf("https://compute.googleapis.com/compute/v1/projects/%s/aggregated/instances?%s",
+		url.PathEscape(p.project), params.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("gcp inventory: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Compute Engine"); err != nil {
+		return nil, fmt.Errorf("gcp inventory: %w", err)
+	}
+
+	var page gcpAggregatedList
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("gcp inventory: invalid instances response: %w", err)
+	}
+	return &page, nil
+}
+
+// filter builds a Compute Engine filter expression matching every label
+func (p *gcpProvider) filter() string {
+	keys := make([]string, 0, len(p.opts.Filters))
+	for key := range p.opts.Filters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var terms []string
+	for _, key := range keys {
+		terms = append(terms, fmt.Sprintf("(labels.%s = %q)", key, p.opts.Filters[key]))
+	}
+	return strings.Join(terms, " AND ")
+}
+
+// token returns an OAuth access token for the Compute Engine API
+func (p *gcpProvider) token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcp inventory: GOOGLE_OAUTH_ACCESS_TOKEN is not set and the metadata server is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "metadata server"); err != nil {
+		return "", fmt.Errorf("gcp inventory: %w", err)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("gcp inventory: invalid token response: %w", err)
+	}
+	return body.AccessToken, nil
+}
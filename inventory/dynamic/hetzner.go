@@ -0,0 +1,121 @@
+package dynamic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// hetznerProvider lists running Hetzner Cloud servers using HCLOUD_TOKEN
+type hetznerProvider struct {
+	opts  Options
+	token string
+}
+
+func newHetznerProvider(opts Options) (Provider, error) {
+	token := os.Getenv("HCLOUD_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("hetzner inventory: HCLOUD_TOKEN is not set")
+	}
+	if opts.User == "" {
+		opts.User = "root" // Hetzner images only provision root
+	}
+	return &hetznerProvider{opts: opts, token: token}, nil
+}
+
+func (p *hetznerProvider) Name() string { return "hetzner" }
+
+type hetznerServers struct {
+	Servers []struct {
+		Name      string            `json:"name"`
+		Status    string            `json:"status"`
+		Labels    map[string]string `json:"labels"`
+		PublicNet struct {
+			IPv4 *struct {
+				IP string `json:"ip"`
+			} `json:"ipv4"`
+		} `json:"public_net"`
+		PrivateNet []struct {
+			IP string `json:"ip"`
+		} `json:"private_net"`
+	} `json:"servers"`
+	Meta struct {
+		Pagination struct {
+			NextPage int `json:"next_page"`
+		} `json:"pagination"`
+	} `json:"meta"`
+}
+
+func (p *hetznerProvider) Hosts(ctx context.Context) ([]common.Host, error) {
+	var instances []instance
+	for page := 1; page != 0; {
+		servers, err := p.list(ctx, page)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, server := range servers.Servers {
+			if server.Status != "running" {
+				continue
+			}
+			mapped := instance{name: server.Name, labels: server.Labels}
+			if server.PublicNet.IPv4 != nil {
+				mapped.publicIP = server.PublicNet.IPv4.IP
+			}
+			if len(server.PrivateNet) > 0 {
+				mapped.privateIP = server.PrivateNet[0].IP
+			}
+			instances = append(instances, mapped)
+		}
+
+		page = servers.Meta.Pagination.NextPage
+	}
+	return toHosts(instances, p.opts), nil
+}
+
+func (p *hetznerProvider) list(ctx context.Context, page int) (*hetznerServers, error) {
+	params := url.Values{}
+	params.Set("page", fmt.Sprint(page))
+	params.Set("per_page", "50")
+	if selector := p.labelSelector(); selector != "" {
+		params.Set("label_selector", selector)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.hetzner.cloud/v1/servers?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("hetzner inventory: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp, "Hetzner Cloud"); err != nil {
+		return nil, fmt.Errorf("hetzner inventory: %w", err)
+	}
+
+	var servers hetznerServers
+	if err := json.NewDecoder(resp.Body).Decode(&servers); err != nil {
+		return nil, fmt.Errorf("hetzner inventory: invalid servers response: %w", err)
+	}
+	return &servers, nil
+}
+
+// labelSelector builds a Hetzner label selector matching every filter
+func (p *hetznerProvider) labelSelector() string {
+	var terms []string
+	for key, value := range p.opts.Filters {
+		terms = append(terms, key+"="+value)
+	}
+	sort.Strings(terms)
+	return strings.Join(terms, ",")
+}
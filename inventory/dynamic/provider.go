@@ -0,0 +1,139 @@
+// Package dynamic enumerates hosts from cloud provider APIs or local SSH
+// configuration instead of, or in addition to, hosts.stl.
+package dynamic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	osuser "os/user"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// Tag or label keys read from instances
+const (
+	UserLabel  = "ssh-user"     // SSH user for the instance
+	GroupLabel = "settle-group" // host group for the instance
+)
+
+// Provider enumerates hosts from an inventory source
+type Provider interface {
+	Name() string
+	Hosts(ctx context.Context) ([]common.Host, error)
+}
+
+// Options select and map instances to hosts
+type Options struct {
+	Filters  map[string]string // tag or label filters, all must match
+	Patterns []string          // host name patterns such as web-*, any must match
+	Address  string            // "public" (default, falls back to private) or "private"
+	User     string            // SSH user when the instance has no ssh-user tag
+	Keyfile  string            // SSH key for every host
+	Port     int
+}
+
+// providers are the built-in inventory providers, by name
+var providers = map[string]func(Options) (Provider, error){
+	"aws":        newAWSProvider,
+	"gcp":        newGCPProvider,
+	"hetzner":    newHetznerProvider,
+	"ssh-config": newSSHConfigProvider,
+	"etc-hosts":  newEtcHostsProvider,
+}
+
+// New returns the named provider
+func New(name string, opts Options) (Provider, error) {
+	constructor, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown inventory provider %q (available: %v)", name, Names())
+	}
+	if opts.Address == "" {
+		opts.Address = "public"
+	}
+	if opts.Address != "public" && opts.Address != "private" {
+		return nil, fmt.Errorf("invalid inventory address %q (expected public or private)", opts.Address)
+	}
+	for _, pattern := range opts.Patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid inventory pattern %q: %w", pattern, err)
+		}
+	}
+	if opts.Port == 0 {
+		opts.Port = 22
+	}
+	return constructor(opts)
+}
+
+// Names returns the names of the built-in providers
+func Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// instance is the provider-independent view of a cloud machine
+type instance struct {
+	name      string
+	publicIP  string
+	privateIP string
+	labels    map[string]string
+}
+
+// toHosts maps instances to hosts, skipping instances without a usable address
+func toHosts(instances []instance, opts Options) []common.Host {
+	var hosts []common.Host
+	for _, inst := range instances {
+		address := inst.publicIP
+		if opts.Address == "private" || address == "" {
+			address = inst.privateIP
+		}
+		if address == "" || !matchesAny(inst.name, opts.Patterns) {
+			continue
+		}
+
+		user := opts.User
+		if value := inst.labels[UserLabel]; value != "" {
+			user = value
+		}
+
+		hosts = append(hosts, common.Host{
+			Name:     inst.name,
+			Hostname: address,
+			User:     user,
+			Port:     opts.Port,
+			Keyfile:  opts.Keyfile,
+			Group:    inst.labels[GroupLabel],
+		})
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts
+}
+
+// localUser is the SSH user for hosts that name none, as with ssh itself
+func localUser(user string) string {
+	if user != "" {
+		return user
+	}
+	if current, err := osuser.Current(); err == nil {
+		return current.Username
+	}
+	return ""
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// checkResponse turns a non-2xx API response into an error
+func checkResponse(resp *http.Response, api string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	return fmt.Errorf("%s API returned %s", api, resp.Status)
+}
@@ -0,0 +1,193 @@
+package dynamic
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// sshConfigProvider turns every concrete Host entry of ~/.ssh/config into a
+// host. Wildcard entries such as `Host *` only supply defaults.
+type sshConfigProvider struct {
+	opts Options
+	path string
+}
+
+func newSSHConfigProvider(opts Options) (Provider, error) {
+	if len(opts.Filters) > 0 {
+		return nil, fmt.Errorf("ssh-config inventory does not support filters; use patterns")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("ssh-config inventory: %w", err)
+	}
+	return &sshConfigProvider{opts: opts, path: filepath.Join(home, ".ssh", "config")}, nil
+}
+
+func (p *sshConfigProvider) Name() string { return "ssh-config" }
+
+// sshConfigBlock is one Host section with its options in file order
+type sshConfigBlock struct {
+	patterns []string
+	options  [][2]string
+}
+
+func (p *sshConfigProvider) Hosts(ctx context.Context) ([]common.Host, error) {
+	blocks, err := readSSHConfig(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("ssh-config inventory: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var hosts []common.Host
+	for _, block := range blocks {
+		for _, alias := range block.patterns {
+			if seen[alias] || strings.ContainsAny(alias, "*?!") || !matchesAny(alias, p.opts.Patterns) {
+				continue
+			}
+			seen[alias] = true
+
+			host, err := p.resolve(alias, blocks)
+			if err != nil {
+				return nil, fmt.Errorf("ssh-config inventory: host %s: %w", alias, err)
+			}
+			hosts = append(hosts, host)
+		}
+	}
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+	return hosts, nil
+}
+
+// resolve applies the options of every block matching alias; as in ssh, the
+// first value found for an option wins
+func (p *sshConfigProvider) resolve(alias string, blocks []sshConfigBlock) (common.Host, error) {
+	values := make(map[string]string)
+	for _, block := range blocks {
+		if !blockMatches(alias, block.patterns) {
+			continue
+		}
+		for _, option := range block.options {
+			if _, ok := values[option[0]]; !ok {
+				values[option[0]] = option[1]
+			}
+		}
+	}
+
+	host := common.Host{
+		Name:     alias,
+		Hostname: alias,
+		User:     localUser(p.opts.User),
+		Port:     p.opts.Port,
+		Keyfile:  p.opts.Keyfile,
+	}
+	if value := values["hostname"]; value != "" {
+		host.Hostname = value
+	}
+	if value := values["user"]; value != "" {
+		host.User = value
+	}
+	if value := values["port"]; value != "" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return host, fmt.Errorf("invalid port %q", value)
+		}
+		host.Port = port
+	}
+	if value := values["identityfile"]; value != "" && host.Keyfile == "" {
+		if strings.HasPrefix(value, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return host, err
+			}
+			value = filepath.Join(home, value[2:])
+		}
+		host.Keyfile = value
+	}
+	return host, nil
+}
+
+// readSSHConfig reads the Host sections of an ssh_config file. Options
+// before the first Host line apply to every host. Match sections are skipped.
+func readSSHConfig(configPath string) ([]sshConfigBlock, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	blocks := []sshConfigBlock{{patterns: []string{"*"}}}
+	current := &blocks[0]
+	skipping := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			key, value, ok = strings.Cut(line, "=")
+		}
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(value), "=")), `"`)
+
+		switch key {
+		case "host":
+			blocks = append(blocks, sshConfigBlock{patterns: strings.Fields(value)})
+			current = &blocks[len(blocks)-1]
+			skipping = false
+		case "match":
+			skipping = true
+		default:
+			if !skipping {
+				current.options = append(current.options, [2]string{key, value})
+			}
+		}
+	}
+	return blocks, scanner.Err()
+}
+
+// blockMatches reports whether alias matches a Host line's patterns: at least
+// one positive pattern and no negated one
+func blockMatches(alias string, patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if ok, _ := path.Match(negated, alias); ok {
+				return false
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, alias); ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchesAny reports whether name matches one of patterns, or patterns is empty
+func matchesAny(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// factsCommand prints the facts of a Linux or macOS host as key=value lines
+const factsCommand = `echo "kernel=$(uname -s)"; echo "kernel_version=$(uname -r)"; echo "arch=$(uname -m)"; ` +
+	`if [ -r /etc/os-release ]; then . /etc/os-release; echo "os=$ID"; echo "os_version=$VERSION_ID"; echo "os_family=$ID_LIKE"; fi; ` +
+	`if [ "$(uname -s)" = Darwin ]; then echo "os=macos"; echo "os_version=$(sw_vers -productVersion)"; echo "memory_kb=$(($(sysctl -n hw.memsize) / 1024))"; echo "cpus=$(sysctl -n hw.ncpu)"; ` +
+	`else echo "memory_kb=$(awk '/^MemTotal:/ { print $2 }' /proc/meminfo)"; echo "cpus=$(getconf _NPROCESSORS_ONLN)"; fi`
+
+// GatherFacts returns the facts of the host behind transport: kernel,
+// kernel_version, arch, os (e.g. ubuntu or macos), os_version, os_family,
+// cpus, memory_kb, memory_mb and memory_gb
+func GatherFacts(ctx context.Context, transport Transport) (map[string]string, error) {
+	result, err := transport.Exec(ctx, factsCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to gather facts: %w", err)
+	}
+	if !result.Success() {
+		return nil, fmt.Errorf("failed to gather facts: %w", result.Err())
+	}
+
+	facts := make(map[string]string)
+	for _, line := range strings.Split(result.Stdout, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if ok && value != "" {
+			facts[key] = value
+		}
+	}
+	// Rounded to a tenth, e.g. 3.8 on a host with 4 GB of which some is reserved
+	if kb, err := strconv.ParseInt(facts["memory_kb"], 10, 64); err == nil {
+		facts["memory_mb"] = strconv.FormatInt(kb/1024, 10)
+		facts["memory_gb"] = strconv.FormatFloat(math.Round(float64(kb)/(1<<20)*10)/10, 'f', -1, 64)
+	}
+	return facts, nil
+}
@@ -0,0 +1,118 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// DefaultLogFiles is how many rotated log files are kept by default
+const DefaultLogFiles = 5
+
+// ansiEscape matches the color sequences log files leave out
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// LogFile receives a copy of every logged line with its time. When it grows
+// past its maximum size, it is renamed to path.1, path.1 to path.2 and so
+// on, keeping the newest files.
+type LogFile struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64 // 0 for no rotation
+	maxFiles int   // rotated files kept besides the current one
+	file     *os.File
+	size     int64
+}
+
+var (
+	logFileMu sync.Mutex
+	logFile   *LogFile
+)
+
+// OpenLogFile opens path for appending, rotating it at maxSize bytes (0
+// never rotates) and keeping maxFiles rotated files
+func OpenLogFile(path string, maxSize int64, maxFiles int) (*LogFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	return &LogFile{path: path, maxSize: maxSize, maxFiles: maxFiles, file: file, size: info.Size()}, nil
+}
+
+// SetLogFile makes every Logger copy its output to file, or stops copying
+// when file is nil
+func SetLogFile(file *LogFile) {
+	logFileMu.Lock()
+	defer logFileMu.Unlock()
+	logFile = file
+}
+
+// teeLogFile copies line to the log file, if one is set
+func teeLogFile(line string) {
+	logFileMu.Lock()
+	file := logFile
+	logFileMu.Unlock()
+	if file != nil {
+		file.writeLine(line)
+	}
+}
+
+// writeLine appends line with a timestamp and the run ID, or as is when it is
+// JSON, which carries both. Errors are dropped: there is nowhere left to log them.
+func (f *LogFile) writeLine(line string) {
+	line = ansiEscape.ReplaceAllString(line, "")
+	if line == "" {
+		return
+	}
+	if !jsonOutput {
+		if runID != "" {
+			line = runID + " " + line
+		}
+		line = time.Now().Format(time.RFC3339) + " " + line
+	}
+	data := []byte(line + "\n")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		f.rotate()
+	}
+	n, _ := f.file.Write(data)
+	f.size += int64(n)
+}
+
+// rotate shifts the rotated files up by one, dropping the oldest, and
+// starts a new file
+func (f *LogFile) rotate() {
+	f.file.Close()
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if f.maxFiles > 0 {
+		os.Rename(f.path, f.path+".1")
+	} else {
+		os.Remove(f.path)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		// Keep appending to the renamed file rather than losing lines
+		file, _ = os.OpenFile(f.path+".1", os.O_WRONLY|os.O_APPEND, 0600)
+	}
+	f.file = file
+	f.size = 0
+}
+
+// Close closes the file
+func (f *LogFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -1,12 +1,31 @@
 package inventory
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 	"strings"
 	"time"
 )
 
+// jsonOutput makes loggers write every line as a JSON object
+var jsonOutput bool
+
+// SetJSON makes loggers write each line as a JSON object with its time,
+// level, run ID, host and message, for log collectors
+func SetJSON(enabled bool) {
+	jsonOutput = enabled
+}
+
+// logEntry is a line of JSON output
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level,omitempty"`
+	RunID   string    `json:"run_id,omitempty"`
+	Host    string    `json:"host,omitempty"`
+	Message string    `json:"message"`
+}
+
 type Logger struct {
 	*log.Logger
 	indentLevel int
@@ -24,54 +43,131 @@ func (l *Logger) SetHost(hostName string) {
 	l.hostName = hostName
 }
 
+// printJSON prints message as a logEntry with registered secrets redacted;
+// blank lines are left out
+func (l *Logger) printJSON(level, message string) {
+	message = strings.TrimSpace(Redact(message))
+	if message == "" {
+		return
+	}
+	data, err := json.Marshal(logEntry{Time: time.Now().UTC(), Level: level, RunID: runID, Host: l.hostName, Message: message})
+	if err != nil {
+		return
+	}
+	l.Logger.Print(string(data))
+	teeLogFile(string(data))
+}
+
 func (l *Logger) indent() string {
 	return strings.Repeat("  ", l.indentLevel)
 }
 
+// rule prints a horizontal rule, which plain and JSON output leave out
+func (l *Logger) rule(char string) {
+	if !plainOutput && !jsonOutput {
+		l.Printf("%s", strings.Repeat(char, 80))
+	}
+}
+
+// heading prints a heading preceded by a blank line and a rule
+func (l *Logger) heading(char, text string) {
+	if plainOutput {
+		l.Printf("\n%s", text)
+		return
+	}
+	l.Printf("\n%s", strings.Repeat(char, 80))
+	l.Printf("%s", text)
+}
+
+// decorate prefixes message with an emoji unless output is plain or JSON
+func decorate(emoji, message string) string {
+	if plainOutput || jsonOutput {
+		return message
+	}
+	return emoji + " " + message
+}
+
+// level prints a message with its level, e.g. "[INFO] ..." or "Info: ..."
+// in plain output, where an empty message prints an empty line
+func (l *Logger) level(id MessageID, message string) {
+	if jsonOutput {
+		l.printJSON(strings.TrimPrefix(string(id), "level."), message)
+		return
+	}
+	if plainOutput {
+		if message == "" {
+			l.Printf("")
+			return
+		}
+		l.Printf("%s%s: %s", l.indent(), Message(id), message)
+		return
+	}
+	l.Printf("%s[%s] %s", l.indent(), Message(id), message)
+}
+
 func (l *Logger) Task(taskName string) {
-	l.Printf("\n%s", strings.Repeat("=", 80))
-	l.Printf("TASK [%s]", taskName)
-	l.Printf("%s", strings.Repeat("=", 80))
+	l.heading("=", Message(MsgTask, taskName))
+	l.rule("=")
 }
 
 func (l *Logger) HostTask(hostName, taskName string) {
-	l.Printf("\n%s", strings.Repeat("-", 80))
-	l.Printf("TASK [%s] ***********************************************************", taskName)
-	l.Printf("host: %s", hostName)
-	l.Printf("%s", strings.Repeat("-", 80))
+	if plainOutput {
+		l.heading("-", Message(MsgTask, taskName))
+	} else {
+		l.heading("-", Message(MsgTask, taskName)+" ***********************************************************")
+	}
+	l.Printf("%s", Message(MsgTaskHost, hostName))
+	l.rule("-")
 }
 
 func (l *Logger) HostSection(hostName string) {
-	l.Printf("\n%s", strings.Repeat("=", 80))
-	l.Printf("HOST: %s", hostName)
-	l.Printf("%s", strings.Repeat("=", 80))
+	l.heading("=", Message(MsgHost, hostName))
+	l.rule("=")
 }
 
 func (l *Logger) Info(message string) {
-	l.Printf("%s[INFO] %s", l.indent(), message)
+	l.level(MsgLevelInfo, message)
 }
 
 func (l *Logger) Success(message string) {
-	l.Printf("%s[SUCCESS] %s", l.indent(), message)
+	l.level(MsgLevelSuccess, message)
 }
 
 func (l *Logger) Error(message string) {
-	l.Printf("%s[ERROR] %s", l.indent(), message)
+	l.level(MsgLevelError, message)
 }
 
 func (l *Logger) Warning(message string) {
-	l.Printf("%s[WARNING] %s", l.indent(), message)
+	l.level(MsgLevelWarning, message)
 }
 
 func (l *Logger) Debug(message string) {
-	l.Printf("%s[DEBUG] %s", l.indent(), message)
+	l.level(MsgLevelDebug, message)
 }
 
+// Command logs a command about to run, from VerbosityCommands on
 func (l *Logger) Command(command string) {
+	if verbosity < VerbosityCommands {
+		return
+	}
 	l.Printf("%s$ %s", l.indent(), command)
 }
 
+// CommandOutput logs the output of a command that succeeded, from
+// VerbosityOutput on
 func (l *Logger) CommandOutput(output string) {
+	if verbosity < VerbosityOutput {
+		return
+	}
+	l.output(output)
+}
+
+// ErrorOutput logs the output of a command that failed, at every verbosity
+func (l *Logger) ErrorOutput(output string) {
+	l.output(output)
+}
+
+func (l *Logger) output(output string) {
 	lines := strings.Split(strings.TrimSpace(output), "\n")
 	for _, line := range lines {
 		if strings.TrimSpace(line) != "" {
@@ -81,44 +177,43 @@ func (l *Logger) CommandOutput(output string) {
 }
 
 func (l *Logger) PackageInstall(pkgName, manager string) {
-	l.Printf("%s📦 Installing %s via %s...", l.indent(), pkgName, manager)
+	l.Printf("%s%s", l.indent(), decorate("📦", Message(MsgPackageInstall, pkgName, manager)))
 }
 
 func (l *Logger) PackageRemove(pkgName, manager string) {
-	l.Printf("%s📦 Removing %s via %s...", l.indent(), pkgName, manager)
+	l.Printf("%s%s", l.indent(), decorate("📦", Message(MsgPackageRemove, pkgName, manager)))
 }
 
 func (l *Logger) PackageExists(pkgName, manager string) {
-	l.Printf("%s📦 %s via %s already exists", l.indent(), pkgName, manager)
+	l.Printf("%s%s", l.indent(), decorate("📦", Message(MsgPackageExists, pkgName, manager)))
 }
 
 func (l *Logger) PackageSuccess(pkgName string, duration time.Duration) {
-	l.Printf("%s✅ Successfully installed %s in %v", l.indent(), pkgName, duration)
+	l.Printf("%s%s", l.indent(), decorate("✅", Message(MsgPackageSuccess, pkgName, duration)))
 }
 
 func (l *Logger) PackageError(pkgName string, err error) {
-	l.Printf("%s❌ Failed to install %s: %v", l.indent(), pkgName, err)
+	l.Printf("%s%s", l.indent(), decorate("❌", Message(MsgPackageError, pkgName, err)))
 }
 
 func (l *Logger) SSHConnection(host, user, port string) {
-	l.Printf("%s🔌 Connecting to %s@%s:%s...", l.indent(), user, host, port)
+	l.Printf("%s%s", l.indent(), decorate("🔌", Message(MsgSSHConnecting, user, host, port)))
 }
 
 func (l *Logger) SSHSuccess() {
-	l.Printf("%s✅ SSH connection established", l.indent())
+	l.Printf("%s%s", l.indent(), decorate("✅", Message(MsgSSHSuccess)))
 }
 
 func (l *Logger) SSHError(err error) {
-	l.Printf("%s❌ SSH connection failed: %v", l.indent(), err)
+	l.Printf("%s%s", l.indent(), decorate("❌", Message(MsgSSHError, err)))
 }
 
 func (l *Logger) Summary(success, failed int) {
-	l.Printf("\n%s", strings.Repeat("-", 80))
-	l.Printf("SUMMARY")
-	l.Printf("%s", strings.Repeat("-", 80))
-	l.Printf("  Successful: %d", success)
-	l.Printf("  Failed: %d", failed)
-	l.Printf("%s", strings.Repeat("-", 80))
+	l.heading("-", Message(MsgSummary))
+	l.rule("-")
+	l.Printf("  %s", Message(MsgSuccessful, success))
+	l.Printf("  %s", Message(MsgFailed, failed))
+	l.rule("-")
 }
 
 func (l *Logger) Indent() {
@@ -0,0 +1,257 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// MessageID identifies a user-facing message in the catalog
+type MessageID string
+
+// Messages of the logger
+const (
+	MsgLevelInfo      MessageID = "level.info"
+	MsgLevelSuccess   MessageID = "level.success"
+	MsgLevelError     MessageID = "level.error"
+	MsgLevelWarning   MessageID = "level.warning"
+	MsgLevelDebug     MessageID = "level.debug"
+	MsgTask           MessageID = "task"
+	MsgTaskHost       MessageID = "task.host"
+	MsgHost           MessageID = "host"
+	MsgSummary        MessageID = "summary"
+	MsgSuccessful     MessageID = "summary.successful"
+	MsgFailed         MessageID = "summary.failed"
+	MsgPackageInstall MessageID = "package.install"
+	MsgPackageRemove  MessageID = "package.remove"
+	MsgPackageExists  MessageID = "package.exists"
+	MsgPackageSuccess MessageID = "package.success"
+	MsgPackageError   MessageID = "package.error"
+	MsgSSHConnecting  MessageID = "ssh.connecting"
+	MsgSSHSuccess     MessageID = "ssh.success"
+	MsgSSHError       MessageID = "ssh.error"
+)
+
+// Messages of the plan renderer
+const (
+	MsgPlanTitle         MessageID = "plan.title"
+	MsgPlanCreatedAt     MessageID = "plan.created_at"
+	MsgPlanTotals        MessageID = "plan.totals"
+	MsgPlanNoHost        MessageID = "plan.no_host"
+	MsgPlanChange        MessageID = "plan.change"
+	MsgPlanOwner         MessageID = "plan.owner"
+	MsgPlanLabels        MessageID = "plan.labels"
+	MsgPlanConfiguration MessageID = "plan.configuration"
+	MsgPlanNoChanges     MessageID = "plan.no_changes"
+	MsgPlanApplyHint     MessageID = "plan.apply_hint"
+	MsgPlanSaved         MessageID = "plan.saved"
+	MsgPlanApplySaved    MessageID = "plan.apply_saved"
+	MsgExecutionPlan     MessageID = "plan.execution"
+)
+
+// DefaultLanguage is used for messages missing from the selected language
+const DefaultLanguage = "en"
+
+// catalogs holds the messages of every supported language
+var catalogs = map[string]map[MessageID]string{
+	"en": {
+		MsgLevelInfo:      "INFO",
+		MsgLevelSuccess:   "SUCCESS",
+		MsgLevelError:     "ERROR",
+		MsgLevelWarning:   "WARNING",
+		MsgLevelDebug:     "DEBUG",
+		MsgTask:           "TASK [%s]",
+		MsgTaskHost:       "host: %s",
+		MsgHost:           "HOST: %s",
+		MsgSummary:        "SUMMARY",
+		MsgSuccessful:     "Successful: %d",
+		MsgFailed:         "Failed: %d",
+		MsgPackageInstall: "Installing %s via %s...",
+		MsgPackageRemove:  "Removing %s via %s...",
+		MsgPackageExists:  "%s via %s already exists",
+		MsgPackageSuccess: "Successfully installed %s in %v",
+		MsgPackageError:   "Failed to install %s: %v",
+		MsgSSHConnecting:  "Connecting to %s@%s:%s...",
+		MsgSSHSuccess:     "SSH connection established",
+		MsgSSHError:       "SSH connection failed: %v",
+
+		MsgPlanTitle:         "=== EXECUTION PLAN ===",
+		MsgPlanCreatedAt:     "Plan created at: %s",
+		MsgPlanTotals:        "Plan: %d to create, %d to update, %d to delete, %d unchanged",
+		MsgPlanNoHost:        "(no host)",
+		MsgPlanChange:        "%s: %s → %s",
+		MsgPlanOwner:         "Owner: %s",
+		MsgPlanLabels:        "Labels: %s",
+		MsgPlanConfiguration: "Configuration:",
+		MsgPlanNoChanges:     "No changes needed. All resources are up to date.",
+		MsgPlanApplyHint:     "To apply this plan, run: settlectl create",
+		MsgPlanSaved:         "Plan saved to: %s",
+		MsgPlanApplySaved:    "To apply exactly this plan, run: settlectl apply %s --approve",
+		MsgExecutionPlan:     "Execution Plan:",
+	},
+	"de": {
+		MsgLevelInfo:      "INFO",
+		MsgLevelSuccess:   "ERFOLG",
+		MsgLevelError:     "FEHLER",
+		MsgLevelWarning:   "WARNUNG",
+		MsgLevelDebug:     "DEBUG",
+		MsgTask:           "AUFGABE [%s]",
+		MsgTaskHost:       "Host: %s",
+		MsgHost:           "HOST: %s",
+		MsgSummary:        "ZUSAMMENFASSUNG",
+		MsgSuccessful:     "Erfolgreich: %d",
+		MsgFailed:         "Fehlgeschlagen: %d",
+		MsgPackageInstall: "Installiere %s mit %s ...",
+		MsgPackageRemove:  "Entferne %s mit %s ...",
+		MsgPackageExists:  "%s ist mit %s bereits installiert",
+		MsgPackageSuccess: "%s in %v installiert",
+		MsgPackageError:   "Installation von %s fehlgeschlagen: %v",
+		MsgSSHConnecting:  "Verbinde mit %s@%s:%s ...",
+		MsgSSHSuccess:     "SSH-Verbindung hergestellt",
+		MsgSSHError:       "SSH-Verbindung fehlgeschlagen: %v",
+
+		MsgPlanTitle:         "=== AUSFÜHRUNGSPLAN ===",
+		MsgPlanCreatedAt:     "Plan erstellt am: %s",
+		MsgPlanTotals:        "Plan: %d erstellen, %d ändern, %d löschen, %d unverändert",
+		MsgPlanNoHost:        "(kein Host)",
+		MsgPlanChange:        "%s: %s → %s",
+		MsgPlanOwner:         "Besitzer: %s",
+		MsgPlanLabels:        "Labels: %s",
+		MsgPlanConfiguration: "Konfiguration:",
+		MsgPlanNoChanges:     "Keine Änderungen nötig. Alle Ressourcen sind aktuell.",
+		MsgPlanApplyHint:     "Um diesen Plan anzuwenden: settlectl create",
+		MsgPlanSaved:         "Plan gespeichert in: %s",
+		MsgPlanApplySaved:    "Um genau diesen Plan anzuwenden: settlectl apply %s --approve",
+		MsgExecutionPlan:     "Ausführungsplan:",
+	},
+}
+
+// plainCatalogs overrides messages in plain output mode with phrasing that
+// reads well with a screen reader: no brackets, rules or ellipses, and
+// positions spelled out
+var plainCatalogs = map[string]map[MessageID]string{
+	"en": {
+		MsgLevelInfo:      "Info",
+		MsgLevelSuccess:   "Success",
+		MsgLevelError:     "Error",
+		MsgLevelWarning:   "Warning",
+		MsgLevelDebug:     "Debug",
+		MsgTask:           "Task: %s",
+		MsgTaskHost:       "Host: %s",
+		MsgHost:           "Host: %s",
+		MsgSummary:        "Summary",
+		MsgPackageInstall: "Installing %s with %s",
+		MsgPackageRemove:  "Removing %s with %s",
+		MsgSSHConnecting:  "Connecting to %[2]s as %[1]s on port %[3]s",
+
+		MsgPlanTitle:  "Execution plan",
+		MsgPlanNoHost: "Without a host",
+		MsgPlanChange: "%s changes from %s to %s",
+	},
+	"de": {
+		MsgLevelInfo:      "Info",
+		MsgLevelSuccess:   "Erfolg",
+		MsgLevelError:     "Fehler",
+		MsgLevelWarning:   "Warnung",
+		MsgLevelDebug:     "Debug",
+		MsgTask:           "Aufgabe: %s",
+		MsgHost:           "Host: %s",
+		MsgSummary:        "Zusammenfassung",
+		MsgPackageInstall: "Installiere %s mit %s",
+		MsgPackageRemove:  "Entferne %s mit %s",
+		MsgSSHConnecting:  "Verbinde mit %[2]s als %[1]s auf Port %[3]s",
+
+		MsgPlanTitle:  "Ausführungsplan",
+		MsgPlanNoHost: "Ohne Host",
+		MsgPlanChange: "%s ändert sich von %s zu %s",
+	},
+}
+
+var (
+	language    = DefaultLanguage
+	plainOutput bool
+)
+
+// Languages returns the supported languages
+func Languages() []string {
+	languages := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// SetLanguage selects the language of messages, e.g. "de" or "de_DE.UTF-8"
+func SetLanguage(lang string) error {
+	normalized := normalizeLanguage(lang)
+	if _, ok := catalogs[normalized]; !ok {
+		return fmt.Errorf("unsupported language: %s (supported: %s)", lang, strings.Join(Languages(), ", "))
+	}
+	language = normalized
+	return nil
+}
+
+// LocaleLanguage returns the language of the locale set in LC_ALL,
+// LC_MESSAGES or LANG when it is supported, and DefaultLanguage otherwise
+func LocaleLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if lang := normalizeLanguage(value); catalogs[lang] != nil {
+			return lang
+		}
+		// The first variable set decides, like setlocale does
+		break
+	}
+	return DefaultLanguage
+}
+
+// normalizeLanguage reduces a locale such as de_DE.UTF-8 to its language
+func normalizeLanguage(lang string) string {
+	lang = strings.ToLower(lang)
+	if i := strings.IndexAny(lang, "_-.@"); i >= 0 {
+		lang = lang[:i]
+	}
+	return lang
+}
+
+// SetPlain enables plain output: no rules, box drawing or emoji, and
+// phrasing meant for screen readers
+func SetPlain(plain bool) {
+	plainOutput = plain
+}
+
+// Plain reports whether plain output is enabled
+func Plain() bool {
+	return plainOutput
+}
+
+// Message returns the message id in the selected language, formatted with
+// args. Messages missing from the language fall back to DefaultLanguage.
+func Message(id MessageID, args ...interface{}) string {
+	format, ok := lookupMessage(language, id)
+	if !ok {
+		format, ok = lookupMessage(DefaultLanguage, id)
+	}
+	if !ok {
+		format = string(id)
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func lookupMessage(lang string, id MessageID) (string, bool) {
+	if plainOutput {
+		if format, ok := plainCatalogs[lang][id]; ok {
+			return format, true
+		}
+	}
+	format, ok := catalogs[lang][id]
+	return format, ok
+}
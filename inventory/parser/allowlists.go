@@ -0,0 +1,39 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseAllowLists reads `allow "name" { ... }` command allow-lists from path
+func ParseAllowLists(path string) ([]common.AllowList, error) {
+	blocks, err := ParseBlocksOfType(path, "allow")
+	if err != nil {
+		return nil, err
+	}
+
+	var allowLists []common.AllowList
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: allow-list name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("allow-list name too long: %s", block.Name)
+		}
+
+		allowList := common.AllowList{Name: block.Name}
+		allowList.Target.Hosts, _ = block.List("hosts")
+		if val, ok := block.Attr("group"); ok {
+			allowList.Target.Group = val
+		}
+		allowList.Commands, _ = block.List("commands")
+		if len(allowList.Commands) == 0 {
+			return nil, fmt.Errorf("allow-list %s: commands is required", block.Name)
+		}
+
+		allowLists = append(allowLists, allowList)
+	}
+
+	return allowLists, nil
+}
@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// Block is a generic `type "name" { key = value }` block from a .stl file.
+// Nested blocks (e.g. `labels { ... }`) are collected in Blocks. A top-level
+// `include "path"` statement is a block without a body.
+type Block struct {
+	Type       string
+	Name       string
+	Attributes map[string]string // values as text; lists and maps in .stl syntax
+	Attrs      []*Attribute      // attributes in source order
+	Blocks     []*Block
+	Line       int
+	Pos        Pos
+	End        Pos // closing brace; Pos for an include
+}
+
+// Attribute is a `key = value` entry of a block or inline map
+type Attribute struct {
+	Key   string
+	Value *Value
+	Pos   Pos
+}
+
+// ValueKind is the kind of an attribute value
+type ValueKind int
+
+// Value kinds
+const (
+	StringValue ValueKind = iota // quoted string or bare word
+	ListValue                    // [a, b]
+	MapValue                     // { key = value }
+)
+
+func (k ValueKind) String() string {
+	switch k {
+	case ListValue:
+		return "list"
+	case MapValue:
+		return "map"
+	}
+	return "string"
+}
+
+// Value is an attribute value
+type Value struct {
+	Kind   ValueKind
+	Str    string       // unquoted text of a string value
+	Quoted bool         // the string was written in double quotes
+	List   []*Value     // items of a list
+	Map    []*Attribute // entries of a map
+	Pos    Pos
+	End    Pos // closing bracket or brace of a list or map; Pos for a string
+}
+
+// String renders the value in .stl syntax; strings are returned unquoted
+func (v *Value) String() string {
+	switch v.Kind {
+	case ListValue:
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = strconv.Quote(item.String())
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case MapValue:
+		entries := make([]string, len(v.Map))
+		for i, entry := range v.Map {
+			entries[i] = fmt.Sprintf("%s = %s", entry.Key, strconv.Quote(entry.Value.String()))
+		}
+		return "{ " + strings.Join(entries, ", ") + " }"
+	}
+	return v.Str
+}
+
+// scalar returns the value of an attribute that takes a single string,
+// rejecting lists and maps that would otherwise be used as their .stl text
+func scalar(attr *Attribute, owner string) (string, error) {
+	if attr.Value.Kind != StringValue {
+		return "", fmt.Errorf("%s: %s in %s must be a single value, found a %s", attr.Value.Pos, attr.Key, owner, attr.Value.Kind)
+	}
+	return attr.Value.Str, nil
+}
+
+// Attr returns the attribute value and whether it was set
+func (b *Block) Attr(key string) (string, bool) {
+	val, ok := b.Attributes[key]
+	return val, ok
+}
+
+// Attribute returns the attribute with the given key, or nil
+func (b *Block) Attribute(key string) *Attribute {
+	for _, attr := range b.Attrs {
+		if attr.Key == key {
+			return attr
+		}
+	}
+	return nil
+}
+
+// List returns a list attribute; a single string becomes a one-item list
+func (b *Block) List(key string) ([]string, bool) {
+	attr := b.Attribute(key)
+	if attr == nil {
+		return nil, false
+	}
+	if attr.Value.Kind != ListValue {
+		if attr.Value.Str == "" {
+			return nil, true
+		}
+		return []string{attr.Value.String()}, true
+	}
+	items := make([]string, 0, len(attr.Value.List))
+	for _, item := range attr.Value.List {
+		items = append(items, item.Str)
+	}
+	return items, true
+}
+
+// Map returns the entries of an inline `{ key = "value" }` map attribute
+func (b *Block) Map(key string) (map[string]string, bool, error) {
+	attr := b.Attribute(key)
+	if attr == nil {
+		return nil, false, nil
+	}
+	if attr.Value.Kind != MapValue {
+		return nil, true, &SyntaxError{Pos: attr.Value.Pos, Message: fmt.Sprintf("%s must be a map: { key = \"value\" }", key)}
+	}
+	entries := make(map[string]string, len(attr.Value.Map))
+	for _, entry := range attr.Value.Map {
+		entries[entry.Key] = entry.Value.Str
+	}
+	return entries, true, nil
+}
+
+// Child returns the first nested block of the given type
+func (b *Block) Child(blockType string) *Block {
+	for _, child := range b.Blocks {
+		if child.Type == blockType {
+			return child
+		}
+	}
+	return nil
+}
+
+// ParseBlocks parses a .stl file and returns all top-level blocks
+func ParseBlocks(path string) ([]*Block, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path cannot be empty")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if err := validateFileSize(file); err != nil {
+		return nil, err
+	}
+
+	return ParseSource(path, file)
+}
+
+// ParseBlocksFrom parses .stl text from r and returns all top-level blocks
+func ParseBlocksFrom(r io.Reader) ([]*Block, error) {
+	return ParseSource("", r)
+}
+
+// ParseSource parses .stl text from r, naming file in positions and errors
+func ParseSource(file string, r io.Reader) ([]*Block, error) {
+	data, err := io.ReadAll(io.LimitReader(r, common.MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %w", err)
+	}
+	if len(data) > common.MaxFileSize {
+		return nil, fmt.Errorf("file too large (max: %d bytes)", common.MaxFileSize)
+	}
+
+	p := &blockParser{lexer: newLexer(file, string(data))}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.parseFile()
+}
+
+// ParseBlocksOfType returns the top-level blocks of the given type from path
+func ParseBlocksOfType(path, blockType string) ([]*Block, error) {
+	blocks, err := ParseBlocks(path)
+	if err != nil {
+		return nil, err
+	}
+	return BlocksOfType(blocks, blockType), nil
+}
+
+// BlocksOfType returns the blocks of the given type
+func BlocksOfType(blocks []*Block, blockType string) []*Block {
+	var result []*Block
+	for _, block := range blocks {
+		if block.Type == blockType {
+			result = append(result, block)
+		}
+	}
+	return result
+}
+
+// ParseList parses a `["a", "b"]` list value; a bare value becomes a single item
+func ParseList(val string) []string {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return nil
+	}
+	if !strings.HasPrefix(val, "[") {
+		return []string{val}
+	}
+
+	val = strings.TrimSuffix(strings.TrimPrefix(val, "["), "]")
+	var items []string
+	for _, item := range splitOutsideQuotes(val, ',') {
+		item = unquote(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func unquote(val string) string {
+	if strings.HasPrefix(val, "[") {
+		return val
+	}
+	// Quoted values may carry escapes such as \n in file content
+	if unquoted, err := strconv.Unquote(val); err == nil {
+		return unquoted
+	}
+	return strings.Trim(val, "\"")
+}
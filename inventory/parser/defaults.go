@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseDefaults reads the `defaults { ... }` block from path. A umask sets
+// the file and directory modes the block leaves out, as it would on the host.
+func ParseDefaults(path string) (common.Defaults, error) {
+	var defaults common.Defaults
+
+	blocks, err := ParseBlocksOfType(path, "defaults")
+	if err != nil {
+		return defaults, err
+	}
+	if len(blocks) == 0 {
+		return defaults, nil
+	}
+	if len(blocks) > 1 {
+		return defaults, fmt.Errorf("%s: only one defaults block is allowed", blocks[1].Pos)
+	}
+	block := blocks[0]
+
+	if defaults.FileMode, err = parseModeAttr(block, "file_mode", 07777); err != nil {
+		return defaults, err
+	}
+	if defaults.DirMode, err = parseModeAttr(block, "dir_mode", 07777); err != nil {
+		return defaults, err
+	}
+	if val, ok := block.Attr("umask"); ok {
+		umask, err := parseMode(val, 0777)
+		if err != nil {
+			return defaults, fmt.Errorf("defaults: invalid umask: %s", val)
+		}
+		defaults.Umask = umask
+		defaults.HasUmask = true
+		if defaults.FileMode == 0 {
+			defaults.FileMode = 0666 &^ umask
+		}
+		if defaults.DirMode == 0 {
+			defaults.DirMode = 0777 &^ umask
+		}
+	}
+	if val, ok := block.Attr("owner"); ok {
+		if len(val) > common.MaxNameLength {
+			return defaults, fmt.Errorf("defaults: owner name too long")
+		}
+		defaults.Owner = val
+	}
+	if val, ok := block.Attr("group"); ok {
+		if len(val) > common.MaxNameLength {
+			return defaults, fmt.Errorf("defaults: group name too long")
+		}
+		defaults.Group = val
+	}
+
+	return defaults, nil
+}
+
+// parseModeAttr parses an optional octal mode attribute, returning 0 if unset
+func parseModeAttr(block *Block, key string, max int) (int, error) {
+	val, ok := block.Attr(key)
+	if !ok {
+		return 0, nil
+	}
+	mode, err := parseMode(val, max)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid %s: %s", block.Type, key, val)
+	}
+	return mode, nil
+}
+
+// parseMode parses an octal mode such as "0644" no larger than max
+func parseMode(val string, max int) (int, error) {
+	mode, err := strconv.ParseInt(val, 8, 32)
+	if err != nil || mode < 0 || int(mode) > max {
+		return 0, fmt.Errorf("invalid mode: %s", val)
+	}
+	return int(mode), nil
+}
@@ -0,0 +1,246 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxInstances bounds how many blocks count or for_each may produce from one
+const maxInstances = 1000
+
+// Variables returns the values of the `variable "name" { default = ... }`
+// blocks as .stl text, which lists and maps keep
+func Variables(blocks []*Block) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, block := range BlocksOfType(blocks, "variable") {
+		if _, ok := vars[block.Name]; ok {
+			return nil, fmt.Errorf("%s: variable %s is declared twice", block.Pos, block.Name)
+		}
+		value, ok := block.Attr("default")
+		if !ok {
+			return nil, fmt.Errorf("%s: variable %s needs a default", block.Pos, block.Name)
+		}
+		vars[block.Name] = value
+	}
+	return vars, nil
+}
+
+// instance is one block produced by count or for_each and the values of its
+// ${count.*} or ${each.*} references
+type instance struct {
+	prefix string
+	values map[string]string
+}
+
+// ExpandBlocks replaces every block with a `count` or `for_each` attribute by
+// one copy per instance. `count = 3` makes copies whose ${count.index} is 0,
+// 1 and 2; `for_each` takes a list, a map or var.name, and sets ${each.key}
+// and ${each.value} to each item or map entry. The copies must differ in
+// name. var.name is looked up in vars.
+func ExpandBlocks(blocks []*Block, vars map[string]string) ([]*Block, error) {
+	var result []*Block
+	for _, block := range blocks {
+		instances, err := blockInstances(block, vars)
+		if err != nil {
+			return nil, err
+		}
+		if instances == nil {
+			if reference := repetitionReference(block); reference != "" {
+				return nil, fmt.Errorf("%s: %s %q uses %s without count or for_each", block.Pos, block.Type, block.Name, reference)
+			}
+			result = append(result, block)
+			continue
+		}
+
+		names := make(map[string]bool)
+		for _, inst := range instances {
+			expanded := expandBlock(block, inst)
+			if reference := repetitionReference(expanded); reference != "" {
+				return nil, fmt.Errorf("%s: %s %q uses %s, which %s does not set", block.Pos, block.Type, block.Name, reference, inst.attribute())
+			}
+			if names[expanded.Name] {
+				return nil, fmt.Errorf("%s: %s %q repeats the name %q; make each name distinct with %s", block.Pos, block.Type, block.Name, expanded.Name, inst.distinct())
+			}
+			names[expanded.Name] = true
+			result = append(result, expanded)
+		}
+	}
+	return result, nil
+}
+
+// attribute returns the attribute that made the instance
+func (inst instance) attribute() string {
+	if inst.prefix == "count" {
+		return "count"
+	}
+	return "for_each"
+}
+
+// distinct returns the reference that tells the instances apart
+func (inst instance) distinct() string {
+	if inst.prefix == "count" {
+		return "${count.index}"
+	}
+	return "${each.key}"
+}
+
+// blockInstances returns the instances of a block with count or for_each,
+// or nil for a plain block
+func blockInstances(block *Block, vars map[string]string) ([]instance, error) {
+	countAttr, forEachAttr := block.Attribute("count"), block.Attribute("for_each")
+	switch {
+	case countAttr != nil && forEachAttr != nil:
+		return nil, fmt.Errorf("%s: %s %q sets both count and for_each", block.Pos, block.Type, block.Name)
+	case countAttr != nil:
+		value, err := resolveVariable(countAttr.Value, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: count: %w", countAttr.Pos, err)
+		}
+		count, err := strconv.Atoi(value.Str)
+		if value.Kind != StringValue || err != nil || count < 0 {
+			return nil, fmt.Errorf("%s: count must be a non-negative integer, found %s", countAttr.Pos, value)
+		}
+		if count > maxInstances {
+			return nil, fmt.Errorf("%s: count %d exceeds the maximum of %d", countAttr.Pos, count, maxInstances)
+		}
+		instances := make([]instance, 0, count)
+		for i := 0; i < count; i++ {
+			instances = append(instances, instance{"count", map[string]string{"index": strconv.Itoa(i)}})
+		}
+		return instances, nil
+	case forEachAttr != nil:
+		value, err := resolveVariable(forEachAttr.Value, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: for_each: %w", forEachAttr.Pos, err)
+		}
+		var instances []instance
+		switch value.Kind {
+		case ListValue:
+			for _, item := range value.List {
+				instances = append(instances, instance{"each", map[string]string{"key": item.String(), "value": item.String()}})
+			}
+		case MapValue:
+			for _, entry := range value.Map {
+				instances = append(instances, instance{"each", map[string]string{"key": entry.Key, "value": entry.Value.String()}})
+			}
+		default:
+			return nil, fmt.Errorf("%s: for_each must be a list, a map or var.name, found %q", forEachAttr.Pos, value.Str)
+		}
+		if len(instances) > maxInstances {
+			return nil, fmt.Errorf("%s: for_each has %d items, more than the maximum of %d", forEachAttr.Pos, len(instances), maxInstances)
+		}
+		// An empty collection produces no blocks rather than the block itself
+		if instances == nil {
+			instances = []instance{}
+		}
+		return instances, nil
+	}
+	return nil, nil
+}
+
+// resolveVariable returns the value of the variable a bare var.name refers
+// to, parsed as .stl, or value itself
+func resolveVariable(value *Value, vars map[string]string) (*Value, error) {
+	name, ok := strings.CutPrefix(value.Str, "var.")
+	if value.Kind != StringValue || value.Quoted || !ok {
+		return value, nil
+	}
+	text, ok := vars[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %s", name)
+	}
+	p := &blockParser{lexer: newLexer(value.Pos.File, text)}
+	if err := p.advance(); err != nil {
+		return nil, fmt.Errorf("variable %s: %w", name, err)
+	}
+	if p.tok.kind == tokenEOF {
+		return &Value{Kind: StringValue, Pos: value.Pos}, nil
+	}
+	resolved, err := p.parseValue()
+	if err != nil {
+		return nil, fmt.Errorf("variable %s: %w", name, err)
+	}
+	return resolved, nil
+}
+
+// expandBlock returns a copy of block without count and for_each, with the
+// references of inst replaced in its name, values and nested blocks
+func expandBlock(block *Block, inst instance) *Block {
+	expanded := &Block{
+		Type:       block.Type,
+		Name:       expandReferences(block.Name, inst),
+		Attributes: make(map[string]string, len(block.Attrs)),
+		Line:       block.Line,
+		Pos:        block.Pos,
+		End:        block.End,
+	}
+	for _, attr := range block.Attrs {
+		if attr.Key == "count" || attr.Key == "for_each" {
+			continue
+		}
+		value := expandValue(attr.Value, inst)
+		expanded.Attrs = append(expanded.Attrs, &Attribute{Key: attr.Key, Value: value, Pos: attr.Pos})
+		expanded.Attributes[attr.Key] = value.String()
+	}
+	for _, child := range block.Blocks {
+		expanded.Blocks = append(expanded.Blocks, expandBlock(child, inst))
+	}
+	return expanded
+}
+
+func expandValue(value *Value, inst instance) *Value {
+	expanded := *value
+	expanded.Str = expandReferences(value.Str, inst)
+	expanded.List = nil
+	for _, item := range value.List {
+		expanded.List = append(expanded.List, expandValue(item, inst))
+	}
+	expanded.Map = nil
+	for _, entry := range value.Map {
+		expanded.Map = append(expanded.Map, &Attribute{Key: entry.Key, Value: expandValue(entry.Value, inst), Pos: entry.Pos})
+	}
+	return &expanded
+}
+
+// expandReferences replaces the ${count.*} or ${each.*} references of inst
+// in text; other references are left alone
+func expandReferences(text string, inst instance) string {
+	if !strings.Contains(text, "${") {
+		return text
+	}
+	return referenceRegex.ReplaceAllStringFunc(text, func(reference string) string {
+		match := referenceRegex.FindStringSubmatch(reference)
+		if value, ok := inst.values[match[2]]; ok && match[1] == inst.prefix {
+			return value
+		}
+		return reference
+	})
+}
+
+// repetitionReference returns the first ${count.*} or ${each.*} reference in
+// a block, or ""
+func repetitionReference(block *Block) string {
+	find := func(text string) string {
+		for _, match := range referenceRegex.FindAllStringSubmatch(text, -1) {
+			if match[1] == "count" || match[1] == "each" {
+				return match[0]
+			}
+		}
+		return ""
+	}
+	if reference := find(block.Name); reference != "" {
+		return reference
+	}
+	for _, attr := range block.Attrs {
+		if reference := find(attr.Value.String()); reference != "" {
+			return reference
+		}
+	}
+	for _, child := range block.Blocks {
+		if reference := repetitionReference(child); reference != "" {
+			return reference
+		}
+	}
+	return ""
+}
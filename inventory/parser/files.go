@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseFiles reads `file "/path" { ... }` blocks from path
+func ParseFiles(path string) ([]common.File, error) {
+	blocks, err := ParseBlocks(path)
+	if err != nil {
+		return nil, err
+	}
+	return FilesFromBlocks(blocks)
+}
+
+// ParseFilesFrom reads `file "/path" { ... }` blocks from r
+func ParseFilesFrom(r io.Reader) ([]common.File, error) {
+	blocks, err := ParseBlocksFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return FilesFromBlocks(blocks)
+}
+
+// FilesFromBlocks builds the files of the `file` blocks in blocks
+func FilesFromBlocks(blocks []*Block) ([]common.File, error) {
+	var files []common.File
+	for _, block := range BlocksOfType(blocks, "file") {
+		file := common.File{Path: block.Name}
+		if val, ok := block.Attr("path"); ok {
+			file.Path = val
+		}
+		if file.Path == "" || !strings.HasPrefix(file.Path, "/") {
+			return nil, fmt.Errorf("%s: file path must be absolute: %q", block.Pos, file.Path)
+		}
+		if len(file.Path) > common.MaxPathLength {
+			return nil, fmt.Errorf("%s: file path too long: %s", block.Pos, file.Path)
+		}
+
+		if err := parseFileContent(block, &file); err != nil {
+			return nil, err
+		}
+		if attr := block.Attribute("mode"); attr != nil {
+			val := attr.Value.String()
+			mode, err := parseMode(val, 07777)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid mode in file %s: %s", attr.Pos, file.Path, val)
+			}
+			file.Mode = mode
+		}
+		if attr := block.Attribute("dir_mode"); attr != nil {
+			val := attr.Value.String()
+			mode, err := parseMode(val, 07777)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid dir_mode in file %s: %s", attr.Pos, file.Path, val)
+			}
+			file.DirMode = mode
+		}
+		if attr := block.Attribute("owner"); attr != nil {
+			val := attr.Value.String()
+			if len(val) > common.MaxNameLength {
+				return nil, fmt.Errorf("%s: owner name too long in file %s", attr.Pos, file.Path)
+			}
+			if err := common.CheckName(val); err != nil {
+				return nil, fmt.Errorf("%s: invalid owner in file %s: %w", attr.Pos, file.Path, err)
+			}
+			file.Owner = val
+		}
+		if attr := block.Attribute("group"); attr != nil {
+			val := attr.Value.String()
+			if len(val) > common.MaxNameLength {
+				return nil, fmt.Errorf("%s: group name too long in file %s", attr.Pos, file.Path)
+			}
+			if err := common.CheckName(val); err != nil {
+				return nil, fmt.Errorf("%s: invalid group in file %s: %w", attr.Pos, file.Path, err)
+			}
+			file.Group = val
+		}
+		if attr := block.Attribute("timeout"); attr != nil {
+			val := attr.Value.String()
+			timeout, err := time.ParseDuration(val)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("%s: invalid timeout in file %s: %s", attr.Pos, file.Path, val)
+			}
+			file.Timeout = timeout
+		}
+		if attr := block.Attribute("interval"); attr != nil {
+			val := attr.Value.String()
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval < common.MinInterval {
+				return nil, fmt.Errorf("%s: invalid interval in file %s: %s (minimum %v)", attr.Pos, file.Path, val, common.MinInterval)
+			}
+			file.Interval = interval
+		}
+		file.Target.Hosts, _ = block.List("hosts")
+		if val, ok := block.Attr("host_group"); ok {
+			file.Target.Group = val
+		}
+		when, err := parseWhen(block)
+		if err != nil {
+			return nil, err
+		}
+		file.Target.When = when
+		if val, ok := block.Attr("on_drift"); ok {
+			file.OnDrift = val
+		}
+		if attr := block.Attribute("notify"); attr != nil {
+			file.Notify, _ = block.List("notify")
+			for _, id := range file.Notify {
+				if kind, name, ok := strings.Cut(id, ":"); !ok || kind == "" || name == "" {
+					return nil, fmt.Errorf("%s: invalid notify in file %s: %s (expected a resource such as service:nginx)", attr.Pos, file.Path, id)
+				}
+			}
+		}
+		if attr := block.Attribute("sensitive"); attr != nil {
+			sensitive, err := parseSensitive(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+			}
+			file.Sensitive = sensitive
+		}
+		hooks, err := parseHooks(block)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", file.Path, err)
+		}
+		file.Hooks = hooks
+		labels, err := parseLabels(block)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", file.Path, err)
+		}
+		file.Labels = labels
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// parseFileContent sets the content of file from whichever of content,
+// content_base64, source and source_url the block declares. A source path is
+// relative to the declaring file; a source that is not UTF-8 text is uploaded
+// as is. Content at a source_url is fetched when applied, so its checksum
+// must be declared.
+func parseFileContent(block *Block, file *common.File) error {
+	var declared []*Attribute
+	for _, key := range []string{"content", "content_base64", "source", "source_url"} {
+		if attr := block.Attribute(key); attr != nil {
+			declared = append(declared, attr)
+		}
+	}
+	if len(declared) > 1 {
+		return fmt.Errorf("%s: file %s sets both %s and %s", declared[1].Pos, file.Path, declared[0].Key, declared[1].Key)
+	}
+
+	if len(declared) == 1 {
+		attr := declared[0]
+		val := attr.Value.String()
+		switch attr.Key {
+		case "content":
+			file.Content = val
+		case "content_base64":
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+			if err != nil {
+				return fmt.Errorf("%s: invalid content_base64 in file %s: %w", attr.Pos, file.Path, err)
+			}
+			file.Content = string(data)
+			file.Verbatim = true
+		case "source":
+			source := val
+			if !filepath.IsAbs(source) && block.Pos.File != "" {
+				source = filepath.Join(filepath.Dir(block.Pos.File), source)
+			}
+			data, err := readSource(source)
+			if err != nil {
+				return fmt.Errorf("%s: invalid source in file %s: %w", attr.Pos, file.Path, err)
+			}
+			file.Source = source
+			file.Content = string(data)
+			file.Verbatim = !utf8.Valid(data)
+		case "source_url":
+			u, err := url.Parse(val)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("%s: invalid source_url in file %s: %s (expected an http or https URL)", attr.Pos, file.Path, val)
+			}
+			if block.Attribute("checksum") == nil {
+				return fmt.Errorf("%s: file %s sets source_url without a checksum", attr.Pos, file.Path)
+			}
+			file.SourceURL = val
+			file.Verbatim = true
+		}
+	}
+
+	if attr := block.Attribute("checksum"); attr != nil {
+		val := attr.Value.String()
+		algorithm, digest := common.ParseChecksum(val)
+		if !strings.Contains(val, ":") || digest == "" {
+			return fmt.Errorf("%s: invalid checksum in file %s: %s (expected e.g. sha256:<hex>)", attr.Pos, file.Path, val)
+		}
+		if _, err := common.ChecksumWith(algorithm, nil); err != nil {
+			return fmt.Errorf("%s: invalid checksum in file %s: %w", attr.Pos, file.Path, err)
+		}
+		if file.SourceURL == "" {
+			if err := common.VerifyChecksum(val, []byte(file.Content)); err != nil {
+				return fmt.Errorf("%s: content of file %s does not match: %w", attr.Pos, file.Path, err)
+			}
+		}
+		file.Checksum = val
+	}
+	return nil
+}
+
+// readSource reads a local file source, refusing one over
+// common.MaxFileContent
+func readSource(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, common.MaxFileContent+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > common.MaxFileContent {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, common.MaxFileContent)
+	}
+	return data, nil
+}
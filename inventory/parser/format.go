@@ -0,0 +1,337 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// formatIndent is one level of indentation in formatted .stl files
+const formatIndent = "  "
+
+// Format returns .stl source in canonical form: two-space indentation, the
+// '=' of consecutive attributes aligned, strings and block labels quoted
+// (true, false, integers and var.name references stay bare) and at most one
+// blank line between statements. Lists and maps that span lines get one
+// entry per line. Comments are kept.
+func Format(file string, src []byte) ([]byte, error) {
+	if len(src) > common.MaxFileSize {
+		return nil, fmt.Errorf("file too large (max: %d bytes)", common.MaxFileSize)
+	}
+
+	p := &blockParser{lexer: newLexer(file, string(src))}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	blocks, err := p.parseFile()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &formatter{comments: p.lexer.comments}
+	statements := make([]statement, len(blocks))
+	for i, block := range blocks {
+		statements[i] = statement{block: block}
+	}
+	f.statements(statements, 0)
+	f.commentsBefore(math.MaxInt, 0)
+	return f.buf.Bytes(), nil
+}
+
+// statement is an attribute or a nested block
+type statement struct {
+	attr  *Attribute
+	block *Block
+}
+
+func (s statement) pos() Pos {
+	if s.attr != nil {
+		return s.attr.Pos
+	}
+	return s.block.Pos
+}
+
+func (s statement) endLine() int {
+	if s.attr != nil {
+		return s.attr.Value.End.Line
+	}
+	return s.block.End.Line
+}
+
+// alignable reports whether the statement is an attribute on a single line
+func (s statement) alignable() bool {
+	return s.attr != nil && !multiline(s.attr.Value)
+}
+
+// bodyStatements returns the attributes and nested blocks of block in
+// source order
+func bodyStatements(block *Block) []statement {
+	statements := make([]statement, 0, len(block.Attrs)+len(block.Blocks))
+	for _, attr := range block.Attrs {
+		statements = append(statements, statement{attr: attr})
+	}
+	for _, child := range block.Blocks {
+		statements = append(statements, statement{block: child})
+	}
+	sort.SliceStable(statements, func(i, j int) bool {
+		a, b := statements[i].pos(), statements[j].pos()
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return statements
+}
+
+func multiline(value *Value) bool {
+	return value.End.Line > value.Pos.Line
+}
+
+type formatter struct {
+	buf      bytes.Buffer
+	comments []comment
+	next     int // index of the next comment to print
+	last     int // source line of the last thing printed; 0 at the start of a body
+}
+
+func (f *formatter) indent(depth int) {
+	f.buf.WriteString(strings.Repeat(formatIndent, depth))
+}
+
+// gap writes a blank line if the source had one between the last thing
+// printed and line
+func (f *formatter) gap(line int) {
+	if f.last > 0 && line > f.last+1 {
+		f.buf.WriteByte('\n')
+	}
+}
+
+// commentsBefore prints the comments above line on lines of their own
+func (f *formatter) commentsBefore(line, depth int) {
+	for f.next < len(f.comments) && f.comments[f.next].pos.Line < line {
+		c := f.comments[f.next]
+		f.next++
+		f.gap(c.pos.Line)
+		f.indent(depth)
+		f.buf.WriteString(c.text)
+		f.buf.WriteByte('\n')
+		f.last = c.pos.Line
+	}
+}
+
+// trailingComment prints a comment at the end of line after what was
+// printed from it
+func (f *formatter) trailingComment(line int) {
+	if f.next < len(f.comments) && f.comments[f.next].pos.Line == line {
+		f.buf.WriteString(" " + f.comments[f.next].text)
+		f.next++
+	}
+}
+
+// blankBetween reports whether a line without a comment separates the
+// lines from and to
+func (f *formatter) blankBetween(from, to int) bool {
+	commented := 0
+	for _, c := range f.comments[f.next:] {
+		if c.pos.Line >= to {
+			break
+		}
+		if c.pos.Line > from {
+			commented++
+		}
+	}
+	return to-from-1 > commented
+}
+
+func (f *formatter) statements(statements []statement, depth int) {
+	// Attributes on consecutive lines align their '='
+	widths := make([]int, len(statements))
+	for start := 0; start < len(statements); {
+		end := start + 1
+		if statements[start].alignable() {
+			for end < len(statements) && statements[end].alignable() &&
+				!f.blankBetween(statements[end-1].endLine(), statements[end].pos().Line) {
+				end++
+			}
+			width := 0
+			for _, s := range statements[start:end] {
+				width = max(width, utf8.RuneCountInString(s.attr.Key))
+			}
+			for i := start; i < end; i++ {
+				widths[i] = width
+			}
+		}
+		start = end
+	}
+
+	for i, s := range statements {
+		f.commentsBefore(s.pos().Line, depth)
+		f.gap(s.pos().Line)
+		if s.attr != nil {
+			f.attribute(s.attr, widths[i], depth)
+		} else {
+			f.block(s.block, depth)
+		}
+	}
+}
+
+func (f *formatter) attribute(attr *Attribute, width, depth int) {
+	f.indent(depth)
+	f.buf.WriteString(attr.Key)
+	f.buf.WriteString(strings.Repeat(" ", max(0, width-utf8.RuneCountInString(attr.Key))))
+	f.buf.WriteString(" = ")
+	f.value(attr.Value, depth)
+	f.trailingComment(attr.Value.End.Line)
+	f.buf.WriteByte('\n')
+	f.last = attr.Value.End.Line
+}
+
+func (f *formatter) block(block *Block, depth int) {
+	f.indent(depth)
+	f.buf.WriteString(block.Type)
+	if block.Name != "" {
+		f.buf.WriteString(" " + strconv.Quote(block.Name))
+	}
+	if depth == 0 && block.Type == "include" {
+		f.trailingComment(block.End.Line)
+		f.buf.WriteByte('\n')
+		f.last = block.End.Line
+		return
+	}
+
+	body := bodyStatements(block)
+	empty := len(body) == 0
+	if empty && (f.next == len(f.comments) || f.comments[f.next].pos.Line >= block.End.Line) {
+		f.buf.WriteString(" {}")
+	} else {
+		f.buf.WriteString(" {")
+		if empty || body[0].pos().Line > block.Pos.Line {
+			f.trailingComment(block.Pos.Line)
+		}
+		f.buf.WriteByte('\n')
+		f.last = 0
+		f.statements(body, depth+1)
+		f.commentsBefore(block.End.Line, depth+1)
+		f.indent(depth)
+		f.buf.WriteString("}")
+	}
+	f.trailingComment(block.End.Line)
+	f.buf.WriteByte('\n')
+	f.last = block.End.Line
+}
+
+func (f *formatter) value(value *Value, depth int) {
+	switch value.Kind {
+	case StringValue:
+		f.buf.WriteString(formatString(value))
+	case ListValue:
+		f.list(value, depth)
+	case MapValue:
+		f.inlineMap(value, depth)
+	}
+}
+
+func (f *formatter) list(list *Value, depth int) {
+	if !multiline(list) {
+		items := make([]string, len(list.List))
+		for i, item := range list.List {
+			items[i] = formatString(item)
+		}
+		f.buf.WriteString("[" + strings.Join(items, ", ") + "]")
+		return
+	}
+
+	f.buf.WriteString("[")
+	f.trailingComment(list.Pos.Line)
+	f.buf.WriteByte('\n')
+	f.last = 0
+	for _, item := range list.List {
+		f.commentsBefore(item.Pos.Line, depth+1)
+		f.gap(item.Pos.Line)
+		f.indent(depth + 1)
+		f.buf.WriteString(formatString(item) + ",")
+		f.trailingComment(item.End.Line)
+		f.buf.WriteByte('\n')
+		f.last = item.End.Line
+	}
+	f.commentsBefore(list.End.Line, depth+1)
+	f.indent(depth)
+	f.buf.WriteString("]")
+}
+
+func (f *formatter) inlineMap(m *Value, depth int) {
+	if !multiline(m) {
+		if len(m.Map) == 0 {
+			f.buf.WriteString("{}")
+			return
+		}
+		entries := make([]string, len(m.Map))
+		for i, entry := range m.Map {
+			entries[i] = formatKey(entry.Key) + " = " + formatString(entry.Value)
+		}
+		f.buf.WriteString("{ " + strings.Join(entries, ", ") + " }")
+		return
+	}
+
+	width := 0
+	for _, entry := range m.Map {
+		width = max(width, utf8.RuneCountInString(formatKey(entry.Key)))
+	}
+	f.buf.WriteString("{")
+	f.trailingComment(m.Pos.Line)
+	f.buf.WriteByte('\n')
+	f.last = 0
+	for _, entry := range m.Map {
+		key := formatKey(entry.Key)
+		f.commentsBefore(entry.Pos.Line, depth+1)
+		f.gap(entry.Pos.Line)
+		f.indent(depth + 1)
+		f.buf.WriteString(key + strings.Repeat(" ", width-utf8.RuneCountInString(key)) + " = " + formatString(entry.Value))
+		f.trailingComment(entry.Value.End.Line)
+		f.buf.WriteByte('\n')
+		f.last = entry.Value.End.Line
+	}
+	f.commentsBefore(m.End.Line, depth+1)
+	f.indent(depth)
+	f.buf.WriteString("}")
+}
+
+// formatString quotes a string value unless it is a bare true, false,
+// integer or var.name reference
+func formatString(value *Value) string {
+	if !value.Quoted && bareLiteral(value.Str) {
+		return value.Str
+	}
+	return strconv.Quote(value.Str)
+}
+
+func bareLiteral(s string) bool {
+	if s == "true" || s == "false" {
+		return true
+	}
+	if name, ok := strings.CutPrefix(s, "var."); ok {
+		return moduleNameRegex.MatchString(name)
+	}
+	if _, err := strconv.Atoi(s); err != nil {
+		return false
+	}
+	// Keep leading zeros of modes such as 0644; reject +1
+	return !strings.HasPrefix(s, "+")
+}
+
+// formatKey quotes a map key that is not a plain word
+func formatKey(key string) string {
+	if key == "" {
+		return `""`
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.", r) {
+			return strconv.Quote(key)
+		}
+	}
+	return key
+}
@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// setHookAttr sets one attribute of a `before`/`after`/`hook` block
+func setHookAttr(hook *common.Hook, key, val string) error {
+	switch key {
+	case "command":
+		hook.Command = val
+	case "local":
+		local, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("invalid local value: %s", val)
+		}
+		hook.Local = local
+	case "on_failure":
+		switch val {
+		case common.HookAbort, common.HookWarn, common.HookIgnore:
+			hook.OnFailure = val
+		default:
+			return fmt.Errorf("invalid on_failure %q (expected abort, warn or ignore)", val)
+		}
+	default:
+		return fmt.Errorf("unknown hook attribute: %s", key)
+	}
+	return nil
+}
+
+// newHook returns a hook with the default failure policy
+func newHook() common.Hook {
+	return common.Hook{OnFailure: common.HookAbort}
+}
+
+// parseHookBlock builds a hook from a nested `before { ... }` or `after { ... }` block
+func parseHookBlock(block *Block) (common.Hook, error) {
+	hook := newHook()
+	for _, attr := range block.Attrs {
+		if err := setHookAttr(&hook, attr.Key, attr.Value.String()); err != nil {
+			return hook, fmt.Errorf("%s: %w", attr.Pos, err)
+		}
+	}
+	if hook.Command == "" {
+		return hook, fmt.Errorf("%s: %s hook needs a command", block.Pos, block.Type)
+	}
+	return hook, nil
+}
+
+// parseWaitFor builds a condition from a nested `wait_for { ... }` block
+func parseWaitFor(block *Block) (common.WaitFor, error) {
+	var wait common.WaitFor
+	conditions := 0
+	for _, attr := range block.Attrs {
+		val := attr.Value.String()
+		switch attr.Key {
+		case "port":
+			port := val
+			if host, p, err := net.SplitHostPort(val); err == nil {
+				// The host ends up in a shell command on the target
+				if host == "" || (net.ParseIP(host) == nil && validateHostname(host) != nil) {
+					return wait, fmt.Errorf("%s: invalid host in wait_for port: %s (expected a hostname or IP address)", attr.Pos, val)
+				}
+				port = p
+			}
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return wait, fmt.Errorf("%s: invalid port in wait_for: %s (expected [host:]port)", attr.Pos, val)
+			}
+			wait.Port = val
+			conditions++
+		case "path":
+			wait.Path = val
+			conditions++
+		case "command":
+			wait.Command = val
+			conditions++
+		case "delay", "timeout", "interval":
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return wait, fmt.Errorf("%s: invalid %s in wait_for: %s", attr.Pos, attr.Key, val)
+			}
+			switch attr.Key {
+			case "delay":
+				wait.Delay = d
+				conditions++
+			case "timeout":
+				wait.Timeout = d
+			case "interval":
+				wait.Interval = d
+			}
+		default:
+			return wait, fmt.Errorf("%s: unknown wait_for attribute: %s", attr.Pos, attr.Key)
+		}
+	}
+	if conditions != 1 {
+		return wait, fmt.Errorf("%s: wait_for needs exactly one of port, path, command or delay", block.Pos)
+	}
+	return wait, nil
+}
+
+// parseHooks collects the before and after hooks and the wait_for
+// conditions nested in block
+func parseHooks(block *Block) (common.Hooks, error) {
+	var hooks common.Hooks
+	for _, child := range block.Blocks {
+		switch child.Type {
+		case "wait_for":
+			wait, err := parseWaitFor(child)
+			if err != nil {
+				return hooks, err
+			}
+			hooks.WaitFor = append(hooks.WaitFor, wait)
+		case "before", "after":
+			hook, err := parseHookBlock(child)
+			if err != nil {
+				return hooks, err
+			}
+			if child.Type == "before" {
+				hooks.Before = append(hooks.Before, hook)
+			} else {
+				hooks.After = append(hooks.After, hook)
+			}
+		}
+	}
+	return hooks, nil
+}
+
+// ParseRunHooks reads project-wide `hook "name" { stage = "pre-run" ... }` blocks
+func ParseRunHooks(path string) ([]common.RunHook, error) {
+	blocks, err := ParseBlocksOfType(path, "hook")
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []common.RunHook
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: hook name cannot be empty", block.Pos)
+		}
+
+		runHook := common.RunHook{Name: block.Name, Hook: newHook()}
+		for _, attr := range block.Attrs {
+			val := attr.Value.String()
+			if attr.Key == "stage" {
+				if val != "pre-run" && val != "post-run" {
+					return nil, fmt.Errorf("%s: hook %s: invalid stage %q (expected pre-run or post-run)", attr.Pos, block.Name, val)
+				}
+				runHook.Stage = val
+				continue
+			}
+			if err := setHookAttr(&runHook.Hook, attr.Key, val); err != nil {
+				return nil, fmt.Errorf("%s: hook %s: %w", attr.Pos, block.Name, err)
+			}
+		}
+		if runHook.Stage == "" {
+			return nil, fmt.Errorf("hook %s: stage is required", block.Name)
+		}
+		if runHook.Command == "" {
+			return nil, fmt.Errorf("hook %s: command is required", block.Name)
+		}
+
+		hooks = append(hooks, runHook)
+	}
+
+	return hooks, nil
+}
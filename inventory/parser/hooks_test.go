@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseWaitForPort(t *testing.T) {
+	tests := []struct {
+		port    string
+		wantErr string
+	}{
+		{port: "5432"},
+		{port: "db:5432"},
+		{port: "db.internal.example.com:5432"},
+		{port: "10.0.0.5:5432"},
+		{port: "[::1]:5432"},
+		{port: "70000", wantErr: "invalid port"},
+		{port: ":5432", wantErr: "invalid host"},
+		{port: "db;touch /tmp/x:5432", wantErr: "invalid host"},
+		{port: "$(reboot):5432", wantErr: "invalid host"},
+		{port: "db/../x:5432", wantErr: "invalid host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.port, func(t *testing.T) {
+			blocks, err := parseString(t, "package \"nginx\" {\n  wait_for {\n    port = \""+tt.port+"\"\n  }\n}\n")
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait, err := parseWaitFor(blocks[0].Child("wait_for"))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if wait.Port != tt.port {
+					t.Errorf("port = %q, want %q", wait.Port, tt.port)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
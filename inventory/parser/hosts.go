@@ -1,13 +1,13 @@
 package parser
 
 import (
-	"bufio"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"github.com/settlectl/settle-core/common"
 )
 
@@ -83,122 +83,207 @@ func validateFileSize(file *os.File) error {
 	return nil
 }
 
+// ParseHosts reads `host "name" { ... }` blocks from path
 func ParseHosts(path string) ([]common.Host, error) {
-
 	if path == "" {
 		return nil, fmt.Errorf("path cannot be empty")
 	}
-	
-
 	if strings.Contains(path, "..") {
 		return nil, fmt.Errorf("path contains directory traversal: %s", path)
 	}
-	
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
 
-	if err := validateFileSize(file); err != nil {
+	blocks, err := ParseBlocksOfType(path, "host")
+	if err != nil {
 		return nil, err
 	}
+	if len(blocks) > common.MaxHosts {
+		return nil, fmt.Errorf("too many hosts (max: %d)", common.MaxHosts)
+	}
 
 	var hosts []common.Host
-	var current common.Host
-	scanner := bufio.NewScanner(file)
-	
+	definedAt := make(map[string]Pos)
+	for _, block := range blocks {
+		host, err := hostFromBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if previous, ok := definedAt[host.Name]; ok {
+			return nil, fmt.Errorf("%s: host %s is already defined at %s", block.Pos, host.Name, previous)
+		}
+		definedAt[host.Name] = block.Pos
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
 
-	buf := make([]byte, 0, common.MaxLineLength)
-	scanner.Buffer(buf, common.MaxLineLength)
+func hostFromBlock(block *Block) (common.Host, error) {
+	host := common.Host{Name: block.Name}
+	if host.Name == "" {
+		return host, fmt.Errorf("%s: host name cannot be empty", block.Pos)
+	}
+	if len(host.Name) > common.MaxNameLength {
+		return host, fmt.Errorf("%s: host name too long: %s", block.Pos, host.Name)
+	}
 
-	lineCount := 0
-	for scanner.Scan() {
-		lineCount++
-		if lineCount > common.MaxFileSize/100 {
-			return nil, fmt.Errorf("too many lines in file")
-		}
-		
-		line := strings.TrimSpace(scanner.Text())
-		
+	vars, err := parseVars(block)
+	if err != nil {
+		return host, fmt.Errorf("host %s: %w", host.Name, err)
+	}
+	if len(vars) > 0 {
+		host.Vars = vars
+	}
 
-		if line == "" || strings.HasPrefix(line, "#") {
+	for _, attr := range block.Attrs {
+		// Every host attribute takes a single value; unknown ones are left
+		// to the schema check
+		if _, known := InventorySchema["host"].Attributes[attr.Key]; !known || attr.Key == "vars" {
 			continue
 		}
-
-		if strings.HasPrefix(line, "host ") {
-			if current.Name != "" {
-				hosts = append(hosts, current)
-				current = common.Host{}
+		if attr.Key == "aliases" {
+			host.Aliases, _ = block.List("aliases")
+			for _, alias := range host.Aliases {
+				if alias == "" || len(alias) > common.MaxNameLength {
+					return host, fmt.Errorf("%s: invalid alias in host %s: %q", attr.Pos, host.Name, alias)
+				}
 			}
-			
-
-			if len(hosts) >= common.MaxHosts {
-				return nil, fmt.Errorf("too many hosts (max: %d)", common.MaxHosts)
+			continue
+		}
+		val, err := scalar(attr, "host "+host.Name)
+		if err != nil {
+			return host, err
+		}
+		switch attr.Key {
+		case "hostname":
+			if err := validateHostname(val); err != nil {
+				return host, fmt.Errorf("%s: invalid hostname in host %s: %w", attr.Pos, host.Name, err)
 			}
-			
-			line = strings.TrimSuffix(line, "{")
-			line = strings.TrimSpace(line)
-			parts := strings.Split(line, "\"")
-			if len(parts) >= 2 {
-				hostName := parts[1]
-				if len(hostName) > common.MaxNameLength {
-					return nil, fmt.Errorf("host name too long: %s", hostName)
-				}
-				current.Name = hostName
+			host.Hostname = val
+		case "user":
+			if len(val) > common.MaxNameLength {
+				return host, fmt.Errorf("%s: username too long in host %s", attr.Pos, host.Name)
 			}
-		} else if strings.Contains(line, "=") {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) != 2 {
-				continue 
+			host.User = val
+		case "port":
+			port, err := strconv.Atoi(val)
+			if err != nil {
+				return host, fmt.Errorf("%s: invalid port in host %s: %w", attr.Pos, host.Name, err)
 			}
-			
-			key := strings.TrimSpace(parts[0])
-			val := strings.Trim(strings.TrimSpace(parts[1]), "\"")
-			
-			switch key {
-			case "hostname":
-				if err := validateHostname(val); err != nil {
-					return nil, fmt.Errorf("invalid hostname in host %s: %w", current.Name, err)
-				}
-				current.Hostname = val
-			case "user":
-				if len(val) > common.MaxNameLength {
-					return nil, fmt.Errorf("username too long in host %s", current.Name)
-				}
-				current.User = val
-			case "port":
-				port, err := strconv.Atoi(val)
-				if err != nil {
-					return nil, fmt.Errorf("invalid port in host %s: %w", current.Name, err)
-				}
-				if err := validatePort(port); err != nil {
-					return nil, fmt.Errorf("invalid port in host %s: %w", current.Name, err)
-				}
-				current.Port = port
-			case "key_file", "keyfile":
-				sanitizedPath, err := sanitizePath(val)
-				if err != nil {
-					return nil, fmt.Errorf("invalid key_file in host %s: %w", current.Name, err)
-				}
-				current.Keyfile = sanitizedPath
-			case "group":
-				if len(val) > common.MaxNameLength {
-					return nil, fmt.Errorf("group name too long in host %s", current.Name)
-				}
-				current.Group = val
+			if err := validatePort(port); err != nil {
+				return host, fmt.Errorf("%s: invalid port in host %s: %w", attr.Pos, host.Name, err)
+			}
+			host.Port = port
+		case "key_file", "keyfile":
+			if common.IsKeyReference(val) {
+				host.Keyfile = val
+				continue
 			}
+			sanitizedPath, err := sanitizePath(val)
+			if err != nil {
+				return host, fmt.Errorf("%s: invalid key_file in host %s: %w", attr.Pos, host.Name, err)
+			}
+			host.Keyfile = sanitizedPath
+		case "group":
+			if len(val) > common.MaxNameLength {
+				return host, fmt.Errorf("%s: group name too long in host %s", attr.Pos, host.Name)
+			}
+			host.Group = val
+		case "mesh_address":
+			if err := validateHostname(val); err != nil {
+				return host, fmt.Errorf("%s: invalid mesh_address in host %s: %w", attr.Pos, host.Name, err)
+			}
+			host.MeshAddress = val
+		case "transport":
+			switch val {
+			case common.TransportSSH, common.TransportLocal:
+			default:
+				return host, fmt.Errorf("%s: invalid transport in host %s: %q (expected %s or %s)", attr.Pos, host.Name, val, common.TransportSSH, common.TransportLocal)
+			}
+			host.Transport = val
+		case "connect_timeout", "command_timeout", "keepalive":
+			if err := setTimeout(&host.Timeouts, attr.Key, val); err != nil {
+				return host, fmt.Errorf("%s: %w in host %s", attr.Pos, err, host.Name)
+			}
+		case "max_sessions":
+			sessions, err := parseMaxSessions(val)
+			if err != nil {
+				return host, fmt.Errorf("%s: %w in host %s", attr.Pos, err, host.Name)
+			}
+			host.MaxSessions = sessions
 		}
 	}
-	
-	if current.Name != "" {
-		hosts = append(hosts, current)
+	return host, nil
+}
+
+var varNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseVars returns the variables of the `vars = { ... }` map attribute and
+// `vars { ... }` block of block
+func parseVars(block *Block) (map[string]string, error) {
+	vars, _, err := block.Map("vars")
+	if err != nil {
+		return nil, err
 	}
-	
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
+	if vars == nil {
+		vars = make(map[string]string)
 	}
-	
-	return hosts, nil
+	if child := block.Child("vars"); child != nil {
+		for _, attr := range child.Attrs {
+			vars[attr.Key] = attr.Value.String()
+		}
+	}
+	for name := range vars {
+		if !varNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	return vars, nil
+}
+
+// ParseGroupVars reads the variables of `group "name" { vars { ... } }`
+// blocks from path, by group
+func ParseGroupVars(path string) (map[string]map[string]string, error) {
+	blocks, err := ParseBlocksOfType(path, "group")
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]map[string]string)
+	for _, block := range blocks {
+		if _, ok := groups[block.Name]; ok {
+			return nil, fmt.Errorf("%s: group %s is already defined", block.Pos, block.Name)
+		}
+		vars, err := parseVars(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s: group %s: %w", block.Pos, block.Name, err)
+		}
+		groups[block.Name] = vars
+	}
+	return groups, nil
+}
+
+// setTimeout sets the timeout of a connect_timeout, command_timeout or
+// keepalive attribute
+func setTimeout(timeouts *common.Timeouts, key, val string) error {
+	d, err := time.ParseDuration(val)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid %s: %s", key, val)
+	}
+	switch key {
+	case "connect_timeout":
+		timeouts.Connect = d
+	case "command_timeout":
+		timeouts.Command = d
+	case "keepalive":
+		timeouts.Keepalive = d
+	}
+	return nil
+}
+
+// parseMaxSessions parses a max_sessions attribute
+func parseMaxSessions(val string) (int, error) {
+	sessions, err := strconv.Atoi(val)
+	if err != nil || sessions < 1 {
+		return 0, fmt.Errorf("invalid max_sessions: %s", val)
+	}
+	return sessions, nil
 }
@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// HostsDir is the directory next to hosts.stl whose *.stl files are also read
+const HostsDir = "hosts.d"
+
+// InventoryFiles returns the inventory files rooted at path: path itself,
+// the files it includes with `include "other.stl"` (relative to the including
+// file, recursively), and every *.stl file in the hosts.d directory next to
+// it with their includes. Missing path and hosts.d are skipped, so the result
+// is empty when neither exists.
+func InventoryFiles(path string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	including := make(map[string]bool)
+
+	var visit func(file string) error
+	visit = func(file string) error {
+		file = filepath.Clean(file)
+		if including[file] {
+			return fmt.Errorf("include cycle at %s", file)
+		}
+		if seen[file] {
+			return nil
+		}
+		seen[file] = true
+		files = append(files, file)
+
+		includes, err := parseIncludes(file)
+		if err != nil {
+			return err
+		}
+		including[file] = true
+		for _, include := range includes {
+			if err := visit(include); err != nil {
+				return err
+			}
+		}
+		delete(including, file)
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if err := visit(path); err != nil {
+			return nil, err
+		}
+	}
+
+	dirFiles, err := filepath.Glob(filepath.Join(filepath.Dir(path), HostsDir, "*.stl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dirFiles)
+	for _, file := range dirFiles {
+		if err := visit(file); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// ParseInventory reads the hosts of every file InventoryFiles returns for
+// path. Host names must be unique across all of them.
+func ParseInventory(path string) ([]common.Host, error) {
+	files, err := InventoryFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no inventory found: %s and %s/ do not exist", path, filepath.Join(filepath.Dir(path), HostsDir))
+	}
+
+	var hosts []common.Host
+	definedIn := make(map[string]string)
+	groupVars := make(map[string]map[string]string)
+	groupIn := make(map[string]string)
+	for _, file := range files {
+		fileHosts, err := ParseHosts(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range fileHosts {
+			if previous, ok := definedIn[host.Name]; ok {
+				return nil, fmt.Errorf("host %q is defined in both %s and %s", host.Name, previous, file)
+			}
+			definedIn[host.Name] = file
+			hosts = append(hosts, host)
+		}
+
+		fileGroups, err := ParseGroupVars(file)
+		if err != nil {
+			return nil, err
+		}
+		for group, vars := range fileGroups {
+			if previous, ok := groupIn[group]; ok {
+				return nil, fmt.Errorf("group %q is defined in both %s and %s", group, previous, file)
+			}
+			groupIn[group] = file
+			groupVars[group] = vars
+		}
+	}
+
+	if len(hosts) > common.MaxHosts {
+		return nil, fmt.Errorf("too many hosts (max: %d)", common.MaxHosts)
+	}
+	if err := checkAliases(hosts); err != nil {
+		return nil, err
+	}
+	for i := range hosts {
+		hosts[i].Vars = mergeVars(groupVars[hosts[i].Group], hosts[i].Vars)
+	}
+	return hosts, nil
+}
+
+// checkAliases rejects an alias that is the name or alias of another host
+func checkAliases(hosts []common.Host) error {
+	names := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		names[host.Name] = host.Name
+	}
+	for _, host := range hosts {
+		for _, alias := range host.Aliases {
+			if owner, ok := names[alias]; ok && owner != host.Name {
+				return fmt.Errorf("alias %q of host %s is already a name of host %s", alias, host.Name, owner)
+			}
+			names[alias] = host.Name
+		}
+	}
+	return nil
+}
+
+// mergeVars returns the variables of a group overridden by those of a host,
+// or nil when there are none
+func mergeVars(group, host map[string]string) map[string]string {
+	if len(group) == 0 && len(host) == 0 {
+		return nil
+	}
+	vars := make(map[string]string, len(group)+len(host))
+	for name, value := range group {
+		vars[name] = value
+	}
+	for name, value := range host {
+		vars[name] = value
+	}
+	return vars
+}
+
+// parseIncludes returns the files included by file, resolved relative to it
+func parseIncludes(file string) ([]string, error) {
+	blocks, err := ParseBlocksOfType(file, "include")
+	if err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	for _, block := range blocks {
+		include := block.Name
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		if strings.Contains(include, "..") {
+			return nil, fmt.Errorf("%s: include path contains directory traversal: %s", block.Pos, include)
+		}
+		if _, err := os.Stat(include); errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%s: included file %s does not exist", block.Pos, include)
+		}
+		includes = append(includes, include)
+	}
+	return includes, nil
+}
@@ -0,0 +1,40 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// setLabel validates and records one label
+func setLabel(labels map[string]string, key, val string) error {
+	if !labelKeyPattern.MatchString(key) || len(key) > common.MaxNameLength {
+		return fmt.Errorf("invalid label key: %q", key)
+	}
+	if len(val) > common.MaxNameLength {
+		return fmt.Errorf("label %s value too long", key)
+	}
+	if _, exists := labels[key]; !exists && len(labels) >= common.MaxLabels {
+		return fmt.Errorf("too many labels (max: %d)", common.MaxLabels)
+	}
+	labels[key] = val
+	return nil
+}
+
+// parseLabels reads the nested `labels { ... }` block of block, if any
+func parseLabels(block *Block) (map[string]string, error) {
+	labels := make(map[string]string)
+	child := block.Child("labels")
+	if child == nil {
+		return labels, nil
+	}
+	for _, attr := range child.Attrs {
+		if err := setLabel(labels, attr.Key, attr.Value.String()); err != nil {
+			return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+		}
+	}
+	return labels, nil
+}
@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pos is a position in a .stl file. Columns count characters from 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// SyntaxError is a malformed .stl file
+type SyntaxError struct {
+	Pos     Pos
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNewline
+	tokenWord   // identifier or bare value, e.g. package, 22, true, 0644
+	tokenString // double-quoted string; the token text is unquoted
+	tokenLBrace
+	tokenRBrace
+	tokenLBracket
+	tokenRBracket
+	tokenEquals
+	tokenComma
+)
+
+func (k tokenKind) String() string {
+	switch k {
+	case tokenEOF:
+		return "end of file"
+	case tokenNewline:
+		return "newline"
+	case tokenWord:
+		return "word"
+	case tokenString:
+		return "string"
+	case tokenLBrace:
+		return "'{'"
+	case tokenRBrace:
+		return "'}'"
+	case tokenLBracket:
+		return "'['"
+	case tokenRBracket:
+		return "']'"
+	case tokenEquals:
+		return "'='"
+	case tokenComma:
+		return "','"
+	}
+	return "token"
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  Pos
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokenWord:
+		return fmt.Sprintf("%q", t.text)
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return t.kind.String()
+}
+
+// lexer splits .stl source into tokens. Comments run from # to the end of
+// the line; carriage returns and a leading byte order mark are ignored.
+type lexer struct {
+	src      string
+	file     string
+	off      int
+	line     int
+	col      int
+	comments []comment // comments in source order, kept for formatting
+}
+
+// comment is a # comment; text includes the # but not the line break
+type comment struct {
+	pos  Pos
+	text string
+}
+
+func newLexer(file, src string) *lexer {
+	src = strings.TrimPrefix(src, "\ufeff")
+	return &lexer{src: src, file: file, line: 1, col: 1}
+}
+
+func (l *lexer) pos() Pos {
+	return Pos{File: l.file, Line: l.line, Col: l.col}
+}
+
+func (l *lexer) peek() rune {
+	if l.off >= len(l.src) {
+		return -1
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.off:])
+	return r
+}
+
+func (l *lexer) advance() rune {
+	r, size := utf8.DecodeRuneInString(l.src[l.off:])
+	l.off += size
+	if r == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	return r
+}
+
+func (l *lexer) errorf(pos Pos, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// next returns the next token
+func (l *lexer) next() (token, error) {
+	for {
+		r := l.peek()
+		switch {
+		case r == '#':
+			pos, start := l.pos(), l.off
+			for r := l.peek(); r != -1 && r != '\n'; r = l.peek() {
+				l.advance()
+			}
+			l.comments = append(l.comments, comment{pos: pos, text: strings.TrimRight(l.src[start:l.off], " \t\r")})
+			continue
+		case r == '\n':
+			pos := l.pos()
+			l.advance()
+			return token{kind: tokenNewline, pos: pos}, nil
+		case r != -1 && unicode.IsSpace(r):
+			l.advance()
+			continue
+		}
+		break
+	}
+
+	pos := l.pos()
+	r := l.peek()
+	if r == utf8.RuneError && l.off < len(l.src) {
+		return token{}, l.errorf(pos, "invalid UTF-8")
+	}
+
+	punctuation := map[rune]tokenKind{
+		'{': tokenLBrace,
+		'}': tokenRBrace,
+		'[': tokenLBracket,
+		']': tokenRBracket,
+		'=': tokenEquals,
+		',': tokenComma,
+	}
+	switch {
+	case r == -1:
+		return token{kind: tokenEOF, pos: pos}, nil
+	case r == '"':
+		return l.lexString(pos)
+	case punctuation[r] != 0:
+		l.advance()
+		return token{kind: punctuation[r], text: string(r), pos: pos}, nil
+	}
+	return l.lexWord(pos)
+}
+
+func (l *lexer) lexString(pos Pos) (token, error) {
+	start := l.off
+	l.advance() // opening quote
+	for {
+		switch l.peek() {
+		case -1, '\n':
+			return token{}, l.errorf(pos, "unterminated string")
+		case '\\':
+			l.advance()
+			if r := l.peek(); r == -1 || r == '\n' {
+				return token{}, l.errorf(pos, "unterminated string")
+			}
+			l.advance()
+		case '"':
+			l.advance()
+			raw := l.src[start:l.off]
+			text, err := strconv.Unquote(raw)
+			if err != nil {
+				return token{}, l.errorf(pos, "invalid escape sequence in string %s", raw)
+			}
+			return token{kind: tokenString, text: text, pos: pos}, nil
+		default:
+			l.advance()
+		}
+	}
+}
+
+// lexWord reads a bare word. ${...} references are part of the word even
+// though they contain braces.
+func (l *lexer) lexWord(pos Pos) (token, error) {
+	start := l.off
+	for {
+		r := l.peek()
+		if r == '$' && strings.HasPrefix(l.src[l.off:], "${") {
+			end := strings.IndexAny(l.src[l.off:], "}\n")
+			if end < 0 || l.src[l.off+end] != '}' {
+				return token{}, l.errorf(l.pos(), "unterminated ${ reference")
+			}
+			for stop := l.off + end + 1; l.off < stop; {
+				l.advance()
+			}
+			continue
+		}
+		if r == -1 || unicode.IsSpace(r) || strings.ContainsRune("{}[]=,#\"", r) {
+			break
+		}
+		l.advance()
+	}
+	// A comment must be separated from a bare value, or abc#123 would
+	// silently become abc
+	if l.peek() == '#' {
+		return token{}, l.errorf(l.pos(), "'#' in unquoted value %s; quote the value or put a space before the comment", l.src[start:l.off])
+	}
+	return token{kind: tokenWord, text: l.src[start:l.off], pos: pos}, nil
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseLimits reads `limits { ... }` blast-radius limits from path. A block
+// without a group limits the whole project; at most one block may name each
+// group.
+func ParseLimits(path string) ([]common.Limits, error) {
+	blocks, err := ParseBlocksOfType(path, "limits")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []common.Limits
+	seen := make(map[string]*Block)
+	for _, block := range blocks {
+		limits := common.Limits{MaxChanges: -1, MaxDeletes: -1, MaxHostsChanged: -1}
+		limits.Group, _ = block.Attr("group")
+		if previous, ok := seen[limits.Group]; ok {
+			return nil, fmt.Errorf("%s: limits for group %q are already set at %s", block.Pos, limits.Group, previous.Pos)
+		}
+		seen[limits.Group] = block
+
+		for _, limit := range []struct {
+			key   string
+			value *int
+		}{
+			{"max_changes", &limits.MaxChanges},
+			{"max_deletes", &limits.MaxDeletes},
+			{"max_hosts_changed", &limits.MaxHostsChanged},
+		} {
+			attr := block.Attribute(limit.key)
+			if attr == nil {
+				continue
+			}
+			n, err := strconv.Atoi(attr.Value.Str)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: limits: %s must be a non-negative integer, found %q", attr.Value.Pos, limit.key, attr.Value.Str)
+			}
+			*limit.value = n
+		}
+
+		result = append(result, limits)
+	}
+
+	return result, nil
+}
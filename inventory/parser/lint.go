@@ -0,0 +1,23 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// ParseLintRules reads the rules disabled by the `lint { disable = [...] }`
+// block of path
+func ParseLintRules(path string) ([]string, error) {
+	blocks, err := ParseBlocksOfType(path, "lint")
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	if len(blocks) > 1 {
+		return nil, fmt.Errorf("%s: only one lint block is allowed", blocks[1].Pos)
+	}
+
+	disabled, _ := blocks[0].List("disable")
+	return disabled, nil
+}
@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseMeshes reads `mesh "name" { ... }` mesh network lookups from path
+func ParseMeshes(path string) ([]common.Mesh, error) {
+	blocks, err := ParseBlocksOfType(path, "mesh")
+	if err != nil {
+		return nil, err
+	}
+
+	var meshes []common.Mesh
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: mesh name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("mesh name too long: %s", block.Name)
+		}
+
+		mesh := common.Mesh{Name: block.Name}
+		mesh.Type, _ = block.Attr("type")
+		mesh.Tailnet, _ = block.Attr("tailnet")
+		mesh.Target.Hosts, _ = block.List("hosts")
+		mesh.Target.Group, _ = block.Attr("group")
+
+		switch mesh.Type {
+		case common.MeshTailscale:
+		case "":
+			return nil, fmt.Errorf("%s: mesh %s: type is required", block.Pos, block.Name)
+		default:
+			return nil, fmt.Errorf("%s: mesh %s: unsupported type %q", block.Pos, block.Name, mesh.Type)
+		}
+
+		meshes = append(meshes, mesh)
+	}
+
+	return meshes, nil
+}
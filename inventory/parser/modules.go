@@ -0,0 +1,306 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+var moduleNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// ModuleContents holds the resources and outputs of a loaded module
+type ModuleContents struct {
+	Packages []common.Package
+	Files    []common.File
+	Services []common.Service
+	Units    []common.SystemdUnit
+	Provided []common.ProviderResource // resources of types implemented by providers
+	Outputs  map[string]string         // output values by name
+}
+
+// ParseModules reads `module "name" { ... }` blocks from path. Sources are
+// resolved relative to the directory of path.
+func ParseModules(path string) ([]common.Module, error) {
+	blocks, err := ParseBlocksOfType(path, "module")
+	if err != nil {
+		return nil, err
+	}
+
+	var modules []common.Module
+	for _, block := range blocks {
+		if !moduleNameRegex.MatchString(block.Name) {
+			return nil, fmt.Errorf("%s: invalid module name %q (letters, digits, - and _ only)", block.Pos, block.Name)
+		}
+
+		module := common.Module{Name: block.Name, Vars: make(map[string]string)}
+		source, ok := block.Attr("source")
+		if !ok || source == "" {
+			return nil, fmt.Errorf("module %s: source is required", block.Name)
+		}
+		if filepath.IsAbs(source) || strings.Contains(source, "..") {
+			return nil, fmt.Errorf("module %s: source must be a directory inside the project: %s", block.Name, source)
+		}
+		module.Source = filepath.Join(filepath.Dir(path), source)
+
+		vars, _, err := block.Map("vars")
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", block.Name, err)
+		}
+		for key, value := range vars {
+			module.Vars[key] = value
+		}
+		if child := block.Child("vars"); child != nil {
+			for _, attr := range child.Attrs {
+				module.Vars[attr.Key] = attr.Value.String()
+			}
+		}
+
+		modules = append(modules, module)
+	}
+
+	return modules, nil
+}
+
+// ModuleFiles returns the .stl files of a module's source directory
+func ModuleFiles(module common.Module) ([]string, error) {
+	info, err := os.Stat(module.Source)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("module %s: source %s is not a directory", module.Name, module.Source)
+	}
+	files, err := filepath.Glob(filepath.Join(module.Source, "*.stl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// LoadModule parses the resource files of a module with its variables
+// substituted for ${var.name}. Variables are declared in the module with
+// `variable "name" { default = "..." }`; those without a default must be set.
+// Outputs are declared with `output "name" { value = "..." }`. Resources are
+// marked with the module name, which namespaces their IDs.
+func LoadModule(module common.Module) (*ModuleContents, error) {
+	files, err := ModuleFiles(module)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := make(map[string]string, len(files))
+	declared := make(map[string]*string)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		if len(data) > common.MaxFileSize {
+			return nil, fmt.Errorf("module %s: %s is too large", module.Name, file)
+		}
+		texts[file] = string(data)
+
+		blocks, err := ParseSource(file, strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		if nested := BlocksOfType(blocks, "module"); len(nested) > 0 {
+			return nil, fmt.Errorf("module %s: %s: nested module %s is not supported", module.Name, file, nested[0].Name)
+		}
+		for _, block := range BlocksOfType(blocks, "variable") {
+			if _, ok := declared[block.Name]; ok {
+				return nil, fmt.Errorf("module %s: variable %s is declared twice", module.Name, block.Name)
+			}
+			if value, ok := block.Attr("default"); ok {
+				declared[block.Name] = &value
+			} else {
+				declared[block.Name] = nil
+			}
+		}
+	}
+
+	vars := make(map[string]string)
+	for name, value := range module.Vars {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("module %s: unknown variable %s", module.Name, name)
+		}
+		vars[name] = value
+	}
+	for name, value := range declared {
+		if _, ok := vars[name]; ok {
+			continue
+		}
+		if value == nil {
+			return nil, fmt.Errorf("module %s: variable %s is required", module.Name, name)
+		}
+		vars[name] = *value
+	}
+
+	contents := &ModuleContents{Outputs: make(map[string]string)}
+	for _, file := range files {
+		text, err := Interpolate(texts[file], "var", vars)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %s: %w", module.Name, file, err)
+		}
+
+		blocks, err := ParseSource(file, strings.NewReader(text))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		blocks, err = ExpandBlocks(blocks, vars)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+
+		packages, err := PackagesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, pkg := range packages {
+			pkg.Module = module.Name
+			contents.Packages = append(contents.Packages, pkg)
+		}
+
+		moduleFiles, err := FilesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, moduleFile := range moduleFiles {
+			moduleFile.Module = module.Name
+			contents.Files = append(contents.Files, moduleFile)
+		}
+
+		services, err := ServicesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, service := range services {
+			service.Module = module.Name
+			contents.Services = append(contents.Services, service)
+		}
+
+		units, err := SystemdUnitsFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, unit := range units {
+			unit.Module = module.Name
+			contents.Units = append(contents.Units, unit)
+		}
+
+		provided, err := ProviderResourcesFromBlocks(blocks)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, resource := range provided {
+			resource.Module = module.Name
+			contents.Provided = append(contents.Provided, resource)
+		}
+
+		for _, block := range BlocksOfType(blocks, "output") {
+			value, ok := block.Attr("value")
+			if !ok {
+				return nil, fmt.Errorf("module %s: output %s needs a value", module.Name, block.Name)
+			}
+			contents.Outputs[block.Name] = value
+		}
+	}
+
+	return contents, nil
+}
+
+// UnusedVariables returns the variables a module declares but none of its
+// files reference as ${var.name}, sorted by name
+func UnusedVariables(module common.Module) ([]string, error) {
+	files, err := ModuleFiles(module)
+	if err != nil {
+		return nil, err
+	}
+
+	declared := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		blocks, err := ParseSource(file, strings.NewReader(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", module.Name, err)
+		}
+		for _, block := range BlocksOfType(blocks, "variable") {
+			declared[block.Name] = true
+		}
+		for _, match := range referenceRegex.FindAllStringSubmatch(string(data), -1) {
+			if match[1] == "var" {
+				referenced[match[2]] = true
+			}
+		}
+		// count = var.name and for_each = var.name
+		for _, block := range blocks {
+			for _, key := range []string{"count", "for_each"} {
+				if value, ok := block.Attr(key); ok && strings.HasPrefix(value, "var.") {
+					referenced[strings.TrimPrefix(value, "var.")] = true
+				}
+			}
+		}
+	}
+
+	var unused []string
+	for name := range declared {
+		if !referenced[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused, nil
+}
+
+var referenceRegex = regexp.MustCompile(`\$\{([a-z]+)\.([a-zA-Z0-9_.-]+)\}`)
+
+// Interpolate replaces every ${prefix.name} reference in text with
+// values[name]. References with other prefixes, such as ${secret.name}, are
+// left alone; an undefined name is an error. Values may not contain quotes or
+// newlines, which would change the structure of the file.
+func Interpolate(text, prefix string, values map[string]string) (string, error) {
+	var err error
+	result := referenceRegex.ReplaceAllStringFunc(text, func(reference string) string {
+		match := referenceRegex.FindStringSubmatch(reference)
+		if match[1] != prefix || err != nil {
+			return reference
+		}
+		value, ok := values[match[2]]
+		if !ok {
+			err = fmt.Errorf("undefined reference %s", reference)
+			return reference
+		}
+		if strings.ContainsAny(value, "\"\n") {
+			err = fmt.Errorf("value of %s may not contain quotes or newlines", reference)
+			return reference
+		}
+		return value
+	})
+	return result, err
+}
+
+// splitOutsideQuotes splits s at every sep that is not inside double quotes
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuotes:
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseNotifications reads `notification "name" { ... }` blocks from path
+func ParseNotifications(path string) ([]common.Notification, error) {
+	blocks, err := ParseBlocksOfType(path, "notification")
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []common.Notification
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: notification name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("notification name too long: %s", block.Name)
+		}
+
+		notification := common.Notification{Name: block.Name, Format: "webhook"}
+
+		rawURL, ok := block.Attr("url")
+		if !ok {
+			return nil, fmt.Errorf("notification %s: url is required", block.Name)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("notification %s: url must be http or https: %s", block.Name, rawURL)
+		}
+		notification.URL = rawURL
+
+		if val, ok := block.Attr("format"); ok {
+			if val != "webhook" && val != "slack" {
+				return nil, fmt.Errorf("notification %s: unsupported format %s (expected webhook or slack)", block.Name, val)
+			}
+			notification.Format = val
+		}
+		notification.Events, _ = block.List("events")
+		if val, ok := block.Attr("template"); ok {
+			notification.Template = val
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, nil
+}
@@ -1,12 +1,15 @@
 package parser
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/secrets"
 )
 
 func ParsePackages(path string) ([]common.Package, error) {
@@ -20,69 +23,211 @@ func ParsePackages(path string) ([]common.Package, error) {
 	}
 	defer file.Close()
 
-	var packages []common.Package
-	var pkg common.Package
+	return ParsePackagesFrom(file)
+}
+
+// ParsePackagesFrom reads `package "name" { ... }` blocks from r
+func ParsePackagesFrom(r io.Reader) ([]common.Package, error) {
+	blocks, err := ParseBlocksFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return PackagesFromBlocks(blocks)
+}
 
-	scanner := bufio.NewScanner(file)
-	buf := make([]byte, 0, common.MaxFileSize)
-	scanner.Buffer(buf, common.MaxFileSize)
+// PackagesFromBlocks builds the packages of the `package` blocks in blocks
+func PackagesFromBlocks(blocks []*Block) ([]common.Package, error) {
+	packageBlocks := BlocksOfType(blocks, "package")
+	if len(packageBlocks) > common.MaxHosts {
+		return nil, fmt.Errorf("too many packages (max: %d)", common.MaxHosts)
+	}
 
-	lineCount := 0
-	for scanner.Scan() {
-		lineCount++
-		if lineCount > common.MaxFileSize/100 {
-			return nil, fmt.Errorf("too many lines in file")
+	var packages []common.Package
+	for _, block := range packageBlocks {
+		pkg := common.Package{Name: block.Name, Labels: make(map[string]string)}
+		if pkg.Name == "" {
+			return nil, fmt.Errorf("%s: package name cannot be empty", block.Pos)
 		}
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+		if len(pkg.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("%s: package name too long: %s", block.Pos, pkg.Name)
+		}
+		if err := common.CheckName(pkg.Name); err != nil {
+			return nil, fmt.Errorf("%s: invalid package name: %w", block.Pos, err)
 		}
 
-		if strings.HasPrefix(line, "package ") {
-			if pkg.Name != "" {
-				packages = append(packages, pkg)
-				pkg = common.Package{}
-			}
-
-			if len(packages) >= common.MaxHosts {
-				return nil, fmt.Errorf("too many packages (max: %d)", common.MaxHosts)
-			}
-
-			line = strings.TrimSuffix(line, "{")
-			line = strings.TrimSpace(line)
-			parts := strings.Split(line, "\"")
-			if len(parts) >= 2 {
-				pkgName := parts[1]
-				if len(pkgName) > common.MaxNameLength {
-					return nil, fmt.Errorf("package name too long: %s", pkgName)
-				}
-				pkg.Name = pkgName
-			}
-		} else if strings.Contains(line, "=") {
-			parts := strings.SplitN(line, "=", 2)
-
-			if len(parts) != 2 {
-				continue
+		for _, attr := range block.Attrs {
+			var val string
+			switch attr.Key {
+			case "version", "manager", "timeout", "interval", "group", "on_drift", "hold", "purge", "autoremove", "cache_valid_time", "conffiles", "channel", "classic", "remote", "remote_url", common.LabelOwner:
+				var err error
+				if val, err = scalar(attr, "package "+pkg.Name); err != nil {
+					return nil, err
+				}
+			case "hosts":
+				if attr.Value.Kind == MapValue {
+					return nil, fmt.Errorf("%s: hosts in package %s must be a list, found a map", attr.Value.Pos, pkg.Name)
+				}
 			}
-
-			key := strings.TrimSpace(parts[0])
-			val := strings.Trim(strings.TrimSpace(parts[1]), "\"")
-			switch key {
+			switch attr.Key {
 			case "version":
-				//TODO: validate version format
+				// Versions with secret references are checked once the
+				// references are expanded
+				if secrets.HasReferences(val) {
+					pkg.Version = val
+					break
+				}
+				if err := common.CheckName(val); err != nil {
+					return nil, fmt.Errorf("%s: invalid version in package %s: %w", attr.Pos, pkg.Name, err)
+				}
 				pkg.Version = val
 			case "manager":
 				//TODO: validate package managers
 				pkg.Manager = val
+			case "timeout":
+				timeout, err := time.ParseDuration(val)
+				if err != nil || timeout < 0 {
+					return nil, fmt.Errorf("%s: invalid timeout in package %s: %s", attr.Pos, pkg.Name, val)
+				}
+				pkg.Timeout = timeout
+			case "interval":
+				interval, err := time.ParseDuration(val)
+				if err != nil || interval < common.MinInterval {
+					return nil, fmt.Errorf("%s: invalid interval in package %s: %s (minimum %v)", attr.Pos, pkg.Name, val, common.MinInterval)
+				}
+				pkg.Interval = interval
+			case "cache_valid_time":
+				valid, err := time.ParseDuration(val)
+				if err != nil || valid <= 0 {
+					return nil, fmt.Errorf("%s: invalid cache_valid_time in package %s: %s", attr.Pos, pkg.Name, val)
+				}
+				pkg.CacheValidTime = valid
+			case "conffiles":
+				if val != common.ConffilesKeep && val != common.ConffilesReplace {
+					return nil, fmt.Errorf("%s: invalid conffiles in package %s: %s (expected %s or %s)", attr.Pos, pkg.Name, val, common.ConffilesKeep, common.ConffilesReplace)
+				}
+				pkg.Conffiles = val
+			case "hosts":
+				pkg.Hosts, _ = block.List("hosts")
+			case "when":
+				when, err := parseWhen(block)
+				if err != nil {
+					return nil, err
+				}
+				pkg.When = when
+			case "group":
+				if len(val) > common.MaxNameLength {
+					return nil, fmt.Errorf("%s: group name too long in package %s", attr.Pos, pkg.Name)
+				}
+				pkg.Group = val
+			case "sensitive":
+				sensitive, err := parseSensitive(attr.Value)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+				}
+				pkg.Sensitive = sensitive
+			case "on_drift":
+				pkg.OnDrift = val
+			case "channel", "remote":
+				if err := common.CheckName(val); err != nil {
+					return nil, fmt.Errorf("%s: invalid %s in package %s: %w", attr.Pos, attr.Key, pkg.Name, err)
+				}
+				if attr.Key == "channel" {
+					pkg.Channel = val
+				} else {
+					pkg.Remote = val
+				}
+			case "remote_url":
+				pkg.RemoteURL = val
+			case "hold", "purge", "autoremove", "classic":
+				enabled, err := strconv.ParseBool(val)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid %s in package %s: %s (expected true or false)", attr.Pos, attr.Key, pkg.Name, val)
+				}
+				switch attr.Key {
+				case "hold":
+					pkg.Hold = enabled
+				case "purge":
+					pkg.Purge = enabled
+				case "classic":
+					pkg.Classic = enabled
+				default:
+					pkg.Autoremove = enabled
+				}
+			case common.LabelOwner:
+				if err := setLabel(pkg.Labels, common.LabelOwner, val); err != nil {
+					return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+				}
 			}
 		}
-	}
-	if pkg.Name != "" {
+
+		if err := checkManagerOptions(pkg); err != nil {
+			return nil, fmt.Errorf("%s: %w", block.Pos, err)
+		}
+
+		labels, err := parseLabels(block)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg.Name, err)
+		}
+		for key, val := range labels {
+			if err := setLabel(pkg.Labels, key, val); err != nil {
+				return nil, fmt.Errorf("package %s: %w", pkg.Name, err)
+			}
+		}
+		hooks, err := parseHooks(block)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg.Name, err)
+		}
+		pkg.Hooks = hooks
+
 		packages = append(packages, pkg)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading file: %w", err)
-	}
 
 	return packages, nil
 }
+
+// checkManagerOptions rejects options the manager of pkg does not take
+func checkManagerOptions(pkg common.Package) error {
+	only := func(option string, set bool, managers ...string) error {
+		if !set {
+			return nil
+		}
+		for _, manager := range managers {
+			if pkg.Manager == manager {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s in package %s is only supported by %s", option, pkg.Name, strings.Join(managers, ", "))
+	}
+	if err := only("channel", pkg.Channel != "", common.PackageManagerSnap, common.PackageManagerFlatpak); err != nil {
+		return err
+	}
+	if err := only("classic", pkg.Classic, common.PackageManagerSnap); err != nil {
+		return err
+	}
+	if err := only("remote", pkg.Remote != "", common.PackageManagerFlatpak); err != nil {
+		return err
+	}
+	if err := only("remote_url", pkg.RemoteURL != "", common.PackageManagerFlatpak); err != nil {
+		return err
+	}
+	if err := only("cache_valid_time", pkg.CacheValidTime != 0, common.PackageManagerAPT); err != nil {
+		return err
+	}
+	if err := only("conffiles", pkg.Conffiles != "", common.PackageManagerAPT); err != nil {
+		return err
+	}
+	if err := only("hold", pkg.Hold, common.PackageManagerAPT, common.PackageManagerSnap); err != nil {
+		return err
+	}
+	if err := only("purge", pkg.Purge, common.PackageManagerAPT, common.PackageManagerSnap, common.PackageManagerFlatpak); err != nil {
+		return err
+	}
+	if err := only("autoremove", pkg.Autoremove, common.PackageManagerAPT, common.PackageManagerFlatpak); err != nil {
+		return err
+	}
+	pinned := pkg.Version != "" && pkg.Version != "latest"
+	if pinned && (pkg.Manager == common.PackageManagerSnap || pkg.Manager == common.PackageManagerFlatpak) {
+		return fmt.Errorf("%s cannot pin the version of package %s; pin a channel instead", pkg.Manager, pkg.Name)
+	}
+	return nil
+}
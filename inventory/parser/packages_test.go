@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePackagesVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		wantErr bool
+	}{
+		{name: "plain", version: "1.24.0-1"},
+		{name: "secret", version: "${secret.nginx_version}"},
+		{name: "secret with a suffix", version: "${secret.nginx_version}-1"},
+		{name: "metacharacter", version: "1.24;reboot", wantErr: true},
+		{name: "metacharacter next to a secret is checked after expansion", version: "${secret.nginx_version};reboot"},
+		{name: "option", version: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "packages.stl")
+			src := "package \"nginx\" {\n  version = \"" + tt.version + "\"\n}\n"
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			packages, err := ParsePackages(path)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "invalid version") {
+					t.Fatalf("error = %v, want an invalid version error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(packages) != 1 || packages[0].Version != tt.version {
+				t.Fatalf("packages = %+v, want version %q", packages, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package parser
+
+import (
+	"fmt"
+)
+
+// maxNesting bounds how deeply blocks may be nested
+const maxNesting = 32
+
+// blockParser is a recursive-descent parser over lexer tokens:
+//
+//	file      = { statement } EOF
+//	statement = "include" label
+//	          | word "=" value
+//	          | word [ label ] "{" { statement } "}"
+//	label     = string | word
+//	value     = string | word | "[" [ value { "," value } [ "," ] ] "]"
+//	          | "{" { word "=" value [ "," ] } "}"
+//
+// Statements end at a newline or, inside a block, at its closing brace;
+// newlines inside lists and maps are insignificant.
+type blockParser struct {
+	lexer *lexer
+	tok   token
+	depth int
+}
+
+func (p *blockParser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *blockParser) errorf(pos Pos, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *blockParser) unexpected(context string) error {
+	return p.errorf(p.tok.pos, "unexpected %s %s", p.tok.describe(), context)
+}
+
+func (p *blockParser) skipNewlines() error {
+	for p.tok.kind == tokenNewline {
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *blockParser) expect(kind tokenKind, context string) (token, error) {
+	tok := p.tok
+	if tok.kind != kind {
+		return tok, p.errorf(tok.pos, "expected %s %s, found %s", kind, context, tok.describe())
+	}
+	return tok, p.advance()
+}
+
+func (p *blockParser) parseFile() ([]*Block, error) {
+	var blocks []*Block
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenEOF {
+			return blocks, nil
+		}
+		if p.tok.kind != tokenWord {
+			return nil, p.unexpected("at top level")
+		}
+
+		start := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenEquals {
+			return nil, p.errorf(start.pos, "attribute %s outside of a block", start.text)
+		}
+		block, err := p.parseBlock(start, true)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+// parseBlock parses the rest of a block whose type word has been consumed
+func (p *blockParser) parseBlock(start token, topLevel bool) (*Block, error) {
+	block := &Block{
+		Type:       start.text,
+		Attributes: make(map[string]string),
+		Line:       start.pos.Line,
+		Pos:        start.pos,
+	}
+
+	if p.tok.kind == tokenString || p.tok.kind == tokenWord {
+		block.Name = p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenString || p.tok.kind == tokenWord {
+			return nil, p.errorf(p.tok.pos, "block %s %q has more than one label", block.Type, block.Name)
+		}
+	}
+
+	if topLevel && block.Type == "include" {
+		if block.Name == "" {
+			return nil, p.errorf(start.pos, "include needs a path")
+		}
+		block.End = block.Pos
+		return block, p.endStatement(false)
+	}
+
+	// The opening brace of a top-level block may be on the line after the
+	// header; anything else after a key within a block is a missing '='
+	if !topLevel && p.tok.kind != tokenLBrace {
+		return nil, p.errorf(p.tok.pos, "expected '=' or '{' after %s, found %s", block.Type, p.tok.describe())
+	}
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(tokenLBrace, fmt.Sprintf("to open %s block", block.Type)); err != nil {
+		return nil, err
+	}
+
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxNesting {
+		return nil, p.errorf(start.pos, "blocks nested too deeply (max: %d)", maxNesting)
+	}
+
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		switch p.tok.kind {
+		case tokenRBrace:
+			block.End = p.tok.pos
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			return block, p.endStatement(!topLevel)
+		case tokenEOF:
+			return nil, p.errorf(start.pos, "unclosed block %s %q", block.Type, block.Name)
+		case tokenWord:
+		default:
+			return nil, p.unexpected(fmt.Sprintf("in %s block", block.Type))
+		}
+
+		key := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenEquals {
+			child, err := p.parseBlock(key, false)
+			if err != nil {
+				return nil, err
+			}
+			block.Blocks = append(block.Blocks, child)
+			continue
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if previous := block.Attribute(key.text); previous != nil {
+			return nil, p.errorf(key.pos, "attribute %s is already set at %s", key.text, previous.Pos)
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenEquals && value.Kind == StringValue && !value.Quoted {
+			return nil, p.errorf(p.tok.pos, "unexpected '=' after %s = %s; quote values containing '='", key.text, value.Str)
+		}
+		block.Attrs = append(block.Attrs, &Attribute{Key: key.text, Value: value, Pos: key.pos})
+		block.Attributes[key.text] = value.String()
+
+		if err := p.endStatement(true); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// endStatement requires a newline or end of file after a statement, or,
+// when inBlock, the closing brace of the enclosing block
+func (p *blockParser) endStatement(inBlock bool) error {
+	switch {
+	case p.tok.kind == tokenNewline:
+		return p.advance()
+	case p.tok.kind == tokenEOF:
+		return nil
+	case inBlock && p.tok.kind == tokenRBrace:
+		return nil
+	}
+	return p.errorf(p.tok.pos, "expected newline, found %s", p.tok.describe())
+}
+
+func (p *blockParser) parseValue() (*Value, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenString, tokenWord:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		return &Value{Kind: StringValue, Str: tok.text, Quoted: tok.kind == tokenString, Pos: tok.pos, End: tok.pos}, nil
+	case tokenLBracket:
+		return p.parseList()
+	case tokenLBrace:
+		return p.parseMap()
+	}
+	return nil, p.errorf(tok.pos, "expected a value, found %s", tok.describe())
+}
+
+func (p *blockParser) parseList() (*Value, error) {
+	list := &Value{Kind: ListValue, Pos: p.tok.pos}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokenRBracket {
+			list.End = p.tok.pos
+			return list, p.advance()
+		}
+		if p.tok.kind != tokenString && p.tok.kind != tokenWord {
+			if p.tok.kind == tokenEOF {
+				return nil, p.errorf(list.Pos, "unclosed list")
+			}
+			return nil, p.unexpected("in list")
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		list.List = append(list.List, item)
+
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		switch p.tok.kind {
+		case tokenComma:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokenRBracket:
+		case tokenEOF:
+			return nil, p.errorf(list.Pos, "unclosed list")
+		default:
+			return nil, p.errorf(p.tok.pos, "expected ',' or ']' in list, found %s", p.tok.describe())
+		}
+	}
+}
+
+func (p *blockParser) parseMap() (*Value, error) {
+	m := &Value{Kind: MapValue, Pos: p.tok.pos}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+		switch p.tok.kind {
+		case tokenRBrace:
+			m.End = p.tok.pos
+			return m, p.advance()
+		case tokenEOF:
+			return nil, p.errorf(m.Pos, "unclosed map")
+		case tokenWord, tokenString:
+		default:
+			return nil, p.unexpected("in map")
+		}
+
+		key := p.tok
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenEquals, fmt.Sprintf("after map key %s", key.text)); err != nil {
+			return nil, err
+		}
+		for _, entry := range m.Map {
+			if entry.Key == key.text {
+				return nil, p.errorf(key.pos, "map key %s is already set at %s", key.text, entry.Pos)
+			}
+		}
+		if p.tok.kind != tokenString && p.tok.kind != tokenWord {
+			return nil, p.errorf(p.tok.pos, "expected a string for map key %s, found %s", key.text, p.tok.describe())
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		m.Map = append(m.Map, &Attribute{Key: key.text, Value: value, Pos: key.pos})
+
+		switch p.tok.kind {
+		case tokenComma:
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		case tokenNewline, tokenRBrace:
+		default:
+			return nil, p.errorf(p.tok.pos, "expected ',' or '}' in map, found %s", p.tok.describe())
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, src string) ([]*Block, error) {
+	t.Helper()
+	return ParseSource("test.stl", strings.NewReader(src))
+}
+
+func TestParseQuirks(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "unquoted equals in value",
+			src:     "host \"web\" {\n  hostname = a=b\n}\n",
+			wantErr: "quote values containing '='",
+		},
+		{
+			name: "quoted equals in value",
+			src:  "host \"web\" {\n  hostname = \"a=b\"\n}\n",
+		},
+		{
+			name:    "hash in unquoted value",
+			src:     "host \"web\" {\n  hostname = abc#123\n}\n",
+			wantErr: "'#' in unquoted value abc",
+		},
+		{
+			name: "comment after unquoted value",
+			src:  "host \"web\" {\n  hostname = abc # the web server\n}\n",
+		},
+		{
+			name: "nested block closed with its parent",
+			src:  "file \"/etc/motd\" {\n  labels { team = \"web\" } }\n",
+		},
+		{
+			name:    "top-level block followed by a statement",
+			src:     "host \"a\" {\n} host \"b\" {\n}\n",
+			wantErr: "expected newline",
+		},
+		{
+			name: "CRLF line endings",
+			src:  "host \"web\" {\r\n  hostname = \"10.0.0.1\"\r\n  port     = 22\r\n}\r\n",
+		},
+		{
+			name: "byte order mark",
+			src:  "\ufeffhost \"web\" {\n  hostname = \"10.0.0.1\"\n}\n",
+		},
+		{
+			name:    "unclosed block",
+			src:     "host \"web\" {\n  hostname = \"10.0.0.1\"\n",
+			wantErr: "unclosed block",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseString(t, tt.src)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseCRLFAndBOMMatchPlainSource(t *testing.T) {
+	plain := "host \"web\" {\n  hostname = \"10.0.0.1\"\n  vars {\n    role = \"web\"\n  }\n}\n"
+	want, err := parseString(t, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, src := range []string{
+		strings.ReplaceAll(plain, "\n", "\r\n"),
+		"\ufeff" + plain,
+	} {
+		got, err := parseString(t, src)
+		if err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		if !reflect.DeepEqual(blockValues(got), blockValues(want)) {
+			t.Errorf("%q parsed as %v, want %v", src, blockValues(got), blockValues(want))
+		}
+	}
+}
+
+func TestParseNestedBlocks(t *testing.T) {
+	blocks, err := parseString(t, "file \"/etc/motd\" {\n  labels { team = \"web\" } }\nfile \"/etc/issue\" {\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want 2", len(blocks))
+	}
+	labels := blocks[0].Child("labels")
+	if labels == nil {
+		t.Fatal("labels block missing")
+	}
+	if team, _ := labels.Attr("team"); team != "web" {
+		t.Errorf("team = %q, want web", team)
+	}
+}
+
+func TestParseHostsRejectsDuplicates(t *testing.T) {
+	path := writeHosts(t, "host \"web\" {\n  hostname = \"10.0.0.1\"\n}\nhost \"web\" {\n  hostname = \"10.0.0.2\"\n}\n")
+	if _, err := ParseHosts(path); err == nil || !strings.Contains(err.Error(), "already defined") {
+		t.Fatalf("error = %v, want a duplicate host error", err)
+	}
+}
+
+func TestParseHostsRejectsListValues(t *testing.T) {
+	path := writeHosts(t, "host \"web\" {\n  hostname = [\"a\", \"b\"]\n}\n")
+	if _, err := ParseHosts(path); err == nil || !strings.Contains(err.Error(), "must be a single value") {
+		t.Fatalf("error = %v, want a single value error", err)
+	}
+}
+
+// FuzzParseSource checks that the parser never panics and that whatever it
+// accepts is formatted to source that parses to the same blocks
+func FuzzParseSource(f *testing.F) {
+	for _, seed := range []string{
+		"host \"web\" {\n  hostname = \"10.0.0.1\"\n  port = 22\n}\n",
+		"package \"nginx\" {\n  version = \"1.2\"\n  hosts = [\"web\", \"db\"]\n}\n",
+		"file \"/etc/motd\" {\n  content = \"hi\\n\"\n  labels { team = \"web\" } }\n",
+		"host \"web\" {\r\n  vars = { role = \"web\" }\r\n}\r\n",
+		"\ufeff# comment\nsettings {\n}\n",
+		"host \"web\" {\n  hostname = a=b\n}\n",
+		"host \"web\" {\n  hostname = abc#123\n}\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		blocks, err := parseString(t, src)
+		if err != nil {
+			return
+		}
+		formatted, err := Format("test.stl", []byte(src))
+		if err != nil {
+			t.Fatalf("parsed but failed to format: %v", err)
+		}
+		reparsed, err := parseString(t, string(formatted))
+		if err != nil {
+			t.Fatalf("formatted source does not parse: %v\n%s", err, formatted)
+		}
+		if !reflect.DeepEqual(blockValues(reparsed), blockValues(blocks)) {
+			t.Fatalf("formatting changed the blocks:\n%v\n%v", blockValues(blocks), blockValues(reparsed))
+		}
+	})
+}
+
+// FuzzParseHosts checks that parsing an inventory never panics and that
+// accepted hosts have a name
+func FuzzParseHosts(f *testing.F) {
+	for _, seed := range []string{
+		"host \"web\" {\n  hostname = \"10.0.0.1\"\n  port = 22\n  user = \"deploy\"\n}\n",
+		"host \"db\" {\n  hostname = \"db.internal\"\n  vars {\n    role = \"db\"\n  }\n}\n",
+		"host \"web\" {\n  hostname = [\"a\"]\n}\n",
+		"host \"local\" {\n  transport = \"local\"\n}\n",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		hosts, err := ParseHosts(writeHosts(t, src))
+		if err != nil {
+			return
+		}
+		for _, host := range hosts {
+			if host.Name == "" {
+				t.Fatalf("accepted a host without a name: %+v", host)
+			}
+		}
+	})
+}
+
+func writeHosts(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts.stl")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// blockValues flattens blocks to their types, names and attribute values,
+// leaving out positions
+func blockValues(blocks []*Block) []string {
+	var values []string
+	for _, block := range blocks {
+		values = append(values, block.Type+" "+block.Name+" {")
+		for _, attr := range block.Attrs {
+			values = append(values, attr.Key+" = "+attr.Value.String())
+		}
+		values = append(values, blockValues(block.Blocks)...)
+		values = append(values, "}")
+	}
+	return values
+}
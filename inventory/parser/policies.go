@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// policyActions are the actions a policy may match
+var policyActions = map[string]bool{"create": true, "update": true, "delete": true}
+
+// ParsePolicies reads `policy "name" { ... }` blocks from path
+func ParsePolicies(path string) ([]common.Policy, error) {
+	blocks, err := ParseBlocksOfType(path, "policy")
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []common.Policy
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: policy name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("policy name too long: %s", block.Name)
+		}
+		if seen[block.Name] {
+			return nil, fmt.Errorf("%s: policy %s is declared twice", block.Pos, block.Name)
+		}
+		seen[block.Name] = true
+
+		policy := common.Policy{Name: block.Name, Effect: common.PolicyDeny, MaxMode: -1}
+		if val, ok := block.Attr("effect"); ok {
+			if val != common.PolicyDeny && val != common.PolicyWarn {
+				return nil, fmt.Errorf("%s: policy %s: effect must be %s or %s, found %q", block.Pos, block.Name, common.PolicyDeny, common.PolicyWarn, val)
+			}
+			policy.Effect = val
+		}
+		policy.Message, _ = block.Attr("message")
+		policy.Command, _ = block.Attr("command")
+		policy.Projects, _ = block.List("projects")
+		policy.Actions, _ = block.List("actions")
+		for _, action := range policy.Actions {
+			if !policyActions[action] {
+				return nil, fmt.Errorf("%s: policy %s: unknown action %q (expected create, update or delete)", block.Pos, block.Name, action)
+			}
+		}
+		policy.Types, _ = block.List("types")
+		policy.Resources, _ = block.List("resources")
+		policy.Target.Hosts, _ = block.List("hosts")
+		policy.Target.Group, _ = block.Attr("group")
+		if _, ok := block.Attr("max_mode"); ok {
+			mode, err := parseModeAttr(block, "max_mode", 07777)
+			if err != nil {
+				return nil, fmt.Errorf("%s: policy %s: %w", block.Pos, block.Name, err)
+			}
+			policy.MaxMode = mode
+		}
+		if policy.Command != "" && (len(policy.Actions) > 0 || len(policy.Types) > 0 || len(policy.Resources) > 0 || policy.MaxMode >= 0 ||
+			len(policy.Target.Hosts) > 0 || policy.Target.Group != "") {
+			return nil, fmt.Errorf("%s: policy %s: a command policy receives the whole plan and cannot also match changes", block.Pos, block.Name)
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
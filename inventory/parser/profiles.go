@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseProfiles reads `profile "name" { ... }` verification profiles from path
+func ParseProfiles(path string) ([]common.Profile, error) {
+	blocks, err := ParseBlocksOfType(path, "profile")
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []common.Profile
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: profile name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("profile name too long: %s", block.Name)
+		}
+
+		profile := common.Profile{Name: block.Name}
+		profile.Resources, _ = block.List("resources")
+		profile.Types, _ = block.List("types")
+		if val, ok := block.Attr("interval"); ok {
+			interval, err := time.ParseDuration(val)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval in profile %s: %w", block.Name, err)
+			}
+			profile.Interval = interval
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
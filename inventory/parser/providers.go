@@ -0,0 +1,231 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// resourceTypePattern matches the block types providers may register
+var resourceTypePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// providerAttributes are accepted by every resource type of a provider and
+// handled by Settle; providers cannot declare them
+var providerAttributes = map[string]AttrSchema{
+	"timeout":         {Kind: KindDuration},
+	"interval":        {Kind: KindDuration},
+	"hosts":           {Kind: KindList},
+	"host_group":      {Kind: KindString},
+	"when":            {Kind: KindString},
+	"count":           {Kind: KindString},
+	"for_each":        {Kind: KindAny},
+	"on_drift":        {Kind: KindString, Values: driftValues},
+	"sensitive":       {Kind: KindSensitive},
+	common.LabelOwner: {Kind: KindString},
+}
+
+// providerTypes maps the resource types registered by providers to the
+// provider implementing them
+var providerTypes = make(map[string]string)
+
+// ParseProviders reads `provider "name" { ... }` blocks from path
+func ParseProviders(path string) ([]common.Provider, error) {
+	blocks, err := ParseBlocksOfType(path, "provider")
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []common.Provider
+	seen := make(map[string]bool)
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: provider name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("provider name too long: %s", block.Name)
+		}
+		if seen[block.Name] {
+			return nil, fmt.Errorf("%s: provider %s is declared twice", block.Pos, block.Name)
+		}
+		seen[block.Name] = true
+
+		provider := common.Provider{Name: block.Name}
+		provider.Command, _ = block.Attr("command")
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// RegisterResourceType adds a resource type implemented by provider to the
+// schemas of resource files and modules. Besides attributes, its blocks take
+// the attributes and nested blocks every resource has, such as hosts and
+// labels. Registering a type again replaces it.
+func RegisterResourceType(provider, blockType string, attributes map[string]AttrSchema) error {
+	if !resourceTypePattern.MatchString(blockType) || len(blockType) > common.MaxNameLength {
+		return fmt.Errorf("provider %s: invalid resource type %q (expected lowercase letters, digits and underscores)", provider, blockType)
+	}
+	if owner, ok := providerTypes[blockType]; ok && owner != provider {
+		return fmt.Errorf("resource type %s is provided by both %s and %s", blockType, owner, provider)
+	}
+	if _, ok := ResourceSchema[blockType]; ok && providerTypes[blockType] == "" {
+		return fmt.Errorf("provider %s: resource type %s is built in", provider, blockType)
+	}
+
+	schema := &BlockSchema{
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: make(map[string]AttrSchema, len(attributes)+len(providerAttributes)),
+		Blocks:     map[string]*BlockSchema{"labels": labelsSchema, "before": hookSchema, "after": hookSchema, "wait_for": waitForSchema},
+	}
+	for name, attr := range providerAttributes {
+		schema.Attributes[name] = attr
+	}
+	for name, attr := range attributes {
+		if _, ok := providerAttributes[name]; ok {
+			return fmt.Errorf("provider %s: attribute %s of %s is reserved", provider, name, blockType)
+		}
+		schema.Attributes[name] = attr
+	}
+
+	ResourceSchema[blockType] = schema
+	ModuleSchema[blockType] = schema
+	providerTypes[blockType] = provider
+	return nil
+}
+
+// ProviderResourcesFromBlocks builds the resources of the blocks whose type
+// a provider registered. Declared attributes are checked against the
+// provider's schema and converted to strings, bools, ints, lists and maps.
+func ProviderResourcesFromBlocks(blocks []*Block) ([]common.ProviderResource, error) {
+	var resources []common.ProviderResource
+	for _, block := range blocks {
+		provider, ok := providerTypes[block.Type]
+		if !ok {
+			continue
+		}
+		schema := ResourceSchema[block.Type]
+		resource := common.ProviderResource{
+			Type:     block.Type,
+			Name:     block.Name,
+			Provider: provider,
+			Config:   make(map[string]interface{}),
+			Labels:   make(map[string]string),
+		}
+		if resource.Name == "" {
+			return nil, fmt.Errorf("%s: %s name cannot be empty", block.Pos, block.Type)
+		}
+		if len(resource.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("%s: %s name too long: %s", block.Pos, block.Type, resource.Name)
+		}
+
+		for _, name := range schema.attributeNames() {
+			attr := block.Attribute(name)
+			if _, ok := providerAttributes[name]; ok {
+				continue
+			}
+			if attr == nil {
+				if schema.Attributes[name].Required {
+					return nil, fmt.Errorf("%s: %s %s needs %s", block.Pos, block.Type, resource.Name, name)
+				}
+				continue
+			}
+			value, err := providerValue(schema.Attributes[name], attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid %s in %s %s: %w", attr.Pos, name, block.Type, resource.Name, err)
+			}
+			resource.Config[name] = value
+		}
+
+		if attr := block.Attribute("timeout"); attr != nil {
+			val := attr.Value.String()
+			timeout, err := time.ParseDuration(val)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("%s: invalid timeout in %s %s: %s", attr.Pos, block.Type, resource.Name, val)
+			}
+			resource.Timeout = timeout
+		}
+		if attr := block.Attribute("interval"); attr != nil {
+			val := attr.Value.String()
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval < common.MinInterval {
+				return nil, fmt.Errorf("%s: invalid interval in %s %s: %s (minimum %v)", attr.Pos, block.Type, resource.Name, val, common.MinInterval)
+			}
+			resource.Interval = interval
+		}
+		resource.Target.Hosts, _ = block.List("hosts")
+		if val, ok := block.Attr("host_group"); ok {
+			resource.Target.Group = val
+		}
+		when, err := parseWhen(block)
+		if err != nil {
+			return nil, err
+		}
+		resource.Target.When = when
+		if val, ok := block.Attr("on_drift"); ok {
+			resource.OnDrift = val
+		}
+		if attr := block.Attribute("sensitive"); attr != nil {
+			sensitive, err := parseSensitive(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+			}
+			resource.Sensitive = sensitive
+		}
+		if val, ok := block.Attr(common.LabelOwner); ok {
+			if err := setLabel(resource.Labels, common.LabelOwner, val); err != nil {
+				return nil, fmt.Errorf("%s: %w", block.Pos, err)
+			}
+		}
+		labels, err := parseLabels(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", block.Type, resource.Name, err)
+		}
+		for key, val := range labels {
+			if err := setLabel(resource.Labels, key, val); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", block.Type, resource.Name, err)
+			}
+		}
+		hooks, err := parseHooks(block)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", block.Type, resource.Name, err)
+		}
+		resource.Hooks = hooks
+
+		resources = append(resources, resource)
+	}
+
+	return resources, nil
+}
+
+// providerValue converts an attribute value to what the provider receives
+// for its kind
+func providerValue(schema AttrSchema, value *Value) (interface{}, error) {
+	if message := schema.check(value); message != "" {
+		return nil, fmt.Errorf("%s", message)
+	}
+
+	switch {
+	case schema.Kind == KindBool:
+		return strconv.ParseBool(value.Str)
+	case schema.Kind == KindInt:
+		return strconv.Atoi(value.Str)
+	case value.Kind == ListValue:
+		items := make([]string, len(value.List))
+		for i, item := range value.List {
+			items[i] = item.String()
+		}
+		return items, nil
+	case value.Kind == MapValue:
+		entries := make(map[string]string, len(value.Map))
+		for _, entry := range value.Map {
+			entries[entry.Key] = entry.Value.String()
+		}
+		return entries, nil
+	case schema.Kind == KindList:
+		return []string{value.Str}, nil
+	}
+	return value.Str, nil
+}
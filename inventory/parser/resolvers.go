@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseResolvers reads `resolver "name" { ... }` host name resolvers from path
+func ParseResolvers(path string) ([]common.Resolver, error) {
+	blocks, err := ParseBlocksOfType(path, "resolver")
+	if err != nil {
+		return nil, err
+	}
+
+	var resolvers []common.Resolver
+	for _, block := range blocks {
+		if block.Name == "" {
+			return nil, fmt.Errorf("%s: resolver name cannot be empty", block.Pos)
+		}
+		if len(block.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("resolver name too long: %s", block.Name)
+		}
+
+		resolver := common.Resolver{Name: block.Name}
+		resolver.Type, _ = block.Attr("type")
+		resolver.Server, _ = block.Attr("server")
+		resolver.Address, _ = block.Attr("address")
+		resolver.Datacenter, _ = block.Attr("datacenter")
+		resolver.Domain, _ = block.Attr("domain")
+		resolver.Target.Hosts, _ = block.List("hosts")
+		resolver.Target.Group, _ = block.Attr("group")
+
+		switch resolver.Type {
+		case common.ResolverSystem, common.ResolverConsul, common.ResolverTailscale:
+		case common.ResolverDNS:
+			if resolver.Server == "" {
+				return nil, fmt.Errorf("%s: resolver %s: server is required for type dns", block.Pos, block.Name)
+			}
+		case "":
+			return nil, fmt.Errorf("%s: resolver %s: type is required", block.Pos, block.Name)
+		default:
+			return nil, fmt.Errorf("%s: resolver %s: unsupported type %q", block.Pos, block.Name, resolver.Type)
+		}
+
+		resolvers = append(resolvers, resolver)
+	}
+
+	return resolvers, nil
+}
@@ -0,0 +1,589 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// AttrKind is the type an attribute value must have
+type AttrKind int
+
+const (
+	KindString    AttrKind = iota
+	KindBool               // true or false
+	KindInt                // decimal integer
+	KindDuration           // e.g. 30s or 1h
+	KindMode               // octal permission bits, e.g. 0644
+	KindList               // ["a", "b"]; a single string is a one-item list
+	KindMap                // { key = "value" }
+	KindSensitive          // true, false or a list of fields
+	KindAny                // a string, list or map
+)
+
+func (k AttrKind) String() string {
+	switch k {
+	case KindBool:
+		return "true or false"
+	case KindInt:
+		return "an integer"
+	case KindDuration:
+		return "a duration such as 30s"
+	case KindMode:
+		return "an octal mode such as 0644"
+	case KindList:
+		return "a list"
+	case KindMap:
+		return "a map"
+	case KindSensitive:
+		return "true, false or a list of fields"
+	case KindAny:
+		return "a string, list or map"
+	}
+	return "a string"
+}
+
+// AttrSchema describes one attribute of a block
+type AttrSchema struct {
+	Kind     AttrKind
+	Required bool
+	Values   []string // allowed values of a string attribute, if limited
+}
+
+// BlockSchema describes the name, attributes and nested blocks of a block type
+type BlockSchema struct {
+	Name         bool // the block takes a name, e.g. package "nginx"
+	NameRequired bool
+	Attributes   map[string]AttrSchema
+	AnyAttribute bool // arbitrary string attributes, e.g. labels
+	AnyKind      bool // arbitrary attributes may also be lists and maps, e.g. module vars
+	Blocks       map[string]*BlockSchema
+	Repeated     bool // may appear more than once in its parent
+}
+
+// FileSchema maps the block types allowed at the top level of a kind of
+// .stl file to their schemas
+type FileSchema map[string]*BlockSchema
+
+// Diagnostic is a problem found while validating a .stl file
+type Diagnostic struct {
+	Pos     Pos
+	Message string
+	Unknown bool // an unknown block type, attribute or nested block, e.g. a typo
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+var (
+	labelsSchema = &BlockSchema{AnyAttribute: true}
+	hookSchema   = &BlockSchema{
+		Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"command":    {Kind: KindString, Required: true},
+			"local":      {Kind: KindBool},
+			"on_failure": {Kind: KindString, Values: []string{common.HookAbort, common.HookWarn, common.HookIgnore}},
+		},
+	}
+	waitForSchema = &BlockSchema{
+		Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"port":     {Kind: KindString},
+			"path":     {Kind: KindString},
+			"command":  {Kind: KindString},
+			"delay":    {Kind: KindDuration},
+			"timeout":  {Kind: KindDuration},
+			"interval": {Kind: KindDuration},
+		},
+	}
+	driftValues   = []string{common.DriftRemediate, common.DriftNotify, common.DriftFail}
+	managerValues = []string{
+		common.PackageManagerAPT, common.PackageManagerYUM, common.PackageManagerDNF, common.PackageManagerZypper,
+		common.PackageManagerPacman, common.PackageManagerBrew, common.PackageManagerPort,
+		common.PackageManagerSnap, common.PackageManagerFlatpak,
+	}
+
+	packageSchema = &BlockSchema{
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"version":          {Kind: KindString},
+			"manager":          {Kind: KindString, Required: true, Values: managerValues},
+			"timeout":          {Kind: KindDuration},
+			"interval":         {Kind: KindDuration},
+			"hold":             {Kind: KindBool},
+			"purge":            {Kind: KindBool},
+			"autoremove":       {Kind: KindBool},
+			"cache_valid_time": {Kind: KindDuration},
+			"conffiles":        {Kind: KindString, Values: []string{common.ConffilesKeep, common.ConffilesReplace}},
+			"channel":          {Kind: KindString},
+			"classic":          {Kind: KindBool},
+			"remote":           {Kind: KindString},
+			"remote_url":       {Kind: KindString},
+			"hosts":            {Kind: KindList},
+			"group":            {Kind: KindString},
+			"when":             {Kind: KindString},
+			"count":            {Kind: KindString}, // an integer or var.name, checked when expanded
+			"for_each":         {Kind: KindAny},
+			"sensitive":        {Kind: KindSensitive},
+			"on_drift":         {Kind: KindString, Values: driftValues},
+			common.LabelOwner:  {Kind: KindString},
+		},
+		Blocks: map[string]*BlockSchema{"labels": labelsSchema, "before": hookSchema, "after": hookSchema, "wait_for": waitForSchema},
+	}
+	fileSchema = &BlockSchema{
+		Name: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"path":           {Kind: KindString},
+			"content":        {Kind: KindString},
+			"content_base64": {Kind: KindString},
+			"source":         {Kind: KindString},
+			"source_url":     {Kind: KindString},
+			"checksum":       {Kind: KindString},
+			"mode":           {Kind: KindMode},
+			"dir_mode":       {Kind: KindMode},
+			"owner":          {Kind: KindString},
+			"group":          {Kind: KindString},
+			"timeout":        {Kind: KindDuration},
+			"interval":       {Kind: KindDuration},
+			"hosts":          {Kind: KindList},
+			"host_group":     {Kind: KindString},
+			"when":           {Kind: KindString},
+			"count":          {Kind: KindString},
+			"for_each":       {Kind: KindAny},
+			"on_drift":       {Kind: KindString, Values: driftValues},
+			"sensitive":      {Kind: KindSensitive},
+			"notify":         {Kind: KindList},
+		},
+		Blocks: map[string]*BlockSchema{"labels": labelsSchema, "before": hookSchema, "after": hookSchema, "wait_for": waitForSchema},
+	}
+	serviceSchema = &BlockSchema{
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"state":   {Kind: KindString, Values: []string{common.ServiceRunning, common.ServiceStopped}},
+			"enabled": {Kind: KindBool},
+			"manager": {Kind: KindString, Values: []string{
+				common.ServiceManagerSystemd, common.ServiceManagerOpenRC, common.ServiceManagerRunit, common.ServiceManagerLaunchd,
+			}},
+			"on_notify":       {Kind: KindString, Values: []string{common.ServiceRestart, common.ServiceReload}},
+			"timeout":         {Kind: KindDuration},
+			"interval":        {Kind: KindDuration},
+			"hosts":           {Kind: KindList},
+			"host_group":      {Kind: KindString},
+			"when":            {Kind: KindString},
+			"count":           {Kind: KindString},
+			"for_each":        {Kind: KindAny},
+			"on_drift":        {Kind: KindString, Values: driftValues},
+			"sensitive":       {Kind: KindSensitive},
+			common.LabelOwner: {Kind: KindString},
+		},
+		Blocks: map[string]*BlockSchema{"labels": labelsSchema, "before": hookSchema, "after": hookSchema, "wait_for": waitForSchema},
+	}
+	systemdUnitSchema = &BlockSchema{
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"content":         {Kind: KindString, Required: true},
+			"restart":         {Kind: KindBool},
+			"timeout":         {Kind: KindDuration},
+			"interval":        {Kind: KindDuration},
+			"hosts":           {Kind: KindList},
+			"host_group":      {Kind: KindString},
+			"when":            {Kind: KindString},
+			"count":           {Kind: KindString},
+			"for_each":        {Kind: KindAny},
+			"on_drift":        {Kind: KindString, Values: driftValues},
+			"sensitive":       {Kind: KindSensitive},
+			common.LabelOwner: {Kind: KindString},
+		},
+		Blocks: map[string]*BlockSchema{"labels": labelsSchema, "before": hookSchema, "after": hookSchema, "wait_for": waitForSchema},
+	}
+)
+
+// ResourceSchema is the schema of resource files
+var ResourceSchema = FileSchema{
+	"package":      packageSchema,
+	"file":         fileSchema,
+	"service":      serviceSchema,
+	"systemd_unit": systemdUnitSchema,
+	"variable": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{"default": {Kind: KindAny, Required: true}},
+	},
+	"module": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"source": {Kind: KindString, Required: true},
+			"vars":   {Kind: KindMap},
+		},
+		Blocks: map[string]*BlockSchema{"vars": {AnyAttribute: true, AnyKind: true}},
+	},
+	"profile": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"resources": {Kind: KindList},
+			"types":     {Kind: KindList},
+			"interval":  {Kind: KindDuration},
+		},
+	},
+}
+
+// ModuleSchema is the schema of the files of a module's source directory
+var ModuleSchema = FileSchema{
+	"package":      packageSchema,
+	"file":         fileSchema,
+	"service":      serviceSchema,
+	"systemd_unit": systemdUnitSchema,
+	"variable": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{"default": {Kind: KindAny}},
+	},
+	"output": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{"value": {Kind: KindString, Required: true}},
+	},
+}
+
+// InventorySchema is the schema of hosts.stl and the files it includes
+var InventorySchema = FileSchema{
+	"include": {Name: true, NameRequired: true, Repeated: true},
+	"host": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"hostname":        {Kind: KindString},
+			"user":            {Kind: KindString},
+			"port":            {Kind: KindInt},
+			"key_file":        {Kind: KindString},
+			"keyfile":         {Kind: KindString},
+			"group":           {Kind: KindString},
+			"mesh_address":    {Kind: KindString},
+			"transport":       {Kind: KindString},
+			"connect_timeout": {Kind: KindDuration},
+			"command_timeout": {Kind: KindDuration},
+			"keepalive":       {Kind: KindDuration},
+			"max_sessions":    {Kind: KindInt},
+			"aliases":         {Kind: KindList},
+			"vars":            {Kind: KindMap},
+		},
+		Blocks: map[string]*BlockSchema{"vars": {AnyAttribute: true}},
+	},
+	"group": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"vars": {Kind: KindMap},
+		},
+		Blocks: map[string]*BlockSchema{"vars": {AnyAttribute: true}},
+	},
+}
+
+// ConfigSchema is the schema of settle.stl
+var ConfigSchema = FileSchema{
+	"settings": {
+		Attributes: map[string]AttrSchema{
+			"hosts_file":    {Kind: KindString},
+			"resource_dir":  {Kind: KindString},
+			"parallelism":   {Kind: KindInt},
+			"unreachable":   {Kind: KindString, Values: []string{common.UnreachableFail, common.UnreachableSkip}},
+			"log_format":    {Kind: KindString, Values: []string{common.LogFormatText, common.LogFormatPlain, common.LogFormatJSON}},
+			"log_file":      {Kind: KindString},
+			"log_max_size":  {Kind: KindString},
+			"log_max_files": {Kind: KindInt},
+		},
+	},
+	"state": {
+		Attributes: map[string]AttrSchema{
+			"backend": {Kind: KindString, Values: []string{common.StateBackendLocal}},
+			"path":    {Kind: KindString},
+			"file":    {Kind: KindString},
+		},
+	},
+	"ssh": {
+		Attributes: map[string]AttrSchema{
+			"user":            {Kind: KindString},
+			"port":            {Kind: KindInt},
+			"key_file":        {Kind: KindString},
+			"connect_timeout": {Kind: KindDuration},
+			"command_timeout": {Kind: KindDuration},
+			"keepalive":       {Kind: KindDuration},
+			"max_sessions":    {Kind: KindInt},
+		},
+	},
+	"defaults": {
+		Attributes: map[string]AttrSchema{
+			"file_mode": {Kind: KindMode},
+			"dir_mode":  {Kind: KindMode},
+			"umask":     {Kind: KindMode},
+			"owner":     {Kind: KindString},
+			"group":     {Kind: KindString},
+		},
+	},
+	"notification": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"url":      {Kind: KindString, Required: true},
+			"format":   {Kind: KindString, Values: []string{"webhook", "slack"}},
+			"events":   {Kind: KindList},
+			"template": {Kind: KindString},
+		},
+	},
+	"hook": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"stage":      {Kind: KindString, Required: true, Values: []string{"pre-run", "post-run"}},
+			"command":    {Kind: KindString, Required: true},
+			"local":      {Kind: KindBool},
+			"on_failure": {Kind: KindString, Values: []string{common.HookAbort, common.HookWarn, common.HookIgnore}},
+		},
+	},
+	"allow": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"hosts":    {Kind: KindList},
+			"group":    {Kind: KindString},
+			"commands": {Kind: KindList, Required: true},
+		},
+	},
+	"lint": {
+		Attributes: map[string]AttrSchema{"disable": {Kind: KindList}},
+	},
+	"limits": {
+		Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"group":             {Kind: KindString},
+			"max_changes":       {Kind: KindInt},
+			"max_deletes":       {Kind: KindInt},
+			"max_hosts_changed": {Kind: KindInt},
+		},
+	},
+	"policy": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"effect":    {Kind: KindString, Values: []string{common.PolicyDeny, common.PolicyWarn}},
+			"message":   {Kind: KindString},
+			"projects":  {Kind: KindList},
+			"actions":   {Kind: KindList},
+			"types":     {Kind: KindList},
+			"resources": {Kind: KindList},
+			"hosts":     {Kind: KindList},
+			"group":     {Kind: KindString},
+			"max_mode":  {Kind: KindMode},
+			"command":   {Kind: KindString},
+		},
+	},
+	"mesh": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"type":    {Kind: KindString, Required: true, Values: []string{common.MeshTailscale}},
+			"tailnet": {Kind: KindString},
+			"hosts":   {Kind: KindList},
+			"group":   {Kind: KindString},
+		},
+	},
+	"resolver": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"type":       {Kind: KindString, Required: true, Values: []string{common.ResolverSystem, common.ResolverDNS, common.ResolverConsul, common.ResolverTailscale}},
+			"server":     {Kind: KindString},
+			"address":    {Kind: KindString},
+			"datacenter": {Kind: KindString},
+			"domain":     {Kind: KindString},
+			"hosts":      {Kind: KindList},
+			"group":      {Kind: KindString},
+		},
+	},
+	"provider": {
+		Name: true, NameRequired: true, Repeated: true,
+		Attributes: map[string]AttrSchema{
+			"command": {Kind: KindString},
+		},
+	},
+}
+
+// Validate checks blocks against the schema and returns every problem found
+func (s FileSchema) Validate(blocks []*Block) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := make(map[string]*Block)
+	for _, block := range blocks {
+		schema, ok := s[block.Type]
+		if !ok {
+			diagnostics = append(diagnostics, Diagnostic{block.Pos, fmt.Sprintf("unknown block type %s (expected %s)", block.Type, strings.Join(s.types(), ", ")), true})
+			continue
+		}
+		if previous, ok := seen[block.Type]; ok && !schema.Repeated {
+			diagnostics = append(diagnostics, Diagnostic{block.Pos, fmt.Sprintf("only one %s block is allowed (first at %s)", block.Type, previous.Pos), false})
+		}
+		seen[block.Type] = block
+		diagnostics = append(diagnostics, schema.validate(block)...)
+	}
+	return diagnostics
+}
+
+func (s FileSchema) types() []string {
+	types := make([]string, 0, len(s))
+	for blockType := range s {
+		types = append(types, blockType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (s *BlockSchema) validate(block *Block) []Diagnostic {
+	var diagnostics []Diagnostic
+	add := func(pos Pos, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{pos, fmt.Sprintf(format, args...), false})
+	}
+	unknown := func(pos Pos, format string, args ...interface{}) {
+		diagnostics = append(diagnostics, Diagnostic{pos, fmt.Sprintf(format, args...), true})
+	}
+
+	switch {
+	case block.Name == "" && s.NameRequired:
+		add(block.Pos, "%s block needs a name", block.Type)
+	case block.Name != "" && !s.Name:
+		add(block.Pos, "%s block does not take a name", block.Type)
+	}
+
+	for _, attr := range block.Attrs {
+		attrSchema, ok := s.Attributes[attr.Key]
+		switch {
+		case ok:
+			if message := attrSchema.check(attr.Value); message != "" {
+				add(attr.Value.Pos, "%s: %s", attr.Key, message)
+			}
+		case s.AnyAttribute:
+			if attr.Value.Kind != StringValue && !s.AnyKind {
+				add(attr.Value.Pos, "%s: expected a string", attr.Key)
+			}
+		default:
+			unknown(attr.Pos, "unknown attribute %s in %s block%s", attr.Key, block.Type, suggest(attr.Key, s.attributeNames()))
+		}
+	}
+	for _, key := range s.attributeNames() {
+		if s.Attributes[key].Required && block.Attribute(key) == nil {
+			add(block.Pos, "%s block %q is missing required attribute %s", block.Type, block.Name, key)
+		}
+	}
+
+	seen := make(map[string]*Block)
+	for _, child := range block.Blocks {
+		childSchema, ok := s.Blocks[child.Type]
+		if !ok {
+			unknown(child.Pos, "unknown block %s in %s block", child.Type, block.Type)
+			continue
+		}
+		if previous, ok := seen[child.Type]; ok && !childSchema.Repeated {
+			add(child.Pos, "only one %s block is allowed in %s block (first at %s)", child.Type, block.Type, previous.Pos)
+		}
+		seen[child.Type] = child
+		diagnostics = append(diagnostics, childSchema.validate(child)...)
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Pos, diagnostics[j].Pos
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return diagnostics
+}
+
+func (s *BlockSchema) attributeNames() []string {
+	names := make([]string, 0, len(s.Attributes))
+	for name := range s.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// suggest returns a "did you mean" hint naming the candidate closest to
+// name, if one is within two edits
+func suggest(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if distance := editDistance(name, candidate); distance < bestDistance {
+			best, bestDistance = candidate, distance
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s?)", best)
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+// check returns why value does not fit the schema, or "" if it does.
+// Values holding ${...} references are only checked once interpolated.
+func (a AttrSchema) check(value *Value) string {
+	if value.Kind == StringValue && strings.Contains(value.Str, "${") {
+		return ""
+	}
+
+	switch a.Kind {
+	case KindAny:
+		return ""
+	case KindList:
+		if value.Kind == MapValue {
+			return "expected " + a.Kind.String()
+		}
+		return ""
+	case KindMap:
+		if value.Kind != MapValue {
+			return "expected " + a.Kind.String()
+		}
+		return ""
+	case KindSensitive:
+		if value.Kind == ListValue {
+			return ""
+		}
+	}
+	if value.Kind != StringValue {
+		return "expected " + a.Kind.String()
+	}
+
+	var err error
+	switch a.Kind {
+	case KindBool, KindSensitive:
+		_, err = strconv.ParseBool(value.Str)
+	case KindInt:
+		_, err = strconv.Atoi(value.Str)
+	case KindDuration:
+		_, err = time.ParseDuration(value.Str)
+	case KindMode:
+		_, err = parseMode(value.Str, 07777)
+	}
+	if err != nil {
+		return fmt.Sprintf("expected %s, found %q", a.Kind, value.Str)
+	}
+
+	if len(a.Values) > 0 {
+		for _, allowed := range a.Values {
+			if value.Str == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("unsupported value %q (expected %s)", value.Str, strings.Join(a.Values, ", "))
+	}
+	return ""
+}
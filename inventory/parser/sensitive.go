@@ -0,0 +1,26 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// parseSensitive reads a `sensitive` attribute: true marks every field,
+// a list such as ["content"] marks only those fields
+func parseSensitive(val *Value) ([]string, error) {
+	if val.Kind == ListValue {
+		fields := make([]string, 0, len(val.List))
+		for _, item := range val.List {
+			fields = append(fields, item.Str)
+		}
+		return fields, nil
+	}
+	sensitive, err := strconv.ParseBool(val.Str)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sensitive value %q (expected true, false or a list of fields)", val.Str)
+	}
+	if sensitive {
+		return []string{"*"}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseServicesFrom reads `service "name" { ... }` blocks from r
+func ParseServicesFrom(r io.Reader) ([]common.Service, error) {
+	blocks, err := ParseBlocksFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return ServicesFromBlocks(blocks)
+}
+
+// ServicesFromBlocks builds the services of the `service` blocks in blocks
+func ServicesFromBlocks(blocks []*Block) ([]common.Service, error) {
+	var services []common.Service
+	for _, block := range BlocksOfType(blocks, "service") {
+		service := common.Service{Name: block.Name, Labels: make(map[string]string)}
+		if service.Name == "" {
+			return nil, fmt.Errorf("%s: service name cannot be empty", block.Pos)
+		}
+		if len(service.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("%s: service name too long: %s", block.Pos, service.Name)
+		}
+		if err := common.CheckName(service.Name); err != nil {
+			return nil, fmt.Errorf("%s: invalid service name: %w", block.Pos, err)
+		}
+
+		if attr := block.Attribute("state"); attr != nil {
+			val := attr.Value.String()
+			if val != common.ServiceRunning && val != common.ServiceStopped {
+				return nil, fmt.Errorf("%s: invalid state in service %s: %s (expected running or stopped)", attr.Pos, service.Name, val)
+			}
+			service.State = val
+		}
+		if attr := block.Attribute("enabled"); attr != nil {
+			val := attr.Value.String()
+			enabled, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid enabled in service %s: %s (expected true or false)", attr.Pos, service.Name, val)
+			}
+			service.Enabled = &enabled
+		}
+		if service.State == "" && service.Enabled == nil {
+			return nil, fmt.Errorf("%s: service %s needs a state or enabled", block.Pos, service.Name)
+		}
+		service.Manager, _ = block.Attr("manager")
+		if attr := block.Attribute("on_notify"); attr != nil {
+			val := attr.Value.String()
+			if val != common.ServiceRestart && val != common.ServiceReload {
+				return nil, fmt.Errorf("%s: invalid on_notify in service %s: %s (expected restart or reload)", attr.Pos, service.Name, val)
+			}
+			service.OnNotify = val
+		}
+		if attr := block.Attribute("timeout"); attr != nil {
+			val := attr.Value.String()
+			timeout, err := time.ParseDuration(val)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("%s: invalid timeout in service %s: %s", attr.Pos, service.Name, val)
+			}
+			service.Timeout = timeout
+		}
+		if attr := block.Attribute("interval"); attr != nil {
+			val := attr.Value.String()
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval < common.MinInterval {
+				return nil, fmt.Errorf("%s: invalid interval in service %s: %s (minimum %v)", attr.Pos, service.Name, val, common.MinInterval)
+			}
+			service.Interval = interval
+		}
+		service.Target.Hosts, _ = block.List("hosts")
+		if val, ok := block.Attr("host_group"); ok {
+			service.Target.Group = val
+		}
+		when, err := parseWhen(block)
+		if err != nil {
+			return nil, err
+		}
+		service.Target.When = when
+		if val, ok := block.Attr("on_drift"); ok {
+			service.OnDrift = val
+		}
+		if attr := block.Attribute("sensitive"); attr != nil {
+			sensitive, err := parseSensitive(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+			}
+			service.Sensitive = sensitive
+		}
+		if val, ok := block.Attr(common.LabelOwner); ok {
+			if err := setLabel(service.Labels, common.LabelOwner, val); err != nil {
+				return nil, fmt.Errorf("%s: %w", block.Pos, err)
+			}
+		}
+		labels, err := parseLabels(block)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		for key, val := range labels {
+			if err := setLabel(service.Labels, key, val); err != nil {
+				return nil, fmt.Errorf("service %s: %w", service.Name, err)
+			}
+		}
+		hooks, err := parseHooks(block)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service.Name, err)
+		}
+		service.Hooks = hooks
+
+		services = append(services, service)
+	}
+
+	return services, nil
+}
@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// ParseSettings reads the `settings`, `state` and `ssh` blocks of settle.stl
+// from path
+func ParseSettings(path string) (common.Settings, error) {
+	var settings common.Settings
+
+	blocks, err := ParseBlocks(path)
+	if err != nil {
+		return settings, err
+	}
+	for _, blockType := range []string{"settings", "state", "ssh"} {
+		if found := BlocksOfType(blocks, blockType); len(found) > 1 {
+			return settings, fmt.Errorf("%s: only one %s block is allowed", found[1].Pos, blockType)
+		}
+	}
+
+	for _, block := range BlocksOfType(blocks, "settings") {
+		for _, attr := range block.Attrs {
+			val, err := scalar(attr, "settings")
+			if err != nil {
+				return settings, err
+			}
+			switch attr.Key {
+			case "hosts_file":
+				settings.HostsFile = val
+			case "resource_dir":
+				settings.ResourceDir = val
+			case "parallelism":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 {
+					return settings, fmt.Errorf("%s: invalid parallelism: %s", attr.Pos, val)
+				}
+				settings.Parallelism = n
+			case "unreachable":
+				if val != common.UnreachableFail && val != common.UnreachableSkip {
+					return settings, fmt.Errorf("%s: invalid unreachable %q (expected fail or skip)", attr.Pos, val)
+				}
+				settings.Unreachable = val
+			case "log_format":
+				switch val {
+				case common.LogFormatText, common.LogFormatPlain, common.LogFormatJSON:
+				default:
+					return settings, fmt.Errorf("%s: invalid log_format %q (expected text, plain or json)", attr.Pos, val)
+				}
+				settings.LogFormat = val
+			case "log_file":
+				if val == "" {
+					return settings, fmt.Errorf("%s: log_file cannot be empty", attr.Pos)
+				}
+				settings.LogFile = val
+			case "log_max_size":
+				size, err := common.ParseSize(val)
+				if err != nil {
+					return settings, fmt.Errorf("%s: log_max_size: %w", attr.Pos, err)
+				}
+				settings.LogMaxSize = size
+			case "log_max_files":
+				n, err := strconv.Atoi(val)
+				if err != nil || n < 1 {
+					return settings, fmt.Errorf("%s: invalid log_max_files: %s", attr.Pos, val)
+				}
+				settings.LogMaxFiles = n
+			}
+		}
+	}
+
+	for _, block := range BlocksOfType(blocks, "state") {
+		for _, attr := range block.Attrs {
+			val, err := scalar(attr, "state")
+			if err != nil {
+				return settings, err
+			}
+			switch attr.Key {
+			case "backend":
+				if val != common.StateBackendLocal {
+					return settings, fmt.Errorf("%s: unsupported state backend %q (expected %s)", attr.Pos, val, common.StateBackendLocal)
+				}
+				settings.StateBackend = val
+			case "path":
+				if val == "" {
+					return settings, fmt.Errorf("%s: state path cannot be empty", attr.Pos)
+				}
+				settings.StatePath = val
+			case "file":
+				if val == "" {
+					return settings, fmt.Errorf("%s: state file cannot be empty", attr.Pos)
+				}
+				settings.StateFile = val
+			}
+		}
+	}
+
+	for _, block := range BlocksOfType(blocks, "ssh") {
+		for _, attr := range block.Attrs {
+			val, err := scalar(attr, "ssh")
+			if err != nil {
+				return settings, err
+			}
+			switch attr.Key {
+			case "user":
+				if len(val) > common.MaxNameLength {
+					return settings, fmt.Errorf("%s: ssh: username too long", attr.Pos)
+				}
+				settings.SSH.User = val
+			case "port":
+				port, err := strconv.Atoi(val)
+				if err == nil {
+					err = validatePort(port)
+				}
+				if err != nil {
+					return settings, fmt.Errorf("%s: ssh: invalid port: %s", attr.Pos, val)
+				}
+				settings.SSH.Port = port
+			case "key_file":
+				if common.IsKeyReference(val) {
+					settings.SSH.Keyfile = val
+					continue
+				}
+				keyfile, err := sanitizePath(val)
+				if err != nil {
+					return settings, fmt.Errorf("%s: ssh: invalid key_file: %w", attr.Pos, err)
+				}
+				settings.SSH.Keyfile = keyfile
+			case "connect_timeout", "command_timeout", "keepalive":
+				if err := setTimeout(&settings.SSH.Timeouts, attr.Key, val); err != nil {
+					return settings, fmt.Errorf("%s: ssh: %w", attr.Pos, err)
+				}
+			case "max_sessions":
+				sessions, err := parseMaxSessions(val)
+				if err != nil {
+					return settings, fmt.Errorf("%s: ssh: %w", attr.Pos, err)
+				}
+				settings.SSH.MaxSessions = sessions
+			}
+		}
+	}
+
+	return settings, nil
+}
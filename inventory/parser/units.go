@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// unitNamePattern matches unit names systemd accepts, with the unit type
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]+\.(service|socket|timer|target|path|mount|automount|swap|slice)$`)
+
+// ParseSystemdUnitsFrom reads `systemd_unit "name" { ... }` blocks from r
+func ParseSystemdUnitsFrom(r io.Reader) ([]common.SystemdUnit, error) {
+	blocks, err := ParseBlocksFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return SystemdUnitsFromBlocks(blocks)
+}
+
+// SystemdUnitsFromBlocks builds the units of the `systemd_unit` blocks in
+// blocks
+func SystemdUnitsFromBlocks(blocks []*Block) ([]common.SystemdUnit, error) {
+	var units []common.SystemdUnit
+	for _, block := range BlocksOfType(blocks, "systemd_unit") {
+		unit := common.SystemdUnit{Name: block.Name, Labels: make(map[string]string)}
+		if !unitNamePattern.MatchString(unit.Name) || len(unit.Name) > common.MaxNameLength {
+			return nil, fmt.Errorf("%s: invalid unit name %q (expected a name with its type, e.g. myapp.service)", block.Pos, unit.Name)
+		}
+
+		content, ok := block.Attr("content")
+		if !ok {
+			return nil, fmt.Errorf("%s: systemd_unit %s needs content", block.Pos, unit.Name)
+		}
+		unit.Content = content
+		if attr := block.Attribute("restart"); attr != nil {
+			val := attr.Value.String()
+			restart, err := strconv.ParseBool(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid restart in systemd_unit %s: %s (expected true or false)", attr.Pos, unit.Name, val)
+			}
+			unit.Restart = restart
+		}
+		if attr := block.Attribute("timeout"); attr != nil {
+			val := attr.Value.String()
+			timeout, err := time.ParseDuration(val)
+			if err != nil || timeout < 0 {
+				return nil, fmt.Errorf("%s: invalid timeout in systemd_unit %s: %s", attr.Pos, unit.Name, val)
+			}
+			unit.Timeout = timeout
+		}
+		if attr := block.Attribute("interval"); attr != nil {
+			val := attr.Value.String()
+			interval, err := time.ParseDuration(val)
+			if err != nil || interval < common.MinInterval {
+				return nil, fmt.Errorf("%s: invalid interval in systemd_unit %s: %s (minimum %v)", attr.Pos, unit.Name, val, common.MinInterval)
+			}
+			unit.Interval = interval
+		}
+		unit.Target.Hosts, _ = block.List("hosts")
+		if val, ok := block.Attr("host_group"); ok {
+			unit.Target.Group = val
+		}
+		when, err := parseWhen(block)
+		if err != nil {
+			return nil, err
+		}
+		unit.Target.When = when
+		if val, ok := block.Attr("on_drift"); ok {
+			unit.OnDrift = val
+		}
+		if attr := block.Attribute("sensitive"); attr != nil {
+			sensitive, err := parseSensitive(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", attr.Pos, err)
+			}
+			unit.Sensitive = sensitive
+		}
+		if val, ok := block.Attr(common.LabelOwner); ok {
+			if err := setLabel(unit.Labels, common.LabelOwner, val); err != nil {
+				return nil, fmt.Errorf("%s: %w", block.Pos, err)
+			}
+		}
+		labels, err := parseLabels(block)
+		if err != nil {
+			return nil, fmt.Errorf("systemd_unit %s: %w", unit.Name, err)
+		}
+		for key, val := range labels {
+			if err := setLabel(unit.Labels, key, val); err != nil {
+				return nil, fmt.Errorf("systemd_unit %s: %w", unit.Name, err)
+			}
+		}
+		hooks, err := parseHooks(block)
+		if err != nil {
+			return nil, fmt.Errorf("systemd_unit %s: %w", unit.Name, err)
+		}
+		unit.Hooks = hooks
+
+		units = append(units, unit)
+	}
+
+	return units, nil
+}
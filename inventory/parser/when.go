@@ -0,0 +1,20 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// parseWhen returns the `when` condition of a resource block, checked for
+// syntax, or "" when it has none
+func parseWhen(block *Block) (string, error) {
+	attr := block.Attribute("when")
+	if attr == nil {
+		return "", nil
+	}
+	if _, err := common.ParseCondition(attr.Value.Str); err != nil {
+		return "", fmt.Errorf("%s: invalid when: %w", attr.Pos, err)
+	}
+	return attr.Value.Str, nil
+}
@@ -0,0 +1,93 @@
+package inventory
+
+import (
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// ConnectionPool shares one SSH connection per host between the resources
+// of a run. Their commands run in sessions multiplexed over it, at most the
+// host's max_sessions at a time, instead of each resource opening a TCP
+// connection of its own.
+type ConnectionPool struct {
+	mu          sync.Mutex
+	connections map[string]*pooledConnection
+}
+
+type pooledConnection struct {
+	mu     sync.Mutex // Held while connecting, so a host is dialed once
+	client *ssh.SSHClient
+	lost   chan struct{} // Closed when the connection drops
+}
+
+func NewConnectionPool() *ConnectionPool {
+	return &ConnectionPool{connections: make(map[string]*pooledConnection)}
+}
+
+// Connect returns a transport over the pooled connection to host, opening
+// the connection on first use or after it dropped. Closing the transport
+// leaves the connection open for the next resource. Hosts not reached over
+// SSH get a transport of their own.
+func (p *ConnectionPool) Connect(host *common.Host) (Transport, error) {
+	if host.Transport != "" && host.Transport != common.TransportSSH {
+		return Connect(host)
+	}
+
+	p.mu.Lock()
+	pooled, ok := p.connections[host.Name]
+	if !ok {
+		pooled = &pooledConnection{}
+		p.connections[host.Name] = pooled
+	}
+	p.mu.Unlock()
+
+	pooled.mu.Lock()
+	defer pooled.mu.Unlock()
+	if pooled.client != nil {
+		select {
+		case <-pooled.lost:
+			pooled.client.Close()
+			pooled.client = nil
+		default:
+		}
+	}
+	if pooled.client == nil {
+		client, err := dialSSH(host)
+		if err != nil {
+			return nil, err
+		}
+		lost := make(chan struct{})
+		go func() {
+			client.Client.Wait()
+			close(lost)
+		}()
+		pooled.client, pooled.lost = client, lost
+	}
+	return &shellTransport{runner: sharedRunner{pooled.client}, host: host.Name}, nil
+}
+
+// Close closes the pooled connections
+func (p *ConnectionPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, pooled := range p.connections {
+		pooled.mu.Lock()
+		if pooled.client != nil {
+			pooled.client.Close()
+		}
+		pooled.mu.Unlock()
+		delete(p.connections, name)
+	}
+}
+
+// sharedRunner runs commands over a pooled connection, which outlives the
+// transports using it
+type sharedRunner struct {
+	*ssh.SSHClient
+}
+
+func (sharedRunner) Close() error {
+	return nil
+}
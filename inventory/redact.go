@@ -0,0 +1,61 @@
+package inventory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces secret values in log output
+const Redacted = "[REDACTED]"
+
+var (
+	secretsMu sync.RWMutex
+	// secretValues is sorted longest first, so a secret is replaced before
+	// any shorter one it contains
+	secretValues []string
+)
+
+// RegisterSecret makes every Logger, and Redact, hide value
+func RegisterSecret(value string) {
+	if value == "" {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	i := sort.Search(len(secretValues), func(i int) bool {
+		if len(secretValues[i]) != len(value) {
+			return len(secretValues[i]) < len(value)
+		}
+		return secretValues[i] >= value
+	})
+	if i < len(secretValues) && secretValues[i] == value {
+		return
+	}
+	secretValues = append(secretValues, "")
+	copy(secretValues[i+1:], secretValues[i:])
+	secretValues[i] = value
+}
+
+// Redact replaces every registered secret value in s
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, value := range secretValues {
+		s = strings.ReplaceAll(s, value, Redacted)
+	}
+	return s
+}
+
+// Printf logs like log.Logger.Printf with registered secrets redacted, as a
+// JSON object in JSON output, and copies the line to the log file
+func (l *Logger) Printf(format string, v ...interface{}) {
+	message := Redact(fmt.Sprintf(format, v...))
+	if jsonOutput {
+		l.printJSON("", message)
+		return
+	}
+	l.Logger.Print(message)
+	teeLogFile(message)
+}
@@ -0,0 +1,38 @@
+package inventory
+
+import "testing"
+
+func TestRedactReplacesLongerSecretsFirst(t *testing.T) {
+	// Registered in both orders, so the outcome does not depend on it
+	RegisterSecret("tok")
+	RegisterSecret("tok3n-abcdef")
+	RegisterSecret("password=hunter2")
+	RegisterSecret("hunter2")
+	RegisterSecret("tok")
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"key: tok3n-abcdef", "key: " + Redacted},
+		{"tok and tok3n-abcdef", Redacted + " and " + Redacted},
+		{"line: password=hunter2", "line: " + Redacted},
+		{"hunter2", Redacted},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := Redact(tt.in); got != tt.want {
+				t.Fatalf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		}
+	}
+
+	for i := 1; i < len(secretValues); i++ {
+		if len(secretValues[i-1]) < len(secretValues[i]) {
+			t.Fatalf("secrets not sorted longest first: %q", secretValues)
+		}
+		if secretValues[i-1] == secretValues[i] {
+			t.Fatalf("secret registered twice: %q", secretValues)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// consulResolver returns the address a node is registered with in the
+// Consul catalog, authenticating with CONSUL_HTTP_TOKEN when it is set
+type consulResolver struct {
+	name       string
+	address    string
+	datacenter string
+	token      string
+}
+
+func newConsulResolver(name, address, datacenter string) *consulResolver {
+	return &consulResolver{
+		name:       name,
+		address:    strings.TrimSuffix(address, "/"),
+		datacenter: datacenter,
+		token:      os.Getenv("CONSUL_HTTP_TOKEN"),
+	}
+}
+
+func (r *consulResolver) Name() string { return r.name }
+
+type consulNode struct {
+	Node *struct {
+		Address string `json:"Address"`
+	} `json:"Node"`
+}
+
+func (r *consulResolver) Resolve(ctx context.Context, name string) (string, error) {
+	endpoint := r.address + "/v1/catalog/node/" + url.PathEscape(name)
+	if r.datacenter != "" {
+		endpoint += "?dc=" + url.QueryEscape(r.datacenter)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	if r.token != "" {
+		req.Header.Set("X-Consul-Token", r.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("consul catalog API returned %s", resp.Status)
+	}
+
+	// An unknown node is a 200 response with a null body
+	var node consulNode
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return "", fmt.Errorf("consul: invalid catalog response: %w", err)
+	}
+	if node.Node == nil || node.Node.Address == "" {
+		return "", fmt.Errorf("node %s is not in the consul catalog", name)
+	}
+	return node.Node.Address, nil
+}
@@ -0,0 +1,114 @@
+// Package resolve turns host names into addresses before Settle dials them,
+// using the system resolver, a specific DNS server, the Consul catalog or
+// Tailscale MagicDNS as configured per host or group in settle.stl.
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// Resolver looks up the address of a host name
+type Resolver interface {
+	Name() string
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+// Defaults of the resolver types
+const (
+	DefaultConsulAddress   = "http://127.0.0.1:8500"
+	DefaultTailscaleServer = "100.100.100.100:53" // MagicDNS
+)
+
+// New returns the resolver a resolver block configures
+func New(config common.Resolver) (Resolver, error) {
+	switch config.Type {
+	case common.ResolverSystem:
+		return &dnsResolver{name: config.Name, resolver: net.DefaultResolver}, nil
+	case common.ResolverDNS:
+		if config.Server == "" {
+			return nil, fmt.Errorf("resolver %s: server is required for type dns", config.Name)
+		}
+		return newDNSResolver(config.Name, config.Server, config.Domain), nil
+	case common.ResolverTailscale:
+		server := config.Server
+		if server == "" {
+			server = DefaultTailscaleServer
+		}
+		return newDNSResolver(config.Name, server, config.Domain), nil
+	case common.ResolverConsul:
+		address := config.Address
+		if address == "" {
+			address = DefaultConsulAddress
+		}
+		return newConsulResolver(config.Name, address, config.Datacenter), nil
+	}
+	return nil, fmt.Errorf("resolver %s: unknown type %q (expected %s, %s, %s or %s)", config.Name, config.Type,
+		common.ResolverSystem, common.ResolverDNS, common.ResolverConsul, common.ResolverTailscale)
+}
+
+// Address returns the address to dial for host. Hosts without a resolver,
+// and hostnames that already are IP addresses, are returned unchanged so
+// the system resolver handles them when dialing.
+func Address(ctx context.Context, host *common.Host) (string, error) {
+	if host.Resolver == nil || net.ParseIP(host.Hostname) != nil {
+		return host.Hostname, nil
+	}
+
+	resolver, err := New(*host.Resolver)
+	if err != nil {
+		return "", err
+	}
+	address, err := resolver.Resolve(ctx, host.Hostname)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s with resolver %s: %w", host.Hostname, resolver.Name(), err)
+	}
+	return address, nil
+}
+
+// dnsResolver looks names up with a net.Resolver, optionally completing
+// names without a dot with a domain
+type dnsResolver struct {
+	name     string
+	resolver *net.Resolver
+	domain   string
+}
+
+// newDNSResolver returns a resolver that queries server; a server without
+// a port uses port 53
+func newDNSResolver(name, server, domain string) *dnsResolver {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &dnsResolver{
+		name:   name,
+		domain: strings.Trim(domain, "."),
+		resolver: &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		},
+	}
+}
+
+func (r *dnsResolver) Name() string { return r.name }
+
+func (r *dnsResolver) Resolve(ctx context.Context, name string) (string, error) {
+	if r.domain != "" && !strings.Contains(name, ".") {
+		name += "." + r.domain
+	}
+	addresses, err := r.resolver.LookupHost(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if len(addresses) == 0 {
+		return "", fmt.Errorf("no addresses for %s", name)
+	}
+	return addresses[0], nil
+}
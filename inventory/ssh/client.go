@@ -1,28 +1,65 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 	"github.com/settlectl/settle-core/common"
-	"github.com/settlectl/settle-core/inventory/parser"
+	"github.com/settlectl/settle-core/inventory/resolve"
 	gossh "golang.org/x/crypto/ssh"
 )
 
+// Connection defaults; hosts may set their own timeouts
 const (
 	ConnectTimeout = 5 * time.Second
 	ReadTimeout    = 60 * time.Second
 	WriteTimeout   = 60 * time.Second
+	KeepalivePeriod = 30 * time.Second
 	MaxConnections = 10
+	MaxSessions     = 10 // OpenSSH's default MaxSessions
 )
 
+// HostTimeouts returns the timeouts of host, with the defaults for those it
+// does not set
+func HostTimeouts(host *common.Host) common.Timeouts {
+	timeouts := host.Timeouts
+	if timeouts.Connect == 0 {
+		timeouts.Connect = ConnectTimeout
+	}
+	if timeouts.Command == 0 {
+		timeouts.Command = ReadTimeout
+	}
+	if timeouts.Keepalive == 0 {
+		timeouts.Keepalive = KeepalivePeriod
+	}
+	return timeouts
+}
+
+// HostMaxSessions returns how many sessions may be open at once over a
+// connection to host
+func HostMaxSessions(host *common.Host) int {
+	if host.MaxSessions > 0 {
+		return host.MaxSessions
+	}
+	return MaxSessions
+}
+
 type SSHClient struct {
 	Host   *common.Host
 	Client *gossh.Client
+
+	// sessions holds a slot for each session open on Client, so concurrent
+	// commands stay within the server's MaxSessions
+	sessions chan struct{}
 }
 
 type SSHConfig struct {
@@ -34,36 +71,25 @@ type SSHConfig struct {
 	HostKeyFile  string
 }
 
+// loadSSHConfig returns the settings ~/.ssh/config gives hostname, or nil
+// when there is no such file
 func loadSSHConfig(hostname string) (*SSHConfig, error) {
-
-	currentUser, err := user.Current()
+	localUser, homeDir, err := currentUserHome()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current user: %w", err)
+		return nil, err
 	}
 
-	sshConfigPath := filepath.Join(currentUser.HomeDir, ".ssh", "config")
+	sshConfigPath := filepath.Join(homeDir, ".ssh", "config")
 
 	if _, err := os.Stat(sshConfigPath); os.IsNotExist(err) {
 		return nil, nil
 	}
 
-	hosts, err := parser.ParseHosts(sshConfigPath)
+	config, err := parseSSHConfig(sshConfigPath, hostname, homeDir, localUser)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse SSH config: %w", err)
+		return nil, fmt.Errorf("failed to read SSH config: %w", err)
 	}
-
-	for _, host := range hosts {
-		if host.Name == hostname {
-			return &SSHConfig{
-				Hostname:     host.Hostname,
-				User:         host.User,
-				Port:         host.Port,
-				IdentityFile: host.Keyfile,
-			}, nil
-		}
-	}
-
-	return nil, nil
+	return config, nil
 }
 
 func resolveHost(hostname string) (string, string, int, string, error) {
@@ -132,10 +158,6 @@ func createSecureConfig(host *common.Host, signer gossh.Signer) (*gossh.ClientCo
 		return nil, fmt.Errorf("invalid hostname: %w", err)
 	}
 
-	if err := validateKeyFile(host.Keyfile); err != nil {
-		return nil, fmt.Errorf("invalid key file: %w", err)
-	}
-
 	config := &gossh.ClientConfig{
 		User: host.User,
 		Auth: []gossh.AuthMethod{
@@ -147,7 +169,7 @@ func createSecureConfig(host *common.Host, signer gossh.Signer) (*gossh.ClientCo
 		// - Automation scenarios requiring unattended operation
 		// - Dynamic cloud environments where host keys change
 		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
-		Timeout:         ConnectTimeout,
+		Timeout:         HostTimeouts(host).Connect,
 		BannerCallback: func(message string) error {
 
 			return nil
@@ -163,6 +185,7 @@ func NewSSHClient(host *common.Host) (*SSHClient, error) {
 	}
 
 	if host.Hostname == "" {
+		debugf("%s has no hostname, looking it up in ~/.ssh/config", host.Name)
 		resolvedHostname, resolvedUser, resolvedPort, resolvedKeyFile, err := resolveHost(host.Name)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve host: %w", err)
@@ -195,50 +218,98 @@ func NewSSHClient(host *common.Host) (*SSHClient, error) {
 					host.Keyfile = keyPath
 					break
 				}
+				debugf("default key %s not found", keyPath)
 			}
 		}
 	}
+	debugf("connecting to %s as %s@%s:%d using key %s", host.Name, host.User, host.Hostname, host.Port, host.Keyfile)
 
-	key, err := os.ReadFile(host.Keyfile)
+	signer, err := loadSigner(host.Keyfile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %w", err)
-	}
-
-	signer, err := gossh.ParsePrivateKey(key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
 	}
 
 	config, err := createSecureConfig(host, signer)
 	if err != nil {
 		return nil, err
 	}
+	debugConfig(config, signer)
 
-	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host.Hostname, fmt.Sprintf("%d", host.Port)), ConnectTimeout)
+	conn, address, err := dial(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to establish connection: %w", err)
+		return nil, err
+	}
+	if debugEnabled() {
+		conn = &countingConn{Conn: conn, opened: time.Now()}
 	}
 
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
+	if tcpConn, ok := unwrapConn(conn).(*net.TCPConn); ok {
 		tcpConn.SetKeepAlive(true)
-		tcpConn.SetKeepAlivePeriod(30 * time.Second)
+		tcpConn.SetKeepAlivePeriod(HostTimeouts(host).Keepalive)
 		tcpConn.SetLinger(0)
 	}
 
-	sshConn, chans, reqs, err := gossh.NewClientConn(conn, net.JoinHostPort(host.Hostname, fmt.Sprintf("%d", host.Port)), config)
+	handshakeStarted := time.Now()
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, address, config)
 	if err != nil {
+		debugf("SSH handshake with %s failed after %s: %v", address, time.Since(handshakeStarted).Round(time.Millisecond), err)
 		conn.Close()
 		return nil, fmt.Errorf("failed to establish SSH connection: %w", err)
 	}
+	debugf("SSH handshake with %s completed in %s; server version %s", address, time.Since(handshakeStarted).Round(time.Millisecond), sshConn.ServerVersion())
 
 	client := gossh.NewClient(sshConn, chans, reqs)
 
 	return &SSHClient{
-		Host:   host,
-		Client: client,
+		Host:     host,
+		Client:   client,
+		sessions: make(chan struct{}, HostMaxSessions(host)),
 	}, nil
 }
 
+// dial opens a TCP connection to host, trying its mesh address first when
+// it has one and falling back to its hostname
+func dial(host *common.Host) (net.Conn, string, error) {
+	port := fmt.Sprintf("%d", host.Port)
+	timeout := HostTimeouts(host).Connect
+	if host.MeshAddress != "" {
+		address := net.JoinHostPort(host.MeshAddress, port)
+		conn, err := dialAddress(address, timeout)
+		if err == nil {
+			return conn, address, nil
+		}
+		debugf("mesh address %s of %s is unreachable, falling back to %s", host.MeshAddress, host.Name, host.Hostname)
+	}
+
+	resolveCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	dialHost, err := resolve.Address(resolveCtx, host)
+	cancel()
+	if err != nil {
+		return nil, "", err
+	}
+	if dialHost != host.Hostname {
+		debugf("resolved %s to %s with resolver %s", host.Hostname, dialHost, host.Resolver.Name)
+	}
+
+	address := net.JoinHostPort(dialHost, port)
+	conn, err := dialAddress(address, timeout)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to establish connection: %w", err)
+	}
+	return conn, address, nil
+}
+
+func dialAddress(address string, timeout time.Duration) (net.Conn, error) {
+	dialStarted := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		debugf("TCP connect to %s failed after %s: %v", address, time.Since(dialStarted).Round(time.Millisecond), err)
+		return nil, err
+	}
+	debugf("TCP connected to %s (local %s) in %s", address, conn.LocalAddr(), time.Since(dialStarted).Round(time.Millisecond))
+	return conn, nil
+}
+
 func (s *SSHClient) Close() error {
 	if s.Client != nil {
 		return s.Client.Close()
@@ -247,39 +318,140 @@ func (s *SSHClient) Close() error {
 }
 
 func (s *SSHClient) RunCommand(ctx context.Context, command string) (string, error) {
+	return s.RunCommandWithInput(ctx, command, "")
+}
+
+// RunCommandWithInput runs command with input fed to its stdin and returns
+// its combined output
+func (s *SSHClient) RunCommandWithInput(ctx context.Context, command string, input string) (string, error) {
+	var out lockedBuffer
+	if err := s.exec(ctx, command, input, &out, &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Exec runs command with input fed to its stdin and returns its output
+// streams and exit code. A nonzero exit code is not an error.
+func (s *SSHClient) Exec(ctx context.Context, command string, input string) (*common.CommandResult, error) {
+	var stdout, stderr lockedBuffer
+	started := time.Now()
+	err := s.exec(ctx, command, input, &stdout, &stderr)
+	result := &common.CommandResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(started),
+	}
+	var exitErr *gossh.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitStatus()
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// exec runs command in a new session, writing its output to stdout and
+// stderr. The allow-list sees command before the options of ctx wrap it.
+func (s *SSHClient) exec(ctx context.Context, command string, input string, stdout, stderr io.Writer) error {
+	if err := CheckAllowed(s.Host.Name, s.Host.AllowedCommands, command); err != nil {
+		return err
+	}
+	script, err := common.CommandOptionsFromContext(ctx).Wrap(command)
+	if err != nil {
+		return err
+	}
+
+	release, err := s.acquireSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	session, err := s.Client.NewSession()
 	if err != nil {
-		return "", fmt.Errorf("failed to create SSH session: %w", err)
+		debugf("opening session channel on %s failed: %v", s.Host.Name, err)
+		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	defer session.Close()
+	sessionStarted := time.Now()
+	debugf("opened session channel on %s", s.Host.Name)
+	defer func() {
+		session.Close()
+		debugf("closed session channel on %s after %s", s.Host.Name, time.Since(sessionStarted).Round(time.Millisecond))
+	}()
+	debugf("exec on %s: %s", s.Host.Name, command)
 
-	resultChan := make(chan struct {
-		Output []byte
-		Error  error
-	}, 1)
+	if input != "" {
+		session.Stdin = strings.NewReader(input)
+	}
+	session.Stdout = stdout
+	session.Stderr = stderr
 
+	resultChan := make(chan error, 1)
 	go func() {
-		defer close(resultChan)
-		out, err := session.CombinedOutput(command)
-		resultChan <- struct {
-			Output []byte
-			Error  error
-		}{out, err}
+		resultChan <- session.Run(script)
 	}()
 
+	// A deadline on ctx (e.g. a per-action timeout) replaces the default
+	timeout := HostTimeouts(s.Host).Command
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
 	select {
 	case <-ctx.Done():
 		_ = session.Signal(gossh.SIGKILL)
-		return "", ctx.Err()
-	case result := <-resultChan:
-		if result.Error != nil {
-			return "", fmt.Errorf("failed to run command: %w", result.Error)
+		return ctx.Err()
+	case err := <-resultChan:
+		debugf("exec on %s finished: %v", s.Host.Name, exitStatus(err))
+		if err != nil {
+			return fmt.Errorf("failed to run command: %w", err)
 		}
-		return string(result.Output), nil
-	case <-time.After(ReadTimeout):
+		return nil
+	case <-time.After(timeout):
 		_ = session.Signal(gossh.SIGKILL)
-		return "", fmt.Errorf("command timed out after %s", ReadTimeout)
+		return fmt.Errorf("%w after %s", common.ErrCommandTimeout, timeout)
+	}
+}
+
+// acquireSession waits until fewer than the host's max sessions are open on
+// the connection and takes a slot, freed by the returned function
+func (s *SSHClient) acquireSession(ctx context.Context) (func(), error) {
+	if s.sessions == nil {
+		return func() {}, nil
 	}
+	select {
+	case s.sessions <- struct{}{}:
+	default:
+		debugf("waiting for a free session on %s (%d open)", s.Host.Name, cap(s.sessions))
+		select {
+		case s.sessions <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return func() { <-s.sessions }, nil
+}
+
+// lockedBuffer is a buffer safe for the concurrent writes of stdout and
+// stderr sharing it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
 }
 
 func (s *SSHClient) TestConnection() error {
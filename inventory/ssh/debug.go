@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// debugLog receives SSH wire-level diagnostics when --debug-ssh is set
+var debugLog func(message string)
+
+// SetDebugLogger enables logging of connection setup, handshake, auth
+// attempts and channel lifecycle. Key material and session IDs are never
+// passed to fn; callers should still redact command lines.
+func SetDebugLogger(fn func(message string)) {
+	debugLog = fn
+}
+
+func debugf(format string, args ...interface{}) {
+	if debugLog != nil {
+		debugLog(fmt.Sprintf(format, args...))
+	}
+}
+
+func debugEnabled() bool {
+	return debugLog != nil
+}
+
+// debugConfig wraps the client config callbacks so the handshake reports
+// what it negotiates and which auth methods it tries
+func debugConfig(config *gossh.ClientConfig, signer gossh.Signer) {
+	if !debugEnabled() {
+		return
+	}
+
+	debugf("client version %s", clientVersion(config))
+	debugf("offering key exchanges %v, ciphers %v, MACs %v (empty means library defaults)",
+		config.KeyExchanges, config.Ciphers, config.MACs)
+	if len(config.HostKeyAlgorithms) > 0 {
+		debugf("accepting host key algorithms %v", config.HostKeyAlgorithms)
+	}
+
+	hostKeyCallback := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		debugf("server host key %s %s", key.Type(), gossh.FingerprintSHA256(key))
+		err := hostKeyCallback(hostname, remote, key)
+		if err != nil {
+			debugf("host key rejected: %v", err)
+		}
+		return err
+	}
+
+	bannerCallback := config.BannerCallback
+	config.BannerCallback = func(message string) error {
+		debugf("server banner: %q", message)
+		if bannerCallback != nil {
+			return bannerCallback(message)
+		}
+		return nil
+	}
+
+	config.Auth = []gossh.AuthMethod{
+		gossh.PublicKeysCallback(func() ([]gossh.Signer, error) {
+			debugf("attempting auth method publickey as %q with %s key %s",
+				config.User, signer.PublicKey().Type(), gossh.FingerprintSHA256(signer.PublicKey()))
+			return []gossh.Signer{signer}, nil
+		}),
+	}
+}
+
+func clientVersion(config *gossh.ClientConfig) string {
+	if config.ClientVersion != "" {
+		return config.ClientVersion
+	}
+	return "(library default)"
+}
+
+// countingConn counts the bytes crossing a connection for debug output
+type countingConn struct {
+	net.Conn
+	read, written atomic.Int64
+	opened        time.Time
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	debugf("closing connection to %s after %s (%d bytes read, %d written)",
+		c.RemoteAddr(), time.Since(c.opened).Round(time.Millisecond), c.read.Load(), c.written.Load())
+	return c.Conn.Close()
+}
+
+// unwrapConn returns the connection underneath debug wrappers
+func unwrapConn(conn net.Conn) net.Conn {
+	if counting, ok := conn.(*countingConn); ok {
+		return counting.Conn
+	}
+	return conn
+}
+
+// exitStatus describes how a remote command ended
+func exitStatus(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	if exitErr, ok := err.(*gossh.ExitError); ok {
+		return fmt.Sprintf("exit status %d", exitErr.ExitStatus())
+	}
+	return err.Error()
+}
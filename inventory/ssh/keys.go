@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// KeyFetchTimeout bounds fetching a private key from a key store
+const KeyFetchTimeout = 30 * time.Second
+
+// KeyLoader fetches the private key a keyfile reference such as
+// vault:secret/data/ssh or op://infra/deploy/private key points to
+type KeyLoader func(ctx context.Context, ref string) ([]byte, error)
+
+var (
+	keyLoader KeyLoader
+
+	// Keys from key stores are fetched once per run and kept in memory only
+	signersMu sync.Mutex
+	signers   = make(map[string]gossh.Signer)
+)
+
+// SetKeyLoader sets how keyfile references to key stores are fetched
+func SetKeyLoader(loader KeyLoader) {
+	keyLoader = loader
+}
+
+// loadSigner returns the signer of the private key in keyfile, or of the
+// key a key store reference points to
+func loadSigner(keyfile string) (gossh.Signer, error) {
+	if !common.IsKeyReference(keyfile) {
+		if err := validateKeyFile(keyfile); err != nil {
+			return nil, fmt.Errorf("invalid key file: %w", err)
+		}
+		key, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		signer, err := gossh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		return signer, nil
+	}
+
+	signersMu.Lock()
+	defer signersMu.Unlock()
+	if signer, ok := signers[keyfile]; ok {
+		return signer, nil
+	}
+	if keyLoader == nil {
+		return nil, fmt.Errorf("no key loader for key reference %s", keyfile)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), KeyFetchTimeout)
+	defer cancel()
+	started := time.Now()
+	key, err := keyLoader(ctx, keyfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key: %w", err)
+	}
+	debugf("fetched key %s in %s", keyfile, time.Since(started).Round(time.Millisecond))
+
+	signer, err := gossh.ParsePrivateKey(key)
+	clear(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key from %s: %w", keyfile, err)
+	}
+	signers[keyfile] = signer
+	return signer, nil
+}
@@ -0,0 +1,402 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// A minimal SFTP version 3 client, enough to transfer files: requests are
+// sent one at a time over the sftp subsystem of a session.
+
+const sftpVersion = 3
+
+// SFTP packet types
+const (
+	sftpInit     = 1
+	sftpVersionP = 2
+	sftpOpen     = 3
+	sftpClose    = 4
+	sftpRead     = 5
+	sftpWrite    = 6
+	sftpSetstat  = 9
+	sftpRemove   = 13
+	sftpMkdir    = 14
+	sftpStat     = 17
+	sftpRename   = 18
+	sftpStatus   = 101
+	sftpHandle   = 102
+	sftpData     = 103
+	sftpAttrs    = 105
+	sftpExtended = 200
+)
+
+// SFTP open flags
+const (
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+)
+
+// SFTP attribute flags
+const (
+	sftpAttrSize        = 0x01
+	sftpAttrUIDGID      = 0x02
+	sftpAttrPermissions = 0x04
+)
+
+// SFTP status codes
+const (
+	sftpStatusOK           = 0
+	sftpStatusEOF          = 1
+	sftpStatusNoSuchFile   = 2
+	sftpStatusPermDenied   = 3
+	sftpPosixRenameExtName = "posix-rename@openssh.com"
+	sftpMaxPacket          = 256 * 1024
+	sftpChunkSize          = 32 * 1024
+)
+
+// SFTPError is an error status returned by the SFTP server
+type SFTPError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *SFTPError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("sftp: %s (status %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("sftp: status %d", e.Code)
+}
+
+// Is lets errors.Is match a missing file with fs.ErrNotExist and a denied
+// access with fs.ErrPermission
+func (e *SFTPError) Is(target error) bool {
+	switch target {
+	case fs.ErrNotExist:
+		return e.Code == sftpStatusNoSuchFile
+	case fs.ErrPermission:
+		return e.Code == sftpStatusPermDenied
+	}
+	return false
+}
+
+type sftpClient struct {
+	session    *gossh.Session
+	w          io.WriteCloser
+	r          io.Reader
+	nextID     uint32
+	extensions map[string]string
+}
+
+// sftpAttributes are the attributes of a remote file that transfers use
+type sftpAttributes struct {
+	size  int64
+	mode  uint32
+	isDir bool
+}
+
+// newSFTPClient starts the sftp subsystem on client
+func newSFTPClient(client *gossh.Client) (*sftpClient, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		session.Close()
+		return nil, fmt.Errorf("sftp subsystem unavailable: %w", err)
+	}
+
+	c := &sftpClient{session: session, w: w, r: r, extensions: make(map[string]string)}
+	var init []byte
+	init = binary.BigEndian.AppendUint32(init, sftpVersion)
+	if err := c.writePacket(sftpInit, init); err != nil {
+		c.Close()
+		return nil, err
+	}
+	kind, payload, err := c.readPacket()
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	if kind != sftpVersionP || len(payload) < 4 {
+		c.Close()
+		return nil, fmt.Errorf("sftp: unexpected packet %d during init", kind)
+	}
+	for rest := payload[4:]; len(rest) > 0; {
+		var name, data string
+		if name, rest, err = readString(rest); err != nil {
+			break
+		}
+		if data, rest, err = readString(rest); err != nil {
+			break
+		}
+		c.extensions[name] = data
+	}
+	return c, nil
+}
+
+func (c *sftpClient) Close() error {
+	c.w.Close()
+	return c.session.Close()
+}
+
+func (c *sftpClient) writePacket(kind byte, payload []byte) error {
+	packet := make([]byte, 0, 5+len(payload))
+	packet = binary.BigEndian.AppendUint32(packet, uint32(len(payload)+1))
+	packet = append(packet, kind)
+	packet = append(packet, payload...)
+	_, err := c.w.Write(packet)
+	return err
+}
+
+func (c *sftpClient) readPacket() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("sftp: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, fmt.Errorf("sftp: %w", err)
+	}
+	return header[4], payload, nil
+}
+
+// request sends a request and returns the type and payload of its reply
+// after the request id
+func (c *sftpClient) request(kind byte, payload []byte) (byte, []byte, error) {
+	c.nextID++
+	id := c.nextID
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(payload)), id)
+	if err := c.writePacket(kind, append(packet, payload...)); err != nil {
+		return 0, nil, fmt.Errorf("sftp: %w", err)
+	}
+
+	replyKind, reply, err := c.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(reply) < 4 || binary.BigEndian.Uint32(reply) != id {
+		return 0, nil, fmt.Errorf("sftp: reply does not match request %d", id)
+	}
+	return replyKind, reply[4:], nil
+}
+
+// status converts a status reply to an error, nil for OK
+func status(kind byte, payload []byte) error {
+	if kind != sftpStatus {
+		return fmt.Errorf("sftp: unexpected packet %d", kind)
+	}
+	if len(payload) < 4 {
+		return fmt.Errorf("sftp: short status packet")
+	}
+	code := binary.BigEndian.Uint32(payload)
+	if code == sftpStatusOK {
+		return nil
+	}
+	message, _, _ := readString(payload[4:])
+	return &SFTPError{Code: code, Message: message}
+}
+
+// expectStatus sends a request answered by a status
+func (c *sftpClient) expectStatus(kind byte, payload []byte) error {
+	replyKind, reply, err := c.request(kind, payload)
+	if err != nil {
+		return err
+	}
+	return status(replyKind, reply)
+}
+
+func (c *sftpClient) open(path string, flags uint32, mode os.FileMode) (string, error) {
+	payload := appendString(nil, path)
+	payload = binary.BigEndian.AppendUint32(payload, flags)
+	if flags&sftpFlagCreat != 0 {
+		payload = binary.BigEndian.AppendUint32(payload, sftpAttrPermissions)
+		payload = binary.BigEndian.AppendUint32(payload, unixMode(mode))
+	} else {
+		payload = binary.BigEndian.AppendUint32(payload, 0)
+	}
+	kind, reply, err := c.request(sftpOpen, payload)
+	if err != nil {
+		return "", err
+	}
+	if kind != sftpHandle {
+		return "", status(kind, reply)
+	}
+	handle, _, err := readString(reply)
+	return handle, err
+}
+
+func (c *sftpClient) closeHandle(handle string) error {
+	return c.expectStatus(sftpClose, appendString(nil, handle))
+}
+
+func (c *sftpClient) write(handle string, offset uint64, data []byte) error {
+	payload := appendString(nil, handle)
+	payload = binary.BigEndian.AppendUint64(payload, offset)
+	payload = appendString(payload, string(data))
+	return c.expectStatus(sftpWrite, payload)
+}
+
+// read returns up to length bytes at offset, and io.EOF past the end
+func (c *sftpClient) read(handle string, offset uint64, length uint32) ([]byte, error) {
+	payload := appendString(nil, handle)
+	payload = binary.BigEndian.AppendUint64(payload, offset)
+	payload = binary.BigEndian.AppendUint32(payload, length)
+	kind, reply, err := c.request(sftpRead, payload)
+	if err != nil {
+		return nil, err
+	}
+	if kind != sftpData {
+		err := status(kind, reply)
+		var sftpErr *SFTPError
+		if errors.As(err, &sftpErr) && sftpErr.Code == sftpStatusEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	data, _, err := readString(reply)
+	return []byte(data), err
+}
+
+func (c *sftpClient) setMode(path string, mode os.FileMode) error {
+	payload := appendString(nil, path)
+	payload = binary.BigEndian.AppendUint32(payload, sftpAttrPermissions)
+	payload = binary.BigEndian.AppendUint32(payload, unixMode(mode))
+	return c.expectStatus(sftpSetstat, payload)
+}
+
+func (c *sftpClient) mkdir(path string, mode os.FileMode) error {
+	payload := appendString(nil, path)
+	payload = binary.BigEndian.AppendUint32(payload, sftpAttrPermissions)
+	payload = binary.BigEndian.AppendUint32(payload, unixMode(mode))
+	return c.expectStatus(sftpMkdir, payload)
+}
+
+func (c *sftpClient) remove(path string) error {
+	return c.expectStatus(sftpRemove, appendString(nil, path))
+}
+
+// rename replaces newPath with oldPath, atomically when the server supports
+// the posix-rename extension
+func (c *sftpClient) rename(oldPath, newPath string) error {
+	if _, ok := c.extensions[sftpPosixRenameExtName]; ok {
+		payload := appendString(nil, sftpPosixRenameExtName)
+		payload = appendString(payload, oldPath)
+		payload = appendString(payload, newPath)
+		return c.expectStatus(sftpExtended, payload)
+	}
+	// Plain SFTP rename fails when the target exists
+	if err := c.remove(newPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return c.expectStatus(sftpRename, appendString(appendString(nil, oldPath), newPath))
+}
+
+func (c *sftpClient) stat(path string) (*sftpAttributes, error) {
+	kind, reply, err := c.request(sftpStat, appendString(nil, path))
+	if err != nil {
+		return nil, err
+	}
+	if kind != sftpAttrs {
+		return nil, status(kind, reply)
+	}
+	return parseAttributes(reply)
+}
+
+func parseAttributes(data []byte) (*sftpAttributes, error) {
+	short := fmt.Errorf("sftp: short attributes")
+	if len(data) < 4 {
+		return nil, short
+	}
+	flags := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	attrs := &sftpAttributes{}
+	if flags&sftpAttrSize != 0 {
+		if len(data) < 8 {
+			return nil, short
+		}
+		attrs.size = int64(binary.BigEndian.Uint64(data))
+		data = data[8:]
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		if len(data) < 8 {
+			return nil, short
+		}
+		data = data[8:]
+	}
+	if flags&sftpAttrPermissions != 0 {
+		if len(data) < 4 {
+			return nil, short
+		}
+		attrs.mode = binary.BigEndian.Uint32(data)
+		attrs.isDir = attrs.mode&0170000 == 0040000
+	}
+	return attrs, nil
+}
+
+// unixMode converts a FileMode to the permission bits SFTP expects
+func unixMode(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// fileMode converts SFTP permission bits to a FileMode
+func fileMode(bits uint32) os.FileMode {
+	mode := os.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("sftp: short string")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return "", nil, fmt.Errorf("sftp: short string")
+	}
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
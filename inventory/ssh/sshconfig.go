@@ -0,0 +1,424 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxIncludeDepth is how deeply Include directives may nest, as in OpenSSH
+const maxIncludeDepth = 16
+
+// sshConfigParser reads an OpenSSH client configuration for one host. As in
+// ssh, the first value obtained for a setting wins.
+type sshConfigParser struct {
+	host      string // name given to ssh, matched by Host patterns
+	homeDir   string
+	localUser string
+	config    SSHConfig
+	identity  string // IdentityFile before its tokens are expanded
+}
+
+// parseSSHConfig returns the settings the OpenSSH client configuration at
+// path gives host. It supports Host and Match blocks with wildcard and
+// negated patterns, Include with globs, and the tokens of IdentityFile.
+// Match exec and localnetwork are never satisfied.
+func parseSSHConfig(path, host, homeDir, localUser string) (*SSHConfig, error) {
+	p := &sshConfigParser{host: strings.ToLower(host), homeDir: homeDir, localUser: localUser}
+	if err := p.readFile(path, true, false, 0); err != nil {
+		return nil, err
+	}
+	if p.identity != "" {
+		identity, err := p.expandTokens(p.identity)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IdentityFile %q: %w", p.identity, err)
+		}
+		p.config.IdentityFile = identity
+	}
+	return &p.config, nil
+}
+
+// readFile applies the lines of path. Lines apply while active; Host and
+// Match lines change it for the rest of the file, but never match within an
+// Include of an inactive block.
+func (p *sshConfigParser) readFile(path string, active, neverMatch bool, depth int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		keyword, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			active = !neverMatch && p.matchHost(args)
+			continue
+		case "match":
+			matched, err := p.matchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", path, number, err)
+			}
+			active = !neverMatch && matched
+			continue
+		case "include":
+			for _, pattern := range args {
+				if err := p.include(pattern, active, neverMatch || !active, depth+1); err != nil {
+					return fmt.Errorf("%s:%d: %w", path, number, err)
+				}
+			}
+			continue
+		}
+		if !active || len(args) == 0 {
+			continue
+		}
+		if err := p.set(keyword, args); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, number, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// include reads the files matching pattern, relative to ~/.ssh unless
+// absolute, in lexical order. A pattern matching nothing is not an error.
+func (p *sshConfigParser) include(pattern string, active, neverMatch bool, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("includes nested more than %d deep", maxIncludeDepth)
+	}
+	pattern = p.expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(p.homeDir, ".ssh", pattern)
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid Include %q: %w", pattern, err)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		debugf("reading %s included from the SSH config", path)
+		if err := p.readFile(path, active, neverMatch, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set records a setting unless an earlier line set it
+func (p *sshConfigParser) set(keyword string, args []string) error {
+	switch keyword {
+	case "hostname":
+		if p.config.Hostname == "" {
+			hostname, err := p.expandTokens(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid HostName %q: %w", args[0], err)
+			}
+			p.config.Hostname = hostname
+		}
+	case "user":
+		if p.config.User == "" {
+			p.config.User = args[0]
+		}
+	case "port":
+		if p.config.Port == 0 {
+			port, err := strconv.Atoi(args[0])
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("invalid Port %q", args[0])
+			}
+			p.config.Port = port
+		}
+	case "identityfile":
+		if p.identity == "" {
+			p.identity = args[0]
+		}
+	case "proxycommand":
+		if p.config.ProxyCommand == "" {
+			p.config.ProxyCommand = strings.Join(args, " ")
+		}
+	case "userknownhostsfile":
+		if p.config.HostKeyFile == "" {
+			p.config.HostKeyFile = p.expandHome(args[0])
+		}
+	}
+	return nil
+}
+
+// matchHost reports whether a Host line's patterns select the host: one
+// pattern matches it and no negated one does
+func (p *sshConfigParser) matchHost(patterns []string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchPattern(strings.ToLower(negated), p.host) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(strings.ToLower(pattern), p.host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchCriteria reports whether every criterion of a Match line holds
+func (p *sshConfigParser) matchCriteria(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, fmt.Errorf("no criteria after Match")
+	}
+
+	matched := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negated := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var result bool
+		switch criterion {
+		case "all":
+			result = true
+		case "canonical":
+			result = false
+		case "final":
+			result = true
+		case "host", "originalhost", "user", "localuser", "exec", "localnetwork", "tagged":
+			if i+1 == len(args) {
+				return false, fmt.Errorf("no argument after Match %s", criterion)
+			}
+			i++
+			result = p.matchCriterion(criterion, args[i])
+		default:
+			return false, fmt.Errorf("unsupported Match criterion %q", args[i])
+		}
+		if negated {
+			result = !result
+		}
+		matched = matched && result
+	}
+	return matched, nil
+}
+
+// matchCriterion evaluates a Match criterion that takes an argument
+func (p *sshConfigParser) matchCriterion(criterion, arg string) bool {
+	switch criterion {
+	case "host":
+		hostname := p.host
+		if p.config.Hostname != "" {
+			hostname = strings.ToLower(p.config.Hostname)
+		}
+		return matchPatternList(strings.ToLower(arg), hostname)
+	case "originalhost":
+		return matchPatternList(strings.ToLower(arg), p.host)
+	case "user":
+		remoteUser := p.config.User
+		if remoteUser == "" {
+			remoteUser = p.localUser
+		}
+		return matchPatternList(arg, remoteUser)
+	case "localuser":
+		return matchPatternList(arg, p.localUser)
+	case "tagged":
+		return arg == ""
+	}
+	debugf("Match %s %s is not supported and never matches", criterion, arg)
+	return false
+}
+
+// expandTokens replaces the % tokens of value, ${VAR} references and a
+// leading ~, like ssh does for IdentityFile
+func (p *sshConfigParser) expandTokens(value string) (string, error) {
+	value = p.expandHome(value)
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '%' && i+1 < len(value):
+			i++
+			token, err := p.token(value[i])
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(token)
+		case value[i] == '%':
+			return "", fmt.Errorf("trailing %%")
+		case strings.HasPrefix(value[i:], "${"):
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${")
+			}
+			name := value[i+2 : i+end]
+			variable, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			b.WriteString(variable)
+			i += end
+		default:
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// token returns the value of the % token c
+func (p *sshConfigParser) token(c byte) (string, error) {
+	switch c {
+	case '%':
+		return "%", nil
+	case 'd':
+		return p.homeDir, nil
+	case 'h':
+		if p.config.Hostname != "" {
+			return p.config.Hostname, nil
+		}
+		return p.host, nil
+	case 'n':
+		return p.host, nil
+	case 'p':
+		if p.config.Port != 0 {
+			return strconv.Itoa(p.config.Port), nil
+		}
+		return "22", nil
+	case 'r':
+		if p.config.User != "" {
+			return p.config.User, nil
+		}
+		return p.localUser, nil
+	case 'u':
+		return p.localUser, nil
+	case 'i':
+		return strconv.Itoa(os.Getuid()), nil
+	case 'l', 'L':
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", err
+		}
+		if c == 'L' {
+			hostname, _, _ = strings.Cut(hostname, ".")
+		}
+		return hostname, nil
+	}
+	return "", fmt.Errorf("unknown token %%%c", c)
+}
+
+// expandHome replaces a leading ~ with the home directory
+func (p *sshConfigParser) expandHome(path string) string {
+	if path == "~" {
+		return p.homeDir
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(p.homeDir, rest)
+	}
+	return path
+}
+
+// splitSSHConfigLine returns the lowercased keyword of a configuration line
+// and its arguments, or an empty keyword for blank lines and comments.
+// The keyword may be followed by whitespace or an equals sign, and arguments
+// may be double-quoted.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil, nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			return keyword, args, nil
+		}
+		if quoted, ok := strings.CutPrefix(rest, `"`); ok {
+			closing := strings.IndexByte(quoted, '"')
+			if closing < 0 {
+				return "", nil, fmt.Errorf("unterminated quote")
+			}
+			args = append(args, quoted[:closing])
+			rest = quoted[closing+1:]
+			continue
+		}
+		next := strings.IndexAny(rest, " \t")
+		if next < 0 {
+			next = len(rest)
+		}
+		args = append(args, rest[:next])
+		rest = rest[next:]
+	}
+}
+
+// matchPatternList reports whether s matches a comma-separated list of
+// patterns, none of the negated ones matching
+func matchPatternList(list, s string) bool {
+	matched := false
+	for _, pattern := range strings.Split(list, ",") {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if matchPattern(negated, s) {
+				return false
+			}
+			continue
+		}
+		if matchPattern(pattern, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchPattern matches s against an ssh_config pattern, where * matches any
+// run of characters and ? any single one
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			pattern = strings.TrimLeft(pattern, "*")
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// currentUserHome returns the name and home directory of the local user
+func currentUserHome() (string, string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get current user: %w", err)
+	}
+	return currentUser.Username, currentUser.HomeDir, nil
+}
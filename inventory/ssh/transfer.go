@@ -0,0 +1,537 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// DefaultUploadMode is the mode of uploaded content without a local file
+const DefaultUploadMode os.FileMode = 0644
+
+// TransferOptions control file transfers
+type TransferOptions struct {
+	// Mode of uploaded files and downloaded local files; 0 keeps the mode
+	// of the source file, or DefaultUploadMode for Upload
+	Mode os.FileMode
+	// Progress is called as bytes of path are transferred; total is -1
+	// when unknown
+	Progress func(path string, done, total int64)
+}
+
+func (o TransferOptions) progress(path string, total int64) func(done int64) {
+	if o.Progress == nil {
+		return func(int64) {}
+	}
+	return func(done int64) { o.Progress(path, done, total) }
+}
+
+// fileTransfer moves files over SFTP or, where SFTP is unavailable, scp.
+// Uploads write a temporary file next to the target and rename it over
+// the target once complete, so readers never see a partial file.
+type fileTransfer interface {
+	upload(ctx context.Context, r io.Reader, size int64, remotePath string, mode os.FileMode, progress func(int64)) error
+	download(ctx context.Context, remotePath string, w io.Writer, progress func(path string, total int64) func(int64)) (os.FileMode, error)
+	mkdirAll(ctx context.Context, remotePath string, mode os.FileMode) error
+	close() error
+}
+
+// openTransfer starts an SFTP session, falling back to scp. Hosts with a
+// command allow-list always use scp, so every transfer is a command the
+// allow-list checks. The SFTP session holds a session slot until closed.
+func (s *SSHClient) openTransfer(ctx context.Context) (fileTransfer, error) {
+	if len(s.Host.AllowedCommands) == 0 {
+		release, err := s.acquireSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		client, err := newSFTPClient(s.Client)
+		if err == nil {
+			debugf("transferring files to %s over sftp", s.Host.Name)
+			return &sftpTransfer{client: client, release: release}, nil
+		}
+		release()
+		debugf("sftp on %s failed, falling back to scp: %v", s.Host.Name, err)
+	}
+	return &scpTransfer{ssh: s}, nil
+}
+
+// Upload writes size bytes from r to remotePath on the host
+func (s *SSHClient) Upload(ctx context.Context, r io.Reader, size int64, remotePath string, opts TransferOptions) error {
+	transfer, err := s.openTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer transfer.close()
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = DefaultUploadMode
+	}
+	if err := transfer.upload(ctx, r, size, remotePath, mode, opts.progress(remotePath, size)); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+// UploadFile copies localPath to remotePath on the host, keeping its mode
+// unless opts sets one
+func (s *SSHClient) UploadFile(ctx context.Context, localPath, remotePath string, opts TransferOptions) error {
+	transfer, err := s.openTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer transfer.close()
+	return uploadFile(ctx, transfer, localPath, remotePath, opts)
+}
+
+func uploadFile(ctx context.Context, transfer fileTransfer, localPath, remotePath string, opts TransferOptions) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", localPath)
+	}
+
+	mode := opts.Mode
+	if mode == 0 {
+		mode = info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+	if err := transfer.upload(ctx, f, info.Size(), remotePath, mode, opts.progress(remotePath, info.Size())); err != nil {
+		return fmt.Errorf("failed to upload %s to %s: %w", localPath, remotePath, err)
+	}
+	return nil
+}
+
+// UploadDir copies the files and directories below localDir to remoteDir
+// on the host, creating directories as needed and keeping modes. opts.Mode
+// applies to files only.
+func (s *SSHClient) UploadDir(ctx context.Context, localDir, remoteDir string, opts TransferOptions) error {
+	transfer, err := s.openTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer transfer.close()
+
+	return filepath.WalkDir(localDir, func(localPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		remotePath := path.Join(remoteDir, filepath.ToSlash(rel))
+
+		switch {
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := transfer.mkdirAll(ctx, remotePath, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to create %s: %w", remotePath, err)
+			}
+			return nil
+		case entry.Type().IsRegular():
+			return uploadFile(ctx, transfer, localPath, remotePath, opts)
+		}
+		return fmt.Errorf("%s: only regular files and directories can be uploaded", localPath)
+	})
+}
+
+// DownloadFile copies remotePath on the host to localPath, keeping its mode
+// unless opts sets one. localPath is replaced only once the download is
+// complete.
+func (s *SSHClient) DownloadFile(ctx context.Context, remotePath, localPath string, opts TransferOptions) error {
+	transfer, err := s.openTransfer(ctx)
+	if err != nil {
+		return err
+	}
+	defer transfer.close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), filepath.Base(localPath)+".settle-tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	mode, err := transfer.download(ctx, remotePath, tmp, func(path string, total int64) func(int64) {
+		return opts.progress(path, total)
+	})
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to download %s: %w", remotePath, err)
+	}
+	if opts.Mode != 0 {
+		mode = opts.Mode
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// tempPath returns a unique temporary path next to target
+func tempPath(target string) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return fmt.Sprintf("%s.settle-tmp-%x", target, suffix)
+}
+
+// watch closes closer when ctx is cancelled, aborting a transfer in
+// progress; stop ends the watch
+func watch(ctx context.Context, closer func() error) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// progressWriter counts the bytes written through it
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	progress func(int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.progress(p.done)
+	return n, err
+}
+
+type sftpTransfer struct {
+	client  *sftpClient
+	release func() // frees the session slot
+}
+
+func (t *sftpTransfer) upload(ctx context.Context, r io.Reader, size int64, remotePath string, mode os.FileMode, progress func(int64)) error {
+	stop := watch(ctx, t.client.Close)
+	defer stop()
+
+	tmp := tempPath(remotePath)
+	handle, err := t.client.open(tmp, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc, mode)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	err = t.write(handle, r, progress)
+	if closeErr := t.client.closeHandle(handle); err == nil {
+		err = closeErr
+	}
+	// The umask of the server applies when creating the file
+	if err == nil {
+		err = t.client.setMode(tmp, mode)
+	}
+	if err == nil {
+		err = t.client.rename(tmp, remotePath)
+	}
+	if err != nil {
+		t.client.remove(tmp)
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+func (t *sftpTransfer) write(handle string, r io.Reader, progress func(int64)) error {
+	buf := make([]byte, sftpChunkSize)
+	var offset uint64
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if err := t.client.write(handle, offset, buf[:n]); err != nil {
+				return err
+			}
+			offset += uint64(n)
+			progress(int64(offset))
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (t *sftpTransfer) download(ctx context.Context, remotePath string, w io.Writer, progress func(path string, total int64) func(int64)) (os.FileMode, error) {
+	stop := watch(ctx, t.client.Close)
+	defer stop()
+
+	attrs, err := t.client.stat(remotePath)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	if attrs.isDir {
+		return 0, fmt.Errorf("%s is a directory", remotePath)
+	}
+	handle, err := t.client.open(remotePath, sftpFlagRead, 0)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	defer t.client.closeHandle(handle)
+
+	report := progress(remotePath, attrs.size)
+	var offset uint64
+	for {
+		data, err := t.client.read(handle, offset, sftpChunkSize)
+		if err == io.EOF {
+			return fileMode(attrs.mode), nil
+		}
+		if err != nil {
+			return 0, contextError(ctx, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return 0, err
+		}
+		offset += uint64(len(data))
+		report(int64(offset))
+	}
+}
+
+func (t *sftpTransfer) mkdirAll(ctx context.Context, remotePath string, mode os.FileMode) error {
+	if attrs, err := t.client.stat(remotePath); err == nil {
+		if !attrs.isDir {
+			return fmt.Errorf("%s exists and is not a directory", remotePath)
+		}
+		return nil
+	}
+	if parent := path.Dir(remotePath); parent != remotePath && parent != "." {
+		if err := t.mkdirAll(ctx, parent, 0755); err != nil {
+			return err
+		}
+	}
+	if err := t.client.mkdir(remotePath, mode); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+func (t *sftpTransfer) close() error {
+	defer t.release()
+	return t.client.Close()
+}
+
+// contextError prefers the context's error over the error of a session it
+// closed
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// scpTransfer runs the remote scp in sink (-t) or source (-f) mode
+type scpTransfer struct {
+	ssh *SSHClient
+}
+
+func (t *scpTransfer) upload(ctx context.Context, r io.Reader, size int64, remotePath string, mode os.FileMode, progress func(int64)) error {
+	tmp := tempPath(remotePath)
+	err := t.run(ctx, "scp -t "+common.ShellQuote(tmp), func(stdin io.WriteCloser, stdout *bufio.Reader) error {
+		return scpSend(stdin, stdout, r, size, path.Base(tmp), mode, progress)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The umask of the server applies when scp creates the file
+	command := fmt.Sprintf("chmod %o %s && mv -f %s %s", unixMode(mode), common.ShellQuote(tmp), common.ShellQuote(tmp), common.ShellQuote(remotePath))
+	if _, err := t.ssh.RunCommand(ctx, command); err != nil {
+		t.ssh.RunCommand(ctx, "rm -f "+common.ShellQuote(tmp))
+		return err
+	}
+	return nil
+}
+
+func (t *scpTransfer) download(ctx context.Context, remotePath string, w io.Writer, progress func(path string, total int64) func(int64)) (os.FileMode, error) {
+	var mode os.FileMode
+	err := t.run(ctx, "scp -f "+common.ShellQuote(remotePath), func(stdin io.WriteCloser, stdout *bufio.Reader) error {
+		var err error
+		mode, err = scpReceive(stdin, stdout, w, func(size int64) func(int64) { return progress(remotePath, size) })
+		return err
+	})
+	return mode, err
+}
+
+// scpSend sends one file of size bytes from r, named name, to a remote
+// scp running in sink mode (-t)
+func scpSend(stdin io.Writer, stdout *bufio.Reader, r io.Reader, size int64, name string, mode os.FileMode, progress func(int64)) error {
+	if err := readAck(stdout); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", unixMode(mode), size, name); err != nil {
+		return err
+	}
+	if err := readAck(stdout); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(&progressWriter{w: stdin, progress: progress}, r, size); err != nil {
+		return err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return err
+	}
+	return readAck(stdout)
+}
+
+// scpReceive receives one file into w from a remote scp running in source
+// mode (-f) and returns its mode; progress is given the size once known
+func scpReceive(stdin io.Writer, stdout *bufio.Reader, w io.Writer, progress func(size int64) func(int64)) (os.FileMode, error) {
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return 0, err
+	}
+	header, err := readRecord(stdout)
+	if err != nil {
+		return 0, err
+	}
+	// C<mode> <size> <name>
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return 0, fmt.Errorf("scp: unexpected record %q", header)
+	}
+	bits, err := strconv.ParseUint(fields[0][1:], 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("scp: invalid mode in %q", header)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("scp: invalid size in %q", header)
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(&progressWriter{w: w, progress: progress(size)}, stdout, size); err != nil {
+		return 0, fmt.Errorf("scp: %w", err)
+	}
+	if err := readAck(stdout); err != nil {
+		return 0, err
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return 0, err
+	}
+	return fileMode(uint32(bits)), nil
+}
+
+func (t *scpTransfer) mkdirAll(ctx context.Context, remotePath string, mode os.FileMode) error {
+	_, err := t.ssh.RunCommand(ctx, fmt.Sprintf("mkdir -p -m %o %s", unixMode(mode), common.ShellQuote(remotePath)))
+	return err
+}
+
+func (t *scpTransfer) close() error {
+	return nil
+}
+
+// run starts command in a new session and talks to it with fn
+func (t *scpTransfer) run(ctx context.Context, command string, fn func(stdin io.WriteCloser, stdout *bufio.Reader) error) error {
+	if err := CheckAllowed(t.ssh.Host.Name, t.ssh.Host.AllowedCommands, command); err != nil {
+		return err
+	}
+	release, err := t.ssh.acquireSession(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	session, err := t.ssh.Client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+	stop := watch(ctx, session.Close)
+	defer stop()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	debugf("exec on %s: %s", t.ssh.Host.Name, command)
+	if err := session.Start(command); err != nil {
+		return fmt.Errorf("failed to start scp: %w", err)
+	}
+
+	if err := fn(stdin, bufio.NewReader(stdout)); err != nil {
+		return contextError(ctx, err)
+	}
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		return contextError(ctx, fmt.Errorf("scp: %w", err))
+	}
+	return nil
+}
+
+// readAck reads the reply scp sends after every record: 0 for success, or
+// 1 (warning) or 2 (error) followed by a message line
+func readAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp: %w", err)
+	}
+	if code == 0 {
+		return nil
+	}
+	message, _ := r.ReadString('\n')
+	return scpError(message)
+}
+
+// readRecord reads a control record such as C0644 12 name, skipping T
+// (timestamp) records
+func readRecord(r *bufio.Reader) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("scp: %w", err)
+		}
+		switch {
+		case line == "":
+			return "", errors.New("scp: empty record")
+		case line[0] == 1 || line[0] == 2:
+			return "", scpError(line[1:])
+		case line[0] == 'T':
+			continue
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+}
+
+// scpError returns the error message sent by the remote scp, which
+// usually starts with "scp: " already
+func scpError(message string) error {
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "scp:") {
+		message = "scp: " + message
+	}
+	return errors.New(message)
+}
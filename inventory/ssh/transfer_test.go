@@ -0,0 +1,625 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/settlectl/settle-core/common"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// fakeHost is an SSH server in memory with an SFTP subsystem and the few
+// commands transfers run
+type fakeHost struct {
+	sftp        bool // offers the sftp subsystem
+	posixRename bool // announces posix-rename@openssh.com
+
+	mu       sync.Mutex
+	files    map[string]*fakeFile
+	commands []string
+}
+
+type fakeFile struct {
+	data []byte
+	mode uint32 // permission bits
+	dir  bool
+}
+
+func newFakeHost() *fakeHost {
+	return &fakeHost{sftp: true, posixRename: true, files: make(map[string]*fakeFile)}
+}
+
+func (h *fakeHost) file(path string) *fakeFile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.files[path]
+}
+
+func (h *fakeHost) put(path string, data []byte, mode uint32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.files[path] = &fakeFile{data: data, mode: mode}
+}
+
+// paths returns the paths of all files
+func (h *fakeHost) paths() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var paths []string
+	for path := range h.files {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// connect returns a client of host connected to h over loopback
+func (h *fakeHost) connect(t *testing.T, host *common.Host) *SSHClient {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, channels, requests, err := gossh.NewServerConn(serverConn, config)
+		if err != nil {
+			return
+		}
+		go gossh.DiscardRequests(requests)
+		for channel := range channels {
+			if channel.ChannelType() != "session" {
+				channel.Reject(gossh.UnknownChannelType, "")
+				continue
+			}
+			session, requests, err := channel.Accept()
+			if err != nil {
+				continue
+			}
+			go h.serveSession(session, requests)
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, channels, requests, err := gossh.NewClientConn(clientConn, "fake", &gossh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := gossh.NewClient(conn, channels, requests)
+	t.Cleanup(func() { client.Close() })
+	return &SSHClient{Host: host, Client: client}
+}
+
+func (h *fakeHost) serveSession(channel gossh.Channel, requests <-chan *gossh.Request) {
+	defer channel.Close()
+	for request := range requests {
+		payload, _, _ := readString(request.Payload)
+		switch {
+		case request.Type == "subsystem" && payload == "sftp" && h.sftp:
+			request.Reply(true, nil)
+			h.serveSFTP(channel)
+			return
+		case request.Type == "exec":
+			request.Reply(true, nil)
+			code := h.exec(payload, channel, bufio.NewReader(channel))
+			channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, code))
+			return
+		default:
+			request.Reply(false, nil)
+		}
+	}
+}
+
+// exec runs command, returning its exit status
+func (h *fakeHost) exec(command string, w io.Writer, r *bufio.Reader) uint32 {
+	h.mu.Lock()
+	h.commands = append(h.commands, command)
+	h.mu.Unlock()
+
+	fields := strings.Fields(command)
+	switch {
+	case len(fields) == 3 && fields[0] == "scp" && fields[1] == "-t":
+		return h.scpSink(fields[2], w, r)
+	case len(fields) == 3 && fields[0] == "scp" && fields[1] == "-f":
+		return h.scpSource(fields[2], w, r)
+	case len(fields) == 8 && fields[0] == "chmod" && fields[3] == "&&" && fields[4] == "mv":
+		mode, _ := strconv.ParseUint(fields[1], 8, 32)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		file := h.files[fields[2]]
+		if file == nil {
+			fmt.Fprintf(w, "chmod: cannot access '%s'\n", fields[2])
+			return 1
+		}
+		file.mode = uint32(mode)
+		delete(h.files, fields[2])
+		h.files[fields[7]] = file
+		return 0
+	case len(fields) == 3 && fields[0] == "rm" && fields[1] == "-f":
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.files, fields[2])
+		return 0
+	case len(fields) == 5 && fields[0] == "mkdir":
+		mode, _ := strconv.ParseUint(fields[3], 8, 32)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.files[fields[4]] = &fakeFile{mode: uint32(mode), dir: true}
+		return 0
+	}
+	return 127
+}
+
+// scpSink plays scp -t, receiving one file into target
+func (h *fakeHost) scpSink(target string, w io.Writer, r *bufio.Reader) uint32 {
+	w.Write([]byte{0})
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return 1
+	}
+	var mode uint32
+	var size int64
+	var name string
+	if _, err := fmt.Sscanf(header, "C%o %d %s\n", &mode, &size, &name); err != nil || name != filepath.Base(target) {
+		fmt.Fprintf(w, "\x02scp: protocol error: %q\n", header)
+		return 1
+	}
+	w.Write([]byte{0})
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 1
+	}
+	if end, err := r.ReadByte(); err != nil || end != 0 {
+		return 1
+	}
+	h.put(target, data, mode)
+	w.Write([]byte{0})
+	return 0
+}
+
+// scpSource plays scp -f, sending the file at path
+func (h *fakeHost) scpSource(path string, w io.Writer, r *bufio.Reader) uint32 {
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	file := h.file(path)
+	if file == nil || file.dir {
+		fmt.Fprintf(w, "\x01scp: %s: No such file or directory\n", path)
+		return 1
+	}
+	fmt.Fprintf(w, "T1700000000 0 1700000000 0\nC%04o %d %s\n", file.mode, len(file.data), filepath.Base(path))
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	w.Write(file.data)
+	w.Write([]byte{0})
+	if ack, err := r.ReadByte(); err != nil || ack != 0 {
+		return 1
+	}
+	return 0
+}
+
+// serveSFTP answers SFTP requests on rw until it is closed
+func (h *fakeHost) serveSFTP(rw io.ReadWriter) {
+	client := &sftpClient{r: rw, w: nopWriteCloser{rw}}
+	for {
+		kind, payload, err := client.readPacket()
+		if err != nil {
+			return
+		}
+		if kind == sftpInit {
+			reply := binary.BigEndian.AppendUint32(nil, sftpVersion)
+			if h.posixRename {
+				reply = appendString(appendString(reply, sftpPosixRenameExtName), "1")
+			}
+			client.writePacket(sftpVersionP, reply)
+			continue
+		}
+		id := payload[:4]
+		replyKind, reply := h.sftpRequest(kind, payload[4:])
+		client.writePacket(replyKind, append(append([]byte{}, id...), reply...))
+	}
+}
+
+func (h *fakeHost) sftpRequest(kind byte, payload []byte) (byte, []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	statusReply := func(code uint32) (byte, []byte) {
+		return sftpStatus, appendString(appendString(binary.BigEndian.AppendUint32(nil, code), ""), "")
+	}
+
+	path, rest, _ := readString(payload)
+	switch kind {
+	case sftpOpen:
+		flags := binary.BigEndian.Uint32(rest)
+		file := h.files[path]
+		if flags&sftpFlagCreat != 0 {
+			mode := binary.BigEndian.Uint32(rest[8:])
+			// The server's umask applies
+			file = &fakeFile{mode: mode &^ 0022}
+			h.files[path] = file
+		}
+		if file == nil {
+			return statusReply(sftpStatusNoSuchFile)
+		}
+		return sftpHandle, appendString(nil, path)
+	case sftpClose:
+		return statusReply(sftpStatusOK)
+	case sftpWrite:
+		offset := binary.BigEndian.Uint64(rest)
+		data, _, _ := readString(rest[8:])
+		file := h.files[path]
+		if end := int(offset) + len(data); end > len(file.data) {
+			file.data = append(file.data, make([]byte, end-len(file.data))...)
+		}
+		copy(file.data[offset:], data)
+		return statusReply(sftpStatusOK)
+	case sftpRead:
+		offset := binary.BigEndian.Uint64(rest)
+		length := binary.BigEndian.Uint32(rest[8:])
+		file := h.files[path]
+		if offset >= uint64(len(file.data)) {
+			return statusReply(sftpStatusEOF)
+		}
+		end := min(offset+uint64(length), uint64(len(file.data)))
+		return sftpData, appendString(nil, string(file.data[offset:end]))
+	case sftpSetstat:
+		file := h.files[path]
+		if file == nil {
+			return statusReply(sftpStatusNoSuchFile)
+		}
+		file.mode = binary.BigEndian.Uint32(rest[4:])
+		return statusReply(sftpStatusOK)
+	case sftpStat:
+		file := h.files[path]
+		if file == nil {
+			return statusReply(sftpStatusNoSuchFile)
+		}
+		mode := file.mode | 0100000
+		if file.dir {
+			mode = file.mode | 0040000
+		}
+		attrs := binary.BigEndian.AppendUint32(nil, sftpAttrSize|sftpAttrPermissions)
+		attrs = binary.BigEndian.AppendUint64(attrs, uint64(len(file.data)))
+		return sftpAttrs, binary.BigEndian.AppendUint32(attrs, mode)
+	case sftpRemove:
+		if h.files[path] == nil {
+			return statusReply(sftpStatusNoSuchFile)
+		}
+		delete(h.files, path)
+		return statusReply(sftpStatusOK)
+	case sftpMkdir:
+		h.files[path] = &fakeFile{mode: binary.BigEndian.Uint32(rest[4:]), dir: true}
+		return statusReply(sftpStatusOK)
+	case sftpRename, sftpExtended:
+		if kind == sftpExtended {
+			if path != sftpPosixRenameExtName {
+				return statusReply(8) // unsupported
+			}
+			path, rest, _ = readString(rest)
+		}
+		target, _, _ := readString(rest)
+		if h.files[path] == nil {
+			return statusReply(sftpStatusNoSuchFile)
+		}
+		if kind == sftpRename && h.files[target] != nil {
+			return statusReply(4) // failure, as plain SFTP renames do not overwrite
+		}
+		h.files[target] = h.files[path]
+		delete(h.files, path)
+		return statusReply(sftpStatusOK)
+	}
+	return statusReply(8)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestReadPacket(t *testing.T) {
+	packet := func(length uint32, body []byte) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, length), body...)
+	}
+	tests := []struct {
+		name        string
+		data        []byte
+		wantKind    byte
+		wantPayload []byte
+		wantErr     string
+	}{
+		{name: "status", data: packet(5, []byte{sftpStatus, 0, 0, 0, 1}), wantKind: sftpStatus, wantPayload: []byte{0, 0, 0, 1}},
+		{name: "type only", data: packet(1, []byte{sftpVersionP}), wantKind: sftpVersionP, wantPayload: []byte{}},
+		{name: "zero length", data: packet(0, []byte{sftpData}), wantErr: "invalid packet length 0"},
+		{name: "too long", data: packet(sftpMaxPacket+1, []byte{sftpData}), wantErr: "invalid packet length"},
+		{name: "truncated header", data: []byte{0, 0}, wantErr: "unexpected EOF"},
+		{name: "truncated payload", data: packet(10, []byte{sftpData, 1, 2}), wantErr: "unexpected EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &sftpClient{r: bytes.NewReader(tt.data)}
+			kind, payload, err := client.readPacket()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kind != tt.wantKind || !bytes.Equal(payload, tt.wantPayload) {
+				t.Errorf("packet = %d %v, want %d %v", kind, payload, tt.wantKind, tt.wantPayload)
+			}
+		})
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	u32 := func(b []byte, v uint32) []byte { return binary.BigEndian.AppendUint32(b, v) }
+	u64 := func(b []byte, v uint64) []byte { return binary.BigEndian.AppendUint64(b, v) }
+	tests := []struct {
+		name    string
+		data    []byte
+		want    sftpAttributes
+		wantErr bool
+	}{
+		{name: "none", data: u32(nil, 0)},
+		{name: "regular file", data: u32(u64(u32(nil, sftpAttrSize|sftpAttrPermissions), 1234), 0100640), want: sftpAttributes{size: 1234, mode: 0100640}},
+		{name: "directory", data: u32(u32(nil, sftpAttrPermissions), 0040755), want: sftpAttributes{mode: 0040755, isDir: true}},
+		{name: "owner skipped", data: u32(u32(u32(u32(nil, sftpAttrUIDGID|sftpAttrPermissions), 1000), 1000), 0100600), want: sftpAttributes{mode: 0100600}},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "short size", data: u32(u32(nil, sftpAttrSize), 1), wantErr: true},
+		{name: "short owner", data: u32(u32(nil, sftpAttrUIDGID), 1000), wantErr: true},
+		{name: "short permissions", data: u32(nil, sftpAttrPermissions), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs, err := parseAttributes(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parsed %+v, want an error", attrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *attrs != tt.want {
+				t.Errorf("attributes = %+v, want %+v", *attrs, tt.want)
+			}
+		})
+	}
+}
+
+func TestSFTPErrorIs(t *testing.T) {
+	if !errors.Is(&SFTPError{Code: sftpStatusNoSuchFile}, fs.ErrNotExist) {
+		t.Error("missing file is not fs.ErrNotExist")
+	}
+	if !errors.Is(&SFTPError{Code: sftpStatusPermDenied}, fs.ErrPermission) {
+		t.Error("denied access is not fs.ErrPermission")
+	}
+	if errors.Is(&SFTPError{Code: 4}, fs.ErrNotExist) {
+		t.Error("failure is fs.ErrNotExist")
+	}
+}
+
+func TestUpload(t *testing.T) {
+	tests := []struct {
+		name         string
+		sftp         bool
+		posixRename  bool
+		allow        []string
+		wantCommands []string
+	}{
+		{name: "sftp", sftp: true, posixRename: true},
+		{name: "sftp without posix-rename", sftp: true},
+		{name: "scp without sftp", wantCommands: []string{"scp -t", "chmod 640"}},
+		{
+			name:         "scp on hosts with an allow-list",
+			sftp:         true,
+			allow:        []string{"scp -t *", "chmod * && mv -f *", "rm -f *"},
+			wantCommands: []string{"scp -t", "chmod 640"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeHost()
+			fake.sftp, fake.posixRename = tt.sftp, tt.posixRename
+			fake.put("/etc/app.conf", []byte("old content that is longer"), 0644)
+			client := fake.connect(t, &common.Host{Name: "web1", AllowedCommands: tt.allow})
+
+			content := bytes.Repeat([]byte("settle\n"), 10000) // several SFTP chunks
+			var done, total int64
+			err := client.Upload(context.Background(), bytes.NewReader(content), int64(len(content)), "/etc/app.conf", TransferOptions{
+				Mode:     0640,
+				Progress: func(_ string, d, t int64) { done, total = d, t },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			file := fake.file("/etc/app.conf")
+			if file == nil || !bytes.Equal(file.data, content) {
+				t.Fatal("uploaded content differs")
+			}
+			if file.mode&0777 != 0640 {
+				t.Errorf("mode = %o, want 640", file.mode&0777)
+			}
+			if paths := fake.paths(); len(paths) != 1 {
+				t.Errorf("files left on the host: %v", paths)
+			}
+			if done != int64(len(content)) || total != int64(len(content)) {
+				t.Errorf("progress = %d of %d, want %d", done, total, len(content))
+			}
+
+			if len(fake.commands) != len(tt.wantCommands) {
+				t.Fatalf("commands = %q, want %q", fake.commands, tt.wantCommands)
+			}
+			for i, prefix := range tt.wantCommands {
+				if !strings.HasPrefix(fake.commands[i], prefix) {
+					t.Errorf("command %d = %q, want one starting with %q", i, fake.commands[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestUploadRefusedByAllowList(t *testing.T) {
+	fake := newFakeHost()
+	client := fake.connect(t, &common.Host{Name: "web1", AllowedCommands: []string{"apt-get install -y *"}})
+
+	err := client.Upload(context.Background(), strings.NewReader("x"), 1, "/etc/app.conf", TransferOptions{})
+	var refused *CommandNotAllowedError
+	if !errors.As(err, &refused) {
+		t.Fatalf("error = %v, want a CommandNotAllowedError", err)
+	}
+	if len(fake.commands) != 0 || len(fake.paths()) != 0 {
+		t.Errorf("refused upload reached the host: %q %v", fake.commands, fake.paths())
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	for _, sftp := range []bool{true, false} {
+		t.Run(fmt.Sprintf("sftp=%v", sftp), func(t *testing.T) {
+			fake := newFakeHost()
+			fake.sftp = sftp
+			content := bytes.Repeat([]byte("settle\n"), 10000)
+			fake.put("/etc/app.conf", content, 0640)
+			client := fake.connect(t, &common.Host{Name: "web1"})
+
+			local := filepath.Join(t.TempDir(), "app.conf")
+			if err := client.DownloadFile(context.Background(), "/etc/app.conf", local, TransferOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(local)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Error("downloaded content differs")
+			}
+			if info, err := os.Stat(local); err != nil || info.Mode().Perm() != 0640 {
+				t.Errorf("mode = %v, %v, want 0640", info.Mode(), err)
+			}
+
+			// A missing file leaves nothing behind
+			missing := filepath.Join(t.TempDir(), "missing")
+			if err := client.DownloadFile(context.Background(), "/etc/missing", missing, TransferOptions{}); err == nil {
+				t.Fatal("downloaded a missing file")
+			}
+			if _, err := os.Stat(missing); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("missing download left %s", missing)
+			}
+		})
+	}
+}
+
+func TestSCPReceive(t *testing.T) {
+	tests := []struct {
+		name     string
+		remote   string
+		want     string
+		wantMode os.FileMode
+		wantErr  string
+	}{
+		{name: "file", remote: "C0640 5 app.conf\nhello\x00", want: "hello", wantMode: 0640},
+		{name: "timestamps skipped", remote: "T1 0 1 0\nC4755 2 tool\nhi\x00", want: "hi", wantMode: 0755 | os.ModeSetuid},
+		{name: "remote error", remote: "\x01scp: /etc/app.conf: No such file or directory\n", wantErr: "scp: /etc/app.conf: No such file or directory"},
+		{name: "unexpected record", remote: "D0755 0 dir\n", wantErr: "unexpected record"},
+		{name: "invalid size", remote: "C0644 -1 app.conf\n", wantErr: "invalid size"},
+		{name: "truncated", remote: "C0644 10 app.conf\nhello", wantErr: "EOF"},
+		{name: "failed after data", remote: "C0644 5 app.conf\nhello\x02scp: read error\n", wantErr: "scp: read error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent, got bytes.Buffer
+			mode, err := scpReceive(&sent, bufio.NewReader(strings.NewReader(tt.remote)), &got, func(int64) func(int64) { return func(int64) {} })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want || mode != tt.wantMode {
+				t.Errorf("received %q with mode %v, want %q with %v", got.String(), mode, tt.want, tt.wantMode)
+			}
+			if sent.String() != "\x00\x00\x00" {
+				t.Errorf("acks sent = %q, want three", sent.String())
+			}
+		})
+	}
+}
+
+func TestSCPSend(t *testing.T) {
+	tests := []struct {
+		name    string
+		remote  string
+		wantErr string
+	}{
+		{name: "accepted", remote: "\x00\x00\x00"},
+		{name: "refused at start", remote: "\x02scp: /etc: Permission denied\n", wantErr: "scp: /etc: Permission denied"},
+		{name: "refused header", remote: "\x00\x01scp: disk full\n", wantErr: "scp: disk full"},
+		{name: "connection lost", remote: "\x00\x00", wantErr: "EOF"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent bytes.Buffer
+			err := scpSend(&sent, bufio.NewReader(strings.NewReader(tt.remote)), strings.NewReader("hello"), 5, "app.conf", 0640|os.ModeSetgid, func(int64) {})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "C2640 5 app.conf\nhello\x00"; sent.String() != want {
+				t.Errorf("sent %q, want %q", sent.String(), want)
+			}
+		})
+	}
+}
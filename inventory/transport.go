@@ -0,0 +1,361 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// Transport runs commands and moves files on a host. Drivers and resources
+// depend on it rather than on SSH, so hosts can be reached over SSH, locally
+// or by other transports.
+type Transport interface {
+	// RunCommand runs command and returns its combined output; a nonzero
+	// exit code is an error
+	RunCommand(ctx context.Context, command string) (string, error)
+	// Exec runs command and returns its stdout, stderr and exit code; only
+	// failing to run it, e.g. a lost connection, is an error
+	Exec(ctx context.Context, command string) (*common.CommandResult, error)
+	// UploadFile writes content to path, with sudo like resource commands
+	UploadFile(ctx context.Context, path string, content []byte) error
+	// DownloadFile returns the content of path
+	DownloadFile(ctx context.Context, path string) ([]byte, error)
+	// Stat describes path; the error wraps fs.ErrNotExist if it is missing
+	Stat(ctx context.Context, path string) (*FileInfo, error)
+	Close() error
+}
+
+// FileInfo describes a file on a host
+type FileInfo struct {
+	Path  string
+	Size  int64
+	Mode  os.FileMode // permission and setuid, setgid and sticky bits
+	Owner string
+	Group string
+	IsDir bool
+}
+
+// Connect opens the transport of host: SSH unless the host sets
+// transport = "local"
+func Connect(host *common.Host) (Transport, error) {
+	switch host.Transport {
+	case "", common.TransportSSH:
+		client, err := dialSSH(host)
+		if err != nil {
+			return nil, err
+		}
+		return NewSSHTransport(client), nil
+	case common.TransportLocal:
+		return NewLocalTransport(host), nil
+	}
+	return nil, fmt.Errorf("host %s: unknown transport %q (expected %s or %s)", host.Name, host.Transport, common.TransportSSH, common.TransportLocal)
+}
+
+// dialSSH opens an SSH connection to host and reports it to the observer
+func dialSSH(host *common.Host) (*ssh.SSHClient, error) {
+	started := time.Now()
+	client, err := ssh.NewSSHClient(host)
+	if connectObserver != nil {
+		connectObserver(host.Name, time.Since(started), err)
+	}
+	return client, err
+}
+
+// connectObserver is called after each attempt to open an SSH connection
+var connectObserver func(host string, elapsed time.Duration, err error)
+
+// ObserveConnects calls observer after each attempt to open an SSH
+// connection, with how long it took and why it failed, e.g. to measure
+// connect latency. It must be set before connecting.
+func ObserveConnects(observer func(host string, elapsed time.Duration, err error)) {
+	connectObserver = observer
+}
+
+// WithCommandOptions returns a transport running the commands of transport
+// with opts, e.g. the environment variables a driver needs. Options already
+// set on transport stay, with opts on top.
+func WithCommandOptions(transport Transport, opts common.CommandOptions) Transport {
+	return &optionsTransport{Transport: transport, opts: opts}
+}
+
+// optionsTransport passes its options to the transport it wraps through the
+// context of each call
+type optionsTransport struct {
+	Transport
+	opts common.CommandOptions
+}
+
+func (t *optionsTransport) RunCommand(ctx context.Context, command string) (string, error) {
+	return t.Transport.RunCommand(common.WithCommandOptions(ctx, t.opts), command)
+}
+
+func (t *optionsTransport) Exec(ctx context.Context, command string) (*common.CommandResult, error) {
+	return t.Transport.Exec(common.WithCommandOptions(ctx, t.opts), command)
+}
+
+func (t *optionsTransport) UploadFile(ctx context.Context, path string, content []byte) error {
+	return t.Transport.UploadFile(common.WithCommandOptions(ctx, t.opts), path, content)
+}
+
+func (t *optionsTransport) DownloadFile(ctx context.Context, path string) ([]byte, error) {
+	return t.Transport.DownloadFile(common.WithCommandOptions(ctx, t.opts), path)
+}
+
+func (t *optionsTransport) Stat(ctx context.Context, path string) (*FileInfo, error) {
+	return t.Transport.Stat(common.WithCommandOptions(ctx, t.opts), path)
+}
+
+// NewSSHTransport returns a transport running commands over client
+func NewSSHTransport(client *ssh.SSHClient) Transport {
+	return &shellTransport{runner: client, host: client.Host.Name}
+}
+
+// NewLocalTransport returns a transport running commands with sh on the
+// machine running settle. The host's allow-list still applies.
+func NewLocalTransport(host *common.Host) Transport {
+	return &shellTransport{runner: &localRunner{host: host}, host: host.Name}
+}
+
+// commandRunner runs shell commands on a host
+type commandRunner interface {
+	RunCommandWithInput(ctx context.Context, command string, input string) (string, error)
+	Exec(ctx context.Context, command string, input string) (*common.CommandResult, error)
+	Close() error
+}
+
+// fileUploader is implemented by runners that transfer files without a
+// shell, i.e. the SSH client over SFTP or scp
+type fileUploader interface {
+	Upload(ctx context.Context, r io.Reader, size int64, remotePath string, opts ssh.TransferOptions) error
+}
+
+var _ fileUploader = (*ssh.SSHClient)(nil)
+
+// UploadOptions control how Transport.UploadFile moves content to a host
+type UploadOptions struct {
+	// StagingDir is a directory of the SSH user, e.g. the run's workspace.
+	// SSH transports upload content there over SFTP, or scp on hosts with
+	// an allow-list, then copy it into place with sudo. Without one, or on
+	// local hosts, content is piped to sudo tee.
+	StagingDir string
+	// Progress is called as bytes are uploaded to the staging directory
+	Progress func(done, total int64)
+}
+
+type uploadOptionsKey struct{}
+
+// WithUploadOptions returns a context making Transport.UploadFile use opts
+func WithUploadOptions(ctx context.Context, opts UploadOptions) context.Context {
+	return context.WithValue(ctx, uploadOptionsKey{}, opts)
+}
+
+func uploadOptionsFromContext(ctx context.Context) UploadOptions {
+	opts, _ := ctx.Value(uploadOptionsKey{}).(UploadOptions)
+	return opts
+}
+
+// shellTransport implements file operations with shell commands, so every
+// transport moves files the same way and allow-lists see each operation
+type shellTransport struct {
+	runner commandRunner
+	host   string
+}
+
+func (t *shellTransport) RunCommand(ctx context.Context, command string) (string, error) {
+	started := time.Now()
+	out, err := t.runner.RunCommandWithInput(ctx, command, "")
+	traceCommand(t.host, command, started, 0, err)
+	return out, err
+}
+
+func (t *shellTransport) Exec(ctx context.Context, command string) (*common.CommandResult, error) {
+	started := time.Now()
+	result, err := t.runner.Exec(ctx, command, "")
+	exitCode := 0
+	if result != nil {
+		exitCode = result.ExitCode
+	}
+	traceCommand(t.host, command, started, exitCode, err)
+	return result, err
+}
+
+func (t *shellTransport) UploadFile(ctx context.Context, path string, content []byte) error {
+	opts := uploadOptionsFromContext(ctx)
+	if uploader, ok := t.runner.(fileUploader); ok && opts.StagingDir != "" {
+		return t.stageUpload(ctx, uploader, path, content, opts)
+	}
+
+	command := fmt.Sprintf("sudo tee %s > /dev/null", common.ShellQuote(path))
+	result, err := t.runner.Exec(ctx, command, string(content))
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", path, err)
+	}
+	return nil
+}
+
+// stageUpload uploads content into the staging directory over SFTP, or
+// scp on hosts with an allow-list, and copies it to path with sudo, so
+// root owns the copy as with sudo tee
+func (t *shellTransport) stageUpload(ctx context.Context, uploader fileUploader, target string, content []byte, opts UploadOptions) error {
+	staged := path.Join(opts.StagingDir, "upload"+strings.ReplaceAll(target, "/", "_"))
+	transfer := ssh.TransferOptions{Mode: 0600}
+	if opts.Progress != nil {
+		transfer.Progress = func(_ string, done, total int64) { opts.Progress(done, total) }
+	}
+	if err := uploader.Upload(ctx, bytes.NewReader(content), int64(len(content)), staged, transfer); err != nil {
+		return fmt.Errorf("failed to upload %s: %w", target, err)
+	}
+
+	command := fmt.Sprintf("sudo cp %s", common.ShellJoin(staged, target))
+	result, err := t.Exec(ctx, command)
+	if err == nil {
+		err = result.Err()
+	}
+	if _, cleanupErr := t.Exec(ctx, "rm -f "+common.ShellQuote(staged)); err == nil {
+		err = cleanupErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", target, err)
+	}
+	return nil
+}
+
+func (t *shellTransport) DownloadFile(ctx context.Context, path string) ([]byte, error) {
+	result, err := t.runner.Exec(ctx, fmt.Sprintf("sudo cat %s", common.ShellQuote(path)), "")
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", path, err)
+	}
+	return []byte(result.Stdout), nil
+}
+
+func (t *shellTransport) Stat(ctx context.Context, path string) (*FileInfo, error) {
+	command := fmt.Sprintf("sudo stat -c '%%s %%a %%U %%G %%F' %s 2>/dev/null || true", common.ShellQuote(path))
+	out, err := t.runner.RunCommandWithInput(ctx, command, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if strings.TrimSpace(out) == "" {
+		return nil, fmt.Errorf("stat %s: %w", path, fs.ErrNotExist)
+	}
+	return parseStat(path, out)
+}
+
+func (t *shellTransport) Close() error {
+	return t.runner.Close()
+}
+
+// parseStat parses the output of stat -c '%s %a %U %G %F'
+func parseStat(path, out string) (*FileInfo, error) {
+	fields := strings.Fields(out)
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("stat %s: unexpected output %q", path, strings.TrimSpace(out))
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: invalid size %q", path, fields[0])
+	}
+	mode, err := strconv.ParseUint(fields[1], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: invalid mode %q", path, fields[1])
+	}
+	perm := os.FileMode(mode) & os.ModePerm
+	for bit, flag := range map[uint64]os.FileMode{04000: os.ModeSetuid, 02000: os.ModeSetgid, 01000: os.ModeSticky} {
+		if mode&bit != 0 {
+			perm |= flag
+		}
+	}
+	return &FileInfo{
+		Path:  path,
+		Size:  size,
+		Mode:  perm,
+		Owner: fields[2],
+		Group: fields[3],
+		IsDir: strings.Join(fields[4:], " ") == "directory",
+	}, nil
+}
+
+// localRunner runs commands with sh on this machine
+type localRunner struct {
+	host *common.Host
+}
+
+func (r *localRunner) RunCommandWithInput(ctx context.Context, command string, input string) (string, error) {
+	var out bytes.Buffer
+	if err := r.run(ctx, command, input, &out, &out); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func (r *localRunner) Exec(ctx context.Context, command string, input string) (*common.CommandResult, error) {
+	var stdout, stderr bytes.Buffer
+	started := time.Now()
+	err := r.run(ctx, command, input, &stdout, &stderr)
+	result := &common.CommandResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(started),
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *localRunner) run(ctx context.Context, command string, input string, stdout, stderr io.Writer) error {
+	if err := ssh.CheckAllowed(r.host.Name, r.host.AllowedCommands, command); err != nil {
+		return err
+	}
+	script, err := common.CommandOptionsFromContext(ctx).Wrap(command)
+	if err != nil {
+		return err
+	}
+
+	// A deadline on ctx (e.g. a per-action timeout) replaces the default
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ssh.HostTimeouts(r.host).Command)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	if input != "" {
+		cmd.Stdin = strings.NewReader(input)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	return nil
+}
+
+func (r *localRunner) Close() error {
+	return nil
+}
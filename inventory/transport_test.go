@@ -0,0 +1,147 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// fakeRunner records the commands it runs and fails failCmd
+type fakeRunner struct {
+	commands []string
+	inputs   []string
+	failCmd  string
+}
+
+func (r *fakeRunner) RunCommandWithInput(ctx context.Context, command string, input string) (string, error) {
+	result, err := r.Exec(ctx, command, input)
+	if err != nil {
+		return "", err
+	}
+	return result.Stdout, result.Err()
+}
+
+func (r *fakeRunner) Exec(ctx context.Context, command string, input string) (*common.CommandResult, error) {
+	r.commands = append(r.commands, command)
+	r.inputs = append(r.inputs, input)
+	if command == r.failCmd {
+		return &common.CommandResult{ExitCode: 1, Stderr: "failed"}, nil
+	}
+	return &common.CommandResult{}, nil
+}
+
+func (r *fakeRunner) Close() error { return nil }
+
+// fakeUploader also receives files like the SSH client
+type fakeUploader struct {
+	fakeRunner
+	uploads map[string]string
+}
+
+func (r *fakeUploader) Upload(ctx context.Context, reader io.Reader, size int64, remotePath string, opts ssh.TransferOptions) error {
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if opts.Mode != 0600 {
+		return errors.New("staged upload is readable by others")
+	}
+	if opts.Progress != nil {
+		opts.Progress(remotePath, size, size)
+	}
+	r.uploads[remotePath] = string(content)
+	return nil
+}
+
+func TestUploadFileStaged(t *testing.T) {
+	runner := &fakeUploader{uploads: map[string]string{}}
+	transport := &shellTransport{runner: runner, host: "web1"}
+
+	var done, total int64
+	ctx := WithUploadOptions(context.Background(), UploadOptions{
+		StagingDir: "/home/deploy/.settle/run",
+		Progress:   func(d, t int64) { done, total = d, t },
+	})
+	if err := transport.UploadFile(ctx, "/etc/app.conf.settle-tmp", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	staged := "/home/deploy/.settle/run/upload_etc_app.conf.settle-tmp"
+	if want := map[string]string{staged: "hello"}; !reflect.DeepEqual(runner.uploads, want) {
+		t.Errorf("uploads = %v, want %v", runner.uploads, want)
+	}
+	want := []string{
+		"sudo cp " + staged + " /etc/app.conf.settle-tmp",
+		"rm -f " + staged,
+	}
+	if !reflect.DeepEqual(runner.commands, want) {
+		t.Errorf("commands = %q, want %q", runner.commands, want)
+	}
+	if done != 5 || total != 5 {
+		t.Errorf("progress = %d of %d, want 5 of 5", done, total)
+	}
+}
+
+func TestUploadFileStagedCopyFails(t *testing.T) {
+	staged := "/home/deploy/.settle/run/upload_etc_app.conf"
+	runner := &fakeUploader{fakeRunner: fakeRunner{failCmd: "sudo cp " + staged + " /etc/app.conf"}, uploads: map[string]string{}}
+	transport := &shellTransport{runner: runner, host: "web1"}
+
+	ctx := WithUploadOptions(context.Background(), UploadOptions{StagingDir: "/home/deploy/.settle/run"})
+	if err := transport.UploadFile(ctx, "/etc/app.conf", []byte("hello")); err == nil {
+		t.Fatal("upload succeeded although the copy failed")
+	}
+	// The staged copy is removed anyway
+	if last := runner.commands[len(runner.commands)-1]; last != "rm -f "+staged {
+		t.Errorf("last command = %q, want the staged copy removed", last)
+	}
+}
+
+func TestUploadFileTee(t *testing.T) {
+	tests := []struct {
+		name   string
+		runner commandRunner
+		ctx    context.Context
+	}{
+		{
+			name:   "without a staging directory",
+			runner: &fakeUploader{uploads: map[string]string{}},
+			ctx:    context.Background(),
+		},
+		{
+			name:   "runner without uploads",
+			runner: &fakeRunner{},
+			ctx:    WithUploadOptions(context.Background(), UploadOptions{StagingDir: "/tmp/run"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &shellTransport{runner: tt.runner, host: "web1"}
+			if err := transport.UploadFile(tt.ctx, "/etc/app.conf", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			var runner *fakeRunner
+			switch r := tt.runner.(type) {
+			case *fakeUploader:
+				runner = &r.fakeRunner
+				if len(r.uploads) != 0 {
+					t.Errorf("uploads = %v, want none", r.uploads)
+				}
+			case *fakeRunner:
+				runner = r
+			}
+			if want := []string{"sudo tee /etc/app.conf > /dev/null"}; !reflect.DeepEqual(runner.commands, want) {
+				t.Errorf("commands = %q, want %q", runner.commands, want)
+			}
+			if runner.inputs[0] != "hello" {
+				t.Errorf("input = %q, want the content", runner.inputs[0])
+			}
+		})
+	}
+}
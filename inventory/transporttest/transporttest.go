@@ -0,0 +1,255 @@
+// Package transporttest provides a scripted fake inventory.Transport and
+// fixtures, so drivers and resources can be tested without real hosts.
+//
+// A Transport is given the commands it expects, in order, with their canned
+// output or exit code. Running any other command fails, and Verify reports
+// expected commands that never ran. Files are kept in memory.
+package transporttest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+	"github.com/settlectl/settle-core/inventory"
+	"github.com/settlectl/settle-core/inventory/ssh"
+)
+
+// ExitError is the error of a scripted command exiting with a non-zero code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.Code)
+}
+
+// Call is an expected command and its canned result
+type Call struct {
+	Command  string // the exact command, or a pattern with * and ? wildcards
+	Output   string // stdout
+	Stderr   string
+	ExitCode int
+	Err      error // returned without running, e.g. a lost connection
+}
+
+// Transport is a fake inventory.Transport replaying scripted calls
+type Transport struct {
+	mu       sync.Mutex
+	calls    []Call
+	next     int
+	files    map[string]*file
+	commands []string
+	closed   bool
+}
+
+type file struct {
+	content []byte
+	mode    os.FileMode
+	isDir   bool
+}
+
+var _ inventory.Transport = (*Transport)(nil)
+
+// New returns a transport expecting calls in order
+func New(calls ...Call) *Transport {
+	return &Transport{calls: calls, files: make(map[string]*file)}
+}
+
+// Expect adds a command that succeeds with output
+func (t *Transport) Expect(command, output string) *Transport {
+	return t.ExpectCall(Call{Command: command, Output: output})
+}
+
+// ExpectFailure adds a command that exits with exitCode
+func (t *Transport) ExpectFailure(command string, exitCode int) *Transport {
+	return t.ExpectCall(Call{Command: command, ExitCode: exitCode})
+}
+
+// ExpectCall adds a call
+func (t *Transport) ExpectCall(call Call) *Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls = append(t.calls, call)
+	return t
+}
+
+// RunCommand returns the result of the next expected call if command
+// matches it. Like the SSH transport, a failing command returns no output.
+func (t *Transport) RunCommand(ctx context.Context, command string) (string, error) {
+	call, err := t.call(ctx, command)
+	switch {
+	case err != nil:
+		return "", err
+	case call.ExitCode != 0:
+		return "", fmt.Errorf("failed to run command: %w", &ExitError{Code: call.ExitCode})
+	}
+	if call.Stderr != "" {
+		return call.Output + call.Stderr, nil
+	}
+	return call.Output, nil
+}
+
+// Exec returns the output streams and exit code of the next expected call
+// if command matches it
+func (t *Transport) Exec(ctx context.Context, command string) (*common.CommandResult, error) {
+	call, err := t.call(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	return &common.CommandResult{Stdout: call.Output, Stderr: call.Stderr, ExitCode: call.ExitCode}, nil
+}
+
+// call consumes the next expected call if command matches it
+func (t *Transport) call(ctx context.Context, command string) (Call, error) {
+	if err := ctx.Err(); err != nil {
+		return Call{}, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.commands = append(t.commands, command)
+
+	if t.next >= len(t.calls) {
+		return Call{}, fmt.Errorf("unexpected command %q: no more commands expected", command)
+	}
+	call := t.calls[t.next]
+	if call.Command != command && !ssh.MatchCommand(call.Command, command) {
+		return Call{}, fmt.Errorf("unexpected command %q: expected %q", command, call.Command)
+	}
+	t.next++
+
+	if call.Err != nil {
+		return Call{}, call.Err
+	}
+	return call, nil
+}
+
+// UploadFile stores content in memory
+func (t *Transport) UploadFile(ctx context.Context, path string, content []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.SetFile(path, content, 0644)
+	return nil
+}
+
+// DownloadFile returns a file uploaded or set with SetFile
+func (t *Transport) DownloadFile(ctx context.Context, path string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.files[path]
+	if !ok || f.isDir {
+		return nil, fmt.Errorf("failed to download %s: %w", path, fs.ErrNotExist)
+	}
+	return append([]byte(nil), f.content...), nil
+}
+
+// Stat describes a file uploaded or set with SetFile or SetDir
+func (t *Transport) Stat(ctx context.Context, path string) (*inventory.FileInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.files[path]
+	if !ok {
+		return nil, fmt.Errorf("stat %s: %w", path, fs.ErrNotExist)
+	}
+	return &inventory.FileInfo{
+		Path:  path,
+		Size:  int64(len(f.content)),
+		Mode:  f.mode,
+		Owner: "root",
+		Group: "root",
+		IsDir: f.isDir,
+	}, nil
+}
+
+func (t *Transport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	return nil
+}
+
+// SetFile puts a file on the fake host
+func (t *Transport) SetFile(path string, content []byte, mode os.FileMode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[path] = &file{content: append([]byte(nil), content...), mode: mode}
+}
+
+// SetDir puts a directory on the fake host
+func (t *Transport) SetDir(path string, mode os.FileMode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.files[path] = &file{mode: mode, isDir: true}
+}
+
+// File returns the content of a file on the fake host
+func (t *Transport) File(path string) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f, ok := t.files[path]
+	if !ok || f.isDir {
+		return nil, false
+	}
+	return append([]byte(nil), f.content...), true
+}
+
+// Commands returns every command run, including unexpected ones
+func (t *Transport) Commands() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.commands...)
+}
+
+// Closed reports whether Close was called
+func (t *Transport) Closed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// Verify returns an error listing the expected commands that did not run
+func (t *Transport) Verify() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next == len(t.calls) {
+		return nil
+	}
+	pending := make([]string, 0, len(t.calls)-t.next)
+	for _, call := range t.calls[t.next:] {
+		pending = append(pending, fmt.Sprintf("%q", call.Command))
+	}
+	return fmt.Errorf("%d expected commands did not run: %s", len(pending), strings.Join(pending, ", "))
+}
+
+// Host returns a host named name for tests
+func Host(name string) *common.Host {
+	return &common.Host{
+		Name:     name,
+		Hostname: name + ".test",
+		User:     "settle",
+		Port:     22,
+	}
+}
+
+// Context returns a runtime context for host that uses transport and
+// discards log output
+func Context(host *common.Host, transport inventory.Transport) *inventory.Context {
+	ctx := inventory.NewContext(host)
+	ctx.Logger.SetOutput(io.Discard)
+	ctx.Transport = transport
+	ctx.Ctx = context.Background()
+	return ctx
+}
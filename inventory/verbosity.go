@@ -0,0 +1,57 @@
+package inventory
+
+import (
+	"fmt"
+	"time"
+)
+
+// Verbosity levels, set with -v, -vv and -vvv
+const (
+	// VerbosityNormal logs progress and results, and the output of commands
+	// that fail
+	VerbosityNormal = 0
+	// VerbosityCommands also logs each command as it runs
+	VerbosityCommands = 1
+	// VerbosityOutput also logs the full output of every command and how
+	// long it took
+	VerbosityOutput = 2
+	// VerbosityTrace also logs SSH handshakes, channels and connection
+	// fallbacks
+	VerbosityTrace = 3
+)
+
+var (
+	verbosity int
+
+	// traceLog receives the timing of every command at VerbosityOutput
+	traceLog func(message string)
+)
+
+// SetVerbosity sets how much loggers print, from VerbosityNormal to
+// VerbosityTrace
+func SetVerbosity(level int) {
+	verbosity = level
+}
+
+// Verbosity returns the verbosity level
+func Verbosity() int {
+	return verbosity
+}
+
+// SetTraceLogger sets where the timing of commands is logged at
+// VerbosityOutput and above
+func SetTraceLogger(fn func(message string)) {
+	traceLog = fn
+}
+
+// traceCommand logs how a command on host ended and how long it took
+func traceCommand(host, command string, started time.Time, exitCode int, err error) {
+	if traceLog == nil || verbosity < VerbosityOutput {
+		return
+	}
+	status := fmt.Sprintf("exit %d", exitCode)
+	if err != nil {
+		status = err.Error()
+	}
+	traceLog(fmt.Sprintf("%s: %s after %s: %s", host, status, time.Since(started).Round(time.Millisecond), command))
+}
@@ -0,0 +1,146 @@
+package inventory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// Workspace is the remote temporary directory of a run, /tmp/settle-<runid>.
+// It is created on a host the first time a resource asks for it and removed
+// from every such host when the run ends, so resources staging scripts, file
+// content or archives never manage temp files themselves.
+type Workspace struct {
+	Dir string
+
+	mu    sync.Mutex
+	hosts map[string]*workspaceHost
+}
+
+type workspaceHost struct {
+	once sync.Once
+	host *common.Host
+	err  error
+}
+
+// NewRunID returns a random identifier for a run
+func NewRunID() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("failed to generate run ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// NewWorkspace returns the workspace of the run with the given ID
+func NewWorkspace(runID string) *Workspace {
+	return &Workspace{
+		Dir:   path.Join("/tmp", "settle-"+runID),
+		hosts: make(map[string]*workspaceHost),
+	}
+}
+
+// Prepare creates the workspace on the context's host, once per host, and
+// returns its directory. Only the SSH user can access it.
+func (w *Workspace) Prepare(ctx *Context) (string, error) {
+	if ctx.Host == nil {
+		return "", fmt.Errorf("no host available")
+	}
+
+	w.mu.Lock()
+	entry, ok := w.hosts[ctx.Host.Name]
+	if !ok {
+		entry = &workspaceHost{host: ctx.Host}
+		w.hosts[ctx.Host.Name] = entry
+	}
+	w.mu.Unlock()
+
+	entry.once.Do(func() {
+		command := fmt.Sprintf("mkdir -p -m 0700 %s", common.ShellQuote(w.Dir))
+		ctx.Logger.Command(command)
+		entry.err = ctx.run(command)
+		if entry.err != nil {
+			entry.err = fmt.Errorf("failed to create workspace %s: %w", w.Dir, entry.err)
+		}
+	})
+	return w.Dir, entry.err
+}
+
+// Path returns the path of name inside the workspace, preparing it first
+func (w *Workspace) Path(ctx *Context, name string) (string, error) {
+	dir, err := w.Prepare(ctx)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, path.Base(name)), nil
+}
+
+// Cleanup removes the workspace from every host it was created on, over the
+// connections of the pool when it is not nil. It is safe to call after a
+// failed or interrupted run; logger reports hosts the workspace could not be
+// removed from.
+func (w *Workspace) Cleanup(ctx context.Context, logger *Logger, connections *ConnectionPool) error {
+	w.mu.Lock()
+	var hosts []*common.Host
+	for _, entry := range w.hosts {
+		if entry.err == nil {
+			hosts = append(hosts, entry.host)
+		}
+	}
+	w.hosts = make(map[string]*workspaceHost)
+	w.mu.Unlock()
+
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Name < hosts[j].Name })
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host *common.Host) {
+			defer wg.Done()
+			hostCtx := &Context{Host: host, Logger: logger, Ctx: ctx, Connections: connections}
+			command := fmt.Sprintf("rm -rf %s", common.ShellQuote(w.Dir))
+			logger.Command(command)
+			if err := hostCtx.run(command); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", host.Name, err)
+			}
+		}(i, host)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("failed to remove workspace %s: %w", w.Dir, failed[0])
+	default:
+		return fmt.Errorf("failed to remove workspace %s from %d hosts: %v", w.Dir, len(failed), failed)
+	}
+}
+
+// run runs command on the context's host, reusing its transport if it has one
+func (c *Context) run(command string) error {
+	transport := c.Transport
+	if transport == nil {
+		created, err := c.Connect(c.Host)
+		if err != nil {
+			return err
+		}
+		defer created.Close()
+		transport = created
+	}
+	_, err := transport.RunCommand(c.Context(), command)
+	return err
+}
@@ -0,0 +1,28 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// EnvProvider reads secrets from environment variables: ${secret.db_password}
+// is read from SETTLE_SECRET_DB_PASSWORD
+type EnvProvider struct {
+	Prefix string
+}
+
+func NewEnvProvider() *EnvProvider {
+	return &EnvProvider{Prefix: "SETTLE_SECRET_"}
+}
+
+func (p *EnvProvider) Name() string { return "env" }
+
+func (p *EnvProvider) Get(ctx context.Context, name string) (string, error) {
+	key := p.Prefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
@@ -0,0 +1,143 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// encryptedFileHeader starts every encrypted secrets file
+const encryptedFileHeader = "settle-secrets-v1\n"
+
+const (
+	saltSize = 16
+	keySize  = 32 // AES-256
+)
+
+// FileProvider reads secrets from a file encrypted with AES-256-GCM, using a
+// key derived from a passphrase with scrypt. The file can be committed; the
+// passphrase cannot.
+type FileProvider struct {
+	Path       string
+	Passphrase string
+	values     map[string]string
+}
+
+func NewFileProvider(path, passphrase string) *FileProvider {
+	return &FileProvider{Path: path, Passphrase: passphrase}
+}
+
+func (p *FileProvider) Name() string { return "file" }
+
+func (p *FileProvider) Get(ctx context.Context, name string) (string, error) {
+	if p.values == nil {
+		values, err := p.Load()
+		if err != nil {
+			return "", err
+		}
+		p.values = values
+	}
+	value, ok := p.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+// Load decrypts the whole file. A missing file holds no secrets.
+func (p *FileProvider) Load() (map[string]string, error) {
+	data, err := os.ReadFile(p.Path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+	if !strings.HasPrefix(string(data), encryptedFileHeader) {
+		return nil, fmt.Errorf("%s is not an encrypted secrets file", p.Path)
+	}
+	if p.Passphrase == "" {
+		return nil, fmt.Errorf("%s is encrypted but no passphrase was given (set SETTLE_SECRETS_KEY)", p.Path)
+	}
+
+	sealed := data[len(encryptedFileHeader):]
+	if len(sealed) < saltSize {
+		return nil, fmt.Errorf("secrets file is truncated")
+	}
+	gcm, err := p.cipher(sealed[:saltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[saltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("secrets file is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(encryptedFileHeader))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secrets file: wrong passphrase or corrupted file")
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("invalid secrets file contents: %w", err)
+	}
+	return values, nil
+}
+
+// Save encrypts values into the file with a fresh salt and nonce
+func (p *FileProvider) Save(values map[string]string) error {
+	if p.Passphrase == "" {
+		return fmt.Errorf("no passphrase given (set SETTLE_SECRETS_KEY)")
+	}
+
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets: %w", err)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	gcm, err := p.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := []byte(encryptedFileHeader)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	out = gcm.Seal(out, nonce, plaintext, []byte(encryptedFileHeader))
+
+	if err := os.MkdirAll(filepath.Dir(p.Path), 0700); err != nil {
+		return fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	p.values = values
+	return os.WriteFile(p.Path, out, 0600)
+}
+
+func (p *FileProvider) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(p.Passphrase), salt, 1<<15, 8, 1, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
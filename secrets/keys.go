@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/settlectl/settle-core/common"
+)
+
+// DefaultKeyField is the field of a Vault secret holding a private key when
+// a reference names none
+const DefaultKeyField = "private_key"
+
+// LoadKey fetches a private key from the key store ref points to:
+//
+//	vault:<path>[#<field>]       a KV v2 secret in Vault (VAULT_ADDR, VAULT_TOKEN)
+//	op://<vault>/<item>/<field>  a 1Password field, read with the op CLI
+//
+// The key is only held in memory.
+func LoadKey(ctx context.Context, ref string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(ref, common.KeyStoreVault):
+		return loadVaultKey(ctx, strings.TrimPrefix(ref, common.KeyStoreVault))
+	case strings.HasPrefix(ref, common.KeyStoreOnePassword):
+		return loadOnePasswordKey(ctx, ref)
+	}
+	return nil, fmt.Errorf("unsupported key reference %q (expected %s... or %s...)", ref, common.KeyStoreVault, common.KeyStoreOnePassword)
+}
+
+func loadVaultKey(ctx context.Context, ref string) ([]byte, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = DefaultKeyField
+	}
+	address := os.Getenv("VAULT_ADDR")
+	if address == "" {
+		return nil, fmt.Errorf("vault key %s: VAULT_ADDR is not set", path)
+	}
+
+	key, err := NewVaultProvider(address, os.Getenv("VAULT_TOKEN"), path).Get(ctx, field)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("vault key %s: the secret has no field %s", path, field)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vault key %s: %w", path, err)
+	}
+	return []byte(key), nil
+}
+
+func loadOnePasswordKey(ctx context.Context, ref string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "op", "read", ref)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("1password key %s: %w: %s", ref, err, message)
+		}
+		return nil, fmt.Errorf("1password key %s: %w", ref, err)
+	}
+	return stdout.Bytes(), nil
+}
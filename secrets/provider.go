@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned by a provider that does not hold a secret
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secret values by name
+type Provider interface {
+	Name() string
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// referencePattern matches ${secret.name} references in .stl values
+var referencePattern = regexp.MustCompile(`\$\{secret\.([A-Za-z0-9_.-]+)\}`)
+
+// Resolver expands secret references using the first provider that holds
+// each secret, and remembers resolved values so they can be redacted
+type Resolver struct {
+	providers []Provider
+	cache     map[string]string
+	onResolve func(value string)
+}
+
+func NewResolver(providers ...Provider) *Resolver {
+	return &Resolver{
+		providers: providers,
+		cache:     make(map[string]string),
+	}
+}
+
+// OnResolve registers fn to be called with every resolved secret value,
+// e.g. to redact it from logs
+func (r *Resolver) OnResolve(fn func(value string)) {
+	r.onResolve = fn
+}
+
+// HasReferences reports whether s contains any ${secret.*} reference
+func HasReferences(s string) bool {
+	return referencePattern.MatchString(s)
+}
+
+// StripReferences removes every ${secret.*} reference from s
+func StripReferences(s string) string {
+	return referencePattern.ReplaceAllString(s, "")
+}
+
+// Expand replaces every ${secret.name} in s with its value
+func (r *Resolver) Expand(ctx context.Context, s string) (string, error) {
+	var firstErr error
+	expanded := referencePattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := referencePattern.FindStringSubmatch(ref)[1]
+		value, err := r.Get(ctx, name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ref
+		}
+		return value
+	})
+	return expanded, firstErr
+}
+
+// Get returns a secret from the first provider holding it
+func (r *Resolver) Get(ctx context.Context, name string) (string, error) {
+	if value, ok := r.cache[name]; ok {
+		return value, nil
+	}
+
+	var tried []string
+	for _, provider := range r.providers {
+		value, err := provider.Get(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			tried = append(tried, provider.Name())
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %s provider: %w", name, provider.Name(), err)
+		}
+
+		r.cache[name] = value
+		if r.onResolve != nil && value != "" {
+			r.onResolve(value)
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("secret %s not found (tried: %s)", name, strings.Join(tried, ", "))
+}
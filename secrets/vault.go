@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from a HashiCorp Vault KV v2 engine. Every
+// secret is a key of the single Vault secret at Path, e.g. "secret/data/settle".
+type VaultProvider struct {
+	Address string
+	Token   string
+	Path    string
+	Client  *http.Client
+	values  map[string]string
+}
+
+func NewVaultProvider(address, token, path string) *VaultProvider {
+	return &VaultProvider{
+		Address: strings.TrimSuffix(address, "/"),
+		Token:   token,
+		Path:    strings.Trim(path, "/"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *VaultProvider) Name() string { return "vault" }
+
+func (p *VaultProvider) Get(ctx context.Context, name string) (string, error) {
+	if p.values == nil {
+		values, err := p.read(ctx)
+		if err != nil {
+			return "", err
+		}
+		p.values = values
+	}
+	value, ok := p.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (p *VaultProvider) read(ctx context.Context) (map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/v1/%s", p.Address, p.Path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.Token)
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return map[string]string{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s", resp.Status, p.Path)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	values := make(map[string]string)
+	for key, value := range body.Data.Data {
+		values[key] = fmt.Sprint(value)
+	}
+	return values, nil
+}